@@ -34,6 +34,7 @@ import (
 	blocksAPI "github.com/thanos-io/thanos/pkg/api/blocks"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/block/parquet"
 	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/component"
@@ -338,6 +339,12 @@ func runCompact(
 		if len(dedupReplicaLabels) == 0 {
 			return errors.New("penalty based deduplication needs at least one replica label specified")
 		}
+	case compact.DedupAlgorithmQuorum, compact.DedupAlgorithmLongest:
+		mergeFunc = dedup.NewChunkSeriesMergerWithAlgorithm(conf.dedupFunc)
+
+		if len(dedupReplicaLabels) == 0 {
+			return errors.Errorf("%s based deduplication needs at least one replica label specified", conf.dedupFunc)
+		}
 	case "":
 		mergeFunc = storage.NewCompactingChunkSeriesMerger(storage.ChainedSeriesMerge)
 
@@ -393,12 +400,18 @@ func runCompact(
 		planner = largeIndexFilterPlanner
 	}
 	blocksCleaner := compact.NewBlocksCleaner(logger, insBkt, ignoreDeletionMarkFilter, deleteDelay, compactMetrics.blocksCleaned, compactMetrics.blockCleanupFailures)
-	compactor, err := compact.NewBucketCompactor(
+	var compactionLifecycleCallback compact.CompactionLifecycleCallback = compact.DefaultCompactionLifecycleCallback{}
+	if conf.enableParquet {
+		compactionLifecycleCallback = compact.NewParquetCompactionLifecycleCallback(compactionLifecycleCallback, compactDir, insBkt, conf.parquetRowGroupSize)
+	}
+	compactor, err := compact.NewBucketCompactorWithCheckerAndCallback(
 		logger,
 		sy,
 		grouper,
 		planner,
 		comp,
+		compact.DefaultBlockDeletableChecker{},
+		compactionLifecycleCallback,
 		compactDir,
 		insBkt,
 		conf.compactionConcurrency,
@@ -745,6 +758,8 @@ type compactConfig struct {
 	enableVerticalCompaction                       bool
 	dedupFunc                                      string
 	skipBlockWithOutOfOrderChunks                  bool
+	enableParquet                                  bool
+	parquetRowGroupSize                            int
 	progressCalculateInterval                      time.Duration
 	filterConf                                     *store.FilterConfig
 	disableAdminOperations                         bool
@@ -823,9 +838,10 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 		Hidden().Default("false").BoolVar(&cc.enableVerticalCompaction)
 
 	cmd.Flag("deduplication.func", "Experimental. Deduplication algorithm for merging overlapping blocks. "+
-		"Possible values are: \"\", \"penalty\". If no value is specified, the default compact deduplication merger is used, which performs 1:1 deduplication for samples. "+
-		"When set to penalty, penalty based deduplication algorithm will be used. At least one replica label has to be set via --deduplication.replica-label flag.").
-		Default("").EnumVar(&cc.dedupFunc, compact.DedupAlgorithmPenalty, "")
+		"Possible values are: \"\", \"penalty\", \"quorum\", \"longest\". If no value is specified, the default compact deduplication merger is used, which performs 1:1 deduplication for samples. "+
+		"When set to penalty, penalty based deduplication algorithm will be used. When set to quorum, the median of the samples of all the replicas is used for each scrape. "+
+		"When set to longest, the replica with the most samples is used wherever it has samples, the other replicas filling the rest of the time range. At least one replica label has to be set via --deduplication.replica-label flag.").
+		Default("").EnumVar(&cc.dedupFunc, compact.DedupAlgorithmPenalty, compact.DedupAlgorithmQuorum, compact.DedupAlgorithmLongest, "")
 
 	cmd.Flag("deduplication.replica-label", "Experimental. Label to treat as a replica indicator of blocks that can be deduplicated (repeated flag). This will merge multiple replica blocks into one. This process is irreversible. "+
 		"Flag may be specified multiple times as well as a comma separated list of labels. "+
@@ -844,6 +860,13 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("compact.skip-block-with-out-of-order-chunks", "When set to true, mark blocks containing index with out-of-order chunks for no compact instead of halting the compaction").
 		Hidden().Default("false").BoolVar(&cc.skipBlockWithOutOfOrderChunks)
 
+	cmd.Flag("compact.enable-parquet", "Experimental. When set to true, compactor writes a Parquet file with the series of each compacted block next to it. "+
+		"Store Gateway can serve series from it with --store.enable-parquet.").
+		Default("false").BoolVar(&cc.enableParquet)
+
+	cmd.Flag("compact.parquet-row-group-size", "Number of series per row group of the Parquet files written by compactor.").
+		Default(strconv.Itoa(parquet.DefaultRowGroupSize)).IntVar(&cc.parquetRowGroupSize)
+
 	cmd.Flag("hash-func", "Specify which hash function to use when calculating the hashes of produced files. If no function has been specified, it does not happen. This permits avoiding downloading some files twice albeit at some performance cost. Possible values are: \"\", \"SHA256\".").
 		Default("").EnumVar(&cc.hashFunc, "SHA256", "")
 
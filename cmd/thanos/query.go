@@ -4,8 +4,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -20,9 +22,12 @@ import (
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/promql"
+	"google.golang.org/grpc"
 
+	frontendv1 "github.com/thanos-io/thanos/internal/cortex/frontend/v1"
 	apiv1 "github.com/thanos-io/thanos/pkg/api/query"
 	"github.com/thanos-io/thanos/pkg/api/query/querypb"
+	"github.com/thanos-io/thanos/pkg/audit"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/component"
@@ -45,6 +50,7 @@ import (
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
 	"github.com/thanos-io/thanos/pkg/store"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/strutil"
 	"github.com/thanos-io/thanos/pkg/targets"
 	"github.com/thanos-io/thanos/pkg/tenancy"
@@ -89,6 +95,13 @@ func registerQuery(app *extkingpin.App) {
 	maxConcurrentQueries := cmd.Flag("query.max-concurrent", "Maximum number of queries processed concurrently by query node.").
 		Default("20").Int()
 
+	remoteReadSampleLimit := cmd.Flag("query.remote-read.sample-limit", "Maximum overall number of samples to return via the remote read interface, in a single query. 0 means no limit. This limit is ignored for streamed response types.").
+		Default("5e7").Int()
+	remoteReadConcurrencyLimit := cmd.Flag("query.remote-read.concurrent-limit", "Maximum number of concurrent remote read calls. 0 means no limit.").
+		Default("10").Int()
+	remoteReadMaxBytesInFrame := cmd.Flag("query.remote-read.max-bytes-in-frame", "Maximum number of bytes in a single frame for streaming remote read response types before marshalling. Note that client might have limit on frame size as well. 1MB as recommended by protobuf by default.").
+		Default("1048576").Int()
+
 	lookbackDelta := cmd.Flag("query.lookback-delta", "The maximum lookback duration for retrieving metrics during expression evaluations. PromQL always evaluates the query for the certain timestamp (query range timestamps are deduced by step). Since scrape intervals might be different, PromQL looks back for given amount of time to get latest sample. If it exceeds the maximum lookback delta it assumes series is stale and returns none (a gap). This is why lookback delta should be set to at least 2 times of the slowest scrape interval. If unset it will use the promql default of 5m.").Duration()
 	dynamicLookbackDelta := cmd.Flag("query.dynamic-lookback-delta", "Allow for larger lookback duration for queries based on resolution.").Hidden().Default("true").Bool()
 
@@ -100,9 +113,10 @@ func registerQuery(app *extkingpin.App) {
 		Enums(string(query.ExternalLabels), string(query.StoreType))
 
 	deduplicationFunc := cmd.Flag("deduplication.func", "Experimental. Deduplication algorithm for merging overlapping series. "+
-		"Possible values are: \"penalty\", \"chain\". If no value is specified, penalty based deduplication algorithm will be used. "+
-		"When set to chain, the default compact deduplication merger is used, which performs 1:1 deduplication for samples. At least one replica label has to be set via --query.replica-label flag.").
-		Default(dedup.AlgorithmPenalty).Enum(dedup.AlgorithmPenalty, dedup.AlgorithmChain)
+		"Possible values are: \"penalty\", \"chain\", \"quorum\", \"longest\". If no value is specified, penalty based deduplication algorithm will be used. "+
+		"When set to chain, the default compact deduplication merger is used, which performs 1:1 deduplication for samples. "+
+		"When set to quorum, the median of the samples of all the replicas is used for each scrape. When set to longest, the replica with the most samples is used wherever it has samples, the other replicas filling the rest of the time range. At least one replica label has to be set via --query.replica-label flag.").
+		Default(dedup.AlgorithmPenalty).Enum(dedup.AlgorithmPenalty, dedup.AlgorithmChain, dedup.AlgorithmQuorum, dedup.AlgorithmLongest)
 
 	queryReplicaLabels := cmd.Flag("query.replica-label", "Labels to treat as a replica indicator along which data is deduplicated. Still you will be able to query without deduplication using 'dedup=false' parameter. Data includes time series, recording rules, and alerting rules. Flag may be specified multiple times as well as a comma separated list of labels.").
 		Strings()
@@ -115,7 +129,7 @@ func registerQuery(app *extkingpin.App) {
 
 	defaultMetadataTimeRange := cmd.Flag("query.metadata.default-time-range", "The default metadata time range duration for retrieving labels through Labels and Series API when the range parameters are not specified. The zero value means range covers the time since the beginning.").Default("0s").Duration()
 
-	selectorLabels := cmd.Flag("selector-label", "Query selector labels that will be exposed in info endpoint (repeated).").
+	selectorLabels := cmd.Flag("selector-label", "Query selector labels that will be exposed in info endpoint and attached as external labels to series served by /federate (repeated).").
 		PlaceHolder("<name>=\"<value>\"").Strings()
 
 	enableAutodownsampling := cmd.Flag("query.auto-downsampling", "Enable automatic adjustment (step / 5) to what source of data should be used in store gateways if no max_source_resolution param is specified.").
@@ -147,6 +161,27 @@ func registerQuery(app *extkingpin.App) {
 
 	storeResponseTimeout := extkingpin.ModelDuration(cmd.Flag("store.response-timeout", "If a Store doesn't send any data in this specified duration then a Store will be ignored and partial data will be returned if it's enabled. 0 disables timeout.").Default("0ms"))
 
+	storeHedgingUpTo := cmd.Flag("store.hedging.up-to", "Maximum number of hedged Series requests sent to other stores advertising the same external labels, when a store doesn't respond in time. 0 disables hedging.").
+		Default("0").Int()
+	storeHedgingQuantile := cmd.Flag("store.hedging.quantile", "Quantile of the time to first response of a store after which its Series requests are hedged.").
+		Default("0.9").Float64()
+	storeHedgingMinDelay := extkingpin.ModelDuration(cmd.Flag("store.hedging.min-delay", "Minimum time to wait for a store to respond before hedging its Series requests. Used as delay until the latency of the store is known.").
+		Default("50ms"))
+
+	storeReplicaRouting := cmd.Flag("store.replica-routing", "Experimental. Send Series requests to a single replica of the stores advertising external labels which only differ by the replica labels, instead of querying all of them and deduplicating. Other replicas are queried if the selected one fails or its response has gaps.").
+		Default("false").Bool()
+	storeReplicaRoutingPreference := cmd.Flag("store.replica-routing.preference", "Values of the replica labels of the replicas to query first, in order of preference. Other replicas are ranked by latency. Flag may be specified multiple times as well as a comma separated list of values.").
+		Strings()
+	storeReplicaRoutingGapThreshold := extkingpin.ModelDuration(cmd.Flag("store.replica-routing.gap-threshold", "Time between samples in the response of a replica above which data is considered missing, in which case all the replicas are queried and deduplicated. 0 disables gap detection.").
+		Default("0s"))
+
+	storeCircuitBreakerFailureThreshold := cmd.Flag("store.circuit-breaker.failure-threshold", "Number of consecutive failed or slow Series requests after which the circuit breaker of a store opens and its requests are rejected right away. 0 disables the circuit breaker.").
+		Default("0").Int()
+	storeCircuitBreakerSlowCallThreshold := extkingpin.ModelDuration(cmd.Flag("store.circuit-breaker.slow-call-threshold", "Time to first response above which Series requests count as failed for the circuit breaker. 0 disables slow call detection.").
+		Default("0s"))
+	storeCircuitBreakerOpenDuration := extkingpin.ModelDuration(cmd.Flag("store.circuit-breaker.open-duration", "Time during which the requests to a store are rejected once its circuit breaker opens, before a probe request is let through.").
+		Default("30s"))
+
 	storeSelectorRelabelConf := *extflag.RegisterPathOrContent(
 		cmd,
 		"selector.relabel-config",
@@ -154,6 +189,34 @@ func registerQuery(app *extkingpin.App) {
 		extflag.WithEnvSubstitution(),
 	)
 
+	costLimitsConf := extflag.RegisterPathOrContent(
+		cmd,
+		"query.cost-limits-config",
+		"YAML file with the thresholds on the estimated cost of queries, for all and per tenant. If set, the cost of queries is estimated by stores before running them. Queries estimated above the max_estimated_* thresholds are rejected, above the expensive_* thresholds are limited to max_concurrent_expensive_queries concurrent queries.",
+	)
+
+	accessPolicyConf := extflag.RegisterPathOrContent(
+		cmd,
+		"query.access-policy-config",
+		"YAML file with the access policies mapping tenants to the series selectors they can read. If set, selectors of queries, series, labels and exemplars requests are restricted to the series the tenant can read, and rules, alerts and targets are filtered by their labels. Tenants without policy are denied, unless default_selectors are set.",
+		extflag.WithEnvSubstitution(),
+	)
+	accessPolicyReloadTimer := cmd.Flag("query.access-policy-config-reload-timer", "Minimum amount of time to pass for the access policy configuration to be reloaded. Helps to avoid excessive reloads.").
+		Default("1s").Duration()
+
+	auditLogConf := extflag.RegisterPathOrContent(
+		cmd,
+		"query.audit-log-config",
+		"YAML file with the audit log configuration. If set, the requests to the query API are recorded with their tenant, user, expression, time range, series returned, bytes fetched, duration and status to a rotating JSON lines file and/or an HTTP webhook.",
+		extflag.WithEnvSubstitution(),
+	)
+
+	frontendAddresses := cmd.Flag("query.frontend-address", "Address of a query frontend with the request queue enabled (repeated). "+
+		"The querier connects to the frontends over gRPC, using the gRPC client flags, and runs the requests they queued, in addition to the requests it receives over HTTP.").
+		PlaceHolder("<address>").Strings()
+	frontendWorkerParallelism := cmd.Flag("query.frontend-worker-parallelism", "Number of requests run concurrently for each query frontend.").
+		Default("10").Int()
+
 	reqLogConfig := extkingpin.RegisterRequestLoggingFlags(cmd)
 
 	alertQueryURL := cmd.Flag("alert.query-url", "The external Thanos Query URL that would be set in all alerts 'Source' field.").String()
@@ -247,6 +310,42 @@ func registerQuery(app *extkingpin.App) {
 			return err
 		}
 
+		var costLimits *query.CostLimitsConfig
+		costLimitsContent, err := costLimitsConf.Content()
+		if err != nil {
+			return errors.Wrap(err, "error while reading cost limits configuration")
+		}
+		if len(costLimitsContent) > 0 {
+			if costLimits, err = query.ParseCostLimitsConfig(costLimitsContent); err != nil {
+				return err
+			}
+		}
+
+		var accessPolicy *tenancy.AccessPolicy
+		accessPolicyContent, err := accessPolicyConf.Content()
+		if err != nil {
+			return errors.Wrap(err, "error while reading access policy configuration")
+		}
+		if len(accessPolicyContent) > 0 {
+			accessPolicy, err = tenancy.NewAccessPolicy(accessPolicyConf, reg, log.With(logger, "component", "access-policy"), *accessPolicyReloadTimer)
+			if err != nil {
+				return err
+			}
+		}
+
+		var auditConf *audit.Config
+		auditLogContent, err := auditLogConf.Content()
+		if err != nil {
+			return errors.Wrap(err, "error while reading audit log configuration")
+		}
+		if len(auditLogContent) > 0 {
+			conf, err := audit.ParseConfig(auditLogContent)
+			if err != nil {
+				return err
+			}
+			auditConf = &conf
+		}
+
 		dialOpts, err := grpcClientConfig.dialOptions(logger, reg, tracer)
 		if err != nil {
 			return err
@@ -280,6 +379,11 @@ func registerQuery(app *extkingpin.App) {
 		if err != nil {
 			return err
 		}
+		endpointSet.SetCircuitBreakerConfig(query.CircuitBreakerConfig{
+			FailureThreshold:  *storeCircuitBreakerFailureThreshold,
+			SlowCallThreshold: time.Duration(*storeCircuitBreakerSlowCallThreshold),
+			OpenDuration:      time.Duration(*storeCircuitBreakerOpenDuration),
+		})
 
 		return runQuery(
 			g,
@@ -306,6 +410,16 @@ func registerQuery(app *extkingpin.App) {
 			*dynamicLookbackDelta,
 			time.Duration(*defaultEvaluationInterval),
 			time.Duration(*storeResponseTimeout),
+			store.HedgingConfig{
+				UpTo:     *storeHedgingUpTo,
+				Quantile: *storeHedgingQuantile,
+				MinDelay: time.Duration(*storeHedgingMinDelay),
+			},
+			store.ReplicaRoutingConfig{
+				Enabled:      *storeReplicaRouting,
+				Preference:   parseReplicaPreference(*storeReplicaRoutingPreference),
+				GapThreshold: time.Duration(*storeReplicaRoutingGapThreshold),
+			},
 			*deduplicationFunc,
 			*queryReplicaLabels,
 			*queryPartitionLabels,
@@ -337,6 +451,15 @@ func registerQuery(app *extkingpin.App) {
 			*enforceTenancy,
 			*tenantLabel,
 			*queryDistributedWithOverlappingInterval,
+			*remoteReadSampleLimit,
+			*remoteReadConcurrencyLimit,
+			*remoteReadMaxBytesInFrame,
+			costLimits,
+			accessPolicy,
+			auditConf,
+			*frontendAddresses,
+			*frontendWorkerParallelism,
+			dialOpts,
 		)
 	})
 }
@@ -368,6 +491,8 @@ func runQuery(
 	dynamicLookbackDelta bool,
 	defaultEvaluationInterval time.Duration,
 	storeResponseTimeout time.Duration,
+	storeHedging store.HedgingConfig,
+	storeReplicaRouting store.ReplicaRoutingConfig,
 	deduplicationFunc string,
 	queryReplicaLabels []string,
 	queryPartitionLabels []string,
@@ -399,6 +524,15 @@ func runQuery(
 	enforceTenancy bool,
 	tenantLabel string,
 	queryDistributedWithOverlappingInterval bool,
+	remoteReadSampleLimit int,
+	remoteReadConcurrencyLimit int,
+	remoteReadMaxBytesInFrame int,
+	costLimits *query.CostLimitsConfig,
+	accessPolicy *tenancy.AccessPolicy,
+	auditConf *audit.Config,
+	frontendAddresses []string,
+	frontendWorkerParallelism int,
+	frontendDialOpts []grpc.DialOption,
 ) error {
 	comp := component.Query
 	if alertQueryURL == "" {
@@ -412,6 +546,8 @@ func runQuery(
 	options := []store.ProxyStoreOption{
 		store.WithTSDBSelector(tsdbSelector),
 		store.WithProxyStoreDebugLogging(debugLogging),
+		store.WithSeriesHedging(storeHedging),
+		store.WithReplicaRouting(storeReplicaRouting),
 	}
 
 	// Parse and sanitize the provided replica labels flags.
@@ -470,6 +606,27 @@ func runQuery(
 
 	lookbackDeltaCreator := LookbackDeltaFactory(lookbackDelta, dynamicLookbackDelta)
 
+	var costEstimator *query.CostEstimator
+	if costLimits != nil {
+		costEstimator = query.NewCostEstimator(logger, reg, storepb.ServerAsClient(proxyStore), *costLimits)
+	}
+
+	var auditLog *audit.Log
+	if auditConf != nil {
+		var err error
+		auditLog, err = audit.NewLog(log.With(logger, "component", "audit"), reg, comp.String(), *auditConf, tenantHeader, defaultTenant, tenantCertField)
+		if err != nil {
+			return errors.Wrap(err, "create audit log")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			<-ctx.Done()
+			return auditLog.Close()
+		}, func(error) {
+			cancel()
+		})
+	}
+
 	// Start query API + UI HTTP server.
 	{
 		router := route.New()
@@ -537,9 +694,21 @@ func runQuery(
 			tenantCertField,
 			enforceTenancy,
 			tenantLabel,
+			gate.New(
+				extprom.WrapRegistererWithPrefix("thanos_query_concurrent_", reg),
+				remoteReadConcurrencyLimit,
+				gate.ReadRequests,
+			),
+			remoteReadSampleLimit,
+			remoteReadMaxBytesInFrame,
+			selectorLset,
+			costEstimator,
+			accessPolicy,
+			auditLog,
 		)
 
 		api.Register(router.WithPrefix("/api/v1"), tracer, logger, ins, logMiddleware)
+		api.RegisterFederate(router, tracer, logger, ins, logMiddleware)
 
 		srv := httpserver.New(logger, reg, comp, httpProbe,
 			httpserver.WithListen(httpBindAddr),
@@ -558,6 +727,40 @@ func runQuery(
 
 			srv.Shutdown(err)
 		})
+
+		if len(frontendAddresses) > 0 {
+			// The requests queued by the frontends do not have the route prefix.
+			handler := http.Handler(router)
+			if webRoutePrefix != "/" {
+				handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					r.URL.Path = webRoutePrefix + r.URL.Path
+					router.ServeHTTP(w, r)
+				})
+			}
+			worker, err := frontendv1.NewWorker(log.With(logger, "component", "frontend-worker"), handler, frontendAddresses, frontendWorkerParallelism, frontendDialOpts)
+			if err != nil {
+				return errors.Wrap(err, "create frontend worker")
+			}
+			g.Add(worker.Run, func(error) {
+				// Finish the running requests, the frontends hand over the other ones to other queriers.
+				ctx, cancel := context.WithTimeout(context.Background(), httpGracePeriod)
+				defer cancel()
+				worker.Stop(ctx)
+			})
+		}
+	}
+	if accessPolicy != nil && accessPolicy.CanReload() {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			level.Debug(logger).Log("msg", "access policy config initialized with file watcher.")
+			if err := accessPolicy.StartConfigReloader(ctx); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			return nil
+		}, func(error) {
+			cancel()
+		})
 	}
 	// Start query (proxy) gRPC StoreAPI.
 	{
@@ -577,6 +780,7 @@ func runQuery(
 						MaxTime:                      maxt,
 						SupportsSharding:             true,
 						SupportsWithoutReplicaLabels: true,
+						SupportsSeriesEstimate:       true,
 						TsdbInfos:                    proxyStore.TSDBInfos(),
 					}, nil
 				}
@@ -655,3 +859,16 @@ func LookbackDeltaFactory(
 		return lds[0]
 	}
 }
+
+// parseReplicaPreference splits the comma separated values of the flag, keeping their order.
+func parseReplicaPreference(f []string) []string {
+	var res []string
+	for _, v := range f {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" && !slices.Contains(res, p) {
+				res = append(res, p)
+			}
+		}
+	}
+	return res
+}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"time"
@@ -11,6 +12,7 @@ import (
 	extflag "github.com/efficientgo/tools/extkingpin"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	grpc_logging "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	"github.com/klauspost/compress/gzhttp"
 	"github.com/oklog/run"
 	"github.com/opentracing/opentracing-go"
@@ -23,9 +25,12 @@ import (
 
 	cortexfrontend "github.com/thanos-io/thanos/internal/cortex/frontend"
 	"github.com/thanos-io/thanos/internal/cortex/frontend/transport"
+	frontendv1 "github.com/thanos-io/thanos/internal/cortex/frontend/v1"
 	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
 	cortexvalidation "github.com/thanos-io/thanos/internal/cortex/util/validation"
 	"github.com/thanos-io/thanos/pkg/api"
+	apiv1 "github.com/thanos-io/thanos/pkg/api/query"
+	"github.com/thanos-io/thanos/pkg/audit"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/exthttp"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
@@ -34,17 +39,27 @@ import (
 	"github.com/thanos-io/thanos/pkg/logging"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/queryfrontend"
+	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
 	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/tenancy"
+	"github.com/thanos-io/thanos/pkg/tls"
 	"github.com/thanos-io/thanos/pkg/tracing"
 )
 
 type queryFrontendConfig struct {
 	queryfrontend.Config
-	http           httpConfig
-	webDisableCORS bool
-	orgIdHeaders   []string
+	http                    httpConfig
+	grpc                    grpcConfig
+	webDisableCORS          bool
+	orgIdHeaders            []string
+	accessPolicyConfig      *extflag.PathOrContent
+	accessPolicyReloadTimer time.Duration
+	auditLogConfig          *extflag.PathOrContent
+	queryRulesConfig        *extflag.PathOrContent
+	queryRulesReloadTimer   time.Duration
+	maxOutstandingPerTenant int
+	grpcQueryAPI            bool
 }
 
 func registerQueryFrontend(app *extkingpin.App) {
@@ -66,6 +81,7 @@ func registerQueryFrontend(app *extkingpin.App) {
 	}
 
 	cfg.http.registerFlag(cmd)
+	cfg.grpc.registerFlag(cmd)
 
 	cmd.Flag("web.disable-cors", "Whether to disable CORS headers to be set by Thanos. By default Thanos sets CORS headers to be allowed by all.").
 		Default("false").BoolVar(&cfg.webDisableCORS)
@@ -91,6 +107,17 @@ func registerQueryFrontend(app *extkingpin.App) {
 	cmd.Flag("query-range.horizontal-shards", "Split queries in this many requests when query duration is below query-range.max-split-interval.").
 		Default("0").Int64Var(&cfg.QueryRangeConfig.HorizontalShards)
 
+	cmd.Flag("query-range.adaptive-split.target-samples", "Target number of samples per split of range queries. "+
+		"When set, the cost of the queries is learned from the stats returned by the queriers, and the queries whose cost is known are split with the largest interval "+
+		"between query-range.min-split-interval and query-range.max-split-interval keeping their splits under the target, and sharded in up to query-frontend.vertical-shards shards when the min interval is not enough. 0 disables the target.").
+		Default("0").Int64Var(&cfg.QueryRangeConfig.AdaptiveSplitTargetSamples)
+
+	cmd.Flag("query-range.adaptive-split.target-duration", "Target duration per split of range queries, learned and applied like query-range.adaptive-split.target-samples. 0 disables the target.").
+		Default("0").DurationVar(&cfg.QueryRangeConfig.AdaptiveSplitTargetDuration)
+
+	cmd.Flag("query-range.adaptive-split.cost-ttl", "How long the learned cost of a query is kept after its last run.").
+		Default("1h").DurationVar(&cfg.QueryRangeConfig.AdaptiveSplitCostTTL)
+
 	cmd.Flag("query-range.max-retries-per-request", "Maximum number of retries for a single query range request; beyond this, the downstream error is returned.").
 		Default("5").IntVar(&cfg.QueryRangeConfig.MaxRetries)
 
@@ -109,12 +136,27 @@ func registerQueryFrontend(app *extkingpin.App) {
 	cmd.Flag("query-range.partial-response", "Enable partial response for query range requests if no partial_response param is specified. --no-query-range.partial-response for disabling.").
 		Default("true").BoolVar(&cfg.QueryRangeConfig.PartialResponseStrategy)
 
+	cmd.Flag("query-range.partial-response-cache-ttl", "How long the splits of query range requests with a partial response or warnings are cached before being fetched again, while the complete splits are cached as usual. 0 disables the caching of these splits.").
+		Default("0s").DurationVar(&cfg.QueryRangeConfig.PartialResponseCacheTTL)
+
 	cfg.QueryRangeConfig.CachePathOrContent = *extflag.RegisterPathOrContent(cmd, "query-range.response-cache-config", "YAML file that contains response cache configuration.", extflag.WithEnvSubstitution())
 
+	// Instant query tripperware flags.
+	cmd.Flag("query-instant.cache-time-bucket", "Align the evaluation time of cached instant queries down to a multiple of this duration, so that the queries sent within the same bucket share a cache entry. 0 disables the alignment. Only used when query-instant.response-cache-config is configured.").
+		Default("1m").DurationVar(&cfg.InstantQueryConfig.CacheTimeBucket)
+
+	cmd.Flag("query-instant.response-cache-max-freshness", "Most recent allowed cacheable evaluation time for instant queries, to prevent caching very recent results that might still be in flux.").
+		Default("10s").DurationVar(&cfg.InstantQueryConfig.MaxCacheFreshness)
+
+	cfg.InstantQueryConfig.CachePathOrContent = *extflag.RegisterPathOrContent(cmd, "query-instant.response-cache-config", "YAML file that contains response cache configuration for instant queries.", extflag.WithEnvSubstitution())
+
 	// Labels tripperware flags.
 	cmd.Flag("labels.split-interval", "Split labels requests by an interval and execute in parallel, it should be greater than 0 when labels.response-cache-config is configured.").
 		Default("24h").DurationVar(&cfg.LabelsConfig.SplitQueriesByInterval)
 
+	cmd.Flag("labels.vertical-shards", "Number of shards by series hash to use when distributing series requests, and labels requests with matchers. The shards of all the splits of a request share the labels.max-query-parallelism budget. 0 disables sharding.").
+		Default("0").IntVar(&cfg.LabelsConfig.NumShards)
+
 	cmd.Flag("labels.max-retries-per-request", "Maximum number of retries for a single label/series API request; beyond this, the downstream error is returned.").
 		Default("5").IntVar(&cfg.LabelsConfig.MaxRetries)
 
@@ -130,6 +172,9 @@ func registerQueryFrontend(app *extkingpin.App) {
 	cmd.Flag("labels.default-time-range", "The default metadata time range duration for retrieving labels through Labels and Series API when the range parameters are not specified.").
 		Default("24h").DurationVar(&cfg.DefaultTimeRange)
 
+	cmd.Flag("labels.partial-response-cache-ttl", "How long the splits of labels and series requests with a partial response or warnings are cached before being fetched again, while the complete splits are cached as usual. 0 disables the caching of these splits.").
+		Default("0s").DurationVar(&cfg.LabelsConfig.PartialResponseCacheTTL)
+
 	cfg.LabelsConfig.CachePathOrContent = *extflag.RegisterPathOrContent(cmd, "labels.response-cache-config", "YAML file that contains response cache configuration.", extflag.WithEnvSubstitution())
 
 	cmd.Flag("cache-compression-type", "Use compression in results cache. Supported values are: 'snappy' and '' (disable compression).").
@@ -140,6 +185,17 @@ func registerQueryFrontend(app *extkingpin.App) {
 
 	cfg.DownstreamTripperConfig.CachePathOrContent = *extflag.RegisterPathOrContent(cmd, "query-frontend.downstream-tripper-config", "YAML file that contains downstream tripper configuration. If your downstream URL is localhost or 127.0.0.1 then it is highly recommended to increase max_idle_conns_per_host to at least 100.", extflag.WithEnvSubstitution())
 
+	cmd.Flag("query-frontend.queue.enabled", "Queue the requests per tenant instead of forwarding them to --query-frontend.downstream-url. "+
+		"Queriers configured with --query.frontend-address pull the queued requests over gRPC, taking the tenants in turn, so that a tenant sending a lot of requests does not take up all the querier capacity.").
+		Default("false").BoolVar(&cfg.QueueEnabled)
+
+	cmd.Flag("query-frontend.queue.max-outstanding-per-tenant", "Maximum number of queued requests per tenant. Requests over the limit are rejected with 429 Too Many Requests. 0 means no limit.").
+		Default("100").IntVar(&cfg.maxOutstandingPerTenant)
+
+	cmd.Flag("query-frontend.grpc-query-api", "Serve the Query gRPC API of the queriers on --grpc-address. "+
+		"The gRPC requests are split, sharded, cached and retried like the HTTP ones.").
+		Default("false").BoolVar(&cfg.grpcQueryAPI)
+
 	cmd.Flag("query-frontend.compress-responses", "Compress HTTP responses.").
 		Default("false").BoolVar(&cfg.CompressResponses)
 
@@ -161,8 +217,21 @@ func registerQueryFrontend(app *extkingpin.App) {
 	cmd.Flag("query-frontend.default-tenant-id", "Default tenant ID to use if tenant header is not present").Default(tenancy.DefaultTenant).Hidden().StringVar(&cfg.DefaultTenant)
 	cmd.Flag("query-frontend.tenant-certificate-field", "Use TLS client's certificate field to determine tenant for requests. Must be one of "+tenancy.CertificateFieldOrganization+", "+tenancy.CertificateFieldOrganizationalUnit+" or "+tenancy.CertificateFieldCommonName+". This setting will cause the query-frontend.tenant-header flag value to be ignored.").Hidden().Default("").EnumVar(&cfg.TenantCertField, "", tenancy.CertificateFieldOrganization, tenancy.CertificateFieldOrganizationalUnit, tenancy.CertificateFieldCommonName)
 
+	cfg.accessPolicyConfig = extflag.RegisterPathOrContent(cmd, "query-frontend.access-policy-config", "YAML file with the access policies mapping tenants to the series selectors they can read. If set, selectors of queries, series, labels and exemplars requests are restricted to the series the tenant can read. Rules, alerts and targets are filtered by queriers configured with the same policies.", extflag.WithEnvSubstitution())
+	cmd.Flag("query-frontend.access-policy-config-reload-timer", "Minimum amount of time to pass for the access policy configuration to be reloaded. Helps to avoid excessive reloads.").
+		Default("1s").DurationVar(&cfg.accessPolicyReloadTimer)
+
+	cfg.queryRulesConfig = extflag.RegisterPathOrContent(cmd, "query-frontend.query-rules-config", "YAML file with the rules matching requests on tenant, PromQL regex or pattern and time range length. Matching query, query_range, labels and series requests are rejected, routed to another downstream URL or forced to a minimum max_source_resolution. The file is reloaded when it changes.", extflag.WithEnvSubstitution())
+	cmd.Flag("query-frontend.query-rules-config-reload-timer", "Minimum amount of time to pass for the query rules configuration to be reloaded. Helps to avoid excessive reloads.").
+		Default("1s").DurationVar(&cfg.queryRulesReloadTimer)
+
+	cfg.auditLogConfig = extflag.RegisterPathOrContent(cmd, "query-frontend.audit-log-config", "YAML file with the audit log configuration. If set, the requests are recorded with their tenant, user, expression, time range, duration and status to a rotating JSON lines file and/or an HTTP webhook.", extflag.WithEnvSubstitution())
+
 	cmd.Flag("query-frontend.vertical-shards", "Number of shards to use when distributing shardable PromQL queries. For more details, you can refer to the Vertical query sharding proposal: https://thanos.io/tip/proposals-accepted/202205-vertical-query-sharding.md").IntVar(&cfg.NumShards)
 
+	cmd.Flag("query-frontend.coalesce-requests", "Send identical concurrent requests of a tenant downstream once, the requests sharing the response. Requests are identical when they are encoded to the same downstream request, with the same method, URL, headers and body, after being split and sharded.").
+		Default("false").BoolVar(&cfg.CoalesceRequests)
+
 	cmd.Flag("query-frontend.slow-query-logs-user-header", "Set the value of the field remote_user in the slow query logs to the value of the given HTTP header. Falls back to reading the user from the basic auth header.").PlaceHolder("<http-header-name>").Default("").StringVar(&cfg.CortexHandlerConfig.SlowQueryLogsUserHeader)
 
 	reqLogConfig := extkingpin.RegisterRequestLoggingFlags(cmd)
@@ -173,7 +242,12 @@ func registerQueryFrontend(app *extkingpin.App) {
 			return errors.Wrap(err, "error while parsing config for request logging")
 		}
 
-		return runQueryFrontend(g, logger, reg, tracer, httpLogOpts, cfg, comp)
+		grpcLogOpts, logFilterMethods, err := logging.ParsegRPCOptions(reqLogConfig)
+		if err != nil {
+			return errors.Wrap(err, "error while parsing config for request logging")
+		}
+
+		return runQueryFrontend(g, logger, reg, tracer, httpLogOpts, grpcLogOpts, logFilterMethods, cfg, comp)
 	})
 }
 
@@ -250,6 +324,8 @@ func runQueryFrontend(
 	reg *prometheus.Registry,
 	tracer opentracing.Tracer,
 	httpLogOpts []logging.Option,
+	grpcLogOpts []grpc_logging.Option,
+	logFilterMethods []string,
 	cfg *queryFrontendConfig,
 	comp component.Component,
 ) error {
@@ -286,6 +362,22 @@ func runQueryFrontend(
 			Compression:                cfg.CacheCompression,
 			CacheConfig:                *cacheConfig,
 			CacheQueryableSamplesStats: cfg.CortexHandlerConfig.QueryStatsEnabled,
+			PartialResponseTTL:         cfg.QueryRangeConfig.PartialResponseCacheTTL,
+		}
+	}
+
+	instantQueryCacheConfContentYaml, err := cfg.InstantQueryConfig.CachePathOrContent.Content()
+	if err != nil {
+		return err
+	}
+	if len(instantQueryCacheConfContentYaml) > 0 {
+		cacheConfig, err := queryfrontend.NewCacheConfig(logger, instantQueryCacheConfContentYaml)
+		if err != nil {
+			return errors.Wrap(err, "initializing the instant query cache config")
+		}
+		cfg.InstantQueryConfig.ResultsCacheConfig = &queryrange.ResultsCacheConfig{
+			Compression: cfg.CacheCompression,
+			CacheConfig: *cacheConfig,
 		}
 	}
 
@@ -299,42 +391,128 @@ func runQueryFrontend(
 			return errors.Wrap(err, "initializing the labels cache config")
 		}
 		cfg.LabelsConfig.ResultsCacheConfig = &queryrange.ResultsCacheConfig{
-			Compression: cfg.CacheCompression,
-			CacheConfig: *cacheConfig,
+			Compression:        cfg.CacheCompression,
+			CacheConfig:        *cacheConfig,
+			PartialResponseTTL: cfg.LabelsConfig.PartialResponseCacheTTL,
 		}
 	}
 
-	if err := cfg.Validate(); err != nil {
-		return errors.Wrap(err, "error validating the config")
-	}
-
 	if cfg.EnableXFunctions {
 		for fname, v := range parse.XFunctions {
 			parser.Functions[fname] = v
 		}
 	}
 
-	// 用于封装 roundTripper
-	tripperWare, err := queryfrontend.NewTripperware(cfg.Config, reg, logger)
+	accessPolicyContent, err := cfg.accessPolicyConfig.Content()
 	if err != nil {
-		return errors.Wrap(err, "setup tripperwares")
+		return errors.Wrap(err, "error while reading access policy configuration")
+	}
+	if len(accessPolicyContent) > 0 {
+		cfg.AccessPolicy, err = tenancy.NewAccessPolicy(cfg.accessPolicyConfig, reg, log.With(logger, "component", "access-policy"), cfg.accessPolicyReloadTimer)
+		if err != nil {
+			return err
+		}
+		if cfg.AccessPolicy.CanReload() {
+			ctx, cancel := context.WithCancel(context.Background())
+			g.Add(func() error {
+				level.Debug(logger).Log("msg", "access policy config initialized with file watcher.")
+				if err := cfg.AccessPolicy.StartConfigReloader(ctx); err != nil {
+					return err
+				}
+				<-ctx.Done()
+				return nil
+			}, func(error) {
+				cancel()
+			})
+		}
 	}
 
-	downstreamTripperConfContentYaml, err := cfg.DownstreamTripperConfig.CachePathOrContent.Content()
+	queryRulesContent, err := cfg.queryRulesConfig.Content()
 	if err != nil {
-		return err
+		return errors.Wrap(err, "error while reading query rules configuration")
+	}
+	if len(queryRulesContent) > 0 {
+		cfg.QueryRules, err = queryfrontend.NewQueryRules(cfg.queryRulesConfig, reg, log.With(logger, "component", "query-rules"), cfg.queryRulesReloadTimer)
+		if err != nil {
+			return err
+		}
+		if cfg.QueryRules.CanReload() {
+			ctx, cancel := context.WithCancel(context.Background())
+			g.Add(func() error {
+				level.Debug(logger).Log("msg", "query rules config initialized with file watcher.")
+				if err := cfg.QueryRules.StartConfigReloader(ctx); err != nil {
+					return err
+				}
+				<-ctx.Done()
+				return nil
+			}, func(error) {
+				cancel()
+			})
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return errors.Wrap(err, "error validating the config")
 	}
 
-	// http.Transport.
-	downstreamTripper, err := parseTransportConfiguration(downstreamTripperConfContentYaml)
+	if cfg.QueryRangeConfig.AdaptiveSplitTargetSamples > 0 || cfg.QueryRangeConfig.AdaptiveSplitTargetDuration > 0 {
+		cfg.QueryCosts = queryfrontend.NewQueryCosts(cfg.QueryRangeConfig.AdaptiveSplitCostTTL, reg)
+	}
+
+	var auditLog *audit.Log
+	auditLogContent, err := cfg.auditLogConfig.Content()
 	if err != nil {
-		return err
+		return errors.Wrap(err, "error while reading audit log configuration")
+	}
+	if len(auditLogContent) > 0 {
+		auditConf, err := audit.ParseConfig(auditLogContent)
+		if err != nil {
+			return err
+		}
+		auditLog, err = audit.NewLog(log.With(logger, "component", "audit"), reg, comp.String(), auditConf, cfg.TenantHeader, cfg.DefaultTenant, cfg.TenantCertField)
+		if err != nil {
+			return errors.Wrap(err, "create audit log")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			<-ctx.Done()
+			return auditLog.Close()
+		}, func(error) {
+			cancel()
+		})
 	}
 
-	// DownstreamTripper.
-	roundTripper, err := cortexfrontend.NewDownstreamRoundTripper(cfg.DownstreamURL, downstreamTripper)
+	// 用于封装 roundTripper
+	tripperWare, err := queryfrontend.NewTripperware(cfg.Config, reg, logger)
 	if err != nil {
-		return errors.Wrap(err, "setup downstream roundtripper")
+		return errors.Wrap(err, "setup tripperwares")
+	}
+
+	var (
+		roundTripper http.RoundTripper
+		requestQueue *frontendv1.Frontend
+	)
+	if cfg.QueueEnabled {
+		// Queriers pull the requests from the queue, the downstream URL is not used.
+		requestQueue = frontendv1.New(cfg.maxOutstandingPerTenant, reg)
+		roundTripper = requestQueue
+	} else {
+		downstreamTripperConfContentYaml, err := cfg.DownstreamTripperConfig.CachePathOrContent.Content()
+		if err != nil {
+			return err
+		}
+
+		// http.Transport.
+		downstreamTripper, err := parseTransportConfiguration(downstreamTripperConfContentYaml)
+		if err != nil {
+			return err
+		}
+
+		// DownstreamTripper.
+		roundTripper, err = cortexfrontend.NewDownstreamRoundTripper(cfg.DownstreamURL, downstreamTripper)
+		if err != nil {
+			return errors.Wrap(err, "setup downstream roundtripper")
+		}
 	}
 
 	// Wrap the downstream RoundTripper into query frontend Tripperware.
@@ -347,9 +525,11 @@ func runQueryFrontend(
 		handler = gzhttp.GzipHandler(handler)
 	}
 
+	grpcProbe := prober.NewGRPC()
 	httpProbe := prober.NewHTTP()
 	statusProber := prober.Combine(
 		httpProbe,
+		grpcProbe,
 		prober.NewInstrumentation(comp, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 	)
 
@@ -386,7 +566,7 @@ func runQueryFrontend(
 						logger,
 						ins.NewHandler(
 							name,
-							logMiddleware.HTTPMiddleware(name, f),
+							logMiddleware.HTTPMiddleware(name, auditLog.HTTPMiddleware(name, f)),
 						),
 						// Cortex frontend middlewares require orgID.
 					),
@@ -411,6 +591,45 @@ func runQueryFrontend(
 		})
 	}
 
+	// gRPC server the queriers pull the queued requests from, and serving the Query API.
+	if requestQueue != nil || cfg.grpcQueryAPI {
+		tlsCfg, err := tls.NewServerConfig(log.With(logger, "protocol", "gRPC"), cfg.grpc.tlsSrvCert, cfg.grpc.tlsSrvKey, cfg.grpc.tlsSrvClientCA, cfg.grpc.tlsMinVersion)
+		if err != nil {
+			return errors.Wrap(err, "setup gRPC server")
+		}
+
+		opts := []grpcserver.Option{
+			grpcserver.WithListen(cfg.grpc.bindAddress),
+			grpcserver.WithGracePeriod(cfg.grpc.gracePeriod),
+			grpcserver.WithMaxConnAge(cfg.grpc.maxConnectionAge),
+			grpcserver.WithTLSConfig(tlsCfg),
+		}
+		if requestQueue != nil {
+			opts = append(opts, grpcserver.WithServer(frontendv1.RegisterFrontendServer(requestQueue)))
+		}
+		if cfg.grpcQueryAPI {
+			// The gRPC queries are audited and instrumented like the HTTP ones, the gRPC server traces and logs them.
+			name := "query-frontend"
+			queryHandler := ins.NewHandler(name, auditLog.HTTPMiddleware(name, handler))
+			opts = append(opts, grpcserver.WithServer(apiv1.RegisterQueryServer(queryfrontend.NewQueryServer(queryHandler, cfg.Config))))
+		}
+		s := grpcserver.New(logger, reg, tracer, grpcLogOpts, logFilterMethods, comp, grpcProbe, opts...)
+
+		g.Add(func() error {
+			return s.ListenAndServe()
+		}, func(err error) {
+			if requestQueue != nil {
+				// Let the queriers run the queued requests before closing their streams.
+				ctx, cancel := context.WithTimeout(context.Background(), cfg.grpc.gracePeriod)
+				defer cancel()
+				if err := requestQueue.Stop(ctx); err != nil {
+					level.Warn(logger).Log("msg", "failed to drain the request queue", "err", err)
+				}
+			}
+			s.Shutdown(err)
+		})
+	}
+
 	level.Info(logger).Log("msg", "starting query frontend")
 	statusProber.Ready()
 	return nil
@@ -387,6 +387,7 @@ func runReceive(
 						MaxTime:                      maxTime,
 						SupportsSharding:             true,
 						SupportsWithoutReplicaLabels: true,
+						SupportsSeriesEstimate:       true,
 						TsdbInfos:                    proxy.TSDBInfos(),
 					}, nil
 				}
@@ -742,6 +742,7 @@ func runRule(
 						MaxTime:                      maxt,
 						SupportsSharding:             true,
 						SupportsWithoutReplicaLabels: true,
+						SupportsSeriesEstimate:       true,
 						TsdbInfos:                    tsdbStore.TSDBInfos(),
 					}, nil
 				}
@@ -81,6 +81,10 @@ type storeConfig struct {
 	storeRateLimits               store.SeriesSelectLimits
 	maxDownloadedBytes            units.Base2Bytes
 	maxConcurrency                int
+	seriesSchedulerEnabled        bool
+	seriesMaxInflightBytes        units.Base2Bytes
+	limitsConfig                  *extflag.PathOrContent
+	limitsConfigReloadTimer       time.Duration
 	component                     component.StoreAPI
 	debugLogging                  bool
 	syncInterval                  time.Duration
@@ -102,8 +106,13 @@ type storeConfig struct {
 	lazyIndexReaderIdleTimeout    time.Duration
 	lazyExpandedPostingsEnabled   bool
 	postingGroupMaxKeySeriesRatio float64
+	parquetEnabled                bool
 
 	indexHeaderLazyDownloadStrategy string
+	indexHeaderFormat               string
+
+	prewarmBlocks    int
+	prewarmBandwidth units.Base2Bytes
 
 	matcherCacheSize int
 }
@@ -142,8 +151,18 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 		"Maximum amount of downloaded (either fetched or touched) bytes in a single Series/LabelNames/LabelValues call. The Series call fails if this limit is exceeded. 0 means no limit.").
 		Default("0").BytesVar(&sc.maxDownloadedBytes)
 
+	sc.limitsConfig = extflag.RegisterPathOrContent(cmd, "store.limits-config", "YAML file that contains per-tenant limits configuration. Limits that are not set fall back to the values of --store.limits.request-series, --store.limits.request-samples and --store.grpc.downloaded-bytes-limit. The tenant is taken from the tenant header of each request.", extflag.WithEnvSubstitution())
+	cmd.Flag("store.limits-config-reload-timer", "Minimum amount of time to pass for the limits configuration to be reloaded. Helps to avoid excessive reloads.").
+		Default("1s").DurationVar(&sc.limitsConfigReloadTimer)
+
 	cmd.Flag("store.grpc.series-max-concurrency", "Maximum number of concurrent Series calls.").Default("20").IntVar(&sc.maxConcurrency)
 
+	cmd.Flag("store.grpc.series-scheduler.enabled", "If true, Series calls are admitted by a scheduler instead of a single gate. The scheduler serves requests by priority class (taken from the 'thanos-series-priority' gRPC metadata: high, normal or low), then round-robin across tenants. It is bounded by --store.grpc.series-max-concurrency and --store.grpc.series-scheduler.max-inflight-bytes.").
+		Default("false").BoolVar(&sc.seriesSchedulerEnabled)
+
+	cmd.Flag("store.grpc.series-scheduler.max-inflight-bytes", "Maximum amount of bytes fetched or touched by all in-flight Series calls. New calls are queued while this budget is exhausted. Only used when --store.grpc.series-scheduler.enabled is set. 0 means no limit.").
+		Default("0").BytesVar(&sc.seriesMaxInflightBytes)
+
 	sc.component = component.Store
 
 	sc.objStoreConfig = *extkingpin.RegisterCommonObjStoreFlags(cmd, "", true)
@@ -207,6 +226,10 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("store.enable-lazy-expanded-postings", "If true, Store Gateway will estimate postings size and try to lazily expand postings if it downloads less data than expanding all postings.").
 		Default("false").BoolVar(&sc.lazyExpandedPostingsEnabled)
 
+	cmd.Flag("store.enable-parquet", "Experimental. If true, Store Gateway will serve series of blocks from their Parquet file, when it exists, "+
+		"using row group pruning on column statistics instead of the TSDB index. See --compact.enable-parquet and the tools bucket convert-parquet command.").
+		Default("false").BoolVar(&sc.parquetEnabled)
+
 	cmd.Flag("store.posting-group-max-key-series-ratio", "Mark posting group as lazy if it fetches more keys than R * max series the query should fetch. With R set to 100, a posting group which fetches 100K keys will be marked as lazy if the current query only fetches 1000 series. thanos_bucket_store_lazy_expanded_posting_groups_total shows lazy expanded postings groups with reasons and you can tune this config accordingly. This config is only valid if lazy expanded posting is enabled. 0 disables the limit.").
 		Default("100").Float64Var(&sc.postingGroupMaxKeySeriesRatio)
 
@@ -214,6 +237,19 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 		Default(string(indexheader.EagerDownloadStrategy)).
 		EnumVar(&sc.indexHeaderLazyDownloadStrategy, string(indexheader.EagerDownloadStrategy), string(indexheader.LazyDownloadStrategy))
 
+	cmd.Flag("store.index-header-format", "Experimental. Format version of the index-headers built by Store Gateway. Supported values: v1, v2. "+
+		"v2 index-headers hold front-coded symbols and postings offsets, which makes them smaller on disk and in page cache for high cardinality blocks. "+
+		"They keep one postings offset in memory every 32 label values, regardless of --store.index-header-posting-offsets-in-mem-sampling. "+
+		"Index-headers already on disk are read in any version; v1 is always used for blocks with TSDB index format v1.").
+		Default("v1").EnumVar(&sc.indexHeaderFormat, "v1", "v2")
+
+	cmd.Flag("store.prewarm.blocks", "Experimental. Number of most recently accessed blocks, their accesses counting half after 6h, whose index-header and most accessed postings are prefetched in the background on startup and whenever new blocks are loaded. "+
+		"Block access statistics are persisted in the data dir. Mostly useful with --store.enable-index-header-lazy-reader. 0 disables prewarming.").
+		Default("0").IntVar(&sc.prewarmBlocks)
+
+	cmd.Flag("store.prewarm.bandwidth", "Experimental. Maximum bytes per second loaded when prewarming blocks. 0 means no limit.").
+		Default("0").BytesVar(&sc.prewarmBandwidth)
+
 	cmd.Flag("web.disable", "Disable Block Viewer UI.").Default("false").BoolVar(&sc.disableWeb)
 
 	cmd.Flag("web.external-prefix", "Static prefix for all HTML links and redirect URLs in the bucket web UI interface. Actual endpoints are still served on / or the web.route-prefix. This allows thanos bucket web UI to be served behind a reverse proxy that strips a URL sub-path.").
@@ -351,6 +387,10 @@ func runStore(
 	if err != nil {
 		return err
 	}
+	shard, err := block.RelabelConfigShard(relabelConfig)
+	if err != nil {
+		return err
+	}
 
 	indexCacheContentYaml, err := conf.indexCacheConfigs.Content()
 	if err != nil {
@@ -407,7 +447,12 @@ func runStore(
 		return errors.Errorf("max concurrency value cannot be lower than 0 (got %v)", conf.maxConcurrency)
 	}
 
-	queriesGate := gate.New(extprom.WrapRegistererWithPrefix("thanos_bucket_store_series_", reg), int(conf.maxConcurrency), gate.Queries)
+	var queriesOption store.BucketStoreOption
+	if conf.seriesSchedulerEnabled {
+		queriesOption = store.WithSeriesScheduler(store.NewSeriesScheduler(reg, conf.maxConcurrency, uint64(conf.seriesMaxInflightBytes)))
+	} else {
+		queriesOption = store.WithQueryGate(gate.New(extprom.WrapRegistererWithPrefix("thanos_bucket_store_series_", reg), int(conf.maxConcurrency), gate.Queries))
+	}
 
 	chunkPool, err := store.NewDefaultChunkBytesPool(uint64(conf.chunkPoolSize))
 	if err != nil {
@@ -426,7 +471,7 @@ func runStore(
 		store.WithRegistry(reg),
 		store.WithIndexCache(indexCache),
 		store.WithMatchersCache(matchersCache),
-		store.WithQueryGate(queriesGate),
+		queriesOption,
 		store.WithChunkPool(chunkPool),
 		store.WithFilterConfig(conf.filterConf),
 		store.WithChunkHashCalculation(true),
@@ -446,17 +491,33 @@ func runStore(
 			return conf.estimatedMaxChunkSize
 		}),
 		store.WithLazyExpandedPostings(conf.lazyExpandedPostingsEnabled),
+		store.WithParquet(conf.parquetEnabled),
 		store.WithPostingGroupMaxKeySeriesRatio(conf.postingGroupMaxKeySeriesRatio),
 		store.WithSeriesMatchRatio(0.5), // TODO: expose series match ratio as config.
 		store.WithIndexHeaderLazyDownloadStrategy(
 			indexheader.IndexHeaderLazyDownloadStrategy(conf.indexHeaderLazyDownloadStrategy).StrategyToDownloadFunc(),
 		),
+		store.WithIndexHeaderFormat(indexHeaderFormat(conf.indexHeaderFormat)),
+		store.WithPrewarm(conf.prewarmBlocks, uint64(conf.prewarmBandwidth)),
 	}
 
 	if conf.debugLogging {
 		options = append(options, store.WithDebugLogging())
 	}
 
+	limitsContentYaml, err := conf.limitsConfig.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of limits configuration")
+	}
+	var tenantLimiter *store.TenantLimiter
+	if len(limitsContentYaml) > 0 {
+		tenantLimiter, err = store.NewTenantLimiter(conf.limitsConfig, conf.storeRateLimits, uint64(conf.maxDownloadedBytes), reg, log.With(logger, "component", "store-limiter"), conf.limitsConfigReloadTimer)
+		if err != nil {
+			return errors.Wrap(err, "create tenant limiter")
+		}
+		options = append(options, store.WithTenantLimiter(tenantLimiter))
+	}
+
 	bs, err := store.NewBucketStore(
 		insBkt,
 		metaFetcher,
@@ -518,6 +579,20 @@ func runStore(
 		})
 	}
 
+	if tenantLimiter != nil && tenantLimiter.CanReload() {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			level.Debug(logger).Log("msg", "limits config initialized with file watcher.")
+			if err := tenantLimiter.StartConfigReloader(ctx); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			return nil
+		}, func(error) {
+			cancel()
+		})
+	}
+
 	infoSrv := info.NewInfoServer(
 		component.Store.String(),
 		info.WithLabelSetFunc(func() []labelpb.ZLabelSet {
@@ -531,7 +606,9 @@ func runStore(
 					MaxTime:                      maxt,
 					SupportsSharding:             true,
 					SupportsWithoutReplicaLabels: true,
+					SupportsSeriesEstimate:       true,
 					TsdbInfos:                    bs.TSDBInfos(),
+					Shard:                        shard,
 				}, nil
 			}
 			return nil, errors.New("Not ready")
@@ -589,3 +666,11 @@ func runStore(
 	level.Info(logger).Log("msg", "starting store node")
 	return nil
 }
+
+// indexHeaderFormat returns the index-header format version for the given flag value.
+func indexHeaderFormat(format string) int {
+	if format == "v2" {
+		return indexheader.BinaryFormatV2
+	}
+	return indexheader.BinaryFormatV1
+}
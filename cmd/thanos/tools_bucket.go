@@ -12,6 +12,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -45,7 +46,9 @@ import (
 
 	v1 "github.com/thanos-io/thanos/pkg/api/blocks"
 	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/indexheader"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/block/parquet"
 	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/compactv2"
@@ -78,7 +81,9 @@ var (
 		},
 	}
 	inspectColumns = []string{"ULID", "FROM", "UNTIL", "RANGE", "UNTIL-DOWN", "#SERIES", "#SAMPLES", "#CHUNKS", "COMP-LEVEL", "COMP-FAILED", "LABELS", "RESOLUTION", "SOURCE"}
-	outputTypes    = []string{"table", "tsv", "csv"}
+	// inspectIndexHeaderColumns are only shown with --index-header-sizes.
+	inspectIndexHeaderColumns = []string{"INDEX-HEADER-V1", "INDEX-HEADER-V2", "INDEX-HEADER-SAVINGS"}
+	outputTypes               = []string{"table", "tsv", "csv"}
 )
 
 type outputType string
@@ -98,9 +103,10 @@ type bucketRewriteConfig struct {
 }
 
 type bucketInspectConfig struct {
-	selector []string
-	sortBy   []string
-	timeout  time.Duration
+	selector         []string
+	sortBy           []string
+	timeout          time.Duration
+	indexHeaderSizes bool
 }
 
 type bucketVerifyConfig struct {
@@ -169,6 +175,13 @@ type bucketUploadBlocksConfig struct {
 	labels []string
 }
 
+type bucketConvertParquetConfig struct {
+	blockIDs     []string
+	tmpDir       string
+	rowGroupSize int
+	overwrite    bool
+}
+
 func (tbc *bucketVerifyConfig) registerBucketVerifyFlag(cmd extkingpin.FlagClause) *bucketVerifyConfig {
 	cmd.Flag("repair", "Attempt to repair blocks for which issues were detected").
 		Short('r').Default("false").BoolVar(&tbc.repair)
@@ -203,8 +216,11 @@ func (tbc *bucketInspectConfig) registerBucketInspectFlag(cmd extkingpin.FlagCla
 	cmd.Flag("selector", "Selects blocks based on label, e.g. '-l key1=\\\"value1\\\" -l key2=\\\"value2\\\"'. All key value pairs must match.").Short('l').
 		PlaceHolder("<name>=\\\"<value>\\\"").StringsVar(&tbc.selector)
 	cmd.Flag("sort-by", "Sort by columns. It's also possible to sort by multiple columns, e.g. '--sort-by FROM --sort-by UNTIL'. I.e., if the 'FROM' value is equal the rows are then further sorted by the 'UNTIL' value.").
-		Default("FROM", "UNTIL").EnumsVar(&tbc.sortBy, inspectColumns...)
+		Default("FROM", "UNTIL").EnumsVar(&tbc.sortBy, append(append([]string{}, inspectColumns...), inspectIndexHeaderColumns...)...)
 	cmd.Flag("timeout", "Timeout to download metadata from remote storage").Default("5m").DurationVar(&tbc.timeout)
+	cmd.Flag("index-header-sizes", "If true, also report the size in bytes of the v1 and v2 index-headers of each block, and the savings of v2 over v1. "+
+		"This downloads the symbols and postings offset table of the index of every selected block, so consider increasing --timeout.").
+		Default("false").BoolVar(&tbc.indexHeaderSizes)
 
 	return tbc
 }
@@ -303,6 +319,15 @@ func (tbc *bucketUploadBlocksConfig) registerBucketUploadBlocksFlag(cmd extkingp
 	return tbc
 }
 
+func (tbc *bucketConvertParquetConfig) registerBucketConvertParquetFlag(cmd extkingpin.FlagClause) *bucketConvertParquetConfig {
+	cmd.Flag("id", "ID (ULID) of the blocks to convert (repeated flag). If none is specified, all blocks in the bucket are converted.").StringsVar(&tbc.blockIDs)
+	cmd.Flag("tmp.dir", "Working directory for temporary files").Default(filepath.Join(os.TempDir(), "thanos-convert-parquet")).StringVar(&tbc.tmpDir)
+	cmd.Flag("row-group-size", "Number of series per row group of the Parquet files.").Default(strconv.Itoa(parquet.DefaultRowGroupSize)).IntVar(&tbc.rowGroupSize)
+	cmd.Flag("overwrite", "Convert blocks which already have a Parquet file, replacing it.").Default("false").BoolVar(&tbc.overwrite)
+
+	return tbc
+}
+
 func registerBucket(app extkingpin.AppClause) {
 	cmd := app.Command("bucket", "Bucket utility commands")
 
@@ -318,6 +343,7 @@ func registerBucket(app extkingpin.AppClause) {
 	registerBucketRewrite(cmd, objStoreConfig)
 	registerBucketRetention(cmd, objStoreConfig)
 	registerBucketUploadBlocks(cmd, objStoreConfig)
+	registerBucketConvertParquet(cmd, objStoreConfig)
 }
 
 func registerBucketVerify(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
@@ -580,6 +606,24 @@ func registerBucketInspect(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 			blockMetas = append(blockMetas, meta)
 		}
 
+		var headerSizes map[ulid.ULID]indexHeaderSizes
+		if tbc.indexHeaderSizes {
+			headerSizes = make(map[ulid.ULID]indexHeaderSizes, len(blockMetas))
+			for _, meta := range blockMetas {
+				if !matchesSelector(meta, selectorLabels) {
+					continue
+				}
+				var sizes indexHeaderSizes
+				if sizes.v1, err = indexheader.BinarySize(ctx, insBkt, meta.ULID, indexheader.BinaryFormatV1); err != nil {
+					return errors.Wrapf(err, "get v1 index-header size of block %s", meta.ULID)
+				}
+				if sizes.v2, err = indexheader.BinarySize(ctx, insBkt, meta.ULID, indexheader.BinaryFormatV2); err != nil {
+					return errors.Wrapf(err, "get v2 index-header size of block %s", meta.ULID)
+				}
+				headerSizes[meta.ULID] = sizes
+			}
+		}
+
 		var opPrinter tablePrinter
 		op := outputType(*output)
 		switch op {
@@ -590,7 +634,7 @@ func registerBucketInspect(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 		case CSV:
 			opPrinter = printCSV
 		}
-		return printBlockData(blockMetas, selectorLabels, tbc.sortBy, opPrinter)
+		return printBlockData(blockMetas, selectorLabels, tbc.sortBy, opPrinter, headerSizes)
 	})
 }
 
@@ -973,8 +1017,16 @@ func printTSV(w io.Writer, t Table) error {
 	return nil
 }
 
-func printBlockData(blockMetas []*metadata.Meta, selectorLabels labels.Labels, sortBy []string, printer tablePrinter) error {
+// indexHeaderSizes holds the sizes in bytes of the index-header of a block in each format version.
+type indexHeaderSizes struct {
+	v1, v2 uint64
+}
+
+func printBlockData(blockMetas []*metadata.Meta, selectorLabels labels.Labels, sortBy []string, printer tablePrinter, headerSizes map[ulid.ULID]indexHeaderSizes) error {
 	header := inspectColumns
+	if headerSizes != nil {
+		header = append(append([]string{}, inspectColumns...), inspectIndexHeaderColumns...)
+	}
 
 	var lines [][]string
 	p := message.NewPrinter(language.English)
@@ -1011,6 +1063,15 @@ func printBlockData(blockMetas []*metadata.Meta, selectorLabels labels.Labels, s
 			time.Duration(blockMeta.Thanos.Downsample.Resolution*int64(time.Millisecond)).String(),
 			string(blockMeta.Thanos.Source))
 
+		if headerSizes != nil {
+			sizes := headerSizes[blockMeta.ULID]
+			savings := "-"
+			if sizes.v1 > 0 {
+				savings = fmt.Sprintf("%.1f%%", 100*(1-float64(sizes.v2)/float64(sizes.v1)))
+			}
+			line = append(line, p.Sprintf("%d", sizes.v1), p.Sprintf("%d", sizes.v2), savings)
+		}
+
 		lines = append(lines, line)
 	}
 
@@ -1518,3 +1579,100 @@ func registerBucketUploadBlocks(app extkingpin.AppClause, objStoreConfig *extfla
 		return nil
 	})
 }
+
+func registerBucketConvertParquet(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
+	cmd := app.Command("convert-parquet", "Convert blocks in the bucket to Parquet files stored next to them, "+
+		"which Store Gateway can serve series from with --store.enable-parquet. Useful to backfill blocks compacted without --compact.enable-parquet.")
+
+	tbc := &bucketConvertParquetConfig{}
+	tbc.registerBucketConvertParquetFlag(cmd)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		bkt, err := client.NewBucket(logger, confContentYaml, component.Bucket.String(), nil)
+		if err != nil {
+			return err
+		}
+		insBkt := objstoretracing.WrapWithTraces(objstore.WrapWithMetrics(bkt, extprom.WrapRegistererWithPrefix("thanos_", reg), bkt.Name()))
+
+		var ids []ulid.ULID
+		for _, id := range tbc.blockIDs {
+			u, err := ulid.Parse(id)
+			if err != nil {
+				return errors.Errorf("id is not a valid block ULID, got: %v", id)
+			}
+			ids = append(ids, u)
+		}
+
+		if err := os.RemoveAll(tbc.tmpDir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(tbc.tmpDir, os.ModePerm); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			defer runutil.CloseWithLogOnErr(logger, insBkt, "bucket client")
+
+			if len(ids) == 0 {
+				if err := insBkt.Iter(ctx, "", func(name string) error {
+					if id, ok := block.IsBlockDir(name); ok {
+						ids = append(ids, id)
+					}
+					return nil
+				}); err != nil {
+					return errors.Wrap(err, "list blocks")
+				}
+			}
+
+			var converted int
+			for _, id := range ids {
+				if !tbc.overwrite {
+					exists, err := insBkt.Exists(ctx, path.Join(id.String(), block.ParquetFilename))
+					if err != nil {
+						return errors.Wrapf(err, "check parquet file of %v", id)
+					}
+					if exists {
+						level.Info(logger).Log("msg", "skipping block with existing parquet file", "id", id)
+						continue
+					}
+				}
+				deletionMarked, err := insBkt.Exists(ctx, path.Join(id.String(), metadata.DeletionMarkFilename))
+				if err != nil {
+					return errors.Wrapf(err, "check deletion mark of %v", id)
+				}
+				if deletionMarked {
+					level.Info(logger).Log("msg", "skipping block marked for deletion", "id", id)
+					continue
+				}
+
+				bdir := filepath.Join(tbc.tmpDir, id.String())
+				level.Info(logger).Log("msg", "downloading block", "id", id)
+				if err := block.Download(ctx, logger, insBkt, id, bdir); err != nil {
+					return errors.Wrapf(err, "download %v", id)
+				}
+
+				begin := time.Now()
+				if err := parquet.ConvertAndUpload(ctx, logger, insBkt, bdir, tbc.rowGroupSize); err != nil {
+					return err
+				}
+				level.Info(logger).Log("msg", "uploaded parquet file", "id", id, "duration", time.Since(begin))
+				converted++
+
+				if err := os.RemoveAll(bdir); err != nil {
+					level.Warn(logger).Log("msg", "failed to remove block directory", "dir", bdir, "err", err)
+				}
+			}
+			level.Info(logger).Log("msg", "convert-parquet done", "converted", converted)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+		return nil
+	})
+}
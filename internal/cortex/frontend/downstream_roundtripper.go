@@ -4,6 +4,7 @@
 package frontend
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 	"path"
@@ -17,6 +18,14 @@ type downstreamRoundTripper struct {
 	transport     http.RoundTripper // 本质为 http.Transport
 }
 
+type downstreamURLContextKey struct{}
+
+// WithDownstreamURL returns a context making the downstream round tripper send the requests to the given URL
+// instead of the configured downstream URL.
+func WithDownstreamURL(ctx context.Context, u *url.URL) context.Context {
+	return context.WithValue(ctx, downstreamURLContextKey{}, u)
+}
+
 // NewDownstreamRoundTripper 创建 downstreamRoundTripper.
 func NewDownstreamRoundTripper(downstreamURL string, transport http.RoundTripper) (http.RoundTripper, error) {
 	// 解析 downstram URL.
@@ -39,10 +48,15 @@ func (d downstreamRoundTripper) RoundTrip(r *http.Request) (*http.Response, erro
 		}
 	}
 
+	downstreamURL := d.downstreamURL
+	if u, ok := r.Context().Value(downstreamURLContextKey{}).(*url.URL); ok {
+		downstreamURL = u
+	}
+
 	// 将原始请求 "重定向" 到 downstream url.
-	r.URL.Scheme = d.downstreamURL.Scheme
-	r.URL.Host = d.downstreamURL.Host
-	r.URL.Path = path.Join(d.downstreamURL.Path, r.URL.Path)
+	r.URL.Scheme = downstreamURL.Scheme
+	r.URL.Host = downstreamURL.Host
+	r.URL.Path = path.Join(downstreamURL.Path, r.URL.Path)
 	// 这里需要将原始请求的 Host 设置为空, 否则会导致请求失败.
 	// 这是为了让 http.Transport 在发送请求时，自动根据 r.URL.Host 来设置 Host 头，而不是使用原请求中的 r.Host 值
 	r.Host = ""
@@ -0,0 +1,155 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package queue
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ErrTooManyRequests is returned when a tenant has too many requests in the queue already.
+	ErrTooManyRequests = errors.New("too many outstanding requests")
+	// ErrStopped is returned when the queue does not accept requests anymore, or has no requests left once stopped.
+	ErrStopped = errors.New("queue is stopped")
+)
+
+// Request is a request waiting in the queue.
+type Request interface{}
+
+// RequestQueue holds the requests of each tenant in a FIFO queue, and hands them over to the queriers picking the
+// tenants in a round-robin fashion, so that a tenant sending a lot of requests does not delay the requests of the
+// other tenants.
+type RequestQueue struct {
+	maxOutstandingPerTenant int
+
+	mtx     sync.Mutex
+	queues  map[string][]Request
+	tenants []string
+	// next is the index in tenants of the tenant the next request is taken from.
+	next    int
+	stopped bool
+	// notify is closed and replaced when requests are enqueued or the queue is stopped, to wake up the queriers.
+	notify chan struct{}
+
+	queueLength       *prometheus.GaugeVec
+	discardedRequests *prometheus.CounterVec
+}
+
+// NewRequestQueue returns a queue accepting at most maxOutstandingPerTenant requests per tenant. The queue length and
+// discarded requests metrics are partitioned by tenant.
+func NewRequestQueue(maxOutstandingPerTenant int, queueLength *prometheus.GaugeVec, discardedRequests *prometheus.CounterVec) *RequestQueue {
+	return &RequestQueue{
+		maxOutstandingPerTenant: maxOutstandingPerTenant,
+		queues:                  map[string][]Request{},
+		notify:                  make(chan struct{}),
+		queueLength:             queueLength,
+		discardedRequests:       discardedRequests,
+	}
+}
+
+// EnqueueRequest adds the request to the queue of the tenant.
+func (q *RequestQueue) EnqueueRequest(tenant string, req Request) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.stopped {
+		return ErrStopped
+	}
+	if q.maxOutstandingPerTenant > 0 && len(q.queues[tenant]) >= q.maxOutstandingPerTenant {
+		q.discardedRequests.WithLabelValues(tenant).Inc()
+		return ErrTooManyRequests
+	}
+	q.enqueue(tenant, req)
+	return nil
+}
+
+// RequeueRequest adds back a request which could not be processed to the queue of the tenant, regardless of the
+// limits and of the queue being stopped, as the request was accepted already.
+func (q *RequestQueue) RequeueRequest(tenant string, req Request) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.enqueue(tenant, req)
+}
+
+func (q *RequestQueue) enqueue(tenant string, req Request) {
+	if _, ok := q.queues[tenant]; !ok {
+		q.tenants = append(q.tenants, tenant)
+	}
+	q.queues[tenant] = append(q.queues[tenant], req)
+	q.queueLength.WithLabelValues(tenant).Inc()
+	q.broadcast()
+}
+
+// GetNextRequestForQuerier blocks until a request is available, and returns it along with its tenant. Once the queue
+// is stopped, it keeps returning the queued requests and then returns ErrStopped.
+func (q *RequestQueue) GetNextRequestForQuerier(ctx context.Context) (Request, string, error) {
+	for {
+		q.mtx.Lock()
+		if len(q.tenants) > 0 {
+			if q.next >= len(q.tenants) {
+				q.next = 0
+			}
+			tenant := q.tenants[q.next]
+			queue := q.queues[tenant]
+			req := queue[0]
+			queue[0] = nil
+
+			if len(queue) == 1 {
+				// The tenant is removed, the next tenant moves to the current index.
+				delete(q.queues, tenant)
+				q.tenants = append(q.tenants[:q.next], q.tenants[q.next+1:]...)
+				q.queueLength.DeleteLabelValues(tenant)
+			} else {
+				q.queues[tenant] = queue[1:]
+				q.queueLength.WithLabelValues(tenant).Dec()
+				q.next++
+			}
+			q.mtx.Unlock()
+			return req, tenant, nil
+		}
+		if q.stopped {
+			q.mtx.Unlock()
+			return nil, "", ErrStopped
+		}
+		notify := q.notify
+		q.mtx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// Len returns the number of requests in the queue.
+func (q *RequestQueue) Len() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	n := 0
+	for _, queue := range q.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// Stop makes the queue reject new requests. The requests in the queue are still handed over to the queriers.
+func (q *RequestQueue) Stop() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.stopped = true
+	q.broadcast()
+}
+
+func (q *RequestQueue) broadcast() {
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
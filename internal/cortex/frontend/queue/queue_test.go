@@ -0,0 +1,95 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestQueue(maxOutstanding int) *RequestQueue {
+	return NewRequestQueue(maxOutstanding,
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "queue_length"}, []string{"tenant"}),
+		prometheus.NewCounterVec(prometheus.CounterOpts{Name: "discarded_requests_total"}, []string{"tenant"}),
+	)
+}
+
+func TestRequestQueue_RoundRobin(t *testing.T) {
+	q := newTestQueue(0)
+	for _, r := range []struct{ tenant, req string }{
+		{"a", "a1"}, {"a", "a2"}, {"a", "a3"}, {"b", "b1"}, {"c", "c1"}, {"c", "c2"},
+	} {
+		testutil.Ok(t, q.EnqueueRequest(r.tenant, r.req))
+	}
+	testutil.Equals(t, 6, q.Len())
+	testutil.Equals(t, 3.0, promtestutil.ToFloat64(q.queueLength.WithLabelValues("a")))
+
+	var got []string
+	for q.Len() > 0 {
+		req, _, err := q.GetNextRequestForQuerier(context.Background())
+		testutil.Ok(t, err)
+		got = append(got, req.(string))
+	}
+	testutil.Equals(t, []string{"a1", "b1", "c1", "a2", "c2", "a3"}, got)
+	testutil.Equals(t, 0, promtestutil.CollectAndCount(q.queueLength))
+}
+
+func TestRequestQueue_MaxOutstandingPerTenant(t *testing.T) {
+	q := newTestQueue(2)
+	testutil.Ok(t, q.EnqueueRequest("a", 1))
+	testutil.Ok(t, q.EnqueueRequest("a", 2))
+	testutil.Equals(t, ErrTooManyRequests, q.EnqueueRequest("a", 3))
+	testutil.Ok(t, q.EnqueueRequest("b", 1))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(q.discardedRequests.WithLabelValues("a")))
+
+	// Requeued requests were accepted already, so they are not limited.
+	q.RequeueRequest("a", 3)
+	testutil.Equals(t, 4, q.Len())
+}
+
+func TestRequestQueue_GetNextBlocksUntilEnqueued(t *testing.T) {
+	q := newTestQueue(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := q.GetNextRequestForQuerier(ctx)
+	testutil.Equals(t, context.DeadlineExceeded, err)
+
+	done := make(chan string)
+	go func() {
+		_, tenant, err := q.GetNextRequestForQuerier(context.Background())
+		testutil.Ok(t, err)
+		done <- tenant
+	}()
+	time.Sleep(10 * time.Millisecond)
+	testutil.Ok(t, q.EnqueueRequest("a", 1))
+	testutil.Equals(t, "a", <-done)
+}
+
+func TestRequestQueue_Stop(t *testing.T) {
+	q := newTestQueue(0)
+	testutil.Ok(t, q.EnqueueRequest("a", 1))
+
+	done := make(chan error)
+	go func() {
+		// Drains the queue, then returns once it is stopped.
+		for {
+			if _, _, err := q.GetNextRequestForQuerier(context.Background()); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Stop()
+
+	testutil.Equals(t, ErrStopped, <-done)
+	testutil.Equals(t, ErrStopped, q.EnqueueRequest("a", 2))
+	testutil.Equals(t, 0, q.Len())
+}
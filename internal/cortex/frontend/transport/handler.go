@@ -249,8 +249,8 @@ func (f *Handler) reportQueryStats(r *http.Request, queryString url.Values, quer
 		"fetched_series_count", numSeries,
 		"fetched_chunks_bytes", numBytes,
 	}, formatQueryString(queryString)...)
-	f.addStatsToLogMessage(logMessage, stats)
-	addQueryRangeToLogMessage(logMessage, queryString)
+	logMessage = f.addStatsToLogMessage(logMessage, stats)
+	logMessage = addQueryRangeToLogMessage(logMessage, queryString)
 
 	level.Info(util_log.WithContext(r.Context(), f.log)).Log(logMessage...)
 }
@@ -280,6 +280,9 @@ func (f *Handler) addStatsToLogMessage(message []interface{}, stats *querier_sta
 	if stats != nil {
 		message = append(message, "peak_samples", stats.LoadPeakSamples())
 		message = append(message, "total_samples_loaded", stats.LoadTotalSamples())
+		if reason := stats.LoadNotShardableReason(); reason != "" {
+			message = append(message, "not_shardable_reason", reason)
+		}
 	}
 
 	return message
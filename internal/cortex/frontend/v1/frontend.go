@@ -0,0 +1,255 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/thanos-io/thanos/internal/cortex/frontend/queue"
+	"github.com/thanos-io/thanos/internal/cortex/tenant"
+)
+
+// Frontend queues the requests per tenant, and hands them over to the queriers connected to its Process gRPC
+// service. It implements http.RoundTripper, so that it can be used in place of the downstream round tripper.
+type Frontend struct {
+	requestQueue *queue.RequestQueue
+	// pending is the number of requests waiting for a response.
+	pending atomic.Int64
+
+	queueDuration     prometheus.Histogram
+	connectedQueriers prometheus.Gauge
+}
+
+type request struct {
+	enqueueTime time.Time
+	ctx         context.Context
+	request     *httpgrpc.HTTPRequest
+	// response and err are buffered, so that Process does not block if the client gave up already.
+	response chan *httpgrpc.HTTPResponse
+	err      chan error
+}
+
+// New creates a new Frontend accepting at most maxOutstandingPerTenant queued requests per tenant.
+func New(maxOutstandingPerTenant int, reg prometheus.Registerer) *Frontend {
+	f := &Frontend{
+		queueDuration: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "thanos_query_frontend_queue_duration_seconds",
+			Help:    "Time spent by requests in the queue before being picked up by a querier.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		connectedQueriers: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_query_frontend_connected_queriers",
+			Help: "Number of querier streams connected to the frontend.",
+		}),
+	}
+	f.requestQueue = queue.NewRequestQueue(maxOutstandingPerTenant,
+		promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_query_frontend_queue_length",
+			Help: "Number of queued requests.",
+		}, []string{"tenant"}),
+		promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_frontend_discarded_requests_total",
+			Help: "Total number of requests discarded because the tenant had too many outstanding requests.",
+		}, []string{"tenant"}),
+	)
+	return f
+}
+
+// RoundTrip queues the request and waits for a querier to run it.
+func (f *Frontend) RoundTrip(r *http.Request) (*http.Response, error) {
+	tracer, span := opentracing.GlobalTracer(), opentracing.SpanFromContext(r.Context())
+	if tracer != nil && span != nil {
+		carrier := opentracing.HTTPHeadersCarrier(r.Header)
+		if err := tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := toHTTPGRPCRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.RoundTripGRPC(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpResp := &http.Response{
+		StatusCode:    int(resp.Code),
+		Body:          io.NopCloser(bytes.NewReader(resp.Body)),
+		Header:        http.Header{},
+		ContentLength: int64(len(resp.Body)),
+		Request:       r,
+	}
+	for _, h := range resp.Headers {
+		httpResp.Header[h.Key] = h.Values
+	}
+	return httpResp, nil
+}
+
+// RoundTripGRPC queues the request in the queue of the tenant of the context and waits for its response.
+func (f *Frontend) RoundTripGRPC(ctx context.Context, req *httpgrpc.HTTPRequest) (*httpgrpc.HTTPResponse, error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "%s", err.Error())
+	}
+	tenantID := tenant.JoinTenantIDs(tenantIDs)
+
+	r := &request{
+		enqueueTime: time.Now(),
+		ctx:         ctx,
+		request:     req,
+		response:    make(chan *httpgrpc.HTTPResponse, 1),
+		err:         make(chan error, 1),
+	}
+
+	f.pending.Add(1)
+	defer f.pending.Add(-1)
+
+	switch err := f.requestQueue.EnqueueRequest(tenantID, r); err {
+	case nil:
+	case queue.ErrTooManyRequests:
+		return nil, httpgrpc.Errorf(http.StatusTooManyRequests, "%s", err.Error())
+	default:
+		return nil, httpgrpc.Errorf(http.StatusServiceUnavailable, "%s", err.Error())
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case resp := <-r.response:
+		return resp, nil
+	case err := <-r.err:
+		return nil, err
+	}
+}
+
+// Process hands over the queued requests to the querier on the other side of the stream, one at a time. The querier
+// closes its side of the stream once it wants to stop, after sending the response to the request it was running.
+func (f *Frontend) Process(srv ProcessServer) error {
+	f.connectedQueriers.Inc()
+	defer f.connectedQueriers.Dec()
+
+	ctx, cancel := context.WithCancel(srv.Context())
+	defer cancel()
+
+	var (
+		resps   = make(chan *httpgrpc.HTTPResponse)
+		recvErr = make(chan error, 1)
+	)
+	go func() {
+		for {
+			resp, err := srv.Recv()
+			if err != nil {
+				recvErr <- err
+				// Stop waiting for requests, the querier does not accept more of them.
+				cancel()
+				return
+			}
+			select {
+			case resps <- resp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		req, tenantID, err := f.requestQueue.GetNextRequestForQuerier(ctx)
+		if err != nil {
+			return f.processExit(err, recvErr)
+		}
+		r := req.(*request)
+
+		// The client gave up already, there is no point running the request.
+		if r.ctx.Err() != nil {
+			continue
+		}
+		f.queueDuration.Observe(time.Since(r.enqueueTime).Seconds())
+
+		if err := srv.Send(r.request); err != nil {
+			f.requestQueue.RequeueRequest(tenantID, r)
+			return err
+		}
+
+		select {
+		case resp := <-resps:
+			r.response <- resp
+		case err := <-recvErr:
+			if err == io.EOF {
+				// The querier is shutting down without running the request, another querier will.
+				f.requestQueue.RequeueRequest(tenantID, r)
+				return nil
+			}
+			r.err <- err
+			return err
+		case <-r.ctx.Done():
+			// The querier is still running the request, its response is not needed anymore. Closing the stream
+			// makes the querier drop it and open a new stream.
+			return nil
+		}
+	}
+}
+
+func (f *Frontend) processExit(err error, recvErr <-chan error) error {
+	if err == queue.ErrStopped {
+		return nil
+	}
+	select {
+	case err := <-recvErr:
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	default:
+		return err
+	}
+}
+
+// Stop makes the frontend reject new requests, and waits until the pending requests got their responses or the
+// context is done.
+func (f *Frontend) Stop(ctx context.Context) error {
+	f.requestQueue.Stop()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for f.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "drain request queue, %d requests pending", f.pending.Load())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func toHTTPGRPCRequest(r *http.Request) (*httpgrpc.HTTPRequest, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+	}
+	req := &httpgrpc.HTTPRequest{
+		Method: r.Method,
+		Url:    r.URL.RequestURI(),
+		Body:   body,
+	}
+	for k, v := range r.Header {
+		req.Headers = append(req.Headers, &httpgrpc.Header{Key: k, Values: v})
+	}
+	return req, nil
+}
@@ -0,0 +1,199 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func setupFrontend(t *testing.T, maxOutstanding int) (*Frontend, string) {
+	t.Helper()
+
+	f := New(maxOutstanding, prometheus.NewRegistry())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+
+	s := grpc.NewServer()
+	RegisterFrontendServer(f)(s)
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(s.Stop)
+	return f, l.Addr().String()
+}
+
+func startWorker(t *testing.T, addr string, parallelism int, handler http.Handler) *Worker {
+	t.Helper()
+
+	w, err := NewWorker(log.NewNopLogger(), handler, []string{addr}, parallelism, []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())})
+	testutil.Ok(t, err)
+	go func() { testutil.Ok(t, w.Run()) }()
+	return w
+}
+
+func roundTrip(f *Frontend, tenant, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.RoundTrip(req.WithContext(user.InjectOrgID(context.Background(), tenant)))
+}
+
+func TestFrontend_RoundTrip(t *testing.T) {
+	f, addr := setupFrontend(t, 10)
+	w := startWorker(t, addr, 2, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, r.URL.Path+"?"+r.URL.RawQuery)
+	}))
+	defer w.Stop(context.Background())
+
+	resp, err := roundTrip(f, "team-a", "/api/v1/query?query=up")
+	testutil.Ok(t, err)
+	testutil.Equals(t, http.StatusOK, resp.StatusCode)
+	b, err := io.ReadAll(resp.Body)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "/api/v1/query?query=up", string(b))
+
+	// Server errors are passed through as responses.
+	resp, err = roundTrip(f, "team-a", "/api/v1/query?fail=1")
+	testutil.Ok(t, err)
+	testutil.Equals(t, http.StatusInternalServerError, resp.StatusCode)
+	b, err = io.ReadAll(resp.Body)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "failed", strings.TrimSpace(string(b)))
+
+	testutil.Ok(t, runUntil(func() bool { return promtestutil.ToFloat64(f.connectedQueriers) == 2 }))
+}
+
+func TestFrontend_TooManyRequests(t *testing.T) {
+	f, _ := setupFrontend(t, 1)
+
+	// Without querier, the first request stays in the queue.
+	ctx, cancel := context.WithCancel(user.InjectOrgID(context.Background(), "team-a"))
+	defer cancel()
+	go func() { _, _ = f.RoundTripGRPC(ctx, &httpgrpc.HTTPRequest{Url: "/"}) }()
+	testutil.Ok(t, runUntil(func() bool { return f.requestQueue.Len() == 1 }))
+
+	_, err := f.RoundTripGRPC(user.InjectOrgID(context.Background(), "team-a"), &httpgrpc.HTTPRequest{Url: "/"})
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	testutil.Assert(t, ok, "expected httpgrpc error, got %v", err)
+	testutil.Equals(t, int32(http.StatusTooManyRequests), resp.Code)
+
+	// Other tenants are not limited.
+	go func() { _, _ = f.RoundTripGRPC(user.InjectOrgID(ctx, "team-b"), &httpgrpc.HTTPRequest{Url: "/"}) }()
+	testutil.Ok(t, runUntil(func() bool { return f.requestQueue.Len() == 2 }))
+}
+
+func TestFrontend_Drain(t *testing.T) {
+	f, addr := setupFrontend(t, 10)
+
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = io.WriteString(w, "ok")
+	})
+
+	// Queue requests before any querier is connected.
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			resp, err := roundTrip(f, "team-a", "/api/v1/query")
+			if err == nil && resp.StatusCode != http.StatusOK {
+				err = io.ErrUnexpectedEOF
+			}
+			errs <- err
+		}()
+	}
+	testutil.Ok(t, runUntil(func() bool { return f.requestQueue.Len() == 3 }))
+
+	stopped := make(chan error)
+	go func() { stopped <- f.Stop(context.Background()) }()
+
+	// New requests are rejected once stopping, the queued ones still run.
+	testutil.Ok(t, runUntil(func() bool {
+		ctx, cancel := context.WithTimeout(user.InjectOrgID(context.Background(), "team-b"), 10*time.Millisecond)
+		defer cancel()
+		_, err := f.RoundTripGRPC(ctx, &httpgrpc.HTTPRequest{Url: "/"})
+		resp, ok := httpgrpc.HTTPResponseFromError(err)
+		return ok && resp.Code == http.StatusServiceUnavailable
+	}))
+
+	w := startWorker(t, addr, 1, handler)
+	defer w.Stop(context.Background())
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		testutil.Ok(t, <-errs)
+	}
+	testutil.Ok(t, <-stopped)
+}
+
+func TestWorker_StopFinishesRunningRequest(t *testing.T) {
+	f, addr := setupFrontend(t, 10)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	w1 := startWorker(t, addr, 1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		_, _ = io.WriteString(w, "w1")
+	}))
+	testutil.Ok(t, runUntil(func() bool { return promtestutil.ToFloat64(f.connectedQueriers) == 1 }))
+
+	bodies := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := roundTrip(f, "team-a", "/api/v1/query")
+			testutil.Ok(t, err)
+			b, err := io.ReadAll(resp.Body)
+			testutil.Ok(t, err)
+			bodies <- string(b)
+		}()
+	}
+	<-started
+
+	// The stopping worker finishes the request it is running, the other one is run by another worker.
+	stopped := make(chan struct{})
+	go func() {
+		w1.Stop(context.Background())
+		close(stopped)
+	}()
+	w2 := startWorker(t, addr, 1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "w2")
+	}))
+	defer w2.Stop(context.Background())
+	close(release)
+
+	got := map[string]int{}
+	for i := 0; i < 2; i++ {
+		got[<-bodies]++
+	}
+	testutil.Equals(t, map[string]int{"w1": 1, "w2": 1}, got)
+	<-stopped
+}
+
+func runUntil(cond func() bool) error {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return nil
+		}
+	}
+	return context.DeadlineExceeded
+}
@@ -0,0 +1,103 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+
+	"github.com/weaveworks/common/httpgrpc"
+	"google.golang.org/grpc"
+)
+
+// The Frontend gRPC service has a single bidirectional streaming method: queriers open Process streams, on which the
+// frontend sends the requests to run and the queriers send back one response per request. The httpgrpc messages are
+// used as is, so that the service does not need generated code of its own.
+const (
+	serviceName       = "frontend.Frontend"
+	processMethodName = "/" + serviceName + "/Process"
+)
+
+// ProcessServer is the server side of a Process stream.
+type ProcessServer interface {
+	Send(*httpgrpc.HTTPRequest) error
+	Recv() (*httpgrpc.HTTPResponse, error)
+	grpc.ServerStream
+}
+
+// ProcessClient is the querier side of a Process stream.
+type ProcessClient interface {
+	Send(*httpgrpc.HTTPResponse) error
+	Recv() (*httpgrpc.HTTPRequest, error)
+	grpc.ClientStream
+}
+
+// FrontendServer is the server API for the Frontend service.
+type FrontendServer interface {
+	Process(ProcessServer) error
+}
+
+var frontendServiceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*FrontendServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Process",
+			Handler:       processHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+// RegisterFrontendServer returns a function registering the Frontend service, to be passed to the gRPC server.
+func RegisterFrontendServer(srv FrontendServer) func(*grpc.Server) {
+	return func(s *grpc.Server) {
+		s.RegisterService(&frontendServiceDesc, srv)
+	}
+}
+
+func processHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FrontendServer).Process(&processServer{stream})
+}
+
+type processServer struct {
+	grpc.ServerStream
+}
+
+func (x *processServer) Send(m *httpgrpc.HTTPRequest) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *processServer) Recv() (*httpgrpc.HTTPResponse, error) {
+	m := new(httpgrpc.HTTPResponse)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// newProcessClient opens a Process stream on the connection.
+func newProcessClient(ctx context.Context, cc grpc.ClientConnInterface) (ProcessClient, error) {
+	stream, err := cc.NewStream(ctx, &frontendServiceDesc.Streams[0], processMethodName)
+	if err != nil {
+		return nil, err
+	}
+	return &processClient{stream}, nil
+}
+
+type processClient struct {
+	grpc.ClientStream
+}
+
+func (x *processClient) Send(m *httpgrpc.HTTPResponse) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *processClient) Recv() (*httpgrpc.HTTPRequest, error) {
+	m := new(httpgrpc.HTTPRequest)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
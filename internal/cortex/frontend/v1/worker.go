@@ -0,0 +1,233 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/httpgrpc/server"
+	"google.golang.org/grpc"
+)
+
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// Worker runs on queriers. It opens Process streams to the frontends, and runs the requests they send with the HTTP
+// handler of the querier.
+type Worker struct {
+	logger      log.Logger
+	server      *server.Server
+	addresses   []string
+	parallelism int
+	conns       []*grpc.ClientConn
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	stopping chan struct{}
+	done     chan struct{}
+}
+
+// NewWorker creates a worker opening parallelism streams to each of the frontend addresses.
+func NewWorker(logger log.Logger, handler http.Handler, addresses []string, parallelism int, dialOpts []grpc.DialOption) (*Worker, error) {
+	if parallelism <= 0 {
+		return nil, errors.New("frontend worker parallelism must be positive")
+	}
+
+	w := &Worker{
+		logger:      logger,
+		server:      server.NewServer(handler),
+		addresses:   addresses,
+		parallelism: parallelism,
+		stopping:    make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, addr := range addresses {
+		conn, err := grpc.NewClient(addr, dialOpts...)
+		if err != nil {
+			w.closeConns()
+			return nil, errors.Wrapf(err, "dial frontend %s", addr)
+		}
+		w.conns = append(w.conns, conn)
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	return w, nil
+}
+
+// Run processes requests until the worker is stopped.
+func (w *Worker) Run() error {
+	defer close(w.done)
+	defer w.closeConns()
+
+	var wg sync.WaitGroup
+	for i, conn := range w.conns {
+		logger := log.With(w.logger, "frontend", w.addresses[i])
+		for j := 0; j < w.parallelism; j++ {
+			wg.Add(1)
+			go func(conn *grpc.ClientConn) {
+				defer wg.Done()
+				w.runStreams(logger, conn)
+			}(conn)
+		}
+	}
+	wg.Wait()
+	return nil
+}
+
+// Stop makes the streams finish the requests they are running and close, so that the frontends hand over the
+// requests they sent afterwards to other queriers. Requests still running once the context is done are cancelled.
+func (w *Worker) Stop(ctx context.Context) {
+	w.stopOnce.Do(func() { close(w.stopping) })
+
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		level.Warn(w.logger).Log("msg", "cancelling requests still running after the frontend worker grace period")
+		w.cancel()
+		<-w.done
+	}
+}
+
+func (w *Worker) closeConns() {
+	for _, conn := range w.conns {
+		if err := conn.Close(); err != nil {
+			level.Warn(w.logger).Log("msg", "failed to close frontend connection", "err", err)
+		}
+	}
+}
+
+func (w *Worker) isStopping() bool {
+	select {
+	case <-w.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// runStreams opens a new stream each time the previous one ends, backing off while the frontend is not reachable or
+// does not hand over requests.
+func (w *Worker) runStreams(logger log.Logger, conn *grpc.ClientConn) {
+	backoff := minBackoff
+	for w.ctx.Err() == nil && !w.isStopping() {
+		processed, err := w.process(conn)
+		if err != nil {
+			level.Warn(logger).Log("msg", "error processing requests from frontend", "err", err)
+		}
+		if err == nil && processed {
+			backoff = minBackoff
+			continue
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-w.stopping:
+		case <-w.ctx.Done():
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// process runs the requests received on a new stream until the frontend closes it, or until the worker is stopping
+// and closes its side of the stream. It returns whether any request was processed.
+func (w *Worker) process(conn *grpc.ClientConn) (bool, error) {
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+
+	c, err := newProcessClient(ctx, conn)
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		// mtx protects the send side of the stream, which is closed by the worker once stopping and not busy.
+		mtx    sync.Mutex
+		busy   bool
+		closed bool
+	)
+	closeSend := func() {
+		if !closed {
+			closed = true
+			if err := c.CloseSend(); err != nil {
+				level.Warn(w.logger).Log("msg", "failed to close frontend stream", "err", err)
+			}
+		}
+	}
+	streamDone := make(chan struct{})
+	defer close(streamDone)
+	go func() {
+		select {
+		case <-w.stopping:
+		case <-streamDone:
+			return
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		if !busy {
+			closeSend()
+		}
+	}()
+
+	processed := false
+	for {
+		req, err := c.Recv()
+		if err == io.EOF {
+			return processed, nil
+		}
+		if err != nil {
+			return processed, err
+		}
+
+		mtx.Lock()
+		if closed {
+			// The frontend sent the request before noticing the stream was closed, and hands it over to another
+			// querier. Keep receiving until the frontend closes the stream.
+			mtx.Unlock()
+			continue
+		}
+		busy = true
+		mtx.Unlock()
+
+		resp := w.handle(ctx, req)
+		processed = true
+
+		mtx.Lock()
+		busy = false
+		err = c.Send(resp)
+		if w.isStopping() {
+			closeSend()
+		}
+		mtx.Unlock()
+		if err != nil {
+			return processed, err
+		}
+	}
+}
+
+func (w *Worker) handle(ctx context.Context, req *httpgrpc.HTTPRequest) *httpgrpc.HTTPResponse {
+	resp, err := w.server.Handle(ctx, req)
+	if err == nil {
+		return resp
+	}
+	// Server errors are returned as errors, the response can be unpacked from them.
+	if resp, ok := httpgrpc.HTTPResponseFromError(err); ok {
+		return resp
+	}
+	return &httpgrpc.HTTPResponse{
+		Code: http.StatusInternalServerError,
+		Body: []byte(err.Error()),
+	}
+}
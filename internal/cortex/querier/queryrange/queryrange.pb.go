@@ -166,7 +166,6 @@ func (m *PrometheusRequest) GetStep() int64 {
 	return 0
 }
 
-
 func (m *PrometheusRequest) GetTimeout() time.Duration {
 	if m != nil {
 		return m.Timeout
@@ -1375,13 +1374,16 @@ func (m *CachedResponse) GetExtents() []Extent {
 }
 
 type Extent struct {
-	Start                int64      `protobuf:"varint,1,opt,name=start,proto3" json:"start"`
-	End                  int64      `protobuf:"varint,2,opt,name=end,proto3" json:"end"`
-	TraceId              string     `protobuf:"bytes,4,opt,name=trace_id,json=traceId,proto3" json:"-"`
-	Response             *types.Any `protobuf:"bytes,5,opt,name=response,proto3" json:"response"`
-	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
-	XXX_unrecognized     []byte     `json:"-"`
-	XXX_sizecache        int32      `json:"-"`
+	Start    int64      `protobuf:"varint,1,opt,name=start,proto3" json:"start"`
+	End      int64      `protobuf:"varint,2,opt,name=end,proto3" json:"end"`
+	TraceId  string     `protobuf:"bytes,4,opt,name=trace_id,json=traceId,proto3" json:"-"`
+	Response *types.Any `protobuf:"bytes,5,opt,name=response,proto3" json:"response"`
+	// expires_at is the time in milliseconds after which the extent must be fetched again. It is only set for
+	// the extents of partial responses, which are cached for a short time.
+	ExpiresAt            int64    `protobuf:"varint,6,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *Extent) Reset()         { *m = Extent{} }
@@ -1445,6 +1447,13 @@ func (m *Extent) GetResponse() *types.Any {
 	return nil
 }
 
+func (m *Extent) GetExpiresAt() int64 {
+	if m != nil {
+		return m.ExpiresAt
+	}
+	return 0
+}
+
 type CachingOptions struct {
 	Disabled             bool     `protobuf:"varint,1,opt,name=disabled,proto3" json:"disabled,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -1635,103 +1644,103 @@ func init() {
 }
 
 var fileDescriptor_9af7607b46ac39b7 = []byte{
-	// 1523 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe4, 0x18, 0x3d, 0x6f, 0x1b, 0x47,
-	0xd6, 0x4b, 0x52, 0x14, 0xf9, 0x28, 0x4b, 0xf6, 0x48, 0x67, 0x53, 0x3a, 0x9d, 0x56, 0x5e, 0x1f,
-	0x0e, 0x3a, 0x9f, 0x4d, 0xe1, 0x74, 0xf0, 0x15, 0x06, 0xce, 0x77, 0xda, 0xb3, 0x12, 0x39, 0xf0,
-	0x87, 0x3c, 0x32, 0x1c, 0x20, 0x8d, 0x31, 0x24, 0x27, 0xd4, 0xc6, 0xe4, 0xee, 0x7a, 0x66, 0xd6,
-	0x96, 0xba, 0xfc, 0x86, 0x54, 0x29, 0x13, 0x20, 0x65, 0x90, 0xbf, 0x11, 0x17, 0x29, 0x52, 0x07,
-	0xc8, 0x26, 0x70, 0xb9, 0x55, 0x9a, 0xf4, 0xc1, 0x7c, 0x2c, 0x77, 0x96, 0x92, 0x28, 0x08, 0x69,
-	0x12, 0xa4, 0x11, 0xe7, 0x7d, 0xce, 0xfb, 0x9a, 0xb7, 0xef, 0x09, 0x6e, 0xf4, 0x22, 0x26, 0xe8,
-	0xe1, 0xe6, 0xcb, 0x84, 0xb2, 0x80, 0x32, 0xf5, 0x7b, 0xc4, 0x48, 0x38, 0xa0, 0xd6, 0xb1, 0x13,
-	0xb3, 0x48, 0x44, 0x08, 0x0a, 0xcc, 0xca, 0xd2, 0x20, 0x1a, 0x44, 0x0a, 0xbd, 0x29, 0x4f, 0x9a,
-	0x63, 0x65, 0x6d, 0x10, 0x45, 0x83, 0x21, 0xdd, 0x54, 0x50, 0x37, 0xf9, 0x70, 0xb3, 0x9f, 0x30,
-	0x22, 0x82, 0x28, 0x34, 0xf4, 0x55, 0x73, 0x9b, 0xfe, 0x89, 0xbb, 0xe6, 0x60, 0xa8, 0xcb, 0x93,
-	0xd2, 0x24, 0x3c, 0xd2, 0x24, 0x6f, 0x1f, 0xae, 0xee, 0xb1, 0x68, 0x44, 0xc5, 0x01, 0x4d, 0x38,
-	0xa6, 0x2f, 0x13, 0xca, 0xc5, 0x2e, 0x25, 0x7d, 0xca, 0xd0, 0x32, 0xd4, 0x1e, 0x91, 0x11, 0x6d,
-	0x3b, 0xeb, 0xce, 0x46, 0xd3, 0x9f, 0xc9, 0x52, 0xd7, 0xb9, 0x85, 0x15, 0x0a, 0xfd, 0x05, 0xea,
-	0xcf, 0xc8, 0x30, 0xa1, 0xbc, 0x5d, 0x59, 0xaf, 0x16, 0x44, 0x83, 0xf4, 0xd2, 0x0a, 0x5c, 0x3e,
-	0xa6, 0x15, 0x21, 0xa8, 0xc5, 0x44, 0x1c, 0x68, 0x7d, 0x58, 0x9d, 0xd1, 0x12, 0xcc, 0x70, 0x41,
-	0x98, 0x68, 0x57, 0xd6, 0x9d, 0x8d, 0x2a, 0xd6, 0x00, 0xba, 0x04, 0x55, 0x1a, 0xf6, 0xdb, 0x55,
-	0x85, 0x93, 0x47, 0x29, 0xcb, 0x05, 0x8d, 0xdb, 0x35, 0x85, 0x52, 0x67, 0xf4, 0x1f, 0x98, 0x15,
-	0xc1, 0x88, 0x46, 0x89, 0x68, 0xcf, 0xac, 0x3b, 0x1b, 0xad, 0xad, 0xe5, 0x8e, 0xf6, 0xb3, 0x93,
-	0xfb, 0xd9, 0xb9, 0x67, 0xa2, 0xe4, 0x37, 0xde, 0xa4, 0xee, 0x85, 0x4f, 0x7f, 0x70, 0x1d, 0x9c,
-	0xcb, 0xc8, 0xab, 0x55, 0xd8, 0xdb, 0x75, 0x65, 0x8f, 0x06, 0xd0, 0x2e, 0xcc, 0xf7, 0x48, 0xef,
-	0x20, 0x08, 0x07, 0x8f, 0x63, 0x29, 0xc9, 0xdb, 0xb3, 0x4a, 0xf7, 0x4a, 0xc7, 0xca, 0xda, 0xff,
-	0x4b, 0x1c, 0x7e, 0x4d, 0x2a, 0xc7, 0x13, 0x72, 0xe8, 0x1e, 0xcc, 0xea, 0x40, 0xf2, 0x76, 0x63,
-	0xbd, 0xba, 0xd1, 0xda, 0xba, 0x6e, 0xab, 0x38, 0x25, 0xe8, 0x79, 0x24, 0x73, 0x51, 0x13, 0x20,
-	0xc1, 0xdb, 0x4d, 0x6d, 0xa5, 0x02, 0xbc, 0xa7, 0xd0, 0xb6, 0x15, 0xf0, 0x38, 0x0a, 0x39, 0xfd,
-	0xd5, 0x69, 0xfb, 0xbe, 0x02, 0xe8, 0xb8, 0x5a, 0xe4, 0x41, 0x7d, 0x5f, 0x10, 0x91, 0x70, 0xa3,
-	0x12, 0xb2, 0xd4, 0xad, 0x73, 0x85, 0xc1, 0x86, 0x82, 0xde, 0x81, 0xda, 0x3d, 0x22, 0x88, 0x4a,
-	0xe3, 0x44, 0xb0, 0x0a, 0x8d, 0x92, 0xc3, 0xbf, 0x22, 0x83, 0x95, 0xa5, 0xee, 0x7c, 0x9f, 0x08,
-	0x72, 0x33, 0x1a, 0x05, 0x82, 0x8e, 0x62, 0x71, 0x84, 0x95, 0x3c, 0xba, 0x0d, 0xcd, 0x1d, 0xc6,
-	0x22, 0xf6, 0xf4, 0x28, 0xa6, 0x2a, 0xff, 0x4d, 0xff, 0x6a, 0x96, 0xba, 0x8b, 0x34, 0x47, 0x5a,
-	0x12, 0x05, 0x27, 0xfa, 0x3b, 0xcc, 0x28, 0x40, 0xd5, 0x47, 0xd3, 0x5f, 0xcc, 0x52, 0x77, 0x41,
-	0x89, 0x58, 0xec, 0x9a, 0x03, 0xed, 0x14, 0x69, 0x99, 0x51, 0x69, 0xf9, 0xeb, 0x69, 0x69, 0xb1,
-	0xa3, 0x7a, 0x2c, 0x2f, 0x5b, 0xd0, 0x78, 0x9f, 0xb0, 0x30, 0x08, 0x07, 0xbc, 0x5d, 0x57, 0xc1,
-	0xbc, 0x92, 0xa5, 0x2e, 0x7a, 0x6d, 0x70, 0xd6, 0xbd, 0x63, 0x3e, 0xef, 0x93, 0x0a, 0xcc, 0x97,
-	0xa3, 0x81, 0x3a, 0x00, 0x98, 0xf2, 0x64, 0x28, 0x94, 0xc3, 0x3a, 0xbe, 0xf3, 0x59, 0xea, 0x02,
-	0x1b, 0x63, 0xb1, 0xc5, 0x81, 0xfe, 0x07, 0x75, 0x0d, 0xa9, 0x0c, 0xb6, 0xb6, 0xda, 0xb6, 0xf1,
-	0xfb, 0x64, 0x14, 0x0f, 0xe9, 0xbe, 0x60, 0x94, 0x8c, 0xfc, 0x79, 0x13, 0xe7, 0xba, 0xd6, 0x84,
-	0x8d, 0x1c, 0x7a, 0x94, 0x17, 0x54, 0x55, 0xa5, 0xea, 0xfa, 0x74, 0xef, 0x65, 0x7a, 0xb9, 0x8e,
-	0xa7, 0x92, 0xb2, 0xe3, 0xa9, 0x10, 0xe8, 0x2e, 0x34, 0x48, 0x48, 0x86, 0x47, 0x3c, 0xe0, 0x2a,
-	0xfa, 0xad, 0xad, 0x25, 0x5b, 0xe5, 0xb6, 0xa1, 0xf9, 0x73, 0x59, 0xea, 0x8e, 0x39, 0xf1, 0xf8,
-	0xe4, 0xfd, 0x5c, 0x81, 0xb5, 0xe2, 0xde, 0xfb, 0x21, 0x17, 0x24, 0x14, 0x4f, 0xa4, 0x82, 0x73,
-	0x15, 0x20, 0x2e, 0x15, 0xe0, 0xdf, 0x4e, 0xf6, 0xca, 0xd6, 0xfe, 0x47, 0x2f, 0xc6, 0xaf, 0x2a,
-	0xb0, 0x72, 0x7a, 0x64, 0xce, 0x5d, 0x98, 0x7b, 0x56, 0x61, 0xca, 0x0c, 0x6c, 0x9c, 0x9d, 0x01,
-	0xcd, 0xff, 0xbb, 0x29, 0xd4, 0x9f, 0x1c, 0x58, 0x9d, 0xe6, 0x08, 0xba, 0x01, 0x75, 0xde, 0x23,
-	0x43, 0xc2, 0x54, 0xb8, 0x5a, 0x5b, 0x97, 0x3a, 0xf9, 0xd7, 0xd8, 0xbc, 0xcc, 0xdd, 0x0b, 0xd8,
-	0x70, 0xa0, 0xbb, 0x30, 0xc7, 0x05, 0x0b, 0xc2, 0x81, 0xa6, 0x98, 0xa0, 0x95, 0x5f, 0xb3, 0x45,
-	0xdf, 0xbd, 0x80, 0x4b, 0xfc, 0xe8, 0x26, 0xd4, 0x5f, 0xd1, 0x9e, 0x88, 0x98, 0x89, 0x0e, 0xb2,
-	0x25, 0x9f, 0x29, 0x8a, 0xbc, 0x4d, 0xf3, 0x48, 0xee, 0x11, 0x11, 0x2c, 0x38, 0x34, 0x8e, 0x97,
-	0xb8, 0x1f, 0x2a, 0x8a, 0xe4, 0xd6, 0x3c, 0x7e, 0x03, 0x4c, 0x2a, 0xbc, 0x7f, 0x43, 0xfd, 0x59,
-	0xae, 0x61, 0x96, 0xab, 0x9b, 0xe5, 0x1b, 0xac, 0x4e, 0xaa, 0xd0, 0x46, 0xe1, 0x9c, 0xc5, 0xdb,
-	0x85, 0xba, 0xd6, 0x8a, 0xee, 0xc2, 0x45, 0x6e, 0x75, 0xa5, 0x5c, 0xfa, 0xd4, 0xb6, 0x85, 0xcb,
-	0xec, 0xde, 0xb0, 0x3c, 0x9e, 0x58, 0xb9, 0x46, 0x4f, 0x6c, 0x93, 0xa4, 0x57, 0x37, 0xce, 0xa8,
-	0x10, 0xcd, 0xac, 0x0b, 0xa5, 0x95, 0xa5, 0x6e, 0x2e, 0x5e, 0xd8, 0xfd, 0x75, 0xa9, 0x17, 0x9d,
-	0x24, 0x88, 0x1e, 0xc3, 0x9f, 0x44, 0x24, 0xc8, 0x50, 0x25, 0x9e, 0x74, 0x87, 0x39, 0x55, 0xd9,
-	0x50, 0xf5, 0x97, 0xb3, 0xd4, 0x3d, 0x99, 0x01, 0x9f, 0x8c, 0x46, 0x9f, 0x39, 0xb0, 0x7a, 0x22,
-	0x65, 0x8f, 0xb2, 0x7d, 0x39, 0xf2, 0xe8, 0x46, 0x7f, 0x67, 0xba, 0x73, 0x93, 0xc2, 0xca, 0x58,
-	0xa3, 0xc1, 0x5f, 0xcf, 0x52, 0x77, 0xea, 0x1d, 0x78, 0x2a, 0x15, 0xfd, 0x13, 0x5a, 0x31, 0x25,
-	0x2f, 0x72, 0x4f, 0x65, 0xc5, 0xcd, 0xf8, 0x0b, 0x59, 0xea, 0xda, 0x68, 0x6c, 0x03, 0x5e, 0x00,
-	0xe7, 0x34, 0x52, 0x0e, 0x3a, 0xaf, 0xe4, 0x18, 0xa2, 0x03, 0x89, 0x35, 0x80, 0xae, 0xc1, 0x9c,
-	0x9c, 0xd7, 0xb8, 0x20, 0xa3, 0xf8, 0xf9, 0x88, 0x9b, 0x31, 0xb1, 0x35, 0xc6, 0x3d, 0xe4, 0xde,
-	0xe7, 0x15, 0x98, 0xb3, 0x4b, 0x08, 0x7d, 0xec, 0x40, 0x7d, 0x48, 0xba, 0x74, 0x98, 0x57, 0xdb,
-	0x62, 0xf1, 0x10, 0x1f, 0x48, 0xfc, 0x1e, 0x09, 0x98, 0xbf, 0x2f, 0xdb, 0xce, 0x77, 0xa9, 0xbb,
-	0x3d, 0x08, 0xc4, 0x41, 0xd2, 0xed, 0xf4, 0xa2, 0xd1, 0xa6, 0x38, 0x20, 0x61, 0xc4, 0x6f, 0x05,
-	0x91, 0x39, 0x6d, 0x06, 0xa1, 0xa0, 0x2c, 0x24, 0xc3, 0xcd, 0x89, 0xf1, 0x5a, 0xeb, 0xd9, 0xee,
-	0x93, 0x58, 0x50, 0x26, 0x7b, 0xd7, 0x88, 0x0a, 0x16, 0xf4, 0xb0, 0xb9, 0x17, 0xdd, 0x29, 0x6a,
-	0x53, 0xa7, 0xef, 0x58, 0x2f, 0x28, 0xda, 0x9e, 0x72, 0xb4, 0x28, 0x42, 0x84, 0x01, 0x0e, 0x02,
-	0x2e, 0xa2, 0x01, 0x93, 0xef, 0xa5, 0xaa, 0xc4, 0xdd, 0xe3, 0xef, 0x65, 0x37, 0xe7, 0x51, 0xde,
-	0x5c, 0x36, 0xda, 0x9a, 0x63, 0x51, 0x6c, 0x69, 0xf1, 0xbe, 0xa8, 0x40, 0xdd, 0x74, 0x8e, 0xdf,
-	0x40, 0x74, 0xfe, 0x01, 0x2d, 0xed, 0xac, 0x9a, 0x3b, 0x55, 0x4e, 0x1d, 0xbf, 0x99, 0xa5, 0xae,
-	0x4e, 0x3a, 0xb6, 0xa9, 0x68, 0x15, 0x9a, 0xe3, 0x6c, 0x9b, 0x8d, 0xa0, 0x40, 0xa0, 0x07, 0x50,
-	0x78, 0x6c, 0x9a, 0xdb, 0x9f, 0xa7, 0xc4, 0x4a, 0xc5, 0xc9, 0x29, 0xc7, 0xa9, 0x38, 0x7a, 0xef,
-	0xc2, 0x9c, 0xdd, 0x75, 0xcb, 0x35, 0xd9, 0x3c, 0x47, 0x4d, 0x0a, 0x58, 0x3c, 0x21, 0x4b, 0x65,
-	0x5f, 0x9c, 0x49, 0x5f, 0xfe, 0x6b, 0xfb, 0x52, 0x39, 0xdb, 0x17, 0xbd, 0x76, 0x58, 0xe6, 0xc7,
-	0xb0, 0x30, 0xc1, 0x23, 0x3d, 0xe8, 0x45, 0x49, 0x28, 0xd4, 0x6d, 0x0e, 0xd6, 0x80, 0xdc, 0xaf,
-	0x78, 0xa2, 0xef, 0x70, 0xb0, 0x3c, 0xa2, 0xdb, 0x30, 0xdb, 0x4d, 0x7a, 0x2f, 0xa8, 0xc8, 0x2b,
-	0xae, 0x74, 0x73, 0x71, 0xa7, 0xe2, 0xc1, 0x39, 0xaf, 0xc7, 0x61, 0x61, 0x82, 0x86, 0xd6, 0x00,
-	0xba, 0x51, 0x12, 0xf6, 0x09, 0x0b, 0x4c, 0x57, 0x9c, 0xc1, 0x16, 0x46, 0x5a, 0x34, 0x8c, 0x5e,
-	0x53, 0x66, 0x6e, 0xd7, 0x80, 0xc4, 0x26, 0x71, 0x4c, 0xf5, 0xe7, 0xcc, 0xc1, 0x1a, 0x28, 0xac,
-	0xaf, 0x59, 0xd6, 0x7b, 0x1f, 0xc1, 0xbc, 0x5c, 0xc0, 0x68, 0x7f, 0x3c, 0x20, 0x2e, 0x43, 0xf5,
-	0x05, 0x3d, 0x32, 0x53, 0xca, 0x6c, 0x96, 0xba, 0x12, 0xc4, 0xf2, 0x8f, 0x5c, 0x12, 0xe9, 0xa1,
-	0xa0, 0xa1, 0xc8, 0x5f, 0x62, 0xe9, 0xc3, 0xb5, 0xa3, 0x48, 0xfe, 0x82, 0x79, 0x3d, 0x39, 0x2b,
-	0xce, 0x0f, 0xde, 0x97, 0x0e, 0xd4, 0x35, 0x13, 0x72, 0xf3, 0x55, 0x55, 0x77, 0x7a, 0x55, 0xaf,
-	0x0a, 0x91, 0x6f, 0xad, 0xcb, 0x7a, 0x6b, 0x55, 0xe5, 0xa0, 0xad, 0xa0, 0x61, 0x5f, 0xaf, 0xaf,
-	0xeb, 0xd0, 0x10, 0x8c, 0xf4, 0xe8, 0xf3, 0xa0, 0x6f, 0xa6, 0xc2, 0x7c, 0x84, 0x53, 0xe8, 0xfb,
-	0x7d, 0x39, 0x9d, 0x30, 0xe3, 0x8e, 0xd9, 0x66, 0x97, 0x8e, 0x6d, 0xb3, 0xdb, 0xe1, 0x91, 0x9e,
-	0x4e, 0x72, 0x4e, 0x3c, 0x3e, 0xbd, 0x57, 0x6b, 0x54, 0x2f, 0xd5, 0xbc, 0x9b, 0x3a, 0x34, 0xd6,
-	0x16, 0xba, 0x02, 0x8d, 0x7e, 0xc0, 0x65, 0xd3, 0xed, 0x2b, 0xc3, 0x1b, 0x78, 0x0c, 0x7b, 0x21,
-	0xb4, 0x76, 0x0e, 0xe3, 0x21, 0x09, 0xd5, 0x8e, 0x8c, 0x56, 0xa1, 0x16, 0x16, 0x8b, 0x63, 0x23,
-	0x4b, 0x5d, 0x05, 0x63, 0xf5, 0x17, 0x6d, 0x43, 0xa3, 0x77, 0x10, 0x0c, 0xfb, 0x8c, 0x86, 0x26,
-	0x92, 0x57, 0xcb, 0x91, 0x1c, 0x2b, 0xd2, 0x36, 0xe6, 0xcc, 0x78, 0x7c, 0xf2, 0xbe, 0x71, 0xa0,
-	0x91, 0x8f, 0x59, 0x67, 0xdc, 0xd6, 0x85, 0x8b, 0xf4, 0x90, 0xf6, 0x12, 0xa9, 0xef, 0x69, 0x30,
-	0xca, 0x07, 0xa4, 0x29, 0x1b, 0xfe, 0x35, 0xd3, 0xb1, 0x1a, 0x39, 0x26, 0x4b, 0xdd, 0xb2, 0x0e,
-	0x5c, 0x06, 0x65, 0xc8, 0xc7, 0x1e, 0xe9, 0xa2, 0x9f, 0x32, 0x10, 0x1e, 0x77, 0xc7, 0x6f, 0xbf,
-	0x79, 0xbb, 0xe6, 0x7c, 0xfb, 0x76, 0xcd, 0xf9, 0xf1, 0xed, 0x9a, 0xf3, 0x81, 0xf5, 0x3f, 0x9c,
-	0x6e, 0x5d, 0x99, 0xf7, 0xaf, 0x5f, 0x02, 0x00, 0x00, 0xff, 0xff, 0xd9, 0xc7, 0x1d, 0xa3, 0x04,
-	0x12, 0x00, 0x00,
+	// 1536 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe4, 0x18, 0x4d, 0x6f, 0x1b, 0x45,
+	0xb4, 0x6b, 0x3b, 0x8e, 0xfd, 0x9c, 0x26, 0xed, 0x24, 0x34, 0x9b, 0x10, 0xb2, 0xee, 0x82, 0x50,
+	0x28, 0xad, 0x23, 0x82, 0xca, 0xa1, 0x12, 0x85, 0x2c, 0x0d, 0xa4, 0xa8, 0x1f, 0xe9, 0xa4, 0x2a,
+	0x12, 0x97, 0x6a, 0x6c, 0x0f, 0xce, 0x52, 0x7b, 0x77, 0xbb, 0x33, 0xdb, 0x26, 0x37, 0x7e, 0x03,
+	0x27, 0x8e, 0x20, 0x71, 0xe6, 0x6f, 0xd0, 0x03, 0x07, 0xce, 0x20, 0x16, 0xd4, 0xe3, 0x9e, 0xb8,
+	0x70, 0x47, 0xf3, 0xb1, 0xde, 0xb1, 0x9d, 0x38, 0x8a, 0xb8, 0x80, 0xb8, 0xd8, 0xf3, 0x3e, 0xe7,
+	0x7d, 0xcd, 0xf3, 0x7b, 0x86, 0x2b, 0x9d, 0x30, 0xe6, 0xf4, 0x70, 0xf3, 0x69, 0x42, 0x63, 0x9f,
+	0xc6, 0xf2, 0xfb, 0x28, 0x26, 0x41, 0x8f, 0x1a, 0xc7, 0x56, 0x14, 0x87, 0x3c, 0x44, 0x50, 0x60,
+	0x56, 0x97, 0x7a, 0x61, 0x2f, 0x94, 0xe8, 0x4d, 0x71, 0x52, 0x1c, 0xab, 0xeb, 0xbd, 0x30, 0xec,
+	0xf5, 0xe9, 0xa6, 0x84, 0xda, 0xc9, 0x17, 0x9b, 0xdd, 0x24, 0x26, 0xdc, 0x0f, 0x03, 0x4d, 0x5f,
+	0xd3, 0xb7, 0xa9, 0xaf, 0xa8, 0xad, 0x0f, 0x9a, 0xba, 0x32, 0x2e, 0x4d, 0x82, 0x23, 0x45, 0x72,
+	0xf7, 0x61, 0x79, 0x2f, 0x0e, 0x07, 0x94, 0x1f, 0xd0, 0x84, 0x61, 0xfa, 0x34, 0xa1, 0x8c, 0xef,
+	0x52, 0xd2, 0xa5, 0x31, 0x5a, 0x81, 0xca, 0x3d, 0x32, 0xa0, 0xb6, 0xd5, 0xb4, 0x36, 0xea, 0xde,
+	0x4c, 0x96, 0x3a, 0xd6, 0x35, 0x2c, 0x51, 0xe8, 0x35, 0xa8, 0x3e, 0x22, 0xfd, 0x84, 0x32, 0xbb,
+	0xd4, 0x2c, 0x17, 0x44, 0x8d, 0x74, 0xd3, 0x12, 0x5c, 0x9c, 0xd0, 0x8a, 0x10, 0x54, 0x22, 0xc2,
+	0x0f, 0x94, 0x3e, 0x2c, 0xcf, 0x68, 0x09, 0x66, 0x18, 0x27, 0x31, 0xb7, 0x4b, 0x4d, 0x6b, 0xa3,
+	0x8c, 0x15, 0x80, 0x2e, 0x40, 0x99, 0x06, 0x5d, 0xbb, 0x2c, 0x71, 0xe2, 0x28, 0x64, 0x19, 0xa7,
+	0x91, 0x5d, 0x91, 0x28, 0x79, 0x46, 0xef, 0xc3, 0x2c, 0xf7, 0x07, 0x34, 0x4c, 0xb8, 0x3d, 0xd3,
+	0xb4, 0x36, 0x1a, 0x5b, 0x2b, 0x2d, 0xe5, 0x67, 0x2b, 0xf7, 0xb3, 0x75, 0x4b, 0x47, 0xc9, 0xab,
+	0xbd, 0x48, 0x9d, 0x73, 0xdf, 0xfc, 0xee, 0x58, 0x38, 0x97, 0x11, 0x57, 0xcb, 0xb0, 0xdb, 0x55,
+	0x69, 0x8f, 0x02, 0xd0, 0x2e, 0xcc, 0x77, 0x48, 0xe7, 0xc0, 0x0f, 0x7a, 0xf7, 0x23, 0x21, 0xc9,
+	0xec, 0x59, 0xa9, 0x7b, 0xb5, 0x65, 0x64, 0xed, 0xa3, 0x11, 0x0e, 0xaf, 0x22, 0x94, 0xe3, 0x31,
+	0x39, 0x74, 0x0b, 0x66, 0x55, 0x20, 0x99, 0x5d, 0x6b, 0x96, 0x37, 0x1a, 0x5b, 0xaf, 0x9b, 0x2a,
+	0x4e, 0x08, 0x7a, 0x1e, 0xc9, 0x5c, 0x54, 0x07, 0x88, 0x33, 0xbb, 0xae, 0xac, 0x94, 0x80, 0xfb,
+	0x10, 0x6c, 0x53, 0x01, 0x8b, 0xc2, 0x80, 0xd1, 0x7f, 0x9c, 0xb6, 0xdf, 0x4a, 0x80, 0x26, 0xd5,
+	0x22, 0x17, 0xaa, 0xfb, 0x9c, 0xf0, 0x84, 0x69, 0x95, 0x90, 0xa5, 0x4e, 0x95, 0x49, 0x0c, 0xd6,
+	0x14, 0xf4, 0x31, 0x54, 0x6e, 0x11, 0x4e, 0xec, 0xd2, 0x64, 0xb0, 0x0a, 0x8d, 0x82, 0xc3, 0xbb,
+	0x24, 0x82, 0x95, 0xa5, 0xce, 0x7c, 0x97, 0x70, 0x72, 0x35, 0x1c, 0xf8, 0x9c, 0x0e, 0x22, 0x7e,
+	0x84, 0xa5, 0x3c, 0xba, 0x0e, 0xf5, 0x9d, 0x38, 0x0e, 0xe3, 0x87, 0x47, 0x11, 0x95, 0xf9, 0xaf,
+	0x7b, 0xcb, 0x59, 0xea, 0x2c, 0xd2, 0x1c, 0x69, 0x48, 0x14, 0x9c, 0xe8, 0x2d, 0x98, 0x91, 0x80,
+	0xac, 0x8f, 0xba, 0xb7, 0x98, 0xa5, 0xce, 0x82, 0x14, 0x31, 0xd8, 0x15, 0x07, 0xda, 0x29, 0xd2,
+	0x32, 0x23, 0xd3, 0xf2, 0xc6, 0x49, 0x69, 0x31, 0xa3, 0x3a, 0x91, 0x97, 0x2d, 0xa8, 0x7d, 0x46,
+	0xe2, 0xc0, 0x0f, 0x7a, 0xcc, 0xae, 0xca, 0x60, 0x5e, 0xca, 0x52, 0x07, 0x3d, 0xd7, 0x38, 0xe3,
+	0xde, 0x21, 0x9f, 0xfb, 0x75, 0x09, 0xe6, 0x47, 0xa3, 0x81, 0x5a, 0x00, 0x98, 0xb2, 0xa4, 0xcf,
+	0xa5, 0xc3, 0x2a, 0xbe, 0xf3, 0x59, 0xea, 0x40, 0x3c, 0xc4, 0x62, 0x83, 0x03, 0x7d, 0x08, 0x55,
+	0x05, 0xc9, 0x0c, 0x36, 0xb6, 0x6c, 0xd3, 0xf8, 0x7d, 0x32, 0x88, 0xfa, 0x74, 0x9f, 0xc7, 0x94,
+	0x0c, 0xbc, 0x79, 0x1d, 0xe7, 0xaa, 0xd2, 0x84, 0xb5, 0x1c, 0xba, 0x97, 0x17, 0x54, 0xb9, 0x69,
+	0x4d, 0x2b, 0x4a, 0xe5, 0xbd, 0x48, 0x2f, 0x53, 0xf1, 0x94, 0x52, 0x66, 0x3c, 0x25, 0x02, 0xdd,
+	0x84, 0x1a, 0x09, 0x48, 0xff, 0x88, 0xf9, 0x4c, 0x46, 0xbf, 0xb1, 0xb5, 0x64, 0xaa, 0xdc, 0xd6,
+	0x34, 0x6f, 0x2e, 0x4b, 0x9d, 0x21, 0x27, 0x1e, 0x9e, 0xdc, 0xbf, 0x4a, 0xb0, 0x5e, 0xdc, 0x7b,
+	0x3b, 0x60, 0x9c, 0x04, 0xfc, 0x81, 0x50, 0x70, 0xa6, 0x02, 0xc4, 0x23, 0x05, 0xf8, 0xe6, 0xf1,
+	0x5e, 0x99, 0xda, 0xff, 0xef, 0xc5, 0xf8, 0x43, 0x09, 0x56, 0x4f, 0x8e, 0xcc, 0x99, 0x0b, 0x73,
+	0xcf, 0x28, 0x4c, 0x91, 0x81, 0x8d, 0xd3, 0x33, 0xa0, 0xf8, 0xff, 0x33, 0x85, 0xfa, 0xa7, 0x05,
+	0x6b, 0xd3, 0x1c, 0x41, 0x57, 0xa0, 0xca, 0x3a, 0xa4, 0x4f, 0x62, 0x19, 0xae, 0xc6, 0xd6, 0x85,
+	0x56, 0xfe, 0x6b, 0xac, 0x5f, 0xe6, 0xee, 0x39, 0xac, 0x39, 0xd0, 0x4d, 0x98, 0x63, 0x3c, 0xf6,
+	0x83, 0x9e, 0xa2, 0xe8, 0xa0, 0x8d, 0xbe, 0x66, 0x83, 0xbe, 0x7b, 0x0e, 0x8f, 0xf0, 0xa3, 0xab,
+	0x50, 0x7d, 0x46, 0x3b, 0x3c, 0x8c, 0x75, 0x74, 0x90, 0x29, 0xf9, 0x48, 0x52, 0xc4, 0x6d, 0x8a,
+	0x47, 0x70, 0x0f, 0x08, 0x8f, 0xfd, 0x43, 0xbb, 0x32, 0xc9, 0x7d, 0x57, 0x52, 0x04, 0xb7, 0xe2,
+	0xf1, 0x6a, 0xa0, 0x53, 0xe1, 0xbe, 0x07, 0xd5, 0x47, 0xb9, 0x86, 0x59, 0x26, 0x6f, 0x16, 0x6f,
+	0xb0, 0x3c, 0xae, 0x42, 0x19, 0x85, 0x73, 0x16, 0x77, 0x17, 0xaa, 0x4a, 0x2b, 0xba, 0x09, 0xe7,
+	0x99, 0xd1, 0x95, 0x72, 0xe9, 0x13, 0xdb, 0x16, 0x1e, 0x65, 0x77, 0xfb, 0xb0, 0x7c, 0x42, 0xae,
+	0xd1, 0x03, 0xd3, 0x24, 0xe1, 0xd5, 0x95, 0x53, 0x2a, 0x44, 0x31, 0xab, 0x42, 0x69, 0x64, 0xa9,
+	0x93, 0x8b, 0x17, 0x76, 0xff, 0x38, 0xd2, 0x8b, 0x8e, 0x13, 0x44, 0xf7, 0xe1, 0x15, 0x1e, 0x72,
+	0xd2, 0x97, 0x89, 0x27, 0xed, 0x3e, 0xdd, 0x37, 0x6c, 0x28, 0x7b, 0x2b, 0x59, 0xea, 0x1c, 0xcf,
+	0x80, 0x8f, 0x47, 0xa3, 0x6f, 0x2d, 0x58, 0x3b, 0x96, 0xb2, 0x47, 0xe3, 0x7d, 0x31, 0xf2, 0xa8,
+	0x46, 0x7f, 0x63, 0xba, 0x73, 0xe3, 0xc2, 0xd2, 0x58, 0xad, 0xc1, 0x6b, 0x66, 0xa9, 0x33, 0xf5,
+	0x0e, 0x3c, 0x95, 0x8a, 0xde, 0x81, 0x46, 0x44, 0xc9, 0x93, 0xdc, 0x53, 0x51, 0x71, 0x33, 0xde,
+	0x42, 0x96, 0x3a, 0x26, 0x1a, 0x9b, 0x80, 0xeb, 0xc3, 0x19, 0x8d, 0x14, 0x83, 0xce, 0x33, 0x31,
+	0x86, 0xa8, 0x40, 0x62, 0x05, 0xa0, 0xcb, 0x30, 0x27, 0xe6, 0x35, 0xc6, 0xc9, 0x20, 0x7a, 0x3c,
+	0x60, 0x7a, 0x4c, 0x6c, 0x0c, 0x71, 0x77, 0x99, 0xfb, 0x5d, 0x09, 0xe6, 0xcc, 0x12, 0x42, 0x5f,
+	0x59, 0x50, 0xed, 0x93, 0x36, 0xed, 0xe7, 0xd5, 0xb6, 0x58, 0x3c, 0xc4, 0x3b, 0x02, 0xbf, 0x47,
+	0xfc, 0xd8, 0xdb, 0x17, 0x6d, 0xe7, 0x97, 0xd4, 0xd9, 0xee, 0xf9, 0xfc, 0x20, 0x69, 0xb7, 0x3a,
+	0xe1, 0x60, 0x93, 0x1f, 0x90, 0x20, 0x64, 0xd7, 0xfc, 0x50, 0x9f, 0x36, 0xfd, 0x80, 0xd3, 0x38,
+	0x20, 0xfd, 0xcd, 0xb1, 0xf1, 0x5a, 0xe9, 0xd9, 0xee, 0x92, 0x88, 0xd3, 0x58, 0xf4, 0xae, 0x01,
+	0xe5, 0xb1, 0xdf, 0xc1, 0xfa, 0x5e, 0x74, 0xa3, 0xa8, 0x4d, 0x95, 0xbe, 0x89, 0x5e, 0x50, 0xb4,
+	0x3d, 0xe9, 0x68, 0x51, 0x84, 0x08, 0x03, 0x1c, 0xf8, 0x8c, 0x87, 0xbd, 0x58, 0xbc, 0x97, 0xb2,
+	0x14, 0x77, 0x26, 0xdf, 0xcb, 0x6e, 0xce, 0x23, 0xbd, 0xb9, 0xa8, 0xb5, 0xd5, 0x87, 0xa2, 0xd8,
+	0xd0, 0xe2, 0x7e, 0x5f, 0x82, 0xaa, 0xee, 0x1c, 0xff, 0x82, 0xe8, 0xbc, 0x0d, 0x0d, 0xe5, 0xac,
+	0x9c, 0x3b, 0x65, 0x4e, 0x2d, 0xaf, 0x9e, 0xa5, 0x8e, 0x4a, 0x3a, 0x36, 0xa9, 0x68, 0x0d, 0xea,
+	0xc3, 0x6c, 0xeb, 0x8d, 0xa0, 0x40, 0xa0, 0x3b, 0x50, 0x78, 0xac, 0x9b, 0xdb, 0xab, 0x53, 0x62,
+	0x25, 0xe3, 0x64, 0x8d, 0xc6, 0xa9, 0x38, 0xba, 0x9f, 0xc0, 0x9c, 0xd9, 0x75, 0x47, 0x6b, 0xb2,
+	0x7e, 0x86, 0x9a, 0xe4, 0xb0, 0x78, 0x4c, 0x96, 0x46, 0x7d, 0xb1, 0xc6, 0x7d, 0xf9, 0xc0, 0xf4,
+	0xa5, 0x74, 0xba, 0x2f, 0x6a, 0xed, 0x30, 0xcc, 0x8f, 0x60, 0x61, 0x8c, 0x47, 0x78, 0xd0, 0x09,
+	0x93, 0x80, 0xcb, 0xdb, 0x2c, 0xac, 0x00, 0xb1, 0x5f, 0xb1, 0x44, 0xdd, 0x61, 0x61, 0x71, 0x44,
+	0xd7, 0x61, 0xb6, 0x9d, 0x74, 0x9e, 0x50, 0x9e, 0x57, 0xdc, 0xc8, 0xcd, 0xc5, 0x9d, 0x92, 0x07,
+	0xe7, 0xbc, 0x2e, 0x83, 0x85, 0x31, 0x1a, 0x5a, 0x07, 0x68, 0x87, 0x49, 0xd0, 0x25, 0xb1, 0xaf,
+	0xbb, 0xe2, 0x0c, 0x36, 0x30, 0xc2, 0xa2, 0x7e, 0xf8, 0x9c, 0xc6, 0xfa, 0x76, 0x05, 0x08, 0x6c,
+	0x12, 0x45, 0x54, 0xfd, 0x9c, 0x59, 0x58, 0x01, 0x85, 0xf5, 0x15, 0xc3, 0x7a, 0xf7, 0x4b, 0x98,
+	0x17, 0x0b, 0x18, 0xed, 0x0e, 0x07, 0xc4, 0x15, 0x28, 0x3f, 0xa1, 0x47, 0x7a, 0x4a, 0x99, 0xcd,
+	0x52, 0x47, 0x80, 0x58, 0x7c, 0x88, 0x25, 0x91, 0x1e, 0x72, 0x1a, 0xf0, 0xfc, 0x25, 0x8e, 0xfc,
+	0x70, 0xed, 0x48, 0x92, 0xb7, 0xa0, 0x5f, 0x4f, 0xce, 0x8a, 0xf3, 0x83, 0xfb, 0xab, 0x05, 0x55,
+	0xc5, 0x84, 0x9c, 0x7c, 0x55, 0x55, 0x9d, 0x5e, 0xd6, 0xab, 0x44, 0xe4, 0x5b, 0xeb, 0x8a, 0xda,
+	0x5a, 0x65, 0x39, 0x28, 0x2b, 0x68, 0xd0, 0x55, 0xeb, 0x6b, 0x13, 0x6a, 0x3c, 0x26, 0x1d, 0xfa,
+	0xd8, 0xef, 0xea, 0xa9, 0x30, 0x1f, 0xe1, 0x24, 0xfa, 0x76, 0x57, 0x4c, 0x27, 0xb1, 0x76, 0x47,
+	0x6f, 0xb3, 0x4b, 0x13, 0xdb, 0xec, 0x76, 0x70, 0xa4, 0xa6, 0x93, 0x9c, 0x13, 0x0f, 0x4f, 0xe8,
+	0x1a, 0x00, 0x3d, 0x8c, 0xfc, 0x98, 0xb2, 0xc7, 0x84, 0xcb, 0x95, 0xb6, 0xac, 0xe6, 0xb5, 0x02,
+	0x8b, 0xeb, 0xfa, 0xbc, 0xcd, 0x3f, 0xad, 0xd4, 0xca, 0x17, 0x2a, 0xee, 0x55, 0x15, 0x49, 0x63,
+	0x69, 0x5d, 0x85, 0x5a, 0xd7, 0x67, 0xa2, 0x47, 0x77, 0xa5, 0x9f, 0x35, 0x3c, 0x84, 0xdd, 0x00,
+	0x1a, 0x3b, 0x87, 0x51, 0x9f, 0x04, 0x72, 0xa5, 0x46, 0x6b, 0x50, 0x09, 0x8a, 0x3d, 0xb3, 0x96,
+	0xa5, 0x8e, 0x84, 0xb1, 0xfc, 0x44, 0xdb, 0x50, 0xeb, 0x1c, 0xf8, 0xfd, 0x6e, 0x4c, 0x03, 0x1d,
+	0xf8, 0xe5, 0xd1, 0xc0, 0x0f, 0x15, 0x29, 0x97, 0x72, 0x66, 0x3c, 0x3c, 0xb9, 0x3f, 0x59, 0x50,
+	0xcb, 0xa7, 0xb2, 0x53, 0x6e, 0x6b, 0xc3, 0x79, 0x7a, 0x48, 0x3b, 0x89, 0xd0, 0xf7, 0xd0, 0x1f,
+	0xe4, 0xf3, 0xd4, 0x94, 0x3f, 0x04, 0x2e, 0xeb, 0x06, 0x57, 0xcb, 0x31, 0x59, 0xea, 0x8c, 0xea,
+	0xc0, 0xa3, 0xa0, 0xc8, 0xd0, 0xd0, 0x23, 0xf5, 0x46, 0xa6, 0xcc, 0x8f, 0x93, 0xee, 0x78, 0xf6,
+	0x8b, 0x97, 0xeb, 0xd6, 0xcf, 0x2f, 0xd7, 0xad, 0x3f, 0x5e, 0xae, 0x5b, 0x9f, 0x1b, 0x7f, 0xf9,
+	0xb4, 0xab, 0xd2, 0xbc, 0x77, 0xff, 0x1e, 0x00, 0x9c, 0xf7, 0xaf, 0xca, 0x33, 0x12, 0x00, 0x00,
 }
 
 func (m *PrometheusRequestHeader) Marshal() (dAtA []byte, err error) {
@@ -2935,6 +2944,11 @@ func (m *Extent) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.ExpiresAt != 0 {
+		i = encodeVarintQueryrange(dAtA, i, uint64(m.ExpiresAt))
+		i--
+		dAtA[i] = 0x30
+	}
 	if m.Response != nil {
 		{
 			size, err := m.Response.MarshalToSizedBuffer(dAtA[:i])
@@ -3681,6 +3695,9 @@ func (m *Extent) Size() (n int) {
 		l = m.Response.Size()
 		n += 1 + l + sovQueryrange(uint64(l))
 	}
+	if m.ExpiresAt != 0 {
+		n += 1 + sovQueryrange(uint64(m.ExpiresAt))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -6788,6 +6805,25 @@ func (m *Extent) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExpiresAt", wireType)
+			}
+			m.ExpiresAt = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ExpiresAt |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipQueryrange(dAtA[iNdEx:])
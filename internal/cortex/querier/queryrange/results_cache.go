@@ -54,6 +54,9 @@ type ResultsCacheConfig struct {
 	CacheConfig                cache.Config `yaml:"cache"`
 	Compression                string       `yaml:"compression"`
 	CacheQueryableSamplesStats bool         `yaml:"cache_queryable_samples_stats"`
+	// PartialResponseTTL is how long the responses marked as not cacheable, like partial responses, are cached
+	// before being fetched again. 0 disables their caching.
+	PartialResponseTTL time.Duration `yaml:"partial_response_ttl"`
 }
 
 // RegisterFlags registers flags.
@@ -62,6 +65,7 @@ func (cfg *ResultsCacheConfig) RegisterFlags(f *flag.FlagSet) {
 
 	f.StringVar(&cfg.Compression, "frontend.compression", "", "Use compression in results cache. Supported values are: 'snappy' and '' (disable compression).")
 	f.BoolVar(&cfg.CacheQueryableSamplesStats, "frontend.cache-queryable-samples-stats", false, "Cache Statistics queryable samples on results cache.")
+	f.DurationVar(&cfg.PartialResponseTTL, "frontend.partial-response-ttl", 0, "How long partial responses are cached before being fetched again. 0 disables their caching.")
 }
 
 func (cfg *ResultsCacheConfig) Validate(qCfg querier.Config) error {
@@ -76,6 +80,10 @@ func (cfg *ResultsCacheConfig) Validate(qCfg querier.Config) error {
 		return errors.New("frontend.cache-queryable-samples-stats may only be enabled in conjunction with querier.per-step-stats-enabled. Please set the latter")
 	}
 
+	if cfg.PartialResponseTTL < 0 {
+		return errors.New("frontend.partial-response-ttl cannot be negative")
+	}
+
 	return cfg.CacheConfig.Validate()
 }
 
@@ -170,6 +178,7 @@ type resultsCache struct {
 	cacheGenNumberLoader       CacheGenNumberLoader
 	shouldCache                ShouldCacheFn
 	cacheQueryableSamplesStats bool
+	partialResponseTTL         time.Duration
 }
 
 // NewResultsCacheMiddleware creates results cache middleware from config.
@@ -215,6 +224,7 @@ func NewResultsCacheMiddleware(
 			cacheGenNumberLoader:       cacheGenNumberLoader,
 			shouldCache:                shouldCache,
 			cacheQueryableSamplesStats: cfg.CacheQueryableSamplesStats,
+			partialResponseTTL:         cfg.PartialResponseTTL,
 		}
 	}), c, nil
 }
@@ -275,13 +285,11 @@ func (s resultsCache) Do(ctx context.Context, r Request) (Response, error) {
 }
 
 // shouldCacheResponse says whether the response should be cached or not.
+// Partial responses are cached only if partialResponseTTL is set, see isPartialResponse.
 func (s resultsCache) shouldCacheResponse(ctx context.Context, req Request, r Response, maxCacheTime int64) bool {
-	headerValues := getHeaderValuesWithName(r, cacheControlHeader)
-	for _, v := range headerValues {
-		if v == noStoreValue {
-			level.Debug(s.logger).Log("msg", fmt.Sprintf("%s header in response is equal to %s, not caching the response", cacheControlHeader, noStoreValue))
-			return false
-		}
+	if isPartialResponse(r) && s.partialResponseTTL == 0 {
+		level.Debug(s.logger).Log("msg", fmt.Sprintf("%s header in response is equal to %s, not caching the response", cacheControlHeader, noStoreValue))
+		return false
 	}
 
 	if !s.isAtModifierCachable(req, maxCacheTime) {
@@ -316,6 +324,12 @@ func (s resultsCache) shouldCacheResponse(ctx context.Context, req Request, r Re
 // isAtModifierCachable returns true if the @ modifier result
 // is safe to cache.
 func (s resultsCache) isAtModifierCachable(r Request, maxCacheTime int64) bool {
+	return IsAtModifierCachable(s.logger, r.GetQuery(), r.GetStart(), r.GetEnd(), maxCacheTime)
+}
+
+// IsAtModifierCachable returns true if the result of the query evaluated
+// between start and end is safe to cache with regard to its @ modifiers.
+func IsAtModifierCachable(logger log.Logger, query string, start, end, maxCacheTime int64) bool {
 	var errAtModifierAfterEnd = errors.New("at modifier after end")
 	// There are 2 cases when @ modifier is not safe to cache:
 	//   1. When @ modifier points to time beyond the maxCacheTime.
@@ -323,21 +337,19 @@ func (s resultsCache) isAtModifierCachable(r Request, maxCacheTime int64) bool {
 	//      below maxCacheTime. In such cases if any tenant is intentionally
 	//      playing with old data, we could cache empty result if we look
 	//      beyond query end.
-	query := r.GetQuery()
 	if !strings.Contains(query, "@") {
 		return true
 	}
 	expr, err := extpromql.ParseExpr(query)
 	if err != nil {
 		// We are being pessimistic in such cases.
-		level.Warn(s.logger).Log("msg", "failed to parse query, considering @ modifier as not cachable", "query", query, "err", err)
+		level.Warn(logger).Log("msg", "failed to parse query, considering @ modifier as not cachable", "query", query, "err", err)
 		return false
 	}
 
 	// This resolves the start() and end() used with the @ modifier.
-	expr = promql.PreprocessExpr(expr, timestamp.Time(r.GetStart()), timestamp.Time(r.GetEnd()))
+	expr = promql.PreprocessExpr(expr, timestamp.Time(start), timestamp.Time(end))
 
-	end := r.GetEnd()
 	atModCachable := true
 	parser.Inspect(expr, func(n parser.Node, _ []parser.Node) error {
 		switch e := n.(type) {
@@ -367,14 +379,19 @@ func (s resultsCache) isAtModifierCachable(r Request, maxCacheTime int64) bool {
 // isOffsetCachable returns true if the offset is positive, result is safe to cache.
 // and false when offset is negative, result is not cached.
 func (s resultsCache) isOffsetCachable(r Request) bool {
+	return IsOffsetCachable(s.logger, r.GetQuery())
+}
+
+// IsOffsetCachable returns true if the result of the query is safe to cache
+// with regard to its offsets.
+func IsOffsetCachable(logger log.Logger, query string) bool {
 	var errNegativeOffset = errors.New("negative offset")
-	query := r.GetQuery()
 	if !strings.Contains(query, "offset") {
 		return true
 	}
 	expr, err := extpromql.ParseExpr(query)
 	if err != nil {
-		level.Warn(s.logger).Log("msg", "failed to parse query, considering offset as not cachable", "query", query, "err", err)
+		level.Warn(logger).Log("msg", "failed to parse query, considering offset as not cachable", "query", query, "err", err)
 		return false
 	}
 
@@ -404,6 +421,17 @@ func (s resultsCache) isOffsetCachable(r Request) bool {
 	return offsetCachable
 }
 
+// isPartialResponse returns true if the downstream marked the response as not cacheable, which it does for partial
+// responses and responses with warnings.
+func isPartialResponse(r Response) bool {
+	for _, v := range getHeaderValuesWithName(r, cacheControlHeader) {
+		if v == noStoreValue {
+			return true
+		}
+	}
+	return false
+}
+
 // getHeaderValuesWithName 返回响应中指定的 header 值.
 func getHeaderValuesWithName(r Response, headerName string) (headerValues []string) {
 	for _, hv := range r.GetHeaders() {
@@ -427,7 +455,7 @@ func (s resultsCache) handleMiss(ctx context.Context, r Request, maxCacheTime in
 		return response, []Extent{}, nil
 	}
 
-	extent, err := toExtent(ctx, r, s.extractor.ResponseWithoutHeaders(response))
+	extent, err := s.toExtent(ctx, r, response)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -461,12 +489,15 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 		return nil, nil, err
 	}
 
+	// The expired extents were fetched again, drop them from the cache entry.
+	extents = removeExpiredExtents(extents, time.Now().UnixMilli())
+
 	for _, reqResp := range reqResps {
 		responses = append(responses, reqResp.Response)
 		if !s.shouldCacheResponse(ctx, r, reqResp.Response, maxCacheTime) {
 			continue
 		}
-		extent, err := toExtent(ctx, reqResp.Request, s.extractor.ResponseWithoutHeaders(reqResp.Response))
+		extent, err := s.toExtent(ctx, reqResp.Request, reqResp.Response)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -491,7 +522,8 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 	mergedExtents := make([]Extent, 0, len(extents))
 
 	for i := 1; i < len(extents); i++ {
-		if accumulator.End+r.GetStep() < extents[i].Start {
+		// Partial extents expire on their own, they are not merged with the other extents.
+		if accumulator.End+r.GetStep() < extents[i].Start || accumulator.ExpiresAt != 0 || extents[i].ExpiresAt != 0 {
 			mergedExtents, err = merge(mergedExtents, accumulator)
 			if err != nil {
 				return nil, nil, err
@@ -540,10 +572,11 @@ func merge(extents []Extent, acc *accumulator) ([]Extent, error) {
 		return nil, err
 	}
 	return append(extents, Extent{
-		Start:    acc.Extent.Start,
-		End:      acc.Extent.End,
-		Response: any,
-		TraceId:  acc.Extent.TraceId,
+		Start:     acc.Extent.Start,
+		End:       acc.Extent.End,
+		Response:  any,
+		TraceId:   acc.Extent.TraceId,
+		ExpiresAt: acc.Extent.ExpiresAt,
 	}), nil
 }
 
@@ -558,6 +591,28 @@ func newAccumulator(base Extent) (*accumulator, error) {
 	}, nil
 }
 
+// toExtent returns the extent caching the response, which expires after partialResponseTTL if it is partial.
+func (s resultsCache) toExtent(ctx context.Context, req Request, res Response) (Extent, error) {
+	extent, err := toExtent(ctx, req, s.extractor.ResponseWithoutHeaders(res))
+	if err != nil {
+		return Extent{}, err
+	}
+	if isPartialResponse(res) {
+		extent.ExpiresAt = time.Now().Add(s.partialResponseTTL).UnixMilli()
+	}
+	return extent, nil
+}
+
+func removeExpiredExtents(extents []Extent, now int64) []Extent {
+	filtered := extents[:0]
+	for _, e := range extents {
+		if e.ExpiresAt == 0 || e.ExpiresAt > now {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
 func toExtent(ctx context.Context, req Request, res Response) (Extent, error) {
 	any, err := types.MarshalAny(res)
 	if err != nil {
@@ -577,6 +632,7 @@ func (s resultsCache) partition(req Request, extents []Extent) ([]Request, []Res
 	var requests []Request
 	var cachedResponses []Response
 	start := req.GetStart()
+	now := time.Now().UnixMilli()
 
 	for _, extent := range extents {
 		// If there is no overlap, ignore this extent.
@@ -584,6 +640,11 @@ func (s resultsCache) partition(req Request, extents []Extent) ([]Request, []Res
 			continue
 		}
 
+		// If this extent holds a partial response which expired, fetch it again.
+		if extent.ExpiresAt != 0 && extent.ExpiresAt <= now {
+			continue
+		}
+
 		// If this extent is tiny and request is not tiny, discard it: more efficient to do a few larger queries.
 		// Hopefully tiny request can make tiny extent into not-so-tiny extent.
 
@@ -167,6 +167,12 @@ func mkExtent(start, end int64) Extent {
 	return mkExtentWithStepWithStats(start, end, 10, false)
 }
 
+func mkPartialExtent(start, end, expiresAt int64) Extent {
+	e := mkExtent(start, end)
+	e.ExpiresAt = expiresAt
+	return e
+}
+
 func mkExtentWithStep(start, end, step int64) Extent {
 	return mkExtentWithStepWithStats(start, end, step, false)
 }
@@ -818,6 +824,39 @@ func TestPartition(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Test a hit on a partial extent which did not expire yet.",
+			input: &PrometheusRequest{
+				Start: 0,
+				End:   100,
+			},
+			prevCachedResponse: []Extent{
+				mkPartialExtent(0, 100, time.Now().Add(time.Hour).UnixMilli()),
+			},
+			expectedCachedResponse: []Response{
+				mkAPIResponse(0, 100, 10),
+			},
+		},
+		{
+			name: "Test a partial extent which expired is fetched again.",
+			input: &PrometheusRequest{
+				Start: 0,
+				End:   100,
+			},
+			prevCachedResponse: []Extent{
+				mkExtent(0, 50),
+				mkPartialExtent(50, 100, time.Now().Add(-time.Second).UnixMilli()),
+			},
+			expectedRequests: []Request{
+				&PrometheusRequest{
+					Start: 50,
+					End:   100,
+				},
+			},
+			expectedCachedResponse: []Response{
+				mkAPIResponse(0, 50, 10),
+			},
+		},
 		{
 			name: "[stats] Test when hit has a large step and only a single sample extent.",
 			// If there is a only a single sample in the split interval, start and end will be the same.
@@ -1100,6 +1139,73 @@ func TestResultsCache(t *testing.T) {
 	require.Equal(t, 2, calls)
 }
 
+func TestResultsCachePartialResponse(t *testing.T) {
+	cfg := ResultsCacheConfig{
+		CacheConfig: cache.Config{
+			Cache: cache.NewMockCache(),
+		},
+		PartialResponseTTL: time.Hour,
+	}
+	rcm, _, err := NewResultsCacheMiddleware(
+		log.NewNopLogger(),
+		cfg,
+		constSplitter(day),
+		mockLimits{},
+		PrometheusCodec,
+		PrometheusResponseExtractor{},
+		nil,
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+
+	var (
+		calls   int
+		partial = true
+	)
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+		calls++
+		resp := mkAPIResponse(req.GetStart(), req.GetEnd(), req.GetStep())
+		if partial {
+			resp.Headers = []*PrometheusResponseHeader{{Name: cacheControlHeader, Values: []string{noStoreValue}}}
+		}
+		return resp, nil
+	}))
+	ctx := user.InjectOrgID(context.Background(), "1")
+	req := &PrometheusRequest{Start: 0, End: 100, Step: 10, Query: "up"}
+	key := constSplitter(day).GenerateCacheKey("1", req)
+
+	// The partial response is cached until it expires.
+	_, err = rc.Do(ctx, req)
+	require.NoError(t, err)
+	_, err = rc.Do(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+
+	extents, ok := rc.(*resultsCache).get(ctx, key)
+	require.True(t, ok)
+	require.Len(t, extents, 1)
+	require.Greater(t, extents[0].ExpiresAt, time.Now().UnixMilli())
+
+	// Once expired, it is fetched again and replaced by the complete response.
+	extents[0].ExpiresAt = time.Now().Add(-time.Second).UnixMilli()
+	rc.(*resultsCache).put(ctx, key, extents)
+	partial = false
+
+	_, err = rc.Do(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+
+	extents, ok = rc.(*resultsCache).get(ctx, key)
+	require.True(t, ok)
+	require.Len(t, extents, 1)
+	require.Equal(t, int64(0), extents[0].ExpiresAt)
+
+	_, err = rc.Do(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+}
+
 func TestResultsCacheRecent(t *testing.T) {
 	var cfg ResultsCacheConfig
 	flagext.DefaultValues(&cfg)
@@ -5,6 +5,7 @@ package stats
 
 import (
 	"context"
+	"sync"
 	"sync/atomic" //lint:ignore faillint we can't use go.uber.org/atomic with a protobuf struct without wrapping it.
 	"time"
 
@@ -15,6 +16,9 @@ type contextKey int
 
 var ctxKey = contextKey(0)
 
+// notShardableReasonMtx guards the NotShardableReason of all the stats, strings cannot be set atomically.
+var notShardableReasonMtx sync.Mutex
+
 // ContextWithEmptyStats returns a context with empty stats.
 func ContextWithEmptyStats(ctx context.Context) (*Stats, context.Context) {
 	stats := &Stats{}
@@ -121,6 +125,28 @@ func (s *Stats) LoadTotalSamples() int64 {
 	return atomic.LoadInt64(&s.TotalLoadedSamples)
 }
 
+// SetNotShardableReason sets the reason the query could not be sharded.
+func (s *Stats) SetNotShardableReason(reason string) {
+	if s == nil {
+		return
+	}
+
+	notShardableReasonMtx.Lock()
+	defer notShardableReasonMtx.Unlock()
+	s.NotShardableReason = reason
+}
+
+// LoadNotShardableReason returns the reason the query could not be sharded, if any.
+func (s *Stats) LoadNotShardableReason() string {
+	if s == nil {
+		return ""
+	}
+
+	notShardableReasonMtx.Lock()
+	defer notShardableReasonMtx.Unlock()
+	return s.NotShardableReason
+}
+
 // Merge the provide Stats into this one.
 func (s *Stats) Merge(other *Stats) {
 	if s == nil || other == nil {
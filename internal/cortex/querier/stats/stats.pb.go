@@ -38,7 +38,9 @@ type Stats struct {
 	// The maximum number of samples loaded in a single execution window.
 	PeakLoadedSamples int32 `protobuf:"varint,4,opt,name=peak_loaded_samples,json=peakLoadedSamples,proto3" json:"peak_loaded_samples,omitempty"`
 	// The total number of samples loaded for the query
-	TotalLoadedSamples   int64    `protobuf:"varint,5,opt,name=total_loaded_samples,json=totalLoadedSamples,proto3" json:"total_loaded_samples,omitempty"`
+	TotalLoadedSamples int64 `protobuf:"varint,5,opt,name=total_loaded_samples,json=totalLoadedSamples,proto3" json:"total_loaded_samples,omitempty"`
+	// The reason the query could not be sharded, if vertical sharding is enabled.
+	NotShardableReason   string   `protobuf:"bytes,6,opt,name=not_shardable_reason,json=notShardableReason,proto3" json:"not_shardable_reason,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -112,6 +114,13 @@ func (m *Stats) GetTotalLoadedSamples() int64 {
 	return 0
 }
 
+func (m *Stats) GetNotShardableReason() string {
+	if m != nil {
+		return m.NotShardableReason
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Stats)(nil), "stats.Stats")
 }
@@ -119,25 +128,27 @@ func init() {
 func init() { proto.RegisterFile("cortex/querier/stats/stats.proto", fileDescriptor_993e99dbe6209dce) }
 
 var fileDescriptor_993e99dbe6209dce = []byte{
-	// 288 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x5c, 0xd0, 0x31, 0x52, 0xb3, 0x40,
-	0x14, 0xc0, 0xf1, 0x6f, 0x93, 0xf0, 0x4d, 0xc4, 0x2a, 0x9b, 0x14, 0x98, 0x82, 0x30, 0x56, 0x54,
-	0xe0, 0xe8, 0x05, 0x1c, 0x62, 0x69, 0x05, 0x56, 0x36, 0xcc, 0x02, 0x1b, 0xc2, 0x64, 0x61, 0x91,
-	0x7d, 0x8c, 0x7a, 0x08, 0x7b, 0x8f, 0x94, 0xd2, 0x13, 0xa8, 0xc3, 0x49, 0x9c, 0xb7, 0x4b, 0x0a,
-	0x6d, 0x18, 0x1e, 0x3f, 0xfe, 0xc3, 0x63, 0x6d, 0x2f, 0x97, 0x1d, 0xf0, 0x97, 0xf0, 0xa9, 0xe7,
-	0x5d, 0xc5, 0xbb, 0x50, 0x01, 0x03, 0x65, 0xae, 0x41, 0xdb, 0x49, 0x90, 0xd4, 0xd2, 0xc3, 0x7a,
-	0x55, 0xca, 0x52, 0xea, 0x27, 0x21, 0xde, 0x19, 0x5c, 0xbb, 0xa5, 0x94, 0xa5, 0xe0, 0xa1, 0x9e,
-	0xb2, 0x7e, 0x17, 0x16, 0x7d, 0xc7, 0xa0, 0x92, 0x8d, 0xf1, 0xcb, 0xb7, 0x89, 0x6d, 0x25, 0xd8,
-	0xd3, 0x5b, 0xfb, 0xec, 0x99, 0x09, 0x91, 0x42, 0x55, 0x73, 0x87, 0x78, 0xc4, 0x3f, 0xbf, 0xbe,
-	0x08, 0x4c, 0x1d, 0x9c, 0xea, 0xe0, 0x6e, 0xac, 0xa3, 0xf9, 0xf1, 0x73, 0xf3, 0xef, 0xfd, 0x6b,
-	0x43, 0xe2, 0x39, 0x56, 0x0f, 0x55, 0xcd, 0xe9, 0x95, 0xbd, 0xda, 0x71, 0xc8, 0xf7, 0xbc, 0x48,
-	0x15, 0x2e, 0xab, 0xd2, 0x5c, 0xf6, 0x0d, 0x38, 0x13, 0x8f, 0xf8, 0xb3, 0x98, 0x8e, 0x96, 0x68,
-	0xda, 0xa2, 0xd0, 0xc0, 0x5e, 0x9e, 0x8a, 0x7c, 0xdf, 0x37, 0x87, 0x34, 0x7b, 0x05, 0xae, 0x9c,
-	0xa9, 0x0e, 0x16, 0x23, 0x6d, 0x51, 0x22, 0x04, 0x7c, 0xbf, 0xe5, 0xec, 0x90, 0x0a, 0xc9, 0x0a,
-	0xfc, 0x0a, 0xab, 0x5b, 0xc1, 0x95, 0x33, 0xf3, 0x88, 0x6f, 0xc5, 0x0b, 0xa4, 0x7b, 0x2d, 0x89,
-	0x01, 0xdc, 0x08, 0x24, 0x30, 0xf1, 0x37, 0xb0, 0x3c, 0xe2, 0x4f, 0x63, 0xaa, 0xed, 0x57, 0x11,
-	0x2d, 0x8f, 0x83, 0x4b, 0x3e, 0x06, 0x97, 0x7c, 0x0f, 0x2e, 0x79, 0x34, 0x47, 0x9b, 0xfd, 0xd7,
-	0xff, 0x7f, 0xf3, 0x13, 0x00, 0x00, 0xff, 0xff, 0x99, 0x96, 0x18, 0xaf, 0x8c, 0x01, 0x00, 0x00,
+	// 312 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x64, 0xd1, 0x31, 0x52, 0xb3, 0x40,
+	0x14, 0xc0, 0xf1, 0x6f, 0x93, 0x90, 0x49, 0xf8, 0xaa, 0x90, 0x14, 0x98, 0x82, 0x30, 0x56, 0x54,
+	0xe0, 0xe8, 0x05, 0x9c, 0xc4, 0xd2, 0x0a, 0xac, 0x6c, 0x76, 0x16, 0x78, 0x21, 0x4c, 0x36, 0xbc,
+	0xb8, 0xfb, 0x18, 0xf5, 0x26, 0xde, 0xc6, 0x36, 0xa5, 0x27, 0x50, 0x27, 0x27, 0x71, 0x76, 0x49,
+	0x1a, 0x6d, 0x18, 0x1e, 0x3f, 0xfe, 0xc3, 0x1b, 0xd6, 0x0d, 0x0b, 0x54, 0x04, 0x2f, 0xc9, 0x53,
+	0x0b, 0xaa, 0x06, 0x95, 0x68, 0x12, 0xa4, 0xbb, 0x6b, 0xbc, 0x57, 0x48, 0xe8, 0x39, 0x76, 0x98,
+	0xcf, 0x2a, 0xac, 0xd0, 0x3e, 0x49, 0xcc, 0x5d, 0x87, 0xf3, 0xa0, 0x42, 0xac, 0x24, 0x24, 0x76,
+	0xca, 0xdb, 0x75, 0x52, 0xb6, 0x4a, 0x50, 0x8d, 0x4d, 0xe7, 0x97, 0xef, 0x3d, 0xd7, 0xc9, 0x4c,
+	0xef, 0xdd, 0xba, 0xe3, 0x67, 0x21, 0x25, 0xa7, 0x7a, 0x07, 0x3e, 0x0b, 0x59, 0xf4, 0xff, 0xfa,
+	0x22, 0xee, 0xea, 0xf8, 0x5c, 0xc7, 0x77, 0xa7, 0x7a, 0x39, 0x3a, 0x7c, 0x2e, 0xfe, 0xbd, 0x7d,
+	0x2d, 0x58, 0x3a, 0x32, 0xd5, 0x43, 0xbd, 0x03, 0xef, 0xca, 0x9d, 0xad, 0x81, 0x8a, 0x0d, 0x94,
+	0x5c, 0x9b, 0x65, 0x35, 0x2f, 0xb0, 0x6d, 0xc8, 0xef, 0x85, 0x2c, 0x1a, 0xa4, 0xde, 0xc9, 0x32,
+	0x4b, 0x2b, 0x23, 0x5e, 0xec, 0x4e, 0xcf, 0x45, 0xb1, 0x69, 0x9b, 0x2d, 0xcf, 0x5f, 0x09, 0xb4,
+	0xdf, 0xb7, 0xc1, 0xe4, 0x44, 0x2b, 0x23, 0x4b, 0x03, 0xe6, 0xfd, 0x3d, 0x88, 0x2d, 0x97, 0x28,
+	0x4a, 0xf3, 0x15, 0xb1, 0xdb, 0x4b, 0xd0, 0xfe, 0x20, 0x64, 0x91, 0x93, 0x4e, 0x0c, 0xdd, 0x5b,
+	0xc9, 0x3a, 0x30, 0x1b, 0x11, 0x92, 0x90, 0xbf, 0x03, 0x27, 0x64, 0x51, 0x3f, 0xf5, 0xac, 0xfd,
+	0x29, 0x1a, 0x24, 0xae, 0x37, 0x42, 0x95, 0x22, 0x97, 0xc0, 0x15, 0x08, 0x8d, 0x8d, 0x3f, 0x0c,
+	0x59, 0x34, 0x4e, 0xbd, 0x06, 0x29, 0x3b, 0x53, 0x6a, 0x65, 0x39, 0x3d, 0x1c, 0x03, 0xf6, 0x71,
+	0x0c, 0xd8, 0xf7, 0x31, 0x60, 0x8f, 0xdd, 0x61, 0xe4, 0x43, 0xfb, 0xc7, 0x6e, 0x7e, 0x06, 0x00,
+	0x6b, 0xef, 0xf1, 0x43, 0xbe, 0x01, 0x00, 0x00,
 }
 
 func (m *Stats) Marshal() (dAtA []byte, err error) {
@@ -164,6 +175,13 @@ func (m *Stats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if len(m.NotShardableReason) > 0 {
+		i -= len(m.NotShardableReason)
+		copy(dAtA[i:], m.NotShardableReason)
+		i = encodeVarintStats(dAtA, i, uint64(len(m.NotShardableReason)))
+		i--
+		dAtA[i] = 0x32
+	}
 	if m.TotalLoadedSamples != 0 {
 		i = encodeVarintStats(dAtA, i, uint64(m.TotalLoadedSamples))
 		i--
@@ -227,6 +245,10 @@ func (m *Stats) Size() (n int) {
 	if m.TotalLoadedSamples != 0 {
 		n += 1 + sovStats(uint64(m.TotalLoadedSamples))
 	}
+	l = len(m.NotShardableReason)
+	if l > 0 {
+		n += 1 + l + sovStats(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -377,6 +399,38 @@ func (m *Stats) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NotShardableReason", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthStats
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthStats
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.NotShardableReason = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipStats(dAtA[iNdEx:])
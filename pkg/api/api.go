@@ -35,6 +35,7 @@ import (
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/common/version"
 
+	"github.com/thanos-io/thanos/pkg/audit"
 	"github.com/thanos-io/thanos/pkg/extannotations"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/logging"
@@ -158,7 +159,7 @@ func NewBaseAPI(logger log.Logger, disableCORS bool, flagsMap map[string]string)
 
 // Register registers the common API endpoints.
 func (api *BaseAPI) Register(r *route.Router, tracer opentracing.Tracer, logger log.Logger, ins extpromhttp.InstrumentationMiddleware, logMiddleware *logging.HTTPServerMiddleware) {
-	instr := GetInstr(tracer, logger, ins, logMiddleware, api.disableCORS)
+	instr := GetInstr(tracer, logger, ins, logMiddleware, nil, api.disableCORS)
 
 	r.Options("/*path", instr("options", api.options))
 
@@ -208,11 +209,13 @@ func GetRuntimeInfoFunc(logger log.Logger) RuntimeInfoFn {
 type InstrFunc func(name string, f ApiFunc) http.HandlerFunc
 
 // GetInstr returns a http HandlerFunc with the instrumentation middleware.
+// Requests are recorded in the audit log, if not nil.
 func GetInstr(
 	tracer opentracing.Tracer,
 	logger log.Logger,
 	ins extpromhttp.InstrumentationMiddleware,
 	logMiddleware *logging.HTTPServerMiddleware,
+	auditLog *audit.Log,
 	disableCORS bool,
 ) InstrFunc {
 	instr := func(name string, f ApiFunc) http.HandlerFunc {
@@ -223,6 +226,9 @@ func GetInstr(
 			if data, warnings, err, releaseResources := f(r); err != nil {
 				RespondError(w, err, data, logger)
 				releaseResources()
+			} else if streamed, ok := data.(*StreamedData); ok {
+				RespondStream(w, streamed, warnings, logger)
+				releaseResources()
 			} else if data != nil {
 				Respond(w, data, warnings, logger)
 				releaseResources()
@@ -236,7 +242,7 @@ func GetInstr(
 			tracing.HTTPMiddleware(tracer, name, logger,
 				ins.NewHandler(name,
 					gzhttp.GzipHandler(
-						logMiddleware.HTTPMiddleware(name, hf),
+						logMiddleware.HTTPMiddleware(name, auditLog.HTTPMiddleware(name, hf)),
 					),
 				),
 			),
@@ -245,6 +251,34 @@ func GetInstr(
 	return instr
 }
 
+// GetHandlerInstr returns a function instrumenting plain http handlers, for endpoints which don't
+// respond in the JSON API format.
+func GetHandlerInstr(
+	tracer opentracing.Tracer,
+	logger log.Logger,
+	ins extpromhttp.InstrumentationMiddleware,
+	logMiddleware *logging.HTTPServerMiddleware,
+	auditLog *audit.Log,
+	disableCORS bool,
+) func(name string, f http.HandlerFunc) http.HandlerFunc {
+	return func(name string, f http.HandlerFunc) http.HandlerFunc {
+		hf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !disableCORS {
+				SetCORS(w)
+			}
+			f(w, r)
+		})
+
+		return middleware.RequestID(
+			tracing.HTTPMiddleware(tracer, name, logger,
+				ins.NewHandler(name,
+					logMiddleware.HTTPMiddleware(name, auditLog.HTTPMiddleware(name, hf)),
+				),
+			),
+		)
+	}
+}
+
 func shouldNotCacheBecauseOfWarnings(warnings []error) bool {
 	for _, w := range warnings {
 		// PromQL warnings should not prevent caching
@@ -283,24 +317,24 @@ func Respond(w http.ResponseWriter, data interface{}, warnings []error, logger l
 	}
 }
 
-func RespondError(w http.ResponseWriter, apiErr *ApiError, data interface{}, logger log.Logger) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "no-store")
-
-	var code int
-	switch apiErr.Typ {
+// StatusCode returns the HTTP status code of the responses of API errors of the given type.
+func StatusCode(typ ErrorType) int {
+	switch typ {
 	case ErrorBadData:
-		code = http.StatusBadRequest
+		return http.StatusBadRequest
 	case ErrorExec:
-		code = 422
+		return 422
 	case ErrorCanceled, ErrorTimeout:
-		code = http.StatusServiceUnavailable
-	case ErrorInternal:
-		code = http.StatusInternalServerError
+		return http.StatusServiceUnavailable
 	default:
-		code = http.StatusInternalServerError
+		return http.StatusInternalServerError
 	}
-	w.WriteHeader(code)
+}
+
+func RespondError(w http.ResponseWriter, apiErr *ApiError, data interface{}, logger log.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(StatusCode(apiErr.Typ))
 
 	json := jsoniter.ConfigCompatibleWithStandardLibrary
 	b, err := json.Marshal(&response{
@@ -86,7 +86,7 @@ func NewBlocksAPI(logger log.Logger, disableCORS bool, label string, flagsMap ma
 func (bapi *BlocksAPI) Register(r *route.Router, tracer opentracing.Tracer, logger log.Logger, ins extpromhttp.InstrumentationMiddleware, logMiddleware *logging.HTTPServerMiddleware) {
 	bapi.baseAPI.Register(r, tracer, logger, ins, logMiddleware)
 
-	instr := api.GetInstr(tracer, logger, ins, logMiddleware, bapi.disableCORS)
+	instr := api.GetInstr(tracer, logger, ins, logMiddleware, nil, bapi.disableCORS)
 
 	r.Get("/blocks", instr("blocks", bapi.blocks))
 	r.Post("/blocks/mark", instr("blocks_mark", bapi.markBlock))
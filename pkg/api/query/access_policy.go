@@ -0,0 +1,186 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/exemplars"
+	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/rules"
+	"github.com/thanos-io/thanos/pkg/rules/rulespb"
+	"github.com/thanos-io/thanos/pkg/targets"
+	"github.com/thanos-io/thanos/pkg/targets/targetspb"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+// rewritePromQL gets the tenant of the request and rewrites the query according to tenancy enforcement and
+// the access policy of the tenant, if any.
+func (qapi *QueryAPI) rewritePromQL(ctx context.Context, r *http.Request, queryStr string) (string, string, context.Context, error) {
+	queryStr, tenant, ctx, err := tenancy.RewritePromQL(ctx, r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField, qapi.enforceTenancy, qapi.tenantLabel, queryStr)
+	if err != nil || qapi.accessPolicy == nil {
+		return queryStr, tenant, ctx, err
+	}
+	queryStr, err = qapi.accessPolicy.EnforceQuery(tenant, queryStr)
+	return queryStr, tenant, ctx, err
+}
+
+// rewriteLabelMatchers gets the tenant of the request and parses the given matchers, restricted according to
+// tenancy enforcement and the access policy of the tenant, if any.
+func (qapi *QueryAPI) rewriteLabelMatchers(ctx context.Context, r *http.Request, formMatchers []string) ([][]*labels.Matcher, context.Context, error) {
+	matcherSets, ctx, err := tenancy.RewriteLabelMatchers(ctx, r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField, qapi.enforceTenancy, qapi.tenantLabel, formMatchers)
+	if err != nil || qapi.accessPolicy == nil {
+		return matcherSets, ctx, err
+	}
+	matcherSets, err = qapi.accessPolicy.EnforceMatcherSets(ctx.Value(tenancy.TenantKey).(string), matcherSets)
+	return matcherSets, ctx, err
+}
+
+// enforceRemoteReadAccessPolicy restricts the matchers of a remote read query to the series the tenant can read.
+// Remote read queries select series with a single set of matchers, so tenants reading series of several
+// selectors can't use remote read.
+func enforceRemoteReadAccessPolicy(policy *tenancy.AccessPolicy, tenant string, matchers []*labels.Matcher) ([]*labels.Matcher, error) {
+	matcherSets, err := policy.EnforceMatcherSets(tenant, [][]*labels.Matcher{matchers})
+	if err != nil {
+		return nil, err
+	}
+	if len(matcherSets) != 1 {
+		return nil, errors.Errorf("access policy of tenant %q can't be enforced on remote read queries", tenant)
+	}
+	return matcherSets[0], nil
+}
+
+// withAccessPolicy adds the tenant of requests to their context, for the clients enforcing the access policy.
+func (qapi *QueryAPI) withAccessPolicy(f api.ApiFunc) api.ApiFunc {
+	if qapi.accessPolicy == nil {
+		return f
+	}
+	return func(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
+		tenant, err := tenancy.GetTenantFromHTTP(r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField)
+		if err != nil {
+			return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+		}
+		return f(r.WithContext(context.WithValue(r.Context(), tenancy.TenantKey, tenant)))
+	}
+}
+
+func tenantFromContext(ctx context.Context) (string, error) {
+	tenant, ok := ctx.Value(tenancy.TenantKey).(string)
+	if !ok {
+		return "", errors.New("no tenant in request context")
+	}
+	return tenant, nil
+}
+
+// accessPolicyRulesClient only returns the rules and alerts with labels the tenant can read.
+type accessPolicyRulesClient struct {
+	rules.UnaryClient
+	policy *tenancy.AccessPolicy
+}
+
+func (c *accessPolicyRulesClient) Rules(ctx context.Context, req *rulespb.RulesRequest) (*rulespb.RuleGroups, annotations.Annotations, error) {
+	tenant, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	groups, warnings, err := c.UnaryClient.Rules(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filtered := make([]*rulespb.RuleGroup, 0, len(groups.Groups))
+	for _, g := range groups.Groups {
+		rs := make([]*rulespb.Rule, 0, len(g.Rules))
+		for _, r := range g.Rules {
+			if !c.policy.Allows(tenant, r.GetLabels()) {
+				continue
+			}
+			if a := r.GetAlert(); a != nil {
+				alerts := make([]*rulespb.AlertInstance, 0, len(a.Alerts))
+				for _, inst := range a.Alerts {
+					if c.policy.Allows(tenant, inst.Labels.PromLabels()) {
+						alerts = append(alerts, inst)
+					}
+				}
+				a.Alerts = alerts
+			}
+			rs = append(rs, r)
+		}
+		if len(rs) > 0 {
+			g.Rules = rs
+			filtered = append(filtered, g)
+		}
+	}
+	groups.Groups = filtered
+	return groups, warnings, nil
+}
+
+// accessPolicyTargetsClient only returns the targets with labels the tenant can read.
+type accessPolicyTargetsClient struct {
+	targets.UnaryClient
+	policy *tenancy.AccessPolicy
+}
+
+func (c *accessPolicyTargetsClient) Targets(ctx context.Context, req *targetspb.TargetsRequest) (*targetspb.TargetDiscovery, annotations.Annotations, error) {
+	tenant, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, warnings, err := c.UnaryClient.Targets(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	active := make([]*targetspb.ActiveTarget, 0, len(t.ActiveTargets))
+	for _, at := range t.ActiveTargets {
+		if c.policy.Allows(tenant, at.Labels.PromLabels()) {
+			active = append(active, at)
+		}
+	}
+	dropped := make([]*targetspb.DroppedTarget, 0, len(t.DroppedTargets))
+	for _, dt := range t.DroppedTargets {
+		if c.policy.Allows(tenant, dt.DiscoveredLabels.PromLabels()) {
+			dropped = append(dropped, dt)
+		}
+	}
+	t.ActiveTargets, t.DroppedTargets = active, dropped
+	return t, warnings, nil
+}
+
+// accessPolicyExemplarsClient restricts the exemplars query to the series the tenant can read.
+type accessPolicyExemplarsClient struct {
+	exemplars.UnaryClient
+	policy *tenancy.AccessPolicy
+}
+
+func (c *accessPolicyExemplarsClient) Exemplars(ctx context.Context, req *exemplarspb.ExemplarsRequest) ([]*exemplarspb.ExemplarData, annotations.Annotations, error) {
+	tenant, err := tenantFromContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	query, err := c.policy.EnforceQuery(tenant, req.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := *req
+	r.Query = query
+
+	data, warnings, err := c.UnaryClient.Exemplars(ctx, &r)
+	if err != nil {
+		return nil, nil, err
+	}
+	filtered := make([]*exemplarspb.ExemplarData, 0, len(data))
+	for _, d := range data {
+		if c.policy.Allows(tenant, d.SeriesLabels.PromLabels()) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered, warnings, nil
+}
@@ -0,0 +1,153 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/thanos-io/thanos/pkg/rules/rulespb"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/targets/targetspb"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+type staticAccessPolicy string
+
+func (c staticAccessPolicy) Content() ([]byte, error) { return []byte(c), nil }
+func (c staticAccessPolicy) Path() string             { return "" }
+
+type mockedTargetsClient struct {
+	t *targetspb.TargetDiscovery
+}
+
+func (c mockedTargetsClient) Targets(context.Context, *targetspb.TargetsRequest) (*targetspb.TargetDiscovery, annotations.Annotations, error) {
+	return c.t, nil, nil
+}
+
+func newTestAccessPolicy(t *testing.T) *tenancy.AccessPolicy {
+	t.Helper()
+
+	p, err := tenancy.NewAccessPolicy(staticAccessPolicy(`
+policies:
+  - tenants: [team-a]
+    selectors:
+      - '{namespace=~"a-.*"}'
+      - '{cluster="shared"}'
+`), nil, log.NewNopLogger(), 0)
+	testutil.Ok(t, err)
+	return p
+}
+
+func newTenantRequest(t *testing.T, target, tenant string) *http.Request {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Header.Set(tenancy.DefaultTenantHeader, tenant)
+	testutil.Ok(t, r.ParseForm())
+	return r
+}
+
+func TestQueryAPI_AccessPolicyRewrite(t *testing.T) {
+	api := &QueryAPI{
+		tenantHeader:  tenancy.DefaultTenantHeader,
+		defaultTenant: tenancy.DefaultTenant,
+		accessPolicy:  newTestAccessPolicy(t),
+	}
+
+	r := newTenantRequest(t, "/api/v1/query", "team-a")
+	query, tenant, _, err := api.rewritePromQL(context.Background(), r, `sum(up{job="api"})`)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "team-a", tenant)
+	testutil.Equals(t, `sum((up{job="api",namespace=~"a-.*"} or up{cluster="shared",job="api"}))`, query)
+
+	r = newTenantRequest(t, `/api/v1/series?match[]={job="api"}`, "team-a")
+	matcherSets, _, err := api.rewriteLabelMatchers(context.Background(), r, r.Form[MatcherParam])
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(matcherSets))
+	testutil.Equals(t, `namespace=~"a-.*"`, matcherSets[0][1].String())
+	testutil.Equals(t, `cluster="shared"`, matcherSets[1][1].String())
+
+	r = newTenantRequest(t, "/api/v1/query", "team-b")
+	_, _, _, err = api.rewritePromQL(context.Background(), r, `up`)
+	testutil.NotOk(t, err)
+}
+
+func TestQueryAPI_AccessPolicyFilters(t *testing.T) {
+	policy := newTestAccessPolicy(t)
+	api := &QueryAPI{
+		tenantHeader:  tenancy.DefaultTenantHeader,
+		defaultTenant: tenancy.DefaultTenant,
+		accessPolicy:  policy,
+	}
+
+	rulesClient := &accessPolicyRulesClient{
+		policy: policy,
+		UnaryClient: mockedRulesClient{g: map[rulespb.RulesRequest_Type][]*rulespb.RuleGroup{
+			rulespb.RulesRequest_ALL: {
+				{
+					Name: "a",
+					Rules: []*rulespb.Rule{
+						rulespb.NewRecordingRule(&rulespb.RecordingRule{
+							Name:   "allowed",
+							Labels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "namespace", Value: "a-1"}}},
+						}),
+						rulespb.NewRecordingRule(&rulespb.RecordingRule{
+							Name:   "denied",
+							Labels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "namespace", Value: "b"}}},
+						}),
+						rulespb.NewAlertingRule(&rulespb.Alert{
+							Name:   "shared",
+							Labels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "cluster", Value: "shared"}}},
+							Alerts: []*rulespb.AlertInstance{
+								{Labels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "cluster", Value: "shared"}, {Name: "pod", Value: "1"}}}},
+								{Labels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "pod", Value: "2"}}}},
+							},
+						}),
+					},
+				},
+				{
+					Name: "b",
+					Rules: []*rulespb.Rule{
+						rulespb.NewRecordingRule(&rulespb.RecordingRule{
+							Name:   "denied",
+							Labels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "namespace", Value: "b"}}},
+						}),
+					},
+				},
+			},
+		}},
+	}
+
+	res, _, apiErr, _ := api.withAccessPolicy(NewRulesHandler(rulesClient, false))(newTenantRequest(t, "/api/v1/rules", "team-a"))
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+	groups := res.(*rulespb.RuleGroups).Groups
+	testutil.Equals(t, 1, len(groups))
+	testutil.Equals(t, 2, len(groups[0].Rules))
+	testutil.Equals(t, "allowed", groups[0].Rules[0].GetRecording().Name)
+	testutil.Equals(t, 1, len(groups[0].Rules[1].GetAlert().Alerts))
+
+	targetsClient := &accessPolicyTargetsClient{
+		policy: policy,
+		UnaryClient: mockedTargetsClient{t: &targetspb.TargetDiscovery{
+			ActiveTargets: []*targetspb.ActiveTarget{
+				{Labels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "namespace", Value: "a-1"}}}},
+				{Labels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "namespace", Value: "b"}}}},
+			},
+			DroppedTargets: []*targetspb.DroppedTarget{
+				{DiscoveredLabels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "namespace", Value: "b"}}}},
+			},
+		}},
+	}
+	res, _, apiErr, _ = api.withAccessPolicy(NewTargetsHandler(targetsClient, false))(newTenantRequest(t, "/api/v1/targets", "team-a"))
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+	testutil.Equals(t, 1, len(res.(*targetspb.TargetDiscovery).ActiveTargets))
+	testutil.Equals(t, 0, len(res.(*targetspb.TargetDiscovery).DroppedTargets))
+}
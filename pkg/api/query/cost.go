@@ -0,0 +1,63 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/util/stats"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/query"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// queryCost is the estimated and actual cost of a query. The estimated cost is missing if estimating failed.
+type queryCost struct {
+	Estimated *query.Cost `json:"estimated,omitempty"`
+	Actual    query.Cost  `json:"actual"`
+}
+
+// queryStats extends the statistics of the PromQL engine with the cost of the query.
+type queryStats struct {
+	stats.BuiltinStats
+	Cost *queryCost `json:"cost,omitempty"`
+}
+
+func (s *queryStats) Builtin() stats.BuiltinStats {
+	return s.BuiltinStats
+}
+
+// admitQuery runs the admission control of the query based on its estimated cost, if enabled. Unless an error
+// is returned, the returned function has to be called once the query is done.
+func (qapi *QueryAPI) admitQuery(ctx context.Context, tenant string, req query.CostEstimateRequest) (*query.Cost, func(), *api.ApiError) {
+	if qapi.costEstimator == nil {
+		return nil, func() {}, nil
+	}
+	cost, done, err := qapi.costEstimator.Admit(ctx, tenant, req)
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorExec, Err: err}
+	}
+	return cost, done, nil
+}
+
+// newQueryStats returns the optional stats of the query response, if the "stats" parameter is not empty.
+func (qapi *QueryAPI) newQueryStats(r *http.Request, qry promql.Query, estimated *query.Cost, seriesStats []storepb.SeriesStatsCounter) stats.QueryStats {
+	if r.FormValue(Stats) == "" {
+		return nil
+	}
+	qs := stats.NewQueryStats(qry.Stats())
+	if qapi.costEstimator == nil {
+		return qs
+	}
+
+	cost := &queryCost{Estimated: estimated}
+	for _, s := range seriesStats {
+		cost.Actual.Series += int64(s.Series)
+		cost.Actual.Chunks += int64(s.Chunks)
+	}
+	return &queryStats{BuiltinStats: qs.Builtin(), Cost: cost}
+}
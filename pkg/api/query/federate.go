@@ -0,0 +1,251 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/opentracing/opentracing-go"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/query"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// defaultFederateLookbackDelta is the lookback delta used for federation when none is configured,
+// matching the default of the PromQL engines.
+const defaultFederateLookbackDelta = 5 * time.Minute
+
+// RegisterFederate registers the Prometheus compatible /federate endpoint.
+func (qapi *QueryAPI) RegisterFederate(r *route.Router, tracer opentracing.Tracer, logger log.Logger, ins extpromhttp.InstrumentationMiddleware, logMiddleware *logging.HTTPServerMiddleware) {
+	handlerInstr := api.GetHandlerInstr(tracer, logger, ins, logMiddleware, qapi.auditLog, qapi.disableCORS)
+	r.Get("/federate", handlerInstr("federate", qapi.federate))
+}
+
+type federateSample struct {
+	lset labels.Labels
+	t    int64
+	f    float64
+}
+
+// federate exposes the latest sample of each series selected by the match[] parameters, within the lookback delta,
+// in the text or OpenMetrics exposition format. Native histograms are not federated as these formats can't carry them.
+func (qapi *QueryAPI) federate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "error parsing form values: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(r.Form[MatcherParam]) == 0 {
+		http.Error(w, "no match[] parameter provided", http.StatusBadRequest)
+		return
+	}
+
+	matcherSets, ctx, err := qapi.rewriteLabelMatchers(r.Context(), r, r.Form[MatcherParam])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	enableDedup, apiErr := qapi.parseEnableDedupParam(r)
+	if apiErr != nil {
+		http.Error(w, apiErr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	replicaLabels, apiErr := qapi.parseReplicaLabelsParam(r)
+	if apiErr != nil {
+		http.Error(w, apiErr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	enablePartialResponse, apiErr := qapi.parsePartialResponseParam(r, qapi.enableQueryPartialResponse)
+	if apiErr != nil {
+		http.Error(w, apiErr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lookbackDelta := qapi.lookbackDeltaCreate(0)
+	lookbackDeltaFromReq, apiErr := qapi.parseLookbackDeltaParam(r)
+	if apiErr != nil {
+		http.Error(w, apiErr.Error(), http.StatusBadRequest)
+		return
+	}
+	if lookbackDeltaFromReq > 0 {
+		lookbackDelta = lookbackDeltaFromReq
+	}
+	if lookbackDelta <= 0 {
+		lookbackDelta = defaultFederateLookbackDelta
+	}
+
+	var (
+		now  = qapi.baseAPI.Now()
+		mint = timestamp.FromTime(now.Add(-lookbackDelta))
+		maxt = timestamp.FromTime(now)
+	)
+
+	q, err := qapi.queryableCreate(
+		enableDedup,
+		replicaLabels,
+		nil,
+		0,
+		enablePartialResponse,
+		false,
+		nil,
+		query.NoopSeriesStatsReporter,
+	).Querier(mint, maxt)
+	if err != nil {
+		qapi.federationErrors.Inc()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer runutil.CloseWithLogOnErr(qapi.logger, q, "federate querier")
+
+	hints := &storage.SelectHints{Start: mint, End: maxt}
+	sets := make([]storage.SeriesSet, 0, len(matcherSets))
+	for _, ms := range matcherSets {
+		sets = append(sets, q.Select(ctx, true, hints, ms...))
+	}
+
+	var (
+		set     = storage.NewMergeSeriesSet(sets, 0, storage.ChainedSeriesMerge)
+		samples []federateSample
+		it      chunkenc.Iterator
+	)
+	for set.Next() {
+		s := set.At()
+		it = s.Iterator(it)
+
+		latest := federateSample{t: math.MinInt64}
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			if vt != chunkenc.ValFloat {
+				continue
+			}
+			t, f := it.At()
+			if t > maxt {
+				break
+			}
+			if t >= mint {
+				latest.t, latest.f = t, f
+			}
+		}
+		if it.Err() != nil {
+			qapi.federationErrors.Inc()
+			http.Error(w, it.Err().Error(), http.StatusInternalServerError)
+			return
+		}
+		// The exposition formats don't support stale markers, so drop them.
+		if latest.t == math.MinInt64 || value.IsStaleNaN(latest.f) {
+			continue
+		}
+		latest.lset = s.Labels()
+		samples = append(samples, latest)
+	}
+	if ws := set.Warnings(); len(ws) > 0 {
+		level.Debug(qapi.logger).Log("msg", "federation select returned warnings", "warnings", ws.AsErrors())
+		qapi.federationWarnings.Add(float64(len(ws)))
+	}
+	if set.Err() != nil {
+		qapi.federationErrors.Inc()
+		http.Error(w, set.Err().Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Series of the same metric have to be exposed together.
+	sort.Slice(samples, func(i, j int) bool {
+		if c := strings.Compare(samples[i].lset.Get(labels.MetricName), samples[j].lset.Get(labels.MetricName)); c != 0 {
+			return c < 0
+		}
+		return labels.Compare(samples[i].lset, samples[j].lset) < 0
+	})
+
+	format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+	if format.FormatType() != expfmt.TypeOpenMetrics {
+		format = expfmt.NewFormat(expfmt.TypeTextPlain)
+	}
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format)
+
+	if err := qapi.encodeFederated(enc, samples); err != nil {
+		qapi.federationErrors.Inc()
+		level.Error(qapi.logger).Log("msg", "federation failed", "err", err)
+		return
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			qapi.federationErrors.Inc()
+			level.Error(qapi.logger).Log("msg", "federation failed", "err", err)
+		}
+	}
+}
+
+// encodeFederated encodes the given samples, sorted by metric name, as untyped metric families.
+// The external labels of the querier are attached to series which don't have them yet.
+func (qapi *QueryAPI) encodeFederated(enc expfmt.Encoder, samples []federateSample) error {
+	b := labels.NewBuilder(labels.EmptyLabels())
+
+	var fam *dto.MetricFamily
+	for _, s := range samples {
+		name := s.lset.Get(labels.MetricName)
+		if name == "" {
+			level.Warn(qapi.logger).Log("msg", "ignoring nameless metric during federation", "metric", s.lset)
+			continue
+		}
+		if fam != nil && fam.GetName() != name {
+			if err := enc.Encode(fam); err != nil {
+				return err
+			}
+			fam = nil
+		}
+		if fam == nil {
+			fam = &dto.MetricFamily{
+				Name: proto.String(name),
+				Type: dto.MetricType_UNTYPED.Enum(),
+			}
+		}
+
+		b.Reset(s.lset)
+		b.Del(labels.MetricName)
+		qapi.externalLabels.Range(func(l labels.Label) {
+			if s.lset.Get(l.Name) == "" {
+				b.Set(l.Name, l.Value)
+			}
+		})
+
+		m := &dto.Metric{
+			TimestampMs: proto.Int64(s.t),
+			Untyped:     &dto.Untyped{Value: proto.Float64(s.f)},
+		}
+		b.Labels().Range(func(l labels.Label) {
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(l.Name), Value: proto.String(l.Value)})
+		})
+		// Like Prometheus, expose an empty instance label so the scraper doesn't attach its own.
+		if s.lset.Get(model.InstanceLabel) == "" && qapi.externalLabels.Get(model.InstanceLabel) == "" {
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(model.InstanceLabel), Value: proto.String("")})
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+		fam.Metric = append(fam.Metric, m)
+	}
+	if fam != nil {
+		return enc.Encode(fam)
+	}
+	return nil
+}
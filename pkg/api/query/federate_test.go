@@ -0,0 +1,135 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+
+	baseAPI "github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/dedup"
+	"github.com/thanos-io/thanos/pkg/query"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestFederate(t *testing.T) {
+	db, err := e2eutil.NewTSDB()
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, db.Close()) }()
+
+	app := db.Appender(context.Background())
+	for _, s := range []struct {
+		lset labels.Labels
+		t    int64
+		v    float64
+	}{
+		{lset: labels.FromStrings("__name__", "up", "job", "a", "tenant_id", "x", "replica", "1"), t: 100_000, v: 1},
+		{lset: labels.FromStrings("__name__", "up", "job", "a", "tenant_id", "x", "replica", "1"), t: 400_000, v: 2},
+		{lset: labels.FromStrings("__name__", "up", "job", "a", "tenant_id", "x", "replica", "2"), t: 400_000, v: 2},
+		// Older than the lookback delta.
+		{lset: labels.FromStrings("__name__", "up", "job", "b", "tenant_id", "x", "replica", "1"), t: 10_000, v: 1},
+		// Stale.
+		{lset: labels.FromStrings("__name__", "up", "job", "c", "tenant_id", "x", "replica", "1"), t: 300_000, v: 1},
+		{lset: labels.FromStrings("__name__", "up", "job", "c", "tenant_id", "x", "replica", "1"), t: 400_000, v: math.Float64frombits(value.StaleNaN)},
+		{lset: labels.FromStrings("__name__", "go_goroutines", "job", "a", "tenant_id", "x", "replica", "1"), t: 400_000, v: 30},
+		{lset: labels.FromStrings("__name__", "up", "job", "a", "tenant_id", "y", "replica", "1"), t: 400_000, v: 1},
+	} {
+		_, err := app.Append(0, s.lset, s.t, s.v)
+		testutil.Ok(t, err)
+	}
+	testutil.Ok(t, app.Commit())
+
+	qapi := &QueryAPI{
+		baseAPI: &baseAPI.BaseAPI{
+			Now: func() time.Time { return time.UnixMilli(450_000) },
+		},
+		logger:                     log.NewNopLogger(),
+		queryableCreate:            query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, time.Minute, dedup.AlgorithmPenalty),
+		lookbackDeltaCreate:        func(int64) time.Duration { return 0 },
+		replicaLabels:              []string{"replica"},
+		enableQueryPartialResponse: true,
+		tenantHeader:               "thanos-tenant",
+		defaultTenant:              "default-tenant",
+		enforceTenancy:             true,
+		tenantLabel:                "tenant_id",
+		externalLabels:             labels.FromStrings("cluster", "eu", "job", "federated"),
+		federationErrors:           prometheus.NewCounter(prometheus.CounterOpts{}),
+		federationWarnings:         prometheus.NewCounter(prometheus.CounterOpts{}),
+	}
+
+	for _, tcase := range []struct {
+		name   string
+		params string
+		accept string
+		exp    string
+	}{
+		{
+			name:   "text format",
+			params: "?match[]=up&match[]=go_goroutines",
+			exp: `# TYPE go_goroutines untyped
+go_goroutines{cluster="eu",instance="",job="a",tenant_id="x"} 30 400000
+# TYPE up untyped
+up{cluster="eu",instance="",job="a",tenant_id="x"} 2 400000
+`,
+		},
+		{
+			name:   "without deduplication",
+			params: "?match[]=up&dedup=false",
+			exp: `# TYPE up untyped
+up{cluster="eu",instance="",job="a",replica="1",tenant_id="x"} 2 400000
+up{cluster="eu",instance="",job="a",replica="2",tenant_id="x"} 2 400000
+`,
+		},
+		{
+			name:   "custom lookback delta",
+			params: "?match[]=up&lookback_delta=500s",
+			exp: `# TYPE up untyped
+up{cluster="eu",instance="",job="a",tenant_id="x"} 2 400000
+up{cluster="eu",instance="",job="b",tenant_id="x"} 1 10000
+`,
+		},
+		{
+			name:   "OpenMetrics format",
+			params: "?match[]=up",
+			accept: "application/openmetrics-text;version=1.0.0",
+			exp: `# TYPE up unknown
+up{cluster="eu",instance="",job="a",tenant_id="x"} 2.0 400.0
+# EOF
+`,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/federate"+tcase.params, nil)
+			r.Header.Set("thanos-tenant", "x")
+			if tcase.accept != "" {
+				r.Header.Set("Accept", tcase.accept)
+			}
+
+			w := httptest.NewRecorder()
+			qapi.federate(w, r)
+			testutil.Equals(t, http.StatusOK, w.Code, w.Body.String())
+			if tcase.accept == "" {
+				testutil.Equals(t, string(expfmt.NewFormat(expfmt.TypeTextPlain)), w.Header().Get("Content-Type"))
+			}
+			testutil.Equals(t, tcase.exp, w.Body.String())
+		})
+	}
+
+	t.Run("missing match", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		qapi.federate(w, httptest.NewRequest(http.MethodGet, "/federate", nil))
+		testutil.Equals(t, http.StatusBadRequest, w.Code)
+	})
+}
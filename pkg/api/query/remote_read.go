@@ -0,0 +1,219 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+
+	"github.com/thanos-io/thanos/pkg/query"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+// remoteRead implements the Prometheus remote read API on top of the global view, honoring the same
+// deduplication, downsampling and partial response parameters as the PromQL API.
+func (qapi *QueryAPI) remoteRead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := qapi.remoteReadGate.Start(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer qapi.remoteReadGate.Done()
+
+	req, err := remote.DecodeReadRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenant, err := tenancy.GetTenantFromHTTP(r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx = context.WithValue(ctx, tenancy.TenantKey, tenant)
+
+	matcherSets := make([][]*labels.Matcher, 0, len(req.Queries))
+	for _, q := range req.Queries {
+		matchers, err := remote.FromLabelMatchers(q.Matchers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if qapi.enforceTenancy {
+			if matchers, err = tenancy.EnforceLabelMatchers(qapi.tenantLabel, tenant, matchers); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if qapi.accessPolicy != nil {
+			if matchers, err = enforceRemoteReadAccessPolicy(qapi.accessPolicy, tenant, matchers); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+
+	queryable, err := qapi.remoteReadQueryable(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	responseType, err := remote.NegotiateResponseType(req.AcceptedResponseTypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch responseType {
+	case prompb.ReadRequest_STREAMED_XOR_CHUNKS:
+		err = qapi.remoteReadStreamedXORChunks(ctx, w, queryable, req, matcherSets)
+	default:
+		// Like Prometheus, default to non streamed raw samples on empty or unknown response types.
+		err = qapi.remoteReadSamples(ctx, w, queryable, req, matcherSets)
+	}
+	if err != nil {
+		var httpErr remote.HTTPError
+		if errors.As(err, &httpErr) {
+			http.Error(w, httpErr.Error(), httpErr.Status())
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// remoteReadQueryable returns the queryable for the remote read request, configured through its URL parameters.
+func (qapi *QueryAPI) remoteReadQueryable(r *http.Request) (storage.Queryable, error) {
+	enableDedup, apiErr := qapi.parseEnableDedupParam(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	replicaLabels, apiErr := qapi.parseReplicaLabelsParam(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	storeDebugMatchers, apiErr := qapi.parseStoreDebugMatchersParam(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	// Remote read clients expect raw samples unless explicitly asked otherwise.
+	maxSourceResolution, apiErr := qapi.parseDownsamplingParamMillis(r, 0)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	enablePartialResponse, apiErr := qapi.parsePartialResponseParam(r, qapi.enableQueryPartialResponse)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	return qapi.queryableCreate(
+		enableDedup,
+		replicaLabels,
+		storeDebugMatchers,
+		maxSourceResolution,
+		enablePartialResponse,
+		false,
+		nil,
+		query.NoopSeriesStatsReporter,
+	), nil
+}
+
+func remoteReadHints(q *prompb.Query) *storage.SelectHints {
+	if q.Hints == nil {
+		return &storage.SelectHints{Start: q.StartTimestampMs, End: q.EndTimestampMs}
+	}
+	return &storage.SelectHints{
+		Start:    q.Hints.StartMs,
+		End:      q.Hints.EndMs,
+		Step:     q.Hints.StepMs,
+		Func:     q.Hints.Func,
+		Grouping: q.Hints.Grouping,
+		Range:    q.Hints.RangeMs,
+		By:       q.Hints.By,
+	}
+}
+
+func (qapi *QueryAPI) remoteReadSamples(ctx context.Context, w http.ResponseWriter, queryable storage.Queryable, req *prompb.ReadRequest, matcherSets [][]*labels.Matcher) error {
+	resp := prompb.ReadResponse{
+		Results: make([]*prompb.QueryResult, len(req.Queries)),
+	}
+	for i, q := range req.Queries {
+		if err := func() error {
+			querier, err := queryable.Querier(q.StartTimestampMs, q.EndTimestampMs)
+			if err != nil {
+				return err
+			}
+			defer runutil.CloseWithLogOnErr(qapi.logger, querier, "remote read querier")
+
+			res, ws, err := remote.ToQueryResult(querier.Select(ctx, false, remoteReadHints(q), matcherSets[i]...), qapi.remoteReadSampleLimit)
+			if err != nil {
+				return err
+			}
+			for _, w := range ws {
+				level.Warn(qapi.logger).Log("msg", "warning on remote read query", "err", w)
+			}
+			resp.Results[i] = res
+			return nil
+		}(); err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+	return remote.EncodeReadResponse(&resp, w)
+}
+
+func (qapi *QueryAPI) remoteReadStreamedXORChunks(ctx context.Context, w http.ResponseWriter, queryable storage.Queryable, req *prompb.ReadRequest, matcherSets [][]*labels.Matcher) error {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("internal http.ResponseWriter does not implement http.Flusher interface")
+	}
+	w.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+
+	for i, q := range req.Queries {
+		if err := func() error {
+			querier, err := queryable.Querier(q.StartTimestampMs, q.EndTimestampMs)
+			if err != nil {
+				return err
+			}
+			defer runutil.CloseWithLogOnErr(qapi.logger, querier, "remote read querier")
+
+			// Series are deduplicated and merged across stores, so XOR chunks are re-encoded from samples.
+			// The streaming API requires series sorted.
+			ws, err := remote.StreamChunkedReadResponses(
+				remote.NewChunkedWriter(w, f),
+				int64(i),
+				storage.NewSeriesSetToChunkSet(querier.Select(ctx, true, remoteReadHints(q), matcherSets[i]...)),
+				nil,
+				qapi.remoteReadMaxBytesInFrame,
+				&qapi.remoteReadMarshalPool,
+			)
+			if err != nil {
+				return err
+			}
+			for _, w := range ws {
+				level.Warn(qapi.logger).Log("msg", "warning on streamed remote read query", "err", w)
+			}
+			return nil
+		}(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/thanos-io/thanos/pkg/dedup"
+	"github.com/thanos-io/thanos/pkg/gate"
+	"github.com/thanos-io/thanos/pkg/query"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestRemoteRead(t *testing.T) {
+	db, err := e2eutil.NewTSDB()
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, db.Close()) }()
+
+	app := db.Appender(context.Background())
+	for _, lbls := range []labels.Labels{
+		labels.FromStrings("__name__", "up", "tenant_id", "a", "replica", "1"),
+		labels.FromStrings("__name__", "up", "tenant_id", "a", "replica", "2"),
+		labels.FromStrings("__name__", "up", "tenant_id", "b", "replica", "1"),
+	} {
+		for i := int64(0); i < 10; i++ {
+			_, err := app.Append(0, lbls, i*60000, float64(i))
+			testutil.Ok(t, err)
+		}
+	}
+	testutil.Ok(t, app.Commit())
+
+	qapi := &QueryAPI{
+		logger:                     log.NewNopLogger(),
+		queryableCreate:            query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, time.Minute, dedup.AlgorithmPenalty),
+		replicaLabels:              []string{"replica"},
+		enableQueryPartialResponse: true,
+		tenantHeader:               "thanos-tenant",
+		defaultTenant:              "default-tenant",
+		enforceTenancy:             true,
+		tenantLabel:                "tenant_id",
+		remoteReadGate:             gate.NewNoop(),
+		remoteReadMaxBytesInFrame:  1024 * 1024,
+	}
+
+	newRequest := func(t *testing.T, params string, responseTypes ...prompb.ReadRequest_ResponseType) *http.Request {
+		q, err := remote.ToQuery(0, 600000, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}, nil)
+		testutil.Ok(t, err)
+		b, err := proto.Marshal(&prompb.ReadRequest{Queries: []*prompb.Query{q}, AcceptedResponseTypes: responseTypes})
+		testutil.Ok(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/read"+params, bytes.NewReader(snappy.Encode(nil, b)))
+		r.Header.Set("Content-Encoding", "snappy")
+		r.Header.Set("thanos-tenant", "a")
+		return r
+	}
+
+	for _, tcase := range []struct {
+		name       string
+		params     string
+		expSeries  []labels.Labels
+		expSamples int
+	}{
+		{
+			name:       "deduplicated",
+			expSeries:  []labels.Labels{labels.FromStrings("__name__", "up", "tenant_id", "a")},
+			expSamples: 10,
+		},
+		{
+			name:   "without deduplication",
+			params: "?dedup=false",
+			expSeries: []labels.Labels{
+				labels.FromStrings("__name__", "up", "replica", "1", "tenant_id", "a"),
+				labels.FromStrings("__name__", "up", "replica", "2", "tenant_id", "a"),
+			},
+			expSamples: 20,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Run("samples", func(t *testing.T) {
+				w := httptest.NewRecorder()
+				qapi.remoteRead(w, newRequest(t, tcase.params, prompb.ReadRequest_SAMPLES))
+				testutil.Equals(t, http.StatusOK, w.Code, w.Body.String())
+
+				b, err := snappy.Decode(nil, w.Body.Bytes())
+				testutil.Ok(t, err)
+				var resp prompb.ReadResponse
+				testutil.Ok(t, proto.Unmarshal(b, &resp))
+				testutil.Equals(t, 1, len(resp.Results))
+
+				var (
+					series  []labels.Labels
+					samples int
+				)
+				for _, ts := range resp.Results[0].Timeseries {
+					series = append(series, ts.ToLabels(&labels.ScratchBuilder{}, nil))
+					samples += len(ts.Samples)
+				}
+				testutil.Equals(t, tcase.expSeries, series)
+				testutil.Equals(t, tcase.expSamples, samples)
+			})
+			t.Run("streamed chunks", func(t *testing.T) {
+				w := httptest.NewRecorder()
+				qapi.remoteRead(w, newRequest(t, tcase.params, prompb.ReadRequest_STREAMED_XOR_CHUNKS))
+				testutil.Equals(t, http.StatusOK, w.Code, w.Body.String())
+
+				var (
+					series  []labels.Labels
+					samples int
+				)
+				r := remote.NewChunkedReader(w.Body, config.DefaultChunkedReadLimit, nil)
+				for {
+					var resp prompb.ChunkedReadResponse
+					if err := r.NextProto(&resp); err == io.EOF {
+						break
+					} else {
+						testutil.Ok(t, err)
+					}
+					for _, cs := range resp.ChunkedSeries {
+						series = append(series, cs.ToLabels(&labels.ScratchBuilder{}, nil))
+						for _, c := range cs.Chunks {
+							chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Data)
+							testutil.Ok(t, err)
+							samples += chk.NumSamples()
+						}
+					}
+				}
+				testutil.Equals(t, tcase.expSeries, series)
+				testutil.Equals(t, tcase.expSamples, samples)
+			})
+		})
+	}
+
+	t.Run("sample limit", func(t *testing.T) {
+		qapi.remoteReadSampleLimit = 5
+
+		w := httptest.NewRecorder()
+		qapi.remoteRead(w, newRequest(t, ""))
+		testutil.Equals(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/thanos-io/thanos/pkg/api"
+)
+
+// parseStreamParam returns true if the request opted in to a streamed response.
+func (qapi *QueryAPI) parseStreamParam(r *http.Request) (bool, *api.ApiError) {
+	val := r.FormValue(StreamParam)
+	if val == "" {
+		return false, nil
+	}
+	stream, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Wrapf(err, "'%s' parameter", StreamParam)}
+	}
+	return stream, nil
+}
+
+// streamQueryData returns the query data streamed series by series if the result is a matrix. The matrix is the
+// result of the engine itself, whose series are released as soon as they are written.
+func streamQueryData(data *queryData) interface{} {
+	m, ok := data.Result.(promql.Matrix)
+	if !ok {
+		return data
+	}
+
+	fields := []api.StreamedField{{Name: "stats", Value: data.Stats}}
+	if data.QueryAnalysis.OperatorName != "" {
+		fields = append(fields, api.StreamedField{Name: "analysis", Value: data.QueryAnalysis})
+	}
+	return &api.StreamedData{
+		ResultType: string(data.ResultType),
+		Items:      &matrixIterator{m: m, i: -1},
+		Fields:     fields,
+	}
+}
+
+// matrixIterator iterates over the series of a matrix, releasing every series once the next one is requested, so that
+// the memory of the written series can be reclaimed while the rest of the response is written.
+type matrixIterator struct {
+	m promql.Matrix
+	i int
+}
+
+func (it *matrixIterator) Next() bool {
+	if it.i >= 0 && it.i < len(it.m) {
+		it.m[it.i] = promql.Series{}
+	}
+	it.i++
+	return it.i < len(it.m)
+}
+
+func (it *matrixIterator) At() interface{} { return it.m[it.i] }
+func (it *matrixIterator) Err() error      { return nil }
+func (it *matrixIterator) Warnings() []error {
+	return nil
+}
+
+// seriesIterator streams the labels of the series of a series set, up to the given limit.
+type seriesIterator struct {
+	set   storage.SeriesSet
+	limit int
+
+	n         int
+	truncated bool
+}
+
+func (it *seriesIterator) Next() bool {
+	if !it.set.Next() {
+		return false
+	}
+	it.n++
+	if it.limit > 0 && it.n > it.limit {
+		it.truncated = true
+		return false
+	}
+	return true
+}
+
+func (it *seriesIterator) At() interface{} { return it.set.At().Labels() }
+
+func (it *seriesIterator) Err() error {
+	if err := it.set.Err(); err != nil {
+		return &api.ApiError{Typ: api.ErrorExec, Err: err}
+	}
+	return nil
+}
+
+func (it *seriesIterator) Warnings() []error {
+	warnings := it.set.Warnings()
+	if it.truncated {
+		warnings.Add(errors.New("results truncated due to limit"))
+	}
+	return warnings.AsErrors()
+}
@@ -24,9 +24,11 @@ import (
 	"encoding/json"
 	"math"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
@@ -46,6 +48,7 @@ import (
 	"github.com/thanos-io/promql-engine/engine"
 
 	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/audit"
 	"github.com/thanos-io/thanos/pkg/exemplars"
 	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
@@ -81,6 +84,7 @@ const (
 	LookbackDeltaParam = "lookback_delta"
 	EngineParam        = "engine"
 	QueryAnalyzeParam  = "analyze"
+	StreamParam        = "stream"
 	RuleNameParam      = "rule_name[]"
 	RuleGroupParam     = "rule_group[]"
 	FileParam          = "file[]"
@@ -125,6 +129,19 @@ type QueryAPI struct {
 	tenantCertField string
 	enforceTenancy  bool
 	tenantLabel     string
+
+	remoteReadGate            gate.Gate
+	remoteReadSampleLimit     int
+	remoteReadMaxBytesInFrame int
+	remoteReadMarshalPool     sync.Pool
+
+	externalLabels     labels.Labels
+	federationErrors   prometheus.Counter
+	federationWarnings prometheus.Counter
+
+	costEstimator *query.CostEstimator
+	accessPolicy  *tenancy.AccessPolicy
+	auditLog      *audit.Log
 }
 
 // NewQueryAPI returns an initialized QueryAPI type.
@@ -160,10 +177,22 @@ func NewQueryAPI(
 	tenantCertField string,
 	enforceTenancy bool,
 	tenantLabel string,
+	remoteReadGate gate.Gate,
+	remoteReadSampleLimit int,
+	remoteReadMaxBytesInFrame int,
+	externalLabels labels.Labels,
+	costEstimator *query.CostEstimator,
+	accessPolicy *tenancy.AccessPolicy,
+	auditLog *audit.Log,
 ) *QueryAPI {
 	if statsAggregatorFactory == nil {
 		statsAggregatorFactory = &store.NoopSeriesStatsAggregatorFactory{}
 	}
+	if accessPolicy != nil {
+		ruleGroups = &accessPolicyRulesClient{UnaryClient: ruleGroups, policy: accessPolicy}
+		targets = &accessPolicyTargetsClient{UnaryClient: targets, policy: accessPolicy}
+		exemplars = &accessPolicyExemplarsClient{UnaryClient: exemplars, policy: accessPolicy}
+	}
 	return &QueryAPI{
 		baseAPI:                                api.NewBaseAPI(logger, disableCORS, flagsMap),
 		logger:                                 logger,
@@ -195,12 +224,27 @@ func NewQueryAPI(
 		tenantCertField:                        tenantCertField,
 		enforceTenancy:                         enforceTenancy,
 		tenantLabel:                            tenantLabel,
+		remoteReadGate:                         remoteReadGate,
+		remoteReadSampleLimit:                  remoteReadSampleLimit,
+		remoteReadMaxBytesInFrame:              remoteReadMaxBytesInFrame,
+		externalLabels:                         externalLabels,
+		costEstimator:                          costEstimator,
+		accessPolicy:                           accessPolicy,
+		auditLog:                               auditLog,
 
 		queryRangeHist: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
 			Name:    "thanos_query_range_requested_timespan_duration_seconds",
 			Help:    "A histogram of the query range window in seconds",
 			Buckets: prometheus.ExponentialBuckets(15*60, 2, 12),
 		}),
+		federationErrors: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_federation_errors_total",
+			Help: "Total number of errors that occurred while sending federation responses.",
+		}),
+		federationWarnings: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_federation_warnings_total",
+			Help: "Total number of warnings that occurred while sending federation responses.",
+		}),
 	}
 }
 
@@ -208,7 +252,7 @@ func NewQueryAPI(
 func (qapi *QueryAPI) Register(r *route.Router, tracer opentracing.Tracer, logger log.Logger, ins extpromhttp.InstrumentationMiddleware, logMiddleware *logging.HTTPServerMiddleware) {
 	qapi.baseAPI.Register(r, tracer, logger, ins, logMiddleware)
 
-	instr := api.GetInstr(tracer, logger, ins, logMiddleware, qapi.disableCORS)
+	instr := api.GetInstr(tracer, logger, ins, logMiddleware, qapi.auditLog, qapi.disableCORS)
 
 	r.Get("/query", instr("query", qapi.query))
 	r.Post("/query", instr("query", qapi.query))
@@ -232,15 +276,18 @@ func (qapi *QueryAPI) Register(r *route.Router, tracer opentracing.Tracer, logge
 
 	r.Get("/stores", instr("stores", qapi.stores))
 
-	r.Get("/alerts", instr("alerts", NewAlertsHandler(qapi.ruleGroups, qapi.enableRulePartialResponse)))
-	r.Get("/rules", instr("rules", NewRulesHandler(qapi.ruleGroups, qapi.enableRulePartialResponse)))
+	r.Get("/alerts", instr("alerts", qapi.withAccessPolicy(NewAlertsHandler(qapi.ruleGroups, qapi.enableRulePartialResponse))))
+	r.Get("/rules", instr("rules", qapi.withAccessPolicy(NewRulesHandler(qapi.ruleGroups, qapi.enableRulePartialResponse))))
 
-	r.Get("/targets", instr("targets", NewTargetsHandler(qapi.targets, qapi.enableTargetPartialResponse)))
+	r.Get("/targets", instr("targets", qapi.withAccessPolicy(NewTargetsHandler(qapi.targets, qapi.enableTargetPartialResponse))))
 
 	r.Get("/metadata", instr("metadata", NewMetricMetadataHandler(qapi.metadatas, qapi.enableMetricMetadataPartialResponse)))
 
-	r.Get("/query_exemplars", instr("exemplars", NewExemplarsHandler(qapi.exemplars, qapi.enableExemplarPartialResponse)))
-	r.Post("/query_exemplars", instr("exemplars", NewExemplarsHandler(qapi.exemplars, qapi.enableExemplarPartialResponse)))
+	r.Get("/query_exemplars", instr("exemplars", qapi.withAccessPolicy(NewExemplarsHandler(qapi.exemplars, qapi.enableExemplarPartialResponse))))
+	r.Post("/query_exemplars", instr("exemplars", qapi.withAccessPolicy(NewExemplarsHandler(qapi.exemplars, qapi.enableExemplarPartialResponse))))
+
+	handlerInstr := api.GetHandlerInstr(tracer, logger, ins, logMiddleware, qapi.auditLog, qapi.disableCORS)
+	r.Post("/read", handlerInstr("remote_read", qapi.remoteRead))
 }
 
 type queryData struct {
@@ -260,6 +307,36 @@ type queryTelemetry struct {
 	PeakSamples  int64            `json:"peakSamples,omitempty"`
 	TotalSamples int64            `json:"totalSamples,omitempty"`
 	Children     []queryTelemetry `json:"children,omitempty"`
+	// Stores describes how the series selected by the operator were fetched from the StoreAPI endpoints.
+	Stores []storeTelemetry `json:"stores,omitempty"`
+}
+
+// storeTelemetry describes the Series call made to a StoreAPI endpoint while analyzing a query.
+type storeTelemetry struct {
+	Name string `json:"name"`
+	// PrunedReason is the reason why the endpoint was not queried.
+	PrunedReason string `json:"prunedReason,omitempty"`
+	Execution    string `json:"executionTime,omitempty"`
+	Series       int    `json:"series"`
+	Chunks       int    `json:"chunks"`
+	Samples      int    `json:"samples"`
+	Error        string `json:"error,omitempty"`
+	// ErrorCode is the gRPC code classifying the error, e.g. Canceled for endpoints interrupted by the failure
+	// of another one.
+	ErrorCode string `json:"errorCode,omitempty"`
+	// Cost is reported by endpoints serving data from object storage.
+	Cost *storeCost `json:"cost,omitempty"`
+}
+
+type storeCost struct {
+	BlocksQueried   int64 `json:"blocksQueried"`
+	PostingsTouched int64 `json:"postingsTouched"`
+	PostingsFetched int64 `json:"postingsFetched"`
+	SeriesTouched   int64 `json:"seriesTouched"`
+	SeriesFetched   int64 `json:"seriesFetched"`
+	ChunksTouched   int64 `json:"chunksTouched"`
+	ChunksFetched   int64 `json:"chunksFetched"`
+	DownloadedBytes int64 `json:"downloadedBytes"`
 }
 
 func (qapi *QueryAPI) parseEnableDedupParam(r *http.Request) (enableDeduplication bool, _ *api.ApiError) {
@@ -411,14 +488,27 @@ func (qapi *QueryAPI) getQueryExplain(query promql.Query) (*engine.ExplainOutput
 
 }
 
-func (qapi *QueryAPI) parseQueryAnalyzeParam(r *http.Request, query promql.Query) (queryTelemetry, error) {
+// parseQueryAnalyzeParam returns true if the query should be analyzed. Only queries of the Thanos engine can be analyzed.
+func (qapi *QueryAPI) parseQueryAnalyzeParam(r *http.Request, query promql.Query) (bool, *api.ApiError) {
 	if r.FormValue(QueryAnalyzeParam) == "true" || r.FormValue(QueryAnalyzeParam) == "1" {
-		if eq, ok := query.(engine.ExplainableQuery); ok {
-			return processAnalysis(eq.Analyze()), nil
+		if _, ok := query.(engine.ExplainableQuery); ok {
+			return true, nil
 		}
-		return queryTelemetry{}, errors.Errorf("Query not analyzable; change engine to 'thanos'")
+		return false, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Errorf("Query not analyzable; change engine to 'thanos'")}
+	}
+	return false, nil
+}
+
+// analyzeQuery returns the analysis of an executed query. The Series calls recorded by the fan-out tracker are
+// attached to the selectors which made them.
+func analyzeQuery(query promql.Query, fanout *store.FanoutTracker) queryTelemetry {
+	analysis := processAnalysis(query.(engine.ExplainableQuery).Analyze())
+
+	// Remaining calls, e.g. made by pushed down operators, are attached to the root operator.
+	for _, sel := range attachFanout(&analysis, fanout.Selects()) {
+		analysis.Stores = append(analysis.Stores, processFanout(sel)...)
 	}
-	return queryTelemetry{}, nil
+	return analysis
 }
 
 func processAnalysis(a *engine.AnalyzeOutputNode) queryTelemetry {
@@ -433,6 +523,128 @@ func processAnalysis(a *engine.AnalyzeOutputNode) queryTelemetry {
 	return analysis
 }
 
+// attachFanout attaches each Series call to the first selector of the tree selecting the same matchers, and returns
+// the calls which did not match any selector.
+func attachFanout(node *queryTelemetry, selects []store.SelectFanout) []store.SelectFanout {
+	if matchers, ok := selectorMatchers(node.OperatorName); ok {
+		for i, sel := range selects {
+			// Selectors list the matchers of their filters, if any, after the matchers they select series with.
+			if len(sel.Matchers) > len(matchers) || !slices.EqualFunc(sel.Matchers, matchers[:len(sel.Matchers)], matcherEqual) {
+				continue
+			}
+			node.Stores = append(node.Stores, processFanout(sel)...)
+			selects = append(selects[:i:i], selects[i+1:]...)
+			break
+		}
+	}
+	for i := range node.Children {
+		selects = attachFanout(&node.Children[i], selects)
+	}
+	return selects
+}
+
+// selectorMatchers parses the matchers of a vector or matrix selector from its operator name, e.g.
+// `[vectorSelector] {[__name__="up" job=~"api"]} 0 mod 2`. It returns false for other operators.
+func selectorMatchers(operatorName string) ([]*labels.Matcher, bool) {
+	rest, ok := strings.CutPrefix(operatorName, "[vectorSelector] {[")
+	if !ok {
+		if rest, ok = strings.CutPrefix(operatorName, "[matrixSelector] {["); !ok {
+			return nil, false
+		}
+	}
+
+	var matchers []*labels.Matcher
+	for !strings.HasPrefix(rest, "]}") {
+		m, r, err := parseMatcher(strings.TrimPrefix(rest, " "))
+		if err != nil {
+			return nil, false
+		}
+		matchers, rest = append(matchers, m), r
+	}
+	return matchers, true
+}
+
+// parseMatcher parses the matcher at the beginning of s, as formatted by labels.Matcher.String, and returns the rest of s.
+func parseMatcher(s string) (*labels.Matcher, string, error) {
+	var (
+		m   labels.Matcher
+		err error
+	)
+	if strings.HasPrefix(s, `"`) {
+		if s, err = unquotePrefix(s, &m.Name); err != nil {
+			return nil, "", err
+		}
+	} else {
+		i := strings.IndexAny(s, "=!")
+		if i <= 0 {
+			return nil, "", errors.Errorf("no label name in %q", s)
+		}
+		m.Name, s = s[:i], s[i:]
+	}
+
+	// Longer operators go first, since "=" is a prefix of "=~".
+	for _, t := range []labels.MatchType{labels.MatchNotEqual, labels.MatchRegexp, labels.MatchNotRegexp, labels.MatchEqual} {
+		if r, ok := strings.CutPrefix(s, t.String()); ok {
+			m.Type, s = t, r
+			if s, err = unquotePrefix(s, &m.Value); err != nil {
+				return nil, "", err
+			}
+			return &m, s, nil
+		}
+	}
+	return nil, "", errors.Errorf("no match type in %q", s)
+}
+
+// unquotePrefix unquotes the quoted string at the beginning of s into dst, and returns the rest of s.
+func unquotePrefix(s string, dst *string) (string, error) {
+	q, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return "", err
+	}
+	if *dst, err = strconv.Unquote(q); err != nil {
+		return "", err
+	}
+	return s[len(q):], nil
+}
+
+func matcherEqual(a, b *labels.Matcher) bool {
+	return a.Type == b.Type && a.Name == b.Name && a.Value == b.Value
+}
+
+func processFanout(sel store.SelectFanout) []storeTelemetry {
+	res := make([]storeTelemetry, 0, len(sel.Stores))
+	for _, st := range sel.Stores {
+		t := storeTelemetry{
+			Name:         st.Store,
+			PrunedReason: st.PrunedReason,
+			Series:       st.Stats.Series,
+			Chunks:       st.Stats.Chunks,
+			Samples:      st.Stats.Samples,
+		}
+		if st.Err != nil {
+			t.Error = st.Err.Error()
+			t.ErrorCode = st.Code().String()
+		}
+		if st.PrunedReason == "" {
+			t.Execution = st.Duration.String()
+		}
+		if qs := st.QueryStats; qs != nil {
+			t.Cost = &storeCost{
+				BlocksQueried:   qs.BlocksQueried,
+				PostingsTouched: qs.PostingsTouched,
+				PostingsFetched: qs.PostingsFetched,
+				SeriesTouched:   qs.SeriesTouched,
+				SeriesFetched:   qs.SeriesFetched,
+				ChunksTouched:   qs.ChunksTouched,
+				ChunksFetched:   qs.ChunksFetched,
+				DownloadedBytes: qs.DataDownloadedSizeSum,
+			}
+		}
+		res = append(res, t)
+	}
+	return res
+}
+
 func (qapi *QueryAPI) queryExplain(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
 	engineParam, apiErr := qapi.parseEngineParam(r)
 	if apiErr != nil {
@@ -490,6 +702,9 @@ func (qapi *QueryAPI) queryExplain(r *http.Request) (interface{}, []error, *api.
 	if apiErr != nil {
 		return nil, nil, apiErr, func() {}
 	}
+	if enableDedup {
+		shardInfo = withoutReplicaLabels(shardInfo, replicaLabels)
+	}
 
 	lookbackDelta := qapi.lookbackDeltaCreate(maxSourceResolution)
 	// Get custom lookback delta from request.
@@ -500,7 +715,7 @@ func (qapi *QueryAPI) queryExplain(r *http.Request) (interface{}, []error, *api.
 	if lookbackDeltaFromReq > 0 {
 		lookbackDelta = lookbackDeltaFromReq
 	}
-	queryStr, _, ctx, err := tenancy.RewritePromQL(ctx, r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField, qapi.enforceTenancy, qapi.tenantLabel, queryParam)
+	queryStr, _, ctx, err := qapi.rewritePromQL(ctx, r, queryParam)
 	if err != nil {
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
@@ -591,6 +806,9 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 	if apiErr != nil {
 		return nil, nil, apiErr, func() {}
 	}
+	if enableDedup {
+		shardInfo = withoutReplicaLabels(shardInfo, replicaLabels)
+	}
 
 	engineParam, apiErr := qapi.parseEngineParam(r)
 	if apiErr != nil {
@@ -607,7 +825,7 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 	if lookbackDeltaFromReq > 0 {
 		lookbackDelta = lookbackDeltaFromReq
 	}
-	queryStr, tenant, ctx, err := tenancy.RewritePromQL(ctx, r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField, qapi.enforceTenancy, qapi.tenantLabel, queryParam)
+	queryStr, tenant, ctx, err := qapi.rewritePromQL(ctx, r, queryParam)
 	if err != nil {
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
@@ -644,10 +862,35 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
 
-	analysis, err := qapi.parseQueryAnalyzeParam(r, qry)
-	if err != nil {
-		return nil, nil, apiErr, func() {}
+	analyze, apiErr := qapi.parseQueryAnalyzeParam(r, qry)
+	if apiErr != nil {
+		return nil, nil, apiErr, qry.Close
+	}
+
+	stream, apiErr := qapi.parseStreamParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr, qry.Close
+	}
+
+	var (
+		estimatedCost *query.Cost
+		admitted      func()
+	)
+	tracing.DoInSpan(ctx, "instant_query_admit", func(ctx context.Context) {
+		estimatedCost, admitted, apiErr = qapi.admitQuery(ctx, tenant, query.CostEstimateRequest{
+			Query:                 queryStr,
+			Start:                 ts,
+			End:                   ts,
+			LookbackDelta:         lookbackDelta,
+			MaxResolutionMillis:   maxSourceResolution,
+			EnablePartialResponse: enablePartialResponse,
+			ShardInfo:             shardInfo,
+		})
+	})
+	if apiErr != nil {
+		return nil, nil, apiErr, qry.Close
 	}
+	defer admitted()
 
 	if err := tracing.DoInSpanWithErr(ctx, "query_gate_ismyturn", qapi.gate.Start); err != nil {
 		return nil, nil, &api.ApiError{Typ: api.ErrorExec, Err: err}, qry.Close
@@ -655,6 +898,12 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 	defer qapi.gate.Done()
 	beforeRange := time.Now()
 
+	var fanout *store.FanoutTracker
+	if analyze {
+		fanout = store.NewFanoutTracker()
+		ctx = store.WithFanoutTracker(ctx, fanout)
+	}
+
 	var res *promql.Result
 	tracing.DoInSpan(ctx, "instant_query_exec", func(ctx context.Context) {
 		res = qry.Exec(ctx)
@@ -676,18 +925,24 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 		aggregator.Aggregate(seriesStats[i])
 	}
 	aggregator.Observe(time.Since(beforeRange).Seconds())
+	auditResult(r.Context(), res.Value, seriesStats)
 
-	// Optional stats field in response if parameter "stats" is not empty.
-	var qs stats.QueryStats
-	if r.FormValue(Stats) != "" {
-		qs = stats.NewQueryStats(qry.Stats())
+	var analysis queryTelemetry
+	if analyze {
+		analysis = analyzeQuery(qry, fanout)
 	}
-	return &queryData{
+
+	// Optional stats field in response if parameter "stats" is not empty.
+	data := &queryData{
 		ResultType:    res.Value.Type(),
 		Result:        res.Value,
-		Stats:         qs,
+		Stats:         qapi.newQueryStats(r, qry, estimatedCost, seriesStats),
 		QueryAnalysis: analysis,
-	}, res.Warnings.AsErrors(), nil, qry.Close
+	}
+	if stream {
+		return streamQueryData(data), res.Warnings.AsErrors(), nil, qry.Close
+	}
+	return data, res.Warnings.AsErrors(), nil, qry.Close
 }
 
 func (qapi *QueryAPI) queryRangeExplain(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
@@ -773,6 +1028,9 @@ func (qapi *QueryAPI) queryRangeExplain(r *http.Request) (interface{}, []error,
 	if apiErr != nil {
 		return nil, nil, apiErr, func() {}
 	}
+	if enableDedup {
+		shardInfo = withoutReplicaLabels(shardInfo, replicaLabels)
+	}
 
 	lookbackDelta := qapi.lookbackDeltaCreate(maxSourceResolution)
 	// Get custom lookback delta from request.
@@ -783,7 +1041,7 @@ func (qapi *QueryAPI) queryRangeExplain(r *http.Request) (interface{}, []error,
 	if lookbackDeltaFromReq > 0 {
 		lookbackDelta = lookbackDeltaFromReq
 	}
-	queryStr, _, ctx, err := tenancy.RewritePromQL(ctx, r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField, qapi.enforceTenancy, qapi.tenantLabel, queryParam)
+	queryStr, _, ctx, err := qapi.rewritePromQL(ctx, r, queryParam)
 	if err != nil {
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
@@ -900,6 +1158,9 @@ func (qapi *QueryAPI) queryRange(r *http.Request) (interface{}, []error, *api.Ap
 	if apiErr != nil {
 		return nil, nil, apiErr, func() {}
 	}
+	if enableDedup {
+		shardInfo = withoutReplicaLabels(shardInfo, replicaLabels)
+	}
 
 	engineParam, apiErr := qapi.parseEngineParam(r)
 	if apiErr != nil {
@@ -916,7 +1177,7 @@ func (qapi *QueryAPI) queryRange(r *http.Request) (interface{}, []error, *api.Ap
 	if lookbackDeltaFromReq > 0 {
 		lookbackDelta = lookbackDeltaFromReq
 	}
-	queryStr, tenant, ctx, err := tenancy.RewritePromQL(ctx, r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField, qapi.enforceTenancy, qapi.tenantLabel, queryParam)
+	queryStr, tenant, ctx, err := qapi.rewritePromQL(ctx, r, queryParam)
 	if err != nil {
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
@@ -952,16 +1213,48 @@ func (qapi *QueryAPI) queryRange(r *http.Request) (interface{}, []error, *api.Ap
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
 
-	analysis, err := qapi.parseQueryAnalyzeParam(r, qry)
-	if err != nil {
-		return nil, nil, apiErr, func() {}
+	analyze, apiErr := qapi.parseQueryAnalyzeParam(r, qry)
+	if apiErr != nil {
+		return nil, nil, apiErr, qry.Close
+	}
+
+	stream, apiErr := qapi.parseStreamParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr, qry.Close
+	}
+
+	var (
+		estimatedCost *query.Cost
+		admitted      func()
+	)
+	tracing.DoInSpan(ctx, "range_query_admit", func(ctx context.Context) {
+		estimatedCost, admitted, apiErr = qapi.admitQuery(ctx, tenant, query.CostEstimateRequest{
+			Query:                 queryStr,
+			Start:                 start,
+			End:                   end,
+			Interval:              step,
+			LookbackDelta:         lookbackDelta,
+			MaxResolutionMillis:   maxSourceResolution,
+			EnablePartialResponse: enablePartialResponse,
+			ShardInfo:             shardInfo,
+		})
+	})
+	if apiErr != nil {
+		return nil, nil, apiErr, qry.Close
 	}
+	defer admitted()
 
 	if err := tracing.DoInSpanWithErr(ctx, "query_gate_ismyturn", qapi.gate.Start); err != nil {
 		return nil, nil, &api.ApiError{Typ: api.ErrorExec, Err: err}, qry.Close
 	}
 	defer qapi.gate.Done()
 
+	var fanout *store.FanoutTracker
+	if analyze {
+		fanout = store.NewFanoutTracker()
+		ctx = store.WithFanoutTracker(ctx, fanout)
+	}
+
 	var res *promql.Result
 	tracing.DoInSpan(ctx, "range_query_exec", func(ctx context.Context) {
 		res = qry.Exec(ctx)
@@ -982,18 +1275,24 @@ func (qapi *QueryAPI) queryRange(r *http.Request) (interface{}, []error, *api.Ap
 		aggregator.Aggregate(seriesStats[i])
 	}
 	aggregator.Observe(time.Since(beforeRange).Seconds())
+	auditResult(r.Context(), res.Value, seriesStats)
 
-	// Optional stats field in response if parameter "stats" is not empty.
-	var qs stats.QueryStats
-	if r.FormValue(Stats) != "" {
-		qs = stats.NewQueryStats(qry.Stats())
+	var analysis queryTelemetry
+	if analyze {
+		analysis = analyzeQuery(qry, fanout)
 	}
-	return &queryData{
+
+	// Optional stats field in response if parameter "stats" is not empty.
+	data := &queryData{
 		ResultType:    res.Value.Type(),
 		Result:        res.Value,
-		Stats:         qs,
+		Stats:         qapi.newQueryStats(r, qry, estimatedCost, seriesStats),
 		QueryAnalysis: analysis,
-	}, res.Warnings.AsErrors(), nil, qry.Close
+	}
+	if stream {
+		return streamQueryData(data), res.Warnings.AsErrors(), nil, qry.Close
+	}
+	return data, res.Warnings.AsErrors(), nil, qry.Close
 }
 
 func (qapi *QueryAPI) labelValues(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
@@ -1024,7 +1323,12 @@ func (qapi *QueryAPI) labelValues(r *http.Request) (interface{}, []error, *api.A
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
 
-	matcherSets, ctx, err := tenancy.RewriteLabelMatchers(ctx, r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField, qapi.enforceTenancy, qapi.tenantLabel, r.Form[MatcherParam])
+	shardInfo, apiErr := qapi.parseShardInfo(r)
+	if apiErr != nil {
+		return nil, nil, apiErr, func() {}
+	}
+
+	matcherSets, ctx, err := qapi.rewriteLabelMatchers(ctx, r, r.Form[MatcherParam])
 	if err != nil {
 		apiErr = &api.ApiError{Typ: api.ErrorBadData, Err: err}
 		return nil, nil, apiErr, func() {}
@@ -1037,7 +1341,7 @@ func (qapi *QueryAPI) labelValues(r *http.Request) (interface{}, []error, *api.A
 		0,
 		enablePartialResponse,
 		true,
-		nil,
+		shardInfo,
 		query.NoopSeriesStatsReporter,
 	).Querier(timestamp.FromTime(start), timestamp.FromTime(end))
 	if err != nil {
@@ -1053,7 +1357,12 @@ func (qapi *QueryAPI) labelValues(r *http.Request) (interface{}, []error, *api.A
 		vals     []string
 		warnings annotations.Annotations
 	)
-	if len(matcherSets) > 0 {
+	if shardInfo != nil {
+		vals, warnings, err = labelsOfSeries(ctx, q, start, end, matcherSets, name)
+		if err != nil {
+			return nil, nil, &api.ApiError{Typ: api.ErrorExec, Err: err}, func() {}
+		}
+	} else if len(matcherSets) > 0 {
 		var callWarnings annotations.Annotations
 		labelValuesSet := make(map[string]struct{})
 		for _, matchers := range matcherSets {
@@ -1105,7 +1414,7 @@ func (qapi *QueryAPI) series(r *http.Request) (interface{}, []error, *api.ApiErr
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
 
-	matcherSets, ctx, err := tenancy.RewriteLabelMatchers(r.Context(), r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField, qapi.enforceTenancy, qapi.tenantLabel, r.Form[MatcherParam])
+	matcherSets, ctx, err := qapi.rewriteLabelMatchers(r.Context(), r, r.Form[MatcherParam])
 	if err != nil {
 		apiErr := &api.ApiError{Typ: api.ErrorBadData, Err: err}
 		return nil, nil, apiErr, func() {}
@@ -1136,6 +1445,19 @@ func (qapi *QueryAPI) series(r *http.Request) (interface{}, []error, *api.ApiErr
 		return nil, nil, apiErr, func() {}
 	}
 
+	stream, apiErr := qapi.parseStreamParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr, func() {}
+	}
+
+	shardInfo, apiErr := qapi.parseShardInfo(r)
+	if apiErr != nil {
+		return nil, nil, apiErr, func() {}
+	}
+	if enableDedup {
+		shardInfo = withoutReplicaLabels(shardInfo, replicaLabels)
+	}
+
 	q, err := qapi.queryableCreate(
 		enableDedup,
 		replicaLabels,
@@ -1143,14 +1465,14 @@ func (qapi *QueryAPI) series(r *http.Request) (interface{}, []error, *api.ApiErr
 		math.MaxInt64,
 		enablePartialResponse,
 		true,
-		nil,
+		shardInfo,
 		query.NoopSeriesStatsReporter,
 	).Querier(timestamp.FromTime(start), timestamp.FromTime(end))
 
 	if err != nil {
 		return nil, nil, &api.ApiError{Typ: api.ErrorExec, Err: err}, func() {}
 	}
-	defer runutil.CloseWithLogOnErr(qapi.logger, q, "queryable series")
+	closeQuerier := func() { runutil.CloseWithLogOnErr(qapi.logger, q, "queryable series") }
 
 	var (
 		metrics = []labels.Labels{}
@@ -1168,6 +1490,12 @@ func (qapi *QueryAPI) series(r *http.Request) (interface{}, []error, *api.ApiErr
 	}
 
 	set := storage.NewMergeSeriesSet(sets, 0, storage.ChainedSeriesMerge)
+	if stream {
+		// The series are selected as they are written, so the querier is only closed once the response was sent.
+		return &api.StreamedData{Items: &seriesIterator{set: set, limit: limit}}, nil, nil, closeQuerier
+	}
+	defer closeQuerier()
+
 	warnings := set.Warnings()
 	for set.Next() {
 		metrics = append(metrics, set.At().Labels())
@@ -1180,9 +1508,82 @@ func (qapi *QueryAPI) series(r *http.Request) (interface{}, []error, *api.ApiErr
 	if set.Err() != nil {
 		return nil, nil, &api.ApiError{Typ: api.ErrorExec, Err: set.Err()}, func() {}
 	}
+	audit.SetSeries(r.Context(), len(metrics))
 	return metrics, warnings.AsErrors(), nil, func() {}
 }
 
+// withoutReplicaLabels excludes the replica labels from the hash of the series of a shard not grouped by labels, so
+// that the replicas of a series are deduplicated within the same shard instead of being counted once per shard.
+func withoutReplicaLabels(info *storepb.ShardInfo, replicaLabels []string) *storepb.ShardInfo {
+	if info == nil || info.By || len(replicaLabels) == 0 {
+		return info
+	}
+	return &storepb.ShardInfo{
+		ShardIndex:  info.ShardIndex,
+		TotalShards: info.TotalShards,
+		Labels:      append(slices.Clone(info.Labels), replicaLabels...),
+	}
+}
+
+// labelsOfSeries returns the sorted label names of the series matching the matcher sets, or the values of the label
+// name if set. The labels of a shard are the ones of its series, stores do not shard their label indexes.
+func labelsOfSeries(ctx context.Context, q storage.Querier, start, end time.Time, matcherSets [][]*labels.Matcher, name string) ([]string, annotations.Annotations, error) {
+	if len(matcherSets) == 0 {
+		matcherSets = [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")}}
+	}
+	hints := &storage.SelectHints{
+		Start: start.UnixMilli(),
+		End:   end.UnixMilli(),
+		Func:  "series",
+	}
+
+	sets := make([]storage.SeriesSet, 0, len(matcherSets))
+	for _, mset := range matcherSets {
+		sets = append(sets, q.Select(ctx, false, hints, mset...))
+	}
+	set := storage.NewMergeSeriesSet(sets, 0, storage.ChainedSeriesMerge)
+
+	found := make(map[string]struct{})
+	for set.Next() {
+		set.At().Labels().Range(func(l labels.Label) {
+			if name == "" {
+				found[l.Name] = struct{}{}
+			} else if l.Name == name {
+				found[l.Value] = struct{}{}
+			}
+		})
+	}
+	if err := set.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	vals := make([]string, 0, len(found))
+	for val := range found {
+		vals = append(vals, val)
+	}
+	sort.Strings(vals)
+	return vals, set.Warnings(), nil
+}
+
+// auditResult reports the number of series returned by a query and the bytes of the chunks fetched for it to the audit log.
+func auditResult(ctx context.Context, val parser.Value, seriesStats []storepb.SeriesStatsCounter) {
+	var series int
+	switch v := val.(type) {
+	case promql.Vector:
+		series = len(v)
+	case promql.Matrix:
+		series = len(v)
+	case promql.Scalar, promql.String:
+		series = 1
+	}
+	var bytesFetched int64
+	for i := range seriesStats {
+		bytesFetched += int64(seriesStats[i].Bytes)
+	}
+	audit.SetSeries(ctx, series)
+	audit.SetBytesFetched(ctx, bytesFetched)
+}
+
 func (qapi *QueryAPI) labelNames(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
 	start, end, err := parseMetadataTimeRange(r, qapi.defaultMetadataTimeRange)
 	if err != nil {
@@ -1204,7 +1605,12 @@ func (qapi *QueryAPI) labelNames(r *http.Request) (interface{}, []error, *api.Ap
 		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
 	}
 
-	matcherSets, ctx, err := tenancy.RewriteLabelMatchers(r.Context(), r, qapi.tenantHeader, qapi.defaultTenant, qapi.tenantCertField, qapi.enforceTenancy, qapi.tenantLabel, r.Form[MatcherParam])
+	shardInfo, apiErr := qapi.parseShardInfo(r)
+	if apiErr != nil {
+		return nil, nil, apiErr, func() {}
+	}
+
+	matcherSets, ctx, err := qapi.rewriteLabelMatchers(r.Context(), r, r.Form[MatcherParam])
 	if err != nil {
 		apiErr := &api.ApiError{Typ: api.ErrorBadData, Err: err}
 		return nil, nil, apiErr, func() {}
@@ -1217,7 +1623,7 @@ func (qapi *QueryAPI) labelNames(r *http.Request) (interface{}, []error, *api.Ap
 		0,
 		enablePartialResponse,
 		true,
-		nil,
+		shardInfo,
 		query.NoopSeriesStatsReporter,
 	).Querier(timestamp.FromTime(start), timestamp.FromTime(end))
 	if err != nil {
@@ -1234,7 +1640,9 @@ func (qapi *QueryAPI) labelNames(r *http.Request) (interface{}, []error, *api.Ap
 		Limit: toHintLimit(limit),
 	}
 
-	if len(matcherSets) > 0 {
+	if shardInfo != nil {
+		names, warnings, err = labelsOfSeries(ctx, q, start, end, matcherSets, "")
+	} else if len(matcherSets) > 0 {
 		var callWarnings annotations.Annotations
 		labelNamesSet := make(map[string]struct{})
 		for _, matchers := range matcherSets {
@@ -26,6 +26,8 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -50,6 +52,10 @@ import (
 	promgate "github.com/prometheus/prometheus/util/gate"
 	"github.com/prometheus/prometheus/util/stats"
 	"github.com/thanos-io/promql-engine/engine"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
 	baseAPI "github.com/thanos-io/thanos/pkg/api"
 	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/component"
@@ -792,6 +798,173 @@ func TestQueryAnalyzeEndpoints(t *testing.T) {
 	}
 }
 
+func TestQueryAnalyzeEndpoints_StoreFanout(t *testing.T) {
+	db, err := e2eutil.NewTSDB()
+	defer func() { testutil.Ok(t, db.Close()) }()
+	testutil.Ok(t, err)
+
+	app := db.Appender(context.Background())
+	for i := int64(0); i < 10; i++ {
+		_, err := app.Append(0, labels.FromStrings("__name__", "test_metric", "foo", "bar"), i*1000, float64(i))
+		testutil.Ok(t, err)
+	}
+	testutil.Ok(t, app.Commit())
+
+	stores := []store.Client{
+		&storetestutil.TestClient{
+			Name:        "1",
+			StoreClient: storepb.ServerAsClient(store.NewTSDBStore(nil, db, component.Query, nil)),
+			MinTime:     math.MinInt64, MaxTime: math.MaxInt64,
+		},
+		&storetestutil.TestClient{Name: "2", MinTime: math.MaxInt64 - 1, MaxTime: math.MaxInt64},
+	}
+	proxy := store.NewProxyStore(nil, nil, func() []store.Client { return stores }, component.Query, nil, 0, store.EagerRetrieval)
+
+	api := &QueryAPI{
+		baseAPI: &baseAPI.BaseAPI{
+			Now: time.Now,
+		},
+		queryableCreate:       query.NewQueryableCreator(nil, nil, proxy, 2, 100*time.Second, dedup.AlgorithmPenalty),
+		remoteEndpointsCreate: emptyRemoteEndpointsCreate,
+		queryCreate:           queryFactory,
+		defaultEngine:         PromqlEnginePrometheus,
+		lookbackDeltaCreate:   func(m int64) time.Duration { return time.Duration(0) },
+		gate:                  gate.New(nil, 4, gate.Queries),
+		defaultRangeQueryStep: time.Second,
+		queryRangeHist: promauto.With(prometheus.NewRegistry()).NewHistogram(prometheus.HistogramOpts{
+			Name: "query_range_hist",
+		}),
+		seriesStatsAggregatorFactory: &store.NoopSeriesStatsAggregatorFactory{},
+		tenantHeader:                 "thanos-tenant",
+		defaultTenant:                "default-tenant",
+	}
+
+	t.Run("prometheus engine", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com?"+url.Values{
+			"query":           []string{"test_metric"},
+			"time":            []string{"5"},
+			QueryAnalyzeParam: []string{"true"},
+		}.Encode(), nil)
+		testutil.Ok(t, err)
+
+		_, _, apiErr, releaseResources := api.query(req)
+		defer releaseResources()
+		testutil.Assert(t, apiErr != nil)
+		testutil.Equals(t, baseAPI.ErrorBadData, apiErr.Typ)
+	})
+
+	for _, endpoint := range []struct {
+		name string
+		f    baseAPI.ApiFunc
+		q    url.Values
+	}{
+		{name: "instant query", f: api.query, q: url.Values{"time": []string{"5"}}},
+		{name: "range query", f: api.queryRange, q: url.Values{"start": []string{"0"}, "end": []string{"9"}, "step": []string{"1"}}},
+	} {
+		t.Run(endpoint.name, func(t *testing.T) {
+			q := endpoint.q
+			q.Set("query", `sum(test_metric{foo="bar"})`)
+			q.Set(EngineParam, string(PromqlEngineThanos))
+			q.Set(QueryAnalyzeParam, "true")
+			req, err := http.NewRequest(http.MethodGet, "http://example.com?"+q.Encode(), nil)
+			testutil.Ok(t, err)
+
+			res, _, apiErr, releaseResources := endpoint.f(req)
+			defer releaseResources()
+			testutil.Assert(t, apiErr == nil, "unexpected error: %v", apiErr)
+
+			var selectors []queryTelemetry
+			var walk func(n queryTelemetry)
+			walk = func(n queryTelemetry) {
+				if len(n.Stores) > 0 {
+					selectors = append(selectors, n)
+				}
+				for _, c := range n.Children {
+					walk(c)
+				}
+			}
+			walk(res.(*queryData).QueryAnalysis)
+
+			testutil.Equals(t, 1, len(selectors))
+			testutil.Assert(t, strings.HasPrefix(selectors[0].OperatorName, "[vectorSelector]"), selectors[0].OperatorName)
+			testutil.Equals(t, 2, len(selectors[0].Stores))
+
+			pruned, queried := selectors[0].Stores[0], selectors[0].Stores[1]
+			testutil.Equals(t, "2", pruned.Name)
+			testutil.Equals(t, "does not have data within this time period", pruned.PrunedReason)
+			testutil.Equals(t, "1", queried.Name)
+			testutil.Equals(t, "", queried.PrunedReason)
+			testutil.Equals(t, 1, queried.Series)
+			testutil.Assert(t, queried.Execution != "")
+		})
+	}
+}
+
+func TestAttachFanout(t *testing.T) {
+	selects := []store.SelectFanout{
+		{
+			Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "a", "b"), labels.MustNewMatcher(labels.MatchRegexp, "c", `d e"]}`)},
+			Stores:   []*store.StoreFanout{{Store: "long", Err: status.Error(codes.Canceled, "canceled")}},
+		},
+		{
+			Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "a", "b")},
+			Stores:   []*store.StoreFanout{{Store: "short"}},
+		},
+		{
+			Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotEqual, "x", "y")},
+			Stores:   []*store.StoreFanout{{Store: "pushed down"}},
+		},
+	}
+	tree := queryTelemetry{
+		OperatorName: "[sum]",
+		Children: []queryTelemetry{
+			{OperatorName: `[vectorSelector] {[a="b" c=~"d e\"]}"]} 0 mod 1`},
+			// Selects of filtered selectors only carry the matchers preceding the filters.
+			{OperatorName: `[matrixSelector] {[a="b" f="g"]} 0 mod 1`},
+			{OperatorName: `[vectorSelector] {[a="b"]} 0 mod 1`},
+		},
+	}
+
+	remaining := attachFanout(&tree, selects)
+	testutil.Equals(t, 1, len(remaining))
+	testutil.Equals(t, "pushed down", remaining[0].Stores[0].Store)
+
+	testutil.Equals(t, []storeTelemetry{{Name: "short", Execution: "0s"}}, tree.Children[1].Stores)
+	testutil.Equals(t, []storeTelemetry{{Name: "long", Execution: "0s", Error: "rpc error: code = Canceled desc = canceled", ErrorCode: "Canceled"}}, tree.Children[0].Stores)
+	testutil.Equals(t, 0, len(tree.Children[2].Stores))
+}
+
+func TestSelectorMatchers(t *testing.T) {
+	for _, tc := range []struct {
+		operator string
+		matchers []*labels.Matcher
+		ok       bool
+	}{
+		{operator: "[sum]"},
+		{operator: `[vectorSelector] {[]} 0 mod 1`, ok: true},
+		{
+			operator: `[vectorSelector] {[__name__="up" job=~"a|b"]} 1 mod 2`,
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up"), labels.MustNewMatcher(labels.MatchRegexp, "job", "a|b")},
+			ok:       true,
+		},
+		{
+			operator: `[matrixSelector] {["utf8.name"!="x y" b!~"c"]} 0 mod 1`,
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotEqual, "utf8.name", "x y"), labels.MustNewMatcher(labels.MatchNotRegexp, "b", "c")},
+			ok:       true,
+		},
+		{operator: `[vectorSelector] {[a="b`},
+	} {
+		t.Run(tc.operator, func(t *testing.T) {
+			matchers, ok := selectorMatchers(tc.operator)
+			testutil.Equals(t, tc.ok, ok)
+			testutil.Equals(t, len(tc.matchers), len(matchers))
+			for i := range tc.matchers {
+				testutil.Assert(t, matcherEqual(tc.matchers[i], matchers[i]), "%v != %v", tc.matchers[i], matchers[i])
+			}
+		})
+	}
+}
+
 func newProxyStoreWithTSDBStore(db store.TSDBReader) *store.ProxyStore {
 	c := &storetestutil.TestClient{
 		Name:        "1",
@@ -810,6 +983,17 @@ func newProxyStoreWithTSDBStore(db store.TSDBReader) *store.ProxyStore {
 	)
 }
 
+// withReplicaLabelsStore ignores the replica labels to trim of the Series requests.
+type withReplicaLabelsStore struct {
+	storepb.StoreClient
+}
+
+func (s withReplicaLabelsStore) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	r := *req
+	r.WithoutReplicaLabels = nil
+	return s.StoreClient.Series(ctx, &r, opts...)
+}
+
 func TestMetadataEndpoints(t *testing.T) {
 	var old = []labels.Labels{
 		{
@@ -1417,6 +1601,155 @@ func TestMetadataEndpoints(t *testing.T) {
 			return
 		}
 	}
+
+	t.Run("streamed series", func(t *testing.T) {
+		q := url.Values{
+			"match[]":   []string{`{foo=~"b.+"}`},
+			"limit":     []string{"2"},
+			StreamParam: []string{"true"},
+		}
+		req, err := http.NewRequest(http.MethodGet, "http://example.com?"+q.Encode(), nil)
+		testutil.Ok(t, err)
+
+		resp, _, apiErr, releaseResources := api.series(req)
+		testutil.Assert(t, apiErr == nil, "unexpected error: %v", apiErr)
+		defer releaseResources()
+
+		streamed, ok := resp.(*baseAPI.StreamedData)
+		testutil.Assert(t, ok, "expected streamed data, got %T", resp)
+
+		var res []labels.Labels
+		for streamed.Items.Next() {
+			res = append(res, streamed.Items.At().(labels.Labels))
+		}
+		testutil.Ok(t, streamed.Items.Err())
+		testutil.Equals(t, 2, len(res))
+		testutil.Equals(t, 1, len(streamed.Items.Warnings()))
+	})
+
+	t.Run("sharded series and labels", func(t *testing.T) {
+		call := func(endpoint baseAPI.ApiFunc, q url.Values, labelName string) interface{} {
+			ctx := route.WithParam(context.Background(), "name", labelName)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com?"+q.Encode(), nil)
+			testutil.Ok(t, err)
+			resp, _, apiErr, releaseResources := endpoint(req)
+			testutil.Assert(t, apiErr == nil, "unexpected error: %v", apiErr)
+			releaseResources()
+			return resp
+		}
+
+		q := url.Values{
+			"match[]":         []string{`{foo=~"b.+"}`},
+			"replicaLabels[]": []string{"replica"},
+		}
+		expSeries := call(api.series, q, "").([]labels.Labels)
+		expNames := call(api.labelNames, q, "").([]string)
+		expValues := call(api.labelValues, q, "__name__").([]string)
+
+		var (
+			gotSeries      []labels.Labels
+			names, values  = map[string]struct{}{}, map[string]struct{}{}
+			shardedRequest = func(i int64) url.Values {
+				info, err := json.Marshal(storepb.ShardInfo{TotalShards: 2, ShardIndex: i})
+				testutil.Ok(t, err)
+				sq := url.Values{ShardInfoParam: []string{string(info)}}
+				for k, v := range q {
+					sq[k] = v
+				}
+				return sq
+			}
+		)
+		for i := int64(0); i < 2; i++ {
+			// The replicas of a series are in the same shard, and deduplicated.
+			gotSeries = append(gotSeries, call(api.series, shardedRequest(i), "").([]labels.Labels)...)
+			for _, n := range call(api.labelNames, shardedRequest(i), "").([]string) {
+				names[n] = struct{}{}
+			}
+			for _, v := range call(api.labelValues, shardedRequest(i), "__name__").([]string) {
+				values[v] = struct{}{}
+			}
+		}
+		sort.Slice(gotSeries, func(i, j int) bool { return labels.Compare(gotSeries[i], gotSeries[j]) < 0 })
+		testutil.Equals(t, expSeries, gotSeries)
+		testutil.Equals(t, len(expNames), len(names))
+		for _, n := range expNames {
+			_, ok := names[n]
+			testutil.Assert(t, ok, "missing label name %s", n)
+		}
+		testutil.Equals(t, len(expValues), len(values))
+		for _, v := range expValues {
+			_, ok := values[v]
+			testutil.Assert(t, ok, "missing label value %s", v)
+		}
+	})
+
+	t.Run("sharded queries count the replicas once", func(t *testing.T) {
+		// The store hashes the series with their replica labels, like stores not supporting trimming them.
+		c := &storetestutil.TestClient{
+			Name:        "1",
+			StoreClient: withReplicaLabelsStore{storepb.ServerAsClient(store.NewTSDBStore(nil, db, component.Query, nil))},
+			MinTime:     math.MinInt64, MaxTime: math.MaxInt64,
+			Shardable: true,
+		}
+		proxy := store.NewProxyStore(nil, nil, func() []store.Client { return []store.Client{c} }, component.Query, nil, 0, store.EagerRetrieval)
+		queryableCreate := api.queryableCreate
+		api.queryableCreate = query.NewQueryableCreator(nil, nil, proxy, 2, timeout, dedup.AlgorithmPenalty)
+		defer func() { api.queryableCreate = queryableCreate }()
+
+		count := func(q url.Values) float64 {
+			req, err := http.NewRequest(http.MethodGet, "http://example.com?"+q.Encode(), nil)
+			testutil.Ok(t, err)
+			resp, _, apiErr, releaseResources := api.query(req)
+			testutil.Assert(t, apiErr == nil, "unexpected error: %v", apiErr)
+			defer releaseResources()
+
+			var res float64
+			for _, s := range resp.(*queryData).Result.(promql.Vector) {
+				res += s.F
+			}
+			return res
+		}
+
+		q := url.Values{
+			"query":           []string{`count(test_metric_replica1)`},
+			"time":            []string{strconv.FormatInt(start/1000+300, 10)},
+			"dedup":           []string{"true"},
+			"replicaLabels[]": []string{"replica"},
+		}
+		testutil.Equals(t, 2.0, count(q))
+
+		for shards := int64(2); shards <= 8; shards++ {
+			var res float64
+			for i := int64(0); i < shards; i++ {
+				info, err := json.Marshal(storepb.ShardInfo{TotalShards: shards, ShardIndex: i})
+				testutil.Ok(t, err)
+				sq := url.Values{ShardInfoParam: []string{string(info)}}
+				for k, v := range q {
+					sq[k] = v
+				}
+				res += count(sq)
+			}
+			testutil.Equals(t, 2.0, res, "%d shards", shards)
+		}
+	})
+}
+
+func TestMatrixIterator(t *testing.T) {
+	m := promql.Matrix{
+		{Metric: labels.FromStrings("a", "1"), Floats: []promql.FPoint{{T: 1, F: 1}}},
+		{Metric: labels.FromStrings("a", "2"), Floats: []promql.FPoint{{T: 1, F: 2}}},
+	}
+	it := &matrixIterator{m: m, i: -1}
+
+	testutil.Assert(t, it.Next())
+	testutil.Equals(t, labels.FromStrings("a", "1"), it.At().(promql.Series).Metric)
+	testutil.Assert(t, it.Next())
+	testutil.Equals(t, labels.FromStrings("a", "2"), it.At().(promql.Series).Metric)
+	// The written series are released.
+	testutil.Equals(t, promql.Series{}, m[0])
+	testutil.Assert(t, !it.Next())
+	testutil.Equals(t, promql.Series{}, m[1])
+	testutil.Assert(t, !it.Next())
 }
 
 func TestStoresEndpoint(t *testing.T) {
@@ -64,7 +64,7 @@ func New(opts Options) *StatusAPI {
 }
 
 func (sapi *StatusAPI) Register(r *route.Router, tracer opentracing.Tracer, logger log.Logger, ins extpromhttp.InstrumentationMiddleware, logMiddleware *logging.HTTPServerMiddleware) {
-	instr := api.GetInstr(tracer, logger, ins, logMiddleware, false)
+	instr := api.GetInstr(tracer, logger, ins, logMiddleware, nil, false)
 	r.Get("/api/v1/status/tsdb", instr("tsdb_status", sapi.httpServeStats))
 }
 
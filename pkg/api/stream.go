@@ -0,0 +1,162 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// streamBufferSize is the size of the buffer of streamed responses. The buffer is flushed to the client
+	// as soon as it fills up.
+	streamBufferSize = 64 * 1024
+
+	// StreamedResponseHeader is set on streamed responses.
+	StreamedResponseHeader = "X-Thanos-Streamed-Response"
+)
+
+// Iterator iterates over the items of streamed response data.
+type Iterator interface {
+	Next() bool
+	At() interface{}
+	// Err returns the error which stopped the iteration, if any. An *ApiError sets the type of the error.
+	Err() error
+	// Warnings returns the warnings gathered while iterating.
+	Warnings() []error
+}
+
+// StreamedField is a field of streamed response data, written after the items.
+type StreamedField struct {
+	Name  string
+	Value interface{}
+}
+
+// StreamedData is response data written item by item, with a bounded buffer flushed to the client as it fills up,
+// rather than marshaled at once.
+//
+// Streamed responses are regular JSON API responses in which the data field comes first. The status, error and
+// warnings fields follow the data as a trailer, so that errors happening once part of the data was sent are
+// signalled with the "error" status:
+//
+//	{"data":{"resultType":"matrix","result":[...]},"status":"error","errorType":"execution","error":"...","warnings":[...]}
+type StreamedData struct {
+	// ResultType is the type of query results, written with the items as result field of the data object.
+	// If empty, the data is the array of items.
+	ResultType string
+	Items      Iterator
+	// Fields are the additional fields of the data object, written after the results. Ignored if ResultType is empty.
+	Fields []StreamedField
+}
+
+// RespondStream writes the streamed data and the trailer of the response.
+func RespondStream(w http.ResponseWriter, data *StreamedData, warnings []error, logger log.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(StreamedResponseHeader, "true")
+	// Errors and warnings are only known once the data was sent.
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	stream := jsoniter.ConfigCompatibleWithStandardLibrary.BorrowStream(w)
+	defer jsoniter.ConfigCompatibleWithStandardLibrary.ReturnStream(stream)
+
+	flush := func() error {
+		if err := stream.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	stream.WriteObjectStart()
+	stream.WriteObjectField("data")
+	if data.ResultType != "" {
+		stream.WriteObjectStart()
+		stream.WriteObjectField("resultType")
+		stream.WriteString(data.ResultType)
+		stream.WriteMore()
+		stream.WriteObjectField("result")
+	}
+	stream.WriteArrayStart()
+	// Send the headers and the beginning of the response right away.
+	if err := flush(); err != nil {
+		level.Error(logger).Log("msg", "error writing response", "err", err)
+		return
+	}
+
+	first := true
+	for data.Items.Next() {
+		if !first {
+			stream.WriteMore()
+		}
+		first = false
+
+		stream.WriteVal(data.Items.At())
+		if stream.Error != nil {
+			level.Error(logger).Log("msg", "error marshaling response", "err", stream.Error)
+			return
+		}
+		if stream.Buffered() >= streamBufferSize {
+			if err := flush(); err != nil {
+				level.Error(logger).Log("msg", "error writing response", "err", err)
+				return
+			}
+		}
+	}
+	stream.WriteArrayEnd()
+	if data.ResultType != "" {
+		for _, f := range data.Fields {
+			if f.Value == nil {
+				continue
+			}
+			stream.WriteMore()
+			stream.WriteObjectField(f.Name)
+			stream.WriteVal(f.Value)
+		}
+		stream.WriteObjectEnd()
+	}
+
+	stream.WriteMore()
+	stream.WriteObjectField("status")
+	if err := data.Items.Err(); err != nil {
+		apiErr := &ApiError{Typ: ErrorExec, Err: err}
+		errors.As(err, &apiErr)
+
+		stream.WriteString(string(StatusError))
+		stream.WriteMore()
+		stream.WriteObjectField("errorType")
+		stream.WriteString(string(apiErr.Typ))
+		stream.WriteMore()
+		stream.WriteObjectField("error")
+		stream.WriteString(apiErr.Err.Error())
+	} else {
+		stream.WriteString(string(StatusSuccess))
+	}
+
+	warnings = append(warnings, data.Items.Warnings()...)
+	if len(warnings) > 0 {
+		stream.WriteMore()
+		stream.WriteObjectField("warnings")
+		stream.WriteArrayStart()
+		for i, warn := range warnings {
+			if i > 0 {
+				stream.WriteMore()
+			}
+			stream.WriteString(warn.Error())
+		}
+		stream.WriteArrayEnd()
+	}
+	stream.WriteObjectEnd()
+
+	if err := flush(); err != nil {
+		level.Error(logger).Log("msg", "error writing response", "err", err)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+type sliceIterator struct {
+	items    []interface{}
+	i        int
+	err      error
+	warnings []error
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.i >= len(it.items) {
+		return false
+	}
+	it.i++
+	return true
+}
+
+func (it *sliceIterator) At() interface{}   { return it.items[it.i-1] }
+func (it *sliceIterator) Err() error        { return it.err }
+func (it *sliceIterator) Warnings() []error { return it.warnings }
+
+func TestRespondStream(t *testing.T) {
+	type response struct {
+		Status    string              `json:"status"`
+		Data      jsoniter.RawMessage `json:"data"`
+		ErrorType ErrorType           `json:"errorType"`
+		Error     string              `json:"error"`
+		Warnings  []string            `json:"warnings"`
+	}
+
+	// Items larger than the buffer are flushed while streaming.
+	large := strings.Repeat("a", streamBufferSize)
+
+	for _, tcase := range []struct {
+		name     string
+		data     *StreamedData
+		warnings []error
+
+		expected     response
+		expectedData string
+	}{
+		{
+			name: "results",
+			data: &StreamedData{
+				ResultType: "matrix",
+				Items:      &sliceIterator{items: []interface{}{1, large, 3}},
+				Fields:     []StreamedField{{Name: "stats", Value: map[string]int{"samples": 3}}, {Name: "analysis"}},
+			},
+			expected:     response{Status: "success"},
+			expectedData: `{"resultType":"matrix","result":[1,"` + large + `",3],"stats":{"samples":3}}`,
+		},
+		{
+			name:         "series",
+			data:         &StreamedData{Items: &sliceIterator{warnings: []error{errors.New("truncated")}}},
+			warnings:     []error{errors.New("partial response")},
+			expected:     response{Status: "success", Warnings: []string{"partial response", "truncated"}},
+			expectedData: `[]`,
+		},
+		{
+			name:         "error after the first items",
+			data:         &StreamedData{Items: &sliceIterator{items: []interface{}{"a", "b"}, err: errors.New("receive series")}},
+			expected:     response{Status: "error", ErrorType: ErrorExec, Error: "receive series"},
+			expectedData: `["a","b"]`,
+		},
+		{
+			name:         "typed error",
+			data:         &StreamedData{Items: &sliceIterator{err: &ApiError{Typ: ErrorTimeout, Err: errors.New("deadline exceeded")}}},
+			expected:     response{Status: "error", ErrorType: ErrorTimeout, Error: "deadline exceeded"},
+			expectedData: `[]`,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			RespondStream(w, tcase.data, tcase.warnings, log.NewNopLogger())
+
+			testutil.Equals(t, http.StatusOK, w.Code)
+			testutil.Equals(t, "true", w.Header().Get(StreamedResponseHeader))
+			testutil.Equals(t, "no-store", w.Header().Get("Cache-Control"))
+
+			var resp response
+			testutil.Ok(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			testutil.Equals(t, tcase.expectedData, string(resp.Data))
+			resp.Data = nil
+			testutil.Equals(t, tcase.expected, resp)
+		})
+	}
+}
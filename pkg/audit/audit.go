@@ -0,0 +1,387 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package audit records the requests served by the Querier and query-frontend to an audit log.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+// maxBodySize is the maximum size of the form bodies parsed to record the parameters of POST requests.
+const maxBodySize = 1 << 20
+
+// Config is the configuration of the audit log.
+type Config struct {
+	// UserHeader is the HTTP header the user is read from. Falls back to the basic auth user.
+	UserHeader string `yaml:"user_header"`
+	// File writes the records as JSON lines to a file rotated by size.
+	File *FileConfig `yaml:"file"`
+	// Webhook sends the records as JSON lines to an HTTP endpoint.
+	Webhook    *WebhookConfig    `yaml:"webhook"`
+	Sampling   SamplingConfig    `yaml:"sampling"`
+	Redactions []RedactionConfig `yaml:"redactions"`
+}
+
+// FileConfig configures the file the records are written to.
+type FileConfig struct {
+	Path string `yaml:"path"`
+	// MaxSizeMB is the size above which the file is rotated. Zero disables rotation.
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// WebhookConfig configures the HTTP endpoint the records are sent to in batches.
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout model.Duration    `yaml:"timeout"`
+	// BatchSize is the maximum number of records sent per request.
+	BatchSize int `yaml:"batch_size"`
+	// FlushInterval is the maximum time a record waits before being sent.
+	FlushInterval model.Duration `yaml:"flush_interval"`
+	// QueueSize is the number of records buffered while waiting to be sent. Records are dropped when it is full.
+	QueueSize int `yaml:"queue_size"`
+}
+
+// SamplingConfig configures which requests are recorded.
+type SamplingConfig struct {
+	// Ratio is the ratio of the requests recorded, between 0 and 1.
+	Ratio float64 `yaml:"ratio"`
+	// AlwaysRecordErrors records all the failed requests, regardless of the ratio.
+	AlwaysRecordErrors bool `yaml:"always_record_errors"`
+	// AlwaysRecordSlowerThan records all the requests slower than the given duration, regardless of the ratio.
+	// Zero disables it.
+	AlwaysRecordSlowerThan model.Duration `yaml:"always_record_slower_than"`
+}
+
+// RedactionConfig replaces the parts of the expressions and series selectors of the requests matching a regular
+// expression before they are recorded.
+type RedactionConfig struct {
+	Regex string `yaml:"regex"`
+	// Replacement can reference the capture groups of the regular expression, e.g. ${1}.
+	Replacement string `yaml:"replacement"`
+}
+
+// DefaultConfig returns the default audit log configuration, recording all the requests.
+func DefaultConfig() Config {
+	return Config{
+		Sampling: SamplingConfig{
+			Ratio:              1,
+			AlwaysRecordErrors: true,
+		},
+	}
+}
+
+// ParseConfig parses the audit log configuration.
+func ParseConfig(content []byte) (Config, error) {
+	conf := DefaultConfig()
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return Config{}, errors.Wrap(err, "parsing audit log config YAML file")
+	}
+	if conf.File == nil && conf.Webhook == nil {
+		return Config{}, errors.New("no file or webhook specified for audit log")
+	}
+	if conf.File != nil && conf.File.Path == "" {
+		return Config{}, errors.New("no path specified for audit log file")
+	}
+	if conf.Webhook != nil && conf.Webhook.URL == "" {
+		return Config{}, errors.New("no URL specified for audit log webhook")
+	}
+	if conf.Sampling.Ratio < 0 || conf.Sampling.Ratio > 1 {
+		return Config{}, errors.Errorf("sampling ratio has to be between 0 and 1, got %v", conf.Sampling.Ratio)
+	}
+	for _, r := range conf.Redactions {
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			return Config{}, errors.Wrapf(err, "parsing redaction regex %q", r.Regex)
+		}
+	}
+	return conf, nil
+}
+
+// Record describes a request served.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Component string    `json:"component"`
+	Endpoint  string    `json:"endpoint"`
+	Path      string    `json:"path"`
+	RequestID string    `json:"request_id,omitempty"`
+	Tenant    string    `json:"tenant"`
+	User      string    `json:"user,omitempty"`
+
+	Query    string   `json:"query,omitempty"`
+	Matchers []string `json:"matchers,omitempty"`
+	// Start, End, Time and Step are the time range parameters of the request, as sent by the client.
+	Start     string `json:"start,omitempty"`
+	End       string `json:"end,omitempty"`
+	QueryTime string `json:"query_time,omitempty"`
+	Step      string `json:"step,omitempty"`
+
+	// Series is the number of series returned, if known by the component.
+	Series *int `json:"series,omitempty"`
+	// BytesFetched is the size of the chunks fetched from the StoreAPI endpoints, if known by the component.
+	BytesFetched *int64 `json:"bytes_fetched,omitempty"`
+
+	DurationSeconds float64 `json:"duration_seconds"`
+	Status          string  `json:"status"`
+	StatusCode      int     `json:"status_code"`
+}
+
+type recordKey struct{}
+
+// SetSeries records the number of series returned by the request with the given context. It is a no-op if the
+// request is not audited.
+func SetSeries(ctx context.Context, series int) {
+	if rec, ok := ctx.Value(recordKey{}).(*Record); ok {
+		rec.Series = &series
+	}
+}
+
+// SetBytesFetched records the size of the chunks fetched to answer the request with the given context. It is a no-op
+// if the request is not audited.
+func SetBytesFetched(ctx context.Context, bytesFetched int64) {
+	if rec, ok := ctx.Value(recordKey{}).(*Record); ok {
+		rec.BytesFetched = &bytesFetched
+	}
+}
+
+// Log records the requests it instruments to its sinks.
+type Log struct {
+	logger    log.Logger
+	component string
+
+	tenantHeader    string
+	defaultTenant   string
+	certTenantField string
+	userHeader      string
+
+	sampling   SamplingConfig
+	redactions []redaction
+	sinks      []sink
+
+	recorded   prometheus.Counter
+	sampledOut prometheus.Counter
+	failures   *prometheus.CounterVec
+}
+
+type redaction struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// NewLog returns an audit log of the requests served by the given component.
+func NewLog(logger log.Logger, reg prometheus.Registerer, component string, conf Config, tenantHeader, defaultTenant, certTenantField string) (*Log, error) {
+	l := &Log{
+		logger:          logger,
+		component:       component,
+		tenantHeader:    tenantHeader,
+		defaultTenant:   defaultTenant,
+		certTenantField: certTenantField,
+		userHeader:      conf.UserHeader,
+		sampling:        conf.Sampling,
+
+		recorded: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_audit_records_total",
+			Help: "Total number of requests recorded in the audit log.",
+		}),
+		sampledOut: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_audit_records_sampled_out_total",
+			Help: "Total number of requests not recorded in the audit log because of sampling.",
+		}),
+		failures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_audit_sink_failures_total",
+			Help: "Total number of records which could not be written to an audit log sink.",
+		}, []string{"sink"}),
+	}
+	for _, r := range conf.Redactions {
+		l.redactions = append(l.redactions, redaction{re: regexp.MustCompile(r.Regex), replacement: r.Replacement})
+	}
+	if conf.File != nil {
+		s, err := newFileSink(*conf.File)
+		if err != nil {
+			return nil, err
+		}
+		l.sinks = append(l.sinks, s)
+	}
+	if conf.Webhook != nil {
+		l.sinks = append(l.sinks, newWebhookSink(logger, *conf.Webhook, l.failures.WithLabelValues("webhook")))
+	}
+	return l, nil
+}
+
+// HTTPMiddleware records the requests served by the handler. It returns the handler as is if the log is nil.
+func (l *Log) HTTPMiddleware(name string, next http.Handler) http.HandlerFunc {
+	if l == nil {
+		return next.ServeHTTP
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := l.newRecord(name, r)
+		sw := &statusResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		start := time.Now()
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), recordKey{}, rec)))
+		rec.DurationSeconds = time.Since(start).Seconds()
+
+		rec.StatusCode = sw.statusCode
+		rec.Status = "success"
+		if sw.statusCode >= 400 {
+			rec.Status = "error"
+		}
+		l.record(rec)
+	}
+}
+
+func (l *Log) newRecord(name string, r *http.Request) *Record {
+	rec := &Record{
+		Time:      time.Now(),
+		Component: l.component,
+		Endpoint:  name,
+		Path:      r.URL.Path,
+	}
+	if rid, ok := middleware.RequestIDFromContext(r.Context()); ok {
+		rec.RequestID = rid
+	}
+	if tenant, err := tenancy.GetTenantFromHTTP(r, l.tenantHeader, l.defaultTenant, l.certTenantField); err == nil {
+		rec.Tenant = tenant
+	}
+	if l.userHeader != "" {
+		rec.User = r.Header.Get(l.userHeader)
+	}
+	if rec.User == "" {
+		rec.User, _, _ = r.BasicAuth()
+	}
+
+	params := requestParams(r)
+	rec.Query = l.redact(params.Get("query"))
+	for _, m := range params["match[]"] {
+		rec.Matchers = append(rec.Matchers, l.redact(m))
+	}
+	rec.Start = params.Get("start")
+	rec.End = params.Get("end")
+	rec.QueryTime = params.Get("time")
+	rec.Step = params.Get("step")
+	return rec
+}
+
+// requestParams returns the URL and form parameters of the request, leaving its body untouched.
+func requestParams(r *http.Request) url.Values {
+	params := r.URL.Query()
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return params
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || len(body) == maxBodySize {
+		return params
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return params
+	}
+	for k, vs := range form {
+		params[k] = append(params[k], vs...)
+	}
+	return params
+}
+
+func (l *Log) redact(s string) string {
+	for _, r := range l.redactions {
+		s = r.re.ReplaceAllString(s, r.replacement)
+	}
+	return s
+}
+
+func (l *Log) sampled(rec *Record) bool {
+	if l.sampling.AlwaysRecordErrors && rec.Status == "error" {
+		return true
+	}
+	if l.sampling.AlwaysRecordSlowerThan > 0 && rec.DurationSeconds >= time.Duration(l.sampling.AlwaysRecordSlowerThan).Seconds() {
+		return true
+	}
+	return rand.Float64() < l.sampling.Ratio
+}
+
+func (l *Log) record(rec *Record) {
+	if !l.sampled(rec) {
+		l.sampledOut.Inc()
+		return
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		level.Error(l.logger).Log("msg", "failed to marshal audit record", "err", err)
+		return
+	}
+	l.recorded.Inc()
+	for _, s := range l.sinks {
+		if err := s.Write(b); err != nil {
+			l.failures.WithLabelValues(s.Name()).Inc()
+			level.Error(l.logger).Log("msg", "failed to write audit record", "sink", s.Name(), "err", err)
+		}
+	}
+}
+
+// Close flushes the records and closes the sinks.
+func (l *Log) Close() error {
+	var errs []string
+	for _, s := range l.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Errorf("closing audit log sinks: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// statusResponseWriter records the status code of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.statusCode = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streamed responses through.
+func (w *statusResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+func TestParseConfig(t *testing.T) {
+	for _, tcase := range []struct {
+		name   string
+		conf   string
+		exp    Config
+		expErr bool
+	}{
+		{
+			name: "file with defaults",
+			conf: `file: {path: /var/log/audit.log}`,
+			exp: Config{
+				File:     &FileConfig{Path: "/var/log/audit.log"},
+				Sampling: SamplingConfig{Ratio: 1, AlwaysRecordErrors: true},
+			},
+		},
+		{
+			name: "webhook with sampling and redactions",
+			conf: `
+user_header: X-User
+webhook:
+  url: http://audit.example.com
+  batch_size: 10
+sampling:
+  ratio: 0.1
+  always_record_slower_than: 10s
+redactions:
+- regex: 'password="[^"]*"'
+  replacement: 'password="<redacted>"'
+`,
+			exp: Config{
+				UserHeader: "X-User",
+				Webhook:    &WebhookConfig{URL: "http://audit.example.com", BatchSize: 10},
+				Sampling:   SamplingConfig{Ratio: 0.1, AlwaysRecordErrors: true, AlwaysRecordSlowerThan: model.Duration(10 * time.Second)},
+				Redactions: []RedactionConfig{{Regex: `password="[^"]*"`, Replacement: `password="<redacted>"`}},
+			},
+		},
+		{name: "no sink", conf: `user_header: X-User`, expErr: true},
+		{name: "file without path", conf: `file: {max_size_mb: 10}`, expErr: true},
+		{name: "invalid ratio", conf: `{file: {path: a.log}, sampling: {ratio: 2}}`, expErr: true},
+		{name: "invalid regex", conf: `{file: {path: a.log}, redactions: [{regex: "("}]}`, expErr: true},
+		{name: "unknown field", conf: `{file: {path: a.log}, unknown: true}`, expErr: true},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			conf, err := ParseConfig([]byte(tcase.conf))
+			if tcase.expErr {
+				testutil.NotOk(t, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.exp, conf)
+		})
+	}
+}
+
+type memorySink struct {
+	mtx     sync.Mutex
+	records []Record
+}
+
+func (s *memorySink) Name() string { return "memory" }
+
+func (s *memorySink) Write(b []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var rec Record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return err
+	}
+	s.records = append(s.records, rec)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func newTestLog(t *testing.T, conf Config) (*Log, *memorySink) {
+	t.Helper()
+
+	conf.File = &FileConfig{Path: filepath.Join(t.TempDir(), "audit.log")}
+	l, err := NewLog(log.NewNopLogger(), prometheus.NewRegistry(), "query", conf, tenancy.DefaultTenantHeader, tenancy.DefaultTenant, "")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, l.Close()) })
+
+	s := &memorySink{}
+	l.sinks = []sink{s}
+	return l, s
+}
+
+func TestLog_HTTPMiddleware(t *testing.T) {
+	conf := DefaultConfig()
+	conf.UserHeader = "X-User"
+	conf.Redactions = []RedactionConfig{{Regex: `token="[^"]*"`, Replacement: `token="<redacted>"`}}
+	l, s := newTestLog(t, conf)
+
+	h := l.HTTPMiddleware("query_range", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler can still read the form the middleware looked at.
+		testutil.Ok(t, r.ParseForm())
+		if r.Form.Get("query") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		SetSeries(r.Context(), 3)
+		SetBytesFetched(r.Context(), 1024)
+	}))
+
+	form := url.Values{"query": {`up{token="secret"}`}, "start": {"100"}, "end": {"200"}, "step": {"10"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/query_range", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(tenancy.DefaultTenantHeader, "team-a")
+	req.Header.Set("X-User", "alice")
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	testutil.Equals(t, http.StatusOK, rw.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/query_range", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	testutil.Equals(t, 2, len(s.records))
+
+	rec := s.records[0]
+	testutil.Equals(t, "query", rec.Component)
+	testutil.Equals(t, "query_range", rec.Endpoint)
+	testutil.Equals(t, "/api/v1/query_range", rec.Path)
+	testutil.Equals(t, "team-a", rec.Tenant)
+	testutil.Equals(t, "alice", rec.User)
+	testutil.Equals(t, `up{token="<redacted>"}`, rec.Query)
+	testutil.Equals(t, "100", rec.Start)
+	testutil.Equals(t, "200", rec.End)
+	testutil.Equals(t, "10", rec.Step)
+	testutil.Equals(t, 3, *rec.Series)
+	testutil.Equals(t, int64(1024), *rec.BytesFetched)
+	testutil.Equals(t, "success", rec.Status)
+	testutil.Equals(t, http.StatusOK, rec.StatusCode)
+
+	rec = s.records[1]
+	testutil.Equals(t, tenancy.DefaultTenant, rec.Tenant)
+	testutil.Equals(t, "error", rec.Status)
+	testutil.Equals(t, http.StatusBadRequest, rec.StatusCode)
+	testutil.Assert(t, rec.Series == nil, "series should not be set for failed request")
+}
+
+func TestLog_HTTPMiddleware_Nil(t *testing.T) {
+	var l *Log
+	called := false
+	l.HTTPMiddleware("query", http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true })).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/query", nil))
+	testutil.Assert(t, called, "handler was not called")
+}
+
+func TestLog_Sampling(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Sampling = SamplingConfig{Ratio: 0, AlwaysRecordErrors: true, AlwaysRecordSlowerThan: 0}
+	l, s := newTestLog(t, conf)
+
+	h := l.HTTPMiddleware("query", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("query") == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	for _, q := range []string{"up", "fail", "up"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/query?query="+q, nil))
+	}
+	testutil.Equals(t, 1, len(s.records))
+	testutil.Equals(t, "fail", s.records[0].Query)
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(l.sampledOut))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(l.recorded))
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	testutil.Ok(t, err)
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	testutil.Ok(t, sc.Err())
+	return lines
+}
+
+func TestFileSink_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	s, err := newFileSink(FileConfig{Path: path, MaxSizeMB: 1, MaxBackups: 2})
+	testutil.Ok(t, err)
+
+	// Each record fills half of the file, so that the file is rotated every two records.
+	rec := []byte(strings.Repeat("a", (1<<20)/2-1))
+	for i := 0; i < 7; i++ {
+		rec[0] = byte('0' + i)
+		testutil.Ok(t, s.Write(rec))
+	}
+	testutil.Ok(t, s.Close())
+
+	for file, exp := range map[string]string{path: "6", path + ".1": "45", path + ".2": "23"} {
+		var firsts string
+		for _, l := range readLines(t, file) {
+			firsts += l[:1]
+		}
+		testutil.Equals(t, exp, firsts, "file %s", file)
+	}
+	_, err = os.Stat(path + ".3")
+	testutil.Assert(t, os.IsNotExist(err), "unexpected third backup")
+}
+
+func TestFileSink_RotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	s, err := newFileSink(FileConfig{Path: path, MaxSizeMB: 1, MaxBackups: 1})
+	testutil.Ok(t, err)
+
+	rec := []byte(strings.Repeat("a", (1<<20)/2-1))
+	for i := 0; i < 2; i++ {
+		rec[0] = byte('0' + i)
+		testutil.Ok(t, s.Write(rec))
+	}
+
+	// The file can't be renamed over a non-empty directory.
+	testutil.Ok(t, os.MkdirAll(filepath.Join(path+".1", "dir"), 0o750))
+	rec[0] = '2'
+	testutil.NotOk(t, s.Write(rec))
+
+	// The file is still open and rotated on the next write.
+	testutil.Ok(t, os.RemoveAll(path+".1"))
+	rec[0] = '3'
+	testutil.Ok(t, s.Write(rec))
+	testutil.Ok(t, s.Close())
+	testutil.Ok(t, s.Close())
+	testutil.NotOk(t, s.Write(rec))
+
+	for file, exp := range map[string]string{path: "3", path + ".1": "01"} {
+		var firsts string
+		for _, l := range readLines(t, file) {
+			firsts += l[:1]
+		}
+		testutil.Equals(t, exp, firsts, "file %s", file)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		batches [][]string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Equals(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		testutil.Equals(t, "secret", r.Header.Get("Authorization"))
+
+		b, err := io.ReadAll(r.Body)
+		testutil.Ok(t, err)
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		batches = append(batches, strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"))
+	}))
+	defer srv.Close()
+
+	failures := prometheus.NewCounter(prometheus.CounterOpts{Name: "failures"})
+	s := newWebhookSink(log.NewNopLogger(), WebhookConfig{
+		URL:           srv.URL,
+		Headers:       map[string]string{"Authorization": "secret"},
+		BatchSize:     2,
+		FlushInterval: model.Duration(time.Minute),
+	}, failures)
+	for i := 0; i < 5; i++ {
+		testutil.Ok(t, s.Write([]byte(fmt.Sprintf(`{"i":%d}`, i))))
+	}
+
+	// Full batches are sent right away, the rest once the sink is closed.
+	testutil.Ok(t, runUntil(func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return len(batches) == 2
+	}))
+	testutil.Ok(t, s.Close())
+
+	testutil.Equals(t, [][]string{{`{"i":0}`, `{"i":1}`}, {`{"i":2}`, `{"i":3}`}, {`{"i":4}`}}, batches)
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(failures))
+}
+
+func runUntil(cond func() bool) error {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return nil
+		}
+	}
+	return fmt.Errorf("condition not met")
+}
@@ -0,0 +1,259 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// sink writes marshaled records.
+type sink interface {
+	Name() string
+	Write(rec []byte) error
+	Close() error
+}
+
+// fileSink appends records as JSON lines to a file, renamed to <path>.1, <path>.2... once it exceeds the maximum size.
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mtx    sync.Mutex
+	f      *os.File
+	size   int64
+	closed bool
+}
+
+func newFileSink(conf FileConfig) (*fileSink, error) {
+	s := &fileSink{
+		path:       conf.Path,
+		maxSize:    int64(conf.MaxSizeMB) << 20,
+		maxBackups: conf.MaxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return errors.Wrap(err, "open audit log file")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		runutil.CloseWithErrCapture(&err, f, "close audit log file")
+		return errors.Wrap(err, "stat audit log file")
+	}
+	s.f, s.size = f, fi.Size()
+	return nil
+}
+
+func (s *fileSink) Name() string { return "file" }
+
+func (s *fileSink) Write(rec []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return errors.New("audit log file is closed")
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(rec))+1 > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(append(rec, '\n'))
+	s.size += int64(n)
+	return err
+}
+
+// rotate renames the file to the first backup and opens a new one. The current file is closed only once the new one
+// is opened, so that records keep being written to it if the rotation fails.
+func (s *fileSink) rotate() error {
+	if s.maxBackups <= 0 {
+		if err := s.f.Truncate(0); err != nil {
+			return errors.Wrap(err, "truncate audit log file")
+		}
+		s.size = 0
+		return nil
+	}
+
+	for i := s.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "rotate audit log file")
+		}
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return errors.Wrap(err, "rotate audit log file")
+	}
+	old := s.f
+	if err := s.open(); err != nil {
+		// Keep writing to the current file.
+		if rerr := os.Rename(s.path+".1", s.path); rerr != nil {
+			err = errors.Wrapf(err, "restore audit log file: %v", rerr)
+		}
+		return err
+	}
+	if err := old.Close(); err != nil {
+		return errors.Wrap(err, "close rotated audit log file")
+	}
+	return nil
+}
+
+func (s *fileSink) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.f.Close()
+}
+
+// webhookSink sends records as JSON lines to an HTTP endpoint in the background.
+type webhookSink struct {
+	logger   log.Logger
+	conf     WebhookConfig
+	client   *http.Client
+	failures prometheus.Counter
+
+	queue  chan []byte
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newWebhookSink(logger log.Logger, conf WebhookConfig, failures prometheus.Counter) *webhookSink {
+	if conf.Timeout <= 0 {
+		conf.Timeout = model.Duration(10 * time.Second)
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 100
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = model.Duration(5 * time.Second)
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = 10000
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &webhookSink{
+		logger:   logger,
+		conf:     conf,
+		client:   &http.Client{Timeout: time.Duration(conf.Timeout)},
+		failures: failures,
+		queue:    make(chan []byte, conf.QueueSize),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go s.run(ctx)
+	return s
+}
+
+func (s *webhookSink) Name() string { return "webhook" }
+
+func (s *webhookSink) Write(rec []byte) error {
+	select {
+	case s.queue <- rec:
+		return nil
+	default:
+		return errors.New("audit log webhook queue is full")
+	}
+}
+
+func (s *webhookSink) run(ctx context.Context) {
+	defer close(s.done)
+
+	var (
+		batch  [][]byte
+		ticker = time.NewTicker(time.Duration(s.conf.FlushInterval))
+	)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.send(batch); err != nil {
+			s.failures.Add(float64(len(batch)))
+			level.Error(s.logger).Log("msg", "failed to send audit records", "records", len(batch), "err", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// Send the records queued so far.
+			for {
+				select {
+				case rec := <-s.queue:
+					batch = append(batch, rec)
+					if len(batch) >= s.conf.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case rec := <-s.queue:
+			batch = append(batch, rec)
+			if len(batch) >= s.conf.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (s *webhookSink) send(batch [][]byte) error {
+	var body bytes.Buffer
+	for _, rec := range batch {
+		body.Write(rec)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, s.conf.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range s.conf.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer runutil.ExhaustCloseWithLogOnErr(s.logger, resp.Body, "audit log webhook response")
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
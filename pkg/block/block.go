@@ -37,6 +37,8 @@ const (
 	IndexHeaderFilename = "index-header"
 	// ChunksDirname is the known dir name for chunks with compressed samples.
 	ChunksDirname = "chunks"
+	// ParquetFilename is the known file for the optional columnar representation of the block.
+	ParquetFilename = "series.parquet"
 
 	// DebugMetas is a directory for debug meta files that happen in the past. Useful for debugging.
 	DebugMetas = "debug/metas"
@@ -58,7 +60,8 @@ func Download(ctx context.Context, logger log.Logger, bucket objstore.Bucket, id
 		return errors.Wrapf(err, "reading meta from %s", dst)
 	}
 
-	ignoredPaths := []string{MetaFilename}
+	// The Parquet file is derived from the block and is not needed to process it locally.
+	ignoredPaths := []string{MetaFilename, ParquetFilename}
 	for _, fl := range m.Thanos.Files {
 		if fl.Hash == nil || fl.Hash.Func == metadata.NoneFunc || fl.RelPath == "" {
 			continue
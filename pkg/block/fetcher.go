@@ -11,10 +11,12 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/golang/groupcache/singleflight"
@@ -1085,3 +1087,16 @@ func ParseRelabelConfig(contentYaml []byte, supportedActions map[relabel.Action]
 
 	return relabelConfig, nil
 }
+
+// RelabelConfigShard returns the shard of the blocks selected by the relabel config, which is equal for the relabel
+// configs selecting the same blocks regardless of their formatting, and empty if all blocks are selected.
+func RelabelConfigShard(relabelConfig []*relabel.Config) (string, error) {
+	if len(relabelConfig) == 0 {
+		return "", nil
+	}
+	b, err := yaml.Marshal(relabelConfig)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal relabel configuration")
+	}
+	return strconv.FormatUint(xxhash.Sum64(b), 16), nil
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/objstore/objtesting"
@@ -1211,3 +1212,32 @@ func Test_ParseRelabelConfig(t *testing.T) {
 	testutil.NotOk(t, err)
 	testutil.Equals(t, "unsupported relabel action: labelmap", err.Error())
 }
+
+func TestRelabelConfigShard(t *testing.T) {
+	parse := func(s string) []*relabel.Config {
+		cfg, err := ParseRelabelConfig([]byte(s), SelectorSupportedRelabelActions)
+		testutil.Ok(t, err)
+		return cfg
+	}
+	shard := func(cfg []*relabel.Config) string {
+		s, err := RelabelConfigShard(cfg)
+		testutil.Ok(t, err)
+		return s
+	}
+	hashmod := func(n int) []*relabel.Config {
+		return parse(fmt.Sprintf(`
+    - action: hashmod
+      source_labels: ["__block_id"]
+      target_label: shard
+      modulus: 2
+    - action: keep
+      source_labels: ["shard"]
+      regex: "%d"
+    `, n))
+	}
+
+	testutil.Equals(t, "", shard(nil))
+	testutil.Equals(t, shard(hashmod(0)), shard(hashmod(0)))
+	testutil.Assert(t, shard(hashmod(0)) != shard(hashmod(1)))
+	testutil.Assert(t, shard(hashmod(0)) != "")
+}
@@ -0,0 +1,206 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package indexheader
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+)
+
+const (
+	// maxTrackedPostings is the maximum number of postings keys whose accesses are tracked per block.
+	maxTrackedPostings = 128
+	// maxReportedPostings is the maximum number of postings keys reported and persisted per block.
+	maxReportedPostings = 32
+	// accessHalfLife is the time after which an access of a block counts half in its recent accesses.
+	accessHalfLife = 6 * time.Hour
+)
+
+// BlockAccessStats holds how often queries accessed the index-header of a block.
+type BlockAccessStats struct {
+	ID       ulid.ULID `json:"id"`
+	Accesses uint64    `json:"accesses"`
+	// RecentAccesses is the number of accesses decayed exponentially with their age, as of the last access.
+	// Blocks are ranked by their recent accesses decayed further to the current time.
+	RecentAccesses float64   `json:"recent_accesses"`
+	LastAccess     time.Time `json:"last_access"`
+	// Postings holds the most accessed postings keys of the block, most accessed first.
+	Postings []labels.Label `json:"postings,omitempty"`
+}
+
+type blockAccess struct {
+	accesses uint64
+	// recent is the number of decayed accesses as of the last access.
+	recent     float64
+	lastAccess time.Time
+	postings   map[labels.Label]uint64
+}
+
+// recentAt returns the number of accesses decayed to the time t.
+func (a *blockAccess) recentAt(t time.Time) float64 {
+	age := t.Sub(a.lastAccess)
+	if age <= 0 {
+		return a.recent
+	}
+	return a.recent * math.Exp2(-float64(age)/float64(accessHalfLife))
+}
+
+func (a *blockAccess) record(t time.Time, keys []labels.Label) {
+	a.accesses++
+	a.recent = a.recentAt(t) + 1
+	a.lastAccess = t
+
+	for _, k := range keys {
+		if _, ok := a.postings[k]; !ok && len(a.postings) >= maxTrackedPostings {
+			// Halve all counts to make room for new keys while keeping the most accessed ones.
+			for pk, c := range a.postings {
+				if c/2 == 0 {
+					delete(a.postings, pk)
+					continue
+				}
+				a.postings[pk] = c / 2
+			}
+			if len(a.postings) >= maxTrackedPostings {
+				continue
+			}
+		}
+		a.postings[k]++
+	}
+}
+
+func (a *blockAccess) stats(id ulid.ULID) BlockAccessStats {
+	s := BlockAccessStats{ID: id, Accesses: a.accesses, RecentAccesses: a.recent, LastAccess: a.lastAccess}
+	for k := range a.postings {
+		s.Postings = append(s.Postings, k)
+	}
+	sort.Slice(s.Postings, func(i, j int) bool {
+		ci, cj := a.postings[s.Postings[i]], a.postings[s.Postings[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		if s.Postings[i].Name != s.Postings[j].Name {
+			return s.Postings[i].Name < s.Postings[j].Name
+		}
+		return s.Postings[i].Value < s.Postings[j].Value
+	})
+	if len(s.Postings) > maxReportedPostings {
+		s.Postings = s.Postings[:maxReportedPostings]
+	}
+	return s
+}
+
+// RecordAccess records that a query accessed the index-header of the given block, looking up the given postings.
+func (p *ReaderPool) RecordAccess(id ulid.ULID, postings []labels.Label) {
+	p.accessMx.Lock()
+	defer p.accessMx.Unlock()
+
+	a, ok := p.access[id]
+	if !ok {
+		a = &blockAccess{postings: map[labels.Label]uint64{}}
+		p.access[id] = a
+	}
+	a.record(p.now(), postings)
+}
+
+// ForgetAccess drops the access statistics of the given block, e.g. once it's not served anymore.
+func (p *ReaderPool) ForgetAccess(id ulid.ULID) {
+	p.accessMx.Lock()
+	defer p.accessMx.Unlock()
+
+	delete(p.access, id)
+}
+
+// HottestBlocks returns the access statistics of the n most recently accessed blocks, hottest first. Blocks are ranked
+// by their accesses decayed exponentially with their age, so that blocks which were busy long ago cool down.
+// If n is not positive, statistics of all blocks are returned.
+func (p *ReaderPool) HottestBlocks(n int) []BlockAccessStats {
+	type rankedStats struct {
+		BlockAccessStats
+		heat float64
+	}
+	now := p.now()
+
+	p.accessMx.Lock()
+	ranked := make([]rankedStats, 0, len(p.access))
+	for id, a := range p.access {
+		ranked = append(ranked, rankedStats{BlockAccessStats: a.stats(id), heat: a.recentAt(now)})
+	}
+	p.accessMx.Unlock()
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].heat != ranked[j].heat {
+			return ranked[i].heat > ranked[j].heat
+		}
+		if !ranked[i].LastAccess.Equal(ranked[j].LastAccess) {
+			return ranked[i].LastAccess.After(ranked[j].LastAccess)
+		}
+		return ranked[i].ID.Compare(ranked[j].ID) < 0
+	})
+	res := make([]BlockAccessStats, 0, len(ranked))
+	for _, r := range ranked {
+		res = append(res, r.BlockAccessStats)
+	}
+	if n > 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// WriteAccessStats persists the access statistics of all blocks to the given file.
+func (p *ReaderPool) WriteAccessStats(path string) error {
+	b, err := json.Marshal(p.HottestBlocks(0))
+	if err != nil {
+		return errors.Wrap(err, "marshal access stats")
+	}
+
+	// Write in an atomic way, to avoid partial writes.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "write access stats")
+	}
+	return fileutil.Replace(tmp, filepath.Clean(path))
+}
+
+// ReadAccessStats loads the access statistics persisted to the given file, replacing the ones of the same blocks.
+// A missing file is not an error.
+func (p *ReaderPool) ReadAccessStats(path string) error {
+	b, err := os.ReadFile(filepath.Clean(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "read access stats")
+	}
+
+	var stats []BlockAccessStats
+	if err := json.Unmarshal(b, &stats); err != nil {
+		return errors.Wrapf(err, "unmarshal access stats %s", path)
+	}
+
+	p.accessMx.Lock()
+	defer p.accessMx.Unlock()
+
+	for _, s := range stats {
+		a := &blockAccess{accesses: s.Accesses, recent: s.RecentAccesses, lastAccess: s.LastAccess, postings: make(map[labels.Label]uint64, len(s.Postings))}
+		if a.recent == 0 {
+			// Stats persisted before the accesses decayed, count them all as of the last access.
+			a.recent = float64(s.Accesses)
+		}
+		// Only the order of postings is persisted, keep it through decreasing counts.
+		for i, k := range s.Postings {
+			a.postings[k] = uint64(len(s.Postings) - i)
+		}
+		p.access[s.ID] = a
+	}
+	return nil
+}
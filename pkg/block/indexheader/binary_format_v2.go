@@ -0,0 +1,418 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package indexheader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+	"github.com/prometheus/prometheus/tsdb/index"
+)
+
+const (
+	// symbolsV2BlockSize is the number of symbols front-coded together in the index-header v2 symbol table.
+	// Every block starts with a full symbol, so a lookup decodes at most symbolsV2BlockSize symbols.
+	symbolsV2BlockSize = 32
+	// postingOffsetsV2BlockSize is the number of label values front-coded together in the index-header v2
+	// postings offset table. The first value of every block is kept in memory.
+	postingOffsetsV2BlockSize = 32
+
+	sectionFlushSize = 32 * 1024
+)
+
+// The index-header v2 keeps the same header and TOC as v1, but encodes both sections differently. They are only
+// written for TSDB index v2 blocks, which reference symbols by their sequence number rather than their offset.
+//
+// Symbols:
+// ┌────────────────────┬──────────────────────┐
+// │ #symbols <4b>      │ block size <4b>      │
+// ├────────────────────┴──────────────────────┤
+// │ ┌───────────────────────────────────────┐ │
+// │ │ shared prefix len <uvarint>           │ │
+// │ ├───────────────────────────────────────┤ │
+// │ │ len(suffix) <uvarint> │ suffix <bytes>│ │
+// │ └───────────────────────────────────────┘ │
+// │                  . . .                    │
+// ├───────────────────────────────────────────┤
+// │ block offsets: #blocks x <8b>             │
+// ├───────────────────────────────────────────┤
+// │ CRC32 <4b>                                │
+// └───────────────────────────────────────────┘
+//
+// Postings offset table, for each label name in order:
+// ┌───────────────────────────────────────────┐
+// │ len(name) <uvarint> │ name <bytes>        │
+// ├───────────────────────────────────────────┤
+// │ ┌───────────────────────────────────────┐ │
+// │ │ #entries <uvarint>                    │ │
+// │ ├───────────────────────────────────────┤ │
+// │ │ shared prefix len <uvarint>           │ │
+// │ │ len(suffix) <uvarint> │ suffix <bytes>│ │
+// │ │ offset delta <uvarint64>              │ │
+// │ │                . . .                  │ │
+// │ └───────────────────────────────────────┘ │
+// │                  . . .                    │
+// ├───────────────────────────────────────────┤
+// │ 0 <uvarint>                               │
+// └───────────────────────────────────────────┘
+// followed by a CRC32 <4b> of the whole table.
+//
+// The first entry of every block holds a full value and an absolute posting offset, and the section ends
+// where the next one starts, so no lengths need to be known upfront and both sections are written in a
+// single pass over the index.
+
+// sectionWriter writes an index-header section while computing its checksum.
+type sectionWriter struct {
+	w       PosWriter
+	crc32   hash.Hash32
+	buf     encoding.Encbuf
+	written uint64
+}
+
+func newSectionWriter(w PosWriter) *sectionWriter {
+	return &sectionWriter{w: w, crc32: newCRC32(), buf: encoding.Encbuf{B: make([]byte, 0, 2*sectionFlushSize)}}
+}
+
+// pos returns the position relative to the start of the section.
+func (s *sectionWriter) pos() uint64 {
+	return s.written + uint64(s.buf.Len())
+}
+
+func (s *sectionWriter) flushIfFull() error {
+	if s.buf.Len() < sectionFlushSize {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *sectionWriter) flush() error {
+	if _, err := s.crc32.Write(s.buf.Get()); err != nil {
+		return err
+	}
+	if err := s.w.Write(s.buf.Get()); err != nil {
+		return err
+	}
+	s.written += uint64(s.buf.Len())
+	s.buf.Reset()
+	return nil
+}
+
+func (s *sectionWriter) close() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.w.Write(s.crc32.Sum(nil))
+}
+
+// WriteSymbolsV2 reads a TSDB index v2 symbol table and writes it as a front-coded index-header v2 symbol table.
+func (w *binaryWriter) WriteSymbolsV2(r io.Reader) error {
+	w.toc.Symbols = w.writer.Pos()
+
+	br := bufio.NewReaderSize(r, sectionFlushSize)
+	var hdr [8]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return errors.Wrap(err, "read symbols header")
+	}
+	count := binary.BigEndian.Uint32(hdr[4:])
+
+	sw := newSectionWriter(w.writer)
+	sw.buf.PutBE32(count)
+	sw.buf.PutBE32(symbolsV2BlockSize)
+
+	offsets := make([]uint64, 0, (count+symbolsV2BlockSize-1)/symbolsV2BlockSize)
+	var prev, sym []byte
+	for i := uint32(0); i < count; i++ {
+		l, err := binary.ReadUvarint(br)
+		if err != nil {
+			return errors.Wrap(err, "read symbol length")
+		}
+		if uint64(cap(sym)) < l {
+			sym = make([]byte, l)
+		}
+		sym = sym[:l]
+		if _, err := io.ReadFull(br, sym); err != nil {
+			return errors.Wrap(err, "read symbol")
+		}
+
+		if i%symbolsV2BlockSize == 0 {
+			offsets = append(offsets, sw.pos())
+			prev = prev[:0]
+		}
+		shared := commonPrefixLen(prev, sym)
+		sw.buf.PutUvarint(shared)
+		sw.buf.PutUvarintBytes(sym[shared:])
+		prev, sym = sym, prev
+
+		if err := sw.flushIfFull(); err != nil {
+			return errors.Wrap(err, "write symbols")
+		}
+	}
+	for _, o := range offsets {
+		sw.buf.PutBE64(o)
+	}
+	return errors.Wrap(sw.close(), "write symbols")
+}
+
+// WritePostingOffsetsV2 reads a TSDB index v2 postings offset table and writes it as an index-header v2 postings
+// offset table.
+func (w *binaryWriter) WritePostingOffsetsV2(r io.Reader) error {
+	w.toc.PostingsOffsetTable = w.writer.Pos()
+
+	br := bufio.NewReaderSize(r, sectionFlushSize)
+	var hdr [8]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return errors.Wrap(err, "read postings offset table header")
+	}
+	count := binary.BigEndian.Uint32(hdr[4:])
+
+	sw := newSectionWriter(w.writer)
+
+	var (
+		block    encoding.Encbuf
+		blockLen int
+
+		name, curName    []byte
+		value, prevValue []byte
+		prevOff          uint64
+	)
+	flushBlock := func() {
+		if blockLen == 0 {
+			return
+		}
+		sw.buf.PutUvarint(blockLen)
+		sw.buf.PutBytes(block.Get())
+		block.Reset()
+		blockLen = 0
+	}
+	readBytes := func(b []byte) ([]byte, error) {
+		l, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(cap(b)) < l {
+			b = make([]byte, l)
+		}
+		b = b[:l]
+		_, err = io.ReadFull(br, b)
+		return b, err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return errors.Wrap(err, "read postings offset entry")
+		}
+		if n != 2 {
+			return errors.Errorf("unexpected number of keys for postings offset table %d", n)
+		}
+		if name, err = readBytes(name); err != nil {
+			return errors.Wrap(err, "read label name")
+		}
+		if value, err = readBytes(value); err != nil {
+			return errors.Wrap(err, "read label value")
+		}
+		off, err := binary.ReadUvarint(br)
+		if err != nil {
+			return errors.Wrap(err, "read postings offset")
+		}
+
+		if i == 0 || !bytes.Equal(name, curName) {
+			if i > 0 {
+				flushBlock()
+				sw.buf.PutUvarint(0)
+			}
+			sw.buf.PutUvarintBytes(name)
+			curName = append(curName[:0], name...)
+		}
+		if blockLen == postingOffsetsV2BlockSize {
+			flushBlock()
+		}
+
+		if blockLen == 0 {
+			block.PutUvarint(0)
+			block.PutUvarintBytes(value)
+			block.PutUvarint64(off)
+		} else {
+			if off < prevOff {
+				return errors.Errorf("postings offset table is not sorted: %d after %d", off, prevOff)
+			}
+			shared := commonPrefixLen(prevValue, value)
+			block.PutUvarint(shared)
+			block.PutUvarintBytes(value[shared:])
+			block.PutUvarint64(off - prevOff)
+		}
+		blockLen++
+		prevValue = append(prevValue[:0], value...)
+		prevOff = off
+
+		if err := sw.flushIfFull(); err != nil {
+			return errors.Wrap(err, "write postings offset table")
+		}
+	}
+	if count > 0 {
+		flushBlock()
+		sw.buf.PutUvarint(0)
+	}
+	return errors.Wrap(sw.close(), "write postings offset table")
+}
+
+func commonPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// decodeFrontCoded decodes the next front-coded value, reusing the previous one.
+func decodeFrontCoded(d *encoding.Decbuf, prev []byte) []byte {
+	shared := d.Uvarint()
+	suffix := d.UvarintBytes()
+	if d.Err() != nil {
+		return prev[:0]
+	}
+	if shared > len(prev) {
+		d.E = errors.Errorf("invalid shared prefix length %d for previous value of length %d", shared, len(prev))
+		return prev[:0]
+	}
+	return append(prev[:shared], suffix...)
+}
+
+// decodePostingOffsetEntry decodes the next postings offset table entry. The first entry of a block holds the
+// absolute posting offset, the following ones a delta to the previous entry.
+func decodePostingOffsetEntry(d *encoding.Decbuf, prevValue []byte, prevOff uint64, first bool) ([]byte, uint64) {
+	if first {
+		prevValue = prevValue[:0]
+	}
+	value := decodeFrontCoded(d, prevValue)
+	off := d.Uvarint64()
+	if first {
+		return value, off
+	}
+	return value, prevOff + off
+}
+
+// verifySection checks the CRC32 stored in the last bytes of the given section.
+func verifySection(bs index.ByteSlice, start, end int) error {
+	if start < 0 || end-start < crc32.Size || end > bs.Len() {
+		return encoding.ErrInvalidSize
+	}
+	b := bs.Range(start, end)
+	if crc32.Checksum(b[:len(b)-crc32.Size], castagnoliTable) != binary.BigEndian.Uint32(b[len(b)-crc32.Size:]) {
+		return encoding.ErrInvalidChecksum
+	}
+	return nil
+}
+
+// compressedSymbols reads the front-coded symbol table of an index-header v2. Contrary to index.Symbols
+// it holds nothing in memory: blocks are located through the offsets stored at the end of the section.
+type compressedSymbols struct {
+	bs           index.ByteSlice
+	start        int
+	offsetsStart int
+	count        int
+	blockSize    int
+	numBlocks    int
+}
+
+func newCompressedSymbols(bs index.ByteSlice, start, end int) (*compressedSymbols, error) {
+	if err := verifySection(bs, start, end); err != nil {
+		return nil, err
+	}
+	if end-start < 8+crc32.Size {
+		return nil, encoding.ErrInvalidSize
+	}
+	hdr := bs.Range(start, start+8)
+	s := &compressedSymbols{
+		bs:        bs,
+		start:     start,
+		count:     int(binary.BigEndian.Uint32(hdr[:4])),
+		blockSize: int(binary.BigEndian.Uint32(hdr[4:])),
+	}
+	if s.blockSize == 0 {
+		return nil, errors.New("invalid symbols block size 0")
+	}
+	s.numBlocks = (s.count + s.blockSize - 1) / s.blockSize
+	s.offsetsStart = end - crc32.Size - 8*s.numBlocks
+	if s.offsetsStart < start+8 {
+		return nil, encoding.ErrInvalidSize
+	}
+	return s, nil
+}
+
+func (s *compressedSymbols) block(i int) encoding.Decbuf {
+	off := binary.BigEndian.Uint64(s.bs.Range(s.offsetsStart+8*i, s.offsetsStart+8*(i+1)))
+	if off > uint64(s.offsetsStart-s.start) {
+		return encoding.Decbuf{E: errors.Errorf("invalid symbols block offset %d", off)}
+	}
+	return encoding.Decbuf{B: s.bs.Range(s.start+int(off), s.offsetsStart)}
+}
+
+// Lookup returns the symbol with the given sequence number.
+func (s *compressedSymbols) Lookup(o uint32) (string, error) {
+	if int(o) >= s.count {
+		return "", errors.Errorf("unknown symbol offset %d", o)
+	}
+	d := s.block(int(o) / s.blockSize)
+	var sym []byte
+	for i := 0; i <= int(o)%s.blockSize; i++ {
+		sym = decodeFrontCoded(&d, sym)
+	}
+	if d.Err() != nil {
+		return "", errors.Wrap(d.Err(), "read symbol")
+	}
+	return string(sym), nil
+}
+
+// ReverseLookup returns the sequence number of the given symbol.
+func (s *compressedSymbols) ReverseLookup(sym string) (uint32, error) {
+	var (
+		buf []byte
+		err error
+	)
+	i := sort.Search(s.numBlocks, func(i int) bool {
+		d := s.block(i)
+		buf = decodeFrontCoded(&d, buf[:0])
+		if d.Err() != nil {
+			err = d.Err()
+		}
+		return string(buf) > sym
+	}) - 1
+	if err != nil {
+		return 0, errors.Wrap(err, "read symbol")
+	}
+	if i < 0 {
+		return 0, errors.Errorf("unknown symbol %q", sym)
+	}
+
+	d := s.block(i)
+	buf = buf[:0]
+	for j := 0; j < s.blockSize && i*s.blockSize+j < s.count; j++ {
+		buf = decodeFrontCoded(&d, buf)
+		if d.Err() != nil {
+			return 0, errors.Wrap(d.Err(), "read symbol")
+		}
+		if string(buf) == sym {
+			return uint32(i*s.blockSize + j), nil
+		}
+		if string(buf) > sym {
+			break
+		}
+	}
+	return 0, errors.Errorf("unknown symbol %q", sym)
+}
+
+// Size returns the number of bytes held in memory, which is always zero.
+func (s *compressedSymbols) Size() int {
+	return 0
+}
@@ -37,6 +37,9 @@ import (
 const (
 	// BinaryFormatV1 represents first version of index-header file.
 	BinaryFormatV1 = 1
+	// BinaryFormatV2 represents the index-header version with a front-coded symbol table and a compact postings
+	// offset table. It can only be built for TSDB index v2 blocks; for older blocks BinaryFormatV1 is written instead.
+	BinaryFormatV2 = 2
 
 	indexTOCLen  = 6*8 + crc32.Size
 	binaryTOCLen = 2*8 + crc32.Size
@@ -101,7 +104,8 @@ type BinaryTOC struct {
 }
 
 // WriteBinary build index header from the pieces of index in object storage, and cached in file if necessary.
-func WriteBinary(ctx context.Context, bkt objstore.BucketReader, id ulid.ULID, filename string, downloadDuration prometheus.Histogram) ([]byte, error) {
+// The index-header is written in the given format version, unless the block index is too old for it.
+func WriteBinary(ctx context.Context, bkt objstore.BucketReader, id ulid.ULID, filename string, downloadDuration prometheus.Histogram, format int) ([]byte, error) {
 	start := time.Now()
 
 	defer func() {
@@ -124,50 +128,95 @@ func WriteBinary(ctx context.Context, bkt objstore.BucketReader, id ulid.ULID, f
 	// Buffer for copying and encbuffers.
 	// This also will control the size of file writer buffer.
 	buf := make([]byte, 32*1024)
-	bw, err := newBinaryWriter(id, tmpFilename, buf)
+	bw, err := newBinaryWriter(id, tmpFilename, buf, binaryFormatFor(format, indexVersion))
 	if err != nil {
 		return nil, errors.Wrap(err, "new binary index header writer")
 	}
 	defer runutil.CloseWithErrCapture(&err, bw, "close binary writer for %s", tmpFilename)
 
-	if err := bw.AddIndexMeta(indexVersion, ir.toc.PostingsTable); err != nil {
-		return nil, errors.Wrap(err, "add index meta")
+	if err := writeBinary(bw, ir, indexVersion, buf); err != nil {
+		return nil, err
 	}
 
-	if err := ir.CopySymbols(bw.SymbolsWriter(), buf); err != nil {
-		return nil, err
+	if tmpFilename != "" {
+		// Create index-header in atomic way, to avoid partial writes (e.g during restart or crash of store GW).
+		return nil, os.Rename(tmpFilename, filename)
 	}
 
-	if err := bw.writer.Flush(); err != nil {
-		return nil, errors.Wrap(err, "flush")
+	return bw.Buffer(), nil
+}
+
+// BinarySize returns the size in bytes of the index-header of the given format version for the block,
+// without keeping it in memory nor on disk.
+func BinarySize(ctx context.Context, bkt objstore.BucketReader, id ulid.ULID, format int) (uint64, error) {
+	ir, indexVersion, err := newChunkedIndexReader(ctx, bkt, id)
+	if err != nil {
+		return 0, errors.Wrap(err, "new index reader")
 	}
 
-	if err := ir.CopyPostingsOffsets(bw.PostingOffsetsWriter(), buf); err != nil {
-		return nil, err
+	format = binaryFormatFor(format, indexVersion)
+	if format == BinaryFormatV1 {
+		// Sections are copied as is.
+		return headerLen + (ir.toc.Series - ir.toc.Symbols) + (ir.size - ir.toc.PostingsTable) + binaryTOCLen, nil
+	}
+
+	buf := make([]byte, 32*1024)
+	bw := newPosBinaryWriter(&countingWriter{}, buf, format)
+	if err := bw.writer.Write(bw.buf.Get()); err != nil {
+		return 0, err
+	}
+	if err := writeBinary(bw, ir, indexVersion, buf); err != nil {
+		return 0, err
+	}
+	return bw.writer.Pos(), nil
+}
+
+// binaryFormatFor returns the index-header format version to use for the given requested version and index version.
+func binaryFormatFor(format, indexVersion int) int {
+	if format == BinaryFormatV2 && indexVersion != index.FormatV1 {
+		return BinaryFormatV2
+	}
+	return BinaryFormatV1
+}
+
+func writeBinary(bw *binaryWriter, ir *chunkedIndexReader, indexVersion int, buf []byte) error {
+	if err := bw.AddIndexMeta(indexVersion, ir.toc.PostingsTable); err != nil {
+		return errors.Wrap(err, "add index meta")
+	}
+
+	if bw.version == BinaryFormatV2 {
+		if err := ir.ReadSymbols(bw.WriteSymbolsV2); err != nil {
+			return err
+		}
+	} else if err := ir.CopySymbols(bw.SymbolsWriter(), buf); err != nil {
+		return err
 	}
 
 	if err := bw.writer.Flush(); err != nil {
-		return nil, errors.Wrap(err, "flush")
+		return errors.Wrap(err, "flush")
 	}
 
-	if err := bw.WriteTOC(); err != nil {
-		return nil, errors.Wrap(err, "write index header TOC")
+	if bw.version == BinaryFormatV2 {
+		if err := ir.ReadPostingsOffsets(bw.WritePostingOffsetsV2); err != nil {
+			return err
+		}
+	} else if err := ir.CopyPostingsOffsets(bw.PostingOffsetsWriter(), buf); err != nil {
+		return err
 	}
 
 	if err := bw.writer.Flush(); err != nil {
-		return nil, errors.Wrap(err, "flush")
+		return errors.Wrap(err, "flush")
 	}
 
-	if err := bw.writer.Sync(); err != nil {
-		return nil, errors.Wrap(err, "sync")
+	if err := bw.WriteTOC(); err != nil {
+		return errors.Wrap(err, "write index header TOC")
 	}
 
-	if tmpFilename != "" {
-		// Create index-header in atomic way, to avoid partial writes (e.g during restart or crash of store GW).
-		return nil, os.Rename(tmpFilename, filename)
+	if err := bw.writer.Flush(); err != nil {
+		return errors.Wrap(err, "flush")
 	}
 
-	return bw.Buffer(), nil
+	return errors.Wrap(bw.writer.Sync(), "sync")
 }
 
 type chunkedIndexReader struct {
@@ -250,36 +299,49 @@ func (r *chunkedIndexReader) readTOC() (*index.TOC, error) {
 }
 
 func (r *chunkedIndexReader) CopySymbols(w io.Writer, buf []byte) (err error) {
+	return r.ReadSymbols(func(rc io.Reader) error {
+		if _, err := io.CopyBuffer(w, rc, buf); err != nil {
+			return errors.Wrap(err, "copy symbols")
+		}
+		return nil
+	})
+}
+
+// ReadSymbols calls f with a reader of the symbols section of the index.
+func (r *chunkedIndexReader) ReadSymbols(f func(io.Reader) error) (err error) {
 	rc, err := r.bkt.GetRange(r.ctx, r.path, int64(r.toc.Symbols), int64(r.toc.Series-r.toc.Symbols))
 	if err != nil {
 		return errors.Wrapf(err, "get symbols from object storage of %s", r.path)
 	}
 	defer runutil.CloseWithErrCapture(&err, rc, "close symbol reader")
 
-	if _, err := io.CopyBuffer(w, rc, buf); err != nil {
-		return errors.Wrap(err, "copy symbols")
-	}
-
-	return nil
+	return f(rc)
 }
 
 func (r *chunkedIndexReader) CopyPostingsOffsets(w io.Writer, buf []byte) (err error) {
+	return r.ReadPostingsOffsets(func(rc io.Reader) error {
+		if _, err := io.CopyBuffer(w, rc, buf); err != nil {
+			return errors.Wrap(err, "copy posting offsets")
+		}
+		return nil
+	})
+}
+
+// ReadPostingsOffsets calls f with a reader starting at the postings offset table of the index.
+func (r *chunkedIndexReader) ReadPostingsOffsets(f func(io.Reader) error) (err error) {
 	rc, err := r.bkt.GetRange(r.ctx, r.path, int64(r.toc.PostingsTable), int64(r.size-r.toc.PostingsTable))
 	if err != nil {
 		return errors.Wrapf(err, "get posting offset table from object storage of %s", r.path)
 	}
 	defer runutil.CloseWithErrCapture(&err, rc, "close posting offsets reader")
 
-	if _, err := io.CopyBuffer(w, rc, buf); err != nil {
-		return errors.Wrap(err, "copy posting offsets")
-	}
-
-	return nil
+	return f(rc)
 }
 
 // TODO(bwplotka): Add padding for efficient read.
 type binaryWriter struct {
-	writer PosWriter
+	writer  PosWriter
+	version int
 
 	toc BinaryTOC
 
@@ -289,7 +351,7 @@ type binaryWriter struct {
 	crc32 hash.Hash
 }
 
-func newBinaryWriter(id ulid.ULID, cacheFilename string, buf []byte) (w *binaryWriter, err error) {
+func newBinaryWriter(id ulid.ULID, cacheFilename string, buf []byte, version int) (w *binaryWriter, err error) {
 	var binWriter PosWriter
 	if cacheFilename != "" {
 		dir := filepath.Dir(cacheFilename)
@@ -324,8 +386,15 @@ func newBinaryWriter(id ulid.ULID, cacheFilename string, buf []byte) (w *binaryW
 		binWriter = NewMemoryWriter(id, len(buf))
 	}
 
-	w = &binaryWriter{
-		writer: binWriter,
+	w = newPosBinaryWriter(binWriter, buf, version)
+	return w, w.writer.Write(w.buf.Get())
+}
+
+// newPosBinaryWriter returns a binaryWriter with the magic and version buffered, but not written yet.
+func newPosBinaryWriter(writer PosWriter, buf []byte, version int) *binaryWriter {
+	w := &binaryWriter{
+		writer:  writer,
+		version: version,
 
 		// Reusable memory.
 		buf:   encoding.Encbuf{B: buf},
@@ -334,9 +403,8 @@ func newBinaryWriter(id ulid.ULID, cacheFilename string, buf []byte) (w *binaryW
 
 	w.buf.Reset()
 	w.buf.PutBE32(MagicIndex)
-	w.buf.PutByte(BinaryFormatV1)
-
-	return w, w.writer.Write(w.buf.Get())
+	w.buf.PutByte(byte(version))
+	return w
 }
 
 type PosWriterWithBuffer interface {
@@ -404,6 +472,26 @@ func (mw *MemoryWriter) Close() error {
 	return mw.Flush()
 }
 
+// countingWriter is a PosWriter that only tracks the number of bytes written.
+type countingWriter struct {
+	pos uint64
+}
+
+func (cw *countingWriter) Pos() uint64 { return cw.pos }
+
+func (cw *countingWriter) Write(bufs ...[]byte) error {
+	for _, b := range bufs {
+		cw.pos += uint64(len(b))
+	}
+	return nil
+}
+
+func (cw *countingWriter) Flush() error { return nil }
+
+func (cw *countingWriter) Sync() error { return nil }
+
+func (cw *countingWriter) Close() error { return nil }
+
 type FileWriter struct {
 	f          *os.File
 	fileWriter *bufio.Writer
@@ -515,6 +603,14 @@ func (w *binaryWriter) Close() error {
 	return w.writer.Close()
 }
 
+// symbolTable looks up symbols of the index-header.
+type symbolTable interface {
+	Lookup(o uint32) (string, error)
+	ReverseLookup(sym string) (uint32, error)
+	// Size returns the number of bytes held in memory.
+	Size() int
+}
+
 type postingValueOffsets struct {
 	offsets       []postingOffset
 	lastValOffset int64
@@ -524,6 +620,7 @@ type postingOffset struct {
 	// label value.
 	value string
 	// offset of this entry in posting offset table in index-header file.
+	// For the index-header v2 it's the offset of the block starting with this value in the whole file.
 	tableOff int
 }
 
@@ -538,12 +635,16 @@ type BinaryReader struct {
 
 	// Map of LabelName to a list of some LabelValues's position in the offset table.
 	// The first and last values for each name are always present, we keep only 1/postingOffsetsInMemSampling of the rest.
+	// For the index-header v2, the first value of every block is kept instead.
 	postings map[string]*postingValueOffsets
+	// End of the index-header v2 postings offset table, excluding its checksum.
+	postingsV2End int
 	// For the v1 format, labelname -> labelvalue -> offset.
 	postingsV1 map[string]map[string]index.Range
 
 	// Symbols struct that keeps only 1/postingOffsetsInMemSampling in the memory, then looks up the rest via mmap.
-	symbols *index.Symbols
+	// For the index-header v2, nothing is kept in memory.
+	symbols symbolTable
 	// Cache of the label name symbol lookups,
 	// as there are not many and they are half of all lookups.
 	nameSymbols map[uint32]string
@@ -567,7 +668,8 @@ type BinaryReader struct {
 }
 
 // NewBinaryReader loads or builds new index-header if not present on disk.
-func NewBinaryReader(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, dir string, id ulid.ULID, postingOffsetsInMemSampling int, metrics *BinaryReaderMetrics) (*BinaryReader, error) {
+// Index-headers are built in the given format version, but existing ones are read in any version.
+func NewBinaryReader(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, dir string, id ulid.ULID, postingOffsetsInMemSampling int, metrics *BinaryReaderMetrics, format int) (*BinaryReader, error) {
 	if dir != "" {
 		binfn := filepath.Join(dir, id.String(), block.IndexHeaderFilename)
 		br, err := newFileBinaryReader(binfn, postingOffsetsInMemSampling, metrics)
@@ -578,14 +680,14 @@ func NewBinaryReader(ctx context.Context, logger log.Logger, bkt objstore.Bucket
 		level.Debug(logger).Log("msg", "failed to read index-header from disk; recreating", "path", binfn, "err", err)
 
 		start := time.Now()
-		if _, err := WriteBinary(ctx, bkt, id, binfn, metrics.downloadDuration); err != nil {
+		if _, err := WriteBinary(ctx, bkt, id, binfn, metrics.downloadDuration, format); err != nil {
 			return nil, errors.Wrap(err, "write index header")
 		}
 
 		level.Debug(logger).Log("msg", "built index-header file", "path", binfn, "elapsed", time.Since(start))
 		return newFileBinaryReader(binfn, postingOffsetsInMemSampling, metrics)
 	} else {
-		buf, err := WriteBinary(ctx, bkt, id, "", metrics.downloadDuration)
+		buf, err := WriteBinary(ctx, bkt, id, "", metrics.downloadDuration, format)
 		if err != nil {
 			return nil, errors.Wrap(err, "generate index header")
 		}
@@ -678,15 +780,25 @@ func (r *BinaryReader) init() (err error) {
 
 	r.indexLastPostingEnd = int64(binary.BigEndian.Uint64(r.b.Range(6, headerLen)))
 
-	if r.version != BinaryFormatV1 {
+	if r.version != BinaryFormatV1 && r.version != BinaryFormatV2 {
 		return errors.Errorf("unknown index header file version %d", r.version)
 	}
+	if r.version == BinaryFormatV2 && r.indexVersion == index.FormatV1 {
+		return errors.Errorf("index header file version %d is not supported for index version %d", r.version, r.indexVersion)
+	}
 
 	r.toc, err = newBinaryTOCFromByteSlice(r.b)
 	if err != nil {
 		return errors.Wrap(err, "read index header TOC")
 	}
 
+	if r.version == BinaryFormatV2 {
+		if err := r.initV2(); err != nil {
+			return err
+		}
+		return r.initNameSymbols()
+	}
+
 	// TODO(bwplotka): Consider contributing to Prometheus to allow specifying custom number for symbolsFactor.
 	r.symbols, err = index.NewSymbols(r.b, r.indexVersion, int(r.toc.Symbols))
 	if err != nil {
@@ -775,6 +887,64 @@ func (r *BinaryReader) init() (err error) {
 		}
 	}
 
+	return r.initNameSymbols()
+}
+
+// initV2 loads the symbols and postings offset table of an index-header v2.
+func (r *BinaryReader) initV2() (err error) {
+	r.symbols, err = newCompressedSymbols(r.b, int(r.toc.Symbols), int(r.toc.PostingsOffsetTable))
+	if err != nil {
+		return errors.Wrap(err, "read symbols")
+	}
+
+	start, end := int(r.toc.PostingsOffsetTable), r.b.Len()-binaryTOCLen
+	if err := verifySection(r.b, start, end); err != nil {
+		return errors.Wrap(err, "read postings table")
+	}
+	r.postingsV2End = end - crc32.Size
+
+	var (
+		d     = encoding.Decbuf{B: r.b.Range(start, r.postingsV2End)}
+		prev  *postingValueOffsets
+		value []byte
+		off   uint64
+	)
+	for d.Err() == nil && d.Len() > 0 {
+		e := &postingValueOffsets{}
+		r.postings[string(d.UvarintBytes())] = e
+
+		for d.Err() == nil {
+			blockOff := r.postingsV2End - d.Len()
+			n := d.Uvarint()
+			if n == 0 {
+				break
+			}
+			for i := 0; i < n; i++ {
+				value, off = decodePostingOffsetEntry(&d, value, off, i == 0)
+				if i > 0 {
+					continue
+				}
+				e.offsets = append(e.offsets, postingOffset{value: string(value), tableOff: blockOff})
+				if prev != nil {
+					// The postings of the previous label name end where the ones of this name start.
+					prev.lastValOffset = int64(off - crc32.Size)
+					prev = nil
+				}
+			}
+		}
+		prev = e
+	}
+	if d.Err() != nil {
+		return errors.Wrap(d.Err(), "read postings table")
+	}
+	if prev != nil {
+		// As with v1, the end of the last postings is unknown, guess it from the TOC.
+		prev.lastValOffset = r.indexLastPostingEnd - crc32.Size
+	}
+	return nil
+}
+
+func (r *BinaryReader) initNameSymbols() error {
 	r.nameSymbols = make(map[uint32]string, len(r.postings))
 	for k := range r.postings {
 		if k == "" {
@@ -851,6 +1021,10 @@ func (r *BinaryReader) postingsOffset(name string, values ...string) ([]index.Ra
 		return nil, nil
 	}
 
+	if r.version == BinaryFormatV2 {
+		return r.postingsOffsetV2(e, values)
+	}
+
 	buf := 0
 	valueIndex := 0
 	for valueIndex < len(values) && values[valueIndex] < e.offsets[0].value {
@@ -981,6 +1155,58 @@ func (r *BinaryReader) postingsOffset(name string, values ...string) ([]index.Ra
 	return rngs, nil
 }
 
+// postingsOffsetV2 looks up the ranges of the given values in an index-header v2 postings offset table.
+func (r *BinaryReader) postingsOffsetV2(e *postingValueOffsets, values []string) ([]index.Range, error) {
+	rngs := make([]index.Range, 0, len(values))
+
+	var value []byte
+	for _, wantedValue := range values {
+		// Find the last block starting before or at the wanted value.
+		i := sort.Search(len(e.offsets), func(i int) bool { return e.offsets[i].value > wantedValue }) - 1
+		if i < 0 {
+			rngs = append(rngs, NotFoundRange)
+			continue
+		}
+
+		rng := NotFoundRange
+		d := encoding.Decbuf{B: r.b.Range(e.offsets[i].tableOff, r.postingsV2End)}
+		n := d.Uvarint()
+
+		var off uint64
+		for j := 0; j < n && d.Err() == nil; j++ {
+			value, off = decodePostingOffsetEntry(&d, value, off, j == 0)
+			if string(value) < wantedValue {
+				continue
+			}
+			if string(value) == wantedValue {
+				rng.Start = int64(off) + postingLengthFieldSize
+				switch {
+				case j+1 < n:
+					_, next := decodePostingOffsetEntry(&d, value, off, false)
+					rng.End = int64(next) - crc32.Size
+				case i+1 < len(e.offsets):
+					// Last value of the block, its postings end where the next block ones start.
+					nd := encoding.Decbuf{B: r.b.Range(e.offsets[i+1].tableOff, r.postingsV2End)}
+					nd.Uvarint()
+					_, next := decodePostingOffsetEntry(&nd, nil, 0, true)
+					if nd.Err() != nil {
+						return nil, errors.Wrap(nd.Err(), "get postings offset entry")
+					}
+					rng.End = int64(next) - crc32.Size
+				default:
+					rng.End = e.lastValOffset
+				}
+			}
+			break
+		}
+		if d.Err() != nil {
+			return nil, errors.Wrap(d.Err(), "get postings offset entry")
+		}
+		rngs = append(rngs, rng)
+	}
+	return rngs, nil
+}
+
 func (r *BinaryReader) LookupSymbol(ctx context.Context, o uint32) (string, error) {
 	if r.indexVersion == index.FormatV1 {
 		// For v1 little trick is needed. Refs are actual offset inside index, not index-header. This is different
@@ -1035,6 +1261,9 @@ func (r *BinaryReader) LabelValues(name string) ([]string, error) {
 	if len(e.offsets) == 0 {
 		return nil, nil
 	}
+	if r.version == BinaryFormatV2 {
+		return r.labelValuesV2(e)
+	}
 	values := make([]string, 0, len(e.offsets)*r.postingOffsetsInMemSampling)
 
 	d := encoding.NewDecbufAt(r.b, int(r.toc.PostingsOffsetTable), nil)
@@ -1066,6 +1295,26 @@ func (r *BinaryReader) LabelValues(name string) ([]string, error) {
 	return values, nil
 }
 
+func (r *BinaryReader) labelValuesV2(e *postingValueOffsets) ([]string, error) {
+	values := make([]string, 0, len(e.offsets)*postingOffsetsV2BlockSize)
+
+	var (
+		d     = encoding.Decbuf{B: r.b.Range(e.offsets[0].tableOff, r.postingsV2End)}
+		value []byte
+		off   uint64
+	)
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n = d.Uvarint() {
+		for i := 0; i < n; i++ {
+			value, off = decodePostingOffsetEntry(&d, value, off, i == 0)
+			values = append(values, string(value))
+		}
+	}
+	if d.Err() != nil {
+		return nil, errors.Wrap(d.Err(), "get postings offset entry")
+	}
+	return values, nil
+}
+
 func yoloString(b []byte) string {
 	return *((*string)(unsafe.Pointer(&b)))
 }
@@ -111,10 +111,10 @@ func TestReaders(t *testing.T) {
 
 			t.Run("binary reader", func(t *testing.T) {
 				fn := filepath.Join(tmpDir, id.String(), block.IndexHeaderFilename)
-				_, err := WriteBinary(ctx, bkt, id, fn, dummyHistogram)
+				_, err := WriteBinary(ctx, bkt, id, fn, dummyHistogram, BinaryFormatV1)
 				testutil.Ok(t, err)
 
-				br, err := NewBinaryReader(ctx, log.NewNopLogger(), nil, tmpDir, id, 3, NewBinaryReaderMetrics(nil))
+				br, err := NewBinaryReader(ctx, log.NewNopLogger(), nil, tmpDir, id, 3, NewBinaryReaderMetrics(nil), BinaryFormatV1)
 				testutil.Ok(t, err)
 
 				defer func() { testutil.Ok(t, br.Close()) }()
@@ -235,16 +235,57 @@ func TestReaders(t *testing.T) {
 
 			t.Run("lazy binary reader", func(t *testing.T) {
 				fn := filepath.Join(tmpDir, id.String(), block.IndexHeaderFilename)
-				_, err := WriteBinary(ctx, bkt, id, fn, dummyHistogram)
+				_, err := WriteBinary(ctx, bkt, id, fn, dummyHistogram, BinaryFormatV1)
 				testutil.Ok(t, err)
 
-				br, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), nil, tmpDir, id, 3, NewLazyBinaryReaderMetrics(nil), NewBinaryReaderMetrics(nil), nil, false)
+				br, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), nil, tmpDir, id, 3, NewLazyBinaryReaderMetrics(nil), NewBinaryReaderMetrics(nil), nil, false, BinaryFormatV1)
 				testutil.Ok(t, err)
 
 				defer func() { testutil.Ok(t, br.Close()) }()
 
 				compareIndexToHeader(t, b, br)
 			})
+
+			t.Run("binary reader v2", func(t *testing.T) {
+				fn := filepath.Join(tmpDir, id.String(), block.IndexHeaderFilename)
+				_, err := WriteBinary(ctx, bkt, id, fn, dummyHistogram, BinaryFormatV2)
+				testutil.Ok(t, err)
+
+				br, err := NewBinaryReader(ctx, log.NewNopLogger(), nil, tmpDir, id, 3, NewBinaryReaderMetrics(nil), BinaryFormatV2)
+				testutil.Ok(t, err)
+
+				defer func() { testutil.Ok(t, br.Close()) }()
+
+				if id == id1 {
+					testutil.Equals(t, BinaryFormatV2, br.version)
+					testutil.Equals(t, 0, br.symbols.Size())
+					testutil.Equals(t, 3, len(br.nameSymbols))
+					testutil.Equals(t, 4, len(br.postings))
+					// Values of a label name fit in a single block.
+					testutil.Equals(t, 1, len(br.postings["a"].offsets))
+					testutil.Equals(t, int64(776), br.postings["a"].lastValOffset)
+					testutil.Equals(t, int64(901), br.postings["longer-string"].lastValOffset)
+
+					rngs, err := br.PostingsOffsets("a", "0", "1", "10", "9", "99")
+					testutil.Ok(t, err)
+					testutil.Equals(t, 5, len(rngs))
+					testutil.Equals(t, NotFoundRange, rngs[0])
+					testutil.Assert(t, rngs[1].End > rngs[1].Start)
+					testutil.Equals(t, NotFoundRange, rngs[2])
+					testutil.Assert(t, rngs[3].End > rngs[3].Start)
+					testutil.Equals(t, NotFoundRange, rngs[4])
+
+					_, err = br.PostingsOffset("not-existing", "1")
+					testutil.Equals(t, NotFoundRangeErr, err)
+					_, err = br.PostingsOffset("longer-string", "21")
+					testutil.Equals(t, NotFoundRangeErr, err)
+				} else {
+					// Index format v1 references symbols by offset, which v2 index-headers don't keep.
+					testutil.Equals(t, BinaryFormatV1, br.version)
+				}
+
+				compareIndexToHeader(t, b, br)
+			})
 		})
 	}
 
@@ -352,6 +393,77 @@ func compareIndexToHeader(t *testing.T, indexByteSlice index.ByteSlice, headerRe
 	testutil.Equals(t, expRanges[labels.Label{Name: "", Value: ""}].End, ptr.End)
 }
 
+func TestBinaryReaderV2(t *testing.T) {
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+
+	bkt, err := filesystem.NewBucket(filepath.Join(tmpDir, "bkt"))
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bkt.Close()) }()
+
+	// High cardinality labels sharing long prefixes, spanning many blocks.
+	var series []labels.Labels
+	for i := 0; i < 500; i++ {
+		series = append(series, labels.FromStrings(
+			"__name__", fmt.Sprintf("http_requests_total_%d", i%7),
+			"namespace", fmt.Sprintf("namespace-%d", i%3),
+			"pod", fmt.Sprintf("api-server-deployment-5d8c7b9f4-%05d", i),
+		))
+	}
+	id, err := e2eutil.CreateBlock(ctx, tmpDir, series, 2, 0, 1000, labels.FromStrings("ext1", "1"), 124, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(tmpDir, id.String()), metadata.NoneFunc))
+
+	indexFile, err := fileutil.OpenMmapFile(filepath.Join(tmpDir, id.String(), block.IndexFilename))
+	testutil.Ok(t, err)
+	defer func() { _ = indexFile.Close() }()
+
+	v1, err := WriteBinary(ctx, bkt, id, "", dummyHistogram, BinaryFormatV1)
+	testutil.Ok(t, err)
+	v2, err := WriteBinary(ctx, bkt, id, "", dummyHistogram, BinaryFormatV2)
+	testutil.Ok(t, err)
+	testutil.Assert(t, len(v2) < len(v1)/2, "expected v2 index-header (%d bytes) to be less than half of v1 (%d bytes)", len(v2), len(v1))
+
+	for format, exp := range map[int][]byte{BinaryFormatV1: v1, BinaryFormatV2: v2} {
+		size, err := BinarySize(ctx, bkt, id, format)
+		testutil.Ok(t, err)
+		testutil.Equals(t, uint64(len(exp)), size)
+	}
+
+	br1, err := newMemoryBinaryReader(v1, 32, NewBinaryReaderMetrics(nil))
+	testutil.Ok(t, err)
+	br2, err := newMemoryBinaryReader(v2, 32, NewBinaryReaderMetrics(nil))
+	testutil.Ok(t, err)
+	compareIndexToHeader(t, realByteSlice(indexFile.Bytes()), br2)
+
+	names, err := br1.LabelNames()
+	testutil.Ok(t, err)
+	for _, name := range names {
+		values, err := br1.LabelValues(name)
+		testutil.Ok(t, err)
+
+		// Ask for every other value, and values in between that don't exist, across blocks.
+		var wanted []string
+		for i, v := range values {
+			if i%2 == 0 {
+				wanted = append(wanted, v, v+"\x00")
+			}
+		}
+		exp, err := br1.PostingsOffsets(name, wanted...)
+		testutil.Ok(t, err)
+		act, err := br2.PostingsOffsets(name, wanted...)
+		testutil.Ok(t, err)
+		testutil.Equals(t, exp, act)
+	}
+
+	// Corrupted sections are detected.
+	corrupted := append([]byte(nil), v2...)
+	corrupted[headerLen+10]++
+	_, err = newMemoryBinaryReader(corrupted, 32, NewBinaryReaderMetrics(nil))
+	testutil.NotOk(t, err)
+}
+
 func prepareIndexV2Block(t testing.TB, tmpDir string, bkt objstore.Bucket) *metadata.Meta {
 	/* Copy index 6MB block index version 2. It was generated via thanosbench. Meta.json:
 		{
@@ -412,7 +524,7 @@ func BenchmarkBinaryWrite(t *testing.B) {
 
 	t.ResetTimer()
 	for i := 0; i < t.N; i++ {
-		_, err := WriteBinary(ctx, bkt, m.ULID, fn, dummyHistogram)
+		_, err := WriteBinary(ctx, bkt, m.ULID, fn, dummyHistogram, BinaryFormatV1)
 		testutil.Ok(t, err)
 	}
 }
@@ -426,7 +538,7 @@ func BenchmarkBinaryReader(t *testing.B) {
 
 	m := prepareIndexV2Block(t, tmpDir, bkt)
 	fn := filepath.Join(tmpDir, m.ULID.String(), block.IndexHeaderFilename)
-	_, err = WriteBinary(ctx, bkt, m.ULID, fn, dummyHistogram)
+	_, err = WriteBinary(ctx, bkt, m.ULID, fn, dummyHistogram, BinaryFormatV1)
 	testutil.Ok(t, err)
 
 	t.ResetTimer()
@@ -469,7 +581,7 @@ func benchmarkBinaryReaderLookupSymbol(b *testing.B, numSeries int) {
 	testutil.Ok(b, block.Upload(ctx, logger, bkt, filepath.Join(tmpDir, id1.String()), metadata.NoneFunc))
 
 	// Create an index reader.
-	reader, err := NewBinaryReader(ctx, logger, bkt, tmpDir, id1, postingOffsetsInMemSampling, NewBinaryReaderMetrics(nil))
+	reader, err := NewBinaryReader(ctx, logger, bkt, tmpDir, id1, postingOffsetsInMemSampling, NewBinaryReaderMetrics(nil), BinaryFormatV1)
 	testutil.Ok(b, err)
 
 	// Get the offset of each label value symbol.
@@ -604,10 +716,10 @@ func TestReaderPostingsOffsets(t *testing.T) {
 	testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(tmpDir, id.String()), metadata.NoneFunc))
 
 	fn := filepath.Join(tmpDir, id.String(), block.IndexHeaderFilename)
-	_, err = WriteBinary(ctx, bkt, id, fn, dummyHistogram)
+	_, err = WriteBinary(ctx, bkt, id, fn, dummyHistogram, BinaryFormatV1)
 	testutil.Ok(t, err)
 
-	br, err := NewBinaryReader(ctx, log.NewNopLogger(), nil, tmpDir, id, 3, NewBinaryReaderMetrics(nil))
+	br, err := NewBinaryReader(ctx, log.NewNopLogger(), nil, tmpDir, id, 3, NewBinaryReaderMetrics(nil), BinaryFormatV1)
 	testutil.Ok(t, err)
 
 	defer func() { testutil.Ok(t, br.Close()) }()
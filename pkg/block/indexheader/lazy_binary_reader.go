@@ -86,6 +86,9 @@ type LazyBinaryReader struct {
 
 	// If true, index header will be downloaded at query time rather than initialization time.
 	lazyDownload bool
+
+	// Format version of the index-header when it has to be built.
+	format int
 }
 
 // NewLazyBinaryReader makes a new LazyBinaryReader. If the index-header does not exist
@@ -103,6 +106,7 @@ func NewLazyBinaryReader(
 	binaryReaderMetrics *BinaryReaderMetrics,
 	onClosed func(*LazyBinaryReader),
 	lazyDownload bool,
+	format int,
 ) (*LazyBinaryReader, error) {
 	if dir != "" && !lazyDownload {
 		indexHeaderFile := filepath.Join(dir, id.String(), block.IndexHeaderFilename)
@@ -115,7 +119,7 @@ func NewLazyBinaryReader(
 			level.Debug(logger).Log("msg", "the index-header doesn't exist on disk; recreating", "path", indexHeaderFile)
 
 			start := time.Now()
-			if _, err := WriteBinary(ctx, bkt, id, indexHeaderFile, binaryReaderMetrics.downloadDuration); err != nil {
+			if _, err := WriteBinary(ctx, bkt, id, indexHeaderFile, binaryReaderMetrics.downloadDuration, format); err != nil {
 				return nil, errors.Wrap(err, "write index header")
 			}
 
@@ -135,6 +139,7 @@ func NewLazyBinaryReader(
 		usedAt:                      atomic.NewInt64(time.Now().UnixNano()),
 		onClosed:                    onClosed,
 		lazyDownload:                lazyDownload,
+		format:                      format,
 	}, nil
 }
 
@@ -227,6 +232,39 @@ func (r *LazyBinaryReader) LabelNames() ([]string, error) {
 	return r.reader.LabelNames()
 }
 
+// PrefetchSize returns the number of bytes Prefetch would load, and false if it is not known before loading,
+// which is the case of an index-header that is not on disk yet.
+func (r *LazyBinaryReader) PrefetchSize() (int64, bool) {
+	r.readerMx.RLock()
+	defer r.readerMx.RUnlock()
+
+	if r.reader != nil {
+		return 0, true
+	}
+	fi, err := os.Stat(filepath.Join(r.dir, r.id.String(), block.IndexHeaderFilename))
+	if err != nil {
+		return 0, false
+	}
+	return fi.Size(), true
+}
+
+// Prefetch loads the index-header, building it first if it's not on disk yet, and returns the number of bytes loaded.
+// Contrary to other Reader functions it doesn't delay the release of an already loaded index-header.
+func (r *LazyBinaryReader) Prefetch() (int, error) {
+	r.readerMx.RLock()
+	defer r.readerMx.RUnlock()
+
+	if r.reader != nil {
+		return 0, nil
+	}
+	if err := r.load(); err != nil {
+		return 0, err
+	}
+
+	r.usedAt.Store(time.Now().UnixNano())
+	return r.reader.b.Len(), nil
+}
+
 // load ensures the underlying binary index-header reader has been successfully loaded. Returns
 // an error on failure. This function MUST be called with the read lock already acquired.
 func (r *LazyBinaryReader) load() (returnErr error) {
@@ -265,7 +303,7 @@ func (r *LazyBinaryReader) load() (returnErr error) {
 	r.metrics.loadCount.Inc()
 	startTime := time.Now()
 
-	reader, err := NewBinaryReader(r.ctx, r.logger, r.bkt, r.dir, r.id, r.postingOffsetsInMemSampling, r.binaryReaderMetrics)
+	reader, err := NewBinaryReader(r.ctx, r.logger, r.bkt, r.dir, r.id, r.postingOffsetsInMemSampling, r.binaryReaderMetrics, r.format)
 	if err != nil {
 		r.metrics.loadFailedCount.Inc()
 		r.readerErr = err
@@ -32,7 +32,7 @@ func TestNewLazyBinaryReader_ShouldFailIfUnableToBuildIndexHeader(t *testing.T)
 	bkt, err := filesystem.NewBucket(filepath.Join(tmpDir, "bkt"))
 	testutil.Ok(t, err)
 	defer func() { testutil.Ok(t, bkt.Close()) }()
-	_, err = NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, ulid.MustNew(0, nil), 3, NewLazyBinaryReaderMetrics(nil), NewBinaryReaderMetrics(nil), nil, false)
+	_, err = NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, ulid.MustNew(0, nil), 3, NewLazyBinaryReaderMetrics(nil), NewBinaryReaderMetrics(nil), nil, false, BinaryFormatV1)
 	testutil.NotOk(t, err)
 }
 
@@ -44,7 +44,7 @@ func TestNewLazyBinaryReader_ShouldNotFailIfUnableToBuildIndexHeaderWhenLazyDown
 	bkt, err := filesystem.NewBucket(filepath.Join(tmpDir, "bkt"))
 	testutil.Ok(t, err)
 	defer func() { testutil.Ok(t, bkt.Close()) }()
-	_, err = NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, ulid.MustNew(0, nil), 3, NewLazyBinaryReaderMetrics(nil), NewBinaryReaderMetrics(nil), nil, true)
+	_, err = NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, ulid.MustNew(0, nil), 3, NewLazyBinaryReaderMetrics(nil), NewBinaryReaderMetrics(nil), nil, true, BinaryFormatV1)
 	testutil.Ok(t, err)
 }
 
@@ -69,7 +69,7 @@ func TestNewLazyBinaryReader_ShouldBuildIndexHeaderFromBucket(t *testing.T) {
 
 			m := NewLazyBinaryReaderMetrics(nil)
 			bm := NewBinaryReaderMetrics(nil)
-			r, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, m, bm, nil, lazyDownload)
+			r, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, m, bm, nil, lazyDownload, BinaryFormatV1)
 			testutil.Ok(t, err)
 			testutil.Assert(t, r.reader == nil)
 			testutil.Equals(t, float64(0), promtestutil.ToFloat64(m.loadCount))
@@ -126,7 +126,7 @@ func TestNewLazyBinaryReader_ShouldRebuildCorruptedIndexHeader(t *testing.T) {
 		t.Run(fmt.Sprintf("lazyDownload=%v", lazyDownload), func(t *testing.T) {
 			m := NewLazyBinaryReaderMetrics(nil)
 			bm := NewBinaryReaderMetrics(nil)
-			r, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, m, bm, nil, lazyDownload)
+			r, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, m, bm, nil, lazyDownload, BinaryFormatV1)
 			testutil.Ok(t, err)
 			testutil.Assert(t, r.reader == nil)
 			testutil.Equals(t, float64(0), promtestutil.ToFloat64(m.loadCount))
@@ -165,7 +165,7 @@ func TestLazyBinaryReader_ShouldReopenOnUsageAfterClose(t *testing.T) {
 		t.Run(fmt.Sprintf("lazyDownload=%v", lazyDownload), func(t *testing.T) {
 			m := NewLazyBinaryReaderMetrics(nil)
 			bm := NewBinaryReaderMetrics(nil)
-			r, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, m, bm, nil, lazyDownload)
+			r, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, m, bm, nil, lazyDownload, BinaryFormatV1)
 			testutil.Ok(t, err)
 			testutil.Assert(t, r.reader == nil)
 
@@ -220,7 +220,7 @@ func TestLazyBinaryReader_unload_ShouldReturnErrorIfNotIdle(t *testing.T) {
 		t.Run(fmt.Sprintf("lazyDownload=%v", lazyDownload), func(t *testing.T) {
 			m := NewLazyBinaryReaderMetrics(nil)
 			bm := NewBinaryReaderMetrics(nil)
-			r, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, m, bm, nil, lazyDownload)
+			r, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, m, bm, nil, lazyDownload, BinaryFormatV1)
 			testutil.Ok(t, err)
 			testutil.Assert(t, r.reader == nil)
 
@@ -274,7 +274,7 @@ func TestLazyBinaryReader_LoadUnloadRaceCondition(t *testing.T) {
 		t.Run(fmt.Sprintf("lazyDownload=%v", lazyDownload), func(t *testing.T) {
 			m := NewLazyBinaryReaderMetrics(nil)
 			bm := NewBinaryReaderMetrics(nil)
-			r, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, m, bm, nil, lazyDownload)
+			r, err := NewLazyBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, m, bm, nil, lazyDownload, BinaryFormatV1)
 			testutil.Ok(t, err)
 			testutil.Assert(t, r.reader == nil)
 			t.Cleanup(func() {
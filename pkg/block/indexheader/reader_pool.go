@@ -50,6 +50,15 @@ type ReaderPool struct {
 	lazyReaders   map[*LazyBinaryReader]struct{}
 
 	lazyDownloadFunc LazyDownloadIndexHeaderFunc
+
+	// Format version of the index-headers built by the pool's readers.
+	format int
+
+	// Access statistics of blocks, used to prefetch the hottest ones.
+	accessMx sync.Mutex
+	access   map[ulid.ULID]*blockAccess
+	// now returns the current time, the accesses decay with.
+	now func() time.Time
 }
 
 // IndexHeaderLazyDownloadStrategy specifies how to download index headers
@@ -87,8 +96,8 @@ func AlwaysLazyDownloadIndexHeader(meta *metadata.Meta) bool {
 	return true
 }
 
-// NewReaderPool makes a new ReaderPool.
-func NewReaderPool(logger log.Logger, lazyReaderEnabled bool, lazyReaderIdleTimeout time.Duration, metrics *ReaderPoolMetrics, lazyDownloadFunc LazyDownloadIndexHeaderFunc) *ReaderPool {
+// NewReaderPool makes a new ReaderPool. Index-headers missing on disk are built in the given format version.
+func NewReaderPool(logger log.Logger, lazyReaderEnabled bool, lazyReaderIdleTimeout time.Duration, metrics *ReaderPoolMetrics, lazyDownloadFunc LazyDownloadIndexHeaderFunc, format int) *ReaderPool {
 	p := &ReaderPool{
 		logger:                logger,
 		metrics:               metrics,
@@ -97,6 +106,9 @@ func NewReaderPool(logger log.Logger, lazyReaderEnabled bool, lazyReaderIdleTime
 		lazyReaders:           make(map[*LazyBinaryReader]struct{}),
 		close:                 make(chan struct{}),
 		lazyDownloadFunc:      lazyDownloadFunc,
+		format:                format,
+		access:                map[ulid.ULID]*blockAccess{},
+		now:                   time.Now,
 	}
 
 	// Start a goroutine to close idle readers (only if required).
@@ -126,9 +138,9 @@ func (p *ReaderPool) NewBinaryReader(ctx context.Context, logger log.Logger, bkt
 	var err error
 
 	if p.lazyReaderEnabled {
-		reader, err = NewLazyBinaryReader(ctx, logger, bkt, dir, id, postingOffsetsInMemSampling, p.metrics.lazyReader, p.metrics.binaryReader, p.onLazyReaderClosed, p.lazyDownloadFunc(meta))
+		reader, err = NewLazyBinaryReader(ctx, logger, bkt, dir, id, postingOffsetsInMemSampling, p.metrics.lazyReader, p.metrics.binaryReader, p.onLazyReaderClosed, p.lazyDownloadFunc(meta), p.format)
 	} else {
-		reader, err = NewBinaryReader(ctx, logger, bkt, dir, id, postingOffsetsInMemSampling, p.metrics.binaryReader)
+		reader, err = NewBinaryReader(ctx, logger, bkt, dir, id, postingOffsetsInMemSampling, p.metrics.binaryReader, p.format)
 	}
 
 	if err != nil {
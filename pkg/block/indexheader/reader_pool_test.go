@@ -6,10 +6,12 @@ package indexheader
 import (
 	"context"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
 	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/thanos-io/objstore/providers/filesystem"
@@ -59,7 +61,7 @@ func TestReaderPool_NewBinaryReader(t *testing.T) {
 
 	for testName, testData := range tests {
 		t.Run(testName, func(t *testing.T) {
-			pool := NewReaderPool(log.NewNopLogger(), testData.lazyReaderEnabled, testData.lazyReaderIdleTimeout, NewReaderPoolMetrics(nil), AlwaysEagerDownloadIndexHeader)
+			pool := NewReaderPool(log.NewNopLogger(), testData.lazyReaderEnabled, testData.lazyReaderIdleTimeout, NewReaderPoolMetrics(nil), AlwaysEagerDownloadIndexHeader, BinaryFormatV1)
 			defer pool.Close()
 
 			r, err := pool.NewBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, meta)
@@ -96,7 +98,7 @@ func TestReaderPool_ShouldCloseIdleLazyReaders(t *testing.T) {
 	testutil.Ok(t, err)
 
 	metrics := NewReaderPoolMetrics(nil)
-	pool := NewReaderPool(log.NewNopLogger(), true, idleTimeout, metrics, AlwaysEagerDownloadIndexHeader)
+	pool := NewReaderPool(log.NewNopLogger(), true, idleTimeout, metrics, AlwaysEagerDownloadIndexHeader, BinaryFormatV1)
 	defer pool.Close()
 
 	r, err := pool.NewBinaryReader(ctx, log.NewNopLogger(), bkt, tmpDir, blockID, 3, meta)
@@ -132,3 +134,108 @@ func TestReaderPool_ShouldCloseIdleLazyReaders(t *testing.T) {
 	testutil.Equals(t, float64(2), promtestutil.ToFloat64(metrics.lazyReader.loadCount))
 	testutil.Equals(t, float64(2), promtestutil.ToFloat64(metrics.lazyReader.unloadCount))
 }
+
+func TestReaderPool_AccessStats(t *testing.T) {
+	pool := NewReaderPool(log.NewNopLogger(), false, 0, NewReaderPoolMetrics(nil), AlwaysEagerDownloadIndexHeader, BinaryFormatV1)
+	defer pool.Close()
+
+	var (
+		cold = ulid.MustNew(1, nil)
+		warm = ulid.MustNew(2, nil)
+		hot  = ulid.MustNew(3, nil)
+	)
+	pool.RecordAccess(cold, nil)
+	for i := 0; i < 2; i++ {
+		pool.RecordAccess(warm, []labels.Label{{Name: "job", Value: "a"}})
+	}
+	for i := 0; i < 3; i++ {
+		pool.RecordAccess(hot, []labels.Label{{Name: "__name__", Value: "up"}})
+	}
+	pool.RecordAccess(hot, []labels.Label{{Name: "job", Value: "b"}, {Name: "__name__", Value: "up"}})
+
+	hottest := pool.HottestBlocks(2)
+	testutil.Equals(t, 2, len(hottest))
+	testutil.Equals(t, hot, hottest[0].ID)
+	testutil.Equals(t, uint64(4), hottest[0].Accesses)
+	testutil.Equals(t, []labels.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "b"}}, hottest[0].Postings)
+	testutil.Equals(t, warm, hottest[1].ID)
+	testutil.Equals(t, 3, len(pool.HottestBlocks(0)))
+
+	pool.ForgetAccess(warm)
+	testutil.Equals(t, 2, len(pool.HottestBlocks(0)))
+
+	t.Run("persist and read back", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "access-stats.json")
+		testutil.Ok(t, pool.WriteAccessStats(path))
+
+		other := NewReaderPool(log.NewNopLogger(), false, 0, NewReaderPoolMetrics(nil), AlwaysEagerDownloadIndexHeader, BinaryFormatV1)
+		defer other.Close()
+		testutil.Ok(t, other.ReadAccessStats(path))
+
+		exp, got := pool.HottestBlocks(0), other.HottestBlocks(0)
+		testutil.Equals(t, len(exp), len(got))
+		for i := range exp {
+			testutil.Equals(t, exp[i].ID, got[i].ID)
+			testutil.Equals(t, exp[i].Accesses, got[i].Accesses)
+			testutil.Equals(t, exp[i].RecentAccesses, got[i].RecentAccesses)
+			testutil.Assert(t, exp[i].LastAccess.Equal(got[i].LastAccess))
+			testutil.Equals(t, exp[i].Postings, got[i].Postings)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		testutil.Ok(t, pool.ReadAccessStats(filepath.Join(t.TempDir(), "missing.json")))
+	})
+}
+
+func TestReaderPool_AccessStatsDecay(t *testing.T) {
+	pool := NewReaderPool(log.NewNopLogger(), false, 0, NewReaderPoolMetrics(nil), AlwaysEagerDownloadIndexHeader, BinaryFormatV1)
+	defer pool.Close()
+
+	now := time.Unix(0, 0)
+	pool.now = func() time.Time { return now }
+
+	busy, recent := ulid.MustNew(1, nil), ulid.MustNew(2, nil)
+	for i := 0; i < 100; i++ {
+		pool.RecordAccess(busy, nil)
+	}
+	testutil.Equals(t, busy, pool.HottestBlocks(1)[0].ID)
+
+	// A block busy two days ago cools down below a block accessed a few times since.
+	now = now.Add(48 * time.Hour)
+	for i := 0; i < 3; i++ {
+		pool.RecordAccess(recent, nil)
+	}
+	hottest := pool.HottestBlocks(0)
+	testutil.Equals(t, recent, hottest[0].ID)
+	testutil.Equals(t, busy, hottest[1].ID)
+	testutil.Equals(t, uint64(100), hottest[1].Accesses)
+
+	// The decay goes on across restarts.
+	path := filepath.Join(t.TempDir(), "access.json")
+	testutil.Ok(t, pool.WriteAccessStats(path))
+	other := NewReaderPool(log.NewNopLogger(), false, 0, NewReaderPoolMetrics(nil), AlwaysEagerDownloadIndexHeader, BinaryFormatV1)
+	defer other.Close()
+	other.now = pool.now
+	testutil.Ok(t, other.ReadAccessStats(path))
+	now = now.Add(time.Hour)
+	hottest = other.HottestBlocks(0)
+	testutil.Equals(t, recent, hottest[0].ID)
+	testutil.Equals(t, busy, hottest[1].ID)
+}
+
+func TestReaderPool_AccessStatsBoundedPostings(t *testing.T) {
+	pool := NewReaderPool(log.NewNopLogger(), false, 0, NewReaderPoolMetrics(nil), AlwaysEagerDownloadIndexHeader, BinaryFormatV1)
+	defer pool.Close()
+
+	id := ulid.MustNew(1, nil)
+	hot := labels.Label{Name: "__name__", Value: "up"}
+	for i := 0; i < 10*maxTrackedPostings; i++ {
+		pool.RecordAccess(id, []labels.Label{hot, {Name: "i", Value: strconv.Itoa(i)}})
+	}
+
+	testutil.Assert(t, len(pool.access[id].postings) <= maxTrackedPostings)
+	stats := pool.HottestBlocks(0)
+	testutil.Assert(t, len(stats[0].Postings) <= maxReportedPostings)
+	testutil.Equals(t, hot, stats[0].Postings[0])
+}
@@ -0,0 +1,142 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package parquet
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/logutil"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// ConvertBlock writes all series of the TSDB block in the given directory to w as a Parquet file.
+func ConvertBlock(ctx context.Context, logger log.Logger, dir string, w io.Writer, rowGroupSize int) (err error) {
+	// The downsample pool also handles raw chunk encodings.
+	b, err := tsdb.OpenBlock(logutil.GoKitLogToSlog(logger), dir, downsample.NewPool(), nil)
+	if err != nil {
+		return errors.Wrap(err, "open block")
+	}
+	defer runutil.CloseWithErrCapture(&err, b, "block reader")
+
+	indexr, err := b.Index()
+	if err != nil {
+		return errors.Wrap(err, "open index")
+	}
+	defer runutil.CloseWithErrCapture(&err, indexr, "index reader")
+
+	chunkr, err := b.Chunks()
+	if err != nil {
+		return errors.Wrap(err, "open chunks")
+	}
+	defer runutil.CloseWithErrCapture(&err, chunkr, "chunk reader")
+
+	names, err := indexr.LabelNames(ctx)
+	if err != nil {
+		return errors.Wrap(err, "read label names")
+	}
+	pw, err := NewWriter(w, names, rowGroupSize)
+	if err != nil {
+		return err
+	}
+
+	k, v := index.AllPostingsKey()
+	p, err := indexr.Postings(ctx, k, v)
+	if err != nil {
+		return errors.Wrap(err, "read postings")
+	}
+
+	var (
+		builder labels.ScratchBuilder
+		metas   []chunks.Meta
+	)
+	// Postings are sorted by series labels, as required by the writer.
+	for p.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := indexr.Series(p.At(), &builder, &metas); err != nil {
+			return errors.Wrap(err, "read series")
+		}
+		row := Row{Labels: builder.Labels(), Chunks: make([]Chunk, 0, len(metas))}
+		for _, m := range metas {
+			chk, iter, err := chunkr.ChunkOrIterable(m)
+			if err != nil {
+				return errors.Wrapf(err, "read chunk %d of series %s", m.Ref, row.Labels)
+			}
+			if iter != nil {
+				return errors.Errorf("unexpected iterable for chunk %d of series %s", m.Ref, row.Labels)
+			}
+			row.Chunks = append(row.Chunks, Chunk{
+				MinTime:  m.MinTime,
+				MaxTime:  m.MaxTime,
+				Encoding: chk.Encoding(),
+				// Bytes are backed by the mmaped segment files, which stay open until all rows are written.
+				Data: chk.Bytes(),
+			})
+		}
+		if err := pw.Write(row); err != nil {
+			return errors.Wrap(err, "write series")
+		}
+	}
+	if err := p.Err(); err != nil {
+		return errors.Wrap(err, "iterate postings")
+	}
+	return pw.Close()
+}
+
+// ConvertAndUpload converts the TSDB block in the given directory to a Parquet file stored
+// in the same directory, and uploads it next to the block in the bucket.
+func ConvertAndUpload(ctx context.Context, logger log.Logger, bkt objstore.Bucket, dir string, rowGroupSize int) (err error) {
+	meta, err := metadata.ReadFromDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "read meta")
+	}
+
+	dst := filepath.Join(dir, block.ParquetFilename)
+	f, err := os.Create(dst)
+	if err != nil {
+		return errors.Wrap(err, "create parquet file")
+	}
+	defer func() {
+		if err != nil {
+			runutil.CloseWithLogOnErr(logger, f, "parquet file")
+			if rerr := os.Remove(dst); rerr != nil {
+				level.Warn(logger).Log("msg", "failed to remove parquet file", "path", dst, "err", rerr)
+			}
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	if err := ConvertBlock(ctx, logger, dir, bw, rowGroupSize); err != nil {
+		return errors.Wrapf(err, "convert block %s", meta.ULID)
+	}
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err, "flush parquet file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "close parquet file")
+	}
+
+	if err := objstore.UploadFile(ctx, logger, bkt, dst, path.Join(meta.ULID.String(), block.ParquetFilename)); err != nil {
+		return errors.Wrap(err, "upload parquet file")
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	parquetgo "github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestWriterReader(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	rows := []Row{
+		{Labels: labels.FromStrings("__name__", "up", "job", "a"), Chunks: []Chunk{
+			{MinTime: 0, MaxTime: 99, Encoding: chunkenc.EncXOR, Data: []byte{1, 2, 3}},
+			{MinTime: 100, MaxTime: 199, Encoding: chunkenc.EncXOR, Data: []byte{4}},
+		}},
+		{Labels: labels.FromStrings("__name__", "up", "job", "b"), Chunks: []Chunk{
+			{MinTime: 50, MaxTime: 150, Encoding: chunkenc.EncHistogram, Data: []byte{5, 6}},
+		}},
+		{Labels: labels.FromStrings("__name__", "up", "job", "c", "zone", "eu"), Chunks: []Chunk{
+			{MinTime: 1000, MaxTime: 1999, Encoding: chunkenc.EncXOR, Data: []byte{7}},
+		}},
+		{Labels: labels.FromStrings("__name__", "up", "job", "d"), Chunks: []Chunk{
+			{MinTime: 1500, MaxTime: 2500, Encoding: chunkenc.EncXOR, Data: []byte{8, 9}},
+		}},
+		{Labels: labels.FromStrings("__name__", "x", "job", "e")},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []string{"zone", "job", "__name__"}, 2)
+	testutil.Ok(t, err)
+	for _, r := range rows {
+		testutil.Ok(t, w.Write(r))
+	}
+	testutil.Ok(t, w.Close())
+
+	bkt := objstore.NewInMemBucket()
+	testutil.Ok(t, bkt.Upload(ctx, "file.parquet", &buf))
+
+	r, err := NewReader(ctx, bkt, "file.parquet")
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"__name__", "job", "zone"}, r.LabelNames())
+	testutil.Equals(t, int64(5), r.NumRows())
+
+	for _, tcase := range []struct {
+		name       string
+		mint, maxt int64
+		skipChunks bool
+		matchers   []*labels.Matcher
+
+		expected       []Row
+		expectedRGRead int
+	}{
+		{
+			name: "all series with chunks",
+			mint: 0, maxt: 10000,
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "__name__", ".+")},
+			expected: rows[:4],
+			// The last row group only holds a series without chunks.
+			expectedRGRead: 2,
+		},
+		{
+			name: "time range drops chunks and prunes row groups",
+			mint: 120, maxt: 130,
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+			expected: []Row{
+				{Labels: rows[0].Labels, Chunks: rows[0].Chunks[1:]},
+				rows[1],
+			},
+			expectedRGRead: 1,
+		},
+		{
+			name: "equal matcher prunes row groups",
+			mint: 0, maxt: 10000,
+			matchers:       []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "d")},
+			expected:       rows[3:4],
+			expectedRGRead: 1,
+		},
+		{
+			name: "set matcher",
+			mint: 0, maxt: 10000,
+			matchers:       []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "job", "a|c")},
+			expected:       []Row{rows[0], rows[2]},
+			expectedRGRead: 2,
+		},
+		{
+			name: "matcher on missing label value",
+			mint: 0, maxt: 10000,
+			matchers:       []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "zone", "")},
+			expected:       []Row{rows[0], rows[1], rows[3]},
+			expectedRGRead: 2,
+		},
+		{
+			name: "matcher on unknown label",
+			mint: 0, maxt: 10000,
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "foo", "bar")},
+		},
+		{
+			name: "skip chunks",
+			mint: 0, maxt: 10000,
+			skipChunks: true,
+			matchers:   []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotEqual, "zone", "")},
+			expected:   []Row{{Labels: rows[2].Labels}},
+			// Only the second row group has a zone label.
+			expectedRGRead: 1,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			var reserved uint64
+			res, stats, err := readAll(r.Select(ctx, tcase.mint, tcase.maxt, tcase.skipChunks, tcase.matchers, func(_ string, size uint64) error {
+				reserved += size
+				return nil
+			}))
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, res)
+			testutil.Equals(t, 3, stats.RowGroupsTotal)
+			testutil.Equals(t, tcase.expectedRGRead, stats.RowGroupsRead)
+			testutil.Equals(t, reserved, uint64(stats.SeriesBytesFetched+stats.ChunkBytesFetched))
+			if tcase.skipChunks {
+				testutil.Equals(t, 0, stats.ChunkBytesFetched)
+			}
+		})
+	}
+}
+
+func TestConvertAndUpload(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	bkt := objstore.NewInMemBucket()
+
+	series := []labels.Labels{
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "2", "b", "1"),
+		labels.FromStrings("a", "3"),
+	}
+	id, err := e2eutil.CreateBlock(ctx, dir, series, 100, 0, 1000, labels.FromStrings("ext", "1"), 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+
+	testutil.Ok(t, ConvertAndUpload(ctx, log.NewNopLogger(), bkt, filepath.Join(dir, id.String()), 2))
+
+	r, err := NewReader(ctx, bkt, path.Join(id.String(), block.ParquetFilename))
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"a", "b"}, r.LabelNames())
+
+	rows, stats, err := readAll(r.Select(ctx, 0, 1000, false, []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "a", "1|2")}, nil))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, stats.RowGroupsRead)
+	testutil.Equals(t, 2, len(rows))
+	for i, r := range rows {
+		testutil.Equals(t, series[i], r.Labels)
+		testutil.Assert(t, len(r.Chunks) > 0)
+
+		var samples int
+		for _, c := range r.Chunks {
+			chk, err := chunkenc.FromData(c.Encoding, c.Data)
+			testutil.Ok(t, err)
+			samples += chk.NumSamples()
+		}
+		testutil.Equals(t, 100, samples)
+	}
+}
+
+// interopRow is the schema of the files written by NewWriter for series with the __name__, job and zone labels.
+type interopRow struct {
+	Name    string `parquet:"l___name__,plain"`
+	Job     string `parquet:"l_job,plain"`
+	Zone    string `parquet:"l_zone,plain"`
+	MinTime int64  `parquet:"s_min_time,plain"`
+	MaxTime int64  `parquet:"s_max_time,plain"`
+	Chunks  []byte `parquet:"s_chunks,plain"`
+}
+
+// encodedRow is interopRow with columns encoded with other encodings than PLAIN.
+type encodedRow struct {
+	Name    string `parquet:"l___name__,dict"`
+	Job     string `parquet:"l_job,delta"`
+	Zone    string `parquet:"l_zone,dict"`
+	MinTime int64  `parquet:"s_min_time,delta"`
+	MaxTime int64  `parquet:"s_max_time,delta"`
+	Chunks  []byte `parquet:"s_chunks"`
+}
+
+func interopRows(rows []Row) []interopRow {
+	res := make([]interopRow, 0, len(rows))
+	for _, r := range rows {
+		mint, maxt := r.timeRange()
+		res = append(res, interopRow{
+			Name:    r.Labels.Get("__name__"),
+			Job:     r.Labels.Get("job"),
+			Zone:    r.Labels.Get("zone"),
+			MinTime: mint,
+			MaxTime: maxt,
+			Chunks:  encodeChunks(r.Chunks),
+		})
+	}
+	return res
+}
+
+// TestInterop checks that the files are readable by, and that the files written by, another Parquet implementation.
+func TestInterop(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	rows := []Row{
+		{Labels: labels.FromStrings("__name__", "up", "job", "a"), Chunks: []Chunk{
+			{MinTime: 0, MaxTime: 99, Encoding: chunkenc.EncXOR, Data: []byte{1, 2, 3}},
+			{MinTime: 100, MaxTime: 199, Encoding: chunkenc.EncXOR, Data: []byte{4}},
+		}},
+		{Labels: labels.FromStrings("__name__", "up", "job", "b", "zone", "eu"), Chunks: []Chunk{
+			{MinTime: -50, MaxTime: 150, Encoding: chunkenc.EncHistogram, Data: []byte{5, 6}},
+		}},
+		{Labels: labels.FromStrings("__name__", "up", "job", "c"), Chunks: []Chunk{
+			{MinTime: 1000, MaxTime: 1999, Encoding: chunkenc.EncXOR, Data: []byte{7}},
+		}},
+	}
+
+	t.Run("written files are readable", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, []string{"__name__", "job", "zone"}, 2)
+		testutil.Ok(t, err)
+		for _, r := range rows {
+			testutil.Ok(t, w.Write(r))
+		}
+		testutil.Ok(t, w.Close())
+
+		f, err := parquetgo.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		testutil.Ok(t, err)
+		testutil.Equals(t, int64(3), f.NumRows())
+		testutil.Equals(t, 2, len(f.RowGroups()))
+		v, ok := f.Lookup(versionKey)
+		testutil.Assert(t, ok, "expected the version in the key value metadata")
+		testutil.Equals(t, version1, v)
+
+		// Statistics of the label and time columns of the first row group.
+		stats := func(column string) format.Statistics {
+			c, ok := f.Schema().Lookup(column)
+			testutil.Assert(t, ok, "expected column %s", column)
+			return f.Metadata().RowGroups[0].Columns[c.ColumnIndex].MetaData.Statistics
+		}
+		testutil.Equals(t, "a", string(stats("l_job").MinValue))
+		testutil.Equals(t, "b", string(stats("l_job").MaxValue))
+		testutil.Equals(t, parquetgo.Int64Value(-50).Bytes(), stats(MinTimeColumn).MinValue)
+		testutil.Equals(t, parquetgo.Int64Value(199).Bytes(), stats(MaxTimeColumn).MaxValue)
+		// The chunks have no meaningful bounds.
+		testutil.Assert(t, stats(ChunksColumn).MinValue == nil)
+
+		r := parquetgo.NewGenericReader[interopRow](bytes.NewReader(buf.Bytes()))
+		defer r.Close()
+		got := make([]interopRow, len(rows))
+		n, err := r.Read(got)
+		if err != io.EOF {
+			testutil.Ok(t, err)
+		}
+		testutil.Equals(t, len(rows), n)
+		testutil.Equals(t, interopRows(rows), got)
+	})
+
+	t.Run("files written by another implementation are readable", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := parquetgo.NewGenericWriter[interopRow](&buf,
+			parquetgo.Compression(&parquetgo.Uncompressed),
+			parquetgo.DataPageVersion(1),
+			parquetgo.KeyValueMetadata(versionKey, version1),
+		)
+		_, err := w.Write(interopRows(rows[:2]))
+		testutil.Ok(t, err)
+		testutil.Ok(t, w.Flush())
+		_, err = w.Write(interopRows(rows[2:]))
+		testutil.Ok(t, err)
+		testutil.Ok(t, w.Close())
+
+		bkt := objstore.NewInMemBucket()
+		testutil.Ok(t, bkt.Upload(ctx, "file.parquet", &buf))
+		r, err := NewReader(ctx, bkt, "file.parquet")
+		testutil.Ok(t, err)
+		testutil.Equals(t, []string{"__name__", "job", "zone"}, r.LabelNames())
+
+		res, stats, err := readAll(r.Select(ctx, 1000, 2000, false, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}, nil))
+		testutil.Ok(t, err)
+		testutil.Equals(t, rows[2:], res)
+		testutil.Equals(t, 1, stats.RowGroupsRead)
+	})
+
+	t.Run("compressed files with other encodings are readable", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := parquetgo.NewGenericWriter[encodedRow](&buf,
+			parquetgo.Compression(&parquetgo.Zstd),
+			parquetgo.KeyValueMetadata(versionKey, version1),
+		)
+		var encoded []encodedRow
+		for _, r := range interopRows(rows) {
+			encoded = append(encoded, encodedRow(r))
+		}
+		_, err := w.Write(encoded)
+		testutil.Ok(t, err)
+		testutil.Ok(t, w.Close())
+
+		bkt := objstore.NewInMemBucket()
+		testutil.Ok(t, bkt.Upload(ctx, "file.parquet", &buf))
+		r, err := NewReader(ctx, bkt, "file.parquet")
+		testutil.Ok(t, err)
+
+		res, _, err := readAll(r.Select(ctx, 0, 2000, false, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}, nil))
+		testutil.Ok(t, err)
+		testutil.Equals(t, rows, res)
+	})
+}
+
+func readAll(set *RowSet) ([]Row, SelectStats, error) {
+	var rows []Row
+	for set.Next() {
+		rows = append(rows, set.At())
+	}
+	return rows, set.Stats(), set.Err()
+}
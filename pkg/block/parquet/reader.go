@@ -0,0 +1,500 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// footerReadSize is the number of bytes read from the end of the file when opening it,
+	// which is enough to get the whole footer in a single request for most blocks.
+	footerReadSize = 64 * 1024
+	// minFileSize is the size of the magic header and footer, and of the footer length.
+	minFileSize = 12
+)
+
+// SelectStats holds statistics about a single Select call.
+type SelectStats struct {
+	RowGroupsTotal int
+	RowGroupsRead  int
+	RowsRead       int
+	RowsMatched    int
+	// SeriesBytesFetched is the size of label and time columns fetched.
+	SeriesBytesFetched int
+	// ChunkBytesFetched is the size of chunk columns fetched.
+	ChunkBytesFetched int
+}
+
+// ReserveFunc is called with the name and size of each column chunk before it is fetched.
+type ReserveFunc func(column string, size uint64) error
+
+// Reader reads series from a Parquet file in object storage.
+type Reader struct {
+	bkt  objstore.BucketReader
+	name string
+	file *parquet.File
+
+	labelNames []string
+	// columns maps column names to their index in each row group.
+	columns map[string]int
+	// chunks holds the column chunks of each row group.
+	chunks [][]*parquet.FileColumnChunk
+}
+
+// NewReader opens the Parquet file with the given name and reads its footer.
+func NewReader(ctx context.Context, bkt objstore.BucketReader, name string) (*Reader, error) {
+	attrs, err := bkt.Attributes(ctx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get attributes")
+	}
+	if attrs.Size < minFileSize {
+		return nil, errors.Errorf("file %s too small to be a parquet file: %d bytes", name, attrs.Size)
+	}
+
+	// Only the footer is read when opening the file, column chunks are fetched by each Select call.
+	f, err := parquet.OpenFile(&bucketReaderAt{ctx: ctx, bkt: bkt, name: name}, attrs.Size,
+		parquet.SkipMagicBytes(true),
+		parquet.SkipPageIndex(true),
+		parquet.SkipBloomFilters(true),
+		parquet.OptimisticRead(true),
+		parquet.ReadBufferSize(footerReadSize),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open file %s", name)
+	}
+	if v, _ := f.Lookup(versionKey); v != version1 {
+		return nil, errors.Errorf("unsupported version %q of file %s", v, name)
+	}
+
+	r := &Reader{bkt: bkt, name: name, file: f, columns: make(map[string]int, len(f.Root().Columns()))}
+	for _, c := range f.Root().Columns() {
+		if !c.Leaf() || c.Repeated() {
+			return nil, errors.Errorf("nested column %s is not supported in file %s", c.Name(), name)
+		}
+		kind := parquet.ByteArray
+		if c.Name() == MinTimeColumn || c.Name() == MaxTimeColumn {
+			kind = parquet.Int64
+		}
+		if c.Type().Kind() != kind {
+			return nil, errors.Errorf("column %s of file %s has type %s, expected %s", c.Name(), name, c.Type().Kind(), kind)
+		}
+		r.columns[c.Name()] = c.Index()
+		if n, ok := strings.CutPrefix(c.Name(), LabelColumnPrefix); ok {
+			r.labelNames = append(r.labelNames, n)
+		}
+	}
+	sort.Strings(r.labelNames)
+
+	for _, n := range []string{MinTimeColumn, MaxTimeColumn, ChunksColumn} {
+		if _, ok := r.columns[n]; !ok {
+			return nil, errors.Errorf("missing column %s in file %s", n, name)
+		}
+	}
+	for i, rg := range f.RowGroups() {
+		chunks := make([]*parquet.FileColumnChunk, 0, len(r.columns))
+		for _, c := range rg.ColumnChunks() {
+			fc, ok := c.(*parquet.FileColumnChunk)
+			if !ok {
+				return nil, errors.Errorf("unexpected column chunk %T in row group %d", c, i)
+			}
+			chunks = append(chunks, fc)
+		}
+		r.chunks = append(r.chunks, chunks)
+	}
+	return r, nil
+}
+
+// LabelNames returns the sorted label names of all series in the file.
+func (r *Reader) LabelNames() []string {
+	return r.labelNames
+}
+
+// NumRows returns the number of series in the file.
+func (r *Reader) NumRows() int64 {
+	return r.file.NumRows()
+}
+
+// Select returns the series matching the given matchers that have chunks overlapping with
+// the given time range. Chunks outside of the time range are dropped, and no chunks are
+// returned if skipChunks is true. Row groups that can't contain matching series are skipped
+// using column statistics, the others are read one at a time as the returned set is iterated,
+// so that at most one row group is held in memory.
+func (r *Reader) Select(ctx context.Context, mint, maxt int64, skipChunks bool, matchers []*labels.Matcher, reserve ReserveFunc) *RowSet {
+	s := &RowSet{
+		r:          r,
+		ctx:        ctx,
+		mint:       mint,
+		maxt:       maxt,
+		skipChunks: skipChunks,
+		reserve:    reserve,
+		stats:      SelectStats{RowGroupsTotal: len(r.chunks)},
+	}
+
+	// Matchers on labels that are not present in the file are evaluated once against the empty value.
+	for _, m := range matchers {
+		if _, ok := r.columns[LabelColumnPrefix+m.Name]; ok {
+			s.matchers = append(s.matchers, m)
+			continue
+		}
+		if !m.Matches("") {
+			s.rg = len(r.chunks)
+			break
+		}
+	}
+	return s
+}
+
+// RowSet iterates over the rows selected by Reader.Select.
+type RowSet struct {
+	r          *Reader
+	ctx        context.Context
+	mint, maxt int64
+	skipChunks bool
+	matchers   []*labels.Matcher
+	reserve    ReserveFunc
+
+	// rg is the index of the next row group to read.
+	rg    int
+	rows  []Row
+	cur   Row
+	err   error
+	stats SelectStats
+}
+
+// Next advances to the next matching row, reading the next row groups as needed.
+func (s *RowSet) Next() bool {
+	for len(s.rows) == 0 {
+		if s.err != nil || s.rg >= len(s.r.chunks) {
+			return false
+		}
+		if err := s.ctx.Err(); err != nil {
+			s.err = err
+			return false
+		}
+		rg := s.rg
+		s.rg++
+		if !s.r.mayMatch(rg, s.mint, s.maxt, s.matchers) {
+			continue
+		}
+		s.stats.RowGroupsRead++
+
+		rows, err := s.r.selectRowGroup(s.ctx, rg, s.mint, s.maxt, s.skipChunks, s.matchers, s.reserve, &s.stats)
+		if err != nil {
+			s.err = errors.Wrapf(err, "read row group %d", rg)
+			return false
+		}
+		s.rows = rows
+	}
+	s.cur, s.rows = s.rows[0], s.rows[1:]
+	return true
+}
+
+// At returns the current row.
+func (s *RowSet) At() Row {
+	return s.cur
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (s *RowSet) Err() error {
+	return s.err
+}
+
+// Stats returns the statistics of the row groups read so far.
+func (s *RowSet) Stats() SelectStats {
+	return s.stats
+}
+
+// mayMatch returns false if column statistics prove that no series of the row group matches.
+func (r *Reader) mayMatch(rg int, mint, maxt int64, matchers []*labels.Matcher) bool {
+	chunks := r.chunks[rg]
+	if min, _, ok := chunks[r.columns[MinTimeColumn]].Bounds(); ok && min.Int64() > maxt {
+		return false
+	}
+	if _, max, ok := chunks[r.columns[MaxTimeColumn]].Bounds(); ok && max.Int64() < mint {
+		return false
+	}
+	for _, m := range matchers {
+		min, max, ok := chunks[r.columns[LabelColumnPrefix+m.Name]].Bounds()
+		if !ok {
+			continue
+		}
+		if !boundsMayMatch(m, min.ByteArray(), max.ByteArray()) {
+			return false
+		}
+	}
+	return true
+}
+
+func boundsMayMatch(m *labels.Matcher, min, max []byte) bool {
+	inRange := func(v string) bool {
+		return bytes.Compare([]byte(v), min) >= 0 && bytes.Compare([]byte(v), max) <= 0
+	}
+
+	switch m.Type {
+	case labels.MatchEqual:
+		return inRange(m.Value)
+	case labels.MatchNotEqual:
+		// Only prunable if all values in the row group are equal to the rejected one.
+		return !bytes.Equal(min, max) || string(min) != m.Value
+	case labels.MatchRegexp:
+		if set := m.SetMatches(); len(set) > 0 {
+			for _, v := range set {
+				if inRange(v) {
+					return true
+				}
+			}
+			return false
+		}
+		if p := m.Prefix(); p != "" {
+			// All values smaller than the prefix, or all values greater than every string with the prefix.
+			if bytes.Compare(max, []byte(p)) < 0 {
+				return false
+			}
+			if bytes.Compare(min, []byte(p)) > 0 && !bytes.HasPrefix(min, []byte(p)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+func (r *Reader) selectRowGroup(
+	ctx context.Context,
+	rg int,
+	mint, maxt int64,
+	skipChunks bool,
+	matchers []*labels.Matcher,
+	reserve ReserveFunc,
+	stats *SelectStats,
+) ([]Row, error) {
+	numRows := int(r.file.RowGroups()[rg].NumRows())
+	stats.RowsRead += numRows
+
+	// Start with time columns and columns used by matchers, so that remaining columns
+	// are only fetched if there is at least one matching series.
+	selected := make([]bool, numRows)
+	for i := range selected {
+		selected[i] = true
+	}
+	mints, err := r.readColumn(ctx, rg, MinTimeColumn, reserve, stats)
+	if err != nil {
+		return nil, err
+	}
+	maxts, err := r.readColumn(ctx, rg, MaxTimeColumn, reserve, stats)
+	if err != nil {
+		return nil, err
+	}
+	for i := range selected {
+		// Series without chunks have min time greater than max time.
+		if smint, smaxt := mints[i].Int64(), maxts[i].Int64(); smint > smaxt || smint > maxt || smaxt < mint {
+			selected[i] = false
+		}
+	}
+
+	values := make(map[string][]parquet.Value, len(r.labelNames))
+	for _, m := range matchers {
+		if !anySelected(selected) {
+			return nil, nil
+		}
+		vals, ok := values[m.Name]
+		if !ok {
+			if vals, err = r.readColumn(ctx, rg, LabelColumnPrefix+m.Name, reserve, stats); err != nil {
+				return nil, err
+			}
+			values[m.Name] = vals
+		}
+		for i, v := range vals {
+			if selected[i] && !m.Matches(string(v.ByteArray())) {
+				selected[i] = false
+			}
+		}
+	}
+	if !anySelected(selected) {
+		return nil, nil
+	}
+
+	for _, n := range r.labelNames {
+		if _, ok := values[n]; ok {
+			continue
+		}
+		vals, err := r.readColumn(ctx, rg, LabelColumnPrefix+n, reserve, stats)
+		if err != nil {
+			return nil, err
+		}
+		values[n] = vals
+	}
+	var chks []parquet.Value
+	if !skipChunks {
+		if chks, err = r.readColumn(ctx, rg, ChunksColumn, reserve, stats); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		rows []Row
+		b    labels.ScratchBuilder
+	)
+	for i := range selected {
+		if !selected[i] {
+			continue
+		}
+		b.Reset()
+		for _, n := range r.labelNames {
+			if v := values[n][i].ByteArray(); len(v) > 0 {
+				b.Add(n, string(v))
+			}
+		}
+		// Label names are sorted already.
+		row := Row{Labels: b.Labels()}
+		if !skipChunks {
+			all, err := decodeChunks(chks[i].ByteArray())
+			if err != nil {
+				return nil, errors.Wrapf(err, "decode chunks of series %s", row.Labels)
+			}
+			for _, c := range all {
+				if c.MaxTime >= mint && c.MinTime <= maxt {
+					row.Chunks = append(row.Chunks, c)
+				}
+			}
+			if len(row.Chunks) == 0 {
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+	stats.RowsMatched += len(rows)
+	return rows, nil
+}
+
+func anySelected(selected []bool) bool {
+	for _, s := range selected {
+		if s {
+			return true
+		}
+	}
+	return false
+}
+
+// readColumn fetches the column chunk of the given row group in a single request, and returns its values.
+func (r *Reader) readColumn(ctx context.Context, rg int, column string, reserve ReserveFunc, stats *SelectStats) (_ []parquet.Value, err error) {
+	c := r.chunks[rg][r.columns[column]]
+	meta := r.file.Metadata().RowGroups[rg].Columns[r.columns[column]].MetaData
+	// The pages of the chunk start with the dictionary page, if any.
+	off := meta.DataPageOffset
+	if meta.DictionaryPageOffset != 0 {
+		off = meta.DictionaryPageOffset
+	}
+	if reserve != nil {
+		if err := reserve(column, uint64(meta.TotalCompressedSize)); err != nil {
+			return nil, err
+		}
+	}
+	b, err := readRange(ctx, r.bkt, r.name, off, meta.TotalCompressedSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read column %s", column)
+	}
+	if column == ChunksColumn {
+		stats.ChunkBytesFetched += len(b)
+	} else {
+		stats.SeriesBytesFetched += len(b)
+	}
+
+	pages := c.PagesFrom(&bytesReaderAt{b: b, off: off})
+	defer runutil.CloseWithErrCapture(&err, pages, "pages of column %s", column)
+
+	numRows := r.file.RowGroups()[rg].NumRows()
+	values := make([]parquet.Value, 0, numRows)
+	for {
+		p, err := pages.ReadPage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "read page of column %s", column)
+		}
+		// Pages are not released, values reference their data.
+		if values, err = appendPageValues(values, p); err != nil {
+			return nil, errors.Wrapf(err, "read values of column %s", column)
+		}
+	}
+	if int64(len(values)) != numRows {
+		return nil, errors.Errorf("column %s has %d values, expected %d", column, len(values), numRows)
+	}
+	return values, nil
+}
+
+func appendPageValues(values []parquet.Value, p parquet.Page) ([]parquet.Value, error) {
+	n := len(values)
+	values = append(values, make([]parquet.Value, p.NumValues())...)
+	vr := p.Values()
+	for read := n; read < len(values); {
+		c, err := vr.ReadValues(values[read:])
+		read += c
+		if err == io.EOF {
+			return values[:read], nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func readRange(ctx context.Context, bkt objstore.BucketReader, name string, off, length int64) ([]byte, error) {
+	rc, err := bkt.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(rc, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// bucketReaderAt reads ranges of an object, with the given context.
+type bucketReaderAt struct {
+	ctx  context.Context
+	bkt  objstore.BucketReader
+	name string
+}
+
+func (r *bucketReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	b, err := readRange(r.ctx, r.bkt, r.name, off, int64(len(p)))
+	return copy(p, b), err
+}
+
+// bytesReaderAt reads a range of a file fetched already, starting at the given offset in the file.
+type bytesReaderAt struct {
+	b   []byte
+	off int64
+}
+
+func (r *bytesReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	off -= r.off
+	if off < 0 || off > int64(len(r.b)) {
+		return 0, errors.Errorf("offset %d out of the fetched range", off+r.off)
+	}
+	n := copy(p, r.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package parquet implements a columnar representation of TSDB blocks stored as a
+// Parquet file next to the block. Each row holds one series: every label name of the
+// block is a column, and the chunks of the series are stored in a binary column.
+// Rows are sorted by labels and grouped in row groups, whose column statistics allow
+// readers to skip row groups that can't contain matching series.
+package parquet
+
+import (
+	"encoding/binary"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+const (
+	// LabelColumnPrefix is the prefix of all columns holding label values.
+	LabelColumnPrefix = "l_"
+	// MinTimeColumn holds the minimum time of all chunks of a series.
+	MinTimeColumn = "s_min_time"
+	// MaxTimeColumn holds the maximum time of all chunks of a series.
+	MaxTimeColumn = "s_max_time"
+	// ChunksColumn holds the encoded chunks of a series.
+	ChunksColumn = "s_chunks"
+
+	// DefaultRowGroupSize is the default number of series per row group.
+	DefaultRowGroupSize = 10000
+
+	versionKey = "thanos.parquet.version"
+	version1   = "1"
+)
+
+// Chunk is a single chunk of a series.
+type Chunk struct {
+	MinTime, MaxTime int64
+	Encoding         chunkenc.Encoding
+	Data             []byte
+}
+
+// Row is a single series.
+type Row struct {
+	Labels labels.Labels
+	Chunks []Chunk
+}
+
+func (r Row) timeRange() (int64, int64) {
+	if len(r.Chunks) == 0 {
+		return 0, -1
+	}
+	mint, maxt := r.Chunks[0].MinTime, r.Chunks[0].MaxTime
+	for _, c := range r.Chunks[1:] {
+		mint = min(mint, c.MinTime)
+		maxt = max(maxt, c.MaxTime)
+	}
+	return mint, maxt
+}
+
+// Writer writes series as rows of a Parquet file. Rows must be written in label order.
+type Writer struct {
+	w          *parquet.Writer
+	labelNames []string
+
+	// Index of the column of each label name, and of the time and chunks columns.
+	labelColumns                               []int
+	minTimeColumn, maxTimeColumn, chunksColumn int
+
+	row parquet.Row
+}
+
+// NewWriter returns a new Writer for series with the given label names.
+func NewWriter(w io.Writer, labelNames []string, rowGroupSize int) (*Writer, error) {
+	if rowGroupSize <= 0 {
+		return nil, errors.Errorf("invalid row group size %d", rowGroupSize)
+	}
+	names := append([]string(nil), labelNames...)
+	sort.Strings(names)
+	names = slices.Compact(names)
+
+	// All columns are required, series without a label hold an empty value.
+	group := parquet.Group{
+		MinTimeColumn: parquet.Leaf(parquet.Int64Type),
+		MaxTimeColumn: parquet.Leaf(parquet.Int64Type),
+		ChunksColumn:  parquet.Leaf(parquet.ByteArrayType),
+	}
+	for _, n := range names {
+		group[LabelColumnPrefix+n] = parquet.String()
+	}
+	schema := parquet.NewSchema("schema", group)
+	columnIndex := func(name string) int {
+		c, _ := schema.Lookup(name)
+		return c.ColumnIndex
+	}
+
+	pw := &Writer{
+		w: parquet.NewWriter(w, schema,
+			parquet.MaxRowsPerRowGroup(int64(rowGroupSize)),
+			parquet.KeyValueMetadata(versionKey, version1),
+			// The bounds of encoded chunks are meaningless, and would only grow the footer.
+			parquet.SkipPageBounds(ChunksColumn),
+		),
+		labelNames:    names,
+		labelColumns:  make([]int, 0, len(names)),
+		minTimeColumn: columnIndex(MinTimeColumn),
+		maxTimeColumn: columnIndex(MaxTimeColumn),
+		chunksColumn:  columnIndex(ChunksColumn),
+		row:           make(parquet.Row, len(schema.Columns())),
+	}
+	for _, n := range names {
+		pw.labelColumns = append(pw.labelColumns, columnIndex(LabelColumnPrefix+n))
+	}
+	return pw, nil
+}
+
+// Write adds a series to the file.
+func (w *Writer) Write(r Row) error {
+	for i, n := range w.labelNames {
+		c := w.labelColumns[i]
+		w.row[c] = parquet.ByteArrayValue([]byte(r.Labels.Get(n))).Level(0, 0, c)
+	}
+	mint, maxt := r.timeRange()
+	w.row[w.minTimeColumn] = parquet.Int64Value(mint).Level(0, 0, w.minTimeColumn)
+	w.row[w.maxTimeColumn] = parquet.Int64Value(maxt).Level(0, 0, w.maxTimeColumn)
+	w.row[w.chunksColumn] = parquet.ByteArrayValue(encodeChunks(r.Chunks)).Level(0, 0, w.chunksColumn)
+
+	_, err := w.w.WriteRows([]parquet.Row{w.row})
+	return err
+}
+
+// Close flushes all buffered rows and writes the file footer.
+func (w *Writer) Close() error {
+	return w.w.Close()
+}
+
+// encodeChunks encodes chunks as a sequence of
+// <varint min time> <uvarint max time delta> <byte encoding> <uvarint length> <data>.
+func encodeChunks(chks []Chunk) []byte {
+	var b []byte
+	for _, c := range chks {
+		b = binary.AppendVarint(b, c.MinTime)
+		b = binary.AppendUvarint(b, uint64(c.MaxTime-c.MinTime))
+		b = append(b, byte(c.Encoding))
+		b = binary.AppendUvarint(b, uint64(len(c.Data)))
+		b = append(b, c.Data...)
+	}
+	return b
+}
+
+func decodeChunks(b []byte) ([]Chunk, error) {
+	var chks []Chunk
+	for len(b) > 0 {
+		mint, n := binary.Varint(b)
+		if n <= 0 {
+			return nil, errors.New("invalid chunk min time")
+		}
+		b = b[n:]
+		delta, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("invalid chunk max time")
+		}
+		b = b[n:]
+		if len(b) == 0 {
+			return nil, errors.New("missing chunk encoding")
+		}
+		enc := chunkenc.Encoding(b[0])
+		b = b[1:]
+		l, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < l {
+			return nil, errors.New("invalid chunk length")
+		}
+		b = b[n:]
+		chks = append(chks, Chunk{MinTime: mint, MaxTime: mint + int64(delta), Encoding: enc, Data: b[:l]})
+		b = b[l:]
+	}
+	return chks, nil
+}
@@ -47,6 +47,11 @@ const (
 	// DedupAlgorithmPenalty is the penalty based compactor series merge algorithm.
 	// This is the same as the online deduplication of querier except counter reset handling.
 	DedupAlgorithmPenalty = "penalty"
+	// DedupAlgorithmQuorum is the compactor series merge algorithm picking the median of the samples of all the
+	// replicas for each scrape.
+	DedupAlgorithmQuorum = "quorum"
+	// DedupAlgorithmLongest is the compactor series merge algorithm picking the replica with the most samples.
+	DedupAlgorithmLongest = "longest"
 )
 
 // Syncer synchronizes block metas from a bucket into a local directory.
@@ -0,0 +1,54 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/parquet"
+)
+
+// ParquetCompactionLifecycleCallback wraps a CompactionLifecycleCallback and, after each compaction,
+// converts the resulting block to a Parquet file uploaded next to it in the bucket.
+type ParquetCompactionLifecycleCallback struct {
+	CompactionLifecycleCallback
+
+	compactDir   string
+	bkt          objstore.Bucket
+	rowGroupSize int
+}
+
+// NewParquetCompactionLifecycleCallback returns a new ParquetCompactionLifecycleCallback.
+func NewParquetCompactionLifecycleCallback(callback CompactionLifecycleCallback, compactDir string, bkt objstore.Bucket, rowGroupSize int) *ParquetCompactionLifecycleCallback {
+	return &ParquetCompactionLifecycleCallback{
+		CompactionLifecycleCallback: callback,
+		compactDir:                  compactDir,
+		bkt:                         bkt,
+		rowGroupSize:                rowGroupSize,
+	}
+}
+
+func (c *ParquetCompactionLifecycleCallback) PostCompactionCallback(ctx context.Context, logger log.Logger, group *Group, blockID ulid.ULID) error {
+	if err := c.CompactionLifecycleCallback.PostCompactionCallback(ctx, logger, group, blockID); err != nil {
+		return err
+	}
+
+	// The Parquet file is optional: readers fall back to the TSDB index without it, and it can
+	// be backfilled later on, so a failed conversion doesn't fail the compaction.
+	begin := time.Now()
+	bdir := filepath.Join(c.compactDir, group.Key(), blockID.String())
+	if err := parquet.ConvertAndUpload(ctx, logger, c.bkt, bdir, c.rowGroupSize); err != nil {
+		level.Warn(logger).Log("msg", "failed to convert compacted block to parquet", "result_block", blockID, "err", err)
+		return nil
+	}
+	level.Info(logger).Log("msg", "uploaded parquet file", "result_block", blockID, "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds())
+	return nil
+}
@@ -17,6 +17,19 @@ import (
 // NewChunkSeriesMerger merges several chunk series into one.
 // Deduplication is based on penalty based deduplication algorithm without handling counter reset.
 func NewChunkSeriesMerger() storage.VerticalChunkSeriesMergeFunc {
+	return NewChunkSeriesMergerWithAlgorithm(AlgorithmPenalty)
+}
+
+// NewChunkSeriesMergerWithAlgorithm merges several chunk series into one, deduplicating overlapping chunks with the
+// given algorithm. Supported algorithms are penalty, quorum and longest, penalty being used for any other value.
+func NewChunkSeriesMergerWithAlgorithm(algorithm string) storage.VerticalChunkSeriesMergeFunc {
+	samplesMergeFunc := penaltySamplesMerge
+	switch algorithm {
+	case AlgorithmQuorum:
+		samplesMergeFunc = func(replicas []chunkenc.Iterable) chunkenc.Iterator { return newQuorumSeriesIterator(replicas) }
+	case AlgorithmLongest:
+		samplesMergeFunc = newLongestSeriesIterator
+	}
 	return func(series ...storage.ChunkSeries) storage.ChunkSeries {
 		if len(series) == 0 {
 			return nil
@@ -30,16 +43,34 @@ func NewChunkSeriesMerger() storage.VerticalChunkSeriesMergeFunc {
 				}
 				return &dedupChunksIterator{
 					iterators: iterators,
+					// Identical chunks are votes of their replicas.
+					keepDuplicates:   algorithm == AlgorithmQuorum,
+					samplesMergeFunc: samplesMergeFunc,
 				}
 			},
 		}
 	}
 }
 
+// penaltySamplesMerge merges the replicas with the penalty based deduplication algorithm.
+func penaltySamplesMerge(replicas []chunkenc.Iterable) chunkenc.Iterator {
+	it := replicas[0].Iterator(nil)
+	for _, r := range replicas[1:] {
+		it = newDedupSeriesIterator(
+			noopAdjustableSeriesIterator{it},
+			noopAdjustableSeriesIterator{r.Iterator(nil)},
+		)
+	}
+	return it
+}
+
 type dedupChunksIterator struct {
 	iterators []chunks.Iterator
 	h         chunkIteratorHeap
 
+	keepDuplicates   bool
+	samplesMergeFunc func(replicas []chunkenc.Iterable) chunkenc.Iterator
+
 	err  error
 	curr chunks.Meta
 }
@@ -69,7 +100,7 @@ func (d *dedupChunksIterator) Next() bool {
 	}
 
 	var (
-		om       = newOverlappingMerger()
+		om       = newOverlappingMerger(d.samplesMergeFunc)
 		oMaxTime = d.curr.MaxTime
 		prev     = d.curr
 	)
@@ -83,7 +114,8 @@ func (d *dedupChunksIterator) Next() bool {
 			break
 		}
 
-		if next.MinTime == prev.MinTime &&
+		if !d.keepDuplicates &&
+			next.MinTime == prev.MinTime &&
 			next.MaxTime == prev.MaxTime &&
 			bytes.Equal(next.Chunk.Bytes(), prev.Chunk.Bytes()) {
 			// 1:1 duplicates, skip it.
@@ -151,38 +183,33 @@ func (h *chunkIteratorHeap) Pop() interface{} {
 }
 
 type overlappingMerger struct {
-	xorIterators       []chunkenc.Iterator
-	histIterators      []chunkenc.Iterator
-	floatHistIterators []chunkenc.Iterator
-	aggrIterators      [5][]chunkenc.Iterator
+	xorChunks       []chunkenc.Iterable
+	histChunks      []chunkenc.Iterable
+	floatHistChunks []chunkenc.Iterable
+	aggrChunks      [5][]chunkenc.Iterable
 
-	samplesMergeFunc func(a, b chunkenc.Iterator) chunkenc.Iterator
+	samplesMergeFunc func(replicas []chunkenc.Iterable) chunkenc.Iterator
 }
 
-func newOverlappingMerger() *overlappingMerger {
+func newOverlappingMerger(samplesMergeFunc func(replicas []chunkenc.Iterable) chunkenc.Iterator) *overlappingMerger {
 	return &overlappingMerger{
-		samplesMergeFunc: func(a, b chunkenc.Iterator) chunkenc.Iterator {
-			return newDedupSeriesIterator(
-				noopAdjustableSeriesIterator{a},
-				noopAdjustableSeriesIterator{b},
-			)
-		},
+		samplesMergeFunc: samplesMergeFunc,
 	}
 }
 
 func (o *overlappingMerger) addChunk(chk chunks.Meta) {
 	switch chk.Chunk.Encoding() {
 	case chunkenc.EncXOR:
-		o.xorIterators = append(o.xorIterators, chk.Chunk.Iterator(nil))
+		o.xorChunks = append(o.xorChunks, chk.Chunk)
 	case chunkenc.EncFloatHistogram:
-		o.floatHistIterators = append(o.floatHistIterators, chk.Chunk.Iterator(nil))
+		o.floatHistChunks = append(o.floatHistChunks, chk.Chunk)
 	case chunkenc.EncHistogram:
-		o.histIterators = append(o.histIterators, chk.Chunk.Iterator(nil))
+		o.histChunks = append(o.histChunks, chk.Chunk)
 	case downsample.ChunkEncAggr:
 		aggrChk := chk.Chunk.(*downsample.AggrChunk)
 		for i := downsample.AggrCount; i <= downsample.AggrCounter; i++ {
 			if c, err := aggrChk.Get(i); err == nil {
-				o.aggrIterators[i] = append(o.aggrIterators[i], c.Iterator(nil))
+				o.aggrChunks[i] = append(o.aggrChunks[i], c)
 			}
 		}
 	case chunkenc.EncNone:
@@ -193,49 +220,23 @@ func (o *overlappingMerger) addChunk(chk chunks.Meta) {
 }
 
 func (o *overlappingMerger) empty() bool {
-	if len(o.xorIterators) > 0 || len(o.histIterators) > 0 || len(o.floatHistIterators) > 0 {
+	if len(o.xorChunks) > 0 || len(o.histChunks) > 0 || len(o.floatHistChunks) > 0 {
 		return false
 	}
-	return len(o.aggrIterators[downsample.AggrCount]) == 0
+	return len(o.aggrChunks[downsample.AggrCount]) == 0
 }
 
 // Return a chunk iterator based on the encoding of base chunk.
 func (o *overlappingMerger) iterator(baseChk chunks.Meta) chunks.Iterator {
-	var it chunkenc.Iterator
+	var replicas []chunkenc.Iterable
 	switch baseChk.Chunk.Encoding() {
 	case chunkenc.EncXOR:
 		// If XOR encoding, we need to deduplicate the samples and re-encode them to chunks.
-		return storage.NewSeriesToChunkEncoder(&storage.SeriesEntry{
-			SampleIteratorFn: func(_ chunkenc.Iterator) chunkenc.Iterator {
-				it = baseChk.Chunk.Iterator(nil)
-				for _, i := range o.xorIterators {
-					it = o.samplesMergeFunc(it, i)
-				}
-				return it
-			}}).Iterator(nil)
-
+		replicas = append([]chunkenc.Iterable{baseChk.Chunk}, o.xorChunks...)
 	case chunkenc.EncHistogram:
-		return storage.NewSeriesToChunkEncoder(&storage.SeriesEntry{
-			SampleIteratorFn: func(_ chunkenc.Iterator) chunkenc.Iterator {
-				it = baseChk.Chunk.Iterator(nil)
-				for _, i := range o.histIterators {
-					it = o.samplesMergeFunc(it, i)
-				}
-				return it
-			},
-		}).Iterator(nil)
-
+		replicas = append([]chunkenc.Iterable{baseChk.Chunk}, o.histChunks...)
 	case chunkenc.EncFloatHistogram:
-		return storage.NewSeriesToChunkEncoder(&storage.SeriesEntry{
-			SampleIteratorFn: func(_ chunkenc.Iterator) chunkenc.Iterator {
-				it = baseChk.Chunk.Iterator(nil)
-				for _, i := range o.floatHistIterators {
-					it = o.samplesMergeFunc(it, i)
-				}
-				return it
-			},
-		}).Iterator(nil)
-
+		replicas = append([]chunkenc.Iterable{baseChk.Chunk}, o.floatHistChunks...)
 	case downsample.ChunkEncAggr:
 		// If Aggr encoding, each aggregated chunks need to be expanded and deduplicated,
 		// then re-encoded into Aggr chunks.
@@ -243,14 +244,11 @@ func (o *overlappingMerger) iterator(baseChk chunks.Meta) chunks.Iterator {
 		samplesIter := [5]chunkenc.Iterator{}
 		for i := downsample.AggrCount; i <= downsample.AggrCounter; i++ {
 			if c, err := aggrChk.Get(i); err == nil {
-				o.aggrIterators[i] = append(o.aggrIterators[i], c.Iterator(nil))
+				o.aggrChunks[i] = append(o.aggrChunks[i], c)
 			}
 
-			if len(o.aggrIterators[i]) > 0 {
-				for _, j := range o.aggrIterators[i][1:] {
-					o.aggrIterators[i][0] = o.samplesMergeFunc(o.aggrIterators[i][0], j)
-				}
-				samplesIter[i] = o.aggrIterators[i][0]
+			if len(o.aggrChunks[i]) > 0 {
+				samplesIter[i] = o.samplesMergeFunc(o.aggrChunks[i])
 			} else {
 				samplesIter[i] = nil
 			}
@@ -260,6 +258,12 @@ func (o *overlappingMerger) iterator(baseChk chunks.Meta) chunks.Iterator {
 	default:
 		return nil
 	}
+
+	return storage.NewSeriesToChunkEncoder(&storage.SeriesEntry{
+		SampleIteratorFn: func(_ chunkenc.Iterator) chunkenc.Iterator {
+			return o.samplesMergeFunc(replicas)
+		},
+	}).Iterator(nil)
 }
 
 type aggrChunkIterator struct {
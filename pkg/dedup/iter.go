@@ -18,6 +18,11 @@ import (
 const (
 	AlgorithmPenalty = "penalty"
 	AlgorithmChain   = "chain"
+	// AlgorithmQuorum picks the median of the samples of all the replicas for each scrape.
+	AlgorithmQuorum = "quorum"
+	// AlgorithmLongest picks the replica with the most samples wherever it has samples, the other replicas filling the
+	// rest of the time range.
+	AlgorithmLongest = "longest"
 )
 
 type dedupSeriesSet struct {
@@ -160,13 +165,30 @@ func (s *dedupSeriesSet) At() storage.Series {
 	repl := make([]storage.Series, len(s.replicas))
 	copy(repl, s.replicas)
 
-	if s.deduplicationFunc == AlgorithmChain {
+	switch s.deduplicationFunc {
+	case AlgorithmChain:
 		return seriesWithLabels{Series: storage.ChainedSeriesMerge(repl...), lset: s.lset}
-	} else {
+	case AlgorithmQuorum:
+		return &storage.SeriesEntry{Lset: s.lset, SampleIteratorFn: func(chunkenc.Iterator) chunkenc.Iterator {
+			return newQuorumSeriesIterator(iterables(repl))
+		}}
+	case AlgorithmLongest:
+		return &storage.SeriesEntry{Lset: s.lset, SampleIteratorFn: func(chunkenc.Iterator) chunkenc.Iterator {
+			return newLongestSeriesIterator(iterables(repl))
+		}}
+	default:
 		return newDedupSeries(s.lset, repl, s.f)
 	}
 }
 
+func iterables(series []storage.Series) []chunkenc.Iterable {
+	res := make([]chunkenc.Iterable, 0, len(series))
+	for _, s := range series {
+		res = append(res, s)
+	}
+	return res
+}
+
 func (s *dedupSeriesSet) Err() error {
 	return s.set.Err()
 }
@@ -0,0 +1,305 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package dedup
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// quorumSeriesIterator merges replicas by picking, for each scrape, the median of the samples of all the replicas.
+// Samples of the replicas are grouped if they are closer to each other than the median of the recent intervals between
+// the samples of each replica, so that a glitch on a single replica is outvoted by the others. The lower median is used for an even number of
+// replicas, so that the emitted samples are always samples of one of the replicas. Native histograms are ordered by
+// their count.
+type quorumSeriesIterator struct {
+	iters    []chunkenc.Iterator
+	vals     []chunkenc.ValueType
+	consumed []bool
+	prevT    []int64
+
+	// interval is the median of the recent times between two samples of a replica, used as width of the groups of
+	// samples. A single short interval, e.g. a scrape retried early, doesn't shrink it.
+	interval int64
+	// gaps are the last times between two samples of a replica, used as a ring buffer.
+	gaps     []int64
+	nextGap  int
+	gapsSort []int64
+	lastT    int64
+
+	curr int
+	typ  chunkenc.ValueType
+}
+
+func newQuorumSeriesIterator(replicas []chunkenc.Iterable) *quorumSeriesIterator {
+	it := &quorumSeriesIterator{
+		iters:    make([]chunkenc.Iterator, 0, len(replicas)),
+		vals:     make([]chunkenc.ValueType, 0, len(replicas)),
+		consumed: make([]bool, len(replicas)),
+		prevT:    make([]int64, 0, len(replicas)),
+		lastT:    math.MinInt64,
+		curr:     -1,
+	}
+	for _, r := range replicas {
+		ri := r.Iterator(nil)
+		it.iters = append(it.iters, ri)
+		it.vals = append(it.vals, ri.Next())
+		it.prevT = append(it.prevT, math.MinInt64)
+	}
+	return it
+}
+
+func (it *quorumSeriesIterator) Next() chunkenc.ValueType {
+	// Advance the replicas which contributed to the last sample, and skip the samples older than it.
+	anchor := -1
+	for i, ri := range it.iters {
+		if it.consumed[i] && it.vals[i] != chunkenc.ValNone {
+			it.vals[i] = ri.Next()
+		}
+		it.consumed[i] = false
+		if it.vals[i] != chunkenc.ValNone && ri.AtT() <= it.lastT {
+			it.vals[i] = ri.Seek(it.lastT + 1)
+		}
+		if it.vals[i] == chunkenc.ValNone {
+			continue
+		}
+		if anchor == -1 || ri.AtT() < it.iters[anchor].AtT() {
+			anchor = i
+		}
+	}
+	if anchor == -1 {
+		it.curr = -1
+		it.typ = chunkenc.ValNone
+		return chunkenc.ValNone
+	}
+
+	// Gather the samples of the same type belonging to the same scrape as the earliest one.
+	// If we don't know the scrape interval yet, we use the same constant as the penalty based algorithm.
+	const initialInterval = 5000
+
+	var (
+		typ      = it.vals[anchor]
+		end      = it.iters[anchor].AtT() + initialInterval
+		contribs []int
+	)
+	if it.interval > 0 {
+		end = it.iters[anchor].AtT() + it.interval
+	}
+	for i, ri := range it.iters {
+		if it.vals[i] == typ && ri.AtT() < end {
+			contribs = append(contribs, i)
+		}
+	}
+	slices.SortStableFunc(contribs, func(a, b int) int {
+		return cmp.Compare(it.sortValue(a), it.sortValue(b))
+	})
+
+	it.curr = contribs[(len(contribs)-1)/2]
+	it.typ = typ
+	updated := false
+	for _, i := range contribs {
+		t := it.iters[i].AtT()
+		if it.prevT[i] != math.MinInt64 {
+			it.observeGap(t - it.prevT[i])
+			updated = true
+		}
+		it.prevT[i] = t
+		it.consumed[i] = true
+	}
+	if updated {
+		it.gapsSort = append(it.gapsSort[:0], it.gaps...)
+		slices.Sort(it.gapsSort)
+		it.interval = it.gapsSort[len(it.gapsSort)/2]
+	}
+	it.lastT = it.iters[it.curr].AtT()
+	return typ
+}
+
+// observeGap records the time between two samples of a replica.
+func (it *quorumSeriesIterator) observeGap(gap int64) {
+	// Number of recent gaps the interval is estimated from.
+	const window = 16
+
+	if len(it.gaps) < window {
+		it.gaps = append(it.gaps, gap)
+		return
+	}
+	it.gaps[it.nextGap] = gap
+	it.nextGap = (it.nextGap + 1) % window
+}
+
+// sortValue returns the value used to order the current sample of the i-th replica. Stale markers come first.
+func (it *quorumSeriesIterator) sortValue(i int) float64 {
+	switch it.vals[i] {
+	case chunkenc.ValFloat:
+		_, v := it.iters[i].At()
+		return v
+	case chunkenc.ValHistogram:
+		_, h := it.iters[i].AtHistogram(nil)
+		return float64(h.Count)
+	case chunkenc.ValFloatHistogram:
+		_, fh := it.iters[i].AtFloatHistogram(nil)
+		return fh.Count
+	}
+	return 0
+}
+
+func (it *quorumSeriesIterator) Seek(t int64) chunkenc.ValueType {
+	if it.curr != -1 && it.AtT() >= t {
+		return it.typ
+	}
+	for {
+		// Don't use underlying Seek, to not miss the samples to compare with.
+		if it.Next() == chunkenc.ValNone || it.AtT() >= t {
+			return it.typ
+		}
+	}
+}
+
+func (it *quorumSeriesIterator) At() (int64, float64) {
+	return it.iters[it.curr].At()
+}
+
+func (it *quorumSeriesIterator) AtHistogram(h *histogram.Histogram) (int64, *histogram.Histogram) {
+	return it.iters[it.curr].AtHistogram(h)
+}
+
+func (it *quorumSeriesIterator) AtFloatHistogram(fh *histogram.FloatHistogram) (int64, *histogram.FloatHistogram) {
+	return it.iters[it.curr].AtFloatHistogram(fh)
+}
+
+func (it *quorumSeriesIterator) AtT() int64 {
+	return it.iters[it.curr].AtT()
+}
+
+func (it *quorumSeriesIterator) Err() error {
+	for _, ri := range it.iters {
+		if err := ri.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// longestSeriesIterator merges replicas by picking, for each time, the replica with the most samples among the ones
+// spanning it, so that the most complete replica is used wherever it has samples and the other ones fill the rest of
+// the time range. In vertical compaction the replicas are the overlapping chunks, which may also be consecutive chunks
+// of the same replica.
+type longestSeriesIterator struct {
+	iters []chunkenc.Iterator
+	vals  []chunkenc.ValueType
+	// spans are the times of the first and last samples of each replica, and ranked the replicas by decreasing
+	// number of samples. The first replica wins ties.
+	spans  [][2]int64
+	ranked []int
+
+	curr int
+}
+
+func newLongestSeriesIterator(replicas []chunkenc.Iterable) chunkenc.Iterator {
+	it := &longestSeriesIterator{
+		iters: make([]chunkenc.Iterator, 0, len(replicas)),
+		vals:  make([]chunkenc.ValueType, 0, len(replicas)),
+		spans: make([][2]int64, len(replicas)),
+		curr:  -1,
+	}
+	counts := make([]int, len(replicas))
+	for i, r := range replicas {
+		ri := r.Iterator(nil)
+		for ri.Next() != chunkenc.ValNone {
+			if counts[i] == 0 {
+				it.spans[i][0] = ri.AtT()
+			}
+			it.spans[i][1] = ri.AtT()
+			counts[i]++
+		}
+		if ri.Err() != nil {
+			// Use this replica only where all the other ones fail as well, so that the error surfaces.
+			counts[i] = -1
+		}
+		it.ranked = append(it.ranked, i)
+
+		ri = r.Iterator(nil)
+		it.iters = append(it.iters, ri)
+		it.vals = append(it.vals, ri.Next())
+	}
+	slices.SortStableFunc(it.ranked, func(a, b int) int {
+		return cmp.Compare(counts[b], counts[a])
+	})
+	return it
+}
+
+// owner returns the replica with the most samples spanning the time t.
+func (it *longestSeriesIterator) owner(t int64) int {
+	for _, i := range it.ranked {
+		if it.spans[i][0] <= t && t <= it.spans[i][1] {
+			return i
+		}
+	}
+	return -1
+}
+
+func (it *longestSeriesIterator) Next() chunkenc.ValueType {
+	if it.curr != -1 {
+		it.vals[it.curr] = it.iters[it.curr].Next()
+		it.curr = -1
+	}
+	for {
+		// Take the earliest sample of the replicas, skipping it unless its replica owns its time.
+		earliest := -1
+		for i, ri := range it.iters {
+			if it.vals[i] != chunkenc.ValNone && (earliest == -1 || ri.AtT() < it.iters[earliest].AtT()) {
+				earliest = i
+			}
+		}
+		if earliest == -1 {
+			return chunkenc.ValNone
+		}
+		if it.owner(it.iters[earliest].AtT()) == earliest {
+			it.curr = earliest
+			return it.vals[earliest]
+		}
+		it.vals[earliest] = it.iters[earliest].Next()
+	}
+}
+
+func (it *longestSeriesIterator) Seek(t int64) chunkenc.ValueType {
+	if it.curr != -1 && it.AtT() >= t {
+		return it.vals[it.curr]
+	}
+	for {
+		if typ := it.Next(); typ == chunkenc.ValNone || it.AtT() >= t {
+			return typ
+		}
+	}
+}
+
+func (it *longestSeriesIterator) At() (int64, float64) {
+	return it.iters[it.curr].At()
+}
+
+func (it *longestSeriesIterator) AtHistogram(h *histogram.Histogram) (int64, *histogram.Histogram) {
+	return it.iters[it.curr].AtHistogram(h)
+}
+
+func (it *longestSeriesIterator) AtFloatHistogram(fh *histogram.FloatHistogram) (int64, *histogram.FloatHistogram) {
+	return it.iters[it.curr].AtFloatHistogram(fh)
+}
+
+func (it *longestSeriesIterator) AtT() int64 {
+	return it.iters[it.curr].AtT()
+}
+
+func (it *longestSeriesIterator) Err() error {
+	for _, ri := range it.iters {
+		if err := ri.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package dedup
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/efficientgo/core/testutil"
+
+	"github.com/thanos-io/thanos/pkg/testutil/testiters"
+)
+
+type histogramSeries []*testiters.HistogramPair
+
+func (s histogramSeries) Iterator(chunkenc.Iterator) chunkenc.Iterator {
+	return testiters.NewHistogramIterator(s)
+}
+
+// TestDedupSeriesSet_ReplicaSelection checks properties of the quorum and longest algorithms on the fixtures of the
+// penalty based deduplication.
+func TestDedupSeriesSet_ReplicaSelection(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmQuorum, AlgorithmLongest} {
+		for _, tcase := range dedupSeriesSetCases {
+			t.Run(fmt.Sprintf("%s/%s", algorithm, tcase.name), func(t *testing.T) {
+				replicas := map[string][][]sample{}
+				for _, s := range tcase.input {
+					replicas[s.lset.String()] = append(replicas[s.lset.String()], expandSeries(t, s.Iterator(nil)))
+				}
+
+				dedupSet := NewSeriesSet(&mockedSeriesSet{series: tcase.input}, "", algorithm)
+				var ats []storage.Series
+				for dedupSet.Next() {
+					ats = append(ats, dedupSet.At())
+				}
+				testutil.Ok(t, dedupSet.Err())
+				testutil.Equals(t, len(tcase.exp), len(ats))
+
+				for i, s := range ats {
+					testutil.Equals(t, tcase.exp[i].lset, s.Labels(), "labels mismatch for series %v", i)
+					res := expandSeries(t, s.Iterator(nil))
+					repl := replicas[s.Labels().String()]
+
+					// Samples are ordered and all come from one of the replicas.
+					inputs := map[sample]struct{}{}
+					for _, r := range repl {
+						for _, smpl := range r {
+							inputs[smpl] = struct{}{}
+						}
+					}
+					for j, smpl := range res {
+						if j > 0 {
+							testutil.Assert(t, res[j-1].t < smpl.t, "samples out of order for series %v", i)
+						}
+						_, ok := inputs[smpl]
+						testutil.Assert(t, ok, "sample %v not in any replica of series %v", smpl, i)
+					}
+
+					if len(repl) == 1 {
+						testutil.Equals(t, repl[0], res, "values mismatch for single replica series %v", i)
+					}
+					if algorithm == AlgorithmLongest {
+						longest := repl[0]
+						for _, r := range repl[1:] {
+							if len(r) > len(longest) {
+								longest = r
+							}
+						}
+						// The longest replica is used wherever it has samples.
+						var span []sample
+						for _, smpl := range res {
+							if longest[0].t <= smpl.t && smpl.t <= longest[len(longest)-1].t {
+								span = append(span, smpl)
+							}
+						}
+						testutil.Equals(t, longest, span, "values mismatch for series %v", i)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestQuorumSeriesIterator(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		replicas [][]sample
+		exp      []sample
+	}{
+		{
+			name: "glitch on a single replica is outvoted",
+			replicas: [][]sample{
+				{{10000, 1}, {20000, 2}, {30000, 3}, {40000, 4}},
+				{{10000, 1}, {20000, 100}, {30000, 3}, {40000, -100}},
+				{{10000, 1}, {20000, 2}, {30000, 3}, {40000, 4}},
+			},
+			exp: []sample{{10000, 1}, {20000, 2}, {30000, 3}, {40000, 4}},
+		},
+		{
+			name: "gap is filled by other replicas",
+			replicas: [][]sample{
+				{{10000, 1}, {20000, 2}, {40000, 4}},
+				{{10000, 1}, {20000, 2}, {30000, 3}, {40000, 4}},
+			},
+			exp: []sample{{10000, 1}, {20000, 2}, {30000, 3}, {40000, 4}},
+		},
+		{
+			name: "shifted replicas don't increase the sampling frequency",
+			replicas: [][]sample{
+				{{10000, 1}, {20000, 2}, {30000, 3}, {40000, 4}},
+				{{12000, 1}, {22000, 2}, {32000, 3}, {42000, 4}},
+			},
+			exp: []sample{{10000, 1}, {20000, 2}, {30000, 3}, {40000, 4}},
+		},
+		{
+			name: "short gap doesn't increase the sampling frequency",
+			replicas: [][]sample{
+				{{10000, 1}, {20000, 2}, {30000, 3}, {40000, 4}, {41000, 4}, {50000, 5}, {60000, 6}, {70000, 7}},
+				{{12000, 1}, {22000, 2}, {32000, 3}, {42000, 4}, {52000, 5}, {62000, 6}, {72000, 7}},
+			},
+			exp: []sample{{10000, 1}, {20000, 2}, {30000, 3}, {40000, 4}, {41000, 4}, {50000, 5}, {60000, 6}, {70000, 7}},
+		},
+		{
+			name: "lower median is picked",
+			replicas: [][]sample{
+				{{10000, 2}, {20000, 3}},
+				{{10100, 1}, {20100, 4}},
+			},
+			exp: []sample{{10100, 1}, {20000, 3}},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			replicas := make([]chunkenc.Iterable, 0, len(tcase.replicas))
+			for _, r := range tcase.replicas {
+				replicas = append(replicas, series{samples: r})
+			}
+			testutil.Equals(t, tcase.exp, expandSeries(t, newQuorumSeriesIterator(replicas)))
+		})
+	}
+}
+
+func TestQuorumSeriesIterator_NativeHistograms(t *testing.T) {
+	hs := tsdbutil.GenerateTestHistograms(3)
+
+	it := newQuorumSeriesIterator([]chunkenc.Iterable{
+		histogramSeries{{T: 10000, H: hs[0]}, {T: 20000, H: hs[1]}},
+		histogramSeries{{T: 10000, H: hs[0]}, {T: 20000, H: hs[2]}},
+		histogramSeries{{T: 10000, H: hs[0]}, {T: 20000, H: hs[1]}},
+	})
+	require.EqualValues(t, []any{&testiters.HistogramPair{T: 10000, H: hs[0]}, &testiters.HistogramPair{T: 20000, H: hs[1]}}, expandHistogramSeries(t, it))
+}
+
+func TestLongestSeriesIterator(t *testing.T) {
+	a := []sample{{10000, 1}, {20000, 2}, {40000, 4}}
+	b := []sample{{10000, 1}, {20000, 2}, {30000, 3}, {40000, 4}}
+
+	testutil.Equals(t, b, expandSeries(t, newLongestSeriesIterator([]chunkenc.Iterable{series{samples: a}, series{samples: b}})))
+	testutil.Equals(t, a, expandSeries(t, newLongestSeriesIterator([]chunkenc.Iterable{series{samples: a}, series{samples: a[1:]}})))
+
+	// The other replicas fill the time range the longest one does not span.
+	c := []sample{{0, 0}, {10000, 1}, {20000, 2}}
+	d := []sample{{15000, 1.5}, {25000, 2.5}, {35000, 3.5}, {45000, 4.5}, {55000, 5.5}}
+	testutil.Equals(t,
+		[]sample{{0, 0}, {10000, 1}, {15000, 1.5}, {25000, 2.5}, {35000, 3.5}, {45000, 4.5}, {55000, 5.5}},
+		expandSeries(t, newLongestSeriesIterator([]chunkenc.Iterable{series{samples: c}, series{samples: d}})),
+	)
+	it := newLongestSeriesIterator([]chunkenc.Iterable{series{samples: c}, series{samples: d}})
+	testutil.Equals(t, chunkenc.ValFloat, it.Seek(12000))
+	testutil.Equals(t, int64(15000), it.AtT())
+}
+
+func TestDedupChunkSeriesMergerWithAlgorithm(t *testing.T) {
+	lset := labels.FromStrings("bar", "baz")
+	for _, tcase := range []struct {
+		name      string
+		algorithm string
+		input     []storage.ChunkSeries
+		expected  storage.ChunkSeries
+	}{
+		{
+			name:      "quorum",
+			algorithm: AlgorithmQuorum,
+			input: []storage.ChunkSeries{
+				storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{10000, 1}, sample{20000, 2}, sample{30000, 3}}),
+				storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{10000, 1}, sample{20000, -5}, sample{30000, 3}}),
+				storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{10000, 1}, sample{20000, 2}, sample{30000, 3}}),
+			},
+			expected: storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{10000, 1}, sample{20000, 2}, sample{30000, 3}}),
+		},
+		{
+			name:      "longest nested",
+			algorithm: AlgorithmLongest,
+			input: []storage.ChunkSeries{
+				storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{10000, 1}, sample{30000, 3}}),
+				storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{10000, 1}, sample{20000, 2}, sample{30000, 3}}),
+			},
+			expected: storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{10000, 1}, sample{20000, 2}, sample{30000, 3}}),
+		},
+		{
+			name:      "longest partial overlap",
+			algorithm: AlgorithmLongest,
+			input: []storage.ChunkSeries{
+				storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{0, 0}, sample{10, 1}, sample{20, 2}}),
+				storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{15, 1.5}, sample{25, 2.5}, sample{35, 3.5}, sample{45, 4.5}}),
+			},
+			expected: storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{0, 0}, sample{10, 1}, sample{15, 1.5}, sample{25, 2.5}, sample{35, 3.5}, sample{45, 4.5}}),
+		},
+		{
+			name:      "longest consecutive chunks",
+			algorithm: AlgorithmLongest,
+			input: []storage.ChunkSeries{
+				storage.NewListChunkSeriesFromSamples(lset,
+					[]chunks.Sample{sample{0, 0}, sample{10, 1}, sample{20, 2}},
+					[]chunks.Sample{sample{30, 3}, sample{40, 4}, sample{50, 5}},
+				),
+				storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{5, 0.5}, sample{15, 1.5}, sample{25, 2.5}, sample{35, 3.5}}),
+			},
+			expected: storage.NewListChunkSeriesFromSamples(lset, []chunks.Sample{sample{0, 0}, sample{5, 0.5}, sample{15, 1.5}, sample{25, 2.5}, sample{35, 3.5}, sample{40, 4}, sample{50, 5}}),
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			merged := NewChunkSeriesMergerWithAlgorithm(tcase.algorithm)(tcase.input...)
+			testutil.Equals(t, lset, merged.Labels())
+			actChks, err := storage.ExpandChunks(merged.Iterator(nil))
+			testutil.Ok(t, err)
+
+			expChks, err := storage.ExpandChunks(tcase.expected.Iterator(nil))
+			testutil.Ok(t, err)
+			testutil.Equals(t, expChks, actChks)
+		})
+	}
+}
@@ -67,6 +67,7 @@ const (
 	Gets          OperationName = "gets"
 	Sets          OperationName = "sets"
 	WriteRequests OperationName = "write_requests"
+	ReadRequests  OperationName = "read_requests"
 )
 
 type GateFactory interface {
@@ -124,6 +124,12 @@ type StoreInfo struct {
 	SupportsWithoutReplicaLabels bool `protobuf:"varint,5,opt,name=supports_without_replica_labels,json=supportsWithoutReplicaLabels,proto3" json:"supports_without_replica_labels,omitempty"`
 	// TSDBInfos holds metadata for all TSDBs exposed by the store.
 	TsdbInfos []TSDBInfo `protobuf:"bytes,6,rep,name=tsdb_infos,json=tsdbInfos,proto3" json:"tsdb_infos"`
+	// supports_series_estimate means this store supports the estimate field of StoreAPI.Series.
+	SupportsSeriesEstimate bool `protobuf:"varint,7,opt,name=supports_series_estimate,json=supportsSeriesEstimate,proto3" json:"supports_series_estimate,omitempty"`
+	// shard identifies the subset of the data of its label sets exposed by the store, when several stores shard the
+	// same data, e.g. Store Gateways with hashmod relabel configs. Stores with the same label sets and time ranges only
+	// expose the same data if their shards are equal.
+	Shard string `protobuf:"bytes,8,opt,name=shard,proto3" json:"shard,omitempty"`
 }
 
 func (m *StoreInfo) Reset()         { *m = StoreInfo{} }
@@ -400,44 +406,46 @@ func init() {
 func init() { proto.RegisterFile("info/infopb/rpc.proto", fileDescriptor_a1214ec45d2bf952) }
 
 var fileDescriptor_a1214ec45d2bf952 = []byte{
-	// 589 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x94, 0xdf, 0x8a, 0xda, 0x40,
-	0x14, 0xc6, 0x8d, 0x7f, 0xe3, 0x71, 0xdd, 0xee, 0x0e, 0xbb, 0x25, 0x4a, 0x89, 0x12, 0xf6, 0x42,
-	0x68, 0x31, 0x60, 0xa1, 0x94, 0xf6, 0xaa, 0x6e, 0x85, 0x6e, 0xe9, 0x42, 0x1b, 0x85, 0xc2, 0xde,
-	0x84, 0xa8, 0xb3, 0x1a, 0x48, 0x32, 0x63, 0x66, 0xa4, 0xfa, 0x16, 0x7d, 0x95, 0xbe, 0x85, 0x97,
-	0x7b, 0xd9, 0xab, 0xd2, 0xea, 0x43, 0xf4, 0xb6, 0xcc, 0x4c, 0x62, 0x0d, 0xdd, 0xbd, 0xe9, 0x8d,
-	0x66, 0xe6, 0xfb, 0x9d, 0xc9, 0x39, 0xdf, 0x39, 0x13, 0x38, 0xf7, 0xa3, 0x5b, 0x62, 0x8b, 0x1f,
-	0x3a, 0xb6, 0x63, 0x3a, 0xe9, 0xd2, 0x98, 0x70, 0x82, 0x6a, 0x7c, 0xee, 0x45, 0x84, 0x75, 0x85,
-	0xd0, 0x6c, 0x30, 0x4e, 0x62, 0x6c, 0x07, 0xde, 0x18, 0x07, 0x74, 0x6c, 0xf3, 0x35, 0xc5, 0x4c,
-	0x71, 0xcd, 0xb3, 0x19, 0x99, 0x11, 0xf9, 0x68, 0x8b, 0x27, 0xb5, 0x6b, 0xd5, 0xa1, 0x76, 0x15,
-	0xdd, 0x12, 0x07, 0x2f, 0x96, 0x98, 0x71, 0xeb, 0x5b, 0x01, 0x8e, 0xd4, 0x9a, 0x51, 0x12, 0x31,
-	0x8c, 0x5e, 0x00, 0xc8, 0xc3, 0x5c, 0x86, 0x39, 0x33, 0xb4, 0x76, 0xa1, 0x53, 0xeb, 0x9d, 0x76,
-	0x93, 0x57, 0xde, 0x7c, 0x10, 0xd2, 0x10, 0xf3, 0x7e, 0x71, 0xf3, 0xa3, 0x95, 0x73, 0xaa, 0x41,
-	0xb2, 0x66, 0xe8, 0x02, 0xea, 0x97, 0x24, 0xa4, 0x24, 0xc2, 0x11, 0x1f, 0xad, 0x29, 0x36, 0xf2,
-	0x6d, 0xad, 0x53, 0x75, 0xb2, 0x9b, 0xe8, 0x19, 0x94, 0x64, 0xc2, 0x46, 0xa1, 0xad, 0x75, 0x6a,
-	0xbd, 0xc7, 0xdd, 0x83, 0x5a, 0xba, 0x43, 0xa1, 0xc8, 0x64, 0x14, 0x24, 0xe8, 0x78, 0x19, 0x60,
-	0x66, 0x14, 0xef, 0xa1, 0x1d, 0xa1, 0x28, 0x5a, 0x42, 0xe8, 0x1d, 0x3c, 0x0a, 0x31, 0x8f, 0xfd,
-	0x89, 0x1b, 0x62, 0xee, 0x4d, 0x3d, 0xee, 0x19, 0x25, 0x19, 0xd7, 0xca, 0xc4, 0x5d, 0x4b, 0xe6,
-	0x3a, 0x41, 0xe4, 0x01, 0xc7, 0x61, 0x66, 0x0f, 0xf5, 0xa0, 0xc2, 0xbd, 0x78, 0x26, 0x0c, 0x28,
-	0xcb, 0x13, 0x8c, 0xcc, 0x09, 0x23, 0xa5, 0xc9, 0xd0, 0x14, 0x44, 0x2f, 0xa1, 0x8a, 0x57, 0x38,
-	0xa4, 0x81, 0x17, 0x33, 0xa3, 0x22, 0xa3, 0x9a, 0x99, 0xa8, 0x41, 0xaa, 0xca, 0xb8, 0xbf, 0x30,
-	0xb2, 0xa1, 0xb4, 0x58, 0xe2, 0x78, 0x6d, 0xe8, 0x32, 0xaa, 0x91, 0x89, 0xfa, 0x24, 0x94, 0x37,
-	0x1f, 0xaf, 0x54, 0xa1, 0x92, 0xb3, 0x7e, 0x6b, 0x50, 0xdd, 0x7b, 0x85, 0x1a, 0xa0, 0x87, 0x7e,
-	0xe4, 0x72, 0x3f, 0xc4, 0x86, 0xd6, 0xd6, 0x3a, 0x05, 0xa7, 0x12, 0xfa, 0xd1, 0xc8, 0x0f, 0xb1,
-	0x94, 0xbc, 0x95, 0x92, 0xf2, 0x89, 0xe4, 0xad, 0xa4, 0xf4, 0x14, 0x4e, 0xd9, 0x92, 0x52, 0x12,
-	0x73, 0xe6, 0xb2, 0xb9, 0x17, 0x4f, 0xfd, 0x68, 0x26, 0x9b, 0xa2, 0x3b, 0x27, 0xa9, 0x30, 0x4c,
-	0xf6, 0xd1, 0x00, 0x5a, 0x7b, 0xf8, 0x8b, 0xcf, 0xe7, 0x64, 0xc9, 0xdd, 0x18, 0xd3, 0xc0, 0x9f,
-	0x78, 0xae, 0x9c, 0x00, 0x26, 0x9d, 0xd6, 0x9d, 0x27, 0x29, 0xf6, 0x59, 0x51, 0x8e, 0x82, 0xe4,
-	0xd4, 0x30, 0xf4, 0x0a, 0x80, 0xb3, 0xe9, 0xd8, 0x15, 0x85, 0x09, 0x67, 0xc5, 0x68, 0x9d, 0x67,
-	0x9d, 0x1d, 0xbe, 0xed, 0x8b, 0xa2, 0xd2, 0xf1, 0x12, 0xb8, 0x58, 0xb3, 0xf7, 0x45, 0xbd, 0x78,
-	0x52, 0xb2, 0x6a, 0x50, 0xdd, 0xb7, 0xdd, 0x3a, 0x03, 0xf4, 0x6f, 0x2f, 0xc5, 0x7c, 0x1f, 0xf4,
-	0xc7, 0x1a, 0x40, 0x3d, 0x63, 0xfc, 0xff, 0xd9, 0x65, 0x1d, 0xc3, 0xd1, 0x61, 0x27, 0xac, 0x05,
-	0xe8, 0x69, 0xae, 0xc8, 0x86, 0x72, 0x62, 0x82, 0x26, 0x1b, 0xf8, 0xe0, 0x6d, 0x49, 0xb0, 0x4c,
-	0x0a, 0xf9, 0x87, 0x53, 0x28, 0x64, 0x52, 0xe8, 0x5d, 0x42, 0x51, 0xbe, 0xee, 0x75, 0xf2, 0x9f,
-	0x9d, 0xc9, 0x83, 0x3b, 0xdd, 0x6c, 0xdc, 0xa3, 0xa8, 0xdb, 0xdd, 0xbf, 0xd8, 0xfc, 0x32, 0x73,
-	0x9b, 0xad, 0xa9, 0xdd, 0x6d, 0x4d, 0xed, 0xe7, 0xd6, 0xd4, 0xbe, 0xee, 0xcc, 0xdc, 0xdd, 0xce,
-	0xcc, 0x7d, 0xdf, 0x99, 0xb9, 0x9b, 0xb2, 0xfa, 0xd6, 0x8c, 0xcb, 0xf2, 0x53, 0xf1, 0xfc, 0x4f,
-	0x00, 0x00, 0x00, 0xff, 0xff, 0xf1, 0x5f, 0x0a, 0x2f, 0x81, 0x04, 0x00, 0x00,
+	// 620 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x94, 0xcf, 0x6a, 0xdb, 0x40,
+	0x10, 0xc6, 0x2d, 0xff, 0x8b, 0x3c, 0x4e, 0xd2, 0x64, 0x49, 0x82, 0x6c, 0x8a, 0x62, 0x44, 0x0e,
+	0x86, 0x16, 0x0b, 0x5c, 0x28, 0xa5, 0x3d, 0x35, 0xa9, 0xa1, 0x29, 0x0d, 0xb4, 0x72, 0xa0, 0x90,
+	0x8b, 0x58, 0x27, 0x1b, 0x47, 0x60, 0x69, 0x37, 0xbb, 0x6b, 0x9a, 0xbc, 0x45, 0x5f, 0xa5, 0x4f,
+	0xd1, 0x1c, 0x73, 0xec, 0xa9, 0xb4, 0xf1, 0x8b, 0x94, 0x9d, 0x95, 0x1d, 0x8b, 0xa6, 0x3d, 0xf4,
+	0x62, 0x6b, 0xf7, 0xfb, 0xcd, 0x6a, 0xe6, 0xdb, 0xd1, 0xc0, 0x76, 0x92, 0x9d, 0xf3, 0xd0, 0xfc,
+	0x88, 0x51, 0x28, 0xc5, 0x69, 0x4f, 0x48, 0xae, 0x39, 0x69, 0xea, 0x0b, 0x9a, 0x71, 0xd5, 0x33,
+	0x42, 0xbb, 0xa5, 0x34, 0x97, 0x2c, 0x9c, 0xd0, 0x11, 0x9b, 0x88, 0x51, 0xa8, 0xaf, 0x05, 0x53,
+	0x96, 0x6b, 0x6f, 0x8d, 0xf9, 0x98, 0xe3, 0x63, 0x68, 0x9e, 0xec, 0x6e, 0xb0, 0x06, 0xcd, 0xc3,
+	0xec, 0x9c, 0x47, 0xec, 0x72, 0xca, 0x94, 0x0e, 0xbe, 0x56, 0x60, 0xd5, 0xae, 0x95, 0xe0, 0x99,
+	0x62, 0xe4, 0x39, 0x00, 0x1e, 0x16, 0x2b, 0xa6, 0x95, 0xe7, 0x74, 0x2a, 0xdd, 0x66, 0x7f, 0xb3,
+	0x97, 0xbf, 0xf2, 0xe4, 0xbd, 0x91, 0x86, 0x4c, 0xef, 0x57, 0x6f, 0x7e, 0xec, 0x96, 0xa2, 0xc6,
+	0x24, 0x5f, 0x2b, 0xb2, 0x07, 0x6b, 0x07, 0x3c, 0x15, 0x3c, 0x63, 0x99, 0x3e, 0xbe, 0x16, 0xcc,
+	0x2b, 0x77, 0x9c, 0x6e, 0x23, 0x2a, 0x6e, 0x92, 0xa7, 0x50, 0xc3, 0x84, 0xbd, 0x4a, 0xc7, 0xe9,
+	0x36, 0xfb, 0x3b, 0xbd, 0xa5, 0x5a, 0x7a, 0x43, 0xa3, 0x60, 0x32, 0x16, 0x32, 0xb4, 0x9c, 0x4e,
+	0x98, 0xf2, 0xaa, 0x0f, 0xd0, 0x91, 0x51, 0x2c, 0x8d, 0x10, 0x79, 0x0b, 0x8f, 0x52, 0xa6, 0x65,
+	0x72, 0x1a, 0xa7, 0x4c, 0xd3, 0x33, 0xaa, 0xa9, 0x57, 0xc3, 0xb8, 0xdd, 0x42, 0xdc, 0x11, 0x32,
+	0x47, 0x39, 0x82, 0x07, 0xac, 0xa7, 0x85, 0x3d, 0xd2, 0x87, 0x15, 0x4d, 0xe5, 0xd8, 0x18, 0x50,
+	0xc7, 0x13, 0xbc, 0xc2, 0x09, 0xc7, 0x56, 0xc3, 0xd0, 0x39, 0x48, 0x5e, 0x40, 0x83, 0x5d, 0xb1,
+	0x54, 0x4c, 0xa8, 0x54, 0xde, 0x0a, 0x46, 0xb5, 0x0b, 0x51, 0x83, 0xb9, 0x8a, 0x71, 0xf7, 0x30,
+	0x09, 0xa1, 0x76, 0x39, 0x65, 0xf2, 0xda, 0x73, 0x31, 0xaa, 0x55, 0x88, 0xfa, 0x68, 0x94, 0xd7,
+	0x1f, 0x0e, 0x6d, 0xa1, 0xc8, 0x05, 0xdf, 0xca, 0xd0, 0x58, 0x78, 0x45, 0x5a, 0xe0, 0xa6, 0x49,
+	0x16, 0xeb, 0x24, 0x65, 0x9e, 0xd3, 0x71, 0xba, 0x95, 0x68, 0x25, 0x4d, 0xb2, 0xe3, 0x24, 0x65,
+	0x28, 0xd1, 0x2b, 0x2b, 0x95, 0x73, 0x89, 0x5e, 0xa1, 0xf4, 0x04, 0x36, 0xd5, 0x54, 0x08, 0x2e,
+	0xb5, 0x8a, 0xd5, 0x05, 0x95, 0x67, 0x49, 0x36, 0xc6, 0x4b, 0x71, 0xa3, 0x8d, 0xb9, 0x30, 0xcc,
+	0xf7, 0xc9, 0x00, 0x76, 0x17, 0xf0, 0xe7, 0x44, 0x5f, 0xf0, 0xa9, 0x8e, 0x25, 0x13, 0x93, 0xe4,
+	0x94, 0xc6, 0xd8, 0x01, 0x0a, 0x9d, 0x76, 0xa3, 0xc7, 0x73, 0xec, 0x93, 0xa5, 0x22, 0x0b, 0x61,
+	0xd7, 0x28, 0xf2, 0x12, 0x40, 0xab, 0xb3, 0x51, 0x6c, 0x0a, 0x33, 0xce, 0x9a, 0xd6, 0xda, 0x2e,
+	0x3a, 0x3b, 0x7c, 0xb3, 0x6f, 0x8a, 0x9a, 0xb7, 0x97, 0xc1, 0xcd, 0xda, 0xd8, 0xeb, 0xdd, 0xe7,
+	0xcb, 0x64, 0xc2, 0x54, 0xcc, 0x94, 0x4e, 0x52, 0xaa, 0x19, 0xba, 0xed, 0x46, 0x3b, 0x8b, 0xb4,
+	0x51, 0x1e, 0xe4, 0x2a, 0xd9, 0x82, 0x1a, 0x16, 0x88, 0xf6, 0x36, 0x22, 0xbb, 0x78, 0x57, 0x75,
+	0xab, 0x1b, 0xb5, 0xa0, 0x09, 0x8d, 0x45, 0x1b, 0x05, 0x5b, 0x40, 0xfe, 0xec, 0x0d, 0xf3, 0xbd,
+	0x2c, 0xdd, 0x77, 0x30, 0x80, 0xb5, 0xc2, 0x45, 0xfe, 0x9f, 0xfd, 0xc1, 0x3a, 0xac, 0x2e, 0xdf,
+	0x6c, 0x70, 0x09, 0xee, 0xbc, 0x76, 0x12, 0x42, 0x3d, 0x37, 0xd5, 0xe9, 0x38, 0xff, 0xfa, 0xfa,
+	0x72, 0xac, 0x90, 0x42, 0xf9, 0xef, 0x29, 0x54, 0x0a, 0x29, 0xf4, 0x0f, 0xa0, 0x8a, 0xaf, 0x7b,
+	0x95, 0xff, 0x17, 0x7b, 0x7c, 0x69, 0x46, 0xb4, 0x5b, 0x0f, 0x28, 0x76, 0x5a, 0xec, 0xef, 0xdd,
+	0xfc, 0xf2, 0x4b, 0x37, 0x77, 0xbe, 0x73, 0x7b, 0xe7, 0x3b, 0x3f, 0xef, 0x7c, 0xe7, 0xcb, 0xcc,
+	0x2f, 0xdd, 0xce, 0xfc, 0xd2, 0xf7, 0x99, 0x5f, 0x3a, 0xa9, 0xdb, 0xd9, 0x35, 0xaa, 0xe3, 0xe8,
+	0x79, 0xf6, 0x7b, 0x00, 0x2f, 0x2c, 0xc5, 0x7f, 0xd1, 0x04, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -681,6 +689,23 @@ func (m *StoreInfo) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Shard) > 0 {
+		i -= len(m.Shard)
+		copy(dAtA[i:], m.Shard)
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Shard)))
+		i--
+		dAtA[i] = 0x42
+	}
+	if m.SupportsSeriesEstimate {
+		i--
+		if m.SupportsSeriesEstimate {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x38
+	}
 	if len(m.TsdbInfos) > 0 {
 		for iNdEx := len(m.TsdbInfos) - 1; iNdEx >= 0; iNdEx-- {
 			{
@@ -983,6 +1008,13 @@ func (m *StoreInfo) Size() (n int) {
 			n += 1 + l + sovRpc(uint64(l))
 		}
 	}
+	if m.SupportsSeriesEstimate {
+		n += 2
+	}
+	l = len(m.Shard)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
 	return n
 }
 
@@ -1583,6 +1615,58 @@ func (m *StoreInfo) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SupportsSeriesEstimate", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.SupportsSeriesEstimate = bool(v != 0)
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Shard", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Shard = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
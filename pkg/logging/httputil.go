@@ -30,3 +30,10 @@ func (r *ResponseWriterWithStatus) WriteHeader(code int) {
 		r.isHeaderWritten = true
 	}
 }
+
+// Flush implements http.Flusher if the wrapped http.ResponseWriter does, so streamed responses can be flushed.
+func (r *ResponseWriterWithStatus) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
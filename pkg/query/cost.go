@@ -0,0 +1,269 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package query
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/extpromql"
+	"github.com/thanos-io/thanos/pkg/gate"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// defaultLookbackDelta is the lookback delta of the PromQL engines, used when none is configured.
+const defaultLookbackDelta = 5 * time.Minute
+
+// CostLimitsConfig is the configuration of the admission control of queries based on their estimated cost.
+type CostLimitsConfig struct {
+	// MaxConcurrentExpensiveQueries is the maximum number of expensive queries evaluated concurrently.
+	// Other expensive queries wait for their turn. 0 means no limit.
+	MaxConcurrentExpensiveQueries int `yaml:"max_concurrent_expensive_queries"`
+	// DefaultLimits are the limits for tenants without specified limits.
+	DefaultLimits CostLimits `yaml:"default"`
+	// TenantsLimits are the limits per tenant, overriding the default ones.
+	TenantsLimits map[string]*CostLimits `yaml:"tenants"`
+}
+
+// CostLimits are thresholds on the estimated cost of a query. Unset or 0 means no threshold.
+type CostLimits struct {
+	// MaxEstimatedSeries is the number of series above which queries are rejected.
+	MaxEstimatedSeries *int64 `yaml:"max_estimated_series"`
+	// MaxEstimatedChunks is the number of chunks above which queries are rejected.
+	MaxEstimatedChunks *int64 `yaml:"max_estimated_chunks"`
+	// ExpensiveSeries is the number of series above which queries are considered expensive.
+	ExpensiveSeries *int64 `yaml:"expensive_series"`
+	// ExpensiveChunks is the number of chunks above which queries are considered expensive.
+	ExpensiveChunks *int64 `yaml:"expensive_chunks"`
+}
+
+// ParseCostLimitsConfig parses the cost limits configuration.
+func ParseCostLimitsConfig(content []byte) (*CostLimitsConfig, error) {
+	var conf CostLimitsConfig
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing cost limits config YAML file")
+	}
+	return &conf, nil
+}
+
+// limitsFor returns the limits of the given tenant, falling back to the default limits for unset ones.
+func (c *CostLimitsConfig) limitsFor(tenant string) CostLimits {
+	limits := c.DefaultLimits
+	tl, ok := c.TenantsLimits[tenant]
+	if !ok || tl == nil {
+		return limits
+	}
+	if tl.MaxEstimatedSeries != nil {
+		limits.MaxEstimatedSeries = tl.MaxEstimatedSeries
+	}
+	if tl.MaxEstimatedChunks != nil {
+		limits.MaxEstimatedChunks = tl.MaxEstimatedChunks
+	}
+	if tl.ExpensiveSeries != nil {
+		limits.ExpensiveSeries = tl.ExpensiveSeries
+	}
+	if tl.ExpensiveChunks != nil {
+		limits.ExpensiveChunks = tl.ExpensiveChunks
+	}
+	return limits
+}
+
+func exceeds(v int64, limit *int64) bool {
+	return limit != nil && *limit > 0 && v > *limit
+}
+
+// Cost is the cost of a query, in number of series and chunks fetched from stores.
+type Cost struct {
+	Series int64 `json:"series"`
+	Chunks int64 `json:"chunks"`
+}
+
+// CostEstimateRequest holds the parameters of a query to estimate the cost of.
+type CostEstimateRequest struct {
+	Query                 string
+	Start, End            time.Time
+	Interval              time.Duration
+	LookbackDelta         time.Duration
+	MaxResolutionMillis   int64
+	EnablePartialResponse bool
+	ShardInfo             *storepb.ShardInfo
+}
+
+// CostEstimator estimates the cost of queries before running them, by asking stores for estimates of the series
+// and chunks of each selector, and admits them according to per-tenant limits.
+type CostEstimator struct {
+	logger        log.Logger
+	client        storepb.StoreClient
+	conf          CostLimitsConfig
+	expensiveGate gate.Gate
+
+	rejected         *prometheus.CounterVec
+	expensive        prometheus.Counter
+	estimateFailures prometheus.Counter
+	estimateDuration prometheus.Histogram
+}
+
+// NewCostEstimator returns a CostEstimator requesting estimates from the given client.
+func NewCostEstimator(logger log.Logger, reg prometheus.Registerer, client storepb.StoreClient, conf CostLimitsConfig) *CostEstimator {
+	return &CostEstimator{
+		logger: logger,
+		client: client,
+		conf:   conf,
+		expensiveGate: gate.New(
+			extprom.WrapRegistererWithPrefix("thanos_query_expensive_", reg),
+			conf.MaxConcurrentExpensiveQueries,
+			gate.Queries,
+		),
+		rejected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_cost_rejected_queries_total",
+			Help: "Total number of queries rejected because of their estimated cost, by exceeded limit.",
+		}, []string{"limit"}),
+		expensive: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_cost_expensive_queries_total",
+			Help: "Total number of queries queued as expensive because of their estimated cost.",
+		}),
+		estimateFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_cost_estimate_failures_total",
+			Help: "Total number of queries admitted without estimate, because estimating their cost failed.",
+		}),
+		estimateDuration: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "thanos_query_cost_estimate_duration_seconds",
+			Help:    "Duration of estimating the cost of queries.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 5},
+		}),
+	}
+}
+
+// Estimate returns the estimated cost of the query, as the sum of the store estimates of all its selectors over
+// the time range the query will select.
+func (e *CostEstimator) Estimate(ctx context.Context, req CostEstimateRequest) (Cost, error) {
+	defer func(begin time.Time) { e.estimateDuration.Observe(time.Since(begin).Seconds()) }(time.Now())
+
+	expr, err := extpromql.ParseExpr(req.Query)
+	if err != nil {
+		return Cost{}, errors.Wrap(err, "parse query")
+	}
+	expr = promql.PreprocessExpr(expr, req.Start, req.End)
+	if req.LookbackDelta <= 0 {
+		req.LookbackDelta = defaultLookbackDelta
+	}
+	mint, maxt := promql.FindMinMaxTime(&parser.EvalStmt{
+		Expr:          expr,
+		Start:         req.Start,
+		End:           req.End,
+		Interval:      req.Interval,
+		LookbackDelta: req.LookbackDelta,
+	})
+
+	strategy := storepb.PartialResponseStrategy_ABORT
+	if req.EnablePartialResponse {
+		strategy = storepb.PartialResponseStrategy_WARN
+	}
+
+	var (
+		mtx     sync.Mutex
+		cost    Cost
+		g, gctx = errgroup.WithContext(ctx)
+	)
+	for _, ms := range parser.ExtractSelectors(expr) {
+		matchers, err := storepb.PromMatchersToMatchers(ms...)
+		if err != nil {
+			return Cost{}, errors.Wrap(err, "convert matchers")
+		}
+		g.Go(func() error {
+			est, err := e.estimateSelector(gctx, &storepb.SeriesRequest{
+				MinTime:                 mint,
+				MaxTime:                 maxt,
+				Matchers:                matchers,
+				MaxResolutionWindow:     req.MaxResolutionMillis,
+				PartialResponseStrategy: strategy,
+				ShardInfo:               req.ShardInfo,
+				Estimate:                true,
+			})
+			if err != nil {
+				return err
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			cost.Series += est.Series
+			cost.Chunks += est.Chunks
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return Cost{}, err
+	}
+	return cost, nil
+}
+
+func (e *CostEstimator) estimateSelector(ctx context.Context, r *storepb.SeriesRequest) (storepb.SeriesEstimate, error) {
+	var estimate storepb.SeriesEstimate
+
+	cl, err := e.client.Series(ctx, r)
+	if err != nil {
+		return estimate, errors.Wrap(err, "request estimate")
+	}
+	for {
+		resp, err := cl.Recv()
+		if err == io.EOF {
+			return estimate, nil
+		}
+		if err != nil {
+			return estimate, errors.Wrap(err, "receive estimate")
+		}
+		if est := resp.GetEstimate(); est != nil {
+			estimate.Series += est.Series
+			estimate.Chunks += est.Chunks
+		}
+	}
+}
+
+// Admit estimates the cost of the query and checks it against the limits of the tenant. It returns an error if
+// the query has to be rejected, and waits for the turn of expensive queries. Queries are admitted without
+// estimate if estimating fails. Unless an error is returned, the returned function has to be called once
+// the query is done.
+func (e *CostEstimator) Admit(ctx context.Context, tenant string, req CostEstimateRequest) (*Cost, func(), error) {
+	cost, err := e.Estimate(ctx, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		level.Warn(e.logger).Log("msg", "failed to estimate query cost, admitting query", "query", req.Query, "err", err)
+		e.estimateFailures.Inc()
+		return nil, func() {}, nil
+	}
+
+	limits := e.conf.limitsFor(tenant)
+	if exceeds(cost.Series, limits.MaxEstimatedSeries) {
+		e.rejected.WithLabelValues("series").Inc()
+		return &cost, nil, errors.Errorf("estimated number of series %d exceeds the limit of %d", cost.Series, *limits.MaxEstimatedSeries)
+	}
+	if exceeds(cost.Chunks, limits.MaxEstimatedChunks) {
+		e.rejected.WithLabelValues("chunks").Inc()
+		return &cost, nil, errors.Errorf("estimated number of chunks %d exceeds the limit of %d", cost.Chunks, *limits.MaxEstimatedChunks)
+	}
+
+	if !exceeds(cost.Series, limits.ExpensiveSeries) && !exceeds(cost.Chunks, limits.ExpensiveChunks) {
+		return &cost, func() {}, nil
+	}
+	e.expensive.Inc()
+	if err := e.expensiveGate.Start(ctx); err != nil {
+		return &cost, nil, errors.Wrap(err, "wait for turn of expensive queries")
+	}
+	return &cost, e.expensiveGate.Done, nil
+}
@@ -0,0 +1,141 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package query
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+type estimatingStore struct {
+	storepb.UnimplementedStoreServer
+
+	estimate storepb.SeriesEstimate
+
+	mtx  sync.Mutex
+	reqs []*storepb.SeriesRequest
+}
+
+func (s *estimatingStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.mtx.Lock()
+	s.reqs = append(s.reqs, r)
+	s.mtx.Unlock()
+
+	return srv.Send(storepb.NewEstimateSeriesResponse(s.estimate.Series, s.estimate.Chunks))
+}
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func TestParseCostLimitsConfig(t *testing.T) {
+	conf, err := ParseCostLimitsConfig([]byte(`
+max_concurrent_expensive_queries: 2
+default:
+  max_estimated_series: 1000
+  expensive_chunks: 500
+tenants:
+  team-a:
+    max_estimated_series: 10
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, conf.MaxConcurrentExpensiveQueries)
+	testutil.Equals(t, CostLimits{MaxEstimatedSeries: int64Ptr(1000), ExpensiveChunks: int64Ptr(500)}, conf.limitsFor("team-b"))
+	testutil.Equals(t, CostLimits{MaxEstimatedSeries: int64Ptr(10), ExpensiveChunks: int64Ptr(500)}, conf.limitsFor("team-a"))
+
+	_, err = ParseCostLimitsConfig([]byte(`max_series: 10`))
+	testutil.NotOk(t, err)
+}
+
+func TestCostEstimator_Estimate(t *testing.T) {
+	st := &estimatingStore{estimate: storepb.SeriesEstimate{Series: 10, Chunks: 20}}
+	e := NewCostEstimator(log.NewNopLogger(), nil, storepb.ServerAsClient(st), CostLimitsConfig{})
+
+	end := time.Unix(3600, 0)
+	cost, err := e.Estimate(context.Background(), CostEstimateRequest{
+		Query:                 `sum(rate(a[10m])) / sum(b)`,
+		Start:                 end.Add(-time.Hour),
+		End:                   end,
+		Interval:              time.Minute,
+		EnablePartialResponse: true,
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, Cost{Series: 20, Chunks: 40}, cost)
+
+	testutil.Equals(t, 2, len(st.reqs))
+	for _, r := range st.reqs {
+		testutil.Assert(t, r.Estimate, "estimate was not requested")
+		testutil.Equals(t, storepb.PartialResponseStrategy_WARN, r.PartialResponseStrategy)
+		// Range selectors are left-open.
+		testutil.Equals(t, int64(-10*time.Minute/time.Millisecond)+1, r.MinTime)
+		testutil.Equals(t, end.UnixMilli(), r.MaxTime)
+	}
+
+	_, err = e.Estimate(context.Background(), CostEstimateRequest{Query: `sum(`})
+	testutil.NotOk(t, err)
+}
+
+func TestCostEstimator_Admit(t *testing.T) {
+	st := &estimatingStore{estimate: storepb.SeriesEstimate{Series: 100, Chunks: 1000}}
+	req := CostEstimateRequest{Query: `a`, Start: time.Unix(60, 0), End: time.Unix(60, 0)}
+
+	t.Run("rejected", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		e := NewCostEstimator(log.NewNopLogger(), reg, storepb.ServerAsClient(st), CostLimitsConfig{
+			DefaultLimits: CostLimits{MaxEstimatedChunks: int64Ptr(10000)},
+			TenantsLimits: map[string]*CostLimits{"team-a": {MaxEstimatedChunks: int64Ptr(999)}},
+		})
+
+		cost, done, err := e.Admit(context.Background(), "team-b", req)
+		testutil.Ok(t, err)
+		testutil.Equals(t, &Cost{Series: 100, Chunks: 1000}, cost)
+		done()
+
+		_, _, err = e.Admit(context.Background(), "team-a", req)
+		testutil.NotOk(t, err)
+		testutil.Equals(t, "estimated number of chunks 1000 exceeds the limit of 999", err.Error())
+		testutil.Equals(t, 1.0, promtestutil.ToFloat64(e.rejected.WithLabelValues("chunks")))
+	})
+
+	t.Run("expensive", func(t *testing.T) {
+		e := NewCostEstimator(log.NewNopLogger(), nil, storepb.ServerAsClient(st), CostLimitsConfig{
+			MaxConcurrentExpensiveQueries: 1,
+			DefaultLimits:                 CostLimits{ExpensiveSeries: int64Ptr(50)},
+		})
+
+		_, done, err := e.Admit(context.Background(), "", req)
+		testutil.Ok(t, err)
+
+		// The second expensive query waits for the first one to be done.
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, _, err = e.Admit(ctx, "", req)
+		testutil.NotOk(t, err)
+
+		done()
+		_, done, err = e.Admit(context.Background(), "", req)
+		testutil.Ok(t, err)
+		done()
+		testutil.Equals(t, 3.0, promtestutil.ToFloat64(e.expensive))
+	})
+
+	t.Run("estimate failure", func(t *testing.T) {
+		e := NewCostEstimator(log.NewNopLogger(), nil, storepb.ServerAsClient(st), CostLimitsConfig{
+			DefaultLimits: CostLimits{MaxEstimatedSeries: int64Ptr(1)},
+		})
+
+		cost, done, err := e.Admit(context.Background(), "", CostEstimateRequest{Query: `sum(`})
+		testutil.Ok(t, err)
+		testutil.Assert(t, cost == nil, "expected no estimated cost")
+		done()
+		testutil.Equals(t, 1.0, promtestutil.ToFloat64(e.estimateFailures))
+	})
+}
@@ -0,0 +1,276 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/caio/go-tdigest"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/pkg/store"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+var _ store.HealthTracker = &endpointRef{}
+
+// minLatencySamples is the number of Series calls to observe before estimating the latency of an endpoint.
+const minLatencySamples = 10
+
+// CircuitBreakerConfig configures the circuit breaker skipping StoreAPI endpoints with persistently failing or slow calls.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed or slow Series calls opening the circuit. Zero disables
+	// the circuit breaker.
+	FailureThreshold int
+	// SlowCallThreshold is the time to first response above which Series calls are considered failed. Zero disables
+	// slow call detection.
+	SlowCallThreshold time.Duration
+	// OpenDuration is the time during which calls are rejected once the circuit opens, before a probe call is let through.
+	OpenDuration time.Duration
+}
+
+type circuitState string
+
+const (
+	circuitClosed   circuitState = "closed"
+	circuitOpen     circuitState = "open"
+	circuitHalfOpen circuitState = "half-open"
+)
+
+type endpointHealthMetrics struct {
+	circuitBreakerTrips     prometheus.Counter
+	circuitBreakerRejected  prometheus.Counter
+	circuitBreakerRecovered prometheus.Counter
+}
+
+func newEndpointHealthMetrics(reg prometheus.Registerer) *endpointHealthMetrics {
+	return &endpointHealthMetrics{
+		circuitBreakerTrips: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_store_circuit_breaker_trips_total",
+			Help: "Total number of times the circuit breaker of a StoreAPI endpoint opened.",
+		}),
+		circuitBreakerRejected: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_store_circuit_breaker_rejected_requests_total",
+			Help: "Total number of Series requests rejected because the circuit breaker of the StoreAPI endpoint was open.",
+		}),
+		circuitBreakerRecovered: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_store_circuit_breaker_recoveries_total",
+			Help: "Total number of times the circuit breaker of a StoreAPI endpoint closed after a successful probe call.",
+		}),
+	}
+}
+
+// endpointHealth tracks the latency of the Series calls made to an endpoint and the state of its circuit breaker.
+type endpointHealth struct {
+	cfg     CircuitBreakerConfig
+	metrics *endpointHealthMetrics
+
+	mtx      sync.Mutex
+	latency  *tdigest.TDigest
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probeAt  time.Time
+	trips    int64
+	hedged   int64
+}
+
+func newEndpointHealth(cfg CircuitBreakerConfig, metrics *endpointHealthMetrics) *endpointHealth {
+	td, err := tdigest.New()
+	if err != nil {
+		panic(fmt.Sprintf("BUG: Failed to initialize T-Digest: %v", err))
+	}
+	return &endpointHealth{
+		cfg:     cfg,
+		metrics: metrics,
+		latency: td,
+		state:   circuitClosed,
+	}
+}
+
+func (h *endpointHealth) enabled() bool {
+	return h.cfg.FailureThreshold > 0
+}
+
+// allow returns true if a call can be made to the endpoint. Once the circuit was open for the configured duration,
+// a single probe call is let through at a time.
+func (h *endpointHealth) allow(now time.Time) bool {
+	if !h.enabled() {
+		return true
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	switch h.state {
+	case circuitOpen:
+		if now.Sub(h.openedAt) < h.cfg.OpenDuration {
+			h.metrics.circuitBreakerRejected.Inc()
+			return false
+		}
+		h.state = circuitHalfOpen
+		h.probeAt = now
+		return true
+	case circuitHalfOpen:
+		// Let another probe through if the previous one never completed.
+		if now.Sub(h.probeAt) < h.cfg.OpenDuration {
+			h.metrics.circuitBreakerRejected.Inc()
+			return false
+		}
+		h.probeAt = now
+		return true
+	default:
+		return true
+	}
+}
+
+// observe records the time to first response of a Series call and whether it failed.
+func (h *endpointHealth) observe(now time.Time, latency time.Duration, err error) {
+	// Calls canceled by the caller, e.g. hedged calls, tell nothing about the endpoint.
+	canceled := errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if err == nil {
+		_ = h.latency.Add(latency.Seconds())
+	}
+	if !h.enabled() || canceled {
+		return
+	}
+
+	failed := err != nil || (h.cfg.SlowCallThreshold > 0 && latency > h.cfg.SlowCallThreshold)
+	switch {
+	case !failed && h.state == circuitHalfOpen:
+		h.state = circuitClosed
+		h.failures = 0
+		h.metrics.circuitBreakerRecovered.Inc()
+	case !failed:
+		h.failures = 0
+	case h.state == circuitHalfOpen:
+		h.open(now)
+	case h.state == circuitClosed:
+		h.failures++
+		if h.failures >= h.cfg.FailureThreshold {
+			h.open(now)
+		}
+	}
+}
+
+func (h *endpointHealth) open(now time.Time) {
+	h.state = circuitOpen
+	h.openedAt = now
+	h.failures = 0
+	h.trips++
+	h.metrics.circuitBreakerTrips.Inc()
+}
+
+func (h *endpointHealth) seriesLatency(quantile float64) (time.Duration, bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.latency.Count() < minLatencySamples {
+		return 0, false
+	}
+	return time.Duration(h.latency.Quantile(quantile) * float64(time.Second)), true
+}
+
+func (h *endpointHealth) seriesHedged() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.hedged++
+}
+
+func (h *endpointHealth) circuitOpen(now time.Time) bool {
+	if !h.enabled() {
+		return false
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	return h.state == circuitOpen && now.Sub(h.openedAt) < h.cfg.OpenDuration
+}
+
+// updateStatus sets the health information of the endpoint on its status.
+func (h *endpointHealth) updateStatus(s *EndpointStatus) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.latency.Count() >= minLatencySamples {
+		s.SeriesLatencyP90 = h.latency.Quantile(0.9)
+	}
+	s.HedgedRequests = h.hedged
+	if h.enabled() {
+		s.CircuitBreakerState = string(h.state)
+		s.CircuitBreakerTrips = h.trips
+	}
+}
+
+// Series calls the endpoint if its circuit breaker allows it and records the time to first response of the call.
+func (er *endpointRef) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	if er.health == nil {
+		return er.StoreClient.Series(ctx, req, opts...)
+	}
+
+	start := time.Now()
+	if !er.health.allow(start) {
+		return nil, status.Errorf(codes.Unavailable, "circuit breaker of endpoint %s is open", er.addr)
+	}
+	cl, err := er.StoreClient.Series(ctx, req, opts...)
+	if err != nil {
+		er.health.observe(time.Now(), time.Since(start), err)
+		return nil, err
+	}
+	return &trackedSeriesClient{Store_SeriesClient: cl, health: er.health, start: start}, nil
+}
+
+func (er *endpointRef) SeriesLatency(quantile float64) (time.Duration, bool) {
+	if er.health == nil {
+		return 0, false
+	}
+	return er.health.seriesLatency(quantile)
+}
+
+func (er *endpointRef) SeriesHedged() {
+	if er.health != nil {
+		er.health.seriesHedged()
+	}
+}
+
+func (er *endpointRef) CircuitOpen() bool {
+	return er.health != nil && er.health.circuitOpen(time.Now())
+}
+
+// trackedSeriesClient records the time to first response of a Series call.
+type trackedSeriesClient struct {
+	storepb.Store_SeriesClient
+
+	health   *endpointHealth
+	start    time.Time
+	observed bool
+}
+
+func (c *trackedSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	resp, err := c.Store_SeriesClient.Recv()
+	if !c.observed {
+		c.observed = true
+		if err == io.EOF {
+			c.health.observe(time.Now(), time.Since(c.start), nil)
+		} else {
+			c.health.observe(time.Now(), time.Since(c.start), err)
+		}
+	}
+	return resp, err
+}
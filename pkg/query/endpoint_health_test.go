@@ -0,0 +1,111 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+type failingStoreClient struct {
+	storepb.StoreClient
+	calls int
+}
+
+func (c *failingStoreClient) Series(context.Context, *storepb.SeriesRequest, ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	c.calls++
+	return nil, errors.New("unavailable")
+}
+
+func TestEndpointHealth_CircuitBreaker(t *testing.T) {
+	h := newEndpointHealth(CircuitBreakerConfig{
+		FailureThreshold:  2,
+		SlowCallThreshold: 100 * time.Millisecond,
+		OpenDuration:      time.Second,
+	}, newEndpointHealthMetrics(nil))
+	now := time.Unix(0, 0)
+
+	// Successful calls reset the consecutive failures.
+	h.observe(now, time.Millisecond, errors.New("failed"))
+	h.observe(now, time.Millisecond, nil)
+	h.observe(now, time.Millisecond, errors.New("failed"))
+	testutil.Assert(t, !h.circuitOpen(now))
+
+	// Slow calls count as failed, canceled calls are ignored.
+	h.observe(now, time.Millisecond, context.Canceled)
+	h.observe(now, time.Millisecond, status.Error(codes.Canceled, "canceled"))
+	testutil.Assert(t, !h.circuitOpen(now))
+	h.observe(now, time.Second, nil)
+	testutil.Assert(t, h.circuitOpen(now))
+	testutil.Assert(t, !h.allow(now.Add(500*time.Millisecond)))
+
+	// A single probe is let through once the circuit was open long enough, failing probes open the circuit again.
+	now = now.Add(time.Second)
+	testutil.Assert(t, h.allow(now))
+	testutil.Assert(t, !h.allow(now))
+	h.observe(now, time.Millisecond, errors.New("failed"))
+	testutil.Assert(t, h.circuitOpen(now))
+
+	now = now.Add(time.Second)
+	testutil.Assert(t, h.allow(now))
+	h.observe(now, time.Millisecond, nil)
+	testutil.Assert(t, !h.circuitOpen(now))
+	testutil.Assert(t, h.allow(now))
+
+	var s EndpointStatus
+	h.updateStatus(&s)
+	testutil.Equals(t, "closed", s.CircuitBreakerState)
+	testutil.Equals(t, int64(2), s.CircuitBreakerTrips)
+}
+
+func TestEndpointHealth_SeriesLatency(t *testing.T) {
+	h := newEndpointHealth(CircuitBreakerConfig{}, newEndpointHealthMetrics(nil))
+
+	for i := 1; i < minLatencySamples; i++ {
+		h.observe(time.Now(), 100*time.Millisecond, nil)
+	}
+	_, ok := h.seriesLatency(0.9)
+	testutil.Assert(t, !ok, "latency estimated with too few samples")
+
+	h.observe(time.Now(), 100*time.Millisecond, nil)
+	l, ok := h.seriesLatency(0.9)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, 100*time.Millisecond, l.Round(time.Millisecond))
+
+	// Failed calls never open the circuit when the circuit breaker is disabled.
+	for i := 0; i < 10; i++ {
+		h.observe(time.Now(), time.Millisecond, errors.New("failed"))
+	}
+	testutil.Assert(t, h.allow(time.Now()))
+
+	var s EndpointStatus
+	h.updateStatus(&s)
+	testutil.Equals(t, "", s.CircuitBreakerState)
+}
+
+func TestEndpointRef_SeriesCircuitBreaker(t *testing.T) {
+	cl := &failingStoreClient{}
+	er := &endpointRef{
+		StoreClient: cl,
+		addr:        "store:10901",
+		health:      newEndpointHealth(CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Minute}, newEndpointHealthMetrics(nil)),
+	}
+
+	_, err := er.Series(context.Background(), &storepb.SeriesRequest{})
+	testutil.NotOk(t, err)
+	testutil.Assert(t, er.CircuitOpen())
+
+	_, err = er.Series(context.Background(), &storepb.SeriesRequest{})
+	testutil.Equals(t, codes.Unavailable, status.Code(err))
+	testutil.Equals(t, 1, cl.calls)
+}
@@ -110,6 +110,12 @@ type EndpointStatus struct {
 	ComponentType component.Component `json:"-"`
 	MinTime       int64               `json:"minTime"`
 	MaxTime       int64               `json:"maxTime"`
+
+	// SeriesLatencyP90 is the 90th percentile of the time to first response of Series calls, in seconds.
+	SeriesLatencyP90    float64 `json:"seriesLatencyP90,omitempty"`
+	HedgedRequests      int64   `json:"hedgedRequests"`
+	CircuitBreakerState string  `json:"circuitBreakerState,omitempty"`
+	CircuitBreakerTrips int64   `json:"circuitBreakerTrips"`
 }
 
 // endpointSetNodeCollector is a metric collector reporting the number of available storeAPIs for Querier.
@@ -220,6 +226,9 @@ type EndpointSet struct {
 	endpointsMtx    sync.RWMutex
 	endpoints       map[string]*endpointRef
 	endpointsMetric *endpointSetNodeCollector
+
+	circuitBreaker CircuitBreakerConfig
+	healthMetrics  *endpointHealthMetrics
 }
 
 // nowFunc is a function that returns time.Time.
@@ -255,6 +264,7 @@ func NewEndpointSet(
 		now:                      now,
 		logger:                   log.With(logger, "component", "endpointset"),
 		endpointsMetric:          endpointsMetric,
+		healthMetrics:            newEndpointHealthMetrics(reg),
 		endpointInfoTimeout:      endpointInfoTimeout,
 		unhealthyEndpointTimeout: unhealthyEndpointTimeout,
 		endpointSpecs: func() map[string]*GRPCEndpointSpec {
@@ -268,6 +278,11 @@ func NewEndpointSet(
 	}
 }
 
+// SetCircuitBreakerConfig configures the circuit breaker of the endpoints. It has to be called before the first Update.
+func (e *EndpointSet) SetCircuitBreakerConfig(cfg CircuitBreakerConfig) {
+	e.circuitBreaker = cfg
+}
+
 // Update updates the endpoint set. It fetches current list of endpoint specs from function and updates the fresh metadata
 // from all endpoints. Keeps around statically defined nodes that were defined with the strict mode.
 func (e *EndpointSet) Update(ctx context.Context) {
@@ -442,6 +457,7 @@ func (e *EndpointSet) GetStoreClients() []store.Client {
 				addr:        er.addr,
 				metadata:    er.metadata,
 				status:      er.status,
+				health:      er.health,
 			})
 			er.mtx.RUnlock()
 		}
@@ -539,7 +555,11 @@ func (e *EndpointSet) GetEndpointStatus() []EndpointStatus {
 
 		status := v.status
 		if status != nil {
-			statuses = append(statuses, *status)
+			s := *status
+			if v.health != nil {
+				v.health.updateStatus(&s)
+			}
+			statuses = append(statuses, s)
 		}
 	}
 
@@ -560,6 +580,7 @@ type endpointRef struct {
 	created  time.Time
 	metadata *endpointMetadata
 	status   *EndpointStatus
+	health   *endpointHealth
 
 	logger log.Logger
 }
@@ -578,6 +599,7 @@ func (e *EndpointSet) newEndpointRef(spec *GRPCEndpointSpec) (*endpointRef, erro
 		addr:     spec.Addr(),
 		isStrict: spec.isStrictStatic,
 		cc:       conn,
+		health:   newEndpointHealth(e.circuitBreaker, e.healthMetrics),
 	}, nil
 }
 
@@ -761,6 +783,28 @@ func (er *endpointRef) SupportsWithoutReplicaLabels() bool {
 	return er.metadata.Store.SupportsWithoutReplicaLabels
 }
 
+func (er *endpointRef) SupportsSeriesEstimate() bool {
+	er.mtx.RLock()
+	defer er.mtx.RUnlock()
+
+	if er.metadata == nil || er.metadata.Store == nil {
+		return false
+	}
+
+	return er.metadata.Store.SupportsSeriesEstimate
+}
+
+func (er *endpointRef) Shard() string {
+	er.mtx.RLock()
+	defer er.mtx.RUnlock()
+
+	if er.metadata == nil || er.metadata.Store == nil {
+		return ""
+	}
+
+	return er.metadata.Store.Shard
+}
+
 func (er *endpointRef) String() string {
 	mint, maxt := er.TimeRange()
 	return fmt.Sprintf(
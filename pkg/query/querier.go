@@ -279,11 +279,13 @@ func (q *querier) Select(ctx context.Context, _ bool, hints *storage.SelectHints
 		matchers[i] = m.String()
 	}
 	tenant := ctx.Value(tenancy.TenantKey)
+	fanout := store.FanoutTrackerFromContext(ctx)
 	// The context gets canceled as soon as query evaluation is completed by the engine.
 	// We want to prevent this from happening for the async store API calls we make while preserving tracing context.
 	// TODO(bwplotka): Does the above still is true? It feels weird to leave unfinished calls behind query API.
 	ctx = tracing.CopyTraceContext(context.Background(), ctx)
 	ctx = context.WithValue(ctx, tenancy.TenantKey, tenant)
+	ctx = store.WithFanoutTracker(ctx, fanout)
 	ctx, cancel := context.WithTimeout(ctx, q.selectTimeout)
 	span, ctx := tracing.StartSpan(ctx, "querier_select", opentracing.Tags{
 		"minTime":  hints.Start,
@@ -0,0 +1,97 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/pkg/extpromql"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+// newAccessPolicyRoundTripper returns a http.RoundTripper rewriting the queries and matchers of requests so that
+// they only select series the tenant can read. Rules, alerts and targets are filtered by the queriers.
+func newAccessPolicyRoundTripper(policy *tenancy.AccessPolicy, next http.RoundTripper) http.RoundTripper {
+	return queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var (
+			rewrite func(tenant string, form url.Values) error
+			op      = getOperation(r)
+		)
+		switch {
+		case op == instantQueryOp, op == rangeQueryOp, strings.HasSuffix(r.URL.Path, "/api/v1/query_exemplars"):
+			rewrite = func(tenant string, form url.Values) error {
+				query, err := policy.EnforceQuery(tenant, form.Get("query"))
+				if err != nil {
+					return err
+				}
+				form.Set("query", query)
+				return nil
+			}
+		case op == labelNamesOp, op == labelValuesOp, op == seriesOp:
+			rewrite = func(tenant string, form url.Values) error {
+				matcherSets := make([][]*labels.Matcher, 0, len(form["match[]"]))
+				for _, s := range form["match[]"] {
+					matchers, err := extpromql.ParseMetricSelector(s)
+					if err != nil {
+						return err
+					}
+					matcherSets = append(matcherSets, matchers)
+				}
+				matcherSets, err := policy.EnforceMatcherSets(tenant, matcherSets)
+				if err != nil {
+					return err
+				}
+				form.Del("match[]")
+				for _, matchers := range matcherSets {
+					form.Add("match[]", selectorString(matchers))
+				}
+				return nil
+			}
+		default:
+			return next.RoundTrip(r)
+		}
+
+		if err := r.ParseForm(); err != nil {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, "error parsing request form: %s", err.Error())
+		}
+		form := r.Form
+		if err := rewrite(r.Header.Get(tenancy.DefaultTenantHeader), form); err != nil {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, "error enforcing access policy: %s", err.Error())
+		}
+		return next.RoundTrip(withForm(r, form))
+	})
+}
+
+// withForm returns a copy of the request with the given form, as URL parameters of GET requests or
+// as body of POST requests.
+func withForm(r *http.Request, form url.Values) *http.Request {
+	r = r.Clone(r.Context())
+	r.Form, r.PostForm = nil, nil
+
+	encoded := form.Encode()
+	if r.Method == http.MethodPost {
+		r.URL.RawQuery = ""
+		r.Body = io.NopCloser(strings.NewReader(encoded))
+		r.ContentLength = int64(len(encoded))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	r.URL.RawQuery = encoded
+	return r
+}
+
+func selectorString(matchers []*labels.Matcher) string {
+	strs := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		strs = append(strs, m.String())
+	}
+	return "{" + strings.Join(strs, ",") + "}"
+}
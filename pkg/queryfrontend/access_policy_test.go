@@ -0,0 +1,58 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+type staticAccessPolicy string
+
+func (c staticAccessPolicy) Content() ([]byte, error) { return []byte(c), nil }
+func (c staticAccessPolicy) Path() string             { return "" }
+
+func TestAccessPolicyRoundTripper(t *testing.T) {
+	policy, err := tenancy.NewAccessPolicy(staticAccessPolicy(`
+policies:
+  - tenants: [team-a]
+    selectors: ['{namespace="a"}']
+`), nil, log.NewNopLogger(), 0)
+	testutil.Ok(t, err)
+
+	var captured url.Values
+	tripper := newAccessPolicyRoundTripper(policy, queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		testutil.Ok(t, r.ParseForm())
+		captured = r.Form
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, `/api/v1/query?query=sum(up)&time=1`, nil)
+	r.Header.Set(tenancy.DefaultTenantHeader, "team-a")
+	_, err = tripper.RoundTrip(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, `sum(up{namespace="a"})`, captured.Get("query"))
+	testutil.Equals(t, "1", captured.Get("time"))
+
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/series", strings.NewReader(url.Values{"match[]": {`{job="api"}`}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set(tenancy.DefaultTenantHeader, "team-a")
+	_, err = tripper.RoundTrip(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{`{job="api",namespace="a"}`}, captured["match[]"])
+
+	r = httptest.NewRequest(http.MethodGet, `/api/v1/query?query=up`, nil)
+	r.Header.Set(tenancy.DefaultTenantHeader, "team-b")
+	_, err = tripper.RoundTrip(r)
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,230 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/internal/cortex/tenant"
+)
+
+// AdaptiveSplitByIntervalMiddleware splits the range queries like SplitByIntervalMiddleware with the dynamic split
+// interval, except for the queries whose cost is learned. These are split with the largest interval keeping the
+// predicted cost of each split under the targets, and sharded in as many vertical shards as needed to reach the targets
+// when the min split interval is not enough. The costs are learned by QueryCostMiddleware from the requests of the
+// splits reaching the queriers. The splits are keyed in the results cache by the max split interval, so that their
+// cache entries do not change with their interval.
+func AdaptiveSplitByIntervalMiddleware(costs *QueryCosts, config QueryRangeConfig, numShards int, limits queryrange.Limits, merger queryrange.Merger, registerer prometheus.Registerer) queryrange.Middleware {
+	splitter := &adaptiveSplitter{
+		costs:          costs,
+		targetSamples:  config.AdaptiveSplitTargetSamples,
+		targetDuration: config.AdaptiveSplitTargetDuration,
+		minInterval:    config.MinQuerySplitInterval,
+		maxInterval:    config.MaxQuerySplitInterval,
+		maxShards:      numShards,
+		queriesTotal: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "thanos",
+			Name:      "frontend_adaptive_split_queries_total",
+			Help:      "Total number of range queries split by the adaptive split, by whether their cost was learned.",
+		}, []string{"learned"}),
+	}
+	splitter.queriesTotal.WithLabelValues("true")
+	splitter.queriesTotal.WithLabelValues("false")
+
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		split := newSplitByInterval(next, dynamicIntervalFn(config), limits, merger, registerer)
+		split.adaptive = splitter
+		return split
+	})
+}
+
+type adaptiveSplitter struct {
+	costs          *QueryCosts
+	targetSamples  int64
+	targetDuration time.Duration
+	minInterval    time.Duration
+	maxInterval    time.Duration
+	maxShards      int
+
+	queriesTotal *prometheus.CounterVec
+}
+
+// split returns the split interval and the handler learning the cost of the splits of the request. The number of
+// vertical shards of the splits is set in the returned context.
+func (a *adaptiveSplitter) split(ctx context.Context, r queryrange.Request, interval time.Duration, next queryrange.Handler) (context.Context, time.Duration, queryrange.Handler) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil || r.GetStep() <= 0 {
+		return ctx, interval, next
+	}
+	fp := newQueryFingerprint(tenant.JoinTenantIDs(tenantIDs), r.GetQuery())
+
+	if cost, ok := a.costs.get(fp); ok {
+		a.queriesTotal.WithLabelValues("true").Inc()
+		var shards int
+		interval, shards = a.plan(cost, r.GetStep())
+		if a.maxShards > 0 {
+			ctx = withVerticalShards(ctx, shards)
+		}
+	} else {
+		a.queriesTotal.WithLabelValues("false").Inc()
+	}
+	// The samples are learned from all the splits of the query, whatever the order they complete in.
+	stats := a.targetSamples > 0 && a.costs.samplesStale(fp)
+	return ctx, interval, a.observe(fp, stats, next)
+}
+
+// plan returns the largest split interval, the min split interval doubled as many times as possible up to the max one
+// so that the splits keep the same boundaries, for which the predicted cost of the splits is under the targets.
+// The shards split the cost further when it is still over the targets with the min interval.
+func (a *adaptiveSplitter) plan(cost queryCost, step int64) (time.Duration, int) {
+	maxSteps := math.Inf(1)
+	if a.targetSamples > 0 && cost.SamplesPerStep > 0 {
+		maxSteps = math.Min(maxSteps, float64(a.targetSamples)/cost.SamplesPerStep)
+	}
+	if a.targetDuration > 0 && cost.SecondsPerStep > 0 {
+		maxSteps = math.Min(maxSteps, a.targetDuration.Seconds()/cost.SecondsPerStep)
+	}
+	stepsOf := func(interval time.Duration) float64 {
+		return float64(interval.Milliseconds()) / float64(step)
+	}
+
+	interval := a.minInterval
+	if stepsOf(a.maxInterval) <= maxSteps {
+		interval = a.maxInterval
+	} else {
+		for interval*2 < a.maxInterval && stepsOf(interval*2) <= maxSteps {
+			interval *= 2
+		}
+	}
+
+	shards := int(math.Ceil(stepsOf(interval) / maxSteps))
+	if shards > a.maxShards {
+		shards = a.maxShards
+	}
+	if shards < 1 {
+		shards = 1
+	}
+	return interval, shards
+}
+
+// observe returns a handler learning the cost of the splits from the requests reaching the queriers, the requests
+// served by the results cache or coalesced with other ones not telling anything about it. The stats are requested
+// from the queriers if stats is true.
+func (a *adaptiveSplitter) observe(fp queryFingerprint, stats bool, next queryrange.Handler) queryrange.Handler {
+	return queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+		obs := &splitObservation{stats: stats, ranges: map[splitRange]*rangeCost{}}
+		resp, err := next.Do(context.WithValue(ctx, splitObservationKey{}, obs), r)
+		if err != nil {
+			return nil, err
+		}
+
+		obs.mtx.Lock()
+		defer obs.mtx.Unlock()
+		for rng, cost := range obs.ranges {
+			a.costs.observe(fp, (rng.end-rng.start)/r.GetStep()+1, cost.samples, cost.series, cost.duration)
+		}
+		return resp, nil
+	})
+}
+
+// pinCacheInterval returns the splits keyed in the results cache by the max split interval.
+func (a *adaptiveSplitter) pinCacheInterval(reqs []queryrange.Request) []queryrange.Request {
+	for i, r := range reqs {
+		if sr, ok := r.(SplitRequest); ok {
+			reqs[i] = sr.WithSplitInterval(a.maxInterval)
+		}
+	}
+	return reqs
+}
+
+type splitObservationKey struct{}
+
+// splitObservation collects the cost of the requests of a split reaching the queriers.
+type splitObservation struct {
+	// stats is true if the stats are requested from the queriers to learn the samples of the query.
+	stats bool
+
+	mtx    sync.Mutex
+	ranges map[splitRange]*rangeCost
+}
+
+// splitRange is the time range of a request of a split. A split has several ones when the results cache only misses
+// part of it, and the vertical shards of a split share the same one.
+type splitRange struct {
+	start, end int64
+}
+
+type rangeCost struct {
+	// samples is negative when a querier did not return them.
+	samples  int64
+	series   int
+	duration time.Duration
+}
+
+// add merges the cost of a request into the one of its range, the shards of a range running concurrently.
+func (o *splitObservation) add(rng splitRange, samples int64, series int, duration time.Duration) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	cost, ok := o.ranges[rng]
+	if !ok {
+		o.ranges[rng] = &rangeCost{samples: samples, series: series, duration: duration}
+		return
+	}
+	if cost.samples < 0 || samples < 0 {
+		cost.samples = -1
+	} else {
+		cost.samples += samples
+	}
+	cost.series += series
+	cost.duration = max(cost.duration, duration)
+}
+
+// QueryCostMiddleware records the cost of the requests of the adaptive splits reaching the queriers. It must be the
+// last middleware, so that the requests served by the results cache or coalesced with other ones are not recorded.
+// The stats are only requested from the queriers while the samples of the query are learned, and removed from the
+// responses when they were not requested by the client.
+func QueryCostMiddleware() queryrange.Middleware {
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		return queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+			obs, ok := ctx.Value(splitObservationKey{}).(*splitObservation)
+			if !ok || r.GetStep() <= 0 {
+				return next.Do(ctx, r)
+			}
+
+			statsRequested := r.GetStats() != ""
+			if obs.stats && !statsRequested {
+				r = r.WithStats("all")
+			}
+
+			start := time.Now()
+			resp, err := next.Do(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			promResp, ok := resp.(*queryrange.PrometheusResponse)
+			if !ok {
+				return resp, nil
+			}
+
+			samples := int64(-1)
+			if stats := promResp.Data.Stats; stats != nil && stats.Samples != nil {
+				samples = stats.Samples.TotalQueryableSamples
+			}
+			obs.add(splitRange{start: r.GetStart(), end: r.GetEnd()}, samples, len(promResp.Data.Result), time.Since(start))
+
+			if !statsRequested {
+				promResp.Data.Stats = nil
+			}
+			return promResp, nil
+		})
+	})
+}
@@ -0,0 +1,203 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/weaveworks/common/user"
+
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	cortexvalidation "github.com/thanos-io/thanos/internal/cortex/util/validation"
+)
+
+func TestAdaptiveSplitter_Plan(t *testing.T) {
+	splitter := &adaptiveSplitter{
+		minInterval: time.Hour,
+		maxInterval: day,
+		maxShards:   4,
+	}
+	for _, tc := range []struct {
+		name           string
+		targetSamples  int64
+		targetDuration time.Duration
+		cost           queryCost
+		interval       time.Duration
+		shards         int
+	}{
+		{
+			name:          "cheap query is split with the max interval",
+			targetSamples: 6000,
+			cost:          queryCost{SamplesPerStep: 0.001},
+			interval:      day,
+			shards:        1,
+		},
+		{
+			name:          "min interval is doubled while under the target samples",
+			targetSamples: 6000,
+			cost:          queryCost{SamplesPerStep: 10},
+			interval:      8 * time.Hour,
+			shards:        1,
+		},
+		{
+			name:           "min interval is doubled while under the target duration",
+			targetDuration: time.Second,
+			cost:           queryCost{SamplesPerStep: 10, SecondsPerStep: 0.01},
+			interval:       time.Hour,
+			shards:         1,
+		},
+		{
+			name:          "expensive query is sharded up to the max shards",
+			targetSamples: 6000,
+			cost:          queryCost{SamplesPerStep: 1000},
+			interval:      time.Hour,
+			shards:        4,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			splitter.targetSamples, splitter.targetDuration = tc.targetSamples, tc.targetDuration
+			interval, shards := splitter.plan(tc.cost, 60*seconds)
+			testutil.Equals(t, tc.interval, interval)
+			testutil.Equals(t, tc.shards, shards)
+		})
+	}
+}
+
+func TestAdaptiveSplitByIntervalMiddleware(t *testing.T) {
+	limits, err := cortexvalidation.NewOverrides(*defaultLimits, nil)
+	testutil.Ok(t, err)
+
+	var (
+		mtx    sync.Mutex
+		splits []queryrange.Request
+		shards []int
+	)
+	// Every split costs 100 samples per step.
+	next := queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+		mtx.Lock()
+		splits = append(splits, r)
+		if n, ok := ctx.Value(verticalShardsKey{}).(int); ok {
+			shards = append(shards, n)
+		}
+		mtx.Unlock()
+
+		steps := (r.GetEnd()-r.GetStart())/r.GetStep() + 1
+		return &queryrange.PrometheusResponse{Status: queryrange.StatusSuccess, Data: queryrange.PrometheusData{
+			ResultType: "matrix",
+			Stats: &queryrange.PrometheusResponseStats{Samples: &queryrange.PrometheusResponseSamplesStats{
+				TotalQueryableSamples: steps * 100,
+			}},
+		}}, nil
+	})
+
+	costs := NewQueryCosts(time.Hour, nil)
+	split := AdaptiveSplitByIntervalMiddleware(costs, QueryRangeConfig{
+		MinQuerySplitInterval:      time.Hour,
+		MaxQuerySplitInterval:      4 * time.Hour,
+		HorizontalShards:           2,
+		AdaptiveSplitTargetSamples: 3000,
+	}, 4, limits, NewThanosQueryRangeCodec(true), nil).Wrap(QueryCostMiddleware().Wrap(next))
+	ctx := user.InjectOrgID(context.Background(), "1")
+	req := &ThanosQueryRangeRequest{Query: "up", Start: 0, End: 8 * hour, Step: 60 * seconds}
+
+	// The cost of the query is not learned yet, it is split with the dynamic split interval.
+	resp, err := split.Do(ctx, req)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(splits))
+	testutil.Equals(t, 0, len(shards))
+	for _, r := range splits {
+		// The stats are requested to learn the samples of the query.
+		testutil.Equals(t, "all", r.GetStats())
+		// The splits are keyed in the results cache by the max split interval.
+		testutil.Equals(t, 4*time.Hour, r.(SplitRequest).GetSplitInterval())
+	}
+	testutil.Assert(t, resp.(*queryrange.PrometheusResponse).Data.Stats == nil, "expected the stats not requested to be removed")
+
+	// 100 samples per step make 6000 samples per split of 1h, sharded in 2 to reach the target.
+	splits = nil
+	_, err = split.Do(ctx, req)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 8, len(splits))
+	testutil.Equals(t, []int{2, 2, 2, 2, 2, 2, 2, 2}, shards)
+	for _, r := range splits {
+		// The samples are learned, the stats are not requested anymore.
+		testutil.Equals(t, "", r.GetStats())
+		testutil.Equals(t, 4*time.Hour, r.(SplitRequest).GetSplitInterval())
+	}
+
+	cost, ok := costs.get(newQueryFingerprint("1", "up"))
+	testutil.Assert(t, ok, "expected the cost of the query to be learned")
+	testutil.Equals(t, 100.0, cost.SamplesPerStep)
+	testutil.Equals(t, 10, cost.Observations)
+}
+
+func TestQueryCostMiddleware(t *testing.T) {
+	limits, err := cortexvalidation.NewOverrides(*defaultLimits, nil)
+	testutil.Ok(t, err)
+
+	// Every request reaching the querier costs 100 samples per step.
+	querier := queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+		steps := (r.GetEnd()-r.GetStart())/r.GetStep() + 1
+		return &queryrange.PrometheusResponse{Status: queryrange.StatusSuccess, Data: queryrange.PrometheusData{
+			ResultType: "matrix",
+			Result:     []queryrange.SampleStream{{}},
+			Stats: &queryrange.PrometheusResponseStats{Samples: &queryrange.PrometheusResponseSamplesStats{
+				TotalQueryableSamples: steps * 100,
+			}},
+		}}, nil
+	})
+	// The first half of the query is served by the cache, the second half by two shards.
+	cache := queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+		if r.GetEnd() <= 4*hour {
+			return &queryrange.PrometheusResponse{Status: queryrange.StatusSuccess, Data: queryrange.PrometheusData{ResultType: "matrix"}}, nil
+		}
+		reqResps, err := queryrange.DoRequests(ctx, QueryCostMiddleware().Wrap(querier), []queryrange.Request{r, r}, limits)
+		if err != nil {
+			return nil, err
+		}
+		return reqResps[0].Response, nil
+	})
+
+	costs := NewQueryCosts(time.Hour, nil)
+	split := AdaptiveSplitByIntervalMiddleware(costs, QueryRangeConfig{
+		MinQuerySplitInterval:      time.Hour,
+		MaxQuerySplitInterval:      4 * time.Hour,
+		HorizontalShards:           2,
+		AdaptiveSplitTargetSamples: 3000,
+	}, 0, limits, NewThanosQueryRangeCodec(true), nil).Wrap(cache)
+	ctx := user.InjectOrgID(context.Background(), "1")
+	_, err = split.Do(ctx, &ThanosQueryRangeRequest{Query: "up", Start: 0, End: 8*hour - 60*seconds, Step: 60 * seconds})
+	testutil.Ok(t, err)
+
+	// Only the split reaching the querier is learned, the samples and series of its shards being summed.
+	cost, ok := costs.get(newQueryFingerprint("1", "up"))
+	testutil.Assert(t, ok, "expected the cost of the query to be learned")
+	testutil.Equals(t, 1, cost.Observations)
+	testutil.Equals(t, 200.0, cost.SamplesPerStep)
+	testutil.Equals(t, 2.0, cost.Series)
+}
+
+func TestQueryCosts_TTL(t *testing.T) {
+	now := time.Unix(0, 0)
+	costs := NewQueryCosts(time.Hour, nil)
+	costs.now = func() time.Time { return now }
+
+	fp := newQueryFingerprint("1", "sum(rate(up[5m]))")
+	costs.observe(fp, 10, 1000, 1, time.Second)
+	cost, ok := costs.get(newQueryFingerprint("1", "sum( rate(up[5m]) )"))
+	testutil.Assert(t, ok, "expected the cost of the query with the same fingerprint")
+	testutil.Equals(t, 100.0, cost.SamplesPerStep)
+	testutil.Equals(t, 0.1, cost.SecondsPerStep)
+
+	_, ok = costs.get(newQueryFingerprint("2", "sum(rate(up[5m]))"))
+	testutil.Assert(t, !ok, "expected the costs to be learned per tenant")
+
+	now = now.Add(2 * time.Hour)
+	_, ok = costs.get(fp)
+	testutil.Assert(t, !ok, "expected the cost to expire")
+}
@@ -5,40 +5,69 @@ package queryfrontend
 
 import (
 	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
 
 	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/extpromql"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+const (
+	// Results of the normalization of the query or matchers of a cache key.
+	normalizationChanged   = "changed"
+	normalizationUnchanged = "unchanged"
+	normalizationFailed    = "failed"
 )
 
 // thanosCacheKeyGenerator 是一个用于在确定缓存键时使用分割时间区间(split interval)的工具.
+// The queries and matchers are normalized first, so that semantically identical requests share their cache entries.
 type thanosCacheKeyGenerator struct {
-	resolutions []int64
+	resolutions    []int64
+	normalizations *prometheus.CounterVec
 }
 
-func newThanosCacheKeyGenerator() thanosCacheKeyGenerator {
-	return thanosCacheKeyGenerator{
+func newThanosCacheKeyGenerator(reg prometheus.Registerer) thanosCacheKeyGenerator {
+	g := thanosCacheKeyGenerator{
 		resolutions: []int64{downsample.ResLevel2, downsample.ResLevel1, downsample.ResLevel0},
+		normalizations: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "thanos",
+			Name:      "frontend_cache_key_normalizations_total",
+			Help:      "Total number of cache keys generated, by result of the normalization of their query or matchers. Changed keys are shared with the requests written differently but semantically identical.",
+		}, []string{"result"}),
 	}
+	for _, result := range []string{normalizationChanged, normalizationUnchanged, normalizationFailed} {
+		g.normalizations.WithLabelValues(result)
+	}
+	return g
 }
 
 // GenerateCacheKey 根据请求(Request)和时间区间(interval)生成一个缓存键.
 // TODO(yeya24): 添加其他请求参数作为缓存键的一部分.
 func (t thanosCacheKeyGenerator) GenerateCacheKey(userID string, r queryrange.Request) string {
+	// Instant queries are not split, each evaluation time has its own entry.
+	if tr, ok := r.(*ThanosQueryInstantRequest); ok {
+		return fmt.Sprintf("fe:instant:%s:%s:%d:%d:%t:%s:%d:%s", userID, t.normalizeQuery(tr.Query), tr.Time, t.resolutionLevel(tr.MaxSourceResolution), tr.AutoDownsampling, generateShardInfoKey(tr.ShardInfo), tr.LookbackDelta, tr.Engine)
+	}
+
 	if sr, ok := r.(SplitRequest); ok {
 		splitInterval := sr.GetSplitInterval().Milliseconds()
 		currentInterval := r.GetStart() / splitInterval
 
 		switch tr := r.(type) {
 		case *ThanosQueryRangeRequest:
-			i := 0
-			for ; i < len(t.resolutions) && t.resolutions[i] > tr.MaxSourceResolution; i++ {
-			}
-			shardInfoKey := generateShardInfoKey(tr)
-			return fmt.Sprintf("fe:%s:%s:%d:%d:%d:%d:%s:%d:%s", userID, tr.Query, tr.Step, splitInterval, currentInterval, i, shardInfoKey, tr.LookbackDelta, tr.Engine)
+			shardInfoKey := generateShardInfoKey(tr.ShardInfo)
+			return fmt.Sprintf("fe:%s:%s:%d:%d:%d:%d:%s:%d:%s", userID, t.normalizeQuery(tr.Query), tr.Step, splitInterval, currentInterval, t.resolutionLevel(tr.MaxSourceResolution), shardInfoKey, tr.LookbackDelta, tr.Engine)
 		case *ThanosLabelsRequest:
-			return fmt.Sprintf("fe:%s:%s:%s:%d:%d", userID, tr.Label, tr.Matchers, splitInterval, currentInterval)
+			return fmt.Sprintf("fe:%s:%s:%s:%d:%d", userID, tr.Label, t.normalizeMatchers(tr.Matchers), splitInterval, currentInterval) + labelsShardInfoKey(tr.ShardInfo) + labelsLimitKey(tr.Limit)
 		case *ThanosSeriesRequest:
-			return fmt.Sprintf("fe:%s:%s:%d:%d", userID, tr.Matchers, splitInterval, currentInterval)
+			return fmt.Sprintf("fe:%s:%s:%d:%d", userID, t.normalizeMatchers(tr.Matchers), splitInterval, currentInterval) + labelsShardInfoKey(tr.ShardInfo) + labelsLimitKey(tr.Limit)
 		}
 	}
 
@@ -46,9 +75,114 @@ func (t thanosCacheKeyGenerator) GenerateCacheKey(userID string, r queryrange.Re
 	panic("request type not supported")
 }
 
-func generateShardInfoKey(r *ThanosQueryRangeRequest) string {
-	if r.ShardInfo == nil {
+// normalizeQuery returns the canonical form of the query, or the query itself if it cannot be parsed.
+func (t thanosCacheKeyGenerator) normalizeQuery(query string) string {
+	expr, err := extpromql.ParseExpr(query)
+	if err != nil {
+		t.normalizations.WithLabelValues(normalizationFailed).Inc()
+		return query
+	}
+	normalized := canonicalExpr(expr).String()
+	t.observeNormalization(query, normalized)
+	return normalized
+}
+
+// normalizeMatchers returns the matcher sets with sorted matchers, in sorted order, as printed in the cache keys.
+func (t thanosCacheKeyGenerator) normalizeMatchers(matcherSets [][]*labels.Matcher) string {
+	raw := fmt.Sprintf("%s", matcherSets)
+
+	sets := make([][]*labels.Matcher, 0, len(matcherSets))
+	for _, ms := range matcherSets {
+		ms = slices.Clone(ms)
+		sortMatchers(ms)
+		sets = append(sets, ms)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return fmt.Sprintf("%s", sets[i]) < fmt.Sprintf("%s", sets[j])
+	})
+	normalized := fmt.Sprintf("%s", sets)
+	t.observeNormalization(raw, normalized)
+	return normalized
+}
+
+func (t thanosCacheKeyGenerator) observeNormalization(raw, normalized string) {
+	if raw == normalized {
+		t.normalizations.WithLabelValues(normalizationUnchanged).Inc()
+		return
+	}
+	t.normalizations.WithLabelValues(normalizationChanged).Inc()
+}
+
+// canonicalExpr rewrites the expression in place into a canonical form: the metric name of selectors is taken
+// out of their __name__ matcher, matchers are sorted, and so are the grouping and vector matching labels. Printing
+// the expression also normalizes whitespaces and durations.
+func canonicalExpr(expr parser.Expr) parser.Expr {
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.VectorSelector:
+			if n.Name == "" {
+				for _, m := range n.LabelMatchers {
+					if m.Name == labels.MetricName && m.Type == labels.MatchEqual && m.Value != "" {
+						n.Name = m.Value
+						break
+					}
+				}
+			}
+			sortMatchers(n.LabelMatchers)
+		case *parser.AggregateExpr:
+			sort.Strings(n.Grouping)
+		case *parser.BinaryExpr:
+			if n.VectorMatching != nil {
+				sort.Strings(n.VectorMatching.MatchingLabels)
+				sort.Strings(n.VectorMatching.Include)
+			}
+		}
+		return nil
+	})
+	return expr
+}
+
+func sortMatchers(ms []*labels.Matcher) {
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].Name != ms[j].Name {
+			return ms[i].Name < ms[j].Name
+		}
+		if ms[i].Type != ms[j].Type {
+			return ms[i].Type < ms[j].Type
+		}
+		return ms[i].Value < ms[j].Value
+	})
+}
+
+// resolutionLevel returns the index of the highest downsampling resolution allowed by the max source resolution.
+func (t thanosCacheKeyGenerator) resolutionLevel(maxSourceResolution int64) int {
+	i := 0
+	for ; i < len(t.resolutions) && t.resolutions[i] > maxSourceResolution; i++ {
+	}
+	return i
+}
+
+func generateShardInfoKey(info *storepb.ShardInfo) string {
+	if info == nil {
 		return "-"
 	}
-	return fmt.Sprintf("%d:%d", r.ShardInfo.TotalShards, r.ShardInfo.ShardIndex)
+	return fmt.Sprintf("%d:%d", info.TotalShards, info.ShardIndex)
+}
+
+// labelsLimitKey returns the suffix of the cache key of limited labels and series requests, keeping the keys of the
+// unlimited ones unchanged.
+func labelsLimitKey(limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(":limit=%d", limit)
+}
+
+// labelsShardInfoKey is appended to the keys of sharded labels and series requests only, so that the keys of the
+// requests that are not sharded stay the same.
+func labelsShardInfoKey(info *storepb.ShardInfo) string {
+	if info == nil {
+		return ""
+	}
+	return ":" + generateShardInfoKey(info)
 }
@@ -7,15 +7,18 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/labels"
 
 	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 
 	"github.com/efficientgo/core/testutil"
 )
 
 func TestGenerateCacheKey(t *testing.T) {
-	splitter := newThanosCacheKeyGenerator()
+	splitter := newThanosCacheKeyGenerator(nil)
 
 	for _, tc := range []struct {
 		name     string
@@ -87,6 +90,25 @@ func TestGenerateCacheKey(t *testing.T) {
 			},
 			expected: "fe::up:10000:3600000:0:0:-:1000:",
 		},
+		{
+			name: "instant query",
+			req: &ThanosQueryInstantRequest{
+				Query: "up",
+				Time:  60 * seconds,
+			},
+			expected: "fe:instant::up:60000:2:false:-:0:",
+		},
+		{
+			name: "instant query with auto downsampling and shard info",
+			req: &ThanosQueryInstantRequest{
+				Query:            "up",
+				Time:             60 * seconds,
+				AutoDownsampling: true,
+				ShardInfo:        &storepb.ShardInfo{TotalShards: 2, ShardIndex: 1},
+				Engine:           "thanos",
+			},
+			expected: "fe:instant::up:60000:2:true:2:1:0:thanos",
+		},
 		{
 			name: "label names, no matcher",
 			req: &ThanosLabelsRequest{
@@ -114,7 +136,7 @@ func TestGenerateCacheKey(t *testing.T) {
 				},
 				SplitInterval: time.Hour,
 			},
-			expected: `fe:::[[foo="bar"] [baz="qux"]]:3600000:0`,
+			expected: `fe:::[[baz="qux"] [foo="bar"]]:3600000:0`,
 		},
 		{
 			name: "label values, no matcher",
@@ -146,7 +168,28 @@ func TestGenerateCacheKey(t *testing.T) {
 				},
 				SplitInterval: time.Hour,
 			},
-			expected: `fe::up:[[foo="bar"] [baz="qux"]]:3600000:0`,
+			expected: `fe::up:[[baz="qux"] [foo="bar"]]:3600000:0`,
+		},
+		{
+			name: "label values, sharded",
+			req: &ThanosLabelsRequest{
+				Start:         0,
+				Label:         "up",
+				Matchers:      [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, "foo", "bar")}},
+				SplitInterval: time.Hour,
+				ShardInfo:     &storepb.ShardInfo{TotalShards: 4, ShardIndex: 1},
+			},
+			expected: `fe::up:[[foo="bar"]]:3600000:0:4:1`,
+		},
+		{
+			name: "series, sharded",
+			req: &ThanosSeriesRequest{
+				Start:         0,
+				Matchers:      [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, "foo", "bar")}},
+				SplitInterval: time.Hour,
+				ShardInfo:     &storepb.ShardInfo{TotalShards: 4, ShardIndex: 1},
+			},
+			expected: `fe::[[foo="bar"]]:3600000:0:4:1`,
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
@@ -156,8 +199,70 @@ func TestGenerateCacheKey(t *testing.T) {
 	}
 }
 
+func TestGenerateCacheKey_Normalization(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	splitter := newThanosCacheKeyGenerator(reg)
+
+	for _, tc := range []struct {
+		name     string
+		queries  []string
+		expected string
+	}{
+		{
+			name:     "whitespaces",
+			queries:  []string{`sum(rate(up[5m]))`, "sum ( rate(up[5m] ) )", "sum(\n  rate(up[5m])\n)"},
+			expected: `sum(rate(up[5m]))`,
+		},
+		{
+			name:     "matchers order and metric name",
+			queries:  []string{`up{job="a",instance=~"b.*"}`, `up{instance=~"b.*",job="a"}`, `{__name__="up",job="a",instance=~"b.*"}`},
+			expected: `up{instance=~"b.*",job="a"}`,
+		},
+		{
+			name:     "grouping labels order",
+			queries:  []string{`sum by (pod, namespace) (up)`, `sum(up) by (namespace,pod)`},
+			expected: `sum by (namespace, pod) (up)`,
+		},
+		{
+			name:     "vector matching labels order",
+			queries:  []string{`a * on(y, x) group_left(d, c) b`, `a * on(x, y) group_left(c, d) b`},
+			expected: `a * on (x, y) group_left (c, d) b`,
+		},
+		{
+			name:     "durations",
+			queries:  []string{`rate(up[24h])`, `rate(up[1d])`, `rate(up[86400s])`},
+			expected: `rate(up[1d])`,
+		},
+		{
+			name:     "unparsable query",
+			queries:  []string{`sum(`},
+			expected: `sum(`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, q := range tc.queries {
+				key := splitter.GenerateCacheKey("", &ThanosQueryRangeRequest{Query: q, Step: 60 * seconds, SplitInterval: time.Hour})
+				testutil.Equals(t, "fe::"+tc.expected+":60000:3600000:0:2:-:0:", key)
+			}
+		})
+	}
+
+	testutil.Equals(t, 10.0, promtest.ToFloat64(splitter.normalizations.WithLabelValues(normalizationChanged)))
+	testutil.Equals(t, 3.0, promtest.ToFloat64(splitter.normalizations.WithLabelValues(normalizationUnchanged)))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(splitter.normalizations.WithLabelValues(normalizationFailed)))
+
+	key := splitter.GenerateCacheKey("", &ThanosSeriesRequest{
+		Matchers: [][]*labels.Matcher{
+			{labels.MustNewMatcher(labels.MatchEqual, "job", "a"), labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+			{labels.MustNewMatcher(labels.MatchEqual, "__name__", "down")},
+		},
+		SplitInterval: time.Hour,
+	})
+	testutil.Equals(t, `fe::[[__name__="down"] [__name__="up" job="a"]]:3600000:0`, key)
+}
+
 func TestGenerateCacheKey_UnsupportedRequest(t *testing.T) {
-	splitter := newThanosCacheKeyGenerator()
+	splitter := newThanosCacheKeyGenerator(nil)
 
 	req := &queryrange.PrometheusRequest{
 		Query: "up",
@@ -0,0 +1,148 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/internal/cortex/tenant"
+)
+
+// CoalescingMiddleware sends identical concurrent requests of a tenant downstream once, the requests sharing the
+// response. Requests are identical when they are encoded to the same downstream request. The requests whose results
+// must not be cached are not coalesced either.
+func CoalescingMiddleware(codec queryrange.Codec, registerer prometheus.Registerer) queryrange.Middleware {
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		return &coalescer{
+			next:     next,
+			codec:    codec,
+			inflight: map[string]*inflightRequest{},
+			coalesced: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+				Namespace: "thanos",
+				Name:      "frontend_coalesced_requests_total",
+				Help:      "Total number of requests which waited for the response of an identical in-flight request instead of being sent downstream.",
+			}),
+		}
+	})
+}
+
+type coalescer struct {
+	next  queryrange.Handler
+	codec queryrange.Codec
+
+	mtx      sync.Mutex
+	inflight map[string]*inflightRequest
+
+	coalesced prometheus.Counter
+}
+
+type inflightRequest struct {
+	done chan struct{}
+	resp queryrange.Response
+	err  error
+
+	// waiting is the number of requests waiting for the response, the downstream request is canceled once all of
+	// them gave up.
+	waiting int
+	cancel  context.CancelFunc
+}
+
+func (c *coalescer) Do(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+	key, ok := c.key(ctx, r)
+	if !ok {
+		return c.next.Do(ctx, r)
+	}
+
+	c.mtx.Lock()
+	req, ok := c.inflight[key]
+	if ok {
+		req.waiting++
+		c.mtx.Unlock()
+		c.coalesced.Inc()
+	} else {
+		// The downstream request outlives the request starting it if other ones wait for it.
+		reqCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		req = &inflightRequest{done: make(chan struct{}), waiting: 1, cancel: cancel}
+		c.inflight[key] = req
+		c.mtx.Unlock()
+		go c.run(reqCtx, key, req, r)
+	}
+
+	select {
+	case <-req.done:
+		if req.err != nil {
+			return nil, req.err
+		}
+		// Every request gets its own copy, the responses are modified when merged.
+		return proto.Clone(req.resp).(queryrange.Response), nil
+	case <-ctx.Done():
+		c.mtx.Lock()
+		req.waiting--
+		if req.waiting == 0 {
+			req.cancel()
+			if c.inflight[key] == req {
+				delete(c.inflight, key)
+			}
+		}
+		c.mtx.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *coalescer) run(ctx context.Context, key string, req *inflightRequest, r queryrange.Request) {
+	defer req.cancel()
+	req.resp, req.err = c.next.Do(ctx, r)
+
+	c.mtx.Lock()
+	if c.inflight[key] == req {
+		delete(c.inflight, key)
+	}
+	c.mtx.Unlock()
+	close(req.done)
+}
+
+// key returns the downstream request the request is encoded to, with its method, URL, headers and body. The
+// requests that cannot be encoded are not coalesced.
+func (c *coalescer) key(ctx context.Context, r queryrange.Request) (string, bool) {
+	if !shouldCache(r) {
+		return "", false
+	}
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return "", false
+	}
+	req, err := c.codec.EncodeRequest(ctx, r)
+	if err != nil {
+		return "", false
+	}
+	var body []byte
+	if req.Body != nil {
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return "", false
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s %s\n", tenant.JoinTenantIDs(tenantIDs), req.Method, req.URL.String())
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %q\n", name, req.Header[name])
+	}
+	b.Write(body)
+	return b.String(), true
+}
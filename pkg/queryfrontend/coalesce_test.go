@@ -0,0 +1,187 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+
+	"github.com/thanos-io/thanos/internal/cortex/cortexpb"
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+func TestCoalescingMiddleware(t *testing.T) {
+	var (
+		calls   atomic.Int64
+		release = make(chan struct{})
+	)
+	next := queryrange.HandlerFunc(func(ctx context.Context, _ queryrange.Request) (queryrange.Response, error) {
+		calls.Inc()
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return &queryrange.PrometheusResponse{Status: queryrange.StatusSuccess, Data: queryrange.PrometheusData{
+			ResultType: "matrix",
+			Result:     []queryrange.SampleStream{{Samples: []cortexpb.Sample{{TimestampMs: 0, Value: 1}}}},
+		}}, nil
+	})
+
+	newCoalescer := func() (queryrange.Handler, *prometheus.Registry) {
+		reg := prometheus.NewRegistry()
+		return CoalescingMiddleware(NewThanosQueryRangeCodec(true), reg).Wrap(next), reg
+	}
+	req := &ThanosQueryRangeRequest{Path: "/api/v1/query_range", Query: "up", Start: 0, End: hour, Step: 60 * seconds, Dedup: true, SplitInterval: time.Hour}
+
+	t.Run("identical requests of a tenant are coalesced", func(t *testing.T) {
+		calls.Store(0)
+		release = make(chan struct{})
+		coalescer, reg := newCoalescer()
+
+		var (
+			wg    sync.WaitGroup
+			resps = make([]queryrange.Response, 5)
+		)
+		for i := range resps {
+			tenant := "a"
+			if i == len(resps)-1 {
+				tenant = "b"
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				resp, err := coalescer.Do(user.InjectOrgID(context.Background(), tenant), req)
+				testutil.Ok(t, err)
+				resps[i] = resp
+			}(i)
+		}
+		testutil.Ok(t, waitFor(func() bool { return calls.Load() == 2 && coalescedRequests(t, reg) == 3 }))
+		close(release)
+		wg.Wait()
+
+		testutil.Equals(t, int64(2), calls.Load())
+		for _, resp := range resps[1:] {
+			testutil.Equals(t, resps[0], resp)
+			testutil.Assert(t, resps[0] != resp, "expected a copy of the response per request")
+		}
+	})
+
+	t.Run("downstream request is canceled once all requests gave up", func(t *testing.T) {
+		calls.Store(0)
+		release = make(chan struct{})
+		coalescer, reg := newCoalescer()
+
+		ctx1, cancel1 := context.WithCancel(user.InjectOrgID(context.Background(), "a"))
+		ctx2, cancel2 := context.WithCancel(user.InjectOrgID(context.Background(), "a"))
+		errs := make(chan error, 2)
+		go func() { _, err := coalescer.Do(ctx1, req); errs <- err }()
+		testutil.Ok(t, waitFor(func() bool { return calls.Load() == 1 }))
+		go func() { _, err := coalescer.Do(ctx2, req); errs <- err }()
+		testutil.Ok(t, waitFor(func() bool { return coalescedRequests(t, reg) == 1 }))
+
+		// The request which started the downstream request gives up, the other one still waits for it.
+		cancel1()
+		testutil.Equals(t, context.Canceled, <-errs)
+		select {
+		case err := <-errs:
+			t.Fatalf("unexpected response: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		cancel2()
+		testutil.Equals(t, context.Canceled, <-errs)
+
+		// A new request is sent downstream.
+		close(release)
+		_, err := coalescer.Do(user.InjectOrgID(context.Background(), "a"), req)
+		testutil.Ok(t, err)
+		testutil.Equals(t, int64(2), calls.Load())
+	})
+
+	t.Run("requests differing only in dedup are not coalesced", func(t *testing.T) {
+		calls.Store(0)
+		release = make(chan struct{})
+		coalescer, reg := newCoalescer()
+
+		// The request without dedup is sent concurrently to another one, which it would share the cache key with.
+		withReplicaLabels := *req
+		withReplicaLabels.ReplicaLabels = []string{"replica"}
+		withoutDedup := withReplicaLabels
+		withoutDedup.Dedup = false
+
+		errs := make(chan error, 2)
+		go func() {
+			_, err := coalescer.Do(user.InjectOrgID(context.Background(), "a"), &withReplicaLabels)
+			errs <- err
+		}()
+		testutil.Ok(t, waitFor(func() bool { return calls.Load() == 1 }))
+		go func() {
+			_, err := coalescer.Do(user.InjectOrgID(context.Background(), "a"), &withoutDedup)
+			errs <- err
+		}()
+		testutil.Ok(t, waitFor(func() bool { return calls.Load() == 2 }))
+		close(release)
+		testutil.Ok(t, <-errs)
+		testutil.Ok(t, <-errs)
+		testutil.Equals(t, 0.0, coalescedRequests(t, reg))
+	})
+
+	t.Run("requests differing in parameters outside of the cache key are not coalesced", func(t *testing.T) {
+		calls.Store(0)
+		release = make(chan struct{})
+		coalescer, reg := newCoalescer()
+
+		withStats := *req
+		withStats.Stats = "all"
+		partial := *req
+		partial.PartialResponse = true
+
+		errs := make(chan error, 3)
+		for i, r := range []*ThanosQueryRangeRequest{req, &withStats, &partial} {
+			go func() {
+				_, err := coalescer.Do(user.InjectOrgID(context.Background(), "a"), r)
+				errs <- err
+			}()
+			testutil.Ok(t, waitFor(func() bool { return calls.Load() == int64(i+1) }))
+		}
+		close(release)
+		for range 3 {
+			testutil.Ok(t, <-errs)
+		}
+		testutil.Equals(t, 0.0, coalescedRequests(t, reg))
+	})
+
+}
+
+func coalescedRequests(t *testing.T, reg *prometheus.Registry) float64 {
+	mfs, err := reg.Gather()
+	testutil.Ok(t, err)
+	for _, mf := range mfs {
+		if mf.GetName() == "thanos_frontend_coalesced_requests_total" {
+			return mf.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func waitFor(cond func() bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return runutil.Retry(5*time.Millisecond, ctx.Done(), func() error {
+		if !cond() {
+			return errors.New("condition not met")
+		}
+		return nil
+	})
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/cacheutil"
 	"github.com/thanos-io/thanos/pkg/exthttp"
 	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/tenancy"
 )
 
 type ResponseCacheProvider string
@@ -200,6 +201,7 @@ type DownstreamTripperConfig struct {
 type Config struct {
 	QueryRangeConfig
 	LabelsConfig
+	InstantQueryConfig
 	DownstreamTripperConfig
 
 	CortexHandlerConfig    *transport.HandlerConfig
@@ -208,11 +210,19 @@ type Config struct {
 	RequestLoggingDecision string
 	DownstreamURL          string
 	ForwardHeaders         []string
-	NumShards              int // --query-frontend.vertical-shards
+	NumShards              int  // --query-frontend.vertical-shards
+	CoalesceRequests       bool // --query-frontend.coalesce-requests
+	QueueEnabled           bool // --query-frontend.queue.enabled
 	TenantHeader           string
 	DefaultTenant          string
 	TenantCertField        string
 	EnableXFunctions       bool
+	// AccessPolicy restricts the series tenants can read, if set.
+	AccessPolicy *tenancy.AccessPolicy
+	// QueryRules block, route or rewrite the matching requests, if set.
+	QueryRules *QueryRules
+	// QueryCosts learns the cost of the range queries split adaptively, if set.
+	QueryCosts *QueryCosts
 }
 
 // QueryRangeConfig holds the config for query range tripperware.
@@ -222,6 +232,8 @@ type QueryRangeConfig struct {
 
 	ResultsCacheConfig *queryrange.ResultsCacheConfig
 	CachePathOrContent extflag.PathOrContent
+	// --query-range.partial-response-cache-ttl
+	PartialResponseCacheTTL time.Duration
 
 	// --query-range.align-range-with-step
 	AlignRangeWithStep bool
@@ -238,6 +250,29 @@ type QueryRangeConfig struct {
 	HorizontalShards      int64
 	MaxRetries            int
 	Limits                *cortexvalidation.Limits
+
+	// --query-range.adaptive-split.target-samples, 默认值: 0
+	AdaptiveSplitTargetSamples int64
+	// --query-range.adaptive-split.target-duration, 默认值: 0
+	AdaptiveSplitTargetDuration time.Duration
+	// --query-range.adaptive-split.cost-ttl, 默认值: 1h
+	AdaptiveSplitCostTTL time.Duration
+}
+
+// isAdaptiveSplitSet returns true if the split interval of the range queries is chosen from their learned cost.
+func (cfg QueryRangeConfig) isAdaptiveSplitSet() bool {
+	return cfg.AdaptiveSplitTargetSamples > 0 || cfg.AdaptiveSplitTargetDuration > 0
+}
+
+// InstantQueryConfig holds the config for instant query tripperware.
+type InstantQueryConfig struct {
+	ResultsCacheConfig *queryrange.ResultsCacheConfig
+	CachePathOrContent extflag.PathOrContent
+
+	// query-instant.cache-time-bucket
+	CacheTimeBucket time.Duration
+	// query-instant.response-cache-max-freshness
+	MaxCacheFreshness time.Duration
 }
 
 // LabelsConfig holds the config for labels tripperware.
@@ -251,10 +286,15 @@ type LabelsConfig struct {
 
 	ResultsCacheConfig *queryrange.ResultsCacheConfig
 	CachePathOrContent extflag.PathOrContent
+	// labels.partial-response-cache-ttl
+	PartialResponseCacheTTL time.Duration
 
 	// labels.split-interval
 	SplitQueriesByInterval time.Duration
 
+	// labels.vertical-shards
+	NumShards int
+
 	// labels.max-retries-per-request
 	MaxRetries int
 
@@ -288,6 +328,15 @@ func (cfg *Config) Validate() error {
 		}
 	}
 
+	if cfg.QueryRangeConfig.isAdaptiveSplitSet() {
+		if !cfg.isDynamicSplitSet() {
+			return errors.New("adaptive split requires the dynamic query split intervals to be set")
+		}
+		if cfg.QueryRangeConfig.AdaptiveSplitCostTTL <= 0 {
+			return errors.New("adaptive split cost TTL should be greater than 0")
+		}
+	}
+
 	if cfg.LabelsConfig.ResultsCacheConfig != nil {
 		if cfg.LabelsConfig.SplitQueriesByInterval <= 0 {
 			return errors.New("split queries interval should be greater than 0  when caching is enabled")
@@ -297,6 +346,19 @@ func (cfg *Config) Validate() error {
 		}
 	}
 
+	if cfg.InstantQueryConfig.ResultsCacheConfig != nil {
+		if cfg.InstantQueryConfig.CacheTimeBucket < 0 {
+			return errors.New("instant query cache time bucket cannot be negative")
+		}
+		if err := cfg.InstantQueryConfig.ResultsCacheConfig.Validate(querier.Config{}); err != nil {
+			return errors.Wrap(err, "invalid ResultsCache config for query_instant tripperware")
+		}
+	}
+
+	if cfg.QueueEnabled && cfg.QueryRules != nil && cfg.QueryRules.hasRouteRules() {
+		return errors.New("query rules with the route action cannot be used with the request queue enabled, as queued requests are pulled by the queriers")
+	}
+
 	if cfg.LabelsConfig.DefaultTimeRange == 0 {
 		return errors.New("labels.default-time-range cannot be set to 0")
 	}
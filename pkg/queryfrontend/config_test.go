@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+
 	"github.com/thanos-io/thanos/internal/cortex/chunk/cache"
 	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
 )
@@ -21,6 +23,9 @@ func TestConfig_Validate(t *testing.T) {
 		err    string
 	}
 
+	routeRules, err := NewQueryRules(staticQueryRules(`rules: [{name: a, action: route, downstream_url: 'http://heavy:9090'}]`), nil, log.NewNopLogger(), 0)
+	testutil.Ok(t, err)
+
 	testCases := []testCase{
 		{
 			name: "invalid query range options",
@@ -72,6 +77,29 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			err: "min query split interval should be greater than 0 when query split threshold is enabled",
 		},
+		{
+			name: "adaptive split without dynamic query range split",
+			config: Config{
+				QueryRangeConfig: QueryRangeConfig{
+					SplitQueriesByInterval:     10 * time.Hour,
+					AdaptiveSplitTargetSamples: 1000,
+					AdaptiveSplitCostTTL:       time.Hour,
+				},
+			},
+			err: "adaptive split requires the dynamic query split intervals to be set",
+		},
+		{
+			name: "route rules with the request queue",
+			config: Config{
+				DownstreamURL: "localhost:8080",
+				QueueEnabled:  true,
+				QueryRules:    routeRules,
+				LabelsConfig: LabelsConfig{
+					DefaultTimeRange: day,
+				},
+			},
+			err: "query rules with the route action cannot be used with the request queue enabled, as queued requests are pulled by the queriers",
+		},
 		{
 			name: "valid config with caching",
 			config: Config{
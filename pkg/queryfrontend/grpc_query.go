@@ -0,0 +1,217 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/internal/cortex/cortexpb"
+	"github.com/thanos-io/thanos/internal/cortex/frontend/transport"
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/pkg/api/query/querypb"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+var errHistogramsNotSupported = httpgrpc.Errorf(http.StatusNotImplemented, "native histograms are not supported by the gRPC query API")
+
+// QueryServer serves the querypb.Query gRPC service of the Querier. The requests are converted to HTTP requests and
+// served by the HTTP handler of the query frontend, so that they are audited, logged when slow, split, sharded, cached
+// and retried like the HTTP ones.
+type QueryServer struct {
+	handler      http.Handler
+	rangeCodec   *queryRangeCodec
+	instantCodec *queryInstantCodec
+}
+
+// NewQueryServer returns a QueryServer serving the requests with the given handler, which is the transport handler of
+// the tripperware of NewTripperware, wrapped by the same middlewares as the HTTP API.
+func NewQueryServer(handler http.Handler, config Config) *QueryServer {
+	return &QueryServer{
+		handler:      handler,
+		rangeCodec:   NewThanosQueryRangeCodec(config.QueryRangeConfig.PartialResponseStrategy),
+		instantCodec: NewThanosQueryInstantCodec(config.QueryRangeConfig.PartialResponseStrategy),
+	}
+}
+
+func (s *QueryServer) Query(request *querypb.QueryRequest, server querypb.Query_QueryServer) error {
+	req, err := s.instantCodec.DecodeGRPCRequest(request)
+	if err != nil {
+		return grpcError(err)
+	}
+	res, err := s.roundTrip(server.Context(), req, req.Timeout, s.instantCodec)
+	if err != nil {
+		return grpcError(err)
+	}
+	resps, err := s.instantCodec.EncodeGRPCResponse(res)
+	if err != nil {
+		return grpcError(err)
+	}
+	for _, resp := range resps {
+		if err := server.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *QueryServer) QueryRange(request *querypb.QueryRangeRequest, server querypb.Query_QueryRangeServer) error {
+	req, err := s.rangeCodec.DecodeGRPCRequest(request)
+	if err != nil {
+		return grpcError(err)
+	}
+	res, err := s.roundTrip(server.Context(), req, req.Timeout, s.rangeCodec)
+	if err != nil {
+		return grpcError(err)
+	}
+	resps, err := s.rangeCodec.EncodeGRPCResponse(res)
+	if err != nil {
+		return grpcError(err)
+	}
+	for _, resp := range resps {
+		if err := server.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roundTrip serves the request with the HTTP handler, the tenant of the request is taken from the gRPC metadata, or
+// from the client certificate of the connection.
+func (s *QueryServer) roundTrip(ctx context.Context, r queryrange.Request, timeout time.Duration, codec queryrange.Codec) (queryrange.Response, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	httpReq, err := codec.EncodeRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	orgID := "anonymous"
+	if tenant, ok := tenancy.GetTenantFromGRPCMetadata(ctx); ok {
+		httpReq.Header.Set(tenancy.DefaultTenantHeader, tenant)
+		orgID = tenant
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			httpReq.TLS = &info.State
+		}
+	}
+
+	w := &responseBuffer{header: http.Header{}, statusCode: http.StatusOK}
+	s.handler.ServeHTTP(w, httpReq.WithContext(user.InjectOrgID(ctx, orgID)))
+	return codec.DecodeResponse(ctx, &http.Response{
+		StatusCode: w.statusCode,
+		Header:     w.header,
+		Body:       io.NopCloser(&w.body),
+	}, r)
+}
+
+// responseBuffer is the http.ResponseWriter of the requests served by the QueryServer, keeping the response to
+// decode it.
+type responseBuffer struct {
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *responseBuffer) Header() http.Header { return w.header }
+
+func (w *responseBuffer) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.statusCode = statusCode
+	w.wroteHeader = true
+}
+
+func (w *responseBuffer) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
+
+// grpcError converts the HTTP status of the errors returned by the tripperware to a gRPC status.
+func grpcError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, err.Error())
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	code := codes.Internal
+	switch resp.Code {
+	case transport.StatusClientClosedRequest:
+		code = codes.Canceled
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, string(resp.Body))
+}
+
+func sampleStreamToTimeSeries(stream queryrange.SampleStream) (*prompb.TimeSeries, error) {
+	if len(stream.Histograms) > 0 {
+		return nil, errHistogramsNotSupported
+	}
+	samples := make([]prompb.Sample, 0, len(stream.Samples))
+	for _, sample := range stream.Samples {
+		samples = append(samples, prompb.Sample{Value: sample.Value, Timestamp: sample.TimestampMs})
+	}
+	return &prompb.TimeSeries{
+		Labels:  labelpb.ZLabelsFromPromLabels(cortexpb.FromLabelAdaptersToLabels(stream.Labels)),
+		Samples: samples,
+	}, nil
+}
+
+func grpcQueryStats(stats *queryrange.PrometheusResponseStats) *querypb.QueryStats {
+	if stats == nil || stats.Samples == nil {
+		return &querypb.QueryStats{}
+	}
+	return &querypb.QueryStats{
+		SamplesTotal: stats.Samples.TotalQueryableSamples,
+		PeakSamples:  int64(stats.Samples.PeakSamples),
+	}
+}
+
+// grpcEngineParam returns the engine HTTP parameter of the engine type, empty for the default engine of the queriers.
+func grpcEngineParam(engine querypb.EngineType) string {
+	if engine == querypb.EngineType_default {
+		return ""
+	}
+	return engine.String()
+}
@@ -0,0 +1,171 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/internal/cortex/frontend/transport"
+	"github.com/thanos-io/thanos/pkg/api/query/querypb"
+	"github.com/thanos-io/thanos/pkg/audit"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+func TestQueryServer(t *testing.T) {
+	var (
+		calls   atomic.Int64
+		tenants = make(chan string, 10)
+	)
+	rt, err := newFakeRoundTripper()
+	testutil.Ok(t, err)
+	defer rt.Close()
+	rt.setHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Inc()
+		tenants <- r.Header.Get(tenancy.DefaultTenantHeader)
+
+		switch r.URL.Path {
+		case "/api/v1/query_range":
+			if r.FormValue("query") == "invalid" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"invalid query"}`)
+				return
+			}
+			// Every split returns a sample at its start.
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up"},"values":[[%s,"1"]]}]}}`, r.FormValue("start"))
+		case "/api/v1/query":
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up"},"value":[%s,"1"]}]},"warnings":["warning"]}`, r.FormValue("time"))
+		}
+	}))
+
+	tpw, err := NewTripperware(Config{
+		CortexHandlerConfig: &transport.HandlerConfig{},
+		QueryRangeConfig: QueryRangeConfig{
+			Limits:                 defaultLimits,
+			SplitQueriesByInterval: time.Hour,
+		},
+		LabelsConfig: LabelsConfig{
+			Limits: defaultLimits,
+		},
+		ForwardHeaders: []string{tenancy.DefaultTenantHeader},
+	}, nil, log.NewNopLogger())
+	testutil.Ok(t, err)
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditConf := audit.DefaultConfig()
+	auditConf.File = &audit.FileConfig{Path: auditPath}
+	auditLog, err := audit.NewLog(log.NewNopLogger(), nil, "query-frontend", auditConf, tenancy.DefaultTenantHeader, tenancy.DefaultTenant, "")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, auditLog.Close()) }()
+
+	handler := transport.NewHandler(transport.HandlerConfig{MaxBodySize: 10 * 1024 * 1024, QueryStatsEnabled: true}, tpw(rt), log.NewNopLogger(), nil)
+	server := NewQueryServer(auditLog.HTTPMiddleware("query-frontend", handler), Config{})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenancy.DefaultTenantHeader, "team-a"))
+
+	t.Run("range query is split", func(t *testing.T) {
+		calls.Store(0)
+		srv := &queryRangeServer{ctx: ctx}
+		testutil.Ok(t, server.QueryRange(&querypb.QueryRangeRequest{
+			Query:            "up",
+			StartTimeSeconds: 0,
+			EndTimeSeconds:   7200,
+			IntervalSeconds:  60,
+		}, srv))
+
+		testutil.Equals(t, int64(2), calls.Load())
+		testutil.Equals(t, "team-a", <-tenants)
+		testutil.Equals(t, "team-a", <-tenants)
+		testutil.Equals(t, []*querypb.QueryRangeResponse{
+			querypb.NewQueryRangeResponse(&prompb.TimeSeries{
+				Labels:  labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up")),
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 0}, {Value: 1, Timestamp: 3600000}},
+			}),
+			querypb.NewQueryRangeStatsResponse(&querypb.QueryStats{}),
+		}, srv.resps)
+	})
+
+	t.Run("instant query", func(t *testing.T) {
+		calls.Store(0)
+		srv := &queryServer{ctx: ctx}
+		testutil.Ok(t, server.Query(&querypb.QueryRequest{Query: "up", TimeSeconds: 60}, srv))
+
+		testutil.Equals(t, int64(1), calls.Load())
+		testutil.Equals(t, "team-a", <-tenants)
+		testutil.Equals(t, []*querypb.QueryResponse{
+			{Result: &querypb.QueryResponse_Warnings{Warnings: "warning"}},
+			querypb.NewQueryResponse(&prompb.TimeSeries{
+				Labels:  labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up")),
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 60000}},
+			}),
+			querypb.NewQueryStatsResponse(&querypb.QueryStats{}),
+		}, srv.resps)
+	})
+
+	t.Run("queries are audited", func(t *testing.T) {
+		testutil.Ok(t, server.Query(&querypb.QueryRequest{Query: "up", TimeSeconds: 60}, &queryServer{ctx: ctx}))
+		<-tenants
+
+		b, err := os.ReadFile(auditPath)
+		testutil.Ok(t, err)
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		var rec audit.Record
+		testutil.Ok(t, json.Unmarshal([]byte(lines[len(lines)-1]), &rec))
+		testutil.Equals(t, "/api/v1/query", rec.Path)
+		testutil.Equals(t, "team-a", rec.Tenant)
+		testutil.Equals(t, "up", rec.Query)
+		testutil.Equals(t, "60", rec.QueryTime)
+		testutil.Equals(t, "success", rec.Status)
+	})
+
+	t.Run("errors are converted to gRPC status", func(t *testing.T) {
+		err := server.QueryRange(&querypb.QueryRangeRequest{EndTimeSeconds: 60, IntervalSeconds: 60}, &queryRangeServer{ctx: ctx})
+		testutil.Equals(t, codes.InvalidArgument, status.Code(err))
+
+		err = server.QueryRange(&querypb.QueryRangeRequest{Query: "invalid", EndTimeSeconds: 60, IntervalSeconds: 60}, &queryRangeServer{ctx: ctx})
+		testutil.Equals(t, codes.InvalidArgument, status.Code(err))
+		<-tenants
+	})
+}
+
+type queryServer struct {
+	querypb.Query_QueryServer
+	ctx   context.Context
+	resps []*querypb.QueryResponse
+}
+
+func (s *queryServer) Context() context.Context { return s.ctx }
+
+func (s *queryServer) Send(resp *querypb.QueryResponse) error {
+	s.resps = append(s.resps, resp)
+	return nil
+}
+
+type queryRangeServer struct {
+	querypb.Query_QueryRangeServer
+	ctx   context.Context
+	resps []*querypb.QueryRangeResponse
+}
+
+func (s *queryRangeServer) Context() context.Context { return s.ctx }
+
+func (s *queryRangeServer) Send(resp *querypb.QueryRangeResponse) error {
+	s.resps = append(s.resps, resp)
+	return nil
+}
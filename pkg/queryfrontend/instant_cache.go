@@ -0,0 +1,173 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/thanos-io/thanos/internal/cortex/chunk/cache"
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/internal/cortex/tenant"
+)
+
+// NewInstantQueryCacheMiddleware creates a new Middleware caching the responses of instant queries.
+// The evaluation time of the queries is aligned down to a multiple of the time bucket, so that the queries sent
+// within the same bucket, like the ones of dashboards showing the current value of a metric, share a cache entry.
+// Queries evaluated within the max cache freshness of now are not cached, as their result might still change, nor are
+// queries asking for statistics, which are specific to each evaluation. Queries which are not cached are evaluated at
+// their original time.
+func NewInstantQueryCacheMiddleware(
+	logger log.Logger,
+	cfg queryrange.ResultsCacheConfig,
+	splitter queryrange.CacheSplitter,
+	timeBucket time.Duration,
+	maxCacheFreshness time.Duration,
+	shouldCache queryrange.ShouldCacheFn,
+	reg prometheus.Registerer,
+) (queryrange.Middleware, error) {
+	c, err := cache.New(cfg.CacheConfig, reg, logger)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Compression == "snappy" {
+		c = cache.NewSnappy(c, logger)
+	}
+
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		return instantQueryCache{
+			logger:            logger,
+			next:              next,
+			cache:             c,
+			splitter:          splitter,
+			timeBucket:        timeBucket.Milliseconds(),
+			maxCacheFreshness: maxCacheFreshness,
+			shouldCache:       shouldCache,
+		}
+	}), nil
+}
+
+type instantQueryCache struct {
+	logger            log.Logger
+	next              queryrange.Handler
+	cache             cache.Cache
+	splitter          queryrange.CacheSplitter
+	timeBucket        int64
+	maxCacheFreshness time.Duration
+	shouldCache       queryrange.ShouldCacheFn
+}
+
+func (c instantQueryCache) Do(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+	req, ok := r.(*ThanosQueryInstantRequest)
+	if !ok || req.Stats != "" || (c.shouldCache != nil && !c.shouldCache(r)) {
+		return c.next.Do(ctx, r)
+	}
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "%s", err.Error())
+	}
+
+	// Queries without time are evaluated at the current time.
+	aligned := *req
+	if aligned.Time == 0 {
+		aligned.Time = int64(model.Now())
+	}
+	if c.timeBucket > 0 {
+		aligned.Time -= aligned.Time % c.timeBucket
+	}
+
+	maxCacheTime := int64(model.Now().Add(-c.maxCacheFreshness))
+	if aligned.Time > maxCacheTime ||
+		!queryrange.IsAtModifierCachable(c.logger, aligned.Query, aligned.Time, aligned.Time, maxCacheTime) ||
+		!queryrange.IsOffsetCachable(c.logger, aligned.Query) {
+		return c.next.Do(ctx, req)
+	}
+
+	key := c.splitter.GenerateCacheKey(tenant.JoinTenantIDs(tenantIDs), &aligned)
+	if resp, ok := c.get(ctx, key); ok {
+		return resp, nil
+	}
+
+	resp, err := c.next.Do(ctx, &aligned)
+	if err != nil {
+		return nil, err
+	}
+	if promResp, ok := resp.(*queryrange.PrometheusInstantQueryResponse); ok && c.shouldCacheResponse(promResp) {
+		c.put(ctx, key, aligned.Time, promResp)
+	}
+	return resp, nil
+}
+
+// shouldCacheResponse returns false for failed responses, and the ones the querier marked as not cachable, for
+// instance because of warnings or partial responses.
+func (c instantQueryCache) shouldCacheResponse(resp *queryrange.PrometheusInstantQueryResponse) bool {
+	if resp.Status != queryrange.StatusSuccess {
+		return false
+	}
+	for _, h := range resp.Headers {
+		if h.Name != "Cache-Control" {
+			continue
+		}
+		for _, v := range h.Values {
+			if v == "no-store" {
+				level.Debug(c.logger).Log("msg", "Cache-Control header in response is equal to no-store, not caching the response")
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c instantQueryCache) get(ctx context.Context, key string) (queryrange.Response, bool) {
+	found, bufs, _ := c.cache.Fetch(ctx, []string{cache.HashKey(key)})
+	if len(found) != 1 {
+		return nil, false
+	}
+
+	var cached queryrange.CachedResponse
+	if err := proto.Unmarshal(bufs[0], &cached); err != nil {
+		level.Error(c.logger).Log("msg", "error unmarshalling cached value", "err", err)
+		return nil, false
+	}
+	if cached.Key != key || len(cached.Extents) != 1 || cached.Extents[0].Response == nil {
+		return nil, false
+	}
+
+	var resp queryrange.PrometheusInstantQueryResponse
+	if err := types.UnmarshalAny(cached.Extents[0].Response, &resp); err != nil {
+		level.Error(c.logger).Log("msg", "error unmarshalling cached response", "err", err)
+		return nil, false
+	}
+	return &resp, true
+}
+
+func (c instantQueryCache) put(ctx context.Context, key string, t int64, resp *queryrange.PrometheusInstantQueryResponse) {
+	// Headers are not needed to send back the response.
+	stripped := *resp
+	stripped.Headers = nil
+
+	any, err := types.MarshalAny(&stripped)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "error marshalling response", "err", err)
+		return
+	}
+	buf, err := proto.Marshal(&queryrange.CachedResponse{
+		Key:     key,
+		Extents: []queryrange.Extent{{Start: t, End: t, Response: any}},
+	})
+	if err != nil {
+		level.Error(c.logger).Log("msg", "error marshalling cached value", "err", err)
+		return
+	}
+	c.cache.Store(ctx, []string{cache.HashKey(key)}, [][]byte{buf})
+}
@@ -28,6 +28,9 @@ import (
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 )
 
+// limitParam is the parameter limiting the number of returned label names, label values or series.
+const limitParam = "limit"
+
 var (
 	infMinTime = time.Unix(math.MinInt64/1000+62135596801, 0)
 	infMaxTime = time.Unix(math.MaxInt64/1000-62135596801, 999999999)
@@ -49,7 +52,9 @@ func NewThanosLabelsCodec(partialResponse bool, defaultMetadataTimeRange time.Du
 }
 
 // MergeResponse 将同一类型的多个响应合并为一个, 其核心功能就是去重 + 结果升序排序.
-func (c labelsCodec) MergeResponse(_ queryrange.Request, responses ...queryrange.Response) (queryrange.Response, error) {
+// The merged results are truncated to the limit of the request, as each response is limited on its own.
+func (c labelsCodec) MergeResponse(r queryrange.Request, responses ...queryrange.Response) (queryrange.Response, error) {
+	limit := requestLimit(r)
 	if len(responses) == 0 {
 		return &ThanosLabelsResponse{
 			Status: queryrange.StatusSuccess,
@@ -80,6 +85,9 @@ func (c labelsCodec) MergeResponse(_ queryrange.Request, responses ...queryrange
 		}
 
 		sort.Strings(lbls)
+		if limit > 0 && len(lbls) > limit {
+			lbls = lbls[:limit]
+		}
 		return &ThanosLabelsResponse{
 			Status: queryrange.StatusSuccess,
 			Data:   lbls,
@@ -100,6 +108,9 @@ func (c labelsCodec) MergeResponse(_ queryrange.Request, responses ...queryrange
 		}
 
 		sort.Sort(seriesData)
+		if limit > 0 && len(seriesData) > limit {
+			seriesData = seriesData[:limit]
+		}
 		return &ThanosSeriesResponse{
 			Status: queryrange.StatusSuccess,
 			Data:   seriesData,
@@ -109,6 +120,17 @@ func (c labelsCodec) MergeResponse(_ queryrange.Request, responses ...queryrange
 	}
 }
 
+// requestLimit returns the limit of the labels or series request, 0 meaning no limit.
+func requestLimit(r queryrange.Request) int {
+	switch r := r.(type) {
+	case *ThanosLabelsRequest:
+		return r.Limit
+	case *ThanosSeriesRequest:
+		return r.Limit
+	}
+	return 0
+}
+
 // DecodeRequest 将 http.Request(r) 解码为 ThanosLabelsRequest 或 ThanosSeriesRequest.
 func (c labelsCodec) DecodeRequest(_ context.Context, r *http.Request, forwardHeaders []string) (queryrange.Request, error) {
 	// func (r *Request) ParseForm() error
@@ -156,6 +178,16 @@ func (c labelsCodec) EncodeRequest(ctx context.Context, r queryrange.Request) (*
 		if len(thanosReq.StoreMatchers) > 0 {
 			params[queryv1.StoreMatcherParam] = matchersToStringSlice(thanosReq.StoreMatchers)
 		}
+		if thanosReq.ShardInfo != nil {
+			data, err := encodeShardInfo(thanosReq.ShardInfo)
+			if err != nil {
+				return nil, err
+			}
+			params[queryv1.ShardInfoParam] = []string{data}
+		}
+		if thanosReq.Limit > 0 {
+			params[limitParam] = []string{strconv.Itoa(thanosReq.Limit)}
+		}
 
 		if strings.Contains(thanosReq.Path, "/api/v1/label/") {
 			u := &url.URL{
@@ -201,6 +233,19 @@ func (c labelsCodec) EncodeRequest(ctx context.Context, r queryrange.Request) (*
 		if len(thanosReq.StoreMatchers) > 0 {
 			params[queryv1.StoreMatcherParam] = matchersToStringSlice(thanosReq.StoreMatchers)
 		}
+		if thanosReq.ShardInfo != nil {
+			data, err := encodeShardInfo(thanosReq.ShardInfo)
+			if err != nil {
+				return nil, err
+			}
+			params[queryv1.ShardInfoParam] = []string{data}
+		}
+		if thanosReq.Limit > 0 {
+			params[limitParam] = []string{strconv.Itoa(thanosReq.Limit)}
+		}
+		if thanosReq.Stream {
+			params[queryv1.StreamParam] = []string{"true"}
+		}
 
 		req, err = http.NewRequest(http.MethodPost, thanosReq.Path, bytes.NewBufferString(params.Encode()))
 		if err != nil {
@@ -330,6 +375,11 @@ func (c labelsCodec) parseLabelsRequest(r *http.Request, op string, forwardHeade
 		return nil, err
 	}
 
+	result.Limit, err = parseLimitParam(r.FormValue(limitParam))
+	if err != nil {
+		return nil, err
+	}
+
 	result.Path = r.URL.Path
 
 	if op == labelValuesOp {
@@ -395,6 +445,16 @@ func (c labelsCodec) parseSeriesRequest(r *http.Request, forwardHeaders []string
 		return nil, err
 	}
 
+	result.Limit, err = parseLimitParam(r.FormValue(limitParam))
+	if err != nil {
+		return nil, err
+	}
+
+	result.Stream, err = parseStreamParam(r.FormValue(queryv1.StreamParam))
+	if err != nil {
+		return nil, err
+	}
+
 	result.Path = r.URL.Path
 
 	for _, value := range r.Header.Values(cacheControlHeader) {
@@ -445,6 +505,18 @@ func parseMetadataTimeRange(r *http.Request, defaultMetadataTimeRange time.Durat
 	return start, end, nil
 }
 
+// parseLimitParam parses the limit parameter, where 0 means no limit.
+func parseLimitParam(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 0 {
+		return 0, httpgrpc.Errorf(http.StatusBadRequest, errCannotParse, limitParam)
+	}
+	return limit, nil
+}
+
 // parseTimeParam 从请求中提取时间(paramName)参数值, 并以毫秒数值返回. 若无该参数则返回默认时间(defaultValue)毫秒值.
 func parseTimeParam(r *http.Request, paramName string, defaultValue time.Time) (int64, error) {
 	val := r.FormValue(paramName)
@@ -188,6 +188,26 @@ func TestLabelsCodec_DecodeRequest(t *testing.T) {
 				StoreMatchers: [][]*labels.Matcher{},
 			},
 		},
+		{
+			name:            "series cannot parse stream",
+			url:             "/api/v1/series?start=123&end=456&stream=foo",
+			partialResponse: false,
+			expectedError:   httpgrpc.Errorf(http.StatusBadRequest, errCannotParse, queryv1.StreamParam),
+		},
+		{
+			name:            "series streamed",
+			url:             "/api/v1/series?start=123&end=456&stream=true",
+			partialResponse: false,
+			expectedRequest: &ThanosSeriesRequest{
+				Path:          "/api/v1/series",
+				Start:         123000,
+				End:           456000,
+				Dedup:         true,
+				Matchers:      [][]*labels.Matcher{},
+				StoreMatchers: [][]*labels.Matcher{},
+				Stream:        true,
+			},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			r, err := http.NewRequest(http.MethodGet, tc.url, nil)
@@ -257,6 +277,13 @@ func TestLabelsCodec_EncodeRequest(t *testing.T) {
 					r.URL.Query().Get(queryv1.PartialResponseParam) == trueStr
 			},
 		},
+		{
+			name: "thanos labels values request with limit",
+			req:  &ThanosLabelsRequest{Start: 123000, End: 456000, Path: "/api/v1/label/__name__/values", Label: "__name__", Limit: 10},
+			checkFunc: func(r *http.Request) bool {
+				return r.URL.Query().Get("limit") == "10"
+			},
+		},
 		{
 			name: "thanos series request with empty matchers",
 			req:  &ThanosSeriesRequest{Start: 123000, End: 456000, Path: "/api/v1/series"},
@@ -296,6 +323,19 @@ func TestLabelsCodec_EncodeRequest(t *testing.T) {
 					r.URL.Path == "/api/v1/series"
 			},
 		},
+		{
+			name: "thanos series request, streamed",
+			req: &ThanosSeriesRequest{
+				Start:  123000,
+				End:    456000,
+				Path:   "/api/v1/series",
+				Stream: true,
+			},
+			checkFunc: func(r *http.Request) bool {
+				return r.FormValue(queryv1.StreamParam) == trueStr &&
+					r.URL.Path == "/api/v1/series"
+			},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			// Default partial response value doesn't matter when encoding requests.
@@ -0,0 +1,149 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-io/thanos/pkg/extpromql"
+)
+
+// costSmoothing is the weight of a new observation in the learned cost of a query, the older ones decaying
+// exponentially.
+const costSmoothing = 0.3
+
+// QueryCosts keeps in memory the cost of the range queries learned from their splits, per tenant and query
+// fingerprint. The costs are forgotten when the query is not run for the TTL.
+type QueryCosts struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mtx    sync.Mutex
+	costs  map[queryFingerprint]*queryCost
+	nextGC time.Time
+
+	samplesPerStep prometheus.Histogram
+}
+
+// queryFingerprint identifies the queries of a tenant having the same canonical form.
+type queryFingerprint struct {
+	tenant string
+	query  string
+}
+
+// queryCost is the cost of a query per evaluation step, so that it applies to any range and step.
+type queryCost struct {
+	// SamplesPerStep is the number of queryable samples per step, 0 until the queriers return the query stats.
+	SamplesPerStep float64
+	// SamplesUpdatedAt is the last time the samples were learned, the stats being only requested from the queriers
+	// when they are not learned or older than the TTL.
+	SamplesUpdatedAt time.Time
+	// SecondsPerStep is the duration of the splits per step.
+	SecondsPerStep float64
+	// Series is the number of series returned by the splits.
+	Series       float64
+	Observations int
+	UpdatedAt    time.Time
+}
+
+// NewQueryCosts returns QueryCosts forgetting the cost of a query when it is not run for the ttl.
+func NewQueryCosts(ttl time.Duration, reg prometheus.Registerer) *QueryCosts {
+	c := &QueryCosts{
+		ttl:   ttl,
+		now:   time.Now,
+		costs: map[queryFingerprint]*queryCost{},
+		// The learned costs are only exposed in aggregate, the queries of the tenants are not.
+		samplesPerStep: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Namespace: "thanos",
+			Name:      "frontend_query_costs_samples_per_step",
+			Help:      "Queryable samples per step of the splits the cost of the queries is learned from.",
+			Buckets:   prometheus.ExponentialBuckets(1, 10, 8),
+		}),
+	}
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "thanos",
+		Name:      "frontend_query_costs_fingerprints",
+		Help:      "Number of query fingerprints whose cost is learned.",
+	}, func() float64 {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		return float64(len(c.costs))
+	})
+	return c
+}
+
+// newQueryFingerprint returns the fingerprint of the query, which is the canonical form of the query if it parses.
+func newQueryFingerprint(tenant, query string) queryFingerprint {
+	if expr, err := extpromql.ParseExpr(query); err == nil {
+		query = canonicalExpr(expr).String()
+	}
+	return queryFingerprint{tenant: tenant, query: query}
+}
+
+// get returns the learned cost of the query, if it is not expired.
+func (c *QueryCosts) get(fp queryFingerprint) (queryCost, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	cost, ok := c.costs[fp]
+	if !ok || c.now().Sub(cost.UpdatedAt) > c.ttl {
+		return queryCost{}, false
+	}
+	return *cost, true
+}
+
+// samplesStale returns true if the samples of the query are not learned or were learned more than the TTL ago.
+func (c *QueryCosts) samplesStale(fp queryFingerprint) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	cost, ok := c.costs[fp]
+	return !ok || c.now().Sub(cost.SamplesUpdatedAt) > c.ttl
+}
+
+// observe learns the cost of a split of the query evaluated at the given number of steps. The samples are negative
+// when the queriers did not return them.
+func (c *QueryCosts) observe(fp queryFingerprint, steps int64, samples int64, series int, duration time.Duration) {
+	if steps <= 0 {
+		return
+	}
+	now := c.now()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if now.After(c.nextGC) {
+		for key, cost := range c.costs {
+			if now.Sub(cost.UpdatedAt) > c.ttl {
+				delete(c.costs, key)
+			}
+		}
+		c.nextGC = now.Add(c.ttl)
+	}
+
+	cost, ok := c.costs[fp]
+	if !ok || now.Sub(cost.UpdatedAt) > c.ttl {
+		cost = &queryCost{}
+		c.costs[fp] = cost
+	}
+	smooth := func(learned, observed float64) float64 {
+		if cost.Observations == 0 || learned == 0 {
+			return observed
+		}
+		return learned*(1-costSmoothing) + observed*costSmoothing
+	}
+	if samples >= 0 {
+		cost.SamplesPerStep = smooth(cost.SamplesPerStep, float64(samples)/float64(steps))
+		c.samplesPerStep.Observe(float64(samples) / float64(steps))
+		cost.SamplesUpdatedAt = now
+	}
+	cost.SecondsPerStep = smooth(cost.SecondsPerStep, duration.Seconds()/float64(steps))
+	cost.Series = smooth(cost.Series, float64(series))
+	cost.Observations++
+	cost.UpdatedAt = now
+}
@@ -0,0 +1,547 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/weaveworks/common/httpgrpc"
+	"gopkg.in/yaml.v2"
+
+	cortexfrontend "github.com/thanos-io/thanos/internal/cortex/frontend"
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	cortexutil "github.com/thanos-io/thanos/internal/cortex/util"
+	queryv1 "github.com/thanos-io/thanos/pkg/api/query"
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extpromql"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+const (
+	// QueryRuleActionReject rejects the matching requests with a 4xx status code.
+	QueryRuleActionReject = "reject"
+	// QueryRuleActionRoute sends the matching requests to another downstream URL.
+	QueryRuleActionRoute = "route"
+	// QueryRuleActionMinResolution raises the max_source_resolution of the matching queries to a minimum.
+	QueryRuleActionMinResolution = "min_resolution"
+)
+
+// QueryRulesConfig is the configuration of the rules blocking or rewriting requests of the query-frontend.
+type QueryRulesConfig struct {
+	// Rules are evaluated in order, the first rule matching a request applies.
+	Rules []QueryRuleConfig `yaml:"rules"`
+}
+
+// QueryRuleConfig matches the requests meeting all its conditions, and applies its action to them.
+type QueryRuleConfig struct {
+	Name string `yaml:"name"`
+
+	// Tenants the rule applies to. The rule applies to all tenants if empty.
+	Tenants []string `yaml:"tenants"`
+	// QueryRegex matches the queries, or the series selectors of labels and series requests, containing a match
+	// of the regular expression.
+	QueryRegex string `yaml:"query_regex"`
+	// QueryPattern is a PromQL expression matching the queries containing an expression of the same structure.
+	// Selectors of the pattern match the selectors having at least their matchers.
+	QueryPattern string `yaml:"query_pattern"`
+	// MinRange and MaxRange bound the time range of the requests. The time range of instant queries is the
+	// longest range of their range selectors and subqueries.
+	MinRange model.Duration `yaml:"min_range"`
+	MaxRange model.Duration `yaml:"max_range"`
+
+	Action string `yaml:"action"`
+	// StatusCode is the status code of rejected requests, 422 by default.
+	StatusCode int `yaml:"status_code"`
+	// Message is added to the error of rejected requests.
+	Message string `yaml:"message"`
+	// DownstreamURL is the URL routed requests are sent to.
+	DownstreamURL string `yaml:"downstream_url"`
+	// MaxSourceResolution is the minimum max_source_resolution of the queries.
+	MaxSourceResolution model.Duration `yaml:"max_source_resolution"`
+}
+
+type queryRule struct {
+	QueryRuleConfig
+
+	tenants       map[string]struct{}
+	regex         *regexp.Regexp
+	pattern       parser.Expr
+	downstreamURL *url.URL
+}
+
+// ParseQueryRulesConfig parses the query rules configuration.
+func ParseQueryRulesConfig(content []byte) (*QueryRulesConfig, error) {
+	var conf QueryRulesConfig
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing query rules config YAML file")
+	}
+	if _, err := conf.rules(); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+func (c *QueryRulesConfig) rules() ([]*queryRule, error) {
+	var (
+		rules = make([]*queryRule, 0, len(c.Rules))
+		names = map[string]struct{}{}
+	)
+	for i, conf := range c.Rules {
+		if conf.Name == "" {
+			return nil, errors.Errorf("query rule %d: name is required", i)
+		}
+		if _, ok := names[conf.Name]; ok {
+			return nil, errors.Errorf("query rule %q: duplicated name", conf.Name)
+		}
+		names[conf.Name] = struct{}{}
+
+		r, err := newQueryRule(conf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "query rule %q", conf.Name)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func newQueryRule(conf QueryRuleConfig) (*queryRule, error) {
+	r := &queryRule{QueryRuleConfig: conf}
+	if len(conf.Tenants) > 0 {
+		r.tenants = make(map[string]struct{}, len(conf.Tenants))
+		for _, t := range conf.Tenants {
+			r.tenants[t] = struct{}{}
+		}
+	}
+	if conf.QueryRegex != "" {
+		var err error
+		if r.regex, err = regexp.Compile(conf.QueryRegex); err != nil {
+			return nil, errors.Wrap(err, "parse query regex")
+		}
+	}
+	if conf.QueryPattern != "" {
+		var err error
+		if r.pattern, err = extpromql.ParseExpr(conf.QueryPattern); err != nil {
+			return nil, errors.Wrap(err, "parse query pattern")
+		}
+	}
+	if conf.MaxRange > 0 && conf.MaxRange < conf.MinRange {
+		return nil, errors.New("max_range is lower than min_range")
+	}
+
+	switch conf.Action {
+	case QueryRuleActionReject:
+		if r.StatusCode == 0 {
+			r.StatusCode = http.StatusUnprocessableEntity
+		}
+		if r.StatusCode < 400 || r.StatusCode > 499 {
+			return nil, errors.Errorf("status code %d is not a 4xx status code", r.StatusCode)
+		}
+	case QueryRuleActionRoute:
+		u, err := url.Parse(conf.DownstreamURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse downstream URL")
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, errors.Errorf("downstream URL %q must have a scheme and a host", conf.DownstreamURL)
+		}
+		r.downstreamURL = u
+	case QueryRuleActionMinResolution:
+		if conf.MaxSourceResolution <= 0 {
+			return nil, errors.New("max_source_resolution must be positive")
+		}
+	default:
+		return nil, errors.Errorf("unknown action %q, must be one of %s, %s or %s", conf.Action, QueryRuleActionReject, QueryRuleActionRoute, QueryRuleActionMinResolution)
+	}
+	return r, nil
+}
+
+// ruleRequest is what query rules match requests on.
+type ruleRequest struct {
+	tenant string
+	// exprs are the query of query requests, or the series selectors of labels and series requests.
+	exprs []string
+	// rangeMillis is the length of the time range of the request.
+	rangeMillis int64
+}
+
+func (r *queryRule) matches(req ruleRequest) bool {
+	if r.tenants != nil {
+		if _, ok := r.tenants[req.tenant]; !ok {
+			return false
+		}
+	}
+	if req.rangeMillis < int64(r.MinRange)/int64(time.Millisecond) {
+		return false
+	}
+	if r.MaxRange > 0 && req.rangeMillis > int64(r.MaxRange)/int64(time.Millisecond) {
+		return false
+	}
+	if r.regex != nil && !anyExpr(req.exprs, func(s string) bool { return r.regex.MatchString(s) }) {
+		return false
+	}
+	if r.pattern != nil && !anyExpr(req.exprs, func(s string) bool {
+		expr, err := extpromql.ParseExpr(s)
+		// Invalid queries are left for the queriers to reject.
+		return err == nil && containsPattern(expr, r.pattern)
+	}) {
+		return false
+	}
+	return true
+}
+
+func anyExpr(exprs []string, f func(string) bool) bool {
+	for _, e := range exprs {
+		if f(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsPattern returns true if the expression or any of its subexpressions matches the pattern.
+func containsPattern(expr, pattern parser.Expr) bool {
+	found := false
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		if e, ok := node.(parser.Expr); ok && matchesPattern(e, pattern) {
+			found = true
+			return errors.New("found")
+		}
+		return nil
+	})
+	return found
+}
+
+// matchesPattern returns true if the expression has the structure of the pattern. Selectors of the pattern match
+// the selectors having at least their matchers.
+func matchesPattern(expr, pattern parser.Expr) bool {
+	expr, pattern = unwrapParens(expr), unwrapParens(pattern)
+	switch p := pattern.(type) {
+	case *parser.VectorSelector:
+		e, ok := expr.(*parser.VectorSelector)
+		if !ok {
+			return false
+		}
+		for _, pm := range p.LabelMatchers {
+			found := false
+			for _, em := range e.LabelMatchers {
+				if em.Type == pm.Type && em.Name == pm.Name && em.Value == pm.Value {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case *parser.MatrixSelector:
+		e, ok := expr.(*parser.MatrixSelector)
+		return ok && e.Range == p.Range && matchesPattern(e.VectorSelector, p.VectorSelector)
+	case *parser.SubqueryExpr:
+		e, ok := expr.(*parser.SubqueryExpr)
+		return ok && e.Range == p.Range && e.Step == p.Step && matchesPattern(e.Expr, p.Expr)
+	case *parser.Call:
+		e, ok := expr.(*parser.Call)
+		if !ok || e.Func.Name != p.Func.Name || len(e.Args) != len(p.Args) {
+			return false
+		}
+		for i := range p.Args {
+			if !matchesPattern(e.Args[i], p.Args[i]) {
+				return false
+			}
+		}
+		return true
+	case *parser.AggregateExpr:
+		e, ok := expr.(*parser.AggregateExpr)
+		if !ok || e.Op != p.Op || e.Without != p.Without || !sameLabels(e.Grouping, p.Grouping) {
+			return false
+		}
+		if (e.Param == nil) != (p.Param == nil) || (p.Param != nil && !matchesPattern(e.Param, p.Param)) {
+			return false
+		}
+		return matchesPattern(e.Expr, p.Expr)
+	case *parser.BinaryExpr:
+		e, ok := expr.(*parser.BinaryExpr)
+		return ok && e.Op == p.Op && matchesPattern(e.LHS, p.LHS) && matchesPattern(e.RHS, p.RHS)
+	case *parser.UnaryExpr:
+		e, ok := expr.(*parser.UnaryExpr)
+		return ok && e.Op == p.Op && matchesPattern(e.Expr, p.Expr)
+	case *parser.NumberLiteral:
+		e, ok := expr.(*parser.NumberLiteral)
+		return ok && e.Val == p.Val
+	case *parser.StringLiteral:
+		e, ok := expr.(*parser.StringLiteral)
+		return ok && e.Val == p.Val
+	default:
+		return false
+	}
+}
+
+func unwrapParens(expr parser.Expr) parser.Expr {
+	for {
+		p, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = p.Expr
+	}
+}
+
+func sameLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]struct{}, len(a))
+	for _, l := range a {
+		set[l] = struct{}{}
+	}
+	for _, l := range b {
+		if _, ok := set[l]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryRules blocks or rewrites the requests matching rules read from a file that can be reloaded at runtime.
+type QueryRules struct {
+	mtx   sync.RWMutex
+	rules []*queryRule
+	// routesDisabled rejects the rules with the route action on reload.
+	routesDisabled bool
+
+	configPathOrContent fileContent
+	configReloadTimer   time.Duration
+	logger              log.Logger
+
+	configReloadCounter       prometheus.Counter
+	configReloadFailedCounter prometheus.Counter
+	matchesCounter            *prometheus.CounterVec
+}
+
+// fileContent is an interface to avoid a direct dependency on kingpin or extkingpin.
+type fileContent interface {
+	Content() ([]byte, error)
+	Path() string
+}
+
+// NewQueryRules creates a new *QueryRules from the given rules file.
+func NewQueryRules(configFile fileContent, reg prometheus.Registerer, logger log.Logger, configReloadTimer time.Duration) (*QueryRules, error) {
+	q := &QueryRules{
+		configPathOrContent: configFile,
+		configReloadTimer:   configReloadTimer,
+		logger:              logger,
+		configReloadCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_frontend_query_rules_config_reload_total",
+			Help: "How many times the query rules configuration was reloaded.",
+		}),
+		configReloadFailedCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_frontend_query_rules_config_reload_err_total",
+			Help: "How many times the query rules configuration failed to reload.",
+		}),
+		matchesCounter: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_frontend_query_rule_matches_total",
+			Help: "Total number of requests matching a query rule.",
+		}, []string{"rule", "action"}),
+	}
+	if err := q.loadConfig(); err != nil {
+		return nil, errors.Wrap(err, "load query rules config")
+	}
+	return q, nil
+}
+
+// CanReload returns true if the rules are read from a file which can be watched for changes.
+func (q *QueryRules) CanReload() bool {
+	return q.configPathOrContent.Path() != ""
+}
+
+// StartConfigReloader starts watching the rules file and reloads the rules when it changes.
+func (q *QueryRules) StartConfigReloader(ctx context.Context) error {
+	if !q.CanReload() {
+		return nil
+	}
+
+	return extkingpin.PathContentReloader(ctx, q.configPathOrContent, q.logger, func() {
+		level.Info(q.logger).Log("msg", "reloading query rules config")
+		if err := q.loadConfig(); err != nil {
+			q.configReloadFailedCounter.Inc()
+			level.Error(q.logger).Log("msg", "error reloading query rules config", "path", q.configPathOrContent.Path(), "err", err)
+			return
+		}
+		q.configReloadCounter.Inc()
+	}, q.configReloadTimer)
+}
+
+func (q *QueryRules) loadConfig() error {
+	content, err := q.configPathOrContent.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of query rules configuration")
+	}
+	conf, err := ParseQueryRulesConfig(content)
+	if err != nil {
+		return err
+	}
+	rules, err := conf.rules()
+	if err != nil {
+		return err
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if q.routesDisabled {
+		for _, r := range rules {
+			if r.Action == QueryRuleActionRoute {
+				return errors.Errorf("query rule %q: route action is disabled", r.Name)
+			}
+		}
+	}
+	q.rules = rules
+	return nil
+}
+
+// hasRouteRules returns true if one of the rules has the route action.
+func (q *QueryRules) hasRouteRules() bool {
+	q.mtx.RLock()
+	defer q.mtx.RUnlock()
+
+	for _, r := range q.rules {
+		if r.Action == QueryRuleActionRoute {
+			return true
+		}
+	}
+	return false
+}
+
+// disableRoutes rejects the rules with the route action on further reloads, when requests are not sent downstream.
+func (q *QueryRules) disableRoutes() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.routesDisabled = true
+}
+
+// match returns the first rule matching the request, or nil.
+func (q *QueryRules) match(req ruleRequest) *queryRule {
+	q.mtx.RLock()
+	defer q.mtx.RUnlock()
+
+	for _, r := range q.rules {
+		if r.matches(req) {
+			q.matchesCounter.WithLabelValues(r.Name, r.Action).Inc()
+			return r
+		}
+	}
+	return nil
+}
+
+// newQueryRulesRoundTripper returns a http.RoundTripper applying the query rules to query, query_range, labels and
+// series requests. Routed requests are sent to the downstream URL of the rule by the downstream round tripper.
+func newQueryRulesRoundTripper(rules *QueryRules, defaultMetadataTimeRange time.Duration, next http.RoundTripper) http.RoundTripper {
+	return queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		op := getOperation(r)
+		if op == "" {
+			return next.RoundTrip(r)
+		}
+		if err := r.ParseForm(); err != nil {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, "error parsing request form: %s", err.Error())
+		}
+		form := r.Form
+
+		req := ruleRequest{tenant: r.Header.Get(tenancy.DefaultTenantHeader)}
+		switch op {
+		case instantQueryOp:
+			req.exprs = []string{form.Get("query")}
+			if expr, err := extpromql.ParseExpr(form.Get("query")); err == nil {
+				req.rangeMillis = longestRangeMillis(expr)
+			}
+		case rangeQueryOp:
+			req.exprs = []string{form.Get("query")}
+			start, errStart := cortexutil.ParseTime(form.Get("start"))
+			end, errEnd := cortexutil.ParseTime(form.Get("end"))
+			if errStart == nil && errEnd == nil {
+				req.rangeMillis = end - start
+			}
+		default:
+			req.exprs = form["match[]"]
+			if start, end, err := parseMetadataTimeRange(r, defaultMetadataTimeRange); err == nil {
+				req.rangeMillis = end - start
+				// Requests without time range cover all times.
+				if req.rangeMillis < 0 {
+					req.rangeMillis = math.MaxInt64
+				}
+			}
+		}
+
+		rule := rules.match(req)
+		if rule == nil {
+			return next.RoundTrip(withForm(r, form))
+		}
+		switch rule.Action {
+		case QueryRuleActionReject:
+			msg := "query blocked by rule " + strconv.Quote(rule.Name)
+			if rule.Message != "" {
+				msg += ": " + rule.Message
+			}
+			return nil, httpgrpc.Errorf(rule.StatusCode, "%s", msg)
+		case QueryRuleActionRoute:
+			r = withForm(r, form)
+			return next.RoundTrip(r.WithContext(cortexfrontend.WithDownstreamURL(r.Context(), rule.downstreamURL)))
+		case QueryRuleActionMinResolution:
+			if op == instantQueryOp || op == rangeQueryOp {
+				raiseMaxSourceResolution(form, int64(rule.MaxSourceResolution)/int64(time.Millisecond))
+			}
+		}
+		return next.RoundTrip(withForm(r, form))
+	})
+}
+
+// longestRangeMillis returns the longest range of the range selectors and subqueries of the expression.
+func longestRangeMillis(expr parser.Expr) int64 {
+	var longest time.Duration
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.MatrixSelector:
+			longest = max(longest, n.Range)
+		case *parser.SubqueryExpr:
+			longest = max(longest, n.Range)
+		}
+		return nil
+	})
+	return longest.Milliseconds()
+}
+
+// raiseMaxSourceResolution sets the max_source_resolution of the query to the given minimum if lower. Auto
+// downsampling of range queries uses a fifth of the step.
+func raiseMaxSourceResolution(form url.Values, minMillis int64) {
+	var current int64
+	switch v := form.Get(queryv1.MaxSourceResolutionParam); v {
+	case "auto":
+		if step, err := parseDurationMillis(form.Get("step")); err == nil {
+			current = step / 5
+		}
+	case "":
+	default:
+		var err error
+		if current, err = parseDurationMillis(v); err != nil {
+			// Invalid values are left for the codecs to reject.
+			return
+		}
+	}
+	if current < minMillis {
+		form.Set(queryv1.MaxSourceResolutionParam, encodeDurationMillis(minMillis))
+	}
+}
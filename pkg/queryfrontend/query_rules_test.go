@@ -0,0 +1,204 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/weaveworks/common/httpgrpc"
+
+	cortexfrontend "github.com/thanos-io/thanos/internal/cortex/frontend"
+	"github.com/thanos-io/thanos/pkg/extpromql"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+type staticQueryRules string
+
+func (c staticQueryRules) Content() ([]byte, error) { return []byte(c), nil }
+func (c staticQueryRules) Path() string             { return "" }
+
+func TestParseQueryRulesConfig(t *testing.T) {
+	for _, tcase := range []struct {
+		name   string
+		conf   string
+		expErr bool
+	}{
+		{name: "valid", conf: `
+rules:
+- {name: a, query_regex: 'foo.*', action: reject}
+- {name: b, query_pattern: 'rate(foo[1d])', action: route, downstream_url: 'http://heavy:9090'}
+- {name: c, min_range: 30d, action: min_resolution, max_source_resolution: 1h}
+`},
+		{name: "missing name", conf: `rules: [{action: reject}]`, expErr: true},
+		{name: "duplicated name", conf: `rules: [{name: a, action: reject}, {name: a, action: reject}]`, expErr: true},
+		{name: "unknown action", conf: `rules: [{name: a, action: drop}]`, expErr: true},
+		{name: "invalid regex", conf: `rules: [{name: a, query_regex: '(', action: reject}]`, expErr: true},
+		{name: "invalid pattern", conf: `rules: [{name: a, query_pattern: 'rate(', action: reject}]`, expErr: true},
+		{name: "not 4xx", conf: `rules: [{name: a, action: reject, status_code: 500}]`, expErr: true},
+		{name: "route without URL", conf: `rules: [{name: a, action: route}]`, expErr: true},
+		{name: "min resolution without resolution", conf: `rules: [{name: a, action: min_resolution}]`, expErr: true},
+		{name: "inverted range", conf: `rules: [{name: a, min_range: 2d, max_range: 1d, action: reject}]`, expErr: true},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			_, err := ParseQueryRulesConfig([]byte(tcase.conf))
+			if tcase.expErr {
+				testutil.NotOk(t, err)
+				return
+			}
+			testutil.Ok(t, err)
+		})
+	}
+}
+
+type mutableQueryRules struct{ content string }
+
+func (c *mutableQueryRules) Content() ([]byte, error) { return []byte(c.content), nil }
+func (c *mutableQueryRules) Path() string             { return "" }
+
+func TestQueryRules_DisableRoutes(t *testing.T) {
+	conf := &mutableQueryRules{content: `rules: [{name: a, action: reject}]`}
+	rules, err := NewQueryRules(conf, nil, log.NewNopLogger(), 0)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !rules.hasRouteRules())
+
+	rules.disableRoutes()
+	conf.content = `rules: [{name: b, action: route, downstream_url: 'http://heavy:9090'}]`
+	testutil.NotOk(t, rules.loadConfig())
+	testutil.Assert(t, !rules.hasRouteRules())
+	testutil.Equals(t, "a", rules.rules[0].Name)
+}
+
+func TestContainsPattern(t *testing.T) {
+	for _, tcase := range []struct {
+		query, pattern string
+		exp            bool
+	}{
+		{query: `rate(http_requests_total{job="api"}[1d])`, pattern: `rate(http_requests_total[1d])`, exp: true},
+		{query: `sum by (pod) (rate(http_requests_total[1d])) > 1`, pattern: `rate(http_requests_total[1d])`, exp: true},
+		{query: `rate(http_requests_total[5m])`, pattern: `rate(http_requests_total[1d])`},
+		{query: `irate(http_requests_total[1d])`, pattern: `rate(http_requests_total[1d])`},
+		{query: `rate(http_requests_total[1d])`, pattern: `rate(http_requests_total{job="api"}[1d])`},
+		{query: `sum by (pod, ns) (up)`, pattern: `sum by (ns, pod) (up)`, exp: true},
+		{query: `sum by (pod) (up)`, pattern: `sum(up)`},
+		{query: `((up)) * 2`, pattern: `up * 2`, exp: true},
+		{query: `max_over_time(up[30d:1m])`, pattern: `up[30d:1m]`, exp: true},
+		{query: `topk(10, up)`, pattern: `topk(5, up)`},
+	} {
+		t.Run(tcase.query+" "+tcase.pattern, func(t *testing.T) {
+			expr, err := extpromql.ParseExpr(tcase.query)
+			testutil.Ok(t, err)
+			pattern, err := extpromql.ParseExpr(tcase.pattern)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.exp, containsPattern(expr, pattern))
+		})
+	}
+}
+
+func TestQueryRulesRoundTripper(t *testing.T) {
+	var (
+		defaultPaths, heavyPaths []string
+		captured                 url.Values
+	)
+	newDownstream := func(paths *[]string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			testutil.Ok(t, r.ParseForm())
+			captured = r.Form
+			*paths = append(*paths, r.URL.Path)
+		}))
+	}
+	defaultSrv, heavySrv := newDownstream(&defaultPaths), newDownstream(&heavyPaths)
+	defer defaultSrv.Close()
+	defer heavySrv.Close()
+
+	rules, err := NewQueryRules(staticQueryRules(`
+rules:
+- name: runaway-dashboard
+  tenants: [team-a]
+  query_regex: 'dashboard_metric'
+  action: reject
+  status_code: 429
+  message: fix your dashboard
+- name: long-rates
+  query_pattern: 'rate(http_requests_total[1d])'
+  action: route
+  downstream_url: `+heavySrv.URL+`/heavy
+- name: long-ranges
+  min_range: 30d
+  action: min_resolution
+  max_source_resolution: 1h
+`), nil, log.NewNopLogger(), 0)
+	testutil.Ok(t, err)
+
+	downstream, err := cortexfrontend.NewDownstreamRoundTripper(defaultSrv.URL, http.DefaultTransport)
+	testutil.Ok(t, err)
+	tripper := newQueryRulesRoundTripper(rules, 0, downstream)
+
+	roundTrip := func(tenant, method, target string, form url.Values) error {
+		var r *http.Request
+		if method == http.MethodPost {
+			r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(method, target+"?"+form.Encode(), nil)
+		}
+		r.RequestURI = ""
+		r.Header.Set(tenancy.DefaultTenantHeader, tenant)
+		resp, err := tripper.RoundTrip(r)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.Body.Close()
+	}
+
+	// Rejected for the tenant of the rule only.
+	err = roundTrip("team-a", http.MethodGet, "/api/v1/query", url.Values{"query": {"sum(dashboard_metric)"}})
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	testutil.Assert(t, ok, "expected httpgrpc error, got %v", err)
+	testutil.Equals(t, int32(http.StatusTooManyRequests), resp.Code)
+	testutil.Equals(t, `query blocked by rule "runaway-dashboard": fix your dashboard`, string(resp.Body))
+	testutil.Ok(t, roundTrip("team-b", http.MethodGet, "/api/v1/query", url.Values{"query": {"sum(dashboard_metric)"}}))
+	testutil.Equals(t, []string{"/api/v1/query"}, defaultPaths)
+
+	// Routed to the other downstream, with the form of POST requests preserved.
+	testutil.Ok(t, roundTrip("team-a", http.MethodPost, "/api/v1/query", url.Values{"query": {`sum(rate(http_requests_total{job="api"}[1d]))`}}))
+	testutil.Equals(t, []string{"/heavy/api/v1/query"}, heavyPaths)
+	testutil.Equals(t, `sum(rate(http_requests_total{job="api"}[1d]))`, captured.Get("query"))
+
+	// Long range queries get a coarser resolution, unless they ask for a coarser one already.
+	end := time.Unix(0, 0).Add(40 * 24 * time.Hour)
+	form := url.Values{"query": {"up"}, "start": {"0"}, "end": {encodeTime(end.UnixMilli())}, "step": {"1h"}, "max_source_resolution": {"5m"}}
+	testutil.Ok(t, roundTrip("team-a", http.MethodGet, "/api/v1/query_range", form))
+	testutil.Equals(t, "3600", captured.Get("max_source_resolution"))
+
+	form.Set("max_source_resolution", "auto")
+	form.Set("step", "6h")
+	testutil.Ok(t, roundTrip("team-a", http.MethodGet, "/api/v1/query_range", form))
+	testutil.Equals(t, "auto", captured.Get("max_source_resolution"))
+
+	form.Set("end", "3600")
+	form.Set("max_source_resolution", "5m")
+	testutil.Ok(t, roundTrip("team-a", http.MethodGet, "/api/v1/query_range", form))
+	testutil.Equals(t, "5m", captured.Get("max_source_resolution"))
+
+	// The range of instant queries is the one of their range selectors.
+	testutil.Ok(t, roundTrip("team-a", http.MethodGet, "/api/v1/query", url.Values{"query": {"max_over_time(up[31d])"}}))
+	testutil.Equals(t, "3600", captured.Get("max_source_resolution"))
+
+	// Labels requests without time range cover all times, and their selectors are matched.
+	testutil.Ok(t, roundTrip("team-b", http.MethodGet, "/api/v1/labels", url.Values{"match[]": {"{__name__=\"up\"}"}}))
+	testutil.Equals(t, "", captured.Get("max_source_resolution"))
+	err = roundTrip("team-a", http.MethodGet, "/api/v1/series", url.Values{"match[]": {"dashboard_metric"}})
+	testutil.NotOk(t, err)
+
+	testutil.Equals(t, []string{"/api/v1/query", "/api/v1/query_range", "/api/v1/query_range", "/api/v1/query_range", "/api/v1/query", "/api/v1/labels"}, defaultPaths)
+}
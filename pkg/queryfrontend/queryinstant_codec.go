@@ -13,6 +13,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
@@ -25,7 +26,10 @@ import (
 	cortexutil "github.com/thanos-io/thanos/internal/cortex/util"
 	"github.com/thanos-io/thanos/internal/cortex/util/spanlogger"
 	queryv1 "github.com/thanos-io/thanos/pkg/api/query"
+	"github.com/thanos-io/thanos/pkg/api/query/querypb"
 	"github.com/thanos-io/thanos/pkg/extpromql"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
 )
 
 // queryInstantCodec Instant Query 请求响应编解码器, 用于  与 http.Request/http.Response 之间进行编解码.
@@ -177,6 +181,11 @@ func (c queryInstantCodec) DecodeRequest(_ context.Context, r *http.Request, for
 	result.Engine = r.FormValue("engine")
 	result.Stats = r.FormValue(queryv1.Stats)
 
+	result.Stream, err = parseStreamParam(r.FormValue(queryv1.StreamParam))
+	if err != nil {
+		return nil, err
+	}
+
 	for _, header := range forwardHeaders {
 		for h, hv := range r.Header {
 			if strings.EqualFold(h, header) {
@@ -232,6 +241,10 @@ func (c queryInstantCodec) EncodeRequest(ctx context.Context, r queryrange.Reque
 		params[queryv1.LookbackDeltaParam] = []string{encodeDurationMillis(thanosReq.LookbackDelta)}
 	}
 
+	if thanosReq.Stream {
+		params[queryv1.StreamParam] = []string{"true"}
+	}
+
 	req, err := http.NewRequest(http.MethodPost, thanosReq.Path, bytes.NewBufferString(params.Encode()))
 	if err != nil {
 		return nil, httpgrpc.Errorf(http.StatusBadRequest, "error creating request: %s", err.Error())
@@ -504,3 +517,70 @@ func matrixMerge(resps []*queryrange.PrometheusInstantQueryResponse) *queryrange
 
 	return result
 }
+
+// DecodeGRPCRequest 将 querypb.QueryRequest 转换为 ThanosQueryInstantRequest, 请求不支持 query plan.
+func (c queryInstantCodec) DecodeGRPCRequest(r *querypb.QueryRequest) (*ThanosQueryInstantRequest, error) {
+	if r.Query == "" {
+		return nil, errQueryRequired
+	}
+
+	storeMatchers, err := querypb.StoreMatchersToLabelMatchers(r.StoreMatchers)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "%s", err.Error())
+	}
+	return &ThanosQueryInstantRequest{
+		Path:                "/api/v1/query",
+		Time:                r.TimeSeconds * 1000,
+		Timeout:             time.Duration(r.TimeoutSeconds) * time.Second,
+		Query:               r.Query,
+		Dedup:               r.EnableDedup,
+		PartialResponse:     r.EnablePartialResponse,
+		MaxSourceResolution: r.MaxResolutionSeconds * 1000,
+		ReplicaLabels:       r.ReplicaLabels,
+		StoreMatchers:       storeMatchers,
+		ShardInfo:           r.ShardInfo,
+		LookbackDelta:       r.LookbackDeltaSeconds * 1000,
+		Engine:              grpcEngineParam(r.Engine),
+	}, nil
+}
+
+// EncodeGRPCResponse 将 PrometheusInstantQueryResponse 转换为 querypb.QueryResponse 流:
+// 先发送 warnings, 再逐条发送 series, 最后发送 stats, 与 Querier 的 gRPC API 一致. 字符串结果会被忽略.
+func (c queryInstantCodec) EncodeGRPCResponse(res queryrange.Response) ([]*querypb.QueryResponse, error) {
+	promRes, ok := res.(*queryrange.PrometheusInstantQueryResponse)
+	if !ok {
+		return nil, httpgrpc.Errorf(http.StatusInternalServerError, "invalid response format")
+	}
+
+	var resps []*querypb.QueryResponse
+	if len(promRes.Warnings) > 0 {
+		resps = append(resps, &querypb.QueryResponse{Result: &querypb.QueryResponse_Warnings{
+			Warnings: strings.Join(promRes.Warnings, ", "),
+		}})
+	}
+	switch result := promRes.Data.Result.Result.(type) {
+	case *queryrange.PrometheusInstantQueryResult_Vector:
+		for _, sample := range result.Vector.Samples {
+			if sample.Histogram != nil {
+				return nil, errHistogramsNotSupported
+			}
+			resps = append(resps, querypb.NewQueryResponse(&prompb.TimeSeries{
+				Labels:  labelpb.ZLabelsFromPromLabels(cortexpb.FromLabelAdaptersToLabels(sample.Labels)),
+				Samples: []prompb.Sample{{Value: sample.SampleValue, Timestamp: sample.Timestamp}},
+			}))
+		}
+	case *queryrange.PrometheusInstantQueryResult_Matrix:
+		for _, stream := range result.Matrix.SampleStreams {
+			series, err := sampleStreamToTimeSeries(*stream)
+			if err != nil {
+				return nil, err
+			}
+			resps = append(resps, querypb.NewQueryResponse(series))
+		}
+	case *queryrange.PrometheusInstantQueryResult_Scalar:
+		resps = append(resps, querypb.NewQueryResponse(&prompb.TimeSeries{
+			Samples: []prompb.Sample{{Value: result.Scalar.Value, Timestamp: result.Scalar.TimestampMs}},
+		}))
+	}
+	return append(resps, querypb.NewQueryStatsResponse(grpcQueryStats(promRes.Data.Stats))), nil
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
 	cortexutil "github.com/thanos-io/thanos/internal/cortex/util"
 	queryv1 "github.com/thanos-io/thanos/pkg/api/query"
+	"github.com/thanos-io/thanos/pkg/api/query/querypb"
 	"github.com/thanos-io/thanos/pkg/extpromql"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
@@ -37,6 +38,7 @@ var (
 	errEndBeforeStart = httpgrpc.Errorf(http.StatusBadRequest, "end timestamp must not be before start time")
 	errNegativeStep   = httpgrpc.Errorf(http.StatusBadRequest, "zero or negative query resolution step widths are not accepted. Try a positive integer")
 	errStepTooSmall   = httpgrpc.Errorf(http.StatusBadRequest, "exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)")
+	errQueryRequired  = httpgrpc.Errorf(http.StatusBadRequest, "query is required, query plans are not supported")
 	errCannotParse    = "cannot parse parameter %s"
 )
 
@@ -141,6 +143,11 @@ func (c queryRangeCodec) DecodeRequest(_ context.Context, r *http.Request, forwa
 	result.Path = r.URL.Path
 	result.Stats = r.FormValue(queryv1.Stats)
 
+	result.Stream, err = parseStreamParam(r.FormValue(queryv1.StreamParam))
+	if err != nil {
+		return nil, err
+	}
+
 	for _, value := range r.Header.Values(cacheControlHeader) {
 		if strings.Contains(value, noStoreValue) {
 			result.CachingOptions.Disabled = true
@@ -202,6 +209,10 @@ func (c queryRangeCodec) EncodeRequest(ctx context.Context, r queryrange.Request
 		params[queryv1.LookbackDeltaParam] = []string{encodeDurationMillis(thanosReq.LookbackDelta)}
 	}
 
+	if thanosReq.Stream {
+		params[queryv1.StreamParam] = []string{"true"}
+	}
+
 	req, err := http.NewRequest(http.MethodPost, thanosReq.Path, bytes.NewBufferString(params.Encode()))
 	if err != nil {
 		return nil, httpgrpc.Errorf(http.StatusBadRequest, "error creating request: %s", err.Error())
@@ -342,3 +353,66 @@ func encodeShardInfo(info *storepb.ShardInfo) (string, error) {
 
 	return string(data), nil
 }
+
+// DecodeGRPCRequest 将 querypb.QueryRangeRequest 转换为 ThanosQueryRangeRequest, 请求不支持 query plan.
+func (c queryRangeCodec) DecodeGRPCRequest(r *querypb.QueryRangeRequest) (*ThanosQueryRangeRequest, error) {
+	if r.Query == "" {
+		return nil, errQueryRequired
+	}
+
+	result := ThanosQueryRangeRequest{
+		Path:                "/api/v1/query_range",
+		Start:               r.StartTimeSeconds * 1000,
+		End:                 r.EndTimeSeconds * 1000,
+		Step:                r.IntervalSeconds * 1000,
+		Timeout:             time.Duration(r.TimeoutSeconds) * time.Second,
+		Query:               r.Query,
+		Dedup:               r.EnableDedup,
+		PartialResponse:     r.EnablePartialResponse,
+		MaxSourceResolution: r.MaxResolutionSeconds * 1000,
+		ReplicaLabels:       r.ReplicaLabels,
+		ShardInfo:           r.ShardInfo,
+		LookbackDelta:       r.LookbackDeltaSeconds * 1000,
+		Engine:              grpcEngineParam(r.Engine),
+	}
+	if result.End < result.Start {
+		return nil, errEndBeforeStart
+	}
+	if result.Step <= 0 {
+		return nil, errNegativeStep
+	}
+	if (result.End-result.Start)/result.Step > 11000 {
+		return nil, errStepTooSmall
+	}
+
+	var err error
+	result.StoreMatchers, err = querypb.StoreMatchersToLabelMatchers(r.StoreMatchers)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "%s", err.Error())
+	}
+	return &result, nil
+}
+
+// EncodeGRPCResponse 将 PrometheusResponse 转换为 querypb.QueryRangeResponse 流:
+// 先发送 warnings, 再逐条发送 series, 最后发送 stats, 与 Querier 的 gRPC API 一致.
+func (c queryRangeCodec) EncodeGRPCResponse(res queryrange.Response) ([]*querypb.QueryRangeResponse, error) {
+	promRes, ok := res.(*queryrange.PrometheusResponse)
+	if !ok {
+		return nil, httpgrpc.Errorf(http.StatusInternalServerError, "invalid response format")
+	}
+
+	resps := make([]*querypb.QueryRangeResponse, 0, len(promRes.Data.Result)+2)
+	if len(promRes.Warnings) > 0 {
+		resps = append(resps, &querypb.QueryRangeResponse{Result: &querypb.QueryRangeResponse_Warnings{
+			Warnings: strings.Join(promRes.Warnings, ", "),
+		}})
+	}
+	for _, stream := range promRes.Data.Result {
+		series, err := sampleStreamToTimeSeries(stream)
+		if err != nil {
+			return nil, err
+		}
+		resps = append(resps, querypb.NewQueryRangeResponse(series))
+	}
+	return append(resps, querypb.NewQueryRangeStatsResponse(grpcQueryStats(promRes.Data.Stats))), nil
+}
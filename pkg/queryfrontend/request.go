@@ -69,6 +69,7 @@ type ThanosQueryRangeRequest struct {
 	Analyze             bool
 	Engine              string
 	SplitInterval       time.Duration
+	Stream              bool
 }
 
 // IsDedupEnabled returns true if deduplication is enabled.
@@ -185,6 +186,7 @@ type ThanosQueryInstantRequest struct {
 	// Analyze 默认为 false.
 	Analyze bool
 	Engine  string
+	Stream  bool
 }
 
 // IsDedupEnabled returns true if deduplication is enabled.
@@ -274,6 +276,8 @@ type ThanosLabelsRequest struct {
 	Headers         []*RequestHeader
 	Stats           string
 	SplitInterval   time.Duration
+	ShardInfo       *storepb.ShardInfo
+	Limit           int
 }
 
 // GetStoreMatchers returns store matches.
@@ -325,6 +329,13 @@ func (r *ThanosLabelsRequest) WithSplitInterval(interval time.Duration) queryran
 	return &q
 }
 
+// WithShardInfo clones the current request with a different shard info.
+func (r *ThanosLabelsRequest) WithShardInfo(info *storepb.ShardInfo) queryrange.Request {
+	q := *r
+	q.ShardInfo = info
+	return &q
+}
+
 // LogToSpan writes information about this request to an OpenTracing span.
 func (r *ThanosLabelsRequest) LogToSpan(sp opentracing.Span) {
 	fields := []otlog.Field{
@@ -366,6 +377,9 @@ type ThanosSeriesRequest struct {
 	Headers         []*RequestHeader
 	Stats           string
 	SplitInterval   time.Duration
+	Stream          bool
+	ShardInfo       *storepb.ShardInfo
+	Limit           int
 }
 
 // IsDedupEnabled returns true if deduplication is enabled.
@@ -420,6 +434,13 @@ func (r *ThanosSeriesRequest) WithSplitInterval(interval time.Duration) queryran
 	return &q
 }
 
+// WithShardInfo clones the current request with a different shard info.
+func (r *ThanosSeriesRequest) WithShardInfo(info *storepb.ShardInfo) queryrange.Request {
+	q := *r
+	q.ShardInfo = info
+	return &q
+}
+
 // LogToSpan writes information about this request to an OpenTracing span.
 func (r *ThanosSeriesRequest) LogToSpan(sp opentracing.Span) {
 	fields := []otlog.Field{
@@ -67,6 +67,8 @@ func NewTripperware(config Config, reg prometheus.Registerer, logger log.Logger)
 		queryRangeLimits,
 		queryRangeCodec,
 		config.NumShards,
+		config.CoalesceRequests,
+		config.QueryCosts,
 		config.CortexHandlerConfig.QueryStatsEnabled, // --query-frontend.force-query-stats
 		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "query_range"}, reg), logger, config.ForwardHeaders)
 	if err != nil {
@@ -74,29 +76,45 @@ func NewTripperware(config Config, reg prometheus.Registerer, logger log.Logger)
 	}
 
 	// 创建 labels tripperware.
-	labelsTripperware, err := newLabelsTripperware(config.LabelsConfig, labelsLimits, labelsCodec,
+	labelsTripperware, err := newLabelsTripperware(config.LabelsConfig, labelsLimits, labelsCodec, config.CoalesceRequests,
 		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "labels"}, reg), logger, config.ForwardHeaders)
 	if err != nil {
 		return nil, err
 	}
 
 	// 创建 instant query tripperware.
-	queryInstantTripperware := newInstantQueryTripperware(
+	queryInstantTripperware, err := newInstantQueryTripperware(
+		config.InstantQueryConfig,
 		config.NumShards,
+		config.CoalesceRequests,
 		queryRangeLimits,
 		queryInstantCodec,
 		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "query_instant"}, reg),
+		logger,
 		config.ForwardHeaders,
 		config.CortexHandlerConfig.QueryStatsEnabled,
 	)
+	if err != nil {
+		return nil, err
+	}
+	if config.QueryRules != nil && config.QueueEnabled {
+		// Queued requests are pulled by the queriers, they can't be routed.
+		config.QueryRules.disableRoutes()
+	}
 	return func(next http.RoundTripper) http.RoundTripper {
-		tripper := newRoundTripper(
+		var tripper http.RoundTripper = newRoundTripper(
 			next,
 			queryRangeTripperware(next),
 			labelsTripperware(next),
 			queryInstantTripperware(next),
 			reg,
 		)
+		if config.AccessPolicy != nil {
+			tripper = newAccessPolicyRoundTripper(config.AccessPolicy, tripper)
+		}
+		if config.QueryRules != nil {
+			tripper = newQueryRulesRoundTripper(config.QueryRules, config.DefaultTimeRange, tripper)
+		}
 		return tenancy.InternalTenancyConversionTripper(config.TenantHeader, config.TenantCertField, tripper)
 	}, nil
 }
@@ -177,6 +195,8 @@ func newQueryRangeTripperware(
 	limits queryrange.Limits,
 	codec *queryRangeCodec,
 	numShards int,
+	coalesce bool,
+	costs *QueryCosts,
 	forceStats bool,
 	reg prometheus.Registerer,
 	logger log.Logger,
@@ -210,7 +230,14 @@ func newQueryRangeTripperware(
 	}
 
 	// SplitByInterval
-	if config.SplitQueriesByInterval != 0 || config.MinQuerySplitInterval != 0 {
+	if costs != nil && config.isAdaptiveSplitSet() {
+		// 根据学习到的查询开销自适应选择 split interval 与 vertical shards.
+		queryRangeMiddleware = append(
+			queryRangeMiddleware,
+			queryrange.InstrumentMiddleware("split_by_interval", m),
+			AdaptiveSplitByIntervalMiddleware(costs, config, numShards, limits, codec, reg),
+		)
+	} else if config.SplitQueriesByInterval != 0 || config.MinQuerySplitInterval != 0 {
 		queryIntervalFn := dynamicIntervalFn(config)
 
 		queryRangeMiddleware = append(
@@ -228,11 +255,19 @@ func newQueryRangeTripperware(
 		)
 	}
 
+	if coalesce {
+		queryRangeMiddleware = append(
+			queryRangeMiddleware,
+			queryrange.InstrumentMiddleware("coalescing", m),
+			CoalescingMiddleware(codec, reg),
+		)
+	}
+
 	if config.ResultsCacheConfig != nil {
 		queryCacheMiddleware, _, err := queryrange.NewResultsCacheMiddleware(
 			logger,
 			*config.ResultsCacheConfig,
-			newThanosCacheKeyGenerator(),
+			newThanosCacheKeyGenerator(reg),
 			limits,
 			codec,
 			queryrange.PrometheusResponseExtractor{},
@@ -259,11 +294,17 @@ func newQueryRangeTripperware(
 		)
 	}
 
+	if costs != nil && config.isAdaptiveSplitSet() {
+		// 仅学习实际到达 querier 的请求的开销, 因此必须是最后一个中间层.
+		queryRangeMiddleware = append(queryRangeMiddleware, QueryCostMiddleware())
+	}
+
 	return func(next http.RoundTripper) http.RoundTripper {
 		rt := queryrange.NewRoundTripper(next, codec, forwardHeaders, queryRangeMiddleware...)
-		return queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		// Streamed requests are checked against the limits only.
+		return newStreamRoundTripper(next, queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
 			return rt.RoundTrip(r)
-		})
+		}), codec, forwardHeaders, queryrange.NewLimitsMiddleware(limits))
 	}, nil
 }
 
@@ -304,11 +345,12 @@ func dynamicIntervalFn(config QueryRangeConfig) queryrange.IntervalFn {
 }
 
 // newLabelsTripperware returns a Tripperware for labels and series requests
-// configured with middlewares of split by interval and retry.
+// configured with middlewares of split by interval, sharding and retry.
 func newLabelsTripperware(
 	config LabelsConfig,
 	limits queryrange.Limits,
 	codec *labelsCodec,
+	coalesce bool,
 	reg prometheus.Registerer,
 	logger log.Logger,
 	forwardHeaders []string,
@@ -316,6 +358,11 @@ func newLabelsTripperware(
 	labelsMiddleware := []queryrange.Middleware{}
 	m := queryrange.NewInstrumentMiddlewareMetrics(reg)
 
+	if config.NumShards > 0 {
+		// 所有 split 的分片共享同一个并发预算.
+		labelsMiddleware = append(labelsMiddleware, ShardsBudgetMiddleware(limits))
+	}
+
 	// labels.split-interval
 	queryIntervalFn := func(_ queryrange.Request) time.Duration {
 		return config.SplitQueriesByInterval
@@ -330,11 +377,27 @@ func newLabelsTripperware(
 		)
 	}
 
+	if config.NumShards > 0 {
+		labelsMiddleware = append(
+			labelsMiddleware,
+			queryrange.InstrumentMiddleware("sharding", m),
+			LabelsShardingMiddleware(config.NumShards, limits, codec, reg),
+		)
+	}
+
+	if coalesce {
+		labelsMiddleware = append(
+			labelsMiddleware,
+			queryrange.InstrumentMiddleware("coalescing", m),
+			CoalescingMiddleware(codec, reg),
+		)
+	}
+
 	if config.ResultsCacheConfig != nil {
 		queryCacheMiddleware, _, err := queryrange.NewResultsCacheMiddleware(
 			logger,
 			*config.ResultsCacheConfig,
-			newThanosCacheKeyGenerator(),
+			newThanosCacheKeyGenerator(reg),
 			limits,
 			codec,
 			ThanosResponseExtractor{},
@@ -365,24 +428,57 @@ func newLabelsTripperware(
 
 		// 我一直在想问什么上面那行代码已经返回了 http.RoundTripper, 这里还要用 RoundTripFunc 再在外部封装一层呢.
 		// 或许吧, 可能是想抛弃 rt 的 Handler 接口类型.
-		return queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return newStreamRoundTripper(next, queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
 			return rt.RoundTrip(r)
-		})
+		}), codec, forwardHeaders)
 	}, nil
 }
 
 // newInstantQueryTripperware 返回 http.RoundTripper 构造器.
 func newInstantQueryTripperware(
+	config InstantQueryConfig,
 	numShards int,
+	coalesce bool,
 	limits queryrange.Limits,
 	codec queryrange.Codec,
 	reg prometheus.Registerer,
+	logger log.Logger,
 	forwardHeaders []string,
 	forceStats bool,
-) queryrange.Tripperware {
+) (queryrange.Tripperware, error) {
 	var instantQueryMiddlewares []queryrange.Middleware
 	m := queryrange.NewInstrumentMiddlewareMetrics(reg)
 
+	if coalesce {
+		instantQueryMiddlewares = append(
+			instantQueryMiddlewares,
+			queryrange.InstrumentMiddleware("coalescing", m),
+			CoalescingMiddleware(codec, reg),
+		)
+	}
+
+	// The whole response is cached, before it is sharded.
+	if config.ResultsCacheConfig != nil {
+		queryCacheMiddleware, err := NewInstantQueryCacheMiddleware(
+			logger,
+			*config.ResultsCacheConfig,
+			newThanosCacheKeyGenerator(reg),
+			config.CacheTimeBucket,
+			config.MaxCacheFreshness,
+			shouldCache,
+			reg,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "create results cache middleware")
+		}
+
+		instantQueryMiddlewares = append(
+			instantQueryMiddlewares,
+			queryrange.InstrumentMiddleware("results_cache", m),
+			queryCacheMiddleware,
+		)
+	}
+
 	// vertical-sharding middleware.
 	if numShards > 0 {
 		analyzer := querysharding.NewQueryAnalyzer()
@@ -402,10 +498,10 @@ func newInstantQueryTripperware(
 	return func(next http.RoundTripper) http.RoundTripper {
 		// QueryTripper
 		rt := queryrange.NewRoundTripper(next, codec, forwardHeaders, instantQueryMiddlewares...)
-		return queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return newStreamRoundTripper(next, queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
 			return rt.RoundTrip(r)
-		})
-	}
+		}), codec, forwardHeaders)
+	}, nil
 }
 
 // shouldCache 判断是否应该缓存响应数据.
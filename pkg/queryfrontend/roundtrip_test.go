@@ -6,6 +6,8 @@ package queryfrontend
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -25,6 +27,7 @@ import (
 	"github.com/thanos-io/thanos/internal/cortex/cortexpb"
 	"github.com/thanos-io/thanos/internal/cortex/frontend/transport"
 	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	cortexutil "github.com/thanos-io/thanos/internal/cortex/util"
 	cortexvalidation "github.com/thanos-io/thanos/internal/cortex/util/validation"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 )
@@ -839,6 +842,96 @@ func TestRoundTripSeriesCacheMiddleware(t *testing.T) {
 	}
 }
 
+// TestRoundTripInstantQueryCacheMiddleware tests the cache middleware for instant queries.
+func TestRoundTripInstantQueryCacheMiddleware(t *testing.T) {
+	// Evaluation time far enough in the past to be cacheable, at the beginning of a 1m bucket.
+	now := time.Now().Truncate(time.Minute).Add(-time.Hour).UnixMilli()
+
+	newRequest := func(query string, ts int64) *ThanosQueryInstantRequest {
+		return &ThanosQueryInstantRequest{Path: "/api/v1/query", Query: query, Time: ts, Dedup: true}
+	}
+	testRequestWithoutDedup := newRequest("up", now)
+	testRequestWithoutDedup.Dedup = false
+	testRequestWithStoreMatchers := newRequest("up", now)
+	testRequestWithStoreMatchers.StoreMatchers = [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, "foo", "bar")}}
+	testRequestWithStats := newRequest("up", now+10*seconds)
+	testRequestWithStats.Stats = "all"
+	recent := time.Now().UnixMilli()
+
+	cacheConf := &queryrange.ResultsCacheConfig{
+		CacheConfig: cortexcache.Config{
+			EnableFifoCache: true,
+			Fifocache: cortexcache.FifoCacheConfig{
+				MaxSizeBytes: "1MiB",
+				MaxSizeItems: 1000,
+				Validity:     time.Hour,
+			},
+		},
+	}
+
+	tpw, err := NewTripperware(
+		Config{
+			CortexHandlerConfig: &transport.HandlerConfig{},
+			InstantQueryConfig: InstantQueryConfig{
+				ResultsCacheConfig: cacheConf,
+				CacheTimeBucket:    time.Minute,
+				MaxCacheFreshness:  time.Minute,
+			},
+		}, nil, log.NewNopLogger(),
+	)
+	testutil.Ok(t, err)
+
+	rt, err := newFakeRoundTripper()
+	testutil.Ok(t, err)
+	defer rt.Close()
+	res, times, handler := instantQueryResults()
+	rt.setHandler(handler)
+
+	for _, tc := range []struct {
+		name         string
+		req          queryrange.Request
+		expected     int
+		expectedTime int64
+	}{
+		{name: "first request", req: newRequest("up", now+10*seconds), expected: 1, expectedTime: now},
+		{name: "request in the same time bucket, directly use cache", req: newRequest("up", now+50*seconds), expected: 1, expectedTime: now},
+		{name: "request in the next time bucket, not use cache", req: newRequest("up", now+70*seconds), expected: 2, expectedTime: now + 60*seconds},
+		{name: "different query, not use cache", req: newRequest("down", now), expected: 3, expectedTime: now},
+		{name: "same request with dedup disabled, should not use cache", req: testRequestWithoutDedup, expected: 4, expectedTime: now},
+		{name: "same request with dedup disabled again, should not use cache", req: testRequestWithoutDedup, expected: 5, expectedTime: now},
+		{name: "storeMatchers requests won't go to cache", req: testRequestWithStoreMatchers, expected: 6, expectedTime: now},
+		{name: "storeMatchers requests won't go to cache again", req: testRequestWithStoreMatchers, expected: 7, expectedTime: now},
+		{name: "negative offset", req: newRequest("up offset -1m", now+10*seconds), expected: 8, expectedTime: now + 10*seconds},
+		{name: "negative offset is not cached", req: newRequest("up offset -1m", now+10*seconds), expected: 9, expectedTime: now + 10*seconds},
+		{name: "@ modifier after the evaluation time", req: newRequest(fmt.Sprintf("up @ %d", now/1000+60), now), expected: 10, expectedTime: now},
+		{name: "@ modifier after the evaluation time is not cached", req: newRequest(fmt.Sprintf("up @ %d", now/1000+60), now), expected: 11, expectedTime: now},
+		{name: "@ modifier before the evaluation time", req: newRequest(fmt.Sprintf("up @ %d", now/1000-60), now), expected: 12, expectedTime: now},
+		{name: "@ modifier before the evaluation time is cached", req: newRequest(fmt.Sprintf("up @ %d", now/1000-60), now), expected: 12, expectedTime: now},
+		{name: "response with warnings", req: newRequest("warnings", now), expected: 13, expectedTime: now},
+		{name: "response with warnings is not cached", req: newRequest("warnings", now), expected: 14, expectedTime: now},
+		{name: "recent request", req: newRequest("up", recent), expected: 15, expectedTime: recent},
+		{name: "recent request is not cached", req: newRequest("up", recent), expected: 16, expectedTime: recent},
+		{name: "request with stats", req: testRequestWithStats, expected: 17, expectedTime: now + 10*seconds},
+		{name: "request with stats is not cached", req: testRequestWithStats, expected: 18, expectedTime: now + 10*seconds},
+	} {
+		if !t.Run(tc.name, func(t *testing.T) {
+			ctx := user.InjectOrgID(context.Background(), "1")
+			httpReq, err := NewThanosQueryInstantCodec(true).EncodeRequest(ctx, tc.req)
+			testutil.Ok(t, err)
+
+			_, err = tpw(rt).RoundTrip(httpReq)
+			testutil.Ok(t, err)
+
+			testutil.Equals(t, tc.expected, *res)
+			if tc.expectedTime != 0 {
+				testutil.Equals(t, tc.expectedTime, (*times)[len(*times)-1])
+			}
+		}) {
+			break
+		}
+	}
+}
+
 // promqlResults is a mock handler used to test split and cache middleware.
 // Modified from Loki https://github.com/grafana/loki/blob/master/pkg/querier/queryrange/roundtrip_test.go#L547.
 func promqlResults(fail bool) (*int, http.Handler) {
@@ -926,6 +1019,36 @@ func promqlResultsWithFailures(numFailures int) (*atomic.Int64, http.Handler) {
 	})
 }
 
+// instantQueryResults is a mock handler used to test cache middleware for instant queries.
+// It returns the evaluation times of the requests it received, and asks for the response not to be cached if the query
+// is "warnings".
+func instantQueryResults() (*int, *[]int64, http.Handler) {
+	var (
+		count int
+		times []int64
+		lock  sync.Mutex
+	)
+	const body = `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[0,"1"]}]}}`
+
+	return &count, &times, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		ts, err := cortexutil.ParseTime(r.FormValue("time"))
+		if err != nil {
+			panic(err)
+		}
+		times = append(times, ts)
+		if r.FormValue("query") == "warnings" {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			panic(err)
+		}
+		count++
+	})
+}
+
 // labelsResults is a mock handler used to test split and cache middleware for label names and label values requests.
 func labelsResults(fail bool) (*int, http.Handler) {
 	count := 0
@@ -973,3 +1096,85 @@ func seriesResults(fail bool) (*int, http.Handler) {
 		count++
 	})
 }
+
+func TestRoundTripStreamedRequests(t *testing.T) {
+	// The response fails once part of the data was sent, as signalled by its trailer.
+	const streamed = `{"data":{"resultType":"matrix","result":[{"metric":{"foo":"bar"},"values":[[0,"1"]]}]},"status":"error","errorType":"execution","error":"receive series"}`
+
+	var calls atomic.Int64
+	rt, err := newFakeRoundTripper()
+	testutil.Ok(t, err)
+	defer rt.Close()
+	rt.setHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Inc()
+		testutil.Equals(t, "true", r.FormValue("stream"))
+		w.Header().Set("X-Thanos-Streamed-Response", "true")
+		fmt.Fprint(w, streamed)
+	}))
+
+	tpw, err := NewTripperware(Config{
+		CortexHandlerConfig: &transport.HandlerConfig{},
+		QueryRangeConfig: QueryRangeConfig{
+			Limits:                 defaultLimits,
+			SplitQueriesByInterval: time.Hour,
+		},
+		LabelsConfig: LabelsConfig{
+			Limits:                 defaultLimits,
+			SplitQueriesByInterval: time.Hour,
+		},
+	}, nil, log.NewNopLogger())
+	testutil.Ok(t, err)
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	for _, tc := range []struct {
+		name  string
+		req   queryrange.Request
+		codec queryrange.Codec
+	}{
+		{
+			name:  "range query",
+			req:   &ThanosQueryRangeRequest{Path: "/api/v1/query_range", Start: 0, End: 2 * hour, Step: 10 * seconds, Query: "foo", Stream: true},
+			codec: NewThanosQueryRangeCodec(true),
+		},
+		{
+			name:  "instant query",
+			req:   &ThanosQueryInstantRequest{Path: "/api/v1/query", Time: hour, Query: "foo", Stream: true},
+			codec: NewThanosQueryInstantCodec(true),
+		},
+		{
+			name:  "series",
+			req:   &ThanosSeriesRequest{Path: "/api/v1/series", Start: 0, End: 2 * hour, Stream: true},
+			codec: NewThanosLabelsCodec(true, 2*time.Hour),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls.Store(0)
+			httpReq, err := tc.codec.EncodeRequest(ctx, tc.req)
+			testutil.Ok(t, err)
+
+			resp, err := tpw(rt).RoundTrip(httpReq.WithContext(ctx))
+			testutil.Ok(t, err)
+			defer resp.Body.Close()
+
+			// The request is neither split nor decoded, the response is proxied as is.
+			body, err := io.ReadAll(resp.Body)
+			testutil.Ok(t, err)
+			testutil.Equals(t, http.StatusOK, resp.StatusCode)
+			testutil.Equals(t, streamed, string(body))
+			testutil.Equals(t, "true", resp.Header.Get("X-Thanos-Streamed-Response"))
+			testutil.Equals(t, int64(1), calls.Load())
+		})
+	}
+
+	t.Run("streamed range queries are checked against the limits", func(t *testing.T) {
+		calls.Store(0)
+		httpReq, err := NewThanosQueryRangeCodec(true).EncodeRequest(ctx, &ThanosQueryRangeRequest{
+			Path: "/api/v1/query_range", Start: 0, End: 8 * 24 * hour, Step: 10 * seconds, Query: "foo", Stream: true,
+		})
+		testutil.Ok(t, err)
+
+		_, err = tpw(rt).RoundTrip(httpReq.WithContext(ctx))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, int64(0), calls.Load())
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/internal/cortex/tenant"
+	"github.com/thanos-io/thanos/internal/cortex/util/validation"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+type shardsBudgetKey struct{}
+
+// ShardsBudgetMiddleware bounds the number of shards of a request running at the same time to the max query
+// parallelism of the tenant, whatever the number of splits they belong to. It must come before the split middleware.
+func ShardsBudgetMiddleware(limits queryrange.Limits) queryrange.Middleware {
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		return queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+			tenantIDs, err := tenant.TenantIDs(ctx)
+			if err != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, "%s", err.Error())
+			}
+			parallelism := validation.SmallestPositiveIntPerTenant(tenantIDs, limits.MaxQueryParallelism)
+			if parallelism <= 0 {
+				return next.Do(ctx, r)
+			}
+			return next.Do(context.WithValue(ctx, shardsBudgetKey{}, make(chan struct{}, parallelism)), r)
+		})
+	})
+}
+
+// withShardsBudget returns the handler running the shards within the budget of the request, if any.
+func withShardsBudget(ctx context.Context, next queryrange.Handler) queryrange.Handler {
+	budget, ok := ctx.Value(shardsBudgetKey{}).(chan struct{})
+	if !ok {
+		return next
+	}
+	return queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+		select {
+		case budget <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-budget }()
+		return next.Do(ctx, r)
+	})
+}
+
+// LabelsShardingMiddleware shards the series and labels requests by the hash of the series, runs the shards in parallel
+// and merges their deduplicated responses.
+// Labels requests are only sharded when they have matchers, the queriers select the series of their shard to find
+// their labels, which is much more expensive than looking up the index for all series.
+func LabelsShardingMiddleware(numShards int, limits queryrange.Limits, merger queryrange.Merger, registerer prometheus.Registerer) queryrange.Middleware {
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		queriesTotal := promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "thanos",
+			Name:      "frontend_sharding_middleware_queries_total",
+			Help:      "Total number of queries analyzed by the sharding middleware",
+		}, []string{"shardable"})
+		queriesTotal.WithLabelValues("true")
+		queriesTotal.WithLabelValues("false")
+
+		return labelsSharder{
+			next:         next,
+			limits:       limits,
+			numShards:    numShards,
+			merger:       merger,
+			queriesTotal: queriesTotal,
+		}
+	})
+}
+
+type labelsSharder struct {
+	next      queryrange.Handler
+	limits    queryrange.Limits
+	numShards int
+	merger    queryrange.Merger
+
+	queriesTotal *prometheus.CounterVec
+}
+
+func (s labelsSharder) Do(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+	if !s.shardable(r) {
+		s.queriesTotal.WithLabelValues("false").Inc()
+		return s.next.Do(ctx, r)
+	}
+	s.queriesTotal.WithLabelValues("true").Inc()
+
+	reqs := make([]queryrange.Request, 0, s.numShards)
+	for i := 0; i < s.numShards; i++ {
+		// Sharding without any label hashes all the labels of the series. The queriers exclude their replica labels
+		// from the hash of deduplicated series requests, so that the replicas of a series are in the same shard.
+		reqs = append(reqs, r.(ShardedRequest).WithShardInfo(&storepb.ShardInfo{
+			TotalShards: int64(s.numShards),
+			ShardIndex:  int64(i),
+		}))
+	}
+
+	// The shards of all the splits of the request share the same budget, the splits running in parallel as well.
+	reqResps, err := queryrange.DoRequests(ctx, withShardsBudget(ctx, s.next), reqs, s.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	resps := make([]queryrange.Response, 0, len(reqResps))
+	for _, reqResp := range reqResps {
+		resps = append(resps, reqResp.Response)
+	}
+	return s.merger.MergeResponse(r, resps...)
+}
+
+func (s labelsSharder) shardable(r queryrange.Request) bool {
+	switch r := r.(type) {
+	case *ThanosSeriesRequest:
+		return r.ShardInfo == nil
+	case *ThanosLabelsRequest:
+		return r.ShardInfo == nil && len(r.Matchers) > 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/weaveworks/common/user"
+
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	cortexvalidation "github.com/thanos-io/thanos/internal/cortex/util/validation"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+func TestLabelsShardingMiddleware(t *testing.T) {
+	limits, err := cortexvalidation.NewOverrides(*defaultLimits, nil)
+	testutil.Ok(t, err)
+
+	var (
+		mtx    sync.Mutex
+		shards []*storepb.ShardInfo
+	)
+	// Every shard returns the series up{shard="<index>"}, and the series up{} they all have in common.
+	next := queryrange.HandlerFunc(func(_ context.Context, r queryrange.Request) (queryrange.Response, error) {
+		var info *storepb.ShardInfo
+		switch r := r.(type) {
+		case *ThanosSeriesRequest:
+			info = r.ShardInfo
+		case *ThanosLabelsRequest:
+			info = r.ShardInfo
+		}
+		mtx.Lock()
+		shards = append(shards, info)
+		mtx.Unlock()
+
+		if _, ok := r.(*ThanosLabelsRequest); ok {
+			names := []string{"__name__"}
+			if info != nil {
+				names = append(names, "shard")
+			}
+			return &ThanosLabelsResponse{Status: queryrange.StatusSuccess, Data: names}, nil
+		}
+		return &ThanosSeriesResponse{Status: queryrange.StatusSuccess, Data: []labelpb.ZLabelSet{
+			{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "shard", string(rune('0'+info.ShardIndex))))},
+			{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up"))},
+		}}, nil
+	})
+	sharder := LabelsShardingMiddleware(3, limits, NewThanosLabelsCodec(true, 24*time.Hour), nil).Wrap(next)
+	ctx := user.InjectOrgID(context.Background(), "1")
+	matchers := [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}}
+
+	resp, err := sharder.Do(ctx, &ThanosSeriesRequest{Matchers: matchers})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []labelpb.ZLabelSet{
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up"))},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "shard", "0"))},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "shard", "1"))},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "shard", "2"))},
+	}, resp.(*ThanosSeriesResponse).Data)
+	testutil.Equals(t, 3, len(shards))
+	for _, info := range shards {
+		testutil.Equals(t, int64(3), info.TotalShards)
+		testutil.Equals(t, false, info.By)
+	}
+
+	// Labels requests are sharded when they have matchers only.
+	shards = nil
+	resp, err = sharder.Do(ctx, &ThanosLabelsRequest{Matchers: matchers})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"__name__", "shard"}, resp.(*ThanosLabelsResponse).Data)
+	testutil.Equals(t, 3, len(shards))
+
+	shards = nil
+	resp, err = sharder.Do(ctx, &ThanosLabelsRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"__name__"}, resp.(*ThanosLabelsResponse).Data)
+	testutil.Equals(t, []*storepb.ShardInfo{nil}, shards)
+
+	// The limit is applied once the shards, which return up to limit results each, are merged.
+	shards = nil
+	resp, err = sharder.Do(ctx, &ThanosSeriesRequest{Matchers: matchers, Limit: 2})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []labelpb.ZLabelSet{
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up"))},
+		{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "shard", "0"))},
+	}, resp.(*ThanosSeriesResponse).Data)
+	testutil.Equals(t, 3, len(shards))
+
+	resp, err = sharder.Do(ctx, &ThanosLabelsRequest{Matchers: matchers, Limit: 1})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"__name__"}, resp.(*ThanosLabelsResponse).Data)
+}
+
+func TestLabelsShardingMiddleware_SharedBudget(t *testing.T) {
+	limits, err := cortexvalidation.NewOverrides(cortexvalidation.Limits{MaxQueryParallelism: 3}, nil)
+	testutil.Ok(t, err)
+
+	var (
+		mtx              sync.Mutex
+		running, maxSeen int
+		calls            int
+	)
+	next := queryrange.HandlerFunc(func(_ context.Context, r queryrange.Request) (queryrange.Response, error) {
+		mtx.Lock()
+		running++
+		calls++
+		maxSeen = max(maxSeen, running)
+		mtx.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mtx.Lock()
+		running--
+		mtx.Unlock()
+		return &ThanosSeriesResponse{Status: queryrange.StatusSuccess}, nil
+	})
+
+	codec := NewThanosLabelsCodec(true, 2*time.Hour)
+	h := queryrange.MergeMiddlewares(
+		ShardsBudgetMiddleware(limits),
+		SplitByIntervalMiddleware(func(queryrange.Request) time.Duration { return time.Hour }, limits, codec, nil),
+		LabelsShardingMiddleware(3, limits, codec, nil),
+	).Wrap(next)
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	_, err = h.Do(ctx, &ThanosSeriesRequest{
+		Path:     "/api/v1/series",
+		Start:    0,
+		End:      4 * time.Hour.Milliseconds(),
+		Matchers: [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}},
+	})
+	testutil.Ok(t, err)
+
+	// 4 splits of 3 shards, within the parallelism of the tenant rather than its square.
+	testutil.Equals(t, 12, calls)
+	testutil.Assert(t, maxSeen <= 3, "expected at most 3 shards running at the same time, got %d", maxSeen)
+}
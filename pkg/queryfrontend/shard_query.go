@@ -8,16 +8,33 @@ package queryfrontend
 
 import (
 	"context"
+	"math"
+	"sort"
 
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
 
+	"github.com/thanos-io/thanos/internal/cortex/cortexpb"
 	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/internal/cortex/querier/stats"
 
 	"github.com/thanos-io/thanos/pkg/querysharding"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
 
+// errNotRecombinable is returned when the partial aggregations of the shards cannot be recombined, like the ones of
+// native histograms.
+var errNotRecombinable = errors.New("partial aggregations cannot be recombined")
+
+type verticalShardsKey struct{}
+
+// withVerticalShards returns a context overriding the number of shards of the sharding middleware.
+func withVerticalShards(ctx context.Context, shards int) context.Context {
+	return context.WithValue(ctx, verticalShardsKey{}, shards)
+}
+
 // PromQLShardingMiddleware 创建 ShardingMiddleware.
 func PromQLShardingMiddleware(queryAnalyzer querysharding.Analyzer, numShards int, limits queryrange.Limits, merger queryrange.Merger, registerer prometheus.Registerer) queryrange.Middleware {
 	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
@@ -57,17 +74,46 @@ type querySharder struct {
 
 // Do
 func (s querySharder) Do(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+	numShards := s.numShards
+	if shards, ok := ctx.Value(verticalShardsKey{}).(int); ok {
+		if shards <= 1 {
+			return s.next.Do(ctx, r)
+		}
+		numShards = shards
+	}
+
 	// 分片分析器
 	analysis, err := s.queryAnalyzer.Analyze(r.GetQuery())
 	if err != nil || !analysis.IsShardable() {
 		s.queriesTotal.WithLabelValues("false").Inc()
+		stats.FromContext(ctx).SetNotShardableReason(analysis.NotShardableReason())
 		return s.next.Do(ctx, r)
 	}
 
 	s.queriesTotal.WithLabelValues("true").Inc()
-	reqs := s.shardQuery(r, analysis)
+	reqs := s.shardQuery(r, analysis, numShards)
 
-	reqResps, err := queryrange.DoRequests(ctx, s.next, reqs, s.limits)
+	op := analysis.PartialAggregation()
+	next := s.next
+	if op != "" {
+		// Cancel the other shards as soon as one of them returns partial aggregations which can't be recombined.
+		next = queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+			resp, err := s.next.Do(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkRecombinable(resp); err != nil {
+				return nil, err
+			}
+			return resp, nil
+		})
+	}
+
+	reqResps, err := queryrange.DoRequests(ctx, next, reqs, s.limits)
+	if errors.Is(err, errNotRecombinable) {
+		stats.FromContext(ctx).SetNotShardableReason(err.Error())
+		return s.next.Do(ctx, r)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +123,10 @@ func (s querySharder) Do(ctx context.Context, r queryrange.Request) (queryrange.
 		resps = append(resps, reqResp.Response)
 	}
 
+	if op != "" {
+		return s.recombine(r, op, resps)
+	}
+
 	response, err := s.merger.MergeResponse(r, resps...)
 	if err != nil {
 		return nil, err
@@ -85,7 +135,7 @@ func (s querySharder) Do(ctx context.Context, r queryrange.Request) (queryrange.
 }
 
 // shardQuery 将请求分片成多个子请求.
-func (s querySharder) shardQuery(r queryrange.Request, analysis querysharding.QueryAnalysis) []queryrange.Request {
+func (s querySharder) shardQuery(r queryrange.Request, analysis querysharding.QueryAnalysis, numShards int) []queryrange.Request {
 	tr, ok := r.(ShardedRequest)
 	if !ok {
 		return []queryrange.Request{r}
@@ -93,10 +143,10 @@ func (s querySharder) shardQuery(r queryrange.Request, analysis querysharding.Qu
 
 	// TODO 疑问点: Frontend 分片的话, 不应该明确告诉 downstream 它要负责查询什么数据吗?
 	// 他告诉 downstream 总共分片数, 已经当前请求的分片索引是为什么? 难道是 querier 根据分片信息来计算自己负责的部分?
-	reqs := make([]queryrange.Request, s.numShards)
-	for i := 0; i < s.numShards; i++ {
+	reqs := make([]queryrange.Request, numShards)
+	for i := 0; i < numShards; i++ {
 		reqs[i] = tr.WithShardInfo(&storepb.ShardInfo{
-			TotalShards: int64(s.numShards),
+			TotalShards: int64(numShards),
 			ShardIndex:  int64(i),
 			By:          analysis.ShardBy(),
 			Labels:      analysis.ShardingLabels(),
@@ -105,3 +155,142 @@ func (s querySharder) shardQuery(r queryrange.Request, analysis querysharding.Qu
 
 	return reqs
 }
+
+// checkRecombinable returns errNotRecombinable if the partial aggregations of the response can't be recombined.
+func checkRecombinable(resp queryrange.Response) error {
+	switch resp := resp.(type) {
+	case *queryrange.PrometheusResponse:
+		for _, stream := range resp.Data.Result {
+			if len(stream.Histograms) > 0 {
+				return errNotRecombinable
+			}
+		}
+	case *queryrange.PrometheusInstantQueryResponse:
+		for _, sample := range resp.Data.Result.GetVector().GetSamples() {
+			if sample.Histogram != nil {
+				return errNotRecombinable
+			}
+		}
+	default:
+		return errNotRecombinable
+	}
+	return nil
+}
+
+// recombine merges the responses of the shards, whose series are the partial aggregations computed by each shard,
+// and recombines these series with the op aggregation. The responses are expected to be checked by checkRecombinable.
+func (s querySharder) recombine(r queryrange.Request, op string, resps []queryrange.Response) (queryrange.Response, error) {
+	aggregates := partialAggregates{op: op, series: map[string]*partialSeries{}}
+	for _, resp := range resps {
+		switch resp := resp.(type) {
+		case *queryrange.PrometheusResponse:
+			for _, stream := range resp.Data.Result {
+				for _, sample := range stream.Samples {
+					aggregates.add(stream.Labels, sample.TimestampMs, sample.Value)
+				}
+			}
+		case *queryrange.PrometheusInstantQueryResponse:
+			for _, sample := range resp.Data.Result.GetVector().GetSamples() {
+				aggregates.add(sample.Labels, sample.Timestamp, sample.SampleValue)
+			}
+		default:
+			return nil, errNotRecombinable
+		}
+	}
+
+	// The partial series are replaced once the rest of the responses, like their warnings and stats, is merged.
+	merged, err := s.merger.MergeResponse(r, resps...)
+	if err != nil {
+		return nil, err
+	}
+	series := aggregates.sorted()
+	switch merged := merged.(type) {
+	case *queryrange.PrometheusResponse:
+		merged.Data.Result = make([]queryrange.SampleStream, 0, len(series))
+		for _, s := range series {
+			stream := queryrange.SampleStream{Labels: s.labels, Samples: make([]cortexpb.Sample, 0, len(s.timestamps))}
+			for _, t := range s.timestamps {
+				stream.Samples = append(stream.Samples, cortexpb.Sample{TimestampMs: t, Value: s.values[t]})
+			}
+			merged.Data.Result = append(merged.Data.Result, stream)
+		}
+	case *queryrange.PrometheusInstantQueryResponse:
+		vector := &queryrange.Vector{Samples: make([]*queryrange.Sample, 0, len(series))}
+		for _, s := range series {
+			for _, t := range s.timestamps {
+				vector.Samples = append(vector.Samples, &queryrange.Sample{Labels: s.labels, Timestamp: t, SampleValue: s.values[t]})
+			}
+		}
+		merged.Data.ResultType = model.ValVector.String()
+		merged.Data.Result = queryrange.PrometheusInstantQueryResult{
+			Result: &queryrange.PrometheusInstantQueryResult_Vector{Vector: vector},
+		}
+	default:
+		return nil, errNotRecombinable
+	}
+	return merged, nil
+}
+
+// partialAggregates recombines the partial aggregations of the shards, per series and timestamp.
+type partialAggregates struct {
+	op     string
+	series map[string]*partialSeries
+}
+
+type partialSeries struct {
+	labels     []cortexpb.LabelAdapter
+	values     map[int64]float64
+	timestamps []int64
+}
+
+func (p *partialAggregates) add(lbls []cortexpb.LabelAdapter, t int64, v float64) {
+	key := cortexpb.FromLabelAdaptersToLabels(lbls).String()
+	s, ok := p.series[key]
+	if !ok {
+		s = &partialSeries{labels: lbls, values: map[int64]float64{}}
+		p.series[key] = s
+	}
+	prev, ok := s.values[t]
+	if !ok {
+		s.values[t] = v
+		s.timestamps = append(s.timestamps, t)
+		return
+	}
+	s.values[t] = recombinePartialAggregation(p.op, prev, v)
+}
+
+// sorted returns the series sorted by labels, with sorted timestamps.
+func (p *partialAggregates) sorted() []*partialSeries {
+	keys := make([]string, 0, len(p.series))
+	for k := range p.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	series := make([]*partialSeries, 0, len(keys))
+	for _, k := range keys {
+		s := p.series[k]
+		sort.Slice(s.timestamps, func(i, j int) bool { return s.timestamps[i] < s.timestamps[j] })
+		series = append(series, s)
+	}
+	return series
+}
+
+// recombinePartialAggregation follows the PromQL semantics of the aggregations, where a NaN is replaced by any other
+// value by min and max.
+func recombinePartialAggregation(op string, a, b float64) float64 {
+	switch op {
+	case "min":
+		if a > b || math.IsNaN(a) {
+			return b
+		}
+		return a
+	case "max":
+		if a < b || math.IsNaN(a) {
+			return b
+		}
+		return a
+	default:
+		return a + b
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+
+	"github.com/thanos-io/thanos/internal/cortex/cortexpb"
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	"github.com/thanos-io/thanos/internal/cortex/querier/stats"
+	cortexvalidation "github.com/thanos-io/thanos/internal/cortex/util/validation"
+	"github.com/thanos-io/thanos/pkg/querysharding"
+)
+
+func newTestQuerySharder(t *testing.T, numShards int, merger queryrange.Merger, next queryrange.Handler) queryrange.Handler {
+	limits, err := cortexvalidation.NewOverrides(*defaultLimits, nil)
+	testutil.Ok(t, err)
+	return PromQLShardingMiddleware(querysharding.NewQueryAnalyzer(), numShards, limits, merger, nil).Wrap(next)
+}
+
+func TestQuerySharderPartialAggregation(t *testing.T) {
+	var (
+		podA = []cortexpb.LabelAdapter{{Name: "pod", Value: "a"}}
+		podB = []cortexpb.LabelAdapter{{Name: "pod", Value: "b"}}
+	)
+	// Each shard returns the partial aggregation of its series, shard 0 misses the second sample of pod a.
+	shards := map[int64][]queryrange.SampleStream{
+		0: {
+			{Labels: podB, Samples: []cortexpb.Sample{{TimestampMs: 0, Value: 1}}},
+			{Labels: podA, Samples: []cortexpb.Sample{{TimestampMs: 0, Value: math.NaN()}}},
+		},
+		1: {
+			{Labels: podA, Samples: []cortexpb.Sample{{TimestampMs: 0, Value: 2}, {TimestampMs: 15000, Value: 3}}},
+			{Labels: podB, Samples: []cortexpb.Sample{{TimestampMs: 0, Value: 4}}},
+		},
+	}
+
+	for _, tcase := range []struct {
+		query string
+		// exp are the samples of pod a at 0 and 15s, and of pod b at 0.
+		exp []float64
+	}{
+		{query: `sum by (pod) (label_replace(rate(http_requests_total[5m]), "pod", "$1", "instance", "(.*)"))`, exp: []float64{math.NaN(), 3, 5}},
+		{query: `count by (pod) (label_replace(http_requests_total, "pod", "$1", "instance", "(.*)"))`, exp: []float64{math.NaN(), 3, 5}},
+		{query: `min by (pod) (label_replace(http_requests_total, "pod", "$1", "instance", "(.*)"))`, exp: []float64{2, 3, 1}},
+		{query: `max by (pod) (label_replace(http_requests_total, "pod", "$1", "instance", "(.*)"))`, exp: []float64{2, 3, 4}},
+	} {
+		t.Run(tcase.query, func(t *testing.T) {
+			var calls atomic.Int64
+			next := queryrange.HandlerFunc(func(_ context.Context, r queryrange.Request) (queryrange.Response, error) {
+				calls.Inc()
+				req := r.(*ThanosQueryRangeRequest)
+				testutil.Assert(t, req.ShardInfo != nil, "expected sharded request")
+				return &queryrange.PrometheusResponse{
+					Status: queryrange.StatusSuccess,
+					Data:   queryrange.PrometheusData{ResultType: "matrix", Result: shards[req.ShardInfo.ShardIndex]},
+				}, nil
+			})
+			sharder := newTestQuerySharder(t, 2, NewThanosQueryRangeCodec(true), next)
+
+			resp, err := sharder.Do(user.InjectOrgID(context.Background(), "1"), &ThanosQueryRangeRequest{Query: tcase.query, Start: 0, End: 15000, Step: 15000})
+			testutil.Ok(t, err)
+			testutil.Equals(t, int64(2), calls.Load())
+
+			result := resp.(*queryrange.PrometheusResponse).Data.Result
+			testutil.Equals(t, 2, len(result))
+			testutil.Equals(t, podA, result[0].Labels)
+			testutil.Equals(t, podB, result[1].Labels)
+			testutil.Equals(t, 2, len(result[0].Samples))
+			testutil.Equals(t, 1, len(result[1].Samples))
+			got := []float64{result[0].Samples[0].Value, result[0].Samples[1].Value, result[1].Samples[0].Value}
+			for i := range tcase.exp {
+				if math.IsNaN(tcase.exp[i]) {
+					testutil.Assert(t, math.IsNaN(got[i]), "expected NaN, got %v", got[i])
+					continue
+				}
+				testutil.Equals(t, tcase.exp[i], got[i])
+			}
+		})
+	}
+}
+
+func TestQuerySharderPartialAggregationInstant(t *testing.T) {
+	next := queryrange.HandlerFunc(func(_ context.Context, r queryrange.Request) (queryrange.Response, error) {
+		req := r.(*ThanosQueryInstantRequest)
+		return &queryrange.PrometheusInstantQueryResponse{
+			Status: queryrange.StatusSuccess,
+			Data: queryrange.PrometheusInstantQueryData{
+				ResultType: "vector",
+				Result: queryrange.PrometheusInstantQueryResult{
+					Result: &queryrange.PrometheusInstantQueryResult_Vector{Vector: &queryrange.Vector{
+						Samples: []*queryrange.Sample{{Timestamp: 1000, SampleValue: float64(req.ShardInfo.ShardIndex + 1)}},
+					}},
+				},
+			},
+		}, nil
+	})
+	sharder := newTestQuerySharder(t, 3, NewThanosQueryInstantCodec(true), next)
+
+	resp, err := sharder.Do(user.InjectOrgID(context.Background(), "1"), &ThanosQueryInstantRequest{Query: `count(http_requests_total)`, Time: 1000})
+	testutil.Ok(t, err)
+	samples := resp.(*queryrange.PrometheusInstantQueryResponse).Data.Result.GetVector().Samples
+	testutil.Equals(t, 1, len(samples))
+	testutil.Equals(t, 6.0, samples[0].SampleValue)
+	testutil.Equals(t, int64(1000), samples[0].Timestamp)
+}
+
+func TestQuerySharderNotRecombinable(t *testing.T) {
+	var unsharded, canceled atomic.Int64
+	next := queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+		req := r.(*ThanosQueryRangeRequest)
+		switch {
+		case req.ShardInfo == nil:
+			unsharded.Inc()
+		case req.ShardInfo.ShardIndex == 0:
+			// The slow shard is canceled as soon as the other one returns a native histogram.
+			select {
+			case <-ctx.Done():
+				canceled.Inc()
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+				return nil, errors.New("shard not canceled")
+			}
+		}
+		return &queryrange.PrometheusResponse{
+			Status: queryrange.StatusSuccess,
+			Data: queryrange.PrometheusData{ResultType: "matrix", Result: []queryrange.SampleStream{
+				{Histograms: []queryrange.SampleHistogramPair{{Timestamp: 0}}},
+			}},
+		}, nil
+	})
+	sharder := newTestQuerySharder(t, 2, NewThanosQueryRangeCodec(true), next)
+
+	st, ctx := stats.ContextWithEmptyStats(user.InjectOrgID(context.Background(), "1"))
+	_, err := sharder.Do(ctx, &ThanosQueryRangeRequest{Query: `sum(http_requests_total)`, Start: 0, End: 15000, Step: 15000})
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(1), unsharded.Load())
+	testutil.Equals(t, int64(1), canceled.Load())
+	testutil.Equals(t, errNotRecombinable.Error(), st.LoadNotShardableReason())
+}
+
+func TestQuerySharderNotShardableReason(t *testing.T) {
+	next := queryrange.HandlerFunc(func(_ context.Context, r queryrange.Request) (queryrange.Response, error) {
+		return queryrange.NewEmptyPrometheusResponse(), nil
+	})
+	sharder := newTestQuerySharder(t, 2, NewThanosQueryRangeCodec(true), next)
+
+	st, ctx := stats.ContextWithEmptyStats(user.InjectOrgID(context.Background(), "1"))
+	_, err := sharder.Do(ctx, &ThanosQueryRangeRequest{Query: `absent(up)`, Start: 0, End: 15000, Step: 15000})
+	testutil.Ok(t, err)
+	testutil.Equals(t, "function absent is not shardable", st.LoadNotShardableReason())
+}
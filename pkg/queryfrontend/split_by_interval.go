@@ -21,37 +21,51 @@ import (
 // SplitByIntervalMiddleware creates a new Middleware that splits requests by a given interval.
 func SplitByIntervalMiddleware(interval queryrange.IntervalFn, limits queryrange.Limits, merger queryrange.Merger, registerer prometheus.Registerer) queryrange.Middleware {
 	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
-		return splitByInterval{
-			next:     next,
-			limits:   limits,
-			merger:   merger,
-			interval: interval,
-			splitByCounter: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
-				Namespace: "thanos",
-				Name:      "frontend_split_queries_total",
-				Help:      "Total number of underlying query requests after the split by interval is applied",
-			}),
-		}
+		return newSplitByInterval(next, interval, limits, merger, registerer)
 	})
 }
 
+func newSplitByInterval(next queryrange.Handler, interval queryrange.IntervalFn, limits queryrange.Limits, merger queryrange.Merger, registerer prometheus.Registerer) splitByInterval {
+	return splitByInterval{
+		next:     next,
+		limits:   limits,
+		merger:   merger,
+		interval: interval,
+		splitByCounter: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+			Namespace: "thanos",
+			Name:      "frontend_split_queries_total",
+			Help:      "Total number of underlying query requests after the split by interval is applied",
+		}),
+	}
+}
+
 type splitByInterval struct {
 	next           queryrange.Handler
 	limits         queryrange.Limits
 	merger         queryrange.Merger
 	interval       queryrange.IntervalFn // 动态获取 split interval 的函数.
 	splitByCounter prometheus.Counter
+	// 学习查询开销, 自适应选择 split interval 与 vertical shards, 可为 nil.
+	adaptive *adaptiveSplitter
 }
 
 func (s splitByInterval) Do(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
-	reqs, err := splitQuery(r, s.interval(r))
+	interval, next := s.interval(r), s.next
+	if s.adaptive != nil {
+		ctx, interval, next = s.adaptive.split(ctx, r, interval, next)
+	}
+
+	reqs, err := splitQuery(r, interval)
 	if err != nil {
 		return nil, err
 	}
+	if s.adaptive != nil {
+		reqs = s.adaptive.pinCacheInterval(reqs)
+	}
 	s.splitByCounter.Add(float64(len(reqs)))
 
 	// 执行子请求
-	reqResps, err := queryrange.DoRequests(ctx, s.next, reqs, s.limits)
+	reqResps, err := queryrange.DoRequests(ctx, next, reqs, s.limits)
 	if err != nil {
 		return nil, err
 	}
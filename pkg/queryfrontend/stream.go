@@ -0,0 +1,89 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
+	queryv1 "github.com/thanos-io/thanos/pkg/api/query"
+)
+
+// parseStreamParam 解析 stream 参数, 默认为 false.
+func parseStreamParam(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+	stream, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, httpgrpc.Errorf(http.StatusBadRequest, errCannotParse, queryv1.StreamParam)
+	}
+	return stream, nil
+}
+
+// newStreamRoundTripper returns a http.RoundTripper proxying the streamed requests to next once they went through the
+// given middlewares, their responses being sent to the client as they are received. The responses of streamed requests
+// can't be split, merged nor cached, as errors happening mid-stream are only known once the data was sent. The other
+// requests are sent to tripper.
+func newStreamRoundTripper(next, tripper http.RoundTripper, codec queryrange.Codec, forwardHeaders []string, middlewares ...queryrange.Middleware) http.RoundTripper {
+	proxy := queryrange.MergeMiddlewares(middlewares...).Wrap(queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+		req, err := codec.EncodeRequest(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		if err := user.InjectOrgIDIntoHTTPRequest(ctx, req); err != nil {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, "%s", err.Error())
+		}
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		return &streamedResponse{resp: resp}, nil
+	}))
+
+	return queryrange.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if err := r.ParseForm(); err != nil {
+			return tripper.RoundTrip(r)
+		}
+		stream, err := parseStreamParam(r.FormValue(queryv1.StreamParam))
+		if err != nil {
+			return nil, err
+		}
+		if !stream {
+			return tripper.RoundTrip(r)
+		}
+
+		req, err := codec.DecodeRequest(r.Context(), r, forwardHeaders)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := proxy.Do(r.Context(), req)
+		if err != nil {
+			return nil, err
+		}
+		if streamed, ok := resp.(*streamedResponse); ok {
+			return streamed.resp, nil
+		}
+		// The middlewares answered without sending the request downstream.
+		return codec.EncodeResponse(r.Context(), resp)
+	})
+}
+
+// streamedResponse is the response of a streamed request, whose body is proxied to the client without being decoded.
+type streamedResponse struct {
+	resp *http.Response
+}
+
+func (r *streamedResponse) Reset()         {}
+func (r *streamedResponse) String() string { return "streamed response" }
+func (r *streamedResponse) ProtoMessage()  {}
+
+func (r *streamedResponse) GetHeaders() []*queryrange.PrometheusResponseHeader { return nil }
+
+func (r *streamedResponse) GetStats() *queryrange.PrometheusResponseStats { return nil }
@@ -10,11 +10,20 @@ type QueryAnalysis struct {
 	// When set to true, sharding is `by` shardingLabels,
 	// otherwise it is `without` shardingLabels.
 	shardBy bool
+
+	// Aggregation recombining the results of the shards, when the outermost
+	// aggregation of the query is computed by each shard on its own series.
+	// Empty when the results of the shards are concatenated.
+	partialAggregation string
+
+	// Reason the query is not shardable, if it is not.
+	reason string
 }
 
-func nonShardableQuery() QueryAnalysis {
+func nonShardableQuery(reason string) QueryAnalysis {
 	return QueryAnalysis{
 		shardingLabels: nil,
+		reason:         reason,
 	}
 }
 
@@ -69,6 +78,21 @@ func (q *QueryAnalysis) ShardBy() bool {
 	return q.shardBy
 }
 
+// PartialAggregation returns the aggregation, sum, min or max, recombining
+// the partial aggregations computed by the shards, or an empty string if the
+// results of the shards are to be concatenated.
+func (q *QueryAnalysis) PartialAggregation() string {
+	return q.partialAggregation
+}
+
+// NotShardableReason returns the reason the query is not shardable.
+func (q *QueryAnalysis) NotShardableReason() string {
+	if q.IsShardable() {
+		return ""
+	}
+	return q.reason
+}
+
 func intersect(sliceA, sliceB []string) []string {
 	if len(sliceA) == 0 || len(sliceB) == 0 {
 		return []string{}
@@ -28,6 +28,21 @@ import (
 
 var (
 	notShardableErr = fmt.Errorf("expressions are not shardable")
+
+	// partialAggregations maps the aggregations which shards can compute on
+	// their own series to the aggregation recombining their results.
+	partialAggregations = map[parser.ItemType]string{
+		parser.SUM:   "sum",
+		parser.COUNT: "sum",
+		parser.MIN:   "min",
+		parser.MAX:   "max",
+	}
+)
+
+const (
+	reasonParseError     = "query cannot be parsed"
+	reasonNoGrouping     = "query has no aggregation or vector matching"
+	reasonNoCommonLabels = "no label is kept by all the aggregations and vector matchings"
 )
 
 type Analyzer interface {
@@ -88,20 +103,32 @@ func (a *CachedQueryAnalyzer) Analyze(query string) (QueryAnalysis, error) {
 //   - Walk the query and find the least common labelset
 //     used in grouping expressions. If non-empty, treat the query
 //     as shardable by those labels.
+//   - otherwise, if the outermost expression is a sum, count, min or max
+//     aggregation whose argument is shardable, shard the argument and let
+//     each shard compute the aggregation on its own series. The partial
+//     aggregations of the shards are then recombined.
 //   - otherwise, treat the query as non-shardable.
 //
 // The le label is excluded from sharding.
 func (a *QueryAnalyzer) Analyze(query string) (QueryAnalysis, error) {
 	expr, err := extpromql.ParseExpr(query)
 	if err != nil {
-		return nonShardableQuery(), err
+		return nonShardableQuery(reasonParseError), err
 	}
 
+	analysis := analyzeExpr(expr)
+	if analysis.IsShardable() {
+		return analysis, nil
+	}
+	return analyzePartialAggregation(expr, analysis), nil
+}
+
+func analyzeExpr(expr parser.Expr) QueryAnalysis {
 	var (
 		analysis      QueryAnalysis
 		dynamicLabels []string
+		reason        string
 	)
-	isShardable := true
 	parser.Inspect(expr, func(node parser.Node, nodes []parser.Node) error {
 		switch n := node.(type) {
 		case *parser.Call:
@@ -110,7 +137,7 @@ func (a *QueryAnalyzer) Analyze(query string) (QueryAnalysis, error) {
 					dstLabel := stringFromArg(n.Args[1])
 					dynamicLabels = append(dynamicLabels, dstLabel)
 				} else if n.Func.Name == "absent_over_time" || n.Func.Name == "absent" || n.Func.Name == "scalar" {
-					isShardable = false
+					reason = fmt.Sprintf("function %s is not shardable", n.Func.Name)
 					return notShardableErr
 				} else if n.Func.Name == "histogram_quantile" {
 					analysis = analysis.scopeToLabels([]string{"le"}, false)
@@ -135,8 +162,8 @@ func (a *QueryAnalyzer) Analyze(query string) (QueryAnalysis, error) {
 		return nil
 	})
 
-	if !isShardable {
-		return nonShardableQuery(), nil
+	if reason != "" {
+		return nonShardableQuery(reason)
 	}
 
 	// If currently it is shard by, it is still shardable if there is
@@ -149,7 +176,42 @@ func (a *QueryAnalyzer) Analyze(query string) (QueryAnalysis, error) {
 		analysis = analysis.scopeToLabels(dynamicLabels, false)
 	}
 
-	return analysis, nil
+	if !analysis.IsShardable() {
+		if analysis.shardingLabels == nil {
+			analysis.reason = reasonNoGrouping
+		} else {
+			analysis.reason = reasonNoCommonLabels
+		}
+	}
+	return analysis
+}
+
+// analyzePartialAggregation returns the analysis of the argument of the
+// outermost aggregation of the query, if it is a sum, count, min or max and
+// its argument is shardable. Each shard then computes the aggregation on its
+// own series, and the results of the shards are recombined with the returned
+// PartialAggregation. It returns the analysis of the whole query otherwise.
+func analyzePartialAggregation(expr parser.Expr, analysis QueryAnalysis) QueryAnalysis {
+	unwrapParenExpr(&expr)
+	agg, ok := expr.(*parser.AggregateExpr)
+	if !ok {
+		return analysis
+	}
+	recombination, ok := partialAggregations[agg.Op]
+	if !ok {
+		return analysis
+	}
+
+	inner := analyzeExpr(agg.Expr)
+	if inner.shardingLabels == nil && inner.reason == reasonNoGrouping {
+		// The argument does not combine series together, the shards can read any series.
+		inner = inner.scopeToLabels([]string{model.MetricNameLabel}, false)
+	}
+	if !inner.IsShardable() {
+		return analysis
+	}
+	inner.partialAggregation = recombination
+	return inner
 }
 
 // Copied from https://github.com/prometheus/prometheus/blob/v2.40.1/promql/functions.go#L1416.
@@ -14,23 +14,21 @@ import (
 func TestAnalyzeQuery(t *testing.T) {
 
 	type testCase struct {
-		name           string
-		expression     string
-		shardingLabels []string
+		name               string
+		expression         string
+		shardingLabels     []string
+		shardBy            bool
+		partialAggregation string
 	}
 
 	nonShardable := []testCase{
 		{
-			name:       "aggregation",
-			expression: "sum(http_requests_total)",
+			name:       "outer aggregation which cannot be recombined",
+			expression: "topk(5, http_requests_total)",
 		},
 		{
-			name:       "outer aggregation with no grouping",
-			expression: "count(sum by (pod) (http_requests_total))",
-		},
-		{
-			name:       "outer aggregation with without grouping",
-			expression: "count(sum without (pod) (http_requests_total))",
+			name:       "outer aggregation of an argument which is not shardable",
+			expression: "sum(sum by (pod) (http_requests_total) / sum by (cluster) (http_requests_total))",
 		},
 		{
 			name:       "binary expression with constant",
@@ -51,14 +49,6 @@ func TestAnalyzeQuery(t *testing.T) {
 / on ()
 http_requests_total`,
 		},
-		{
-			name:       "aggregate by expression with label_replace, sharding label is dynamic",
-			expression: `sum by (dst_label) (label_replace(metric, "dst_label", "$1", "src_label", "re"))`,
-		},
-		{
-			name:       "aggregate by expression with label_join, sharding label is dynamic",
-			expression: `sum by (dst_label) (label_join(metric, "dst_label", ",", "src_label"))`,
-		},
 		{
 			name:       "absent_over_time is not shardable",
 			expression: `sum by (url) (absent_over_time(http_requests_total{code="400"}[5m]))`,
@@ -71,10 +61,6 @@ http_requests_total`,
 			name:       "scalar is not shardable",
 			expression: `scalar(sum by (url) (http_requests_total{code="400"}))`,
 		},
-		{
-			name:       "sum by le together with histogram_quantile, not shardable",
-			expression: `sum by (le) (histogram_quantile(0.99, http_requests_duration_seconds_bucket))`,
-		},
 		{
 			name:       "sum by le together with histogram_quantile in binary expression",
 			expression: `sum by (le) (http_requests_duration_seconds_bucket) + histogram_quantile(0.99, http_requests_duration_seconds_bucket)`,
@@ -283,12 +269,93 @@ http_requests_total`,
 		},
 	}
 
+	shardableWithPartialAggregation := []testCase{
+		{
+			name:               "aggregation",
+			expression:         "sum(http_requests_total)",
+			shardingLabels:     []string{model.MetricNameLabel},
+			partialAggregation: "sum",
+		},
+		{
+			name:               "count is recombined with sum",
+			expression:         "count(http_requests_total)",
+			shardingLabels:     []string{model.MetricNameLabel},
+			partialAggregation: "sum",
+		},
+		{
+			name:               "min and max are recombined with themselves",
+			expression:         "(max(rate(http_requests_total[5m])))",
+			shardingLabels:     []string{model.MetricNameLabel},
+			partialAggregation: "max",
+		},
+		{
+			name:               "outer aggregation with no grouping",
+			expression:         "count(sum by (pod) (http_requests_total))",
+			shardingLabels:     []string{"pod"},
+			shardBy:            true,
+			partialAggregation: "sum",
+		},
+		{
+			name:               "outer aggregation with without grouping",
+			expression:         "count(sum without (pod) (http_requests_total))",
+			shardingLabels:     []string{"pod"},
+			partialAggregation: "sum",
+		},
+		{
+			name:               "aggregate by expression with label_replace, sharding label is dynamic",
+			expression:         `sum by (dst_label) (label_replace(metric, "dst_label", "$1", "src_label", "re"))`,
+			shardingLabels:     []string{"dst_label"},
+			partialAggregation: "sum",
+		},
+		{
+			name:               "aggregate by expression with label_join, sharding label is dynamic",
+			expression:         `min by (dst_label) (label_join(metric, "dst_label", ",", "src_label"))`,
+			shardingLabels:     []string{"dst_label"},
+			partialAggregation: "min",
+		},
+		{
+			name:               "sum by le together with histogram_quantile",
+			expression:         `sum by (le) (histogram_quantile(0.99, http_requests_duration_seconds_bucket))`,
+			shardingLabels:     []string{"le"},
+			partialAggregation: "sum",
+		},
+		{
+			name:               "aggregation of a subquery",
+			expression:         `sum(max_over_time(rate(http_requests_total[5m])[1h:5m]))`,
+			shardingLabels:     []string{model.MetricNameLabel},
+			partialAggregation: "sum",
+		},
+		{
+			name:               "aggregation of a binary expression",
+			expression:         `sum(http_requests_total{code="400"} / on (pod) http_requests_total)`,
+			shardingLabels:     []string{"pod"},
+			shardBy:            true,
+			partialAggregation: "sum",
+		},
+	}
+
 	for _, test := range nonShardable {
 		t.Run(test.name, func(t *testing.T) {
 			analyzer := NewQueryAnalyzer()
 			analysis, err := analyzer.Analyze(test.expression)
 			require.NoError(t, err)
 			require.False(t, analysis.IsShardable())
+			require.NotEmpty(t, analysis.NotShardableReason())
+		})
+	}
+
+	for _, test := range shardableWithPartialAggregation {
+		t.Run(test.name, func(t *testing.T) {
+			analyzer := NewQueryAnalyzer()
+			analysis, err := analyzer.Analyze(test.expression)
+			require.NoError(t, err)
+			require.True(t, analysis.IsShardable())
+			require.Equal(t, test.shardBy, analysis.ShardBy())
+			require.Equal(t, test.partialAggregation, analysis.PartialAggregation())
+
+			sort.Strings(test.shardingLabels)
+			sort.Strings(analysis.ShardingLabels())
+			require.Equal(t, test.shardingLabels, analysis.ShardingLabels())
 		})
 	}
 
@@ -299,6 +366,7 @@ http_requests_total`,
 			require.NoError(t, err)
 			require.True(t, analysis.IsShardable())
 			require.True(t, analysis.ShardBy())
+			require.Empty(t, analysis.PartialAggregation())
 
 			sort.Strings(test.shardingLabels)
 			sort.Strings(analysis.ShardingLabels())
@@ -320,3 +388,21 @@ http_requests_total`,
 		})
 	}
 }
+
+func TestNotShardableReason(t *testing.T) {
+	analyzer := NewQueryAnalyzer()
+	for expression, reason := range map[string]string{
+		`sum by (url) (absent(http_requests_total))`:      "function absent is not shardable",
+		`rate(http_requests_total[5m])`:                   reasonNoGrouping,
+		`http_requests_total / on () http_requests_total`: reasonNoCommonLabels,
+		`sum by (pod) (http_requests_total)`:              "",
+	} {
+		analysis, err := analyzer.Analyze(expression)
+		require.NoError(t, err)
+		require.Equal(t, reason, analysis.NotShardableReason(), expression)
+	}
+
+	analysis, err := analyzer.Analyze(`sum(`)
+	require.Error(t, err)
+	require.Equal(t, reasonParseError, analysis.NotShardableReason())
+}
@@ -261,6 +261,14 @@ func (l *localClient) SupportsWithoutReplicaLabels() bool {
 	return true
 }
 
+func (l *localClient) SupportsSeriesEstimate() bool {
+	return true
+}
+
+func (l *localClient) Shard() string {
+	return ""
+}
+
 type tenant struct {
 	readyS        *ReadyStorage
 	storeTSDB     *store.TSDBStore
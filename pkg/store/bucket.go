@@ -26,6 +26,7 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/gogo/protobuf/types"
 	"github.com/oklog/ulid"
+	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -37,7 +38,9 @@ import (
 	"github.com/prometheus/prometheus/tsdb/index"
 	"github.com/prometheus/prometheus/util/zeropool"
 	"github.com/weaveworks/common/httpgrpc"
+	"go.uber.org/atomic"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -47,6 +50,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/indexheader"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/block/parquet"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/gate"
@@ -164,6 +168,9 @@ type bucketStoreMetrics struct {
 	seriesRefetches       *prometheus.CounterVec
 	chunkRefetches        *prometheus.CounterVec
 	emptyPostingCount     *prometheus.CounterVec
+	parquetRowGroups      *prometheus.CounterVec
+	prewarmedBlocks       prometheus.Counter
+	prewarmedBytes        *prometheus.CounterVec
 
 	lazyExpandedPostingsCount                     prometheus.Counter
 	lazyExpandedPostingGroupsByReason             *prometheus.CounterVec
@@ -356,6 +363,21 @@ func newBucketStoreMetrics(reg prometheus.Registerer) *bucketStoreMetrics {
 		Help: "Total number of empty postings when fetching block series.",
 	}, []string{tenancy.MetricLabel})
 
+	m.parquetRowGroups = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_parquet_row_groups_total",
+		Help: "Total number of Parquet row groups considered when fetching block series, by whether they were read or pruned.",
+	}, []string{"state", tenancy.MetricLabel})
+
+	m.prewarmedBlocks = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_prewarmed_blocks_total",
+		Help: "Total number of blocks whose index-header and postings were prefetched based on their access statistics.",
+	})
+
+	m.prewarmedBytes = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_prewarmed_bytes_total",
+		Help: "Total number of bytes loaded when prefetching blocks, by data type.",
+	}, []string{"data_type"})
+
 	m.lazyExpandedPostingsCount = promauto.With(reg).NewCounter(prometheus.CounterOpts{
 		Name: "thanos_bucket_store_lazy_expanded_postings_total",
 		Help: "Total number of times when lazy expanded posting optimization applies.",
@@ -418,6 +440,8 @@ type BucketStore struct {
 
 	// Query gate which limits the maximum amount of concurrent queries.
 	queryGate gate.Gate
+	// Series scheduler which, if set, is used instead of the query gate to admit Series calls.
+	seriesScheduler *SeriesScheduler
 
 	// chunksLimiterFactory creates a new limiter used to limit the number of chunks fetched by each Series() call.
 	chunksLimiterFactory ChunksLimiterFactory
@@ -426,6 +450,8 @@ type BucketStore struct {
 	seriesLimiterFactory SeriesLimiterFactory
 	// bytesLimiterFactory creates a new limiter used to limit the amount of bytes fetched/touched by each Series() call.
 	bytesLimiterFactory BytesLimiterFactory
+	// tenantLimiter, if set, resolves the limits per tenant instead of the limiter factories.
+	tenantLimiter *TenantLimiter
 
 	partitioner Partitioner
 
@@ -451,10 +477,22 @@ type BucketStore struct {
 	blockEstimatedMaxChunkFunc  BlockEstimator
 
 	indexHeaderLazyDownloadStrategy indexheader.LazyDownloadIndexHeaderFunc
+	indexHeaderFormat               int
 
 	requestLoggerFunc RequestLoggerFunc
 
 	blockLifecycleCallback BlockLifecycleCallback
+
+	// enableParquet serves Series from blocks' Parquet files when they exist.
+	enableParquet bool
+
+	// prewarmBlocks is the number of hottest blocks to prefetch when new blocks are loaded, 0 disables it.
+	prewarmBlocks int
+	// prewarmLimiter limits the bytes per second loaded by prefetching, nil if unlimited.
+	prewarmLimiter *rate.Limiter
+	prewarmMtx     sync.Mutex
+	prewarmRunning bool
+	prewarmWg      sync.WaitGroup
 }
 
 func (s *BucketStore) validate() error {
@@ -533,6 +571,30 @@ func WithQueryGate(queryGate gate.Gate) BucketStoreOption {
 	}
 }
 
+// WithSeriesScheduler sets a SeriesScheduler which admits Series calls by priority and tenant.
+// When set, it takes precedence over the query gate.
+func WithSeriesScheduler(scheduler *SeriesScheduler) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.seriesScheduler = scheduler
+	}
+}
+
+// WithParquet enables serving Series from the Parquet file of a block, if it exists,
+// instead of the TSDB index.
+func WithParquet(enabled bool) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.enableParquet = enabled
+	}
+}
+
+// WithTenantLimiter sets a TenantLimiter which resolves the series, chunks and bytes limits
+// per tenant. When set, it takes precedence over the limiter factories.
+func WithTenantLimiter(limiter *TenantLimiter) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.tenantLimiter = limiter
+	}
+}
+
 // WithChunkPool sets a pool.Bytes to use for chunks.
 func WithChunkPool(chunkPool pool.Pool[byte]) BucketStoreOption {
 	return func(s *BucketStore) {
@@ -618,6 +680,27 @@ func WithIndexHeaderLazyDownloadStrategy(strategy indexheader.LazyDownloadIndexH
 	}
 }
 
+// WithIndexHeaderFormat sets the format version of the index-headers built by the store.
+// Existing index-headers are read in any version.
+func WithIndexHeaderFormat(format int) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.indexHeaderFormat = format
+	}
+}
+
+// WithPrewarm enables prefetching the index-header and most accessed postings of the given number of
+// hottest blocks on startup and whenever new blocks are loaded. Access statistics are persisted in the data dir.
+// A non zero bandwidth limits the bytes per second loaded by prefetching.
+func WithPrewarm(blocks int, bandwidth uint64) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.prewarmBlocks = blocks
+		if bandwidth > 0 {
+			// The burst allows loading a second worth of bytes at once.
+			s.prewarmLimiter = rate.NewLimiter(rate.Limit(bandwidth), int(bandwidth))
+		}
+	}
+}
+
 // BlockLifecycleCallback specifies callbacks that will be called during the lifecycle of a block.
 type BlockLifecycleCallback interface {
 	// PreAdd is called before adding a block to indicate if the block needs to be added.
@@ -681,6 +764,7 @@ func NewBucketStore(
 		seriesBatchSize:                 SeriesBatchSize,
 		sortingStrategy:                 sortingStrategyStore,
 		indexHeaderLazyDownloadStrategy: indexheader.AlwaysEagerDownloadIndexHeader,
+		indexHeaderFormat:               indexheader.BinaryFormatV1,
 		requestLoggerFunc:               NoopRequestLoggerFunc,
 		blockLifecycleCallback:          &noopBlockLifecycleCallback{},
 	}
@@ -691,7 +775,7 @@ func NewBucketStore(
 
 	// Depend on the options
 	indexReaderPoolMetrics := indexheader.NewReaderPoolMetrics(extprom.WrapRegistererWithPrefix("thanos_bucket_store_", s.reg))
-	s.indexReaderPool = indexheader.NewReaderPool(s.logger, lazyIndexReaderEnabled, lazyIndexReaderIdleTimeout, indexReaderPoolMetrics, s.indexHeaderLazyDownloadStrategy)
+	s.indexReaderPool = indexheader.NewReaderPool(s.logger, lazyIndexReaderEnabled, lazyIndexReaderIdleTimeout, indexReaderPoolMetrics, s.indexHeaderLazyDownloadStrategy, s.indexHeaderFormat)
 	s.metrics = newBucketStoreMetrics(s.reg) // TODO(metalmatze): Might be possible via Option too
 
 	if err := s.validate(); err != nil {
@@ -706,11 +790,43 @@ func NewBucketStore(
 		return nil, errors.Wrap(err, "create dir")
 	}
 
+	if s.prewarmBlocks > 0 {
+		if err := s.indexReaderPool.ReadAccessStats(s.accessStatsPath()); err != nil {
+			level.Warn(s.logger).Log("msg", "failed to read block access statistics, starting without", "err", err)
+		}
+	}
+
 	return s, nil
 }
 
+func (s *BucketStore) newChunksLimiter(tenant string) ChunksLimiter {
+	failedCounter := s.metrics.queriesDropped.WithLabelValues("chunks", tenant)
+	if s.tenantLimiter != nil {
+		return s.tenantLimiter.NewChunksLimiter(tenant, failedCounter)
+	}
+	return s.chunksLimiterFactory(failedCounter)
+}
+
+func (s *BucketStore) newSeriesLimiter(tenant string) SeriesLimiter {
+	failedCounter := s.metrics.queriesDropped.WithLabelValues("series", tenant)
+	if s.tenantLimiter != nil {
+		return s.tenantLimiter.NewSeriesLimiter(tenant, failedCounter)
+	}
+	return s.seriesLimiterFactory(failedCounter)
+}
+
+func (s *BucketStore) newBytesLimiter(tenant string) BytesLimiter {
+	failedCounter := s.metrics.queriesDropped.WithLabelValues("bytes", tenant)
+	if s.tenantLimiter != nil {
+		return s.tenantLimiter.NewBytesLimiter(tenant, failedCounter)
+	}
+	return s.bytesLimiterFactory(failedCounter)
+}
+
 // Close the store.
 func (s *BucketStore) Close() (err error) {
+	s.prewarmWg.Wait()
+
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
@@ -731,7 +847,10 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 		return metaFetchErr
 	}
 
-	var wg sync.WaitGroup
+	var (
+		wg    sync.WaitGroup
+		added atomic.Int64
+	)
 	blockc := make(chan *metadata.Meta)
 
 	for i := 0; i < s.blockSyncConcurrency; i++ {
@@ -744,6 +863,7 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 				if err := s.addBlock(ctx, meta); err != nil {
 					continue
 				}
+				added.Inc()
 			}
 			wg.Done()
 		}()
@@ -796,6 +916,17 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 		return strings.Compare(s.advLabelSets[i].String(), s.advLabelSets[j].String()) < 0
 	})
 	s.mtx.Unlock()
+
+	if s.prewarmBlocks > 0 {
+		if s.dir != "" {
+			if err := s.indexReaderPool.WriteAccessStats(s.accessStatsPath()); err != nil {
+				level.Warn(s.logger).Log("msg", "failed to persist block access statistics", "err", err)
+			}
+		}
+		if added.Load() > 0 {
+			s.startPrewarm(ctx)
+		}
+	}
 	return nil
 }
 
@@ -909,6 +1040,11 @@ func (s *BucketStore) addBlock(ctx context.Context, meta *metadata.Meta) (err er
 		}
 	}()
 
+	if s.enableParquet {
+		b.parquetReader = s.openParquetReader(ctx, meta.ULID)
+	}
+	b.readerPool = s.indexReaderPool
+
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
@@ -943,6 +1079,7 @@ func (s *BucketStore) removeBlock(id ulid.ULID) error {
 	}
 
 	s.metrics.blocksLoaded.Dec()
+	s.indexReaderPool.ForgetAccess(id)
 	if err := b.Close(); err != nil {
 		return errors.Wrap(err, "close block")
 	}
@@ -1561,11 +1698,65 @@ func debugFoundBlockSetOverview(logger log.Logger, mint, maxt, maxResolutionMill
 	level.Debug(logger).Log("msg", "Blocks source resolutions", "blocks", len(bs), "Maximum Resolution", maxResolutionMillis, "mint", mint, "maxt", maxt, "lset", lset.String(), "spans", strings.Join(parts, "\n"))
 }
 
+// newBlockRespSet returns a respSet streaming series of a single block from the given client.
+func (s *BucketStore) newBlockRespSet(
+	span opentracing.Span,
+	blk *bucketBlock,
+	onClose func(),
+	client storepb.Store_SeriesClient,
+	shardMatcher *storepb.ShardMatcher,
+	tenant string,
+) respSet {
+	if s.sortingStrategy == sortingStrategyStore {
+		return newEagerRespSet(
+			span,
+			10*time.Minute,
+			blk.meta.ULID.String(),
+			[]labels.Labels{blk.extLset},
+			onClose,
+			client,
+			shardMatcher,
+			false,
+			s.metrics.emptyPostingCount.WithLabelValues(tenant),
+			nil,
+		)
+	}
+	return newLazyRespSet(
+		span,
+		10*time.Minute,
+		blk.meta.ULID.String(),
+		[]labels.Labels{blk.extLset},
+		onClose,
+		client,
+		shardMatcher,
+		false,
+		s.metrics.emptyPostingCount.WithLabelValues(tenant),
+	)
+}
+
 // Series implements the storepb.StoreServer interface.
 func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store_SeriesServer) (err error) {
+	// Estimates are served from index-headers only, so they don't need to wait for their turn.
+	if req.Estimate {
+		return s.estimateSeries(req, seriesSrv)
+	}
+
 	srv := newFlushableServer(seriesSrv, sortingStrategyNone)
 
-	if s.queryGate != nil {
+	tenant, _ := tenancy.GetTenantFromGRPCMetadata(srv.Context())
+
+	var ticket *SeriesTicket
+	if s.seriesScheduler != nil {
+		priority, _ := GetSeriesPriorityFromGRPCMetadata(srv.Context())
+		tracing.DoInSpan(srv.Context(), "store_series_scheduler_ismyturn", func(ctx context.Context) {
+			ticket, err = s.seriesScheduler.Acquire(srv.Context(), tenant, priority)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to wait for turn")
+		}
+
+		defer ticket.Done()
+	} else if s.queryGate != nil {
 		tracing.DoInSpan(srv.Context(), "store_query_gate_ismyturn", func(ctx context.Context) {
 			err = s.queryGate.Start(srv.Context())
 		})
@@ -1576,8 +1767,6 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 		defer s.queryGate.Done()
 	}
 
-	tenant, _ := tenancy.GetTenantFromGRPCMetadata(srv.Context())
-
 	matchers, err := storecache.MatchersToPromMatchersCached(s.matcherCache, req.Matchers...)
 	if err != nil {
 		return status.Error(codes.InvalidArgument, err.Error())
@@ -1586,7 +1775,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 	req.MaxTime = s.limitMaxTime(req.MaxTime)
 
 	var (
-		bytesLimiter     = s.bytesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("bytes", tenant))
+		bytesLimiter     = s.newBytesLimiter(tenant)
 		ctx              = srv.Context()
 		stats            = &queryStats{}
 		respSets         []respSet
@@ -1595,14 +1784,18 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 		resHints         = &hintspb.SeriesResponseHints{}
 		reqBlockMatchers []*labels.Matcher
 
-		chunksLimiter = s.chunksLimiterFactory(s.metrics.queriesDropped.WithLabelValues("chunks", tenant))
-		seriesLimiter = s.seriesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("series", tenant))
+		chunksLimiter = s.newChunksLimiter(tenant)
+		seriesLimiter = s.newSeriesLimiter(tenant)
 
 		queryStatsEnabled = false
 
 		logger = s.requestLoggerFunc(ctx, s.logger)
 	)
 
+	if ticket != nil {
+		bytesLimiter = ticket.TrackBytes(bytesLimiter)
+	}
+
 	if req.Hints != nil {
 		reqHints := &hintspb.SeriesRequestHints{}
 		if err := types.UnmarshalAny(req.Hints, reqHints); err != nil {
@@ -1651,6 +1844,49 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 
 			shardMatcher := req.ShardInfo.Matcher(&s.buffers)
 
+			if blk.parquetReader != nil {
+				parquetClient := newParquetSeriesClient(
+					srv.Context(),
+					log.With(logger, "block", blk.meta.ULID),
+					blk,
+					req,
+					seriesLimiter,
+					chunksLimiter,
+					bytesLimiter,
+					blockMatchers,
+					shardMatcher,
+					s.enableChunkHashCalculation,
+					extLsetToRemove,
+					s.metrics.parquetRowGroups,
+					tenant,
+				)
+
+				g.Go(func() error {
+					span, _ := tracing.StartSpan(gctx, "bucket_store_block_series_parquet", tracing.Tags{
+						"block.id":         blk.meta.ULID,
+						"block.mint":       blk.meta.MinTime,
+						"block.maxt":       blk.meta.MaxTime,
+						"block.resolution": blk.meta.Thanos.Downsample.Resolution,
+					})
+
+					onClose := func() {
+						mtx.Lock()
+						stats = parquetClient.MergeStats(stats)
+						mtx.Unlock()
+					}
+
+					parquetClient.Select()
+					resp := s.newBlockRespSet(span, blk, onClose, parquetClient, shardMatcher, tenant)
+
+					mtx.Lock()
+					respSets = append(respSets, resp)
+					mtx.Unlock()
+
+					return nil
+				})
+				continue
+			}
+
 			blockClient := newBlockSeriesClient(
 				srv.Context(),
 				log.With(logger, "block", blk.meta.ULID),
@@ -1703,33 +1939,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, seriesSrv storepb.Store
 					return errors.Wrapf(err, "fetch postings for block %s", blk.meta.ULID)
 				}
 
-				var resp respSet
-				if s.sortingStrategy == sortingStrategyStore {
-					resp = newEagerRespSet(
-						span,
-						10*time.Minute,
-						blk.meta.ULID.String(),
-						[]labels.Labels{blk.extLset},
-						onClose,
-						blockClient,
-						shardMatcher,
-						false,
-						s.metrics.emptyPostingCount.WithLabelValues(tenant),
-						nil,
-					)
-				} else {
-					resp = newLazyRespSet(
-						span,
-						10*time.Minute,
-						blk.meta.ULID.String(),
-						[]labels.Labels{blk.extLset},
-						onClose,
-						blockClient,
-						shardMatcher,
-						false,
-						s.metrics.emptyPostingCount.WithLabelValues(tenant),
-					)
-				}
+				resp := s.newBlockRespSet(span, blk, onClose, blockClient, shardMatcher, tenant)
 
 				mtx.Lock()
 				respSets = append(respSets, resp)
@@ -1904,8 +2114,8 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 
 	var mtx sync.Mutex
 	var sets [][]string
-	var seriesLimiter = s.seriesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("series", tenant))
-	var bytesLimiter = s.bytesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("bytes", tenant))
+	var seriesLimiter = s.newSeriesLimiter(tenant)
+	var bytesLimiter = s.newBytesLimiter(tenant)
 	var logger = s.requestLoggerFunc(ctx, s.logger)
 
 	for _, b := range s.blocks {
@@ -2128,8 +2338,8 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 
 	var mtx sync.Mutex
 	var sets [][]string
-	var seriesLimiter = s.seriesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("series", tenant))
-	var bytesLimiter = s.bytesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("bytes", tenant))
+	var seriesLimiter = s.newSeriesLimiter(tenant)
+	var bytesLimiter = s.newBytesLimiter(tenant)
 	var logger = s.requestLoggerFunc(ctx, s.logger)
 	var stats = &queryStats{}
 
@@ -2470,6 +2680,12 @@ type bucketBlock struct {
 
 	estimatedMaxChunkSize  int
 	estimatedMaxSeriesSize int
+
+	// parquetReader is set if series can be read from the block's Parquet file.
+	parquetReader *parquet.Reader
+
+	// readerPool records query accesses to the block, if set.
+	readerPool *indexheader.ReaderPool
 }
 
 func newBucketBlock(
@@ -0,0 +1,151 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"math"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	storecache "github.com/thanos-io/thanos/pkg/store/cache"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// estimateSeries responds with a single estimate frame, computed from the index-headers of the queried blocks
+// without fetching any postings, series or chunks. Series spanning multiple blocks are counted once per block,
+// so the estimate is an upper bound.
+func (s *BucketStore) estimateSeries(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	matchers, err := storecache.MatchersToPromMatchersCached(s.matcherCache, req.Matchers...)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	var (
+		mint = s.limitMinTime(req.MinTime)
+		maxt = s.limitMaxTime(req.MaxTime)
+
+		reqBlockMatchers     []*labels.Matcher
+		numSeries, numChunks int64
+	)
+	if req.Hints != nil {
+		reqHints := &hintspb.SeriesRequestHints{}
+		if err := types.UnmarshalAny(req.Hints, reqHints); err != nil {
+			return status.Error(codes.InvalidArgument, errors.Wrap(err, "unmarshal series request hints").Error())
+		}
+		reqBlockMatchers, err = storepb.MatchersToPromMatchers(reqHints.BlockMatchers...)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, errors.Wrap(err, "translate request hints labels matchers").Error())
+		}
+	}
+
+	s.mtx.RLock()
+	for _, bs := range s.blockSets {
+		blockMatchers, ok := bs.labelMatchers(matchers...)
+		if !ok {
+			continue
+		}
+		for _, b := range bs.getFor(mint, maxt, req.MaxResolutionWindow, reqBlockMatchers) {
+			series, chunks, err := b.estimateSeries(blockMatchers, mint, maxt)
+			if err != nil {
+				s.mtx.RUnlock()
+				return status.Error(codes.Internal, errors.Wrapf(err, "estimate series of block %s", b.meta.ULID).Error())
+			}
+			numSeries += series
+			numChunks += chunks
+		}
+	}
+	s.mtx.RUnlock()
+
+	if req.ShardInfo != nil && req.ShardInfo.TotalShards > 1 {
+		numSeries /= req.ShardInfo.TotalShards
+		numChunks /= req.ShardInfo.TotalShards
+	}
+	return srv.Send(storepb.NewEstimateSeriesResponse(numSeries, numChunks))
+}
+
+// estimateSeries estimates the number of series of the block selected by the given matchers, from the sizes of
+// their postings lists, and the number of their chunks within [mint, maxt] from the block stats.
+func (b *bucketBlock) estimateSeries(matchers []*labels.Matcher, mint, maxt int64) (int64, int64, error) {
+	series := int64(-1)
+	for _, m := range matchers {
+		// Matchers selecting series without the label can't narrow down the estimate.
+		if m.Matches("") {
+			continue
+		}
+		n, err := b.estimatePostings(m)
+		if err != nil {
+			return 0, 0, err
+		}
+		if series < 0 || n < series {
+			series = n
+		}
+	}
+	if series < 0 {
+		n, err := b.postingsSize(index.AllPostingsKey())
+		if err != nil {
+			return 0, 0, err
+		}
+		series = n
+	}
+
+	stats := b.meta.Stats
+	if stats.NumSeries > 0 && uint64(series) > stats.NumSeries {
+		series = int64(stats.NumSeries)
+	}
+	if series == 0 || stats.NumSeries == 0 {
+		// Without stats, assume a single chunk per series.
+		return series, series, nil
+	}
+
+	overlap := 1.0
+	if span := b.meta.MaxTime - b.meta.MinTime; span > 0 {
+		overlap = float64(min(maxt, b.meta.MaxTime)-max(mint, b.meta.MinTime)) / float64(span)
+	}
+	chunksPerSeries := max(float64(stats.NumChunks)/float64(stats.NumSeries)*overlap, 1)
+	return series, int64(math.Ceil(float64(series) * chunksPerSeries)), nil
+}
+
+// estimatePostings returns the number of postings matching the given matcher, according to the index-header.
+func (b *bucketBlock) estimatePostings(m *labels.Matcher) (int64, error) {
+	if m.Type == labels.MatchEqual {
+		return b.postingsSize(m.Name, m.Value)
+	}
+
+	vals, err := b.indexHeaderReader.LabelValues(m.Name)
+	if err != nil {
+		return 0, errors.Wrapf(err, "label values of %s", m.Name)
+	}
+	matching := make([]string, 0, len(vals))
+	for _, v := range vals {
+		if m.Matches(v) {
+			matching = append(matching, v)
+		}
+	}
+	return b.postingsSize(m.Name, matching...)
+}
+
+// postingsSize returns the number of postings of the given label values, derived from the size of their lists.
+func (b *bucketBlock) postingsSize(name string, values ...string) (int64, error) {
+	if len(values) == 0 {
+		return 0, nil
+	}
+	rngs, err := b.indexHeaderReader.PostingsOffsets(name, values...)
+	if err != nil {
+		return 0, errors.Wrapf(err, "postings offsets of %s", name)
+	}
+	var n int64
+	for _, r := range rngs {
+		// Postings lists start with the number of postings, followed by 4 bytes per posting.
+		if r.End-r.Start <= 4 {
+			continue
+		}
+		n += (r.End-r.Start)/4 - 1
+	}
+	return n, nil
+}
@@ -0,0 +1,81 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore/providers/filesystem"
+
+	"github.com/thanos-io/thanos/pkg/block/indexheader"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	storetestutil "github.com/thanos-io/thanos/pkg/store/storepb/testutil"
+)
+
+func TestBucketBlock_EstimateSeries(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	bkt, err := filesystem.NewBucket(filepath.Join(tmpDir, "bkt"))
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bkt.Close()) }()
+
+	id := uploadTestBlock(t, tmpDir, bkt, 500)
+	meta, err := metadata.ReadFromDir(filepath.Join(tmpDir, "tmp", id.String()))
+	testutil.Ok(t, err)
+
+	r, err := indexheader.NewBinaryReader(context.Background(), log.NewNopLogger(), bkt, tmpDir, id, DefaultPostingOffsetInMemorySampling, indexheader.NewBinaryReaderMetrics(nil), indexheader.BinaryFormatV1)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, r.Close()) }()
+
+	b := &bucketBlock{meta: meta, indexHeaderReader: r}
+
+	for _, tcase := range []struct {
+		name     string
+		matchers []*labels.Matcher
+		expected int64
+	}{
+		{
+			name:     "equal",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "j", "foo")},
+			expected: 200,
+		},
+		{
+			name:     "regex",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "j", "foo|bar")},
+			expected: 500,
+		},
+		{
+			name: "smallest postings list",
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, "j", "foo"),
+				labels.MustNewMatcher(labels.MatchEqual, "n", "1"+storetestutil.LabelLongSuffix),
+			},
+			expected: 20,
+		},
+		{
+			name:     "not equal",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotEqual, "j", "foo")},
+			expected: 500,
+		},
+		{
+			name:     "missing value",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "j", "baz")},
+			expected: 0,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			series, chunks, err := b.estimateSeries(tcase.matchers, math.MinInt64, math.MaxInt64)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, series)
+			// Every series of the block has a single chunk.
+			testutil.Equals(t, tcase.expected, chunks)
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"io"
+	"path"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/weaveworks/common/httpgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/parquet"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// openParquetReader returns a reader for the Parquet file of the given block, or nil if the
+// block has no usable Parquet file, in which case series are read through the TSDB index.
+func (s *BucketStore) openParquetReader(ctx context.Context, id ulid.ULID) *parquet.Reader {
+	r, err := parquet.NewReader(ctx, s.bkt, path.Join(id.String(), block.ParquetFilename))
+	if err == nil {
+		return r
+	}
+	if s.bkt.IsObjNotFoundErr(errors.Cause(err)) {
+		level.Debug(s.logger).Log("msg", "no parquet file for block, falling back to TSDB index", "id", id)
+	} else {
+		level.Warn(s.logger).Log("msg", "failed to open parquet file for block, falling back to TSDB index", "id", id, "err", err)
+	}
+	return nil
+}
+
+// parquetSeriesClient is a storepb.Store_SeriesClient for a
+// single block served from its Parquet file in object storage.
+type parquetSeriesClient struct {
+	grpc.ClientStream
+	ctx             context.Context
+	logger          log.Logger
+	reader          *parquet.Reader
+	extLset         labels.Labels
+	extLsetToRemove map[string]struct{}
+
+	mint           int64
+	maxt           int64
+	seriesLimit    int
+	skipChunks     bool
+	loadAggregates []storepb.Aggr
+	matchers       []*labels.Matcher
+	shardMatcher   *storepb.ShardMatcher
+
+	seriesLimiter SeriesLimiter
+	chunksLimiter ChunksLimiter
+	bytesLimiter  BytesLimiter
+
+	calculateChunkHash bool
+	rowGroups          *prometheus.CounterVec
+	tenant             string
+
+	// Internal state.
+	stats         *queryStats
+	rows          *parquet.RowSet
+	limitErr      error
+	seriesMatched int
+}
+
+func newParquetSeriesClient(
+	ctx context.Context,
+	logger log.Logger,
+	b *bucketBlock,
+	req *storepb.SeriesRequest,
+	seriesLimiter SeriesLimiter,
+	chunksLimiter ChunksLimiter,
+	bytesLimiter BytesLimiter,
+	matchers []*labels.Matcher,
+	shardMatcher *storepb.ShardMatcher,
+	calculateChunkHash bool,
+	extLsetToRemove map[string]struct{},
+	rowGroups *prometheus.CounterVec,
+	tenant string,
+) *parquetSeriesClient {
+	extLset := b.extLset
+	if extLsetToRemove != nil {
+		extLset = rmLabels(extLset.Copy(), extLsetToRemove)
+	}
+
+	return &parquetSeriesClient{
+		ctx:             ctx,
+		logger:          logger,
+		reader:          b.parquetReader,
+		extLset:         extLset,
+		extLsetToRemove: extLsetToRemove,
+
+		mint:           req.MinTime,
+		maxt:           req.MaxTime,
+		seriesLimit:    int(req.Limit),
+		skipChunks:     req.SkipChunks,
+		loadAggregates: req.Aggregates,
+		matchers:       matchers,
+		shardMatcher:   shardMatcher,
+
+		seriesLimiter: seriesLimiter,
+		chunksLimiter: chunksLimiter,
+		bytesLimiter:  bytesLimiter,
+
+		calculateChunkHash: calculateChunkHash,
+		rowGroups:          rowGroups,
+		tenant:             tenant,
+
+		stats: &queryStats{},
+	}
+}
+
+// Select prepares the selection of the matching series, which are read from the Parquet file
+// one row group at a time as they are received.
+func (c *parquetSeriesClient) Select() {
+	c.rows = c.reader.Select(c.ctx, c.mint, c.maxt, c.skipChunks, c.matchers, func(column string, size uint64) error {
+		dataType := SeriesFetched
+		if column == parquet.ChunksColumn {
+			dataType = ChunksFetched
+		}
+		c.limitErr = c.bytesLimiter.ReserveWithType(size, dataType)
+		return c.limitErr
+	})
+}
+
+func noopSave(b []byte) ([]byte, error) {
+	return b, nil
+}
+
+func (c *parquetSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	for {
+		if c.seriesLimit > 0 && c.seriesMatched >= c.seriesLimit {
+			return nil, io.EOF
+		}
+		begin := time.Now()
+		ok := c.rows.Next()
+		c.stats.SeriesFetchDurationSum += time.Since(begin)
+		if !ok {
+			if c.limitErr != nil {
+				return nil, httpgrpc.Errorf(int(codes.ResourceExhausted), "exceeded bytes limit while fetching parquet columns: %s", c.limitErr)
+			}
+			if err := c.rows.Err(); err != nil {
+				return nil, errors.Wrap(err, "select series")
+			}
+			return nil, io.EOF
+		}
+		row := c.rows.At()
+
+		completeLabelset := labelpb.ExtendSortedLabels(row.Labels, c.extLset)
+		if c.extLsetToRemove != nil {
+			completeLabelset = rmLabels(completeLabelset, c.extLsetToRemove)
+		}
+		if !c.shardMatcher.MatchesLabels(completeLabelset) {
+			continue
+		}
+		c.seriesMatched++
+
+		// Limits are reserved as the series are received, before their chunks are decoded.
+		if err := c.seriesLimiter.Reserve(1); err != nil {
+			return nil, httpgrpc.Errorf(int(codes.ResourceExhausted), "exceeded series limit: %s", err)
+		}
+		s := &storepb.Series{Labels: labelpb.ZLabelsFromPromLabels(completeLabelset)}
+		if !c.skipChunks {
+			if err := c.chunksLimiter.Reserve(uint64(len(row.Chunks))); err != nil {
+				return nil, httpgrpc.Errorf(int(codes.ResourceExhausted), "exceeded chunks limit: %s", err)
+			}
+			s.Chunks = make([]storepb.AggrChunk, len(row.Chunks))
+			for i, chk := range row.Chunks {
+				s.Chunks[i] = storepb.AggrChunk{MinTime: chk.MinTime, MaxTime: chk.MaxTime}
+				raw := rawChunk(append([]byte{byte(chk.Encoding)}, chk.Data...))
+				if err := populateChunk(&s.Chunks[i], &raw, c.loadAggregates, noopSave, c.calculateChunkHash); err != nil {
+					return nil, errors.Wrapf(err, "populate chunk of series %s", row.Labels)
+				}
+			}
+			c.stats.chunksTouched += len(row.Chunks)
+			c.stats.chunksFetched += len(row.Chunks)
+		}
+		return storepb.NewSeriesResponse(s), nil
+	}
+}
+
+func (c *parquetSeriesClient) CloseSend() error {
+	return nil
+}
+
+// MergeStats merges the stats of the row groups read, it must be called once when the client is closed.
+func (c *parquetSeriesClient) MergeStats(stats *queryStats) *queryStats {
+	if c.rows != nil {
+		st := c.rows.Stats()
+		c.rowGroups.WithLabelValues("read", c.tenant).Add(float64(st.RowGroupsRead))
+		c.rowGroups.WithLabelValues("pruned", c.tenant).Add(float64(st.RowGroupsTotal - st.RowGroupsRead))
+		c.stats.seriesTouched += st.RowsRead
+		c.stats.seriesFetched += st.RowsMatched
+		c.stats.SeriesFetchedSizeSum += units.Base2Bytes(st.SeriesBytesFetched)
+		c.stats.ChunksFetchedSizeSum += units.Base2Bytes(st.ChunkBytesFetched)
+	}
+	stats.merge(c.stats)
+	return stats
+}
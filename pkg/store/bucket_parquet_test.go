@@ -0,0 +1,178 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/block/parquet"
+	storecache "github.com/thanos-io/thanos/pkg/store/cache"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestBucketStore_Parquet(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	logger := log.NewNopLogger()
+
+	bkt, err := filesystem.NewBucket(filepath.Join(tmpDir, "bucket"))
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, bkt.Close()) })
+
+	var series []labels.Labels
+	for _, job := range []string{"a", "b", "c"} {
+		for _, instance := range []string{"1", "2", "3", "4"} {
+			series = append(series, labels.FromStrings("__name__", "up", "job", job, "instance", instance))
+		}
+	}
+
+	// The first block has a Parquet file, the second one is served through the TSDB index.
+	// Series are sorted by instance first, so each row group holds the series of one instance.
+	blocksDir := filepath.Join(tmpDir, "blocks")
+	id1, err := e2eutil.CreateBlock(ctx, blocksDir, series, 100, 0, 1000, labels.FromStrings("ext", "1"), 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	id2, err := e2eutil.CreateBlock(ctx, blocksDir, series, 100, 1000, 2000, labels.FromStrings("ext", "1"), 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	for _, id := range []string{id1.String(), id2.String()} {
+		testutil.Ok(t, block.Upload(ctx, logger, bkt, filepath.Join(blocksDir, id), metadata.NoneFunc))
+	}
+	testutil.Ok(t, parquet.ConvertAndUpload(ctx, logger, bkt, filepath.Join(blocksDir, id1.String()), 3))
+
+	newStore := func(reg prometheus.Registerer, enableParquet bool, seriesLimit uint64) *BucketStore {
+		instrBkt := objstore.WithNoopInstr(bkt)
+		dir := t.TempDir()
+		fetcher, err := block.NewMetaFetcher(logger, 10, instrBkt, block.NewConcurrentLister(logger, instrBkt), dir, nil, nil)
+		testutil.Ok(t, err)
+		indexCache, err := storecache.NewInMemoryIndexCacheWithConfig(logger, nil, nil, storecache.InMemoryIndexCacheConfig{})
+		testutil.Ok(t, err)
+
+		store, err := NewBucketStore(
+			instrBkt,
+			fetcher,
+			dir,
+			NewChunksLimiterFactory(0),
+			NewSeriesLimiterFactory(seriesLimit),
+			NewBytesLimiterFactory(0),
+			NewGapBasedPartitioner(PartitionerMaxGapSize),
+			10,
+			false,
+			DefaultPostingOffsetInMemorySampling,
+			true,
+			false,
+			0,
+			WithLogger(logger),
+			WithRegistry(reg),
+			WithIndexCache(indexCache),
+			WithParquet(enableParquet),
+		)
+		testutil.Ok(t, err)
+		testutil.Ok(t, store.SyncBlocks(ctx))
+		return store
+	}
+
+	reg := prometheus.NewRegistry()
+	parquetStore := newStore(reg, true, 0)
+	indexStore := newStore(nil, false, 0)
+
+	testutil.Assert(t, parquetStore.getBlock(id1).parquetReader != nil)
+	testutil.Assert(t, parquetStore.getBlock(id2).parquetReader == nil)
+
+	for _, tcase := range []struct {
+		name       string
+		req        *storepb.SeriesRequest
+		numSeries  int
+		rowsPruned bool
+	}{
+		{
+			name: "all series",
+			req: &storepb.SeriesRequest{
+				MinTime:  0,
+				MaxTime:  2000,
+				Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"}},
+			},
+			numSeries: 12,
+		},
+		{
+			name: "single job",
+			req: &storepb.SeriesRequest{
+				MinTime:  0,
+				MaxTime:  2000,
+				Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "b"}},
+			},
+			numSeries: 4,
+		},
+		{
+			name: "regex and skip chunks",
+			req: &storepb.SeriesRequest{
+				MinTime:    0,
+				MaxTime:    500,
+				SkipChunks: true,
+				Matchers: []storepb.LabelMatcher{
+					{Type: storepb.LabelMatcher_RE, Name: "instance", Value: "1|2"},
+					{Type: storepb.LabelMatcher_NEQ, Name: "job", Value: "a"},
+				},
+			},
+			numSeries:  4,
+			rowsPruned: true,
+		},
+		{
+			name: "without replica labels",
+			req: &storepb.SeriesRequest{
+				MinTime:              0,
+				MaxTime:              2000,
+				WithoutReplicaLabels: []string{"ext"},
+				Matchers:             []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "instance", Value: "2"}},
+			},
+			numSeries:  3,
+			rowsPruned: true,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			prunedBefore := prom_testutil.ToFloat64(parquetStore.metrics.parquetRowGroups.WithLabelValues("pruned", tenancy.DefaultTenant))
+
+			parquetSrv := newStoreSeriesServer(ctx)
+			testutil.Ok(t, parquetStore.Series(tcase.req, parquetSrv))
+			indexSrv := newStoreSeriesServer(ctx)
+			testutil.Ok(t, indexStore.Series(tcase.req, indexSrv))
+
+			testutil.Equals(t, tcase.numSeries, len(parquetSrv.SeriesSet))
+			testutil.Equals(t, indexSrv.SeriesSet, parquetSrv.SeriesSet)
+
+			pruned := prom_testutil.ToFloat64(parquetStore.metrics.parquetRowGroups.WithLabelValues("pruned", tenancy.DefaultTenant)) - prunedBefore
+			testutil.Equals(t, tcase.rowsPruned, pruned > 0)
+		})
+	}
+
+	t.Run("series limit is reserved before reading all row groups", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		limitedStore := newStore(reg, true, 2)
+
+		// Only the Parquet block is queried, its first row group holds 3 series.
+		err := limitedStore.Series(&storepb.SeriesRequest{
+			MinTime:  0,
+			MaxTime:  500,
+			Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"}},
+		}, newStoreSeriesServer(ctx))
+		testutil.NotOk(t, err)
+		testutil.Assert(t, strings.Contains(err.Error(), "exceeded series limit"), err.Error())
+		testutil.Equals(t, 1.0, prom_testutil.ToFloat64(limitedStore.metrics.parquetRowGroups.WithLabelValues("read", tenancy.DefaultTenant)))
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block/indexheader"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+// accessStatsFilename is the name of the file in the data dir holding the persisted block access statistics.
+const accessStatsFilename = "index-header-access-stats.json"
+
+func (s *BucketStore) accessStatsPath() string {
+	return filepath.Join(s.dir, accessStatsFilename)
+}
+
+// recordAccess records that a query looked up the given postings of the block.
+func (b *bucketBlock) recordAccess(keys []labels.Label) {
+	if b.readerPool == nil {
+		return
+	}
+	b.readerPool.RecordAccess(b.meta.ULID, keys)
+}
+
+// startPrewarm prefetches the hottest blocks in the background, unless a prewarm is already running.
+func (s *BucketStore) startPrewarm(ctx context.Context) {
+	s.prewarmMtx.Lock()
+	defer s.prewarmMtx.Unlock()
+
+	if s.prewarmRunning {
+		return
+	}
+	s.prewarmRunning = true
+	s.prewarmWg.Add(1)
+
+	go func() {
+		defer func() {
+			s.prewarmMtx.Lock()
+			s.prewarmRunning = false
+			s.prewarmMtx.Unlock()
+			s.prewarmWg.Done()
+		}()
+
+		start := time.Now()
+		n, err := s.prewarm(ctx)
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "prewarming blocks stopped", "prewarmed", n, "err", err)
+			return
+		}
+		level.Info(s.logger).Log("msg", "prewarmed blocks", "prewarmed", n, "elapsed", time.Since(start))
+	}()
+}
+
+// prewarm loads the index-header and the most accessed postings of the hottest blocks served by the store,
+// one block at a time and within the configured bandwidth. It returns the number of prewarmed blocks.
+func (s *BucketStore) prewarm(ctx context.Context) (int, error) {
+	prewarmed := 0
+	for _, stats := range s.indexReaderPool.HottestBlocks(0) {
+		if prewarmed >= s.prewarmBlocks {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return prewarmed, err
+		}
+
+		b := s.getBlock(stats.ID)
+		if b == nil {
+			continue
+		}
+		if err := s.prewarmBlock(ctx, b, stats.Postings); err != nil {
+			level.Warn(s.logger).Log("msg", "failed to prewarm block", "block", stats.ID, "err", err)
+			continue
+		}
+		prewarmed++
+		s.metrics.prewarmedBlocks.Inc()
+	}
+	return prewarmed, nil
+}
+
+func (s *BucketStore) prewarmBlock(ctx context.Context, b *bucketBlock, keys []labels.Label) error {
+	if r, ok := b.indexHeaderReader.(*indexheader.LazyBinaryReader); ok {
+		size, known := r.PrefetchSize()
+		if err := s.waitPrewarm(ctx, size); err != nil {
+			return err
+		}
+		n, err := r.Prefetch()
+		if err != nil {
+			return err
+		}
+		s.metrics.prewarmedBytes.WithLabelValues("index-header").Add(float64(n))
+		if !known {
+			// The index-header was downloaded, the next loads wait for its bytes.
+			s.chargePrewarm(int64(n))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var size int64
+	for _, k := range keys {
+		if rng, err := b.indexHeaderReader.PostingsOffset(k.Name, k.Value); err == nil {
+			size += rng.End - rng.Start
+		}
+	}
+	if err := s.waitPrewarm(ctx, size); err != nil {
+		return err
+	}
+
+	indexr := b.indexReader(s.logger)
+	defer runutil.CloseWithLogOnErr(s.logger, indexr, "prewarm index reader")
+
+	_, closeFns, err := indexr.fetchPostings(ctx, keys, NewBytesLimiterFactory(0)(nil), tenancy.DefaultTenant)
+	for _, closeFn := range closeFns {
+		closeFn()
+	}
+	if err != nil {
+		return err
+	}
+	s.metrics.prewarmedBytes.WithLabelValues("postings").Add(float64(indexr.stats.PostingsFetchedSizeSum))
+	return nil
+}
+
+// waitPrewarm waits until n bytes can be loaded within the prewarm bandwidth, and takes them from it.
+func (s *BucketStore) waitPrewarm(ctx context.Context, n int64) error {
+	if s.prewarmLimiter == nil {
+		return nil
+	}
+	for burst := int64(s.prewarmLimiter.Burst()); n > 0; n -= burst {
+		if err := s.prewarmLimiter.WaitN(ctx, int(min(n, burst))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chargePrewarm takes n bytes already loaded from the prewarm bandwidth without waiting, so that the next
+// loads wait for them.
+func (s *BucketStore) chargePrewarm(n int64) {
+	if s.prewarmLimiter == nil {
+		return
+	}
+	now := time.Now()
+	for burst := int64(s.prewarmLimiter.Burst()); n > 0; n -= burst {
+		s.prewarmLimiter.ReserveN(now, int(min(n, burst)))
+	}
+}
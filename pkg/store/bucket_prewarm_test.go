@@ -0,0 +1,128 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestBucketStore_Prewarm(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	logger := log.NewNopLogger()
+
+	bkt, err := filesystem.NewBucket(filepath.Join(tmpDir, "bucket"))
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, bkt.Close()) })
+
+	var series []labels.Labels
+	for _, job := range []string{"a", "b", "c"} {
+		series = append(series, labels.FromStrings("__name__", "up", "job", job))
+	}
+
+	blocksDir := filepath.Join(tmpDir, "blocks")
+	cold, err := e2eutil.CreateBlock(ctx, blocksDir, series, 100, 0, 1000, labels.FromStrings("ext", "1"), 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	hot, err := e2eutil.CreateBlock(ctx, blocksDir, series, 100, 1000, 2000, labels.FromStrings("ext", "1"), 0, metadata.NoneFunc, nil)
+	testutil.Ok(t, err)
+	for _, id := range []string{cold.String(), hot.String()} {
+		testutil.Ok(t, block.Upload(ctx, logger, bkt, filepath.Join(blocksDir, id), metadata.NoneFunc))
+	}
+
+	// Both stores share the data dir, like a Store Gateway being restarted.
+	dir := filepath.Join(tmpDir, "data")
+	newStore := func() *BucketStore {
+		instrBkt := objstore.WithNoopInstr(bkt)
+		fetcher, err := block.NewMetaFetcher(logger, 10, instrBkt, block.NewConcurrentLister(logger, instrBkt), dir, nil, nil)
+		testutil.Ok(t, err)
+
+		store, err := NewBucketStore(
+			instrBkt,
+			fetcher,
+			dir,
+			NewChunksLimiterFactory(0),
+			NewSeriesLimiterFactory(0),
+			NewBytesLimiterFactory(0),
+			NewGapBasedPartitioner(PartitionerMaxGapSize),
+			10,
+			false,
+			DefaultPostingOffsetInMemorySampling,
+			true,
+			true,
+			0,
+			WithLogger(logger),
+			WithPrewarm(1, 0),
+		)
+		testutil.Ok(t, err)
+		testutil.Ok(t, store.SyncBlocks(ctx))
+		store.prewarmWg.Wait()
+		return store
+	}
+
+	store := newStore()
+	testutil.Equals(t, float64(0), prom_testutil.ToFloat64(store.metrics.prewarmedBlocks))
+
+	// Query the hot block only.
+	srv := newStoreSeriesServer(ctx)
+	testutil.Ok(t, store.Series(&storepb.SeriesRequest{
+		MinTime:  1000,
+		MaxTime:  2000,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "b"}},
+	}, srv))
+	testutil.Equals(t, 1, len(srv.SeriesSet))
+
+	hottest := store.indexReaderPool.HottestBlocks(0)
+	testutil.Equals(t, 1, len(hottest))
+	testutil.Equals(t, hot, hottest[0].ID)
+	testutil.Equals(t, []labels.Label{{Name: "job", Value: "b"}}, hottest[0].Postings)
+
+	// Syncing persists the access statistics.
+	testutil.Ok(t, store.SyncBlocks(ctx))
+	testutil.Ok(t, store.Close())
+
+	restarted := newStore()
+	defer func() { testutil.Ok(t, restarted.Close()) }()
+
+	testutil.Equals(t, float64(1), prom_testutil.ToFloat64(restarted.metrics.prewarmedBlocks))
+	testutil.Assert(t, prom_testutil.ToFloat64(restarted.metrics.prewarmedBytes.WithLabelValues("index-header")) > 0)
+	testutil.Assert(t, prom_testutil.ToFloat64(restarted.metrics.prewarmedBytes.WithLabelValues("postings")) > 0)
+}
+
+func TestBucketStore_WaitPrewarm(t *testing.T) {
+	t.Parallel()
+
+	s := &BucketStore{}
+	WithPrewarm(1, 100)(s)
+
+	// The burst of a second of bandwidth is available at once.
+	testutil.Ok(t, s.waitPrewarm(context.Background(), 100))
+
+	// The next bytes are only available after the bandwidth refills the bucket.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	testutil.NotOk(t, s.waitPrewarm(ctx, 50))
+
+	// Bytes charged after loading are waited for by the next loads.
+	s = &BucketStore{}
+	WithPrewarm(1, 100)(s)
+	s.chargePrewarm(250)
+	testutil.NotOk(t, s.waitPrewarm(ctx, 1))
+}
@@ -0,0 +1,235 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+type fanoutTrackerKey struct{}
+
+// FanoutTracker records how the Series requests made while evaluating a query were fanned out to stores.
+type FanoutTracker struct {
+	mtx     sync.Mutex
+	selects []*SelectFanout
+}
+
+// NewFanoutTracker returns a new FanoutTracker.
+func NewFanoutTracker() *FanoutTracker {
+	return &FanoutTracker{}
+}
+
+// WithFanoutTracker returns a context recording the fan-out of the Series requests made with it to the tracker.
+func WithFanoutTracker(ctx context.Context, t *FanoutTracker) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, fanoutTrackerKey{}, t)
+}
+
+// FanoutTrackerFromContext returns the tracker carried by the context, if any.
+func FanoutTrackerFromContext(ctx context.Context) *FanoutTracker {
+	t, _ := ctx.Value(fanoutTrackerKey{}).(*FanoutTracker)
+	return t
+}
+
+// Selects returns the fan-out of the Series requests recorded so far, in the order the requests were made.
+func (t *FanoutTracker) Selects() []SelectFanout {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	res := make([]SelectFanout, 0, len(t.selects))
+	for _, sel := range t.selects {
+		s := *sel
+		s.Stores = make([]*StoreFanout, 0, len(sel.Stores))
+		for _, st := range sel.Stores {
+			c := *st
+			if st.QueryStats != nil {
+				qs := *st.QueryStats
+				c.QueryStats = &qs
+			}
+			s.Stores = append(s.Stores, &c)
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// newSelectFanout starts recording the fan-out of a Series request, if the context carries a tracker.
+func newSelectFanout(ctx context.Context, req *storepb.SeriesRequest) *SelectFanout {
+	t := FanoutTrackerFromContext(ctx)
+	if t == nil {
+		return nil
+	}
+	matchers, err := storepb.MatchersToPromMatchers(req.Matchers...)
+	if err != nil {
+		return nil
+	}
+
+	sel := &SelectFanout{Matchers: matchers, MinTime: req.MinTime, MaxTime: req.MaxTime, tracker: t}
+	t.mtx.Lock()
+	t.selects = append(t.selects, sel)
+	t.mtx.Unlock()
+	return sel
+}
+
+type selectFanoutKey struct{}
+
+func withSelectFanout(ctx context.Context, sel *SelectFanout) context.Context {
+	if sel == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, selectFanoutKey{}, sel)
+}
+
+func selectFanoutFromContext(ctx context.Context) *SelectFanout {
+	sel, _ := ctx.Value(selectFanoutKey{}).(*SelectFanout)
+	return sel
+}
+
+// SelectFanout describes how a Series request was fanned out to stores.
+type SelectFanout struct {
+	Matchers []*labels.Matcher
+	MinTime  int64
+	MaxTime  int64
+	Stores   []*StoreFanout
+
+	tracker *FanoutTracker
+}
+
+// StoreFanout describes the Series call made to a store, or the reason why the store was pruned.
+type StoreFanout struct {
+	Store string
+	// PrunedReason is the reason why the store was not queried. Empty for queried stores.
+	PrunedReason string
+	// Duration is the time taken to receive the whole response of the store.
+	Duration time.Duration
+	Stats    storepb.SeriesStatsCounter
+	// Err is the error the Series call failed with, if any.
+	Err error
+	// QueryStats are the statistics reported by stores supporting them, e.g. BucketStore.
+	QueryStats *hintspb.QueryStats
+}
+
+// Code returns the gRPC code classifying the failure of the Series call, or codes.OK if it succeeded.
+// Calls interrupted by the cancellation or the deadline of the query are classified as such even when
+// the store did not answer with a gRPC status.
+func (st *StoreFanout) Code() codes.Code {
+	switch {
+	case st.Err == nil:
+		return codes.OK
+	case errors.Is(st.Err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(st.Err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	}
+	if s, ok := status.FromError(st.Err); ok {
+		return s.Code()
+	}
+	return codes.Unknown
+}
+
+func (sel *SelectFanout) add(st *StoreFanout) *StoreFanout {
+	sel.tracker.mtx.Lock()
+	defer sel.tracker.mtx.Unlock()
+
+	sel.Stores = append(sel.Stores, st)
+	return st
+}
+
+func (sel *SelectFanout) update(f func()) {
+	sel.tracker.mtx.Lock()
+	defer sel.tracker.mtx.Unlock()
+
+	f()
+}
+
+func (sel *SelectFanout) pruned(st Client, reason string) {
+	if sel == nil {
+		return
+	}
+	sel.add(&StoreFanout{Store: st.String(), PrunedReason: reason})
+}
+
+// trackStores wraps the stores with clients recording the Series calls made to them.
+func (sel *SelectFanout) trackStores(stores []Client) []Client {
+	res := make([]Client, 0, len(stores))
+	for _, st := range stores {
+		res = append(res, &fanoutClient{Client: st, sel: sel})
+	}
+	return res
+}
+
+// queryStatsHints returns the request hints asking stores to report query statistics.
+func queryStatsHints() (*types.Any, error) {
+	return types.MarshalAny(&hintspb.SeriesRequestHints{EnableQueryStats: true})
+}
+
+type fanoutClient struct {
+	Client
+
+	sel *SelectFanout
+}
+
+func (c *fanoutClient) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	st := c.sel.add(&StoreFanout{Store: c.Client.String()})
+
+	start := time.Now()
+	cl, err := c.Client.Series(ctx, req, opts...)
+	if err != nil {
+		c.sel.update(func() {
+			st.Duration = time.Since(start)
+			st.Err = err
+		})
+		return nil, err
+	}
+	return &fanoutSeriesClient{Store_SeriesClient: cl, sel: c.sel, st: st, start: start}, nil
+}
+
+// fanoutSeriesClient records the statistics of the responses received from a store.
+type fanoutSeriesClient struct {
+	storepb.Store_SeriesClient
+
+	sel   *SelectFanout
+	st    *StoreFanout
+	start time.Time
+}
+
+func (c *fanoutSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	resp, err := c.Store_SeriesClient.Recv()
+	c.sel.update(func() {
+		switch {
+		case errors.Is(err, io.EOF):
+			c.st.Duration = time.Since(c.start)
+		case err != nil:
+			c.st.Duration = time.Since(c.start)
+			c.st.Err = err
+		case resp.GetSeries() != nil:
+			c.st.Stats.Count(resp.GetSeries())
+		case resp.GetHints() != nil:
+			var hints hintspb.SeriesResponseHints
+			if types.UnmarshalAny(resp.GetHints(), &hints) != nil || hints.QueryStats == nil {
+				return
+			}
+			if c.st.QueryStats == nil {
+				c.st.QueryStats = &hintspb.QueryStats{}
+			}
+			c.st.QueryStats.Merge(hints.QueryStats)
+		}
+	})
+	return resp, err
+}
@@ -0,0 +1,276 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// HedgingConfig configures the hedging of Series calls across stores advertising identical label sets.
+type HedgingConfig struct {
+	// UpTo is the maximum number of hedged calls made after the first one. Zero disables hedging.
+	UpTo int
+	// Quantile of the time to first response of the store after which the call is hedged.
+	Quantile float64
+	// MinDelay is the minimum time after which the call is hedged. It is used as delay until
+	// enough calls were observed to estimate the latency of the store.
+	MinDelay time.Duration
+}
+
+// HealthTracker is implemented by clients tracking the health of the calls made to their store.
+type HealthTracker interface {
+	// SeriesLatency returns the given quantile of the time to first response of Series calls,
+	// or false if not enough calls were observed.
+	SeriesLatency(quantile float64) (time.Duration, bool)
+	// SeriesHedged records that a Series call to the store was hedged.
+	SeriesHedged()
+	// CircuitOpen returns true if calls to the store are rejected by its circuit breaker.
+	CircuitOpen() bool
+}
+
+// WithSeriesHedging enables hedging of Series calls across stores advertising identical label sets, time ranges
+// and shards.
+func WithSeriesHedging(cfg HedgingConfig) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.hedging = cfg
+	}
+}
+
+// hedgedStores replaces the stores advertising identical, non-empty, label sets, identical time ranges and TSDBs and
+// the same shard with a single client hedging Series calls across them.
+func (s *ProxyStore) hedgedStores(stores []Client) []Client {
+	var (
+		groups = make(map[string][]Client, len(stores))
+		keys   = make([]string, 0, len(stores))
+		res    = make([]Client, 0, len(stores))
+	)
+	for _, st := range stores {
+		lsets := st.LabelSets()
+		if len(lsets) == 0 {
+			res = append(res, st)
+			continue
+		}
+		key := storeDataKey(st, lsets, nil)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], st)
+	}
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) == 1 {
+			res = append(res, group[0])
+			continue
+		}
+		res = append(res, newHedgedClient(group, s.hedging, s.metrics))
+	}
+	return res
+}
+
+// storeDataKey returns a key identifying the data exposed by the store with the given label sets. Stores with equal
+// keys expose the same data, as far as their Info API tells. The given labels are removed from the labels of the
+// TSDBs of the store.
+func storeDataKey(st Client, lsets []labels.Labels, withoutLabels []string) string {
+	infos := make([]string, 0, len(st.TSDBInfos()))
+	for _, info := range st.TSDBInfos() {
+		b := labels.NewBuilder(labelpb.ZLabelsToPromLabels(info.Labels.Labels))
+		b.Del(withoutLabels...)
+		infos = append(infos, fmt.Sprintf("%s[%d,%d]", b.Labels(), info.MinTime, info.MaxTime))
+	}
+	sort.Strings(infos)
+
+	mint, maxt := st.TimeRange()
+	return fmt.Sprintf("%s [%d,%d] %s %q", labelpb.PromLabelSetsToString(lsets), mint, maxt, strings.Join(infos, ","), st.Shard())
+}
+
+// hedgedClient is a Client hedging Series calls across stores holding the same data. The first
+// store sending a response is streamed from, the calls to the other stores are canceled.
+type hedgedClient struct {
+	// Client is the preferred store, describing the data of the group.
+	Client
+
+	stores  []Client
+	cfg     HedgingConfig
+	metrics *proxyStoreMetrics
+}
+
+func newHedgedClient(stores []Client, cfg HedgingConfig, metrics *proxyStoreMetrics) *hedgedClient {
+	stores = append([]Client(nil), stores...)
+
+	// Prefer stores with closed circuits, then the ones with the lowest latency. Stores with unknown latency
+	// are preferred so that their latency gets observed.
+	latency := func(st Client) time.Duration {
+		if ht, ok := st.(HealthTracker); ok {
+			if l, ok := ht.SeriesLatency(cfg.Quantile); ok {
+				return l
+			}
+		}
+		return 0
+	}
+	circuitOpen := func(st Client) bool {
+		ht, ok := st.(HealthTracker)
+		return ok && ht.CircuitOpen()
+	}
+	sort.SliceStable(stores, func(i, j int) bool {
+		if oi, oj := circuitOpen(stores[i]), circuitOpen(stores[j]); oi != oj {
+			return !oi
+		}
+		return latency(stores[i]) < latency(stores[j])
+	})
+
+	return &hedgedClient{
+		Client:  stores[0],
+		stores:  stores,
+		cfg:     cfg,
+		metrics: metrics,
+	}
+}
+
+func (c *hedgedClient) String() string {
+	names := make([]string, 0, len(c.stores))
+	for _, st := range c.stores {
+		names = append(names, st.String())
+	}
+	return fmt.Sprintf("hedged [%s]", strings.Join(names, "; "))
+}
+
+// delay returns the time after which a call to the preferred store is hedged.
+func (c *hedgedClient) delay() time.Duration {
+	ht, ok := c.Client.(HealthTracker)
+	if !ok {
+		return c.cfg.MinDelay
+	}
+	l, ok := ht.SeriesLatency(c.cfg.Quantile)
+	if !ok || l < c.cfg.MinDelay {
+		return c.cfg.MinDelay
+	}
+	return l
+}
+
+type hedgedAttempt struct {
+	idx    int
+	cl     storepb.Store_SeriesClient
+	first  *storepb.SeriesResponse
+	err    error
+	cancel context.CancelFunc
+}
+
+// Series calls the preferred store, and the next one each time no response was received after the hedging delay
+// or the previous call failed. It returns the stream of the first store sending a response.
+func (c *hedgedClient) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	var (
+		maxAttempts = min(len(c.stores), c.cfg.UpTo+1)
+		results     = make(chan hedgedAttempt, maxAttempts)
+		cancels     = make([]context.CancelFunc, 0, maxAttempts)
+		pending     int
+		lastErr     error
+	)
+	start := func() {
+		idx := len(cancels)
+		actx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		pending++
+
+		go func() {
+			cl, err := c.stores[idx].Series(actx, req, opts...)
+			if err != nil {
+				results <- hedgedAttempt{idx: idx, err: err, cancel: cancel}
+				return
+			}
+			first, err := cl.Recv()
+			results <- hedgedAttempt{idx: idx, cl: cl, first: first, err: err, cancel: cancel}
+		}()
+	}
+	cancelAll := func(except int) {
+		for i, cancel := range cancels {
+			if i != except {
+				cancel()
+			}
+		}
+	}
+
+	delay := c.delay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	start()
+	for {
+		select {
+		case a := <-results:
+			pending--
+			if a.err == nil || a.err == io.EOF {
+				cancelAll(a.idx)
+				if a.idx > 0 {
+					c.metrics.hedgedSeriesRequestsWon.Inc()
+				}
+				return &hedgedSeriesClient{Store_SeriesClient: a.cl, first: a.first, firstErr: a.err, cancel: a.cancel}, nil
+			}
+			a.cancel()
+			lastErr = a.err
+			if len(cancels) < maxAttempts {
+				start()
+				continue
+			}
+			if pending == 0 {
+				return nil, lastErr
+			}
+		case <-timer.C:
+			if len(cancels) < maxAttempts {
+				if ht, ok := c.Client.(HealthTracker); ok {
+					ht.SeriesHedged()
+				}
+				c.metrics.hedgedSeriesRequests.Inc()
+				start()
+				timer.Reset(delay)
+			}
+		case <-ctx.Done():
+			cancelAll(-1)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// hedgedSeriesClient replays the first response received by the hedged call before streaming the rest. The context
+// of the call is canceled once the stream is closed or fully received.
+type hedgedSeriesClient struct {
+	storepb.Store_SeriesClient
+
+	first    *storepb.SeriesResponse
+	firstErr error
+	replayed bool
+	cancel   context.CancelFunc
+}
+
+func (c *hedgedSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	var (
+		resp *storepb.SeriesResponse
+		err  error
+	)
+	if !c.replayed {
+		c.replayed = true
+		resp, err = c.first, c.firstErr
+	} else {
+		resp, err = c.Store_SeriesClient.Recv()
+	}
+	if err != nil {
+		c.cancel()
+	}
+	return resp, err
+}
+
+func (c *hedgedSeriesClient) CloseSend() error {
+	defer c.cancel()
+	return c.Store_SeriesClient.CloseSend()
+}
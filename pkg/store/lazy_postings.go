@@ -296,6 +296,7 @@ func keysToFetchFromPostingGroups(postingGroups []*postingGroup) ([]labels.Label
 
 func fetchAndExpandPostingGroups(ctx context.Context, r *bucketIndexReader, postingGroups []*postingGroup, bytesLimiter BytesLimiter, tenant string) ([]storage.SeriesRef, []*labels.Matcher, error) {
 	keys, lazyMatchers := keysToFetchFromPostingGroups(postingGroups)
+	r.block.recordAccess(keys)
 	fetchedPostings, closeFns, err := r.fetchPostings(ctx, keys, bytesLimiter, tenant)
 	defer func() {
 		for _, closeFn := range closeFns {
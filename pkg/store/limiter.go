@@ -51,6 +51,8 @@ type BytesLimiterFactory func(failedCounter prometheus.Counter) BytesLimiter
 type Limiter struct {
 	limit    uint64
 	reserved atomic.Uint64
+	// tenant, if set, is reported when the limit is exceeded.
+	tenant string
 
 	// Counter metric which we will increase if limit is exceeded.
 	failedCounter prometheus.Counter
@@ -62,6 +64,11 @@ func NewLimiter(limit uint64, ctr prometheus.Counter) *Limiter {
 	return &Limiter{limit: limit, failedCounter: ctr}
 }
 
+// NewLimiterForTenant returns a new limiter with a specified limit of the given tenant. 0 disables the limit.
+func NewLimiterForTenant(tenant string, limit uint64, ctr prometheus.Counter) *Limiter {
+	return &Limiter{limit: limit, failedCounter: ctr, tenant: tenant}
+}
+
 // Reserve implements ChunksLimiter.
 func (l *Limiter) Reserve(num uint64) error {
 	return l.ReserveWithType(num, 0)
@@ -78,6 +85,9 @@ func (l *Limiter) ReserveWithType(num uint64, _ StoreDataType) error {
 		// We need to protect from the counter being incremented twice due to concurrency
 		// while calling Reserve().
 		l.failedOnce.Do(l.failedCounter.Inc)
+		if l.tenant != "" {
+			return errors.Errorf("limit %v for tenant %q violated (got %v)", l.limit, l.tenant, reserved)
+		}
 		return errors.Errorf("limit %v violated (got %v)", l.limit, reserved)
 	}
 	return nil
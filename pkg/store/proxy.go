@@ -6,6 +6,7 @@ package store
 import (
 	"context"
 	"fmt"
+	"io"
 	"math"
 	"strings"
 	"sync"
@@ -66,6 +67,14 @@ type Client interface {
 	// and sorted response is supported by the underlying store.
 	SupportsWithoutReplicaLabels() bool
 
+	// SupportsSeriesEstimate returns true if the underlying store responds to Series requests
+	// with the estimate field set with an estimate instead of series.
+	SupportsSeriesEstimate() bool
+
+	// Shard returns the subset of the data of its label sets exposed by the underlying store, when several stores
+	// shard the same data, or empty.
+	Shard() string
+
 	// String returns the string representation of the store client.
 	String() string
 
@@ -92,10 +101,16 @@ type ProxyStore struct {
 	tsdbSelector      *TSDBSelector
 	matcherCache      storecache.MatchersCache
 	enableDedup       bool
+	hedging           HedgingConfig
+	replicaRouting    ReplicaRoutingConfig
 }
 
 type proxyStoreMetrics struct {
-	emptyStreamResponses prometheus.Counter
+	emptyStreamResponses        prometheus.Counter
+	hedgedSeriesRequests        prometheus.Counter
+	hedgedSeriesRequestsWon     prometheus.Counter
+	replicaRoutedSeriesRequests prometheus.Counter
+	replicaFailovers            *prometheus.CounterVec
 }
 
 func newProxyStoreMetrics(reg prometheus.Registerer) *proxyStoreMetrics {
@@ -105,6 +120,22 @@ func newProxyStoreMetrics(reg prometheus.Registerer) *proxyStoreMetrics {
 		Name: "thanos_proxy_store_empty_stream_responses_total",
 		Help: "Total number of empty responses received.",
 	})
+	m.hedgedSeriesRequests = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_hedged_series_requests_total",
+		Help: "Total number of Series requests hedged to another store advertising the same label sets.",
+	})
+	m.hedgedSeriesRequestsWon = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_hedged_series_requests_won_total",
+		Help: "Total number of hedged Series requests answered first by another store than the preferred one.",
+	})
+	m.replicaRoutedSeriesRequests = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_replica_routed_series_requests_total",
+		Help: "Total number of Series requests to a group of replicas answered by a single replica.",
+	})
+	m.replicaFailovers = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_replica_failovers_total",
+		Help: "Total number of Series requests to a group of replicas sent to another replica, because the preferred one failed or its response had gaps.",
+	}, []string{"reason"})
 
 	return &m
 }
@@ -282,8 +313,12 @@ func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.
 
 	ctx = metadata.AppendToOutgoingContext(ctx, tenancy.DefaultTenantHeader, tenant)
 	level.Debug(s.logger).Log("msg", "Tenant info in Series()", "tenant", tenant)
+	if priority, ok := GetSeriesPriorityFromGRPCMetadata(ctx); ok {
+		ctx = WithSeriesPriority(ctx, priority)
+	}
 
-	stores, storeLabelSets, storeDebugMsgs := s.matchingStores(ctx, originalRequest.MinTime, originalRequest.MaxTime, matchers)
+	fanout := newSelectFanout(ctx, originalRequest)
+	stores, storeLabelSets, storeDebugMsgs := s.matchingStores(withSelectFanout(ctx, fanout), originalRequest.MinTime, originalRequest.MaxTime, matchers)
 	if len(stores) == 0 {
 		level.Debug(reqLogger).Log("err", ErrorNoStoresMatched, "stores", strings.Join(storeDebugMsgs, ";"))
 		return nil
@@ -303,6 +338,25 @@ func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.
 		PartialResponseStrategy: originalRequest.PartialResponseStrategy,
 		ShardInfo:               originalRequest.ShardInfo,
 		WithoutReplicaLabels:    originalRequest.WithoutReplicaLabels,
+		Estimate:                originalRequest.Estimate,
+	}
+
+	if r.Estimate {
+		return s.estimateSeries(ctx, r, stores, srv, reqLogger)
+	}
+	if s.hedging.UpTo > 0 {
+		stores = s.hedgedStores(stores)
+	}
+	// Replicas can only be told apart from each other when deduplicating along replica labels.
+	if s.replicaRouting.Enabled && len(r.WithoutReplicaLabels) > 0 {
+		stores = s.replicaRoutedStores(stores, r.WithoutReplicaLabels)
+	}
+	if fanout != nil {
+		// Ask stores supporting it to report the cost of the request.
+		if r.Hints, err = queryStatsHints(); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		stores = fanout.trackStores(stores)
 	}
 
 	storeResponses := make([]respSet, 0, len(stores))
@@ -355,6 +409,90 @@ func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.
 	return nil
 }
 
+// estimateSeries fans the estimate request out to the given stores and responds with the sum of their estimates.
+// Stores not supporting estimates are skipped, as they would run the full request instead.
+func (s *ProxyStore) estimateSeries(ctx context.Context, r *storepb.SeriesRequest, stores []Client, srv storepb.Store_SeriesServer, reqLogger log.Logger) error {
+	var (
+		mtx      sync.Mutex
+		estimate storepb.SeriesEstimate
+		warnings []string
+		g, gctx  = errgroup.WithContext(ctx)
+	)
+	for _, st := range stores {
+		if !st.SupportsSeriesEstimate() {
+			level.Debug(reqLogger).Log("msg", "store does not support series estimates, skipping", "store", st.String())
+			continue
+		}
+		g.Go(func() error {
+			est, ws, err := estimateStoreSeries(gctx, st, r)
+
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			warnings = append(warnings, ws...)
+			if err != nil {
+				err = errors.Wrapf(err, "estimate series from %s", st.String())
+				level.Error(reqLogger).Log("err", err)
+				if !r.PartialResponseDisabled || r.PartialResponseStrategy == storepb.PartialResponseStrategy_WARN {
+					warnings = append(warnings, err.Error())
+					return nil
+				}
+				return err
+			}
+			estimate.Series += est.Series
+			estimate.Chunks += est.Chunks
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return status.Error(codes.Aborted, err.Error())
+	}
+
+	for _, w := range warnings {
+		if err := srv.Send(storepb.NewWarnSeriesResponse(errors.New(w))); err != nil {
+			return status.Error(codes.Unknown, errors.Wrap(err, "send series response").Error())
+		}
+	}
+	if err := srv.Send(storepb.NewEstimateSeriesResponse(estimate.Series, estimate.Chunks)); err != nil {
+		return status.Error(codes.Unknown, errors.Wrap(err, "send series response").Error())
+	}
+	return nil
+}
+
+// estimateStoreSeries requests the estimate of the given store. A store responding with series despite advertising
+// estimates has its stream canceled right away, and doesn't contribute to the estimate.
+func estimateStoreSeries(ctx context.Context, st Client, r *storepb.SeriesRequest) (storepb.SeriesEstimate, []string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		estimate storepb.SeriesEstimate
+		warnings []string
+	)
+	cl, err := st.Series(ctx, r)
+	if err != nil {
+		return estimate, nil, err
+	}
+	for {
+		resp, err := cl.Recv()
+		if err == io.EOF {
+			return estimate, warnings, nil
+		}
+		if err != nil {
+			return estimate, warnings, err
+		}
+		switch {
+		case resp.GetEstimate() != nil:
+			estimate.Series += resp.GetEstimate().Series
+			estimate.Chunks += resp.GetEstimate().Chunks
+		case resp.GetWarning() != "":
+			warnings = append(warnings, resp.GetWarning())
+		case resp.GetSeries() != nil:
+			return storepb.SeriesEstimate{}, warnings, nil
+		}
+	}
+}
+
 // LabelNames returns all known label names.
 func (s *ProxyStore) LabelNames(ctx context.Context, originalRequest *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
 	// TODO(bwplotka): This should be part of request logger, otherwise it does not make much sense. Also, could be
@@ -572,10 +710,12 @@ func (s *ProxyStore) matchingStores(ctx context.Context, minTime, maxTime int64,
 		stores         []Client
 		storeLabelSets []labels.Labels
 		storeDebugMsgs []string
+		fanout         = selectFanoutFromContext(ctx)
 	)
 	for _, st := range s.stores() {
 		// We might be able to skip the store if its meta information indicates it cannot have series matching our query.
 		if ok, reason := storeMatches(ctx, s.debugLogging, st, minTime, maxTime, matchers...); !ok {
+			fanout.pruned(st, reason)
 			if s.debugLogging {
 				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, reason))
 			}
@@ -583,6 +723,7 @@ func (s *ProxyStore) matchingStores(ctx context.Context, minTime, maxTime int64,
 		}
 		matches, extraMatchers := s.tsdbSelector.MatchLabelSets(st.LabelSets()...)
 		if !matches {
+			fanout.pruned(st, "tsdb selector")
 			if s.debugLogging {
 				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "tsdb selector"))
 			}
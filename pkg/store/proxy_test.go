@@ -6,6 +6,7 @@ package store
 import (
 	"context"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"os"
@@ -22,16 +23,20 @@ import (
 	"github.com/gogo/protobuf/types"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	storecache "github.com/thanos-io/thanos/pkg/store/cache"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	storetestutil "github.com/thanos-io/thanos/pkg/store/storepb/testutil"
@@ -1392,6 +1397,437 @@ func TestProxyStore_Series_RequestParamsProxied(t *testing.T) {
 	testutil.Assert(t, proto.Equal(req, m.LastSeriesReq), "request was not proxied properly to underlying storeAPI: %s vs %s", req, m.LastSeriesReq)
 }
 
+func TestProxyStore_Series_Estimate(t *testing.T) {
+	t.Parallel()
+
+	supporting := &mockedStoreAPI{
+		RespSeries: []*storepb.SeriesResponse{
+			storepb.NewEstimateSeriesResponse(10, 20),
+		},
+	}
+	warning := &mockedStoreAPI{
+		RespSeries: []*storepb.SeriesResponse{
+			storepb.NewWarnSeriesResponse(errors.New("warning")),
+			storepb.NewEstimateSeriesResponse(5, 5),
+		},
+	}
+	// Stores not advertising estimates would run the full request, they are not queried.
+	unsupporting := &mockedStoreAPI{
+		RespSeries: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}}),
+		},
+	}
+	// Stores advertising estimates but responding with series are not part of the estimate.
+	misbehaving := &mockedStoreAPI{
+		RespSeries: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}}),
+		},
+	}
+	var cls []Client
+	for _, m := range []*mockedStoreAPI{supporting, warning, misbehaving} {
+		cls = append(cls, &storetestutil.TestClient{StoreClient: m, MinTime: 1, MaxTime: 300, SeriesEstimateEnabled: true})
+	}
+	cls = append(cls, &storetestutil.TestClient{StoreClient: unsupporting, MinTime: 1, MaxTime: 300})
+	q := NewProxyStore(nil,
+		nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		1*time.Second, EagerRetrieval,
+	)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+		Estimate: true,
+	}, s))
+
+	testutil.Equals(t, 0, len(s.SeriesSet))
+	testutil.Equals(t, []string{"warning"}, s.Warnings)
+	testutil.Equals(t, []*storepb.SeriesEstimate{{Series: 15, Chunks: 25}}, s.EstimateSet)
+	testutil.Assert(t, supporting.LastSeriesReq.Estimate, "estimate was not requested from the store")
+	testutil.Assert(t, unsupporting.LastSeriesReq == nil, "store not supporting estimates was queried")
+}
+
+func TestProxyStore_Series_Hedging(t *testing.T) {
+	t.Parallel()
+
+	var (
+		lset   = []labels.Labels{labels.FromStrings("ext", "1")}
+		series = storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}})
+		req    = &storepb.SeriesRequest{
+			MinTime:  1,
+			MaxTime:  300,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+		}
+	)
+	for _, tcase := range []struct {
+		name           string
+		stores         []*storetestutil.TestClient
+		expectedSeries int
+		expectedHedged float64
+	}{
+		{
+			name: "slow store is hedged",
+			stores: []*storetestutil.TestClient{
+				{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{series}, RespDuration: 10 * time.Second}, ExtLset: lset, MinTime: 1, MaxTime: 300},
+				{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{series}}, ExtLset: lset, MinTime: 1, MaxTime: 300},
+			},
+			expectedSeries: 1,
+			expectedHedged: 1,
+		},
+		{
+			name: "failing store fails over",
+			stores: []*storetestutil.TestClient{
+				{StoreClient: &mockedStoreAPI{RespError: errors.New("test error")}, ExtLset: lset, MinTime: 1, MaxTime: 300},
+				{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{series}}, ExtLset: lset, MinTime: 1, MaxTime: 300},
+			},
+			expectedSeries: 1,
+		},
+		{
+			name: "stores with different label sets are not hedged",
+			stores: []*storetestutil.TestClient{
+				{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{series}, RespDuration: 100 * time.Millisecond}, ExtLset: lset, MinTime: 1, MaxTime: 300},
+				{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b", "c", "d"), []sample{{1, 1}})}}, ExtLset: []labels.Labels{labels.FromStrings("ext", "2")}, MinTime: 1, MaxTime: 300},
+			},
+			expectedSeries: 2,
+		},
+		{
+			name: "stores with disjoint time ranges are not hedged",
+			stores: []*storetestutil.TestClient{
+				{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{series}, RespDuration: 100 * time.Millisecond}, ExtLset: lset, MinTime: 1, MaxTime: 150},
+				{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b", "c", "d"), []sample{{200, 1}})}}, ExtLset: lset, MinTime: 151, MaxTime: 300},
+			},
+			expectedSeries: 2,
+		},
+		{
+			name: "stores with different shards are not hedged",
+			stores: []*storetestutil.TestClient{
+				{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{series}, RespDuration: 100 * time.Millisecond}, ExtLset: lset, MinTime: 1, MaxTime: 300, StoreShard: "a"},
+				{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b", "c", "d"), []sample{{1, 1}})}}, ExtLset: lset, MinTime: 1, MaxTime: 300, StoreShard: "b"},
+			},
+			expectedSeries: 2,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			cls := make([]Client, 0, len(tcase.stores))
+			for _, st := range tcase.stores {
+				cls = append(cls, st)
+			}
+			q := NewProxyStore(nil,
+				nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				0, EagerRetrieval,
+				WithSeriesHedging(HedgingConfig{UpTo: 1, Quantile: 0.9, MinDelay: 20 * time.Millisecond}),
+			)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			s := newStoreSeriesServer(ctx)
+			testutil.Ok(t, q.Series(req, s))
+			testutil.Ok(t, ctx.Err())
+			testutil.Equals(t, 0, len(s.Warnings))
+			testutil.Equals(t, tcase.expectedSeries, len(s.SeriesSet))
+			testutil.Equals(t, tcase.expectedHedged, prom_testutil.ToFloat64(q.metrics.hedgedSeriesRequests))
+		})
+	}
+}
+
+func TestHedgedClient_ReleasesWinningCall(t *testing.T) {
+	t.Parallel()
+
+	var (
+		lset   = []labels.Labels{labels.FromStrings("ext", "1")}
+		series = storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}})
+	)
+	newClient := func() *hedgedClient {
+		return newHedgedClient([]Client{
+			&storetestutil.TestClient{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{series}, RespDuration: 10 * time.Second}, ExtLset: lset, MinTime: 1, MaxTime: 300},
+			&storetestutil.TestClient{StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{series, series}}, ExtLset: lset, MinTime: 1, MaxTime: 300},
+		}, HedgingConfig{UpTo: 1, Quantile: 0.9, MinDelay: 20 * time.Millisecond}, newProxyStoreMetrics(nil))
+	}
+
+	t.Run("fully received", func(t *testing.T) {
+		cl, err := newClient().Series(context.Background(), &storepb.SeriesRequest{})
+		testutil.Ok(t, err)
+		for i := 0; i < 2; i++ {
+			_, err := cl.Recv()
+			testutil.Ok(t, err)
+			testutil.Ok(t, cl.Context().Err())
+		}
+		_, err = cl.Recv()
+		testutil.Equals(t, io.EOF, err)
+		testutil.Equals(t, context.Canceled, cl.Context().Err())
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		cl, err := newClient().Series(context.Background(), &storepb.SeriesRequest{})
+		testutil.Ok(t, err)
+		_, err = cl.Recv()
+		testutil.Ok(t, err)
+		testutil.Ok(t, cl.CloseSend())
+		testutil.Equals(t, context.Canceled, cl.Context().Err())
+	})
+}
+
+func TestProxyStore_Series_Fanout(t *testing.T) {
+	t.Parallel()
+
+	hints, err := types.MarshalAny(&hintspb.SeriesResponseHints{QueryStats: &hintspb.QueryStats{BlocksQueried: 2, PostingsTouched: 5}})
+	testutil.Ok(t, err)
+
+	bucketStore := &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}, {2, 2}}),
+		storepb.NewHintsSeriesResponse(hints),
+	}}
+	cls := []Client{
+		&storetestutil.TestClient{Name: "bucket", StoreClient: bucketStore, ExtLset: []labels.Labels{labels.FromStrings("ext", "1")}, MinTime: 1, MaxTime: 300},
+		&storetestutil.TestClient{Name: "failing", StoreClient: &mockedStoreAPI{RespError: status.Error(codes.Unavailable, "test error")}, ExtLset: []labels.Labels{labels.FromStrings("ext", "2")}, MinTime: 1, MaxTime: 300},
+		&storetestutil.TestClient{Name: "old", StoreClient: &mockedStoreAPI{}, MinTime: 400, MaxTime: 500},
+	}
+	q := NewProxyStore(nil, nil, func() []Client { return cls }, component.Query, labels.EmptyLabels(), 0, EagerRetrieval)
+
+	tracker := NewFanoutTracker()
+	s := newStoreSeriesServer(WithFanoutTracker(context.Background(), tracker))
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+	}, s))
+	testutil.Equals(t, 1, len(s.SeriesSet))
+
+	// Stores are asked to report query statistics.
+	var reqHints hintspb.SeriesRequestHints
+	testutil.Ok(t, types.UnmarshalAny(bucketStore.LastSeriesReq.Hints, &reqHints))
+	testutil.Assert(t, reqHints.EnableQueryStats)
+
+	selects := tracker.Selects()
+	testutil.Equals(t, 1, len(selects))
+	testutil.Equals(t, `a="b"`, selects[0].Matchers[0].String())
+
+	stores := map[string]*StoreFanout{}
+	for _, st := range selects[0].Stores {
+		stores[st.Store] = st
+	}
+	testutil.Equals(t, 3, len(stores))
+	testutil.Equals(t, "does not have data within this time period", stores["old"].PrunedReason)
+	testutil.Equals(t, "", stores["bucket"].PrunedReason)
+	testutil.Equals(t, 1, stores["bucket"].Stats.Series)
+	testutil.Equals(t, 2, stores["bucket"].Stats.Samples)
+	testutil.Equals(t, int64(2), stores["bucket"].QueryStats.BlocksQueried)
+	testutil.Equals(t, int64(5), stores["bucket"].QueryStats.PostingsTouched)
+	testutil.Equals(t, codes.OK, stores["bucket"].Code())
+	testutil.Equals(t, codes.Unavailable, stores["failing"].Code())
+
+	// Requests made without a tracker are not recorded.
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+	}, newStoreSeriesServer(context.Background())))
+	testutil.Equals(t, 1, len(tracker.Selects()))
+}
+
+func TestStoreFanout_Code(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{name: "no error", code: codes.OK},
+		{name: "gRPC status", err: status.Error(codes.ResourceExhausted, "limit"), code: codes.ResourceExhausted},
+		{name: "wrapped gRPC status", err: errors.Wrap(status.Error(codes.Unavailable, "down"), "receive series"), code: codes.Unavailable},
+		{name: "canceled", err: errors.Wrap(context.Canceled, "receive series"), code: codes.Canceled},
+		{name: "deadline exceeded", err: errors.Wrap(context.DeadlineExceeded, "receive series"), code: codes.DeadlineExceeded},
+		{name: "other error", err: errors.New("boom"), code: codes.Unknown},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.Equals(t, tc.code, (&StoreFanout{Err: tc.err}).Code())
+		})
+	}
+}
+
+func TestProxyStore_Series_ReplicaRouting(t *testing.T) {
+	t.Parallel()
+
+	var (
+		series    = storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}, {2, 2}}, []sample{{3, 3}, {4, 4}})
+		gapSeries = storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}, {2, 2}}, []sample{{500, 3}, {501, 4}})
+		others    = []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "b", "c", "1"), []sample{{1, 1}, {2, 2}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "b", "c", "2"), []sample{{1, 1}, {2, 2}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "b", "c", "3"), []sample{{1, 1}, {2, 2}}),
+		}
+		req = &storepb.SeriesRequest{
+			MinTime:              1,
+			MaxTime:              600,
+			Matchers:             []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+			WithoutReplicaLabels: []string{"replica"},
+		}
+		replica = func(name string, api *mockedStoreAPI) *storetestutil.TestClient {
+			return &storetestutil.TestClient{
+				Name:                        name,
+				StoreClient:                 api,
+				ExtLset:                     []labels.Labels{labels.FromStrings("ext", "1", "replica", name)},
+				MinTime:                     1,
+				MaxTime:                     600,
+				WithoutReplicaLabelsEnabled: true,
+			}
+		}
+	)
+	for _, tcase := range []struct {
+		name              string
+		apis              []*mockedStoreAPI
+		expectedQueried   []bool
+		expectedSeries    int
+		expectedRouted    float64
+		expectedFailovers map[string]float64
+	}{
+		{
+			name:            "preferred replica is queried",
+			apis:            []*mockedStoreAPI{{RespSeries: []*storepb.SeriesResponse{series}}, {RespSeries: []*storepb.SeriesResponse{series}}},
+			expectedQueried: []bool{false, true},
+			expectedSeries:  1,
+			expectedRouted:  1,
+		},
+		{
+			name:              "failing replica fails over",
+			apis:              []*mockedStoreAPI{{RespSeries: []*storepb.SeriesResponse{series}}, {RespError: errors.New("test error")}},
+			expectedQueried:   []bool{true, true},
+			expectedSeries:    1,
+			expectedRouted:    1,
+			expectedFailovers: map[string]float64{"error": 1},
+		},
+		{
+			name: "replica failing while streaming fails over for the remaining series",
+			apis: []*mockedStoreAPI{
+				{RespSeries: others},
+				{RespSeries: others, injectedError: errors.New("test error"), injectedErrorIndex: 2},
+			},
+			expectedQueried:   []bool{true, true},
+			expectedSeries:    3,
+			expectedFailovers: map[string]float64{"error": 1},
+		},
+		{
+			name:              "response with gaps falls back to dedup",
+			apis:              []*mockedStoreAPI{{RespSeries: []*storepb.SeriesResponse{series}}, {RespSeries: []*storepb.SeriesResponse{gapSeries}}},
+			expectedQueried:   []bool{true, true},
+			expectedSeries:    1,
+			expectedFailovers: map[string]float64{"gap": 1},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			cls := []Client{replica("a", tcase.apis[0]), replica("b", tcase.apis[1])}
+			q := NewProxyStore(nil,
+				nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				0, EagerRetrieval,
+				WithReplicaRouting(ReplicaRoutingConfig{Enabled: true, Preference: []string{"b"}, GapThreshold: 100 * time.Millisecond}),
+			)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			s := newStoreSeriesServer(ctx)
+			testutil.Ok(t, q.Series(req, s))
+			testutil.Equals(t, 0, len(s.Warnings))
+			testutil.Equals(t, tcase.expectedSeries, len(s.SeriesSet))
+			for i, api := range tcase.apis {
+				testutil.Equals(t, tcase.expectedQueried[i], api.LastSeriesReq != nil)
+			}
+			for _, reason := range []string{"error", "gap"} {
+				testutil.Equals(t, tcase.expectedFailovers[reason], prom_testutil.ToFloat64(q.metrics.replicaFailovers.WithLabelValues(reason)))
+			}
+			testutil.Equals(t, tcase.expectedRouted, prom_testutil.ToFloat64(q.metrics.replicaRoutedSeriesRequests))
+		})
+	}
+}
+
+func TestProxyStore_replicaRoutedStores(t *testing.T) {
+	t.Parallel()
+
+	replica := func(name string, mint, maxt int64, shard string) Client {
+		return &storetestutil.TestClient{
+			Name:       name,
+			ExtLset:    []labels.Labels{labels.FromStrings("ext", "1", "replica", name)},
+			MinTime:    mint,
+			MaxTime:    maxt,
+			StoreShard: shard,
+		}
+	}
+	q := NewProxyStore(nil, nil, func() []Client { return nil }, component.Query, labels.EmptyLabels(), 0, EagerRetrieval,
+		WithReplicaRouting(ReplicaRoutingConfig{Enabled: true}),
+	)
+	for _, tcase := range []struct {
+		name     string
+		stores   []Client
+		expected int
+	}{
+		{
+			name:     "replicas are grouped",
+			stores:   []Client{replica("a", 1, 300, ""), replica("b", 1, 300, "")},
+			expected: 1,
+		},
+		{
+			name:     "replicas with disjoint time ranges are not grouped",
+			stores:   []Client{replica("a", 1, 150, ""), replica("b", 151, 300, "")},
+			expected: 2,
+		},
+		{
+			name:     "replicas with different shards are not grouped",
+			stores:   []Client{replica("a", 1, 300, "0"), replica("b", 1, 300, "1")},
+			expected: 2,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			testutil.Equals(t, tcase.expected, len(q.replicaRoutedStores(tcase.stores, []string{"replica"})))
+		})
+	}
+}
+
+func TestReplicaGroupClient_Series_Streams(t *testing.T) {
+	t.Parallel()
+
+	series := []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 1}}),
+	}
+	replica := func(name string, api *mockedStoreAPI) Client {
+		return &storetestutil.TestClient{
+			Name:        name,
+			StoreClient: api,
+			ExtLset:     []labels.Labels{labels.FromStrings("ext", "1", "replica", name)},
+			MinTime:     1,
+			MaxTime:     300,
+		}
+	}
+	c := newReplicaGroupClient([]Client{
+		// The second series of the preferred replica is never sent.
+		replica("a", &mockedStoreAPI{RespSeries: series, RespDuration: time.Hour, SlowSeriesIndex: 1}),
+		replica("b", &mockedStoreAPI{RespSeries: series}),
+	}, []string{"replica"}, ReplicaRoutingConfig{Enabled: true, Preference: []string{"a"}, GapThreshold: 100 * time.Millisecond}, newProxyStoreMetrics(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cl, err := c.Series(ctx, &storepb.SeriesRequest{MinTime: 1, MaxTime: 300})
+	testutil.Ok(t, err)
+	resp, err := cl.Recv()
+	testutil.Ok(t, err)
+	testutil.Equals(t, series[0], resp)
+	testutil.Ok(t, ctx.Err())
+	testutil.Ok(t, cl.CloseSend())
+}
+
 func TestProxyStore_Series_RegressionFillResponseChannel(t *testing.T) {
 	t.Parallel()
 
@@ -1969,9 +2405,10 @@ type storeSeriesServer struct {
 
 	ctx context.Context
 
-	SeriesSet []storepb.Series
-	Warnings  []string
-	HintsSet  []*types.Any
+	SeriesSet   []storepb.Series
+	Warnings    []string
+	HintsSet    []*types.Any
+	EstimateSet []*storepb.SeriesEstimate
 
 	Size int64
 }
@@ -1998,6 +2435,11 @@ func (s *storeSeriesServer) Send(r *storepb.SeriesResponse) error {
 		return nil
 	}
 
+	if r.GetEstimate() != nil {
+		s.EstimateSet = append(s.EstimateSet, r.GetEstimate())
+		return nil
+	}
+
 	// Unsupported field, skip.
 	return nil
 }
@@ -0,0 +1,471 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// replicaLatencyQuantile is the quantile of the time to first response used to rank the replicas of a group.
+const replicaLatencyQuantile = 0.9
+
+// ReplicaRoutingConfig configures the routing of Series calls to a single replica of the groups of stores advertising
+// label sets which only differ by replica labels.
+type ReplicaRoutingConfig struct {
+	Enabled bool
+	// Preference is the ordered list of preferred values of the replica labels. Replicas without a preferred value
+	// are ranked by latency after the preferred ones.
+	Preference []string
+	// GapThreshold is the time between samples of the response of a replica above which data is considered missing.
+	// All the replicas of the group are then queried and their responses deduplicated. Zero disables gap detection.
+	GapThreshold time.Duration
+}
+
+// WithReplicaRouting enables routing of Series calls to a single replica of each group of replicas.
+func WithReplicaRouting(cfg ReplicaRoutingConfig) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.replicaRouting = cfg
+	}
+}
+
+// replicaRoutedStores replaces the stores advertising label sets which only differ by the given replica labels, with
+// identical time ranges and TSDBs and the same shard, with a single client routing Series calls to one of them.
+func (s *ProxyStore) replicaRoutedStores(stores []Client, replicaLabels []string) []Client {
+	var (
+		groups = make(map[string][]Client, len(stores))
+		keys   = make([]string, 0, len(stores))
+		res    = make([]Client, 0, len(stores))
+	)
+	for _, st := range stores {
+		key, ok := replicaGroupKey(st, replicaLabels)
+		if !ok {
+			res = append(res, st)
+			continue
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], st)
+	}
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) == 1 {
+			res = append(res, group[0])
+			continue
+		}
+		res = append(res, newReplicaGroupClient(group, replicaLabels, s.replicaRouting, s.metrics))
+	}
+	return res
+}
+
+// replicaGroupKey returns a key identifying the data exposed by the store regardless of the replica labels, or false
+// if the store doesn't advertise any replica label.
+func replicaGroupKey(st Client, replicaLabels []string) (string, bool) {
+	lsets := st.LabelSets()
+	var (
+		found    bool
+		stripped = make([]labels.Labels, 0, len(lsets))
+	)
+	for _, lset := range lsets {
+		b := labels.NewBuilder(lset)
+		for _, l := range replicaLabels {
+			if lset.Has(l) {
+				found = true
+				b.Del(l)
+			}
+		}
+		stripped = append(stripped, b.Labels())
+	}
+	if !found {
+		return "", false
+	}
+	return storeDataKey(st, stripped, replicaLabels), true
+}
+
+// replicaGroupClient is a Client routing Series calls to a single replica of a group of stores holding the same data.
+// Other replicas are called if the call fails or if the response has gaps, in which case all the responses are merged
+// to be deduplicated.
+type replicaGroupClient struct {
+	// Client is the preferred replica, describing the data of the group.
+	Client
+
+	replicas []Client
+	cfg      ReplicaRoutingConfig
+	metrics  *proxyStoreMetrics
+}
+
+func newReplicaGroupClient(replicas []Client, replicaLabels []string, cfg ReplicaRoutingConfig, metrics *proxyStoreMetrics) *replicaGroupClient {
+	replicas = append([]Client(nil), replicas...)
+
+	// Prefer replicas with closed circuits, then the configured preference, then the ones with the lowest latency.
+	preference := func(st Client) int {
+		for i, p := range cfg.Preference {
+			for _, lset := range st.LabelSets() {
+				for _, l := range replicaLabels {
+					if lset.Get(l) == p {
+						return i
+					}
+				}
+			}
+		}
+		return len(cfg.Preference)
+	}
+	latency := func(st Client) time.Duration {
+		if ht, ok := st.(HealthTracker); ok {
+			if l, ok := ht.SeriesLatency(replicaLatencyQuantile); ok {
+				return l
+			}
+		}
+		return 0
+	}
+	circuitOpen := func(st Client) bool {
+		ht, ok := st.(HealthTracker)
+		return ok && ht.CircuitOpen()
+	}
+	sort.SliceStable(replicas, func(i, j int) bool {
+		if oi, oj := circuitOpen(replicas[i]), circuitOpen(replicas[j]); oi != oj {
+			return !oi
+		}
+		if pi, pj := preference(replicas[i]), preference(replicas[j]); pi != pj {
+			return pi < pj
+		}
+		return latency(replicas[i]) < latency(replicas[j])
+	})
+
+	return &replicaGroupClient{
+		Client:   replicas[0],
+		replicas: replicas,
+		cfg:      cfg,
+		metrics:  metrics,
+	}
+}
+
+func (c *replicaGroupClient) String() string {
+	names := make([]string, 0, len(c.replicas))
+	for _, st := range c.replicas {
+		names = append(names, st.String())
+	}
+	return fmt.Sprintf("replicas [%s]", strings.Join(names, "; "))
+}
+
+// Series streams the response of the first replica accepting the call. If the replica fails, or if its response has
+// gaps, the series not streamed yet are merged with the ones of the remaining replicas.
+func (c *replicaGroupClient) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	cl := &replicaSeriesClient{ctx: ctx, c: c, req: req, opts: opts}
+	if err := cl.openNext(); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// replicaSeriesClient streams the response of a replica of a group. Series are buffered only until one of them has
+// data at the beginning of the time range the replica is expected to have data for.
+type replicaSeriesClient struct {
+	ctx  context.Context
+	c    *replicaGroupClient
+	req  *storepb.SeriesRequest
+	opts []grpc.CallOption
+
+	// next is the index of the next replica to fail over to.
+	next    int
+	primary *seriesSource
+	// pending are the series of the primary replica not streamed yet, while it has no data at the beginning.
+	pending []*storepb.SeriesResponse
+	started bool
+	// last is the label set of the last series streamed from the primary replica, if sent.
+	last labels.Labels
+	sent bool
+
+	merge *seriesMerge
+	err   error
+}
+
+// openNext makes the next replica the primary one.
+func (cl *replicaSeriesClient) openNext() error {
+	var lastErr error
+	for cl.next < len(cl.c.replicas) {
+		st := cl.c.replicas[cl.next]
+		cl.next++
+
+		src, err := openSeriesSource(cl.ctx, st, cl.req, cl.opts...)
+		if err != nil {
+			if cl.ctx.Err() != nil {
+				return err
+			}
+			cl.c.metrics.replicaFailovers.WithLabelValues("error").Inc()
+			lastErr = err
+			continue
+		}
+		cl.primary, cl.pending, cl.started = src, nil, false
+		return nil
+	}
+	return lastErr
+}
+
+// checkGaps returns true if gaps in the response of the primary replica should be looked for.
+func (cl *replicaSeriesClient) checkGaps() bool {
+	return cl.c.cfg.GapThreshold > 0 && cl.next < len(cl.c.replicas)
+}
+
+func (cl *replicaSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	for {
+		if cl.err != nil {
+			return nil, cl.err
+		}
+		if cl.merge != nil {
+			return cl.merge.Recv()
+		}
+		if cl.started && len(cl.pending) > 0 {
+			return cl.send(cl.popPending())
+		}
+
+		resp, err := cl.primary.Recv()
+		if err == io.EOF {
+			if len(cl.pending) > 0 {
+				// None of the series has data at the beginning of the time range.
+				cl.c.metrics.replicaFailovers.WithLabelValues("gap").Inc()
+				cl.startMerge(nil)
+				continue
+			}
+			cl.c.metrics.replicaRoutedSeriesRequests.Inc()
+			cl.err = io.EOF
+			continue
+		}
+		if err != nil {
+			if cl.ctx.Err() != nil {
+				cl.err = err
+				continue
+			}
+			if cl.next == len(cl.c.replicas) {
+				cl.err = err
+				continue
+			}
+			cl.c.metrics.replicaFailovers.WithLabelValues("error").Inc()
+			if cl.sent {
+				cl.startMerge(nil)
+				continue
+			}
+			if err := cl.openNext(); err != nil {
+				cl.err = err
+			}
+			continue
+		}
+
+		s := resp.GetSeries()
+		if s == nil || !cl.checkGaps() {
+			return cl.send(resp)
+		}
+		if seriesHasGaps(s, cl.c.cfg.GapThreshold) {
+			cl.c.metrics.replicaFailovers.WithLabelValues("gap").Inc()
+			cl.startMerge(resp)
+			continue
+		}
+		if !cl.started && len(s.Chunks) > 0 {
+			storeMinTime, _ := cl.primary.st.TimeRange()
+			cl.started = s.Chunks[0].MinTime-max(cl.req.MinTime, storeMinTime) <= cl.c.cfg.GapThreshold.Milliseconds()
+		}
+		cl.pending = append(cl.pending, resp)
+	}
+}
+
+func (cl *replicaSeriesClient) popPending() *storepb.SeriesResponse {
+	resp := cl.pending[0]
+	cl.pending = cl.pending[1:]
+	return resp
+}
+
+func (cl *replicaSeriesClient) send(resp *storepb.SeriesResponse) (*storepb.SeriesResponse, error) {
+	if s := resp.GetSeries(); s != nil {
+		cl.last, cl.sent = labelpb.ZLabelsToPromLabels(s.Labels), true
+	}
+	return resp, nil
+}
+
+// startMerge falls back to merging the series of the primary replica not streamed yet, starting with the pending
+// ones and the given one, with the ones of the remaining replicas.
+func (cl *replicaSeriesClient) startMerge(resp *storepb.SeriesResponse) {
+	cl.primary.buf = append(cl.pending, cl.primary.buf...)
+	if resp != nil {
+		cl.primary.buf = append(cl.primary.buf, resp)
+	}
+	cl.pending = nil
+
+	srcs := []*seriesSource{cl.primary}
+	m := &seriesMerge{ctx: cl.ctx, after: cl.last, hasAfter: cl.sent}
+	for _, st := range cl.c.replicas[cl.next:] {
+		src, err := openSeriesSource(cl.ctx, st, cl.req, cl.opts...)
+		if err != nil {
+			m.warn(err)
+			continue
+		}
+		srcs = append(srcs, src)
+	}
+	cl.next = len(cl.c.replicas)
+	m.srcs = srcs
+	m.heads = make([]*storepb.SeriesResponse, len(srcs))
+	cl.merge = m
+}
+
+func (cl *replicaSeriesClient) Header() (metadata.MD, error) { return nil, nil }
+func (cl *replicaSeriesClient) Trailer() metadata.MD         { return nil }
+func (cl *replicaSeriesClient) Context() context.Context     { return cl.ctx }
+func (cl *replicaSeriesClient) SendMsg(interface{}) error    { return nil }
+func (cl *replicaSeriesClient) RecvMsg(interface{}) error    { return nil }
+
+func (cl *replicaSeriesClient) CloseSend() error {
+	if cl.merge != nil {
+		cl.merge.close()
+	} else if cl.primary != nil {
+		cl.primary.close()
+	}
+	return nil
+}
+
+// seriesHasGaps returns true if the series has consecutive chunks further apart than the threshold.
+func seriesHasGaps(s *storepb.Series, threshold time.Duration) bool {
+	th := threshold.Milliseconds()
+	for i := 1; i < len(s.Chunks); i++ {
+		if s.Chunks[i].MinTime-s.Chunks[i-1].MaxTime > th {
+			return true
+		}
+	}
+	return false
+}
+
+// seriesSource is the response of a store, preceded by buffered responses.
+type seriesSource struct {
+	st     Client
+	buf    []*storepb.SeriesResponse
+	cl     storepb.Store_SeriesClient
+	cancel context.CancelFunc
+}
+
+func openSeriesSource(ctx context.Context, st Client, req *storepb.SeriesRequest, opts ...grpc.CallOption) (*seriesSource, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	cl, err := st.Series(ctx, req, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &seriesSource{st: st, cl: cl, cancel: cancel}, nil
+}
+
+func (s *seriesSource) Recv() (*storepb.SeriesResponse, error) {
+	if len(s.buf) > 0 {
+		resp := s.buf[0]
+		s.buf = s.buf[1:]
+		return resp, nil
+	}
+	if s.cl == nil {
+		return nil, io.EOF
+	}
+	resp, err := s.cl.Recv()
+	if err != nil {
+		s.close()
+	}
+	return resp, err
+}
+
+func (s *seriesSource) close() {
+	s.cl = nil
+	s.cancel()
+}
+
+// seriesMerge merges the sorted responses of several stores. Series present in several responses are kept, to be
+// deduplicated by the caller. Series sorting before the ones already streamed are skipped. Other responses are
+// streamed as soon as they are received.
+type seriesMerge struct {
+	ctx   context.Context
+	srcs  []*seriesSource
+	heads []*storepb.SeriesResponse
+	// others are the responses other than series to stream first.
+	others []*storepb.SeriesResponse
+
+	after    labels.Labels
+	hasAfter bool
+}
+
+func (m *seriesMerge) warn(err error) {
+	m.others = append(m.others, storepb.NewWarnSeriesResponse(err))
+}
+
+// fill receives the next series of the source, unless it has one already.
+func (m *seriesMerge) fill(i int) error {
+	src := m.srcs[i]
+	for m.heads[i] == nil {
+		resp, err := src.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if m.ctx.Err() != nil {
+				return err
+			}
+			m.warn(err)
+			return nil
+		}
+		s := resp.GetSeries()
+		if s == nil {
+			m.others = append(m.others, resp)
+			continue
+		}
+		if m.hasAfter && labels.Compare(labelpb.ZLabelsToPromLabels(s.Labels), m.after) <= 0 {
+			continue
+		}
+		m.heads[i] = resp
+	}
+	return nil
+}
+
+func (m *seriesMerge) Recv() (*storepb.SeriesResponse, error) {
+	for i := range m.srcs {
+		if err := m.fill(i); err != nil {
+			m.close()
+			return nil, err
+		}
+	}
+	if len(m.others) > 0 {
+		resp := m.others[0]
+		m.others = m.others[1:]
+		return resp, nil
+	}
+
+	next := -1
+	for i, h := range m.heads {
+		if h == nil {
+			continue
+		}
+		if next == -1 || labels.Compare(
+			labelpb.ZLabelsToPromLabels(h.GetSeries().Labels),
+			labelpb.ZLabelsToPromLabels(m.heads[next].GetSeries().Labels),
+		) < 0 {
+			next = i
+		}
+	}
+	if next == -1 {
+		m.close()
+		return nil, io.EOF
+	}
+	resp := m.heads[next]
+	m.heads[next] = nil
+	return resp, nil
+}
+
+func (m *seriesMerge) close() {
+	for _, src := range m.srcs {
+		src.close()
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc/metadata"
+)
+
+// SeriesPriorityHeader is the gRPC metadata key used to carry the priority class of a Series request.
+const SeriesPriorityHeader = "thanos-series-priority"
+
+// SeriesPriority is the priority class of a Series request. Requests of a higher class are always
+// admitted before requests of a lower class.
+type SeriesPriority string
+
+const (
+	SeriesPriorityHigh   SeriesPriority = "high"
+	SeriesPriorityNormal SeriesPriority = "normal"
+	SeriesPriorityLow    SeriesPriority = "low"
+)
+
+// seriesPriorities lists all priority classes in the order they are served.
+var seriesPriorities = []SeriesPriority{SeriesPriorityHigh, SeriesPriorityNormal, SeriesPriorityLow}
+
+// ParseSeriesPriority parses the given string as a SeriesPriority.
+func ParseSeriesPriority(s string) (SeriesPriority, error) {
+	for _, p := range seriesPriorities {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", errors.Errorf("unknown series priority %q, expected one of %v", s, seriesPriorities)
+}
+
+// GetSeriesPriorityFromGRPCMetadata returns the priority class carried in the incoming gRPC metadata.
+// The second return value reports whether a valid priority was found. SeriesPriorityNormal is returned otherwise.
+func GetSeriesPriorityFromGRPCMetadata(ctx context.Context) (SeriesPriority, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(SeriesPriorityHeader)) == 0 {
+		return SeriesPriorityNormal, false
+	}
+	p, err := ParseSeriesPriority(md.Get(SeriesPriorityHeader)[0])
+	if err != nil {
+		return SeriesPriorityNormal, false
+	}
+	return p, true
+}
+
+// WithSeriesPriority returns a new context that carries the given priority class to the StoreAPI servers called with it.
+func WithSeriesPriority(ctx context.Context, p SeriesPriority) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, SeriesPriorityHeader, string(p))
+}
+
+type seriesSchedulerMetrics struct {
+	queueWait     *prometheus.HistogramVec
+	queueLength   *prometheus.GaugeVec
+	inflight      prometheus.Gauge
+	inflightBytes prometheus.Gauge
+}
+
+func newSeriesSchedulerMetrics(reg prometheus.Registerer) *seriesSchedulerMetrics {
+	return &seriesSchedulerMetrics{
+		queueWait: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "thanos_bucket_store_series_queue_wait_seconds",
+			Help:    "Time spent by Series requests waiting in the scheduler queue before being admitted.",
+			Buckets: []float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120},
+		}, []string{"tenant", "priority"}),
+		queueLength: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_bucket_store_series_queue_length",
+			Help: "Number of Series requests waiting in the scheduler queue.",
+		}, []string{"priority"}),
+		inflight: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_bucket_store_series_scheduler_in_flight",
+			Help: "Number of Series requests admitted by the scheduler that are currently in flight.",
+		}),
+		inflightBytes: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_bucket_store_series_scheduler_in_flight_bytes",
+			Help: "Number of bytes fetched or touched by Series requests that are currently in flight.",
+		}),
+	}
+}
+
+// SeriesScheduler admits Series requests based on a concurrency and an in-flight bytes budget.
+// Waiting requests are served by strict priority class first, then round-robin across tenants
+// within the same class, and finally in FIFO order within the same tenant.
+//
+// The in-flight bytes budget is a soft one: bytes are accounted while a request runs, and no new
+// request is admitted while the budget is exhausted. At least one request is always allowed to run.
+type SeriesScheduler struct {
+	maxConcurrency   int
+	maxInflightBytes uint64
+
+	mtx           sync.Mutex
+	running       int
+	inflightBytes atomic.Uint64
+	queues        map[SeriesPriority]*tenantQueues
+
+	metrics *seriesSchedulerMetrics
+}
+
+// NewSeriesScheduler returns a new SeriesScheduler. A maxConcurrency or maxInflightBytes of 0 disables the respective limit.
+func NewSeriesScheduler(reg prometheus.Registerer, maxConcurrency int, maxInflightBytes uint64) *SeriesScheduler {
+	s := &SeriesScheduler{
+		maxConcurrency:   maxConcurrency,
+		maxInflightBytes: maxInflightBytes,
+		queues:           make(map[SeriesPriority]*tenantQueues, len(seriesPriorities)),
+		metrics:          newSeriesSchedulerMetrics(reg),
+	}
+	for _, p := range seriesPriorities {
+		s.queues[p] = &tenantQueues{tenants: map[string]*list.List{}}
+		s.metrics.queueLength.WithLabelValues(string(p))
+	}
+	return s
+}
+
+// tenantQueues holds per-tenant FIFO queues of a single priority class and dequeues them round-robin.
+type tenantQueues struct {
+	tenants map[string]*list.List
+	order   []string
+	next    int
+}
+
+func (q *tenantQueues) push(t *SeriesTicket) {
+	l, ok := q.tenants[t.tenant]
+	if !ok {
+		l = list.New()
+		q.tenants[t.tenant] = l
+		q.order = append(q.order, t.tenant)
+	}
+	t.elem = l.PushBack(t)
+}
+
+func (q *tenantQueues) pop() *SeriesTicket {
+	if len(q.order) == 0 {
+		return nil
+	}
+	if q.next >= len(q.order) {
+		q.next = 0
+	}
+	tenant := q.order[q.next]
+	l := q.tenants[tenant]
+	t := l.Remove(l.Front()).(*SeriesTicket)
+	t.elem = nil
+	if l.Len() == 0 {
+		q.removeTenant(q.next)
+	} else {
+		q.next++
+	}
+	return t
+}
+
+func (q *tenantQueues) remove(t *SeriesTicket) {
+	l := q.tenants[t.tenant]
+	l.Remove(t.elem)
+	t.elem = nil
+	if l.Len() > 0 {
+		return
+	}
+	for i, tenant := range q.order {
+		if tenant == t.tenant {
+			q.removeTenant(i)
+			if i < q.next {
+				q.next--
+			}
+			return
+		}
+	}
+}
+
+func (q *tenantQueues) removeTenant(i int) {
+	delete(q.tenants, q.order[i])
+	q.order = append(q.order[:i], q.order[i+1:]...)
+}
+
+// SeriesTicket represents a Series request going through the SeriesScheduler.
+type SeriesTicket struct {
+	s        *SeriesScheduler
+	tenant   string
+	priority SeriesPriority
+	enqueued time.Time
+	admitted chan struct{}
+	elem     *list.Element
+	bytes    atomic.Uint64
+}
+
+// Acquire waits until the request of the given tenant and priority can run. The returned ticket
+// must be released with Done once the request finishes.
+func (s *SeriesScheduler) Acquire(ctx context.Context, tenant string, priority SeriesPriority) (*SeriesTicket, error) {
+	t := &SeriesTicket{
+		s:        s,
+		tenant:   tenant,
+		priority: priority,
+		enqueued: time.Now(),
+		admitted: make(chan struct{}),
+	}
+
+	s.mtx.Lock()
+	s.queues[priority].push(t)
+	s.metrics.queueLength.WithLabelValues(string(priority)).Inc()
+	s.dispatch()
+	s.mtx.Unlock()
+
+	select {
+	case <-t.admitted:
+		return t, nil
+	case <-ctx.Done():
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	select {
+	case <-t.admitted:
+		// We were admitted while giving up, hand the slot over to the next request.
+		s.release(t)
+	default:
+		s.queues[priority].remove(t)
+		s.metrics.queueLength.WithLabelValues(string(priority)).Dec()
+	}
+	return nil, ctx.Err()
+}
+
+// canAdmit must be called with the lock held.
+func (s *SeriesScheduler) canAdmit() bool {
+	if s.running == 0 {
+		return true
+	}
+	if s.maxConcurrency > 0 && s.running >= s.maxConcurrency {
+		return false
+	}
+	return s.maxInflightBytes == 0 || s.inflightBytes.Load() < s.maxInflightBytes
+}
+
+// dispatch admits as many queued requests as the budget allows. It must be called with the lock held.
+func (s *SeriesScheduler) dispatch() {
+	for s.canAdmit() {
+		var t *SeriesTicket
+		for _, p := range seriesPriorities {
+			if t = s.queues[p].pop(); t != nil {
+				break
+			}
+		}
+		if t == nil {
+			return
+		}
+		s.running++
+		s.metrics.inflight.Inc()
+		s.metrics.queueLength.WithLabelValues(string(t.priority)).Dec()
+		s.metrics.queueWait.WithLabelValues(t.tenant, string(t.priority)).Observe(time.Since(t.enqueued).Seconds())
+		close(t.admitted)
+	}
+}
+
+// release must be called with the lock held.
+func (s *SeriesScheduler) release(t *SeriesTicket) {
+	b := t.bytes.Swap(0)
+	s.inflightBytes.Sub(b)
+	s.metrics.inflightBytes.Sub(float64(b))
+	s.running--
+	s.metrics.inflight.Dec()
+	s.dispatch()
+}
+
+// Done releases the budget held by the ticket and admits the next waiting requests.
+func (t *SeriesTicket) Done() {
+	t.s.mtx.Lock()
+	defer t.s.mtx.Unlock()
+	t.s.release(t)
+}
+
+// TrackBytes wraps the given BytesLimiter so that all reserved bytes are accounted in the
+// in-flight bytes of the scheduler until the ticket is released.
+func (t *SeriesTicket) TrackBytes(l BytesLimiter) BytesLimiter {
+	return &schedulerBytesLimiter{BytesLimiter: l, t: t}
+}
+
+type schedulerBytesLimiter struct {
+	BytesLimiter
+	t *SeriesTicket
+}
+
+func (l *schedulerBytesLimiter) ReserveWithType(num uint64, dataType StoreDataType) error {
+	l.t.bytes.Add(num)
+	l.t.s.inflightBytes.Add(num)
+	l.t.s.metrics.inflightBytes.Add(float64(num))
+	return l.BytesLimiter.ReserveWithType(num, dataType)
+}
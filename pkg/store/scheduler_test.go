@@ -0,0 +1,140 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/metadata"
+)
+
+// enqueue starts acquiring a ticket in the background and waits until the request is queued.
+func enqueue(t *testing.T, s *SeriesScheduler, tenant string, p SeriesPriority) <-chan *SeriesTicket {
+	t.Helper()
+
+	before := prom_testutil.ToFloat64(s.metrics.queueLength.WithLabelValues(string(p)))
+	ch := make(chan *SeriesTicket, 1)
+	go func() {
+		ticket, err := s.Acquire(context.Background(), tenant, p)
+		if err != nil {
+			close(ch)
+			return
+		}
+		ch <- ticket
+	}()
+	testutil.Ok(t, waitFor(func() bool {
+		return prom_testutil.ToFloat64(s.metrics.queueLength.WithLabelValues(string(p))) == before+1
+	}))
+	return ch
+}
+
+func waitFor(cond func() bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return nil
+}
+
+func TestSeriesScheduler_PriorityAndFairness(t *testing.T) {
+	t.Parallel()
+
+	s := NewSeriesScheduler(nil, 1, 0)
+
+	running, err := s.Acquire(context.Background(), "a", SeriesPriorityNormal)
+	testutil.Ok(t, err)
+
+	lowA := enqueue(t, s, "a", SeriesPriorityLow)
+	normalA1 := enqueue(t, s, "a", SeriesPriorityNormal)
+	normalA2 := enqueue(t, s, "a", SeriesPriorityNormal)
+	normalB := enqueue(t, s, "b", SeriesPriorityNormal)
+	high := enqueue(t, s, "c", SeriesPriorityHigh)
+
+	// High priority goes first, then tenants are served round-robin, low priority goes last.
+	expected := []<-chan *SeriesTicket{high, normalA1, normalB, normalA2, lowA}
+	running.Done()
+	for i, ch := range expected {
+		select {
+		case ticket := <-ch:
+			for _, other := range expected[i+1:] {
+				testutil.Equals(t, 0, len(other))
+			}
+			ticket.Done()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request %d was not admitted", i)
+		}
+	}
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(s.metrics.inflight))
+}
+
+func TestSeriesScheduler_InflightBytes(t *testing.T) {
+	t.Parallel()
+
+	s := NewSeriesScheduler(nil, 0, 100)
+
+	first, err := s.Acquire(context.Background(), "a", SeriesPriorityNormal)
+	testutil.Ok(t, err)
+	testutil.Ok(t, first.TrackBytes(NewBytesLimiterFactory(0)(nil)).ReserveWithType(100, PostingsFetched))
+	testutil.Equals(t, 100.0, prom_testutil.ToFloat64(s.metrics.inflightBytes))
+
+	// The budget is exhausted, so the next request has to wait.
+	second := enqueue(t, s, "a", SeriesPriorityNormal)
+	testutil.Equals(t, 0, len(second))
+
+	first.Done()
+	select {
+	case ticket := <-second:
+		ticket.Done()
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not admitted after the budget was released")
+	}
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(s.metrics.inflightBytes))
+}
+
+func TestSeriesScheduler_ContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	s := NewSeriesScheduler(nil, 1, 0)
+
+	running, err := s.Acquire(context.Background(), "a", SeriesPriorityNormal)
+	testutil.Ok(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = s.Acquire(ctx, "b", SeriesPriorityNormal)
+	testutil.Equals(t, context.DeadlineExceeded, err)
+	testutil.Equals(t, 0.0, prom_testutil.ToFloat64(s.metrics.queueLength.WithLabelValues(string(SeriesPriorityNormal))))
+
+	running.Done()
+	ticket, err := s.Acquire(context.Background(), "b", SeriesPriorityNormal)
+	testutil.Ok(t, err)
+	ticket.Done()
+}
+
+func TestGetSeriesPriorityFromGRPCMetadata(t *testing.T) {
+	t.Parallel()
+
+	p, ok := GetSeriesPriorityFromGRPCMetadata(context.Background())
+	testutil.Assert(t, !ok)
+	testutil.Equals(t, SeriesPriorityNormal, p)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(SeriesPriorityHeader, "high"))
+	p, ok = GetSeriesPriorityFromGRPCMetadata(ctx)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, SeriesPriorityHigh, p)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(SeriesPriorityHeader, "urgent"))
+	p, ok = GetSeriesPriorityFromGRPCMetadata(ctx)
+	testutil.Assert(t, !ok)
+	testutil.Equals(t, SeriesPriorityNormal, p)
+}
@@ -52,6 +52,14 @@ func NewHintsSeriesResponse(hints *types.Any) *SeriesResponse {
 	}
 }
 
+func NewEstimateSeriesResponse(series, chunks int64) *SeriesResponse {
+	return &SeriesResponse{
+		Result: &SeriesResponse_Estimate{
+			Estimate: &SeriesEstimate{Series: series, Chunks: chunks},
+		},
+	}
+}
+
 func GRPCCodeFromWarn(warn string) codes.Code {
 	if strings.Contains(warn, "rpc error: code = ResourceExhausted") {
 		return codes.ResourceExhausted
@@ -504,6 +512,8 @@ type SeriesStatsCounter struct {
 	Series  int
 	Chunks  int
 	Samples int
+	// Bytes is the size of the data of the chunks.
+	Bytes int
 }
 
 func (c *SeriesStatsCounter) CountSeries(seriesLabels []labelpb.ZLabel) {
@@ -520,31 +530,37 @@ func (c *SeriesStatsCounter) Count(series *Series) {
 		if chk.Raw != nil {
 			c.Chunks++
 			c.Samples += chk.Raw.XORNumSamples()
+			c.Bytes += len(chk.Raw.Data)
 		}
 
 		if chk.Count != nil {
 			c.Chunks++
 			c.Samples += chk.Count.XORNumSamples()
+			c.Bytes += len(chk.Count.Data)
 		}
 
 		if chk.Counter != nil {
 			c.Chunks++
 			c.Samples += chk.Counter.XORNumSamples()
+			c.Bytes += len(chk.Counter.Data)
 		}
 
 		if chk.Max != nil {
 			c.Chunks++
 			c.Samples += chk.Max.XORNumSamples()
+			c.Bytes += len(chk.Max.Data)
 		}
 
 		if chk.Min != nil {
 			c.Chunks++
 			c.Samples += chk.Min.XORNumSamples()
+			c.Bytes += len(chk.Min.Data)
 		}
 
 		if chk.Sum != nil {
 			c.Chunks++
 			c.Samples += chk.Sum.XORNumSamples()
+			c.Bytes += len(chk.Sum.Data)
 		}
 	}
 }
@@ -182,6 +182,10 @@ type SeriesRequest struct {
 	WithoutReplicaLabels []string `protobuf:"bytes,14,rep,name=without_replica_labels,json=withoutReplicaLabels,proto3" json:"without_replica_labels,omitempty"`
 	// limit is used to limit the number of results returned
 	Limit int64 `protobuf:"varint,15,opt,name=limit,proto3" json:"limit,omitempty"`
+	// estimate makes the store respond with a single SeriesEstimate frame instead of series, computed
+	// from the index only. It is meant for cheap cost estimation before running a query.
+	// Stores not supporting it respond with series, which clients are expected to discard.
+	Estimate bool `protobuf:"varint,16,opt,name=estimate,proto3" json:"estimate,omitempty"`
 }
 
 func (m *SeriesRequest) Reset()         { *m = SeriesRequest{} }
@@ -430,6 +434,7 @@ type SeriesResponse struct {
 	//	*SeriesResponse_Series
 	//	*SeriesResponse_Warning
 	//	*SeriesResponse_Hints
+	//	*SeriesResponse_Estimate
 	Result isSeriesResponse_Result `protobuf_oneof:"result"`
 }
 
@@ -481,10 +486,14 @@ type SeriesResponse_Warning struct {
 type SeriesResponse_Hints struct {
 	Hints *types.Any `protobuf:"bytes,3,opt,name=hints,proto3,oneof" json:"hints,omitempty"`
 }
+type SeriesResponse_Estimate struct {
+	Estimate *SeriesEstimate `protobuf:"bytes,4,opt,name=estimate,proto3,oneof" json:"estimate,omitempty"`
+}
 
-func (*SeriesResponse_Series) isSeriesResponse_Result()  {}
-func (*SeriesResponse_Warning) isSeriesResponse_Result() {}
-func (*SeriesResponse_Hints) isSeriesResponse_Result()   {}
+func (*SeriesResponse_Series) isSeriesResponse_Result()   {}
+func (*SeriesResponse_Warning) isSeriesResponse_Result()  {}
+func (*SeriesResponse_Hints) isSeriesResponse_Result()    {}
+func (*SeriesResponse_Estimate) isSeriesResponse_Result() {}
 
 func (m *SeriesResponse) GetResult() isSeriesResponse_Result {
 	if m != nil {
@@ -514,14 +523,63 @@ func (m *SeriesResponse) GetHints() *types.Any {
 	return nil
 }
 
+func (m *SeriesResponse) GetEstimate() *SeriesEstimate {
+	if x, ok := m.GetResult().(*SeriesResponse_Estimate); ok {
+		return x.Estimate
+	}
+	return nil
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*SeriesResponse) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
 		(*SeriesResponse_Series)(nil),
 		(*SeriesResponse_Warning)(nil),
 		(*SeriesResponse_Hints)(nil),
+		(*SeriesResponse_Estimate)(nil),
+	}
+}
+
+// SeriesEstimate is the estimated cost of a Series request.
+type SeriesEstimate struct {
+	// Upper bound of the number of series matching the request.
+	Series int64 `protobuf:"varint,1,opt,name=series,proto3" json:"series,omitempty"`
+	// Number of chunks the matching series are expected to have within the requested time range.
+	Chunks int64 `protobuf:"varint,2,opt,name=chunks,proto3" json:"chunks,omitempty"`
+}
+
+func (m *SeriesEstimate) Reset()         { *m = SeriesEstimate{} }
+func (m *SeriesEstimate) String() string { return proto.CompactTextString(m) }
+func (*SeriesEstimate) ProtoMessage()    {}
+func (*SeriesEstimate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a938d55a388af629, []int{9}
+}
+func (m *SeriesEstimate) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SeriesEstimate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SeriesEstimate.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
 	}
 }
+func (m *SeriesEstimate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SeriesEstimate.Merge(m, src)
+}
+func (m *SeriesEstimate) XXX_Size() int {
+	return m.Size()
+}
+func (m *SeriesEstimate) XXX_DiscardUnknown() {
+	xxx_messageInfo_SeriesEstimate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SeriesEstimate proto.InternalMessageInfo
 
 type LabelNamesRequest struct {
 	PartialResponseDisabled bool `protobuf:"varint,1,opt,name=partial_response_disabled,json=partialResponseDisabled,proto3" json:"partial_response_disabled,omitempty"`
@@ -544,7 +602,7 @@ func (m *LabelNamesRequest) Reset()         { *m = LabelNamesRequest{} }
 func (m *LabelNamesRequest) String() string { return proto.CompactTextString(m) }
 func (*LabelNamesRequest) ProtoMessage()    {}
 func (*LabelNamesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{9}
+	return fileDescriptor_a938d55a388af629, []int{10}
 }
 func (m *LabelNamesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -586,7 +644,7 @@ func (m *LabelNamesResponse) Reset()         { *m = LabelNamesResponse{} }
 func (m *LabelNamesResponse) String() string { return proto.CompactTextString(m) }
 func (*LabelNamesResponse) ProtoMessage()    {}
 func (*LabelNamesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{10}
+	return fileDescriptor_a938d55a388af629, []int{11}
 }
 func (m *LabelNamesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -637,7 +695,7 @@ func (m *LabelValuesRequest) Reset()         { *m = LabelValuesRequest{} }
 func (m *LabelValuesRequest) String() string { return proto.CompactTextString(m) }
 func (*LabelValuesRequest) ProtoMessage()    {}
 func (*LabelValuesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{11}
+	return fileDescriptor_a938d55a388af629, []int{12}
 }
 func (m *LabelValuesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -679,7 +737,7 @@ func (m *LabelValuesResponse) Reset()         { *m = LabelValuesResponse{} }
 func (m *LabelValuesResponse) String() string { return proto.CompactTextString(m) }
 func (*LabelValuesResponse) ProtoMessage()    {}
 func (*LabelValuesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{12}
+	return fileDescriptor_a938d55a388af629, []int{13}
 }
 func (m *LabelValuesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -719,6 +777,7 @@ func init() {
 	proto.RegisterType((*Grouping)(nil), "thanos.Grouping")
 	proto.RegisterType((*Range)(nil), "thanos.Range")
 	proto.RegisterType((*SeriesResponse)(nil), "thanos.SeriesResponse")
+	proto.RegisterType((*SeriesEstimate)(nil), "thanos.SeriesEstimate")
 	proto.RegisterType((*LabelNamesRequest)(nil), "thanos.LabelNamesRequest")
 	proto.RegisterType((*LabelNamesResponse)(nil), "thanos.LabelNamesResponse")
 	proto.RegisterType((*LabelValuesRequest)(nil), "thanos.LabelValuesRequest")
@@ -728,79 +787,83 @@ func init() {
 func init() { proto.RegisterFile("store/storepb/rpc.proto", fileDescriptor_a938d55a388af629) }
 
 var fileDescriptor_a938d55a388af629 = []byte{
-	// 1149 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x56, 0x4b, 0x6f, 0x23, 0x45,
-	0x10, 0xf6, 0x78, 0x3c, 0x7e, 0x94, 0x13, 0xaf, 0xb7, 0xd7, 0xc9, 0x4e, 0xbc, 0x92, 0x63, 0x8c,
-	0x90, 0xac, 0x55, 0xe4, 0xac, 0xbc, 0x08, 0x09, 0xc4, 0x25, 0x09, 0x2c, 0x59, 0x89, 0x04, 0xe8,
-	0xec, 0x12, 0x04, 0x87, 0x51, 0xdb, 0xee, 0x8c, 0x47, 0x3b, 0xaf, 0x4c, 0xf7, 0x90, 0xf8, 0x0c,
-	0x67, 0xc4, 0x9d, 0xdb, 0xfe, 0x9a, 0xdc, 0xd8, 0x23, 0x27, 0x04, 0xc9, 0x1f, 0x41, 0xfd, 0x18,
-	0x3f, 0x82, 0xf7, 0xa5, 0xe4, 0x62, 0x75, 0x7d, 0x5f, 0x75, 0x4d, 0x75, 0xf5, 0x57, 0xe5, 0x86,
-	0xfb, 0x8c, 0x47, 0x09, 0xdd, 0x96, 0xbf, 0xf1, 0x60, 0x3b, 0x89, 0x87, 0xbd, 0x38, 0x89, 0x78,
-	0x84, 0x8a, 0x7c, 0x4c, 0xc2, 0x88, 0x35, 0x37, 0x16, 0x1d, 0xf8, 0x24, 0xa6, 0x4c, 0xb9, 0x34,
-	0x1b, 0x6e, 0xe4, 0x46, 0x72, 0xb9, 0x2d, 0x56, 0x1a, 0x6d, 0x2f, 0x6e, 0x88, 0x93, 0x28, 0xb8,
-	0xb6, 0x6f, 0xc3, 0x8d, 0x22, 0xd7, 0xa7, 0xdb, 0xd2, 0x1a, 0xa4, 0x27, 0xdb, 0x24, 0x9c, 0x28,
-	0xaa, 0x73, 0x07, 0x56, 0x8f, 0x13, 0x8f, 0x53, 0x4c, 0x59, 0x1c, 0x85, 0x8c, 0x76, 0x7e, 0x31,
-	0x60, 0x45, 0x23, 0xa7, 0x29, 0x65, 0x1c, 0xed, 0x00, 0x70, 0x2f, 0xa0, 0x8c, 0x26, 0x1e, 0x65,
-	0xb6, 0xd1, 0x36, 0xbb, 0xd5, 0xfe, 0x03, 0xb1, 0x3b, 0xa0, 0x7c, 0x4c, 0x53, 0xe6, 0x0c, 0xa3,
-	0x78, 0xd2, 0x7b, 0xe6, 0x05, 0xf4, 0x48, 0xba, 0xec, 0x16, 0x2e, 0xfe, 0xde, 0xcc, 0xe1, 0xb9,
-	0x4d, 0x68, 0x1d, 0x8a, 0x9c, 0x86, 0x24, 0xe4, 0x76, 0xbe, 0x6d, 0x74, 0x2b, 0x58, 0x5b, 0xc8,
-	0x86, 0x52, 0x42, 0x63, 0xdf, 0x1b, 0x12, 0xdb, 0x6c, 0x1b, 0x5d, 0x13, 0x67, 0x66, 0xe7, 0xa5,
-	0x05, 0xab, 0x2a, 0x5c, 0x96, 0xc6, 0x06, 0x94, 0x03, 0x2f, 0x74, 0x44, 0x54, 0xdb, 0x50, 0xce,
-	0x81, 0x17, 0x8a, 0xcf, 0x4a, 0x8a, 0x9c, 0x2b, 0x2a, 0xaf, 0x29, 0x72, 0x2e, 0xa9, 0x4f, 0x04,
-	0xc5, 0x87, 0x63, 0x9a, 0x30, 0xdb, 0x94, 0xa9, 0x37, 0x7a, 0xaa, 0xce, 0xbd, 0xaf, 0xc9, 0x80,
-	0xfa, 0x07, 0x8a, 0xd4, 0x39, 0x4f, 0x7d, 0x51, 0x1f, 0xd6, 0x44, 0xc8, 0x84, 0xb2, 0xc8, 0x4f,
-	0xb9, 0x17, 0x85, 0xce, 0x99, 0x17, 0x8e, 0xa2, 0x33, 0xbb, 0x20, 0xe3, 0xdf, 0x0b, 0xc8, 0x39,
-	0x9e, 0x72, 0xc7, 0x92, 0x42, 0x5b, 0x00, 0xc4, 0x75, 0x13, 0xea, 0x12, 0x4e, 0x99, 0x6d, 0xb5,
-	0xcd, 0x6e, 0xad, 0xbf, 0x92, 0x7d, 0x6d, 0xc7, 0x75, 0x13, 0x3c, 0xc7, 0xa3, 0xcf, 0x60, 0x23,
-	0x26, 0x09, 0xf7, 0x88, 0x2f, 0xbe, 0x22, 0x6b, 0xef, 0x8c, 0x3c, 0x46, 0x06, 0x3e, 0x1d, 0xd9,
-	0xc5, 0xb6, 0xd1, 0x2d, 0xe3, 0xfb, 0xda, 0x21, 0xbb, 0x9b, 0x2f, 0x34, 0x8d, 0x7e, 0x5a, 0xb2,
-	0x97, 0xf1, 0x84, 0x70, 0xea, 0x4e, 0xec, 0x52, 0xdb, 0xe8, 0xd6, 0xfa, 0x9b, 0xd9, 0x87, 0xbf,
-	0x5d, 0x8c, 0x71, 0xa4, 0xdd, 0xfe, 0x17, 0x3c, 0x23, 0xd0, 0x26, 0x54, 0xd9, 0x0b, 0x2f, 0x76,
-	0x86, 0xe3, 0x34, 0x7c, 0xc1, 0xec, 0xb2, 0x4c, 0x05, 0x04, 0xb4, 0x27, 0x11, 0xf4, 0x10, 0xac,
-	0xb1, 0x17, 0x72, 0x66, 0x57, 0xda, 0x86, 0x2c, 0xa8, 0x52, 0x57, 0x2f, 0x53, 0x57, 0x6f, 0x27,
-	0x9c, 0x60, 0xe5, 0x82, 0x10, 0x14, 0x18, 0xa7, 0xb1, 0x0d, 0xb2, 0x6c, 0x72, 0x8d, 0x1a, 0x60,
-	0x25, 0x24, 0x74, 0xa9, 0x5d, 0x95, 0xa0, 0x32, 0xd0, 0x63, 0xa8, 0x9e, 0xa6, 0x34, 0x99, 0x38,
-	0x2a, 0xf6, 0x8a, 0x8c, 0x8d, 0xb2, 0x53, 0x7c, 0x27, 0xa8, 0x7d, 0xc1, 0x60, 0x38, 0x9d, 0xae,
-	0xd1, 0x23, 0x00, 0x36, 0x26, 0xc9, 0xc8, 0xf1, 0xc2, 0x93, 0xc8, 0x5e, 0x95, 0x7b, 0xee, 0x66,
-	0x7b, 0x8e, 0x04, 0xf3, 0x34, 0x3c, 0x89, 0x70, 0x85, 0x65, 0x4b, 0xf4, 0x31, 0xac, 0x9f, 0x79,
-	0x7c, 0x1c, 0xa5, 0xdc, 0xd1, 0x5a, 0x73, 0x7c, 0x21, 0x04, 0x66, 0xd7, 0xda, 0x66, 0xb7, 0x82,
-	0x1b, 0x9a, 0xc5, 0x8a, 0x94, 0x22, 0x61, 0x22, 0x65, 0xdf, 0x0b, 0x3c, 0x6e, 0xdf, 0x51, 0x29,
-	0x4b, 0xa3, 0xf3, 0xd2, 0x00, 0x98, 0x25, 0x26, 0x0b, 0xc7, 0x69, 0xec, 0x04, 0x9e, 0xef, 0x7b,
-	0x4c, 0x8b, 0x14, 0x04, 0x74, 0x20, 0x11, 0xd4, 0x86, 0xc2, 0x49, 0x1a, 0x0e, 0xa5, 0x46, 0xab,
-	0x33, 0x69, 0x3c, 0x49, 0xc3, 0x21, 0x96, 0x0c, 0xda, 0x82, 0xb2, 0x9b, 0x44, 0x69, 0xec, 0x85,
-	0xae, 0x54, 0x5a, 0xb5, 0x5f, 0xcf, 0xbc, 0xbe, 0xd2, 0x38, 0x9e, 0x7a, 0xa0, 0x0f, 0xb3, 0x42,
-	0x5a, 0xd2, 0x75, 0x35, 0x73, 0xc5, 0x02, 0xd4, 0x75, 0xed, 0x9c, 0x41, 0x65, 0x5a, 0x08, 0x99,
-	0xa2, 0xae, 0xd7, 0x88, 0x9e, 0x4f, 0x53, 0x54, 0xfc, 0x88, 0x9e, 0xa3, 0x0f, 0x60, 0x85, 0x47,
-	0x9c, 0xf8, 0x8e, 0xc4, 0x98, 0x6e, 0xa7, 0xaa, 0xc4, 0x64, 0x18, 0x86, 0x6a, 0x90, 0x1f, 0x4c,
-	0x64, 0xbf, 0x96, 0x71, 0x7e, 0x30, 0x11, 0xcd, 0xad, 0x2b, 0x58, 0x90, 0x15, 0xd4, 0x56, 0xa7,
-	0x09, 0x05, 0x71, 0x32, 0x21, 0x81, 0x90, 0xe8, 0xa6, 0xad, 0x60, 0xb9, 0xee, 0xf4, 0xa1, 0x9c,
-	0x9d, 0x47, 0xc7, 0x33, 0x96, 0xc4, 0x33, 0x17, 0xe2, 0x6d, 0x82, 0x25, 0x0f, 0x26, 0x1c, 0x16,
-	0x4a, 0xac, 0xad, 0xce, 0x6f, 0x06, 0xd4, 0xb2, 0x99, 0xa1, 0x34, 0x8d, 0xba, 0x50, 0x9c, 0xce,
-	0x2d, 0x51, 0xa2, 0xda, 0x54, 0x1b, 0x12, 0xdd, 0xcf, 0x61, 0xcd, 0xa3, 0x26, 0x94, 0xce, 0x48,
-	0x12, 0x8a, 0xc2, 0xcb, 0x19, 0xb5, 0x9f, 0xc3, 0x19, 0x80, 0xb6, 0x32, 0xc1, 0x9b, 0xaf, 0x17,
-	0xfc, 0x7e, 0x4e, 0x4b, 0x7e, 0xb7, 0x0c, 0xc5, 0x84, 0xb2, 0xd4, 0xe7, 0x9d, 0x5f, 0x4d, 0xb8,
-	0x2b, 0x05, 0x74, 0x48, 0x82, 0xd9, 0x20, 0x7b, 0x63, 0xe3, 0x1b, 0x37, 0x68, 0xfc, 0xfc, 0x0d,
-	0x1b, 0xbf, 0x01, 0x16, 0xe3, 0x24, 0xe1, 0x7a, 0x16, 0x2b, 0x03, 0xd5, 0xc1, 0xa4, 0xe1, 0x48,
-	0xcf, 0x3d, 0xb1, 0x9c, 0xf5, 0xbf, 0xf5, 0xf6, 0xfe, 0x9f, 0x9f, 0xbf, 0xc5, 0xf7, 0x98, 0xbf,
-	0xaf, 0x6f, 0xd3, 0xd2, 0xbb, 0xb4, 0x69, 0x79, 0xbe, 0x4d, 0x13, 0x40, 0xf3, 0xb7, 0xa0, 0xa5,
-	0xd1, 0x00, 0x4b, 0x48, 0x51, 0xfd, 0xa3, 0x55, 0xb0, 0x32, 0x50, 0x13, 0xca, 0xfa, 0xd6, 0x85,
-	0xf6, 0x05, 0x31, 0xb5, 0x67, 0xe7, 0x36, 0xdf, 0x7a, 0xee, 0xce, 0x1f, 0xa6, 0xfe, 0xe8, 0xf7,
-	0xc4, 0x4f, 0x67, 0x77, 0x2f, 0x12, 0x14, 0xa8, 0x6e, 0x06, 0x65, 0xbc, 0x59, 0x11, 0xf9, 0x1b,
-	0x28, 0xc2, 0xbc, 0x2d, 0x45, 0x14, 0x96, 0x28, 0xc2, 0x5a, 0xa2, 0x88, 0xe2, 0xfb, 0x29, 0xa2,
-	0x74, 0x2b, 0x8a, 0x28, 0xbf, 0x8b, 0x22, 0x2a, 0xf3, 0x8a, 0x48, 0xe1, 0xde, 0xc2, 0xe5, 0x68,
-	0x49, 0xac, 0x43, 0xf1, 0x67, 0x89, 0x68, 0x4d, 0x68, 0xeb, 0xb6, 0x44, 0xf1, 0x70, 0x17, 0x0a,
-	0xe2, 0x19, 0x80, 0x4a, 0x60, 0xe2, 0x9d, 0xe3, 0x7a, 0x0e, 0x55, 0xc0, 0xda, 0xfb, 0xe6, 0xf9,
-	0xe1, 0xb3, 0xba, 0x21, 0xb0, 0xa3, 0xe7, 0x07, 0xf5, 0xbc, 0x58, 0x1c, 0x3c, 0x3d, 0xac, 0x9b,
-	0x72, 0xb1, 0xf3, 0x43, 0xbd, 0x80, 0xaa, 0x50, 0x92, 0x5e, 0x5f, 0xe2, 0xba, 0xd5, 0xff, 0xd3,
-	0x00, 0xeb, 0x48, 0xbc, 0xf4, 0xd0, 0xa7, 0x50, 0x54, 0x53, 0x0c, 0xad, 0x2d, 0x4e, 0x35, 0x2d,
-	0xb6, 0xe6, 0xfa, 0x75, 0x58, 0x1d, 0xf3, 0x91, 0x81, 0xf6, 0x00, 0x66, 0x1d, 0x81, 0x36, 0x16,
-	0xea, 0x3f, 0x3f, 0xab, 0x9a, 0xcd, 0x65, 0x94, 0xae, 0xd6, 0x13, 0xa8, 0xce, 0x15, 0x11, 0x2d,
-	0xba, 0x2e, 0xc8, 0xbe, 0xf9, 0x60, 0x29, 0xa7, 0xe2, 0xf4, 0x0f, 0xa1, 0x26, 0xdf, 0x9b, 0x42,
-	0xcf, 0xea, 0x64, 0x9f, 0x43, 0x15, 0xd3, 0x20, 0xe2, 0x54, 0xe2, 0x68, 0xaa, 0x8f, 0xf9, 0x67,
-	0x69, 0x73, 0xed, 0x1a, 0xaa, 0x9f, 0xaf, 0xb9, 0xdd, 0x8f, 0x2e, 0xfe, 0x6d, 0xe5, 0x2e, 0x2e,
-	0x5b, 0xc6, 0xab, 0xcb, 0x96, 0xf1, 0xcf, 0x65, 0xcb, 0xf8, 0xfd, 0xaa, 0x95, 0x7b, 0x75, 0xd5,
-	0xca, 0xfd, 0x75, 0xd5, 0xca, 0xfd, 0x58, 0xd2, 0xcf, 0xe4, 0x41, 0x51, 0xde, 0xd0, 0xe3, 0xff,
-	0x02, 0x00, 0x00, 0xff, 0xff, 0x84, 0xe1, 0x09, 0x34, 0x90, 0x0b, 0x00, 0x00,
+	// 1202 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x56, 0x4b, 0x6f, 0xdb, 0x46,
+	0x10, 0x16, 0x45, 0x51, 0x8f, 0x91, 0xad, 0x28, 0x1b, 0x59, 0xa1, 0x15, 0x40, 0x56, 0x59, 0x14,
+	0x10, 0x02, 0x43, 0x0e, 0x94, 0xa0, 0x40, 0x8b, 0x1e, 0x6a, 0xbb, 0x49, 0x1d, 0xa0, 0x76, 0x5b,
+	0x3a, 0xa9, 0x8b, 0xf6, 0x40, 0x50, 0xd2, 0x9a, 0x22, 0xc2, 0x97, 0xb9, 0xcb, 0xda, 0x3a, 0xb7,
+	0x3f, 0xa0, 0xf7, 0xde, 0xfa, 0x43, 0x7a, 0xea, 0xc1, 0xb7, 0xe6, 0xd8, 0x53, 0xd1, 0xda, 0x7f,
+	0xa4, 0xd8, 0x07, 0x29, 0xd1, 0x51, 0x5e, 0xb0, 0x2f, 0xc4, 0xce, 0x7c, 0xb3, 0xb3, 0x33, 0xb3,
+	0xdf, 0x0c, 0x17, 0xee, 0x12, 0x1a, 0xc6, 0x78, 0x8b, 0x7f, 0xa3, 0xd1, 0x56, 0x1c, 0x8d, 0x07,
+	0x51, 0x1c, 0xd2, 0x10, 0x95, 0xe9, 0xd4, 0x0e, 0x42, 0xd2, 0x59, 0xcf, 0x1b, 0xd0, 0x59, 0x84,
+	0x89, 0x30, 0xe9, 0xb4, 0x9c, 0xd0, 0x09, 0xf9, 0x72, 0x8b, 0xad, 0xa4, 0xb6, 0x97, 0xdf, 0x10,
+	0xc5, 0xa1, 0x7f, 0x65, 0xdf, 0xba, 0x13, 0x86, 0x8e, 0x87, 0xb7, 0xb8, 0x34, 0x4a, 0x8e, 0xb7,
+	0xec, 0x60, 0x26, 0x20, 0xe3, 0x16, 0xac, 0x1e, 0xc5, 0x2e, 0xc5, 0x26, 0x26, 0x51, 0x18, 0x10,
+	0x6c, 0xfc, 0xac, 0xc0, 0x8a, 0xd4, 0x9c, 0x24, 0x98, 0x50, 0xb4, 0x0d, 0x40, 0x5d, 0x1f, 0x13,
+	0x1c, 0xbb, 0x98, 0xe8, 0x4a, 0x4f, 0xed, 0xd7, 0x87, 0xf7, 0xd8, 0x6e, 0x1f, 0xd3, 0x29, 0x4e,
+	0x88, 0x35, 0x0e, 0xa3, 0xd9, 0xe0, 0x99, 0xeb, 0xe3, 0x43, 0x6e, 0xb2, 0x53, 0x3a, 0xff, 0x67,
+	0xa3, 0x60, 0x2e, 0x6c, 0x42, 0x6d, 0x28, 0x53, 0x1c, 0xd8, 0x01, 0xd5, 0x8b, 0x3d, 0xa5, 0x5f,
+	0x33, 0xa5, 0x84, 0x74, 0xa8, 0xc4, 0x38, 0xf2, 0xdc, 0xb1, 0xad, 0xab, 0x3d, 0xa5, 0xaf, 0x9a,
+	0xa9, 0x68, 0xfc, 0xa1, 0xc1, 0xaa, 0x70, 0x97, 0x86, 0xb1, 0x0e, 0x55, 0xdf, 0x0d, 0x2c, 0xe6,
+	0x55, 0x57, 0x84, 0xb1, 0xef, 0x06, 0xec, 0x58, 0x0e, 0xd9, 0x67, 0x02, 0x2a, 0x4a, 0xc8, 0x3e,
+	0xe3, 0xd0, 0xc7, 0x0c, 0xa2, 0xe3, 0x29, 0x8e, 0x89, 0xae, 0xf2, 0xd0, 0x5b, 0x03, 0x51, 0xe7,
+	0xc1, 0x57, 0xf6, 0x08, 0x7b, 0xfb, 0x02, 0x94, 0x31, 0x67, 0xb6, 0x68, 0x08, 0x6b, 0xcc, 0x65,
+	0x8c, 0x49, 0xe8, 0x25, 0xd4, 0x0d, 0x03, 0xeb, 0xd4, 0x0d, 0x26, 0xe1, 0xa9, 0x5e, 0xe2, 0xfe,
+	0xef, 0xf8, 0xf6, 0x99, 0x99, 0x61, 0x47, 0x1c, 0x42, 0x9b, 0x00, 0xb6, 0xe3, 0xc4, 0xd8, 0xb1,
+	0x29, 0x26, 0xba, 0xd6, 0x53, 0xfb, 0x8d, 0xe1, 0x4a, 0x7a, 0xda, 0xb6, 0xe3, 0xc4, 0xe6, 0x02,
+	0x8e, 0x3e, 0x85, 0xf5, 0xc8, 0x8e, 0xa9, 0x6b, 0x7b, 0x56, 0x2c, 0x6b, 0x6f, 0x4d, 0x5c, 0x62,
+	0x8f, 0x3c, 0x3c, 0xd1, 0xcb, 0x3d, 0xa5, 0x5f, 0x35, 0xef, 0x4a, 0x83, 0xf4, 0x6e, 0xbe, 0x90,
+	0x30, 0xfa, 0x71, 0xc9, 0x5e, 0x42, 0x63, 0x9b, 0x62, 0x67, 0xa6, 0x57, 0x7a, 0x4a, 0xbf, 0x31,
+	0xdc, 0x48, 0x0f, 0xfe, 0x26, 0xef, 0xe3, 0x50, 0x9a, 0xbd, 0xe2, 0x3c, 0x05, 0xd0, 0x06, 0xd4,
+	0xc9, 0x0b, 0x37, 0xb2, 0xc6, 0xd3, 0x24, 0x78, 0x41, 0xf4, 0x2a, 0x0f, 0x05, 0x98, 0x6a, 0x97,
+	0x6b, 0xd0, 0x7d, 0xd0, 0xa6, 0x6e, 0x40, 0x89, 0x5e, 0xeb, 0x29, 0xbc, 0xa0, 0x82, 0x5d, 0x83,
+	0x94, 0x5d, 0x83, 0xed, 0x60, 0x66, 0x0a, 0x13, 0x84, 0xa0, 0x44, 0x28, 0x8e, 0x74, 0xe0, 0x65,
+	0xe3, 0x6b, 0xd4, 0x02, 0x2d, 0xb6, 0x03, 0x07, 0xeb, 0x75, 0xae, 0x14, 0x02, 0x7a, 0x08, 0xf5,
+	0x93, 0x04, 0xc7, 0x33, 0x4b, 0xf8, 0x5e, 0xe1, 0xbe, 0x51, 0x9a, 0xc5, 0xb7, 0x0c, 0xda, 0x63,
+	0x88, 0x09, 0x27, 0xd9, 0x1a, 0x3d, 0x00, 0x20, 0x53, 0x3b, 0x9e, 0x58, 0x6e, 0x70, 0x1c, 0xea,
+	0xab, 0x7c, 0xcf, 0xed, 0x74, 0xcf, 0x21, 0x43, 0x9e, 0x06, 0xc7, 0xa1, 0x59, 0x23, 0xe9, 0x12,
+	0x3d, 0x82, 0xf6, 0xa9, 0x4b, 0xa7, 0x61, 0x42, 0x2d, 0xc9, 0x35, 0xcb, 0x63, 0x44, 0x20, 0x7a,
+	0xa3, 0xa7, 0xf6, 0x6b, 0x66, 0x4b, 0xa2, 0xa6, 0x00, 0x39, 0x49, 0x08, 0x0b, 0xd9, 0x73, 0x7d,
+	0x97, 0xea, 0xb7, 0x44, 0xc8, 0x5c, 0x40, 0x1d, 0xa8, 0x62, 0x42, 0x5d, 0xdf, 0xa6, 0x58, 0x6f,
+	0xf2, 0x32, 0x65, 0xb2, 0xf1, 0xbb, 0x02, 0x30, 0x0f, 0x9a, 0x17, 0x95, 0xe2, 0xc8, 0xf2, 0x5d,
+	0xcf, 0x73, 0x89, 0x24, 0x30, 0x30, 0xd5, 0x3e, 0xd7, 0xa0, 0x1e, 0x94, 0x8e, 0x93, 0x60, 0xcc,
+	0xf9, 0x5b, 0x9f, 0xd3, 0xe6, 0x49, 0x12, 0x8c, 0x4d, 0x8e, 0xa0, 0x4d, 0xa8, 0x3a, 0x71, 0x98,
+	0x44, 0x6e, 0xe0, 0x70, 0x16, 0xd6, 0x87, 0xcd, 0xd4, 0xea, 0x4b, 0xa9, 0x37, 0x33, 0x0b, 0xf4,
+	0x61, 0x5a, 0x64, 0x8d, 0x9b, 0xae, 0xa6, 0xa6, 0x26, 0x53, 0xca, 0x9a, 0x1b, 0xa7, 0x50, 0xcb,
+	0x8a, 0xc4, 0x43, 0x94, 0xb5, 0x9c, 0xe0, 0xb3, 0x2c, 0x44, 0x81, 0x4f, 0xf0, 0x19, 0xfa, 0x00,
+	0x56, 0x68, 0x48, 0x6d, 0xcf, 0xe2, 0x3a, 0x22, 0x5b, 0xad, 0xce, 0x75, 0xdc, 0x0d, 0x41, 0x0d,
+	0x28, 0x8e, 0x66, 0xbc, 0x97, 0xab, 0x66, 0x71, 0x34, 0x63, 0x8d, 0x2f, 0xab, 0x5b, 0xe2, 0xd5,
+	0x95, 0x92, 0xd1, 0x81, 0x12, 0xcb, 0x8c, 0xd1, 0x23, 0xb0, 0x65, 0x43, 0xd7, 0x4c, 0xbe, 0x36,
+	0x86, 0x50, 0x4d, 0xf3, 0x91, 0xfe, 0x94, 0x25, 0xfe, 0xd4, 0x9c, 0xbf, 0x0d, 0xd0, 0x78, 0x62,
+	0xcc, 0x20, 0x57, 0x62, 0x29, 0x19, 0x7f, 0x2a, 0xd0, 0x48, 0xe7, 0x89, 0xe0, 0x3b, 0xea, 0x43,
+	0x39, 0x9b, 0x69, 0xac, 0x44, 0x8d, 0x8c, 0x37, 0x5c, 0xbb, 0x57, 0x30, 0x25, 0x8e, 0x3a, 0x50,
+	0x39, 0xb5, 0xe3, 0x80, 0x15, 0x9e, 0xcf, 0xaf, 0xbd, 0x82, 0x99, 0x2a, 0xd0, 0x66, 0xda, 0x0c,
+	0xea, 0xeb, 0x9b, 0x61, 0xaf, 0x90, 0xb6, 0xc3, 0xa3, 0x05, 0xc6, 0x88, 0x3b, 0x6c, 0xe7, 0x4f,
+	0x7d, 0x2c, 0xd1, 0xbd, 0xc2, 0x9c, 0x4b, 0x3b, 0x55, 0x28, 0xc7, 0x98, 0x24, 0x1e, 0x35, 0x3e,
+	0x87, 0x46, 0xde, 0x8e, 0x25, 0xbc, 0x90, 0x85, 0x9a, 0xc5, 0xdc, 0x86, 0xb2, 0x6c, 0x60, 0x71,
+	0x4d, 0x52, 0x32, 0x7e, 0x51, 0xe1, 0x36, 0x27, 0xf5, 0x81, 0xed, 0xcf, 0x87, 0xeb, 0x1b, 0x87,
+	0x91, 0x72, 0x8d, 0x61, 0x54, 0xbc, 0xe6, 0x30, 0x6a, 0x81, 0x46, 0xa8, 0x1d, 0x53, 0xf9, 0x7f,
+	0x10, 0x02, 0x6a, 0x82, 0x8a, 0x83, 0x89, 0x9c, 0xc5, 0x6c, 0x39, 0x9f, 0x49, 0xda, 0xdb, 0x67,
+	0xd2, 0xe2, 0x3f, 0xa1, 0xfc, 0x1e, 0xff, 0x84, 0xd7, 0x8f, 0x8e, 0xca, 0xbb, 0x8c, 0x8e, 0xea,
+	0xc2, 0xe8, 0x30, 0x62, 0x40, 0x8b, 0xb7, 0x20, 0x29, 0xd9, 0x02, 0x8d, 0xb5, 0x80, 0xf8, 0xcb,
+	0xd6, 0x4c, 0x21, 0xb0, 0x31, 0x23, 0xd9, 0xc6, 0x2e, 0x93, 0x01, 0x99, 0x3c, 0xcf, 0x5b, 0x7d,
+	0x6b, 0xde, 0xc6, 0x6f, 0xaa, 0x3c, 0xf4, 0x3b, 0xdb, 0x4b, 0xe6, 0x77, 0xcf, 0x02, 0x64, 0x5a,
+	0xd9, 0x84, 0x42, 0x78, 0x33, 0x23, 0x8a, 0xd7, 0x60, 0x84, 0x7a, 0x53, 0x8c, 0x28, 0x2d, 0x61,
+	0x84, 0xb6, 0x84, 0x11, 0xe5, 0xf7, 0x63, 0x44, 0xe5, 0x46, 0x18, 0x51, 0x7d, 0x17, 0x46, 0xd4,
+	0x16, 0x19, 0x91, 0xc0, 0x9d, 0xdc, 0xe5, 0x48, 0x4a, 0xb4, 0xa1, 0xfc, 0x13, 0xd7, 0x48, 0x4e,
+	0x48, 0xe9, 0xa6, 0x48, 0x71, 0x7f, 0x07, 0x4a, 0xec, 0x69, 0x82, 0x2a, 0xa0, 0x9a, 0xdb, 0x47,
+	0xcd, 0x02, 0xaa, 0x81, 0xb6, 0xfb, 0xf5, 0xf3, 0x83, 0x67, 0x4d, 0x85, 0xe9, 0x0e, 0x9f, 0xef,
+	0x37, 0x8b, 0x6c, 0xb1, 0xff, 0xf4, 0xa0, 0xa9, 0xf2, 0xc5, 0xf6, 0xf7, 0xcd, 0x12, 0xaa, 0x43,
+	0x85, 0x5b, 0x3d, 0x36, 0x9b, 0xda, 0xf0, 0x2f, 0x05, 0xb4, 0x43, 0x1a, 0xc6, 0x18, 0x7d, 0x02,
+	0x65, 0x31, 0x9f, 0xd0, 0x5a, 0x7e, 0xae, 0x49, 0xb2, 0x75, 0xda, 0x57, 0xd5, 0x22, 0xcd, 0x07,
+	0x0a, 0xda, 0x05, 0x98, 0x77, 0x04, 0x5a, 0xcf, 0xd5, 0x7f, 0x71, 0x56, 0x75, 0x3a, 0xcb, 0x20,
+	0x59, 0xad, 0x27, 0x50, 0x5f, 0x28, 0x22, 0xca, 0x9b, 0xe6, 0x68, 0xdf, 0xb9, 0xb7, 0x14, 0x13,
+	0x7e, 0x86, 0x07, 0xd0, 0xe0, 0x6f, 0x60, 0xc6, 0x67, 0x91, 0xd9, 0x67, 0x50, 0x37, 0xb1, 0x1f,
+	0x52, 0xcc, 0xf5, 0x28, 0xe3, 0xc7, 0xe2, 0x53, 0xb9, 0xb3, 0x76, 0x45, 0x2b, 0x9f, 0xd4, 0x85,
+	0x9d, 0x8f, 0xce, 0xff, 0xeb, 0x16, 0xce, 0x2f, 0xba, 0xca, 0xcb, 0x8b, 0xae, 0xf2, 0xef, 0x45,
+	0x57, 0xf9, 0xf5, 0xb2, 0x5b, 0x78, 0x79, 0xd9, 0x2d, 0xfc, 0x7d, 0xd9, 0x2d, 0xfc, 0x50, 0x91,
+	0x4f, 0xf7, 0x51, 0x99, 0xdf, 0xd0, 0xc3, 0xff, 0x07, 0x00, 0x16, 0x66, 0x28, 0x85, 0x24, 0x0c,
+	0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -1177,6 +1240,18 @@ func (m *SeriesRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.Estimate {
+		i--
+		if m.Estimate {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0x80
+	}
 	if m.Limit != 0 {
 		i = encodeVarintRpc(dAtA, i, uint64(m.Limit))
 		i--
@@ -1616,6 +1691,60 @@ func (m *SeriesResponse_Hints) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	}
 	return len(dAtA) - i, nil
 }
+func (m *SeriesResponse_Estimate) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *SeriesResponse_Estimate) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	if m.Estimate != nil {
+		{
+			size, err := m.Estimate.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintRpc(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x22
+	}
+	return len(dAtA) - i, nil
+}
+func (m *SeriesEstimate) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SeriesEstimate) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *SeriesEstimate) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.Chunks != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Chunks))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.Series != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Series))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
 func (m *LabelNamesRequest) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -2011,6 +2140,9 @@ func (m *SeriesRequest) Size() (n int) {
 	if m.Limit != 0 {
 		n += 1 + sovRpc(uint64(m.Limit))
 	}
+	if m.Estimate {
+		n += 3
+	}
 	return n
 }
 
@@ -2151,6 +2283,33 @@ func (m *SeriesResponse_Hints) Size() (n int) {
 	}
 	return n
 }
+func (m *SeriesResponse_Estimate) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Estimate != nil {
+		l = m.Estimate.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
+func (m *SeriesEstimate) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Series != 0 {
+		n += 1 + sovRpc(uint64(m.Series))
+	}
+	if m.Chunks != 0 {
+		n += 1 + sovRpc(uint64(m.Chunks))
+	}
+	return n
+}
+
 func (m *LabelNamesRequest) Size() (n int) {
 	if m == nil {
 		return 0
@@ -2921,6 +3080,26 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 16:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Estimate", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Estimate = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -3643,6 +3822,129 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 			}
 			m.Result = &SeriesResponse_Hints{v}
 			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Estimate", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			v := &SeriesEstimate{}
+			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			m.Result = &SeriesResponse_Estimate{v}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SeriesEstimate) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesEstimate: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesEstimate: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
+			}
+			m.Series = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Series |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Chunks", wireType)
+			}
+			m.Chunks = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Chunks |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
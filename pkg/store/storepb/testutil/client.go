@@ -19,6 +19,8 @@ type TestClient struct {
 	MinTime, MaxTime            int64
 	Shardable                   bool
 	WithoutReplicaLabelsEnabled bool
+	SeriesEstimateEnabled       bool
+	StoreShard                  string
 	IsLocalStore                bool
 	StoreTSDBInfos              []infopb.TSDBInfo
 	StoreFilterNotMatches       bool
@@ -29,6 +31,8 @@ func (c TestClient) TimeRange() (mint, maxt int64)          { return c.MinTime,
 func (c TestClient) TSDBInfos() []infopb.TSDBInfo           { return c.StoreTSDBInfos }
 func (c TestClient) SupportsSharding() bool                 { return c.Shardable }
 func (c TestClient) SupportsWithoutReplicaLabels() bool     { return c.WithoutReplicaLabelsEnabled }
+func (c TestClient) SupportsSeriesEstimate() bool           { return c.SeriesEstimateEnabled }
+func (c TestClient) Shard() string                          { return c.StoreShard }
 func (c TestClient) String() string                         { return c.Name }
 func (c TestClient) Addr() (string, bool)                   { return c.Name, c.IsLocalStore }
 func (c TestClient) Matches(matches []*labels.Matcher) bool { return !c.StoreFilterNotMatches }
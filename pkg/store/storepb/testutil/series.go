@@ -266,9 +266,10 @@ type SeriesServer struct {
 
 	ctx context.Context
 
-	SeriesSet []*storepb.Series
-	Warnings  []string
-	HintsSet  []*types.Any
+	SeriesSet   []*storepb.Series
+	Warnings    []string
+	HintsSet    []*types.Any
+	EstimateSet []*storepb.SeriesEstimate
 
 	Size int64
 }
@@ -294,6 +295,11 @@ func (s *SeriesServer) Send(r *storepb.SeriesResponse) error {
 		s.HintsSet = append(s.HintsSet, r.GetHints())
 		return nil
 	}
+
+	if r.GetEstimate() != nil {
+		s.EstimateSet = append(s.EstimateSet, r.GetEstimate())
+		return nil
+	}
 	// Unsupported field, skip.
 	return nil
 }
@@ -0,0 +1,198 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/model"
+)
+
+// TenantLimitsConfig is the configuration of per-tenant limits applied against
+// individual Series, LabelNames and LabelValues calls.
+type TenantLimitsConfig struct {
+	// DefaultLimits are the limits for tenants without specified limits.
+	// Unset values fall back to the limits given by flags.
+	DefaultLimits RequestLimitsConfig `yaml:"default"`
+	// TenantsLimits are the limits per tenant. Unset values fall back to DefaultLimits.
+	TenantsLimits map[string]*RequestLimitsConfig `yaml:"tenants"`
+}
+
+// RequestLimitsConfig holds the per-request limits. A tenant might not always
+// have all limits configured, so things here must use pointers. 0 means no limit.
+type RequestLimitsConfig struct {
+	// SeriesLimit is the maximum number of series touched by a single request.
+	SeriesLimit *uint64 `yaml:"series_limit"`
+	// SamplesLimit is the maximum number of samples fetched by a single request.
+	SamplesLimit *uint64 `yaml:"samples_limit"`
+	// DownloadedBytesLimit is the maximum amount of fetched or touched bytes by a single request.
+	DownloadedBytesLimit *model.Bytes `yaml:"downloaded_bytes_limit"`
+}
+
+// overlayWith sets all limits that are not set in rl from other.
+func (rl RequestLimitsConfig) overlayWith(other RequestLimitsConfig) RequestLimitsConfig {
+	if rl.SeriesLimit == nil {
+		rl.SeriesLimit = other.SeriesLimit
+	}
+	if rl.SamplesLimit == nil {
+		rl.SamplesLimit = other.SamplesLimit
+	}
+	if rl.DownloadedBytesLimit == nil {
+		rl.DownloadedBytesLimit = other.DownloadedBytesLimit
+	}
+	return rl
+}
+
+// ParseTenantLimitsConfig parses the per-tenant limits configuration. Even though
+// the result is a pointer, it will only be nil if an error is returned.
+func ParseTenantLimitsConfig(content []byte) (*TenantLimitsConfig, error) {
+	var conf TenantLimitsConfig
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing limits config YAML file")
+	}
+	for tenant, limits := range conf.TenantsLimits {
+		if limits == nil {
+			return nil, errors.Errorf("no limits specified for tenant %q", tenant)
+		}
+	}
+	return &conf, nil
+}
+
+// fileContent is an interface to avoid a direct dependency on kingpin or extkingpin.
+type fileContent interface {
+	Content() ([]byte, error)
+	Path() string
+}
+
+// requestLimits are the resolved limits of a single tenant.
+type requestLimits struct {
+	series, samples, bytes uint64
+}
+
+// TenantLimiter resolves the per-request limits of the tenant carried by a request.
+// Limits are read from a limits file that can be reloaded at runtime, and default
+// to the static limits given on construction.
+type TenantLimiter struct {
+	mtx    sync.RWMutex
+	config *TenantLimitsConfig
+
+	defaults            RequestLimitsConfig
+	configPathOrContent fileContent
+	configReloadTimer   time.Duration
+	logger              log.Logger
+
+	configReloadCounter       prometheus.Counter
+	configReloadFailedCounter prometheus.Counter
+}
+
+// NewTenantLimiter creates a new *TenantLimiter from the given limits file. The given
+// default limits are applied to all limits that are not set in the limits file.
+func NewTenantLimiter(configFile fileContent, defaults SeriesSelectLimits, maxDownloadedBytes uint64, reg prometheus.Registerer, logger log.Logger, configReloadTimer time.Duration) (*TenantLimiter, error) {
+	downloadedBytes := model.Bytes(maxDownloadedBytes)
+	l := &TenantLimiter{
+		config: &TenantLimitsConfig{},
+		defaults: RequestLimitsConfig{
+			SeriesLimit:          &defaults.SeriesPerRequest,
+			SamplesLimit:         &defaults.SamplesPerRequest,
+			DownloadedBytesLimit: &downloadedBytes,
+		},
+		configPathOrContent: configFile,
+		configReloadTimer:   configReloadTimer,
+		logger:              logger,
+		configReloadCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_store_limits_config_reload_total",
+			Help: "How many times the limits configuration was reloaded.",
+		}),
+		configReloadFailedCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_store_limits_config_reload_err_total",
+			Help: "How many times the limits configuration failed to reload.",
+		}),
+	}
+	if configFile == nil {
+		return l, nil
+	}
+	if err := l.loadConfig(); err != nil {
+		return nil, errors.Wrap(err, "load tenant limits config")
+	}
+	return l, nil
+}
+
+// CanReload returns true if the limits are read from a file which can be watched for changes.
+func (l *TenantLimiter) CanReload() bool {
+	return l.configPathOrContent != nil && l.configPathOrContent.Path() != ""
+}
+
+// StartConfigReloader starts watching the limits file and reloads the limits when it changes.
+func (l *TenantLimiter) StartConfigReloader(ctx context.Context) error {
+	if !l.CanReload() {
+		return nil
+	}
+
+	return extkingpin.PathContentReloader(ctx, l.configPathOrContent, l.logger, func() {
+		level.Info(l.logger).Log("msg", "reloading limits config")
+		if err := l.loadConfig(); err != nil {
+			l.configReloadFailedCounter.Inc()
+			level.Error(l.logger).Log("msg", "error reloading tenant limits config", "path", l.configPathOrContent.Path(), "err", err)
+			return
+		}
+		l.configReloadCounter.Inc()
+	}, l.configReloadTimer)
+}
+
+func (l *TenantLimiter) loadConfig() error {
+	content, err := l.configPathOrContent.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of limits configuration")
+	}
+	config, err := ParseTenantLimitsConfig(content)
+	if err != nil {
+		return err
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.config = config
+	return nil
+}
+
+func (l *TenantLimiter) limitsFor(tenant string) requestLimits {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	limits := l.config.DefaultLimits.overlayWith(l.defaults)
+	if tenantLimits, ok := l.config.TenantsLimits[tenant]; ok {
+		limits = tenantLimits.overlayWith(limits)
+	}
+	return requestLimits{
+		series:  *limits.SeriesLimit,
+		samples: *limits.SamplesLimit,
+		bytes:   uint64(*limits.DownloadedBytesLimit),
+	}
+}
+
+// NewSeriesLimiter returns a SeriesLimiter enforcing the series limit of the given tenant.
+func (l *TenantLimiter) NewSeriesLimiter(tenant string, failedCounter prometheus.Counter) SeriesLimiter {
+	return NewLimiterForTenant(tenant, l.limitsFor(tenant).series, failedCounter)
+}
+
+// NewChunksLimiter returns a ChunksLimiter enforcing the samples limit of the given tenant.
+// The samples limit is an approximation based on the max number of samples per chunk.
+func (l *TenantLimiter) NewChunksLimiter(tenant string, failedCounter prometheus.Counter) ChunksLimiter {
+	return NewLimiterForTenant(tenant, l.limitsFor(tenant).samples/MaxSamplesPerChunk, failedCounter)
+}
+
+// NewBytesLimiter returns a BytesLimiter enforcing the downloaded bytes limit of the given tenant.
+func (l *TenantLimiter) NewBytesLimiter(tenant string, failedCounter prometheus.Counter) BytesLimiter {
+	return NewLimiterForTenant(tenant, l.limitsFor(tenant).bytes, failedCounter)
+}
@@ -0,0 +1,134 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type staticFileContent struct {
+	path    string
+	content []byte
+}
+
+func (c staticFileContent) Content() ([]byte, error) {
+	if c.path != "" {
+		return os.ReadFile(c.path)
+	}
+	return c.content, nil
+}
+
+func (c staticFileContent) Path() string { return c.path }
+
+func TestParseTenantLimitsConfig(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseTenantLimitsConfig([]byte(`
+tenants:
+  foo:
+`))
+	testutil.NotOk(t, err)
+
+	_, err = ParseTenantLimitsConfig([]byte(`
+default:
+  unknown_limit: 10
+`))
+	testutil.NotOk(t, err)
+
+	conf, err := ParseTenantLimitsConfig([]byte(`
+default:
+  series_limit: 100
+tenants:
+  foo:
+    downloaded_bytes_limit: 1KiB
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(100), *conf.DefaultLimits.SeriesLimit)
+	testutil.Equals(t, uint64(1024), uint64(*conf.TenantsLimits["foo"].DownloadedBytesLimit))
+}
+
+func TestTenantLimiter_LimitsFor(t *testing.T) {
+	t.Parallel()
+
+	l, err := NewTenantLimiter(staticFileContent{content: []byte(`
+default:
+  series_limit: 100
+tenants:
+  foo:
+    samples_limit: 1200
+  bar:
+    series_limit: 0
+    downloaded_bytes_limit: 1KiB
+`)}, SeriesSelectLimits{SeriesPerRequest: 10, SamplesPerRequest: 20}, 30, nil, log.NewNopLogger(), time.Second)
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, requestLimits{series: 100, samples: 20, bytes: 30}, l.limitsFor("baz"))
+	testutil.Equals(t, requestLimits{series: 100, samples: 1200, bytes: 30}, l.limitsFor("foo"))
+	testutil.Equals(t, requestLimits{series: 0, samples: 20, bytes: 1024}, l.limitsFor("bar"))
+}
+
+func TestTenantLimiter_Limiters(t *testing.T) {
+	t.Parallel()
+
+	l, err := NewTenantLimiter(staticFileContent{content: []byte(`
+tenants:
+  foo:
+    series_limit: 1
+`)}, SeriesSelectLimits{}, 0, nil, log.NewNopLogger(), time.Second)
+	testutil.Ok(t, err)
+
+	c := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	seriesLimiter := l.NewSeriesLimiter("foo", c)
+	testutil.Ok(t, seriesLimiter.Reserve(1))
+	err = seriesLimiter.Reserve(1)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, `limit 1 for tenant "foo" violated (got 2)`, err.Error())
+	testutil.Equals(t, float64(1), prom_testutil.ToFloat64(c))
+
+	testutil.Ok(t, l.NewSeriesLimiter("bar", c).Reserve(100))
+}
+
+func TestTenantLimiter_Reload(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "limits.yaml")
+	testutil.Ok(t, os.WriteFile(path, []byte(`
+tenants:
+  foo:
+    series_limit: 1
+`), os.ModePerm))
+
+	reg := prometheus.NewRegistry()
+	l, err := NewTenantLimiter(staticFileContent{path: path}, SeriesSelectLimits{}, 0, reg, log.NewNopLogger(), 100*time.Millisecond)
+	testutil.Ok(t, err)
+	testutil.Assert(t, l.CanReload())
+	testutil.Equals(t, uint64(1), l.limitsFor("foo").series)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	testutil.Ok(t, l.StartConfigReloader(ctx))
+
+	testutil.Ok(t, os.WriteFile(path, []byte(`
+tenants:
+  foo:
+    series_limit: 2
+`), os.ModePerm))
+	testutil.Ok(t, waitFor(func() bool { return l.limitsFor("foo").series == 2 }))
+	testutil.Equals(t, float64(1), prom_testutil.ToFloat64(l.configReloadCounter))
+
+	// Invalid configuration keeps the previous limits.
+	testutil.Ok(t, os.WriteFile(path, []byte(`tenants: [`), os.ModePerm))
+	testutil.Ok(t, waitFor(func() bool { return prom_testutil.ToFloat64(l.configReloadFailedCounter) == 1 }))
+	testutil.Equals(t, uint64(2), l.limitsFor("foo").series)
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunks"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -299,6 +300,10 @@ func (s *TSDBStore) Series(r *storepb.SeriesRequest, seriesSrv storepb.Store_Ser
 	}
 	finalExtLset := rmLabels(s.extLset.Copy(), extLsetToRemove)
 
+	if r.Estimate {
+		return s.estimateSeries(srv, set, shardMatcher, extLsetToRemove, finalExtLset)
+	}
+
 	// Stream at most one series per frame; series may be split over multiple frames according to maxBytesInFrame.
 	for set.Next() {
 		series := set.At()
@@ -375,6 +380,37 @@ func (s *TSDBStore) Series(r *storepb.SeriesRequest, seriesSrv storepb.Store_Ser
 	return srv.Flush()
 }
 
+// estimateSeries responds with the number of series and chunks in the given set. Walking the local TSDB
+// is cheap enough to count them exactly, without copying any chunk data.
+func (s *TSDBStore) estimateSeries(srv flushableServer, set storage.ChunkSeriesSet, shardMatcher *storepb.ShardMatcher, extLsetToRemove map[string]struct{}, finalExtLset labels.Labels) error {
+	var (
+		numSeries, numChunks int64
+		chIter               chunks.Iterator
+	)
+	for set.Next() {
+		series := set.At()
+		if !shardMatcher.MatchesLabels(labelpb.ExtendSortedLabels(rmLabels(series.Labels(), extLsetToRemove), finalExtLset)) {
+			continue
+		}
+		numSeries++
+
+		chIter = series.Iterator(chIter)
+		for chIter.Next() {
+			numChunks++
+		}
+		if err := chIter.Err(); err != nil {
+			return status.Error(codes.Internal, errors.Wrap(err, "chunk iter").Error())
+		}
+	}
+	if err := set.Err(); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := srv.Send(storepb.NewEstimateSeriesResponse(numSeries, numChunks)); err != nil {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	return srv.Flush()
+}
+
 // LabelNames returns all known label names constrained with the given matchers.
 func (s *TSDBStore) LabelNames(ctx context.Context, r *storepb.LabelNamesRequest) (
 	*storepb.LabelNamesResponse, error,
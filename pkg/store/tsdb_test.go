@@ -195,6 +195,42 @@ func TestTSDBStore_Series(t *testing.T) {
 	}
 }
 
+func TestTSDBStore_Series_Estimate(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := e2eutil.NewTSDB()
+	defer func() { testutil.Ok(t, db.Close()) }()
+	testutil.Ok(t, err)
+
+	tsdbStore := NewTSDBStore(nil, db, component.Rule, labels.FromStrings("region", "eu-west"))
+
+	appender := db.Appender(context.Background())
+	for i := 1; i <= 240; i++ {
+		_, err = appender.Append(0, labels.FromStrings("a", "1", "b", "1"), int64(i), float64(i))
+		testutil.Ok(t, err)
+	}
+	_, err = appender.Append(0, labels.FromStrings("a", "1", "b", "2"), 1, 1)
+	testutil.Ok(t, err)
+	_, err = appender.Append(0, labels.FromStrings("a", "2"), 1, 1)
+	testutil.Ok(t, err)
+	testutil.Ok(t, appender.Commit())
+
+	srv := newStoreSeriesServer(ctx)
+	testutil.Ok(t, tsdbStore.Series(&storepb.SeriesRequest{
+		MinTime: 0,
+		MaxTime: 300,
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+		},
+		Estimate: true,
+	}, srv))
+	testutil.Equals(t, 0, len(srv.SeriesSet))
+	testutil.Equals(t, []*storepb.SeriesEstimate{{Series: 2, Chunks: 3}}, srv.EstimateSet)
+}
+
 type delegatorServer struct {
 	*storetestutil.SeriesServer
 
@@ -0,0 +1,447 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package tenancy
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extpromql"
+)
+
+// AccessPolicyConfig is the configuration of the series tenants can read.
+type AccessPolicyConfig struct {
+	// Policies grant tenants read access to series. Tenants granted access by several policies can read
+	// the series of all of them.
+	Policies []AccessPolicyRule `yaml:"policies"`
+	// DefaultSelectors grant read access to tenants without policy. Tenants without policy are denied if empty.
+	DefaultSelectors []string `yaml:"default_selectors"`
+}
+
+// AccessPolicyRule grants tenants read access to the series matching any of the selectors.
+type AccessPolicyRule struct {
+	// Tenants are the tenant IDs the policy applies to.
+	Tenants []string `yaml:"tenants"`
+	// Selectors are series selectors, e.g. {namespace=~"a-.*", cluster="shared"}. Series have to match
+	// all the matchers of at least one selector.
+	Selectors []string `yaml:"selectors"`
+}
+
+// accessSelectors are the sets of label matchers a tenant can read series of. Series have to match
+// all the matchers of at least one set.
+type accessSelectors [][]*labels.Matcher
+
+// ParseAccessPolicyConfig parses the access policy configuration.
+func ParseAccessPolicyConfig(content []byte) (*AccessPolicyConfig, error) {
+	var conf AccessPolicyConfig
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing access policy config YAML file")
+	}
+	if _, _, err := conf.selectors(); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// selectors returns the access selectors per tenant, and of tenants without policy.
+func (c *AccessPolicyConfig) selectors() (map[string]accessSelectors, accessSelectors, error) {
+	tenants := map[string]accessSelectors{}
+	for i, p := range c.Policies {
+		if len(p.Tenants) == 0 {
+			return nil, nil, errors.Errorf("no tenants specified for policy %d", i)
+		}
+		if len(p.Selectors) == 0 {
+			return nil, nil, errors.Errorf("no selectors specified for policy %d", i)
+		}
+		sels, err := parseAccessSelectors(p.Selectors)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "policy %d", i)
+		}
+		for _, tenant := range p.Tenants {
+			tenants[tenant] = append(tenants[tenant], sels...)
+		}
+	}
+	for tenant, sels := range tenants {
+		tenants[tenant] = mergeAccessSelectors(sels)
+	}
+
+	defaults, err := parseAccessSelectors(c.DefaultSelectors)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "default selectors")
+	}
+	return tenants, mergeAccessSelectors(defaults), nil
+}
+
+func parseAccessSelectors(selectors []string) (accessSelectors, error) {
+	sels := make(accessSelectors, 0, len(selectors))
+	for _, s := range selectors {
+		ms, err := extpromql.ParseMetricSelector(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse selector %s", s)
+		}
+		sels = append(sels, ms)
+	}
+	return sels, nil
+}
+
+// mergeAccessSelectors merges selectors made of a single positive matcher on the same label into a single
+// regex matcher, e.g. {tenant_id="a"} and {tenant_id="b"} into {tenant_id=~"a|b"}, which keeps the
+// rewritten queries simple in the common case of tenants reading the series of several tenants.
+func mergeAccessSelectors(sels accessSelectors) accessSelectors {
+	if len(sels) < 2 {
+		return sels
+	}
+	alternatives := make([]string, 0, len(sels))
+	for _, ms := range sels {
+		if len(ms) != 1 || ms[0].Name != sels[0][0].Name {
+			return sels
+		}
+		switch ms[0].Type {
+		case labels.MatchEqual:
+			alternatives = append(alternatives, regexp.QuoteMeta(ms[0].Value))
+		case labels.MatchRegexp:
+			alternatives = append(alternatives, "(?:"+ms[0].Value+")")
+		default:
+			return sels
+		}
+	}
+	return accessSelectors{{labels.MustNewMatcher(labels.MatchRegexp, sels[0][0].Name, strings.Join(alternatives, "|"))}}
+}
+
+// AccessPolicy enforces the series tenants can read. Policies are read from a file that can be reloaded
+// at runtime.
+type AccessPolicy struct {
+	mtx      sync.RWMutex
+	tenants  map[string]accessSelectors
+	defaults accessSelectors
+
+	configPathOrContent fileContent
+	configReloadTimer   time.Duration
+	logger              log.Logger
+
+	configReloadCounter       prometheus.Counter
+	configReloadFailedCounter prometheus.Counter
+	deniedCounter             prometheus.Counter
+}
+
+// fileContent is an interface to avoid a direct dependency on kingpin or extkingpin.
+type fileContent interface {
+	Content() ([]byte, error)
+	Path() string
+}
+
+// NewAccessPolicy creates a new *AccessPolicy from the given policy file.
+func NewAccessPolicy(configFile fileContent, reg prometheus.Registerer, logger log.Logger, configReloadTimer time.Duration) (*AccessPolicy, error) {
+	p := &AccessPolicy{
+		configPathOrContent: configFile,
+		configReloadTimer:   configReloadTimer,
+		logger:              logger,
+		configReloadCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_access_policy_config_reload_total",
+			Help: "How many times the access policy configuration was reloaded.",
+		}),
+		configReloadFailedCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_access_policy_config_reload_err_total",
+			Help: "How many times the access policy configuration failed to reload.",
+		}),
+		deniedCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_access_policy_denied_requests_total",
+			Help: "Total number of requests denied because the tenant has no access policy.",
+		}),
+	}
+	if err := p.loadConfig(); err != nil {
+		return nil, errors.Wrap(err, "load access policy config")
+	}
+	return p, nil
+}
+
+// CanReload returns true if the policies are read from a file which can be watched for changes.
+func (p *AccessPolicy) CanReload() bool {
+	return p.configPathOrContent.Path() != ""
+}
+
+// StartConfigReloader starts watching the policy file and reloads the policies when it changes.
+func (p *AccessPolicy) StartConfigReloader(ctx context.Context) error {
+	if !p.CanReload() {
+		return nil
+	}
+
+	return extkingpin.PathContentReloader(ctx, p.configPathOrContent, p.logger, func() {
+		level.Info(p.logger).Log("msg", "reloading access policy config")
+		if err := p.loadConfig(); err != nil {
+			p.configReloadFailedCounter.Inc()
+			level.Error(p.logger).Log("msg", "error reloading access policy config", "path", p.configPathOrContent.Path(), "err", err)
+			return
+		}
+		p.configReloadCounter.Inc()
+	}, p.configReloadTimer)
+}
+
+func (p *AccessPolicy) loadConfig() error {
+	content, err := p.configPathOrContent.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of access policy configuration")
+	}
+	conf, err := ParseAccessPolicyConfig(content)
+	if err != nil {
+		return err
+	}
+	tenants, defaults, err := conf.selectors()
+	if err != nil {
+		return err
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.tenants = tenants
+	p.defaults = defaults
+	return nil
+}
+
+// selectorsFor returns the access selectors of the given tenant, or an error if the tenant has none.
+func (p *AccessPolicy) selectorsFor(tenant string) (accessSelectors, error) {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	if sels, ok := p.tenants[tenant]; ok {
+		return sels, nil
+	}
+	if len(p.defaults) > 0 {
+		return p.defaults, nil
+	}
+	p.deniedCounter.Inc()
+	return nil, errors.Errorf("tenant %q is not granted access by any policy", tenant)
+}
+
+// EnforceQuery rewrites the PromQL query so that its selectors only select series the tenant can read.
+func (p *AccessPolicy) EnforceQuery(tenant string, query string) (string, error) {
+	sels, err := p.selectorsFor(tenant)
+	if err != nil {
+		return "", err
+	}
+
+	expr, err := extpromql.ParseExpr(query)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing query string, when enforcing access policy")
+	}
+	expr, err = enforceAccessSelectors(expr, sels)
+	if err != nil {
+		return "", errors.Wrap(err, "error enforcing access policy")
+	}
+	return expr.String(), nil
+}
+
+// EnforceMatcherSets restricts the given matcher sets to series the tenant can read. If no matcher set is
+// given, the tenant's access selectors are returned.
+func (p *AccessPolicy) EnforceMatcherSets(tenant string, matcherSets [][]*labels.Matcher) ([][]*labels.Matcher, error) {
+	sels, err := p.selectorsFor(tenant)
+	if err != nil {
+		return nil, err
+	}
+	if len(matcherSets) == 0 {
+		return sels, nil
+	}
+
+	res := make([][]*labels.Matcher, 0, len(matcherSets)*len(sels))
+	for _, ms := range matcherSets {
+		for _, sel := range sels {
+			res = append(res, appendMatchers(ms, sel))
+		}
+	}
+	return res, nil
+}
+
+// Allows returns true if the tenant can read the series with the given labels.
+func (p *AccessPolicy) Allows(tenant string, lset labels.Labels) bool {
+	sels, err := p.selectorsFor(tenant)
+	if err != nil {
+		return false
+	}
+	for _, sel := range sels {
+		matches := true
+		for _, m := range sel {
+			if !m.Matches(lset.Get(m.Name)) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// appendMatchers returns the matchers of ms and sel, without duplicates. Appending never widens the
+// selection of ms, so matchers of ms on the same labels as sel are kept.
+func appendMatchers(ms []*labels.Matcher, sel []*labels.Matcher) []*labels.Matcher {
+	res := make([]*labels.Matcher, 0, len(ms)+len(sel))
+	res = append(res, ms...)
+Outer:
+	for _, m := range sel {
+		for _, existing := range ms {
+			if existing.String() == m.String() {
+				continue Outer
+			}
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// enforceAccessSelectors injects the access selectors into all selectors of the expression. With several
+// access selectors, selectors are replaced by the union of their restrictions to each access selector,
+// e.g. foo by (foo{a="1"} or foo{b="2"}) and rate(foo[5m]) by (rate(foo{a="1"}[5m]) or rate(foo{b="2"}[5m])).
+func enforceAccessSelectors(expr parser.Expr, sels accessSelectors) (parser.Expr, error) {
+	switch n := expr.(type) {
+	case *parser.VectorSelector:
+		return union(len(sels), parser.LOR, func(i int) parser.Expr { return restrictSelector(n, sels[i]) }), nil
+
+	case *parser.MatrixSelector:
+		if len(sels) > 1 {
+			return nil, errors.New("range vectors can't be restricted to several access selectors outside of functions")
+		}
+		return restrictMatrixSelector(n, sels[0]), nil
+
+	case *parser.Call:
+		args := make(parser.Expressions, len(n.Args))
+		matrixArg := -1
+		for i, arg := range n.Args {
+			if ms, ok := arg.(*parser.MatrixSelector); ok {
+				args[i] = ms
+				matrixArg = i
+				continue
+			}
+			a, err := enforceAccessSelectors(arg, sels)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = a
+		}
+		if matrixArg < 0 {
+			c := *n
+			c.Args = args
+			return &c, nil
+		}
+
+		// Functions over range vectors evaluate series independently, so they are evaluated over each
+		// restriction, and their results joined.
+		var op parser.ItemType = parser.LOR
+		if n.Func.Name == "absent_over_time" {
+			op = parser.LAND
+		}
+		return union(len(sels), op, func(i int) parser.Expr {
+			c := *n
+			c.Args = append(parser.Expressions{}, args...)
+			c.Args[matrixArg] = restrictMatrixSelector(args[matrixArg].(*parser.MatrixSelector), sels[i])
+			return &c
+		}), nil
+
+	case *parser.AggregateExpr:
+		e, err := enforceAccessSelectors(n.Expr, sels)
+		if err != nil {
+			return nil, err
+		}
+		c := *n
+		c.Expr = e
+		if n.Param != nil {
+			if c.Param, err = enforceAccessSelectors(n.Param, sels); err != nil {
+				return nil, err
+			}
+		}
+		return &c, nil
+
+	case *parser.BinaryExpr:
+		lhs, err := enforceAccessSelectors(n.LHS, sels)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := enforceAccessSelectors(n.RHS, sels)
+		if err != nil {
+			return nil, err
+		}
+		c := *n
+		c.LHS, c.RHS = lhs, rhs
+		return &c, nil
+
+	case *parser.SubqueryExpr:
+		e, err := enforceAccessSelectors(n.Expr, sels)
+		if err != nil {
+			return nil, err
+		}
+		c := *n
+		c.Expr = e
+		return &c, nil
+
+	case *parser.ParenExpr:
+		e, err := enforceAccessSelectors(n.Expr, sels)
+		if err != nil {
+			return nil, err
+		}
+		return &parser.ParenExpr{Expr: e, PosRange: n.PosRange}, nil
+
+	case *parser.UnaryExpr:
+		e, err := enforceAccessSelectors(n.Expr, sels)
+		if err != nil {
+			return nil, err
+		}
+		c := *n
+		c.Expr = e
+		return &c, nil
+
+	case *parser.StepInvariantExpr:
+		e, err := enforceAccessSelectors(n.Expr, sels)
+		if err != nil {
+			return nil, err
+		}
+		return &parser.StepInvariantExpr{Expr: e}, nil
+
+	case *parser.NumberLiteral, *parser.StringLiteral:
+		return n, nil
+
+	default:
+		return nil, errors.Errorf("unhandled node type %T", n)
+	}
+}
+
+// union joins the n expressions returned by f with the given set operator, or on() for "and", within parentheses.
+func union(n int, op parser.ItemType, f func(i int) parser.Expr) parser.Expr {
+	expr := f(0)
+	if n == 1 {
+		return expr
+	}
+	for i := 1; i < n; i++ {
+		matching := &parser.VectorMatching{Card: parser.CardManyToMany}
+		if op == parser.LAND {
+			matching.On = true
+		}
+		expr = &parser.BinaryExpr{Op: op, LHS: expr, RHS: f(i), VectorMatching: matching}
+	}
+	return &parser.ParenExpr{Expr: expr}
+}
+
+func restrictSelector(vs *parser.VectorSelector, sel []*labels.Matcher) *parser.VectorSelector {
+	c := *vs
+	c.LabelMatchers = appendMatchers(vs.LabelMatchers, sel)
+	return &c
+}
+
+func restrictMatrixSelector(ms *parser.MatrixSelector, sel []*labels.Matcher) *parser.MatrixSelector {
+	c := *ms
+	c.VectorSelector = restrictSelector(ms.VectorSelector.(*parser.VectorSelector), sel)
+	return &c
+}
@@ -0,0 +1,190 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package tenancy_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/extpromql"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/tenancy"
+)
+
+type policyFile struct {
+	path    string
+	content string
+}
+
+func (f policyFile) Content() ([]byte, error) {
+	if f.path != "" {
+		return os.ReadFile(f.path)
+	}
+	return []byte(f.content), nil
+}
+
+func (f policyFile) Path() string { return f.path }
+
+const testAccessPolicy = `
+policies:
+  - tenants: [team-a]
+    selectors:
+      - '{namespace=~"a-.*"}'
+      - '{cluster="shared"}'
+  - tenants: [team-b]
+    selectors:
+      - '{namespace="b", cluster="eu"}'
+  - tenants: [ops]
+    selectors: ['{tenant_id="a"}']
+  - tenants: [ops]
+    selectors: ['{tenant_id="b.c"}']
+`
+
+func TestAccessPolicy_EnforceQuery(t *testing.T) {
+	p, err := tenancy.NewAccessPolicy(policyFile{content: testAccessPolicy}, nil, log.NewNopLogger(), 0)
+	testutil.Ok(t, err)
+
+	for _, tcase := range []struct {
+		tenant   string
+		query    string
+		expected string
+		err      bool
+	}{
+		{
+			tenant:   "team-b",
+			query:    `sum by (pod) (rate(http_requests_total{namespace="b"}[5m])) / on() group_left() up`,
+			expected: `sum by (pod) (rate(http_requests_total{cluster="eu",namespace="b"}[5m])) / on () group_left () up{cluster="eu",namespace="b"}`,
+		},
+		{
+			tenant:   "ops",
+			query:    `up`,
+			expected: `up{tenant_id=~"a|b\\.c"}`,
+		},
+		{
+			tenant:   "team-a",
+			query:    `sum(up) + rate(foo[5m])`,
+			expected: `sum((up{namespace=~"a-.*"} or up{cluster="shared"})) + (rate(foo{namespace=~"a-.*"}[5m]) or rate(foo{cluster="shared"}[5m]))`,
+		},
+		{
+			tenant:   "team-a",
+			query:    `absent_over_time(foo[5m])`,
+			expected: `(absent_over_time(foo{namespace=~"a-.*"}[5m]) and on () absent_over_time(foo{cluster="shared"}[5m]))`,
+		},
+		{
+			tenant:   "team-a",
+			query:    `max_over_time(up[1h:5m])`,
+			expected: `max_over_time((up{namespace=~"a-.*"} or up{cluster="shared"})[1h:5m])`,
+		},
+		{
+			tenant: "team-a",
+			query:  `foo[5m]`,
+			err:    true,
+		},
+		{
+			tenant: "team-c",
+			query:  `up`,
+			err:    true,
+		},
+	} {
+		t.Run(tcase.tenant+" "+tcase.query, func(t *testing.T) {
+			q, err := p.EnforceQuery(tcase.tenant, tcase.query)
+			if tcase.err {
+				testutil.NotOk(t, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, q)
+
+			// The rewritten query has to be valid.
+			_, err = extpromql.ParseExpr(q)
+			testutil.Ok(t, err)
+		})
+	}
+}
+
+func TestAccessPolicy_EnforceMatcherSets(t *testing.T) {
+	p, err := tenancy.NewAccessPolicy(policyFile{content: testAccessPolicy}, nil, log.NewNopLogger(), 0)
+	testutil.Ok(t, err)
+
+	sets, err := p.EnforceMatcherSets("team-a", nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{`{namespace=~"a-.*"}`, `{cluster="shared"}`}, matcherSetsStrings(sets))
+
+	sets, err = p.EnforceMatcherSets("team-a", [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, "job", "api")}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{`{job="api",namespace=~"a-.*"}`, `{job="api",cluster="shared"}`}, matcherSetsStrings(sets))
+
+	_, err = p.EnforceMatcherSets("team-c", nil)
+	testutil.NotOk(t, err)
+}
+
+func matcherSetsStrings(sets [][]*labels.Matcher) []string {
+	res := make([]string, 0, len(sets))
+	for _, ms := range sets {
+		strs := make([]string, 0, len(ms))
+		for _, m := range ms {
+			strs = append(strs, m.String())
+		}
+		res = append(res, "{"+strings.Join(strs, ",")+"}")
+	}
+	return res
+}
+
+func TestAccessPolicy_Allows(t *testing.T) {
+	p, err := tenancy.NewAccessPolicy(policyFile{content: testAccessPolicy + `
+default_selectors: ['{public="true"}']
+`}, nil, log.NewNopLogger(), 0)
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, p.Allows("team-a", labels.FromStrings("namespace", "a-1")))
+	testutil.Assert(t, p.Allows("team-a", labels.FromStrings("cluster", "shared")))
+	testutil.Assert(t, !p.Allows("team-a", labels.FromStrings("namespace", "b")))
+	testutil.Assert(t, !p.Allows("team-b", labels.FromStrings("namespace", "b")))
+	testutil.Assert(t, p.Allows("team-b", labels.FromStrings("namespace", "b", "cluster", "eu")))
+	testutil.Assert(t, p.Allows("ops", labels.FromStrings("tenant_id", "b.c")))
+	testutil.Assert(t, !p.Allows("ops", labels.FromStrings("tenant_id", "bxc")))
+	testutil.Assert(t, p.Allows("team-c", labels.FromStrings("public", "true")))
+}
+
+func TestAccessPolicy_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	testutil.Ok(t, os.WriteFile(path, []byte(testAccessPolicy), 0600))
+
+	p, err := tenancy.NewAccessPolicy(policyFile{path: path}, nil, log.NewNopLogger(), 10*time.Millisecond)
+	testutil.Ok(t, err)
+	testutil.Assert(t, p.CanReload())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	testutil.Ok(t, p.StartConfigReloader(ctx))
+	testutil.Assert(t, !p.Allows("team-c", labels.FromStrings("namespace", "c")))
+
+	testutil.Ok(t, os.WriteFile(path, []byte(testAccessPolicy+`
+  - tenants: [team-c]
+    selectors: ['{namespace="c"}']
+`), 0600))
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx.Done(), func() error {
+		if !p.Allows("team-c", labels.FromStrings("namespace", "c")) {
+			return errors.New("policy not reloaded yet")
+		}
+		return nil
+	}))
+
+	// Invalid policies are not loaded.
+	testutil.Ok(t, os.WriteFile(path, []byte(`policies: [{tenants: [a], selectors: ['{a=']}]`), 0600))
+	time.Sleep(100 * time.Millisecond)
+	testutil.Assert(t, p.Allows("team-c", labels.FromStrings("namespace", "c")))
+
+	_, err = tenancy.ParseAccessPolicyConfig([]byte(`policies: [{tenants: [a]}]`))
+	testutil.NotOk(t, err)
+}
@@ -161,6 +161,16 @@ func EnforceQueryTenancy(tenantLabel string, tenant string, query string) (strin
 	return expr.String(), nil
 }
 
+// EnforceLabelMatchers makes sure the given label matchers only select series of the given tenant.
+func EnforceLabelMatchers(tenantLabel string, tenant string, matchers []*labels.Matcher) ([]*labels.Matcher, error) {
+	e := injectproxy.NewEnforcer(false, &labels.Matcher{
+		Name:  tenantLabel,
+		Type:  labels.MatchEqual,
+		Value: tenant,
+	})
+	return e.EnforceMatchers(matchers)
+}
+
 func getLabelMatchers(formMatchers []string, tenant string, enforceTenancy bool, tenantLabel string) ([][]*labels.Matcher, error) {
 	tenantLabelMatcher := &labels.Matcher{
 		Name:  tenantLabel,
@@ -185,8 +195,7 @@ func getLabelMatchers(formMatchers []string, tenant string, enforceTenancy bool,
 		}
 
 		if enforceTenancy {
-			e := injectproxy.NewEnforcer(false, tenantLabelMatcher)
-			matchers, err = e.EnforceMatchers(matchers)
+			matchers, err = EnforceLabelMatchers(tenantLabel, tenant, matchers)
 			if err != nil {
 				return nil, err
 			}
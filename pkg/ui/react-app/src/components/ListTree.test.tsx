@@ -0,0 +1,71 @@
+import * as React from 'react';
+import { mount } from 'enzyme';
+import ListTree, { QueryTree, storeFanoutClassName, storeFanoutSummary } from './ListTree';
+
+const analysis: QueryTree = {
+  name: '[aggregate] sum by ([])',
+  executionTime: '1ms',
+  children: [
+    {
+      name: '[vectorSelector] {[__name__="up"]} 0 mod 1',
+      executionTime: '900µs',
+      stores: [
+        {
+          name: 'store-gateway:10901',
+          executionTime: '800µs',
+          series: 2,
+          chunks: 4,
+          samples: 480,
+          cost: {
+            blocksQueried: 1,
+            postingsTouched: 3,
+            postingsFetched: 3,
+            seriesTouched: 2,
+            seriesFetched: 2,
+            chunksTouched: 4,
+            chunksFetched: 4,
+            downloadedBytes: 1024,
+          },
+        },
+        {
+          name: 'sidecar:10901',
+          prunedReason: 'does not have data within this time period',
+          series: 0,
+          chunks: 0,
+          samples: 0,
+        },
+      ],
+    },
+  ],
+};
+
+describe('ListTree', () => {
+  it('renders the stores queried by the operators', () => {
+    const tree = mount(<ListTree id="analyze-tree" node={analysis} />);
+    const text = tree.text();
+    expect(text).toContain('[store] store-gateway:10901');
+    expect(text).toContain('[store] sidecar:10901');
+  });
+
+  it('summarizes the fan-out of stores', () => {
+    expect(storeFanoutSummary(analysis.children![0].stores![0])).toEqual(
+      '2 series, 4 chunks, 480 samples, 1 blocks, 3 postings touched, 2 series touched, 4 chunks touched, 1024 bytes downloaded'
+    );
+    expect(storeFanoutSummary(analysis.children![0].stores![1])).toEqual(
+      'pruned: does not have data within this time period'
+    );
+    expect(storeFanoutSummary({ name: 'receive', series: 0, chunks: 0, samples: 0, error: 'unavailable' })).toEqual(
+      '0 series, 0 chunks, 0 samples, error: unavailable'
+    );
+    const failed = { name: 'receive', series: 0, chunks: 0, samples: 0, error: 'unavailable', errorCode: 'Unavailable' };
+    expect(storeFanoutSummary(failed)).toEqual('0 series, 0 chunks, 0 samples, error (Unavailable): unavailable');
+  });
+
+  it('highlights the stores which failed the query', () => {
+    const store = { name: 'receive', series: 0, chunks: 0, samples: 0 };
+    expect(storeFanoutClassName(store)).toEqual('');
+    expect(storeFanoutClassName({ ...store, prunedReason: 'tsdb selector' })).toEqual('text-muted');
+    expect(storeFanoutClassName({ ...store, error: 'canceled', errorCode: 'Canceled' })).toEqual('text-muted');
+    expect(storeFanoutClassName({ ...store, error: 'unavailable', errorCode: 'Unavailable' })).toEqual('text-danger');
+  });
+});
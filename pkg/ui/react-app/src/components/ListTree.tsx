@@ -2,14 +2,66 @@ import React, { useState } from 'react';
 import { InputProps, Collapse, ListGroupItem, ListGroup, Tooltip } from 'reactstrap';
 import { ExplainTree } from '../pages/graph/ExpressionInput';
 
+export interface StoreCost {
+  blocksQueried: number;
+  postingsTouched: number;
+  postingsFetched: number;
+  seriesTouched: number;
+  seriesFetched: number;
+  chunksTouched: number;
+  chunksFetched: number;
+  downloadedBytes: number;
+}
+
+export interface StoreFanout {
+  name: string;
+  prunedReason?: string;
+  executionTime?: string;
+  series: number;
+  chunks: number;
+  samples: number;
+  error?: string;
+  errorCode?: string;
+  cost?: StoreCost;
+}
+
 export interface QueryTree {
   name: string;
   executionTime?: string;
   peakSamples?: number;
   totalSamples?: number;
   children?: QueryTree[];
+  stores?: StoreFanout[];
 }
 
+export const storeFanoutSummary = (store: StoreFanout): string => {
+  if (store.prunedReason) {
+    return `pruned: ${store.prunedReason}`;
+  }
+  const parts = [`${store.series} series`, `${store.chunks} chunks`, `${store.samples} samples`];
+  if (store.cost) {
+    parts.push(
+      `${store.cost.blocksQueried} blocks`,
+      `${store.cost.postingsTouched} postings touched`,
+      `${store.cost.seriesTouched} series touched`,
+      `${store.cost.chunksTouched} chunks touched`,
+      `${store.cost.downloadedBytes} bytes downloaded`
+    );
+  }
+  if (store.error) {
+    parts.push(store.errorCode ? `error (${store.errorCode}): ${store.error}` : `error: ${store.error}`);
+  }
+  return parts.join(', ');
+};
+
+// Endpoints canceled because the query failed or ended early are not the cause of the failure.
+export const storeFanoutClassName = (store: StoreFanout): string => {
+  if (store.prunedReason || store.errorCode === 'Canceled') {
+    return 'text-muted';
+  }
+  return store.error ? 'text-danger' : '';
+};
+
 interface NodeProps extends InputProps {
   node: QueryTree | ExplainTree | null;
 }
@@ -52,10 +104,27 @@ const ListTree: React.FC<NodeProps> = ({ id, node }) => {
     }
   };
 
+  // Listing the StoreAPI endpoints the series of a node were fetched from.
+  const storesMapper = (stores: StoreFanout[], parentId: string, lvl: number) => {
+    return stores.map((store: StoreFanout, index: number) => (
+      <ListGroupItem key={`store-${index}-${parentId}`} className="bg-transparent p-0 border-0 rounded-0 border-bottom-0">
+        <div
+          className={`d-flex align-items-center ${storeFanoutClassName(store)}`}
+          style={{ paddingLeft: `${25 * lvl}px` }}
+        >
+          [store] {store.name}
+          {store.executionTime && <span style={{ paddingLeft: `20px` }}>{store.executionTime}</span>}
+          <span style={{ paddingLeft: `20px` }}>{storeFanoutSummary(store)}</span>
+        </div>
+      </ListGroupItem>
+    ));
+  };
+
   // Constructing List Items recursively.
   const mapper = (nodes: QueryTree[], parentId?: any, lvl?: any) => {
     return nodes.map((node: QueryTree, index: number) => {
       const id = `${index}-${parentId ? parentId : 'top'}`.replace(/[^a-zA-Z0-9-_]/g, '');
+      const expandable = node.children || node.stores;
       const item = (
         <React.Fragment>
           <ListGroupItem
@@ -63,12 +132,12 @@ const ListTree: React.FC<NodeProps> = ({ id, node }) => {
           >
             {
               <div className={`d-flex align-items-center`} style={{ paddingLeft: `${25 * lvl}px` }}>
-                {node.children && (
+                {expandable && (
                   <div className="pl-0 btn text-primary" style={{ cursor: 'inherit' }} color="link">
                     {mapping[id] ? '\u002B' : '\u002D'}
                   </div>
                 )}
-                <div id={id} style={{ cursor: `${node.children ? 'pointer' : 'inherit'}` }} onClick={toggle}>
+                <div id={id} style={{ cursor: `${expandable ? 'pointer' : 'inherit'}` }} onClick={toggle}>
                   {node.name}
                   {node.executionTime && (
                     <>
@@ -119,7 +188,12 @@ const ListTree: React.FC<NodeProps> = ({ id, node }) => {
               </div>
             }
           </ListGroupItem>
-          {node.children && <Collapse isOpen={mapping[id]}>{mapper(node.children, id, (lvl || 0) + 1)}</Collapse>}
+          {expandable && (
+            <Collapse isOpen={mapping[id]}>
+              {node.stores && storesMapper(node.stores, id, (lvl || 0) + 1)}
+              {node.children && mapper(node.children, id, (lvl || 0) + 1)}
+            </Collapse>
+          )}
         </React.Fragment>
       );
 
@@ -1,7 +1,7 @@
 import React from 'react';
 import { mount } from 'enzyme';
 import { Button, Collapse, Table, Badge } from 'reactstrap';
-import StorePoolPanel, { StorePoolPanelProps, storeTimeRangeMsg } from './StorePoolPanel';
+import StorePoolPanel, { StorePoolPanelProps, storeTimeRangeMsg, circuitBreakerColor } from './StorePoolPanel';
 import StoreLabels from './StoreLabels';
 import { getColor } from '../../../pages/targets/target';
 import { formatTime, parseTime, isValidTime } from '../../../utils';
@@ -55,7 +55,7 @@ describe('StorePoolPanel', () => {
   describe('for each store', () => {
     const table = storePoolPanel.find(Table);
     defaultProps.storePool.forEach((store, idx) => {
-      const { name, minTime, maxTime, labelSets, lastCheck, lastError } = store;
+      const { name, minTime, maxTime, labelSets, lastCheck, lastError, hedgedRequests, circuitBreakerState } = store;
       const row = table.find('tr').at(idx + 1);
       const validMinTime = isValidTime(minTime);
       const validMaxTime = isValidTime(maxTime);
@@ -112,6 +112,24 @@ describe('StorePoolPanel', () => {
         }
       });
 
+      it('renders hedged requests', () => {
+        const td = row.find({ 'data-testid': 'hedgedRequests' });
+        expect(td).toHaveLength(1);
+        expect(td.text()).toBe(`${hedgedRequests}`);
+      });
+
+      it('renders a badge for the circuit breaker state', () => {
+        const td = row.find({ 'data-testid': 'circuitBreaker' });
+        expect(td).toHaveLength(1);
+
+        const badge = td.find(Badge);
+        expect(badge).toHaveLength(circuitBreakerState ? 1 : 0);
+        if (circuitBreakerState) {
+          expect(badge.prop('color')).toEqual(circuitBreakerColor(circuitBreakerState));
+          expect(badge.text()).toEqual(circuitBreakerState.toUpperCase());
+        }
+      });
+
       it('renders lastCheck', () => {
         const td = row.find({ 'data-testid': 'lastCheck' });
         expect(td).toHaveLength(1);
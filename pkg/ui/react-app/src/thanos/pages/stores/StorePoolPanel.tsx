@@ -18,6 +18,9 @@ export const columns = [
   'Announced LabelSets',
   'Min Time (UTC)',
   'Max Time (UTC)',
+  'Series Latency (p90)',
+  'Hedged Requests',
+  'Circuit Breaker',
   'Last Successful Health Check',
   'Last Message',
 ];
@@ -36,6 +39,17 @@ export const storeTimeRangeMsg = (validMin: boolean, validMax: boolean): string
   return '';
 };
 
+export const circuitBreakerColor = (state: string): string => {
+  switch (state) {
+    case 'open':
+      return 'danger';
+    case 'half-open':
+      return 'warning';
+    default:
+      return 'success';
+  }
+};
+
 export const StorePoolPanel: FC<StorePoolPanelProps> = ({ title, storePool }) => {
   const [{ expanded }, setOptions] = useLocalStorage(`store-pool-${title}-expanded`, { expanded: true });
 
@@ -55,7 +69,18 @@ export const StorePoolPanel: FC<StorePoolPanelProps> = ({ title, storePool }) =>
           </thead>
           <tbody>
             {storePool.map((store: Store) => {
-              const { name, minTime, maxTime, labelSets, lastCheck, lastError } = store;
+              const {
+                name,
+                minTime,
+                maxTime,
+                labelSets,
+                lastCheck,
+                lastError,
+                seriesLatencyP90,
+                hedgedRequests,
+                circuitBreakerState,
+                circuitBreakerTrips,
+              } = store;
               const health = lastError ? 'down' : 'up';
               const color = getColor(health);
               const validMinTime = isValidTime(minTime);
@@ -78,6 +103,17 @@ export const StorePoolPanel: FC<StorePoolPanelProps> = ({ title, storePool }) =>
                   <td data-testid="maxTime" title={storeTimeRangeMsg(validMinTime, validMaxTime)}>
                     {validMaxTime ? formatTime(maxTime) : <FontAwesomeIcon icon={faMinus} />}
                   </td>
+                  <td data-testid="seriesLatency">
+                    {seriesLatencyP90 ? `${(seriesLatencyP90 * 1000).toFixed(0)}ms` : <FontAwesomeIcon icon={faMinus} />}
+                  </td>
+                  <td data-testid="hedgedRequests">{hedgedRequests}</td>
+                  <td data-testid="circuitBreaker" title={`Tripped ${circuitBreakerTrips} times`}>
+                    {circuitBreakerState ? (
+                      <Badge color={circuitBreakerColor(circuitBreakerState)}>{circuitBreakerState.toUpperCase()}</Badge>
+                    ) : (
+                      <FontAwesomeIcon icon={faMinus} />
+                    )}
+                  </td>
                   <td data-testid="lastCheck">
                     {isValidTime(parseTime(lastCheck)) ? (
                       formatRelative(lastCheck, now())
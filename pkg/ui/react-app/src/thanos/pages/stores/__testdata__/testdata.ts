@@ -18,6 +18,10 @@ export const sampleAPIResponse: { status: string; data: StoreListProps } = {
         maxTime: 9223372036854776000,
         minTime: -62167219200000,
         name: 'thanos_sidecar_one:10901',
+        seriesLatencyP90: 0.052,
+        hedgedRequests: 3,
+        circuitBreakerState: 'closed',
+        circuitBreakerTrips: 1,
       },
       {
         labelSets: [],
@@ -26,6 +30,9 @@ export const sampleAPIResponse: { status: string; data: StoreListProps } = {
         maxTime: 92233720368547,
         minTime: 62167219200000,
         name: 'thanos_sidecar_two:10901',
+        hedgedRequests: 0,
+        circuitBreakerState: 'open',
+        circuitBreakerTrips: 4,
       },
     ],
     store: [
@@ -59,6 +66,8 @@ export const sampleAPIResponse: { status: string; data: StoreListProps } = {
         maxTime: 1592136000000,
         minTime: 1589461363260,
         name: 'thanos_store:10901',
+        hedgedRequests: 0,
+        circuitBreakerTrips: 0,
       },
     ],
   },
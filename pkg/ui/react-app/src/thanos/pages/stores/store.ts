@@ -7,4 +7,8 @@ export interface Store {
   lastError: string | null;
   lastCheck: string;
   labelSets: Labels[];
+  seriesLatencyP90?: number;
+  hedgedRequests: number;
+  circuitBreakerState?: string;
+  circuitBreakerTrips: number;
 }